@@ -6,6 +6,9 @@ package antler
 import (
 	"context"
 	"fmt"
+	"io"
+	"math"
+	"slices"
 	"sort"
 	"time"
 
@@ -20,15 +23,39 @@ const LinuxSSThreshInfinity = 2147483647
 // Analyze is a reporter that processes stream and packet data for reports.
 // This must be in the Report pipeline *before* reporters that require it.
 type Analyze struct {
+	// ClockOffset, if true, estimates the offset between the clocks of the
+	// client and server of each packet flow, and corrects the server's times
+	// for it, so one-way delays are meaningful between nodes that don't share
+	// a clock. See PacketAnalysis.estimateClockOffset.
+	ClockOffset bool
+
+	// Percentiles lists the percentiles, from 0 to 100, to calculate for the
+	// OWD and RTT of packet flows. If empty, defaultPercentiles is used.
+	Percentiles []float64
 }
 
+// defaultPercentiles are the delay percentiles calculated by default.
+var defaultPercentiles = []float64{50, 90, 99, 99.9}
+
 // report implements reporter
-func (Analyze) report(ctx context.Context, rw rwer, in <-chan any,
+func (a Analyze) report(ctx context.Context, rw rwer, in <-chan any,
 	out chan<- any) (err error) {
 	y := newAnalysis()
+	y.clockOffset = a.ClockOffset
+	y.percentiles = a.Percentiles
+	if len(y.percentiles) == 0 {
+		y.percentiles = defaultPercentiles
+	}
+	c := newMemoryCheck(ctx, Analyze{})
 	for d := range in {
 		out <- d
 		y.add(d)
+		if err = c.add(); err != nil {
+			return
+		}
+	}
+	if err = context.Cause(ctx); err != nil {
+		return
 	}
 	y.analyze()
 	out <- y
@@ -37,8 +64,22 @@ func (Analyze) report(ctx context.Context, rw rwer, in <-chan any,
 
 // analysis contains the results of the Analyze reporter.
 type analysis struct {
-	streams streams
-	packets packets
+	streams  streams
+	packets  packets
+	rpcs     rpcs
+	stations stations
+	tins     tins
+	flows    *flowRegistry
+	working  WorkingLatency
+
+	// metrics are the Metrics from a MinimalSummary, if any
+	metrics Metrics
+
+	// clockOffset is true to correct for clock offsets in packet flows
+	clockOffset bool
+
+	// percentiles are the delay percentiles to calculate for packet flows
+	percentiles []float64
 }
 
 // newAnalysis returns a new analysis.
@@ -46,12 +87,50 @@ func newAnalysis() analysis {
 	return analysis{
 		newStreams(),
 		newPackets(),
+		newRPCs(),
+		newStations(),
+		newTins(),
+		nil,
+		WorkingLatency{},
+		nil,
+		false,
+		defaultPercentiles,
 	}
 }
 
+// readAnalysis returns the analysis of a Test's data file in the given result
+// directory. If the data file doesn't exist, errors.Is(err, fs.ErrNotExist)
+// returns true.
+func readAnalysis(results Results, dir string, test *Test) (y analysis,
+	err error) {
+	rw := resultRW{Results: results}
+	rw.WorkDir = dir
+	rw = test.RW(rw)
+	var r io.ReadCloser
+	if r, err = test.DataReader(rw); err != nil {
+		return
+	}
+	defer func() {
+		if e := r.Close(); e != nil && err == nil {
+			err = e
+		}
+	}()
+	y = newAnalysis()
+	if err = decodeData(rw, r, func(a any) bool {
+		y.add(a)
+		return true
+	}); err != nil {
+		return
+	}
+	y.analyze()
+	return
+}
+
 // add adds a data item from the result stream.
 func (y *analysis) add(a any) {
 	switch v := a.(type) {
+	case *flowRegistry:
+		y.flows = v
 	case node.StreamInfo:
 		s := y.streams.analysis(v.Flow)
 		if v.Server {
@@ -68,7 +147,11 @@ func (y *analysis) add(a any) {
 		}
 	case node.TCPInfo:
 		s := y.streams.analysis(v.Flow)
-		s.TCPInfo = append(s.TCPInfo, v)
+		if v.Receiver {
+			s.ReceiverTCPInfo = append(s.ReceiverTCPInfo, v)
+		} else {
+			s.TCPInfo = append(s.TCPInfo, v)
+		}
 	case node.PacketInfo:
 		p := y.packets.analysis(v.Flow)
 		if v.Server {
@@ -91,36 +174,143 @@ func (y *analysis) add(a any) {
 				p.ClientRcvd = append(p.ClientRcvd, v)
 			}
 		}
+	case node.PacketTrain:
+		p := y.packets.analysis(v.Flow)
+		p.Train = append(p.Train, v)
+	case node.RPCInfo:
+		r := y.rpcs.analysis(v.Flow)
+		if v.Server {
+			r.Server = v
+		} else {
+			r.Client = v
+		}
+	case node.RPCTransaction:
+		r := y.rpcs.analysis(v.Flow)
+		r.Transaction = append(r.Transaction, v)
+	case MinimalSummary:
+		for _, m := range v.Stream {
+			y.streams.analysis(m.Flow).summary = &m
+		}
+		for _, m := range v.Packet {
+			m = m.clone()
+			y.packets.analysis(m.Flow).summary = &m
+		}
+		y.metrics = v.Metrics
+	case node.StationInfo:
+		s := y.stations.analysis(v)
+		s.Info = append(s.Info, v)
+	case node.TinInfo:
+		t := y.tins.analysis(v)
+		t.Info = append(t.Info, v)
 	}
 }
 
 // analyze uses the collected data to calculate relevant metrics and stats.
 func (y *analysis) analyze() {
-	ss := y.streams.StartTime()
-	ps := y.packets.StartTime()
-	st := ss
-	if st.IsZero() || (!ps.IsZero() && ps.Before(st)) {
-		st = ps
+	var st time.Time
+	for _, t := range []time.Time{
+		y.streams.StartTime(),
+		y.packets.StartTime(),
+		y.rpcs.StartTime(),
+	} {
+		if st.IsZero() || (!t.IsZero() && t.Before(st)) {
+			st = t
+		}
+	}
+	if st.IsZero() {
+		for _, t := range []time.Time{
+			y.stations.StartTime(),
+			y.tins.StartTime(),
+		} {
+			if st.IsZero() || (!t.IsZero() && t.Before(st)) {
+				st = t
+			}
+		}
 	}
 	y.streams.synchronize(st)
-	y.packets.synchronize(st)
+	y.packets.synchronize(st, y.clockOffset)
+	y.rpcs.synchronize(st)
+	y.stations.synchronize(st)
+	y.tins.synchronize(st)
 	y.streams.analyze()
-	y.packets.analyze()
+	y.packets.analyze(y.percentiles)
+	y.rpcs.analyze()
+	y.stations.analyze()
+	y.tins.analyze()
+	y.working = newWorkingLatency(y.streams, y.packets)
+	if y.flows == nil {
+		y.flows = newFlowRegistry(nil, nil)
+	}
+	y.meta()
+}
+
+// meta sets the FlowMeta for each flow from the Flow registry. For streams,
+// any fields recorded in the StreamInfo take precedence.
+func (y *analysis) meta() {
+	for _, s := range y.streams {
+		m := y.flows.meta(s.Flow)
+		m = m.stream(s.Server.Stream)
+		s.Meta = m.stream(s.Client.Stream)
+	}
+	for _, p := range y.packets {
+		p.Meta = y.flows.meta(p.Flow)
+	}
+	for _, r := range y.rpcs {
+		r.Meta = y.flows.meta(r.Flow)
+	}
+}
+
+// streamList returns the StreamAnalysis for each stream, in Flow registry
+// order, with undeclared Flows sorted by start time.
+func (y *analysis) streamList() (s []StreamAnalysis) {
+	s = y.streams.byTime()
+	if y.flows != nil {
+		y.flows.sortStreams(s)
+	}
+	return
+}
+
+// packetList returns the PacketAnalysis for each packet flow, in Flow registry
+// order, with undeclared Flows sorted by start time.
+func (y *analysis) packetList() (p []PacketAnalysis) {
+	p = y.packets.byTime()
+	if y.flows != nil {
+		y.flows.sortPackets(p)
+	}
+	return
+}
+
+// rpcList returns the RPCAnalysis for each RPC flow, in Flow registry order,
+// with undeclared Flows sorted by start time.
+func (y *analysis) rpcList() (r []RPCAnalysis) {
+	r = y.rpcs.byTime()
+	if y.flows != nil {
+		y.flows.sortRPCs(r)
+	}
+	return
 }
 
 // StreamAnalysis contains the data and calculated stats for a stream.
+// TCPInfo is from the sender's socket, and ReceiverTCPInfo from the
+// receiver's, if enabled with Transfer.TCPInfoReceiver. Meta is from the Flow's
+// declaration, updated with the Stream in the StreamInfo.
 type StreamAnalysis struct {
-	Flow         node.Flow
-	Client       node.StreamInfo
-	Server       node.StreamInfo
-	Sent         []node.StreamIO
-	Rcvd         []node.StreamIO
-	TCPInfo      []node.TCPInfo
-	GoodputPoint []GoodputPoint
-	RtxCumAvg    []rtxCumAvg
-	FCT          metric.Duration
-	Length       metric.Bytes
-	SSExitTime   metric.RelativeTime
+	Flow            node.Flow
+	Meta            FlowMeta
+	Client          node.StreamInfo
+	Server          node.StreamInfo
+	Sent            []node.StreamIO
+	Rcvd            []node.StreamIO
+	TCPInfo         []node.TCPInfo
+	ReceiverTCPInfo []node.TCPInfo
+	GoodputPoint    []GoodputPoint
+	RtxCumAvg       []rtxCumAvg
+	FCT             metric.Duration
+	Length          metric.Bytes
+	SSExitTime      metric.RelativeTime
+
+	// summary is the StreamSummary in minimal results mode, or nil
+	summary *StreamSummary
 }
 
 // T0 returns the earliest absolute time from Sent or Rcvd.
@@ -141,6 +331,19 @@ func (s *StreamAnalysis) T0() time.Time {
 	}
 }
 
+// tcpInfoTinit returns the base time for TCPInfo from the sender, or the
+// receiver if receiver is true.
+func (s *StreamAnalysis) tcpInfoTinit(receiver bool) time.Time {
+	d := s.Client.Direction
+	if d == "" {
+		d = s.Server.Direction
+	}
+	if (d == node.Down) != receiver {
+		return s.Server.Tinit
+	}
+	return s.Client.Tinit
+}
+
 // Goodput returns the total goodput for the stream.
 func (s *StreamAnalysis) Goodput() metric.Bitrate {
 	return metric.CalcBitrate(s.Length, s.FCT.Duration())
@@ -195,8 +398,8 @@ func (m *streams) StartTime() (start time.Time) {
 	return
 }
 
-// synchronize adjusts the StreamIO RelativeTime values from node-relative to
-// test-relative time.
+// synchronize adjusts the StreamIO and TCPInfo RelativeTime values from
+// node-relative to test-relative time.
 func (m *streams) synchronize(start time.Time) {
 	for _, r := range *m {
 		for i := 0; i < len(r.Sent); i++ {
@@ -211,9 +414,18 @@ func (m *streams) synchronize(start time.Time) {
 		}
 		for i := 0; i < len(r.TCPInfo); i++ {
 			n := &r.TCPInfo[i]
-			t := n.T.Time(r.Server.Tinit)
+			t := n.T.Time(r.tcpInfoTinit(false))
 			n.T = metric.RelativeTime(t.Sub(start))
 		}
+		for i := 0; i < len(r.ReceiverTCPInfo); i++ {
+			n := &r.ReceiverTCPInfo[i]
+			t := n.T.Time(r.tcpInfoTinit(true))
+			n.T = metric.RelativeTime(t.Sub(start))
+		}
+		if s := r.summary; s != nil && s.SSExit >= 0 {
+			t := s.SSExit.Time(r.tcpInfoTinit(false))
+			s.SSExit = metric.RelativeTime(t.Sub(start))
+		}
 	}
 }
 
@@ -224,7 +436,7 @@ func (m *streams) analyze() {
 		for i := 0; i < len(s.Rcvd)-1; i++ {
 			r := s.Rcvd[i]
 			var g metric.Bitrate
-			if pr != (node.StreamIO{}) {
+			if i > 0 {
 				g = metric.CalcBitrate(r.Total-pr.Total,
 					time.Duration(r.T-pr.T))
 			}
@@ -244,6 +456,9 @@ func (m *streams) analyze() {
 		if !sx {
 			s.SSExitTime = metric.RelativeTime(-1)
 		}
+		if s.summary != nil {
+			s.SSExitTime = s.summary.SSExit
+		}
 		if len(s.Rcvd) > 0 {
 			s.Length = s.Rcvd[len(s.Rcvd)-1].Total
 			if len(s.Sent) > 0 {
@@ -268,34 +483,170 @@ func (m *streams) byTime() (s []StreamAnalysis) {
 type PacketAnalysis struct {
 	// data
 	Flow       node.Flow
+	Meta       FlowMeta
 	Client     node.PacketInfo
 	Server     node.PacketInfo
 	ClientSent []node.PacketIO
 	ClientRcvd []node.PacketIO
 	ServerSent []node.PacketIO
 	ServerRcvd []node.PacketIO
+	Train      []node.PacketTrain
 
 	// statistics
-	Up      packetStats // stats from client to server
-	Down    packetStats // stats from server to client
-	RTT     []rtt
-	RTTMean float64
+	Up       packetStats // stats from client to server
+	Down     packetStats // stats from server to client
+	RTT      []rtt
+	RTTMean  float64
+	RTTStats delayDist      // RTT distribution (ms)
+	DSCP     []dscpStats    // stats by the DSCP value packets were sent with
+	Capacity metric.Bitrate // bottleneck capacity estimated from Trains
+	AvailBW  metric.Bitrate // available bandwidth estimated from Trains
+
+	// ClockOffset is the estimated offset of the server's clock from the
+	// client's, which was subtracted from the server's times, or zero if
+	// clock offset correction was not enabled or possible.
+	ClockOffset time.Duration
+
+	// summary is the PacketSummary in minimal results mode, in which there's
+	// no PacketIO, or nil
+	summary *PacketSummary
+}
+
+// estimateClockOffset estimates the offset of the server's clock from the
+// client's, using a min-filter on the forward and reverse deltas (the
+// differences between the receive and send times, each by its own node's
+// clock). Each delta is the one-way delay plus or minus the offset, so if the
+// minimum one-way delays are the same in each direction, the offset is half
+// the difference between the minimum forward and reverse deltas. Asymmetric
+// base delays bias the estimate by half their difference, and clock drift
+// during the flow is not accounted for.
+//
+// False is returned if no packets were received in one of the directions.
+func (y *PacketAnalysis) estimateClockOffset() (offset time.Duration,
+	ok bool) {
+	// minDelta returns the minimum delta from packets sent by the snd node
+	// and received by the rcv node.
+	minDelta := func(sent, rcvd []node.PacketIO, snd, rcv node.PacketInfo) (
+		delta time.Duration, ok bool) {
+		m := make(map[node.Seq]time.Time)
+		for _, p := range sent {
+			m[p.Seq] = snd.Time(p.T)
+		}
+		for _, p := range rcvd {
+			t, k := m[p.Seq]
+			if !k {
+				continue
+			}
+			if d := rcv.Time(p.T).Sub(t); !ok || d < delta {
+				delta = d
+				ok = true
+			}
+		}
+		return
+	}
+	var f, r time.Duration
+	if f, ok = minDelta(y.ClientSent, y.ServerRcvd, y.Client,
+		y.Server); !ok {
+		return
+	}
+	if r, ok = minDelta(y.ServerSent, y.ClientRcvd, y.Server,
+		y.Client); !ok {
+		return
+	}
+	offset = (f - r) / 2
+	return
+}
+
+// dscpStats contains statistics for the packets in a packet flow that were
+// sent by the client with one DSCP value, used to verify DiffServ handling.
+// Packets are remarked if they were received with a different DSCP value than
+// they were sent with. Remarking can only be detected for packets whose
+// received DSCP could be read.
+type dscpStats struct {
+	DSCP            byte    // DSCP value the client sent with
+	Sent            int     // packets sent by the client
+	LostPct         float64 // percent lost from client to server
+	RTTMean         float64 // mean RTT (ms)
+	RTTP99          float64 // 99th percentile RTT (ms)
+	Remarked        int     // packets received by the server remarked
+	RemarkedPct     float64 // percent of verified packets remarked
+	RemarkedTo      []byte  // distinct DSCP values remarked to by the server
+	DownRemarked    int     // replies received by the client remarked
+	DownRemarkedPct float64 // percent of verified replies remarked
 }
 
 // packetStats contains statistics for one direction of a packet flow.
 type packetStats struct {
+	Sent     int // packets sent
+	Rcvd     int // packets received, including duplicates
 	Lost     []lost
 	LostPct  float64
 	Dup      []dup
 	DupPct   float64
 	OWD      []owd
 	OWDMean  float64
+	OWDStats delayDist // OWD distribution (ms)
 	Early    []early
 	EarlyPct float64
 	Late     []late
 	LatePct  float64
 }
 
+// delayDist summarizes a distribution of delays, in milliseconds.
+type delayDist struct {
+	N          int          // number of samples
+	Min        float64      // minimum delay
+	Max        float64      // maximum delay
+	Mean       float64      // mean delay
+	StdDev     float64      // sample standard deviation
+	Percentile []percentile // requested percentiles, in the order given
+}
+
+// namedDelayDist is a delayDist with a name, for display.
+type namedDelayDist struct {
+	Name string
+	delayDist
+}
+
+// percentile is a single percentile value in a delayDist.
+type percentile struct {
+	P     float64 // percentile, from 0 to 100
+	Value float64 // delay at the percentile
+}
+
+// newDelayDist returns a delayDist for the given delays, with the given
+// percentiles. The delays are sorted in place. If there are no delays, the zero
+// value is returned.
+func newDelayDist(delays []float64, percentiles []float64) (d delayDist) {
+	if len(delays) == 0 {
+		return
+	}
+	sort.Float64s(delays)
+	d.N = len(delays)
+	d.Min = delays[0]
+	d.Max = delays[len(delays)-1]
+	d.Mean, d.StdDev = stat.MeanStdDev(delays, nil)
+	if math.IsNaN(d.StdDev) {
+		d.StdDev = 0
+	}
+	for _, p := range percentiles {
+		v := stat.Quantile(p/100, stat.Empirical, delays, nil)
+		d.Percentile = append(d.Percentile, percentile{p, v})
+	}
+	return
+}
+
+// P returns the delay at percentile p, or NaN if it wasn't calculated. P is
+// used in templates, e.g. {{.Up.OWDStats.P 99.9}}.
+func (d delayDist) P(p float64) float64 {
+	for _, c := range d.Percentile {
+		if c.P == p {
+			return c.Value
+		}
+	}
+	return math.NaN()
+}
+
 // owd is a single one-way delay data point.
 type owd struct {
 	T     metric.RelativeTime // time the packet was received
@@ -339,6 +690,8 @@ type dup struct {
 func (s *packetStats) analyze(src, dst []node.PacketIO) (
 	dstMap map[node.Seq]node.PacketIO) {
 	srcLen := len(src)
+	s.Sent = srcLen
+	s.Rcvd = len(dst)
 	// create dst map, find dups and remove from dst
 	dstMap = make(map[node.Seq]node.PacketIO)
 	var dst2 []node.PacketIO
@@ -396,6 +749,9 @@ func (s *packetStats) analyze(src, dst []node.PacketIO) (
 
 // T0 returns the earliest absolute packet time.
 func (y *PacketAnalysis) T0() time.Time {
+	if y.summary != nil {
+		return y.summary.T0
+	}
 	if len(y.ClientSent) == 0 {
 		if len(y.ServerRcvd) == 0 {
 			return time.Time{}
@@ -412,9 +768,39 @@ func (y *PacketAnalysis) T0() time.Time {
 	}
 }
 
-// analyze gets the packet statistics for the Flow. The data fields must already
-// have been populated.
-func (y *PacketAnalysis) analyze() {
+// SendStart returns the time the client sent its first packet, relative to the
+// start of the Test once synchronized, or zero if it sent none.
+func (y PacketAnalysis) SendStart() metric.RelativeTime {
+	if y.summary != nil {
+		return y.summary.SendStart
+	}
+	if len(y.ClientSent) == 0 {
+		return 0
+	}
+	return y.ClientSent[0].T
+}
+
+// analyze gets the packet statistics for the Flow, including the given delay
+// percentiles. The data fields must already have been populated. In minimal
+// results mode, the statistics are set from the PacketSummary instead.
+func (y *PacketAnalysis) analyze(percentiles []float64) {
+	if s := y.summary; s != nil {
+		y.Up = s.Up
+		y.Down = s.Down
+		y.Up.restore()
+		y.Down.restore()
+		y.RTT = s.RTT
+		y.RTTMean = s.RTTMean
+		if s.RTTStats.N == 0 {
+			y.RTTMean = math.NaN()
+		}
+		y.RTTStats = s.RTTStats
+		y.DSCP = s.DSCP
+		y.Capacity = s.Capacity
+		y.AvailBW = s.AvailBW
+		y.ClockOffset = s.ClockOffset
+		return
+	}
 	//fmt.Printf("analyze ClientSent:%d ServerRcvd:%d\n",
 	//	len(y.ClientSent), len(y.ServerRcvd))
 	// analyze stats for each direction
@@ -433,6 +819,183 @@ func (y *PacketAnalysis) analyze() {
 		}
 	}
 	y.RTTMean = stat.Mean(rr, nil)
+	y.RTTStats = newDelayDist(rr, percentiles)
+	y.Up.OWDStats = newDelayDist(y.Up.delays(), percentiles)
+	y.Down.OWDStats = newDelayDist(y.Down.delays(), percentiles)
+	y.analyzeDSCP()
+	y.analyzeTrains()
+}
+
+// DelayDists returns the RTT, OWD up and OWD down delay distributions that have
+// samples, each with its name.
+func (y PacketAnalysis) DelayDists() (d []namedDelayDist) {
+	for _, n := range []namedDelayDist{
+		{"RTT", y.RTTStats},
+		{"OWD Up", y.Up.OWDStats},
+		{"OWD Down", y.Down.OWDStats},
+	} {
+		if n.N > 0 {
+			d = append(d, n)
+		}
+	}
+	return
+}
+
+// analyzeTrains estimates the bottleneck capacity and available bandwidth from
+// the dispersion of the packets in any Trains, when they're received.
+//
+// Capacity is the 90th percentile of the rates the trains were received at.
+// Cross traffic that arrives during a train lowers its rate, so the trains
+// least affected by it approach the capacity. Trains are used instead of packet
+// pairs, as pairs are easily compressed by receive batching (e.g. interrupt
+// coalescing), and the percentile discards trains distorted by the same.
+//
+// The available bandwidth is estimated for each train from the rate it was
+// sent at (Ri) and received at (Ro), using the single bottleneck fluid model,
+// in which Ro = C * Ri / (Ri + X) for cross traffic at rate X, so the available
+// bandwidth C - X = C - Ri * (C / Ro - 1). AvailBW is the median over the
+// trains. The estimate is only valid if the trains are sent faster than the
+// available bandwidth, which is true for back-to-back packets unless the
+// sender's link is the bottleneck.
+func (y *PacketAnalysis) analyzeTrains() {
+	if len(y.Train) == 0 {
+		return
+	}
+	snt := make(map[node.Seq]metric.RelativeTime)
+	for _, p := range y.ClientSent {
+		snt[p.Seq] = p.T
+	}
+	rcv := make(map[node.Seq]metric.RelativeTime)
+	for _, p := range y.ServerRcvd {
+		if _, ok := rcv[p.Seq]; !ok {
+			rcv[p.Seq] = p.T
+		}
+	}
+	// rate returns the rate in bps of n packets of length l in duration d
+	rate := func(n, l int, d metric.RelativeTime) float64 {
+		return float64(8*n*l) / time.Duration(d).Seconds()
+	}
+	var ri, ro []float64 // train send and receive rates
+	for _, t := range y.Train {
+		var f, l node.Seq // first and last seq received
+		var n int         // packets received
+		for i := 0; i < t.Count; i++ {
+			s := t.Seq + node.Seq(i)
+			if _, ok := rcv[s]; !ok {
+				continue
+			}
+			if n == 0 {
+				f = s
+			}
+			l = s
+			n++
+		}
+		e := t.Seq + node.Seq(t.Count-1)
+		if n < 2 || rcv[l] <= rcv[f] || snt[e] <= snt[t.Seq] {
+			continue
+		}
+		ri = append(ri, rate(t.Count-1, t.Length, snt[e]-snt[t.Seq]))
+		ro = append(ro, rate(int(l-f), t.Length, rcv[l]-rcv[f]))
+	}
+	if len(ro) == 0 {
+		return
+	}
+	r := append([]float64{}, ro...)
+	sort.Float64s(r)
+	c := stat.Quantile(0.9, stat.Empirical, r, nil)
+	y.Capacity = metric.Bitrate(c)
+	var aa []float64
+	for i := range ri {
+		a := c - ri[i]*(c/ro[i]-1)
+		aa = append(aa, max(0, min(a, c)))
+	}
+	sort.Float64s(aa)
+	y.AvailBW = metric.Bitrate(stat.Quantile(0.5, stat.Empirical, aa, nil))
+}
+
+// analyzeDSCP records the dscpStats for each DSCP value that the client sent
+// packets with, if known.
+func (y *PacketAnalysis) analyzeDSCP() {
+	seqMap := func(pp []node.PacketIO) (m map[node.Seq]node.PacketIO) {
+		m = make(map[node.Seq]node.PacketIO)
+		for _, p := range pp {
+			if _, ok := m[p.Seq]; !ok {
+				m[p.Seq] = p
+			}
+		}
+		return
+	}
+	srv := seqMap(y.ServerRcvd)
+	ssnt := seqMap(y.ServerSent)
+	crcv := seqMap(y.ClientRcvd)
+	rtt := make(map[node.Seq]time.Duration)
+	for _, r := range y.RTT {
+		rtt[r.Seq] = r.Delay
+	}
+	type acc struct {
+		dscpStats
+		lost, verified, downVerified int
+		rtt                          []float64
+	}
+	m := make(map[byte]*acc)
+	var dd []byte
+	for _, p := range y.ClientSent {
+		if !p.HasTOS {
+			continue
+		}
+		d := p.TOS >> 2
+		a, ok := m[d]
+		if !ok {
+			a = &acc{dscpStats: dscpStats{DSCP: d}}
+			m[d] = a
+			dd = append(dd, d)
+		}
+		a.Sent++
+		r, ok := srv[p.Seq]
+		if !ok {
+			a.lost++
+			continue
+		}
+		if r.HasTOS {
+			a.verified++
+			if t := r.TOS >> 2; t != d {
+				a.Remarked++
+				if !slices.Contains(a.RemarkedTo, t) {
+					a.RemarkedTo = append(a.RemarkedTo, t)
+				}
+			}
+		}
+		if t, ok := rtt[p.Seq]; ok {
+			a.rtt = append(a.rtt, t.Seconds()*1000.0)
+		}
+		s, sok := ssnt[p.Seq]
+		c, cok := crcv[p.Seq]
+		if sok && cok && s.HasTOS && c.HasTOS {
+			a.downVerified++
+			if s.TOS>>2 != c.TOS>>2 {
+				a.DownRemarked++
+			}
+		}
+	}
+	slices.Sort(dd)
+	y.DSCP = nil
+	for _, d := range dd {
+		a := m[d]
+		a.LostPct = 100.0 * float64(a.lost) / float64(a.Sent)
+		if a.verified > 0 {
+			a.RemarkedPct = 100.0 * float64(a.Remarked) / float64(a.verified)
+		}
+		if a.downVerified > 0 {
+			a.DownRemarkedPct = 100.0 * float64(a.DownRemarked) /
+				float64(a.downVerified)
+		}
+		if len(a.rtt) > 0 {
+			sort.Float64s(a.rtt)
+			a.RTTMean = stat.Mean(a.rtt, nil)
+			a.RTTP99 = stat.Quantile(0.99, stat.Empirical, a.rtt, nil)
+		}
+		y.DSCP = append(y.DSCP, a.dscpStats)
+	}
 }
 
 // packets aggregates data for multiple packet flows.
@@ -466,9 +1029,26 @@ func (k *packets) StartTime() (start time.Time) {
 }
 
 // synchronize adjusts the PacketIO RelativeTime values from node-relative to
-// test-relative time.
-func (k *packets) synchronize(start time.Time) {
+// test-relative time. If clockOffset is true, the server times are first
+// corrected for the estimated clock offset, if it can be estimated.
+func (k *packets) synchronize(start time.Time, clockOffset bool) {
 	for _, p := range *k {
+		if s := p.summary; s != nil {
+			d := metric.RelativeTime(s.Base.Sub(start))
+			s.SendStart += d
+			s.Up.shift(d)
+			s.Down.shift(d)
+			for i := range s.RTT {
+				s.RTT[i].T += d
+			}
+			continue
+		}
+		if clockOffset {
+			if o, ok := p.estimateClockOffset(); ok {
+				p.ClockOffset = o
+				p.Server.Tinit = p.Server.Tinit.Add(-o)
+			}
+		}
 		for i := 0; i < len(p.ClientSent); i++ {
 			io := &p.ClientSent[i]
 			t := io.T.Time(p.Client.Tinit)
@@ -493,9 +1073,9 @@ func (k *packets) synchronize(start time.Time) {
 }
 
 // analyze uses the collected data to calculate relevant metrics and stats.
-func (k *packets) analyze() {
+func (k *packets) analyze(percentiles []float64) {
 	for _, p := range *k {
-		p.analyze()
+		p.analyze(percentiles)
 	}
 }
 
@@ -509,3 +1089,106 @@ func (k *packets) byTime() (d []PacketAnalysis) {
 	})
 	return
 }
+
+// RPCAnalysis contains the data and calculated stats for an RPC flow. Latency
+// statistics are in milliseconds.
+type RPCAnalysis struct {
+	// data
+	Flow        node.Flow
+	Meta        FlowMeta
+	Client      node.RPCInfo
+	Server      node.RPCInfo
+	Transaction []node.RPCTransaction
+
+	// statistics
+	LatencyMean float64
+	LatencyP50  float64
+	LatencyP99  float64
+	LatencyP999 float64
+	LatencyMax  float64
+}
+
+// T0 returns the earliest absolute transaction time.
+func (r *RPCAnalysis) T0() time.Time {
+	if len(r.Transaction) == 0 {
+		return time.Time{}
+	}
+	return r.Client.Time(r.Transaction[0].T)
+}
+
+// analyze calculates the latency statistics for the RPC flow.
+func (r *RPCAnalysis) analyze() {
+	if len(r.Transaction) == 0 {
+		return
+	}
+	var ll []float64
+	for _, t := range r.Transaction {
+		ll = append(ll, t.Latency.Seconds()*1000.0)
+	}
+	r.LatencyMean = stat.Mean(ll, nil)
+	sort.Float64s(ll)
+	r.LatencyP50 = stat.Quantile(0.5, stat.Empirical, ll, nil)
+	r.LatencyP99 = stat.Quantile(0.99, stat.Empirical, ll, nil)
+	r.LatencyP999 = stat.Quantile(0.999, stat.Empirical, ll, nil)
+	r.LatencyMax = ll[len(ll)-1]
+}
+
+// rpcs aggregates data for multiple RPC flows.
+type rpcs map[node.Flow]*RPCAnalysis
+
+// newRPCs returns a new rpcs.
+func newRPCs() rpcs {
+	return rpcs(make(map[node.Flow]*RPCAnalysis))
+}
+
+// analysis adds RPCAnalysis for the given flow if it doesn't already exist.
+func (m *rpcs) analysis(flow node.Flow) (r *RPCAnalysis) {
+	var ok bool
+	if r, ok = (*m)[flow]; ok {
+		return
+	}
+	r = &RPCAnalysis{Flow: flow}
+	(*m)[flow] = r
+	return
+}
+
+// StartTime returns the earliest absolute start time among the RPC flows.
+func (m *rpcs) StartTime() (start time.Time) {
+	for _, r := range *m {
+		t0 := r.T0()
+		if start.IsZero() || (!t0.IsZero() && t0.Before(start)) {
+			start = t0
+		}
+	}
+	return
+}
+
+// synchronize adjusts the RPCTransaction RelativeTime values from
+// node-relative to test-relative time.
+func (m *rpcs) synchronize(start time.Time) {
+	for _, r := range *m {
+		for i := 0; i < len(r.Transaction); i++ {
+			x := &r.Transaction[i]
+			t := x.T.Time(r.Client.Tinit)
+			x.T = metric.RelativeTime(t.Sub(start))
+		}
+	}
+}
+
+// analyze uses the collected data to calculate relevant metrics and stats.
+func (m *rpcs) analyze() {
+	for _, r := range *m {
+		r.analyze()
+	}
+}
+
+// byTime returns a slice of RPCAnalysis, sorted by start time.
+func (m *rpcs) byTime() (r []RPCAnalysis) {
+	for _, a := range *m {
+		r = append(r, *a)
+	}
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].T0().Before(r[j].T0())
+	})
+	return
+}
@@ -15,8 +15,10 @@ import (
 	"io/fs"
 	"log"
 	"os"
+	"os/user"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
 	"sync"
 	"text/template"
@@ -27,7 +29,8 @@ import (
 	"github.com/heistp/antler/node"
 )
 
-// dataChanBufLen is used as the buffer length for data channels.
+// dataChanBufLen is the default buffer length for data channels (see
+// Tuning.DataChanLen).
 const dataChanBufLen = 64
 
 //go:embed init/*.cue
@@ -165,6 +168,10 @@ type RunCommand struct {
 	// run before or had errors are run.
 	Filter TestFilter
 
+	// Tags lists values to inject into the CUE config, in the form key=value,
+	// for fields with a corresponding @tag attribute.
+	Tags []string
+
 	// Skipped is called when a Test was skipped because it wasn't accepted by
 	// the Filter.
 	Skipped func(*Test)
@@ -179,29 +186,109 @@ type RunCommand struct {
 	// Running is called when a Test starts running.
 	Running func(*Test)
 
+	// Blocked is called when a Test was skipped because a Test it DependsOn,
+	// with the given ID, did not complete.
+	Blocked func(test *Test, dependency TestID)
+
+	// Unfed is called when a Test was skipped because a Feedback key it
+	// Imports is not in the run's Feedback store.
+	Unfed func(test *Test, key string)
+
+	// Windowed is called with the decision made for a Test with a Window,
+	// before it runs, waits for its Window, or is skipped.
+	Windowed func(WindowDecision)
+
+	// CheckPrereqs, if true, checks for the prerequisites of the Tests to be
+	// run, on each of their nodes, before running them. Nodes that join an
+	// existing netns aren't checked, as it may be created by an earlier
+	// runner.
+	CheckPrereqs bool
+
+	// Preflight is called before prerequisites are checked, with the Tests
+	// to be run.
+	Preflight func([]*Test)
+
+	// NoCache, if true, always runs the After reports, even if their inputs
+	// are unchanged from the prior result.
+	NoCache bool
+
+	// ReportJobs, if greater than zero, defers the After reports until all
+	// Tests have run, then runs the report pipelines for up to ReportJobs
+	// Tests concurrently. If zero, the After reports for each Test run right
+	// after it. Note that when deferring, data for Tests with an empty
+	// DataFile is held in memory until it's reported.
+	ReportJobs int
+
+	// PipelineStats, if not nil, enables measuring the stages of the During
+	// and After report pipelines, and is called with the stats for each when
+	// it completes.
+	PipelineStats func(PipelineStats)
+
+	// Expected is called with the result of a Test's Expect conditions, after
+	// its After reports.
+	Expected func(*Test, ExpectResult)
+
+	// Copying is called with the progress of copying the result directory,
+	// when the WorkDir and RootDir are on different filesystems.
+	Copying func(CopyProgress)
+
+	// Hold, if not nil, enables debug mode, in which the Filter must select
+	// exactly one Test to run. Hold is called after the Test's Run completes,
+	// successfully or not, with the process info for each of its nodes, and
+	// the nodes, along with their network namespaces, are kept running until
+	// Hold returns, so they may be inspected. Nodes are torn down before Hold
+	// is called if the Test's Timeout expires.
+	Hold func(context.Context, *Test, []node.NodeProcess)
+
+	// Event, if not nil, is called with an Event as each Test starts, as each
+	// runner starts on its nodes, periodically with its progress, and when it's
+	// done. Event may be called concurrently for Tests that run concurrently,
+	// and should return quickly, as the Test's During reports wait for it.
+	Event func(Event)
+
+	// CollectFailed is called when streaming results to the collector fails,
+	// if Results.Collect is set. Uploads are retried, and it's not called
+	// again until they succeed.
+	CollectFailed func(error)
+
+	// LiveFailed is called when sending live progress to the server fails, if
+	// Results.Live is set. Updates are retried, and it's not called again
+	// until they succeed.
+	LiveFailed func(error)
+
 	// Done is called when the RunCommand is done.
 	Done func(RunInfo)
+
+	// dir, if not empty, is the directory to load the config from, instead of
+	// the current directory. Relative Results paths are under it.
+	dir string
 }
 
 // RunInfo contains stats and info for a test run.
 type RunInfo struct {
-	sync.Mutex
 	Start     time.Time
 	Elapsed   time.Duration
 	Ran       int
 	Linked    int
+	Unmet     int
 	ResultDir string
 }
 
+// runInfo wraps RunInfo with a Mutex for concurrent updates.
+type runInfo struct {
+	sync.Mutex
+	RunInfo
+}
+
 // ran increments the Ran field.
-func (i *RunInfo) ran() {
+func (i *runInfo) ran() {
 	i.Lock()
 	i.Ran++
 	i.Unlock()
 }
 
 // linked increments the Linked field.
-func (i *RunInfo) linked() {
+func (i *runInfo) linked() {
 	i.Lock()
 	i.Linked++
 	i.Unlock()
@@ -210,38 +297,123 @@ func (i *RunInfo) linked() {
 // run implements command
 func (r RunCommand) run(ctx context.Context) (err error) {
 	var c *Config
-	if c, err = LoadConfig(&load.Config{}); err != nil {
+	if c, err = LoadConfig(&load.Config{Dir: r.dir, Tags: r.Tags}); err != nil {
 		return
 	}
+	c.Results = c.Results.in(r.dir)
 	var rw resultRW
 	if rw, err = c.Results.open(); err != nil {
+		err = exitError(ExitEnvironment, err)
 		return
 	}
+	ctx = withTuning(ctx, c.Tuning)
+	var l *collector
+	if c.Results.Collect != nil {
+		l = newCollector(c.Results, r.CollectFailed)
+		l.start(ctx)
+	}
+	var v *liveFeed
+	if c.Results.Live != nil {
+		v = newLiveFeed(c.Results.Live, r.LiveFailed)
+		e := r.Event
+		r.Event = func(ev Event) {
+			v.event(ev)
+			if e != nil {
+				e(ev)
+			}
+		}
+	}
 	m := newMultiRunner(c.MultiReport)
-	d := doRun{r, rw, m, &RunInfo{}}
+	f := newFlowRegistry(c.Test, c.Theme.palette())
+	d := doRun{r, rw, m, f, c.reportConfig(), &runInfo{}, nil, &expectTally{},
+		newManifest(), v, &completedTests{}, newFeedbackStore()}
+	if r.ReportJobs > 0 {
+		d.Deferred = &deferredReports{}
+	}
+	if v != nil {
+		v.start(ctx, d.willRun(c.Test))
+	}
 	defer func() {
 		if e := m.stop(rw); e != nil && err == nil {
-			err = e
+			err = exitError(ExitReport, e)
 		}
+		if err == nil {
+			err = d.Tally.err()
+		}
+		d.Info.Unmet = d.Tally.unmet
 		d.Info.Elapsed = time.Since(d.Info.Start)
+		lc := context.WithoutCancel(ctx)
+		if l != nil {
+			l.stop(lc)
+		}
+		if v != nil {
+			v.stop(lc)
+		}
 		if d.Info.Ran == 0 {
 			if e := rw.Abort(); e != nil && err == nil {
 				err = e
 			}
 		} else {
-			var e error
-			if d.Info.ResultDir, e = rw.Close(); e != nil && err == nil {
+			e := d.Manifest.save(rw)
+			if e != nil && err == nil {
 				err = e
 			}
+			if d.Info.ResultDir, e = rw.Close(r.Copying); e != nil &&
+				err == nil {
+				err = e
+			}
+			if e = rw.index(d.Info.ResultDir, c.Test); e != nil &&
+				err == nil {
+				err = e
+			}
+		}
+		if l != nil {
+			var n string
+			if d.Info.ResultDir != "" {
+				n = filepath.Base(d.Info.ResultDir)
+			}
+			l.finish(lc, n)
 		}
 		if r.Done != nil {
-			r.Done(*d.Info)
+			r.Done(d.Info.RunInfo)
 		}
 	}()
+	d.Info.Start = time.Now()
+	d.Manifest.order(c.Test)
+	if r.Hold != nil {
+		if r.Filter == nil {
+			err = exitError(ExitConfig,
+				errors.New("debug mode requires a filter"))
+			return
+		}
+		if n := len(d.willRun(c.Test)); n != 1 {
+			err = exitError(ExitConfig, fmt.Errorf(
+				"debug mode requires a filter matching one test, not %d", n))
+			return
+		}
+	}
+	if r.CheckPrereqs {
+		tt := d.willRun(c.Test)
+		if r.Preflight != nil {
+			r.Preflight(tt)
+		}
+		if err = preflight(ctx, tt); err != nil {
+			err = exitError(ExitEnvironment, err)
+			return
+		}
+	}
 	if err = m.start(rw); err != nil {
+		err = exitError(ExitReport, err)
 		return
 	}
-	d.Info.Start = time.Now()
+	if d.Deferred != nil {
+		defer func() {
+			if e := d.Deferred.run(ctx, r.ReportJobs, d.report); e != nil &&
+				err == nil {
+				err = e
+			}
+		}()
+	}
 	for _, t := range c.Test {
 		t := t
 		if err = d.Test(ctx, &t); err != nil {
@@ -254,9 +426,17 @@ func (r RunCommand) run(ctx context.Context) (err error) {
 // doRun is a Tester that runs a Test and its reports.
 type doRun struct {
 	RunCommand
-	RW    resultRW
-	Multi *multiRunner
-	Info  *RunInfo
+	RW        resultRW
+	Multi     *multiRunner
+	Flows     *flowRegistry
+	Report    reportConfig
+	Info      *runInfo
+	Deferred  *deferredReports
+	Tally     *expectTally
+	Manifest  *manifest
+	Live      *liveFeed
+	Completed *completedTests
+	Feedback  *feedbackStore
 }
 
 // Test implements Tester.
@@ -303,27 +483,201 @@ func (d doRun) Test(ctx context.Context, test *Test) (err error) {
 		}
 	}
 	if s == nil {
+		if id, ok := d.Completed.all(test.DependsOn); !ok {
+			if d.Blocked != nil {
+				d.Blocked(test, id)
+			}
+			return
+		}
+		if k, ok := d.Feedback.missing(test.Import); !ok {
+			if d.Unfed != nil {
+				d.Unfed(test, k)
+			}
+			return
+		}
+		if test.Window != nil {
+			var ok bool
+			if ok, err = d.window(ctx, test); err != nil || !ok {
+				return
+			}
+		}
 		if d.Running != nil {
 			d.Running(test)
 		}
 		d.Info.ran()
-		if s, err = d.run(ctx, test); err != nil {
+		defer d.Manifest.start(test)()
+		t := time.Now()
+		if d.Event != nil {
+			d.Event(TestStarted{test, t})
+		}
+		s, err = d.run(ctx, test)
+		if d.Event != nil {
+			d.Event(TestDone{test, time.Since(t), err})
+		}
+		if err != nil {
+			d.Multi.fail(ctx, test.RW(d.RW), test, err)
+			err = exitError(ExitTest, err)
 			return
 		}
 	}
-	r := report([]reporter{s})
-	r = r.add(test.AfterDefault.report())
-	r = r.add(test.After.report())
-	o, me := d.Multi.tee(ctx, rw, test)
-	pe := r.pipeline(ctx, rw, nil, o)
-	for e := range mergeErr(me, pe) {
+	d.Completed.add(test.ID)
+	if d.Deferred != nil {
+		d.Deferred.add(test, s)
+		return
+	}
+	err = d.report(ctx, test, s)
+	return
+}
+
+// completedTests records the IDs of the Tests that completed in a run, either
+// because they ran successfully, or were linked from a prior result.
+type completedTests []TestID
+
+// add adds a Test ID.
+func (c *completedTests) add(id TestID) {
+	*c = append(*c, id)
+}
+
+// all returns ok true if all the given Test IDs completed. If not, missing is
+// the first that didn't.
+func (c *completedTests) all(ids []TestID) (missing TestID, ok bool) {
+	for _, i := range ids {
+		if !slices.ContainsFunc(*c, i.Equal) {
+			missing = i
+			return
+		}
+	}
+	ok = true
+	return
+}
+
+// window decides whether a Test with a Window runs, waits for its Window or is
+// skipped, records the decision, then waits if needed. False is returned if
+// the Test was skipped.
+func (d doRun) window(ctx context.Context, test *Test) (run bool, err error) {
+	var w WindowDecision
+	if w, err = test.Window.decide(test, time.Now()); err != nil {
+		err = exitError(ExitConfig, fmt.Errorf("test %s: %w", test.ID, err))
+		return
+	}
+	d.Manifest.window(w)
+	if d.Windowed != nil {
+		d.Windowed(w)
+	}
+	if w.Action == windowSkip {
+		return
+	}
+	if err = w.wait(ctx); err != nil {
+		return
+	}
+	run = true
+	return
+}
+
+// lock returns the Test's Lock, with its Owner set to identify this run.
+func (d doRun) lock(test *Test) (l node.Lock) {
+	l = test.Lock
+	if l.Path == "" {
+		return
+	}
+	h, _ := os.Hostname()
+	n := os.Getenv("USER")
+	if u, e := user.Current(); e == nil {
+		n = u.Username
+	}
+	p := os.Getpid()
+	l.Owner = node.LockOwner{
+		Run:   fmt.Sprintf("%s-%d-%d", h, p, d.Info.Start.UnixNano()),
+		User:  n,
+		Host:  h,
+		PID:   p,
+		Test:  test.ID.String(),
+		Start: d.Info.Start,
+	}
+	return
+}
+
+// report runs the After reports for a Test, using src as the data source.
+func (d doRun) report(ctx context.Context, test *Test, src reporter) (
+	err error) {
+	a := afterReports{d.Multi, d.Flows, d.Report, d.NoCache, d.PipelineStats,
+		d.expected}
+	_, err = a.run(ctx, test.RW(d.RW), test, src)
+	err = exitError(ExitReport, err)
+	return
+}
+
+// expected adds the result of a Test's Expect conditions to the Tally, and
+// calls Expected.
+func (d doRun) expected(test *Test, result ExpectResult) {
+	d.Tally.add(test, result)
+	if d.Expected != nil {
+		d.Expected(test, result)
+	}
+}
+
+// deferredReports holds the Tests and data sources for After reports that are
+// deferred until all Tests have run.
+type deferredReports struct {
+	test []*Test
+	src  []reporter
+}
+
+// add adds the After reports for a Test.
+func (r *deferredReports) add(test *Test, src reporter) {
+	r.test = append(r.test, test)
+	r.src = append(r.src, src)
+}
+
+// run runs the deferred reports using the report func, with up to jobs
+// running concurrently. After the first error, the Context passed to report
+// is canceled, and the first error is returned after all reports are done.
+func (r *deferredReports) run(ctx context.Context, jobs int,
+	report func(context.Context, *Test, reporter) error) (err error) {
+	ctx, x := context.WithCancelCause(ctx)
+	defer x(nil)
+	s := make(chan struct{}, jobs)
+	var ecc errChans
+	for i, t := range r.test {
+		ec := ecc.make()
+		go func(t *Test, src reporter, ec chan error) {
+			defer close(ec)
+			s <- struct{}{}
+			defer func() {
+				<-s
+			}()
+			if e := report(ctx, t, src); e != nil {
+				ec <- e
+			}
+		}(t, r.src[i], ec)
+	}
+	for e := range ecc.merge() {
 		if err == nil {
 			err = e
+			x(e)
 		}
 	}
 	return
 }
 
+// willRun returns the Tests that are expected to run, without side effects.
+// When Filter is nil, Tests with prior results are omitted, though they may
+// still be re-run if their prior results contain errors.
+func (d doRun) willRun(tests Tests) (run []*Test) {
+	for i := range tests {
+		t := &tests[i]
+		if d.Filter != nil {
+			if !d.Filter.Accept(t) {
+				continue
+			}
+		} else if t.DataFile != "" && t.RW(d.RW).HasPrior(t.DataFile) {
+			continue
+		}
+		run = append(run, t)
+	}
+	return
+}
+
 // run runs a Test.
 func (u doRun) run(ctx context.Context, test *Test) (src reporter, err error) {
 	rw := test.RW(u.RW)
@@ -335,14 +689,41 @@ func (u doRun) run(ctx context.Context, test *Test) (src reporter, err error) {
 		err = nil
 	}
 	var a appendData
-	p := test.DuringDefault.report()
+	var p report
+	var g *progress
+	if u.Event != nil {
+		g = newProgress(test, u.Event)
+		defer g.send()
+		p = append(p, g)
+	}
+	if u.Live != nil {
+		p = append(p, u.Live.reporter(test))
+	}
+	if test.Minimal != nil {
+		p = append(p, test.Minimal)
+	}
+	p = p.add(test.DuringDefault.report())
 	p = p.add(test.During.report())
-	if w != nil {
-		p = append(p, writeData{w})
+	if w != nil && test.DataSegment != nil {
+		s := &writeSegments{
+			DataSegment: *test.DataSegment,
+			index:       w,
+			dataFile:    test.DataFile,
+			format:      test.DataFormat,
+		}
+		if g != nil {
+			s.written = &g.written
+		}
+		p = append(p, s)
+	} else if w != nil {
+		if g != nil {
+			w = countWriteCloser{w, &g.written}
+		}
+		p = append(p, writeData{w, test.DataFormat})
 	} else {
 		p = append(p, &a)
 	}
-	d := make(chan any, dataChanBufLen)
+	d := make(chan any, dataChanLen(ctx))
 	ctx, x := context.WithCancelCause(ctx)
 	defer x(nil)
 	if test.Timeout > 0 {
@@ -350,24 +731,48 @@ func (u doRun) run(ctx context.Context, test *Test) (src reporter, err error) {
 		ctx, t = context.WithTimeout(ctx, test.Timeout.Duration())
 		defer t()
 	}
-	go node.Do(ctx, &test.Run, &exeSource{}, d)
-	for e := range p.pipeline(ctx, rw, d, nil) {
+	var h func([]node.NodeProcess)
+	if u.Hold != nil {
+		h = func(proc []node.NodeProcess) {
+			u.Hold(ctx, test, proc)
+		}
+	}
+	var f func(node.Feedback)
+	if len(test.Export) > 0 {
+		f = func(fb node.Feedback) {
+			u.Manifest.feedback(u.Feedback.export(test, fb))
+		}
+	}
+	go node.Do(ctx, &test.Run, &exeSource{}, node.Options{
+		NodeOptions: node.NodeOptions{
+			Log:      test.Log,
+			Overflow: test.Overflow,
+			Tuning:   contextTuning(ctx).Tuning,
+			Diagnose: test.Diagnose,
+			Lock:     u.lock(test),
+		},
+		Feedback:   u.Feedback.get(test.Import),
+		OnFeedback: f,
+		Hold:       h,
+	}, d)
+	c := withPipelineStats(withMemoryLimit(withTest(ctx, test), test), test,
+		"during", u.PipelineStats)
+	for e := range p.pipeline(c, rw, d, nil) {
 		x(e)
 		if err == nil {
 			err = e
 		}
 	}
 	if err != nil {
+		if a.spill != nil {
+			a.spill.Close()
+		}
 		return
 	}
 	if w != nil {
-		var r io.ReadCloser
-		if r, err = test.DataReader(rw); err != nil {
-			return
-		}
-		src = readData{r}
+		src = readDataFile(test.DataFile)
 	} else {
-		src = rangeData(a)
+		src, err = a.source()
 	}
 	return
 }
@@ -383,11 +788,7 @@ func (u doRun) link(test *Test) (src reporter, err error) {
 		}
 		return
 	}
-	var r io.ReadCloser
-	if r, err = test.DataReader(rw); err != nil {
-		return
-	}
-	src = readData{r}
+	src = readDataFile(test.DataFile)
 	return
 }
 
@@ -404,6 +805,26 @@ type ReportCommand struct {
 	// Reporting is called when a report starts running.
 	Reporting func(test *Test)
 
+	// Cached is called when a report was not run because its inputs are
+	// unchanged, and its outputs were linked from the prior result.
+	Cached func(test *Test)
+
+	// NoCache, if true, always runs the reports, even if their inputs are
+	// unchanged from the prior result.
+	NoCache bool
+
+	// PipelineStats, if not nil, enables measuring the stages of the report
+	// pipelines, and is called with the stats for each when it completes.
+	PipelineStats func(PipelineStats)
+
+	// Expected is called with the result of a Test's Expect conditions, after
+	// its reports.
+	Expected func(*Test, ExpectResult)
+
+	// Copying is called with the progress of copying the result directory,
+	// when the WorkDir and RootDir are on different filesystems.
+	Copying func(CopyProgress)
+
 	// Done is called when the ReportCommand is done.
 	Done func(ReportInfo)
 }
@@ -413,6 +834,7 @@ type ReportInfo struct {
 	Start     time.Time
 	Elapsed   time.Duration
 	Reported  int
+	Unmet     int
 	ResultDir string
 }
 
@@ -424,14 +846,22 @@ func (r ReportCommand) run(ctx context.Context) (err error) {
 	}
 	var rw resultRW
 	if rw, err = c.Results.open(); err != nil {
+		err = exitError(ExitEnvironment, err)
 		return
 	}
+	ctx = withTuning(ctx, c.Tuning)
 	m := newMultiRunner(c.MultiReport)
-	d := doReport{r, rw, m, &ReportInfo{}}
+	f := newFlowRegistry(c.Test, c.Theme.palette())
+	d := doReport{r, rw, m, f, c.reportConfig(), &ReportInfo{},
+		&expectTally{}}
 	defer func() {
 		if e := m.stop(rw); e != nil && err == nil {
-			err = e
+			err = exitError(ExitReport, e)
 		}
+		if err == nil {
+			err = d.Tally.err()
+		}
+		d.Info.Unmet = d.Tally.unmet
 		d.Info.Elapsed = time.Since(d.Info.Start)
 		if d.Info.Reported == 0 {
 			if e := rw.Abort(); e != nil && err == nil {
@@ -439,7 +869,12 @@ func (r ReportCommand) run(ctx context.Context) (err error) {
 			}
 		} else {
 			var e error
-			if d.Info.ResultDir, e = rw.Close(); e != nil && err == nil {
+			if d.Info.ResultDir, e = rw.Close(r.Copying); e != nil &&
+				err == nil {
+				err = e
+			}
+			if e = rw.index(d.Info.ResultDir, c.Test); e != nil &&
+				err == nil {
 				err = e
 			}
 		}
@@ -448,6 +883,7 @@ func (r ReportCommand) run(ctx context.Context) (err error) {
 		}
 	}()
 	if err = m.start(rw); err != nil {
+		err = exitError(ExitReport, err)
 		return
 	}
 	d.Info.Start = time.Now()
@@ -463,9 +899,12 @@ func (r ReportCommand) run(ctx context.Context) (err error) {
 // doReport is a Tester that runs reports.
 type doReport struct {
 	ReportCommand
-	RW    resultRW
-	Multi *multiRunner
-	Info  *ReportInfo
+	RW     resultRW
+	Multi  *multiRunner
+	Flows  *flowRegistry
+	Report reportConfig
+	Info   *ReportInfo
+	Tally  *expectTally
 }
 
 // Test implements Tester.
@@ -489,24 +928,29 @@ func (d doReport) Test(ctx context.Context, test *Test) (err error) {
 	if d.Reporting != nil {
 		d.Reporting(test)
 	}
-	var r io.ReadCloser
-	if r, err = test.DataReader(rw); err != nil {
+	d.Info.Reported++
+	a := afterReports{d.Multi, d.Flows, d.Report, d.NoCache, d.PipelineStats,
+		d.expected}
+	var c bool
+	if c, err = a.run(ctx, rw, test, readDataFile(test.DataFile)); err != nil {
+		err = exitError(ExitReport, err)
 		return
 	}
-	d.Info.Reported++
-	t := report([]reporter{readData{r}})
-	t = t.add(test.AfterDefault.report())
-	t = t.add(test.After.report())
-	o, me := d.Multi.tee(ctx, rw, test)
-	pe := t.pipeline(ctx, rw, nil, o)
-	for e := range mergeErr(me, pe) {
-		if err == nil {
-			err = e
-		}
+	if c && d.Cached != nil {
+		d.Cached(test)
 	}
 	return
 }
 
+// expected adds the result of a Test's Expect conditions to the Tally, and
+// calls Expected.
+func (d doReport) expected(test *Test, result ExpectResult) {
+	d.Tally.add(test, result)
+	if d.Expected != nil {
+		d.Expected(test, result)
+	}
+}
+
 // ServerCommand runs the builtin web server.
 type ServerCommand struct {
 }
@@ -520,10 +964,47 @@ func (s ServerCommand) run(ctx context.Context) (err error) {
 	log.SetPrefix("")
 	log.SetFlags(0)
 	log.SetOutput(os.Stdout)
+	c.Server.results = c.Results
+	c.Server.test = c.Test
 	err = c.Server.Run(ctx)
 	return
 }
 
+// SearchCommand searches the Catalog of results.
+type SearchCommand struct {
+	// Query selects the Tests to return.
+	Query CatalogQuery
+
+	// Result is called for each Test selected by Query.
+	Result func(CatalogResult)
+}
+
+// run implements command
+func (s SearchCommand) run(ctx context.Context) (err error) {
+	var c *Config
+	if c, err = LoadConfig(&load.Config{}); err != nil {
+		return
+	}
+	if c.Results.Catalog == "" {
+		err = ExitError{ExitConfig,
+			fmt.Errorf("the Catalog is disabled (Results.Catalog is empty)")}
+		return
+	}
+	var g Catalog
+	if g, err = loadCatalog(c.Results.Catalog); err != nil {
+		return
+	}
+	if err = g.prune(c.Results.RootDir); err != nil {
+		return
+	}
+	for _, r := range g.Search(s.Query, c.Results.RootDir) {
+		if s.Result != nil {
+			s.Result(r)
+		}
+	}
+	return
+}
+
 /*
 // teeReport runs the Test.Report and reportStack pipelines concurrently, using
 // src to supply the data.
@@ -0,0 +1,250 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// APIResult is a result directory, as listed by the REST API.
+type APIResult struct {
+	// Name is the base name of the result directory.
+	Name string
+
+	// Tests is the number of Tests that wrote new files to the result.
+	Tests int
+
+	// Unmet is the number of Tests whose Expect conditions were not met.
+	Unmet int
+}
+
+// APIReport is the state of report regeneration, as returned by the REST API.
+type APIReport struct {
+	// Running is true while the reports are being regenerated.
+	Running bool
+
+	// Start is the time regeneration last started.
+	Start time.Time `json:",omitempty"`
+
+	// Info is the ReportInfo for the last completed regeneration.
+	Info *ReportInfo `json:",omitempty"`
+
+	// Error is the error from the last completed regeneration, if any.
+	Error string `json:",omitempty"`
+}
+
+// apiHandler is the http.Handler for the /api endpoints of the builtin web
+// server. Results and Tests are listed from the Catalog, and summaries are
+// calculated from the data files, using the Results and Tests in the config.
+type apiHandler struct {
+	ctx     context.Context
+	token   string
+	rootDir string
+	catalog string
+	results Results
+	test    Tests
+	report  APIReport
+	mtx     sync.Mutex
+}
+
+// register registers the handler's endpoints with the given ServeMux. POST
+// endpoints are registered only if there is a token, as they modify results.
+func (h *apiHandler) register(m *http.ServeMux) {
+	m.HandleFunc("GET /api/results", h.listResults)
+	m.HandleFunc("GET /api/results/{result}/tests", h.listTests)
+	m.HandleFunc("GET /api/results/{result}/stats/{path...}", h.stats)
+	m.HandleFunc("GET /api/report", h.getReport)
+	if h.token != "" {
+		m.HandleFunc("POST /api/report", tokenAuth(h.token, h.postReport))
+	}
+}
+
+// listResults returns the APIResults in the Catalog, newest first.
+func (h *apiHandler) listResults(w http.ResponseWriter, r *http.Request) {
+	c, err := h.loadCatalog()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rr := []APIResult{}
+	for _, u := range c.Run {
+		a := APIResult{Name: u.Name, Tests: len(u.Test)}
+		for _, t := range u.Test {
+			if t.Expect != nil && !t.Expect.Pass {
+				a.Unmet++
+			}
+		}
+		rr = append(rr, a)
+	}
+	sort.Slice(rr, func(i, j int) bool {
+		return rr[i].Name > rr[j].Name
+	})
+	h.encode(w, rr)
+}
+
+// listTests returns the CatalogTests for a result.
+func (h *apiHandler) listTests(w http.ResponseWriter, r *http.Request) {
+	u, err := h.run(r.PathValue("result"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	tt := u.Test
+	if tt == nil {
+		tt = []CatalogTest{}
+	}
+	h.encode(w, tt)
+}
+
+// stats returns the Stats for the Test with the given path in a result, as
+// written by EmitStats, calculated from its data file.
+func (h *apiHandler) stats(w http.ResponseWriter, r *http.Request) {
+	u, err := h.run(r.PathValue("result"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	p := r.PathValue("path")
+	var t *Test
+	for _, c := range u.Test {
+		if c.Path != p {
+			continue
+		}
+		for i := range h.test {
+			if h.test[i].ID.Equal(c.ID) {
+				t = &h.test[i]
+				break
+			}
+		}
+		if t == nil {
+			err = fmt.Errorf("Test %s is not in the config", c.ID)
+		}
+		break
+	}
+	if t == nil {
+		if err == nil {
+			err = fmt.Errorf("no Test with path '%s' in result %s", p, u.Name)
+		}
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	var s *Stats
+	if s, err = h.analyze(u.Name, t); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.encode(w, s)
+}
+
+// analyze returns the Stats for a Test in the named result.
+func (h *apiHandler) analyze(result string, test *Test) (s *Stats, err error) {
+	var y analysis
+	if y, err = readAnalysis(h.results, filepath.Join(h.rootDir, result),
+		test); err != nil {
+		return
+	}
+	s = newStats(y)
+	return
+}
+
+// getReport returns the APIReport.
+func (h *apiHandler) getReport(w http.ResponseWriter, r *http.Request) {
+	h.mtx.Lock()
+	p := h.report
+	h.mtx.Unlock()
+	h.encode(w, p)
+}
+
+// postReport starts regenerating the reports, as for the report command, and
+// returns the APIReport with status Accepted, or Conflict if regeneration is
+// already running.
+func (h *apiHandler) postReport(w http.ResponseWriter, r *http.Request) {
+	h.mtx.Lock()
+	if h.report.Running {
+		p := h.report
+		h.mtx.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		if err := json.NewEncoder(w).Encode(p); err != nil {
+			log.Printf("api: %s", err)
+		}
+		return
+	}
+	h.report.Running = true
+	h.report.Start = time.Now()
+	p := h.report
+	h.mtx.Unlock()
+	go h.runReport()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		log.Printf("api: %s", err)
+	}
+}
+
+// runReport runs the ReportCommand and records its result.
+func (h *apiHandler) runReport() {
+	var i ReportInfo
+	err := Run(h.ctx, ReportCommand{
+		Done: func(info ReportInfo) {
+			i = info
+		},
+	})
+	switch {
+	case err != nil:
+		log.Printf("api: report regeneration failed: %s", err)
+	case i.ResultDir == "":
+		log.Printf("api: reported on %d tests, no changes made", i.Reported)
+	default:
+		log.Printf("api: reported on %d tests, result saved to: '%s'",
+			i.Reported, i.ResultDir)
+	}
+	h.mtx.Lock()
+	h.report.Running = false
+	h.report.Info = &i
+	h.report.Error = ""
+	if err != nil {
+		h.report.Error = err.Error()
+	}
+	h.mtx.Unlock()
+}
+
+// loadCatalog loads the Catalog and prunes results that no longer exist.
+func (h *apiHandler) loadCatalog() (c Catalog, err error) {
+	if c, err = loadCatalog(h.catalog); err == nil {
+		err = c.prune(h.rootDir)
+	}
+	return
+}
+
+// run returns the CatalogRun for the named result.
+func (h *apiHandler) run(name string) (u CatalogRun, err error) {
+	var c Catalog
+	if c, err = h.loadCatalog(); err != nil {
+		return
+	}
+	for _, u = range c.Run {
+		if u.Name == name {
+			return
+		}
+	}
+	err = fmt.Errorf("result %s not found", name)
+	return
+}
+
+// encode writes v as JSON.
+func (h *apiHandler) encode(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("api: %s", err)
+	}
+}
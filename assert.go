@@ -0,0 +1,327 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"fmt"
+	"strings"
+
+	"gonum.org/v1/gonum/stat"
+)
+
+// Assert contains the union of composable predicates on a Test's results, for
+// acceptance criteria that can't be expressed as a simple MetricCond in Expect.
+// Only one field may be set.
+type Assert struct {
+	Metric     *MetricCond
+	Between    *Between
+	Percentile *Percentile
+	Ratio      *Ratio
+	Trend      *Trend
+	Not        *Assert
+	All        []Assert
+	Any        []Assert
+}
+
+// predicate returns the only non-nil predicate in the union, or panics if
+// exactly one field isn't set.
+func (a *Assert) predicate() (p predicate) {
+	var n int
+	if p, n = a.value(); n != 1 {
+		panic(UnionError{a, n}.Error())
+	}
+	return
+}
+
+// validate returns an error if exactly one field isn't set, in this Assert or
+// any it contains.
+func (a *Assert) validate() (err error) {
+	if _, n := a.value(); n != 1 {
+		err = UnionError{a, n}
+		return
+	}
+	if a.Not != nil {
+		if err = a.Not.validate(); err != nil {
+			return
+		}
+	}
+	for _, aa := range [][]Assert{a.All, a.Any} {
+		for i := range aa {
+			if err = aa[i].validate(); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// value returns the last non-nil field, and the number of non-nil fields.
+func (a *Assert) value() (p predicate, n int) {
+	if a.Metric != nil {
+		p = a.Metric
+		n++
+	}
+	if a.Between != nil {
+		p = a.Between
+		n++
+	}
+	if a.Percentile != nil {
+		p = a.Percentile
+		n++
+	}
+	if a.Ratio != nil {
+		p = a.Ratio
+		n++
+	}
+	if a.Trend != nil {
+		p = a.Trend
+		n++
+	}
+	if a.Not != nil {
+		p = not{a.Not}
+		n++
+	}
+	if a.All != nil {
+		p = all(a.All)
+		n++
+	}
+	if a.Any != nil {
+		p = anyOf(a.Any)
+		n++
+	}
+	return
+}
+
+// hasTrend returns true if any of the Asserts contain a Trend.
+func hasTrend(aa []Assert) bool {
+	for _, a := range aa {
+		if a.Trend != nil {
+			return true
+		}
+		if a.Not != nil && hasTrend([]Assert{*a.Not}) {
+			return true
+		}
+		if hasTrend(a.All) || hasTrend(a.Any) {
+			return true
+		}
+	}
+	return false
+}
+
+// predicate is implemented by the conditions in Expect and Assert.
+type predicate interface {
+	// eval evaluates the condition on the Test's results.
+	eval(env assertEnv) ExpectCond
+}
+
+// assertEnv contains the results that predicates are evaluated on.
+type assertEnv struct {
+	// metrics are the Test's Metrics.
+	metrics Metrics
+
+	// dists are the Test's distributions of delay values.
+	dists dists
+
+	// prior are the Metrics for the Test from the most recent run in the
+	// Catalog, or nil if there are none.
+	prior Metrics
+}
+
+// metric returns a condition evaluated on the named metric's value, using
+// compare.
+func (e assertEnv) metric(expect, name string,
+	compare func(float64) bool) (c ExpectCond) {
+	c.Expect = expect
+	v, ok := e.metrics[name]
+	if !ok {
+		c.Note = fmt.Sprintf("metric %s not found", name)
+		return
+	}
+	c.Value = &v
+	c.Pass = compare(v)
+	return
+}
+
+// eval implements predicate
+func (m *MetricCond) eval(env assertEnv) ExpectCond {
+	return env.metric(m.String(), m.Name, func(v float64) bool {
+		return compare(v, m.Op, m.Value)
+	})
+}
+
+// compare returns true if v meets the condition given by op and value, where op
+// is one of <, <=, > or >=.
+func compare(v float64, op string, value float64) bool {
+	switch op {
+	case "<":
+		return v < value
+	case "<=":
+		return v <= value
+	case ">":
+		return v > value
+	case ">=":
+		return v >= value
+	}
+	return false
+}
+
+// Between is a predicate that passes if the value of the named metric is
+// between Min and Max, inclusive.
+type Between struct {
+	Metric string
+	Min    Number
+	Max    Number
+}
+
+// eval implements predicate
+func (b *Between) eval(env assertEnv) ExpectCond {
+	x := fmt.Sprintf("%s in [%g, %g]", b.Metric, b.Min, b.Max)
+	return env.metric(x, b.Metric, func(v float64) bool {
+		return v >= float64(b.Min) && v <= float64(b.Max)
+	})
+}
+
+// Percentile is a predicate on an arbitrary percentile P (from 0 to 100) of a
+// named distribution of delays in milliseconds, e.g. owd, rtt, udp.owd_up or
+// rpc_latency. It's used for percentiles other than those in the Metrics.
+type Percentile struct {
+	Dist  string
+	P     Number
+	Op    string
+	Value Number
+}
+
+// eval implements predicate
+func (p *Percentile) eval(env assertEnv) (c ExpectCond) {
+	c.Expect = fmt.Sprintf("p%g(%s)%s%g", p.P, p.Dist, p.Op, p.Value)
+	vv, ok := env.dists[p.Dist]
+	if !ok {
+		c.Note = fmt.Sprintf("distribution %s not found", p.Dist)
+		return
+	}
+	v := stat.Quantile(float64(p.P)/100, stat.Empirical, vv, nil)
+	c.Value = &v
+	c.Pass = compare(v, p.Op, float64(p.Value))
+	return
+}
+
+// Ratio is a predicate on the ratio of the values of two named metrics, Metric
+// divided by Over, e.g. to compare the goodput of one flow to another.
+type Ratio struct {
+	Metric string
+	Over   string
+	Op     string
+	Value  Number
+}
+
+// eval implements predicate
+func (r *Ratio) eval(env assertEnv) (c ExpectCond) {
+	c.Expect = fmt.Sprintf("%s/%s%s%g", r.Metric, r.Over, r.Op, r.Value)
+	for _, n := range []string{r.Metric, r.Over} {
+		if _, ok := env.metrics[n]; !ok {
+			c.Note = fmt.Sprintf("metric %s not found", n)
+			return
+		}
+	}
+	d := env.metrics[r.Over]
+	if d == 0 {
+		c.Note = fmt.Sprintf("metric %s is zero", r.Over)
+		return
+	}
+	v := env.metrics[r.Metric] / d
+	c.Value = &v
+	c.Pass = compare(v, r.Op, float64(r.Value))
+	return
+}
+
+// Trend is a predicate on the percent change in the value of a named metric,
+// relative to its value for the same Test in the most recent run in the
+// Catalog. If there's no prior value, the predicate passes.
+type Trend struct {
+	Metric string
+	Op     string
+	Value  Number
+}
+
+// eval implements predicate
+func (t *Trend) eval(env assertEnv) (c ExpectCond) {
+	c.Expect = fmt.Sprintf("change(%s)%s%g%%", t.Metric, t.Op, t.Value)
+	p, ok := env.prior[t.Metric]
+	if !ok {
+		c.Note = fmt.Sprintf("no prior value for %s", t.Metric)
+		c.Pass = true
+		return
+	}
+	v, ok := env.metrics[t.Metric]
+	if !ok {
+		c.Note = fmt.Sprintf("metric %s not found", t.Metric)
+		return
+	}
+	if p == 0 {
+		c.Note = fmt.Sprintf("prior value for %s is zero", t.Metric)
+		c.Pass = true
+		return
+	}
+	v = 100 * (v - p) / p
+	c.Value = &v
+	c.Pass = compare(v, t.Op, float64(t.Value))
+	return
+}
+
+// not is a predicate that passes if its Assert fails.
+type not struct {
+	*Assert
+}
+
+// eval implements predicate
+func (n not) eval(env assertEnv) (c ExpectCond) {
+	x := n.predicate().eval(env)
+	c.Expect = fmt.Sprintf("not(%s)", x.Expect)
+	c.Pass = !x.Pass
+	return
+}
+
+// all is a predicate that passes if all of its Asserts pass.
+type all []Assert
+
+// eval implements predicate
+func (a all) eval(env assertEnv) ExpectCond {
+	return evalList("all", a, env, func(pass, n int) bool {
+		return pass == n
+	})
+}
+
+// anyOf is a predicate that passes if any of its Asserts pass.
+type anyOf []Assert
+
+// eval implements predicate
+func (a anyOf) eval(env assertEnv) ExpectCond {
+	return evalList("any", a, env, func(pass, n int) bool {
+		return pass > 0
+	})
+}
+
+// evalList evaluates a list of Asserts, with the result determined by the
+// passed func, given the number that passed and the total. The Note lists
+// those that failed.
+func evalList(name string, aa []Assert, env assertEnv,
+	result func(pass, n int) bool) (c ExpectCond) {
+	var xx, ff []string
+	var p int
+	for i := range aa {
+		x := aa[i].predicate().eval(env)
+		xx = append(xx, x.Expect)
+		if x.Pass {
+			p++
+		} else {
+			ff = append(ff, x.Expect)
+		}
+	}
+	c.Expect = fmt.Sprintf("%s(%s)", name, strings.Join(xx, ", "))
+	c.Pass = result(p, len(aa))
+	if !c.Pass && len(ff) > 0 {
+		c.Note = "failed: " + strings.Join(ff, ", ")
+	}
+	return
+}
@@ -0,0 +1,267 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"sync"
+)
+
+// reportCacheFile is the name suffix of the file that records the key and
+// outputs of a Test's After reports.
+const reportCacheFile = "reportcache.json"
+
+// reportCache is saved after a Test's After reports run, to record their key,
+// and the files they wrote and removed. If the key is unchanged in a later run,
+// the outputs are linked from the prior result instead of being regenerated.
+type reportCache struct {
+	Key    string
+	Write  []string
+	Remove []string
+}
+
+// afterReports runs the After reports for Tests, or if their inputs are
+// unchanged, links their outputs from the prior result.
+type afterReports struct {
+	Multi   *multiRunner
+	Flows   *flowRegistry
+	Config  reportConfig
+	NoCache bool
+	Stats   func(PipelineStats)
+	Expect  func(*Test, ExpectResult)
+}
+
+// run runs the After reports for a Test, using src as the data source. If the
+// outputs were linked from the cache, cached is true.
+func (a afterReports) run(ctx context.Context, rw resultRW, test *Test,
+	src reporter) (cached bool, err error) {
+	var k string
+	if !a.NoCache && test.DataFile != "" {
+		if k, err = a.key(rw, test); err != nil {
+			return
+		}
+		if cached, err = a.link(rw, k); err != nil || cached {
+			if cached {
+				err = a.tee(ctx, rw, test, src)
+			}
+			return
+		}
+	}
+	c := &cacheRW{rwer: rw}
+	r := report([]reporter{src, a.Flows})
+	r = r.add(test.AfterDefault.report())
+	r = r.add(test.After.report())
+	if test.hasExpect() {
+		var p Metrics
+		if hasTrend(test.Assert) {
+			if p, err = rw.priorMetrics(test); err != nil {
+				return
+			}
+		}
+		r = append(r, checkExpect{test, p, a.Expect})
+	}
+	o, me := a.Multi.tee(ctx, rw, test)
+	x := withPipelineStats(withMemoryLimit(withTest(ctx, test), test), test,
+		"after", a.Stats)
+	pe := r.pipeline(x, c, nil, o)
+	for e := range mergeErr(me, pe) {
+		if err == nil {
+			err = e
+		}
+	}
+	if err != nil || k == "" {
+		return
+	}
+	c.cache.Key = k
+	err = c.save(rw)
+	return
+}
+
+// tee sends the data from src to any multiReporters for the Test, without
+// running the After reports. The result of any Expect or Assert conditions is
+// read from the prior result.
+func (a afterReports) tee(ctx context.Context, rw resultRW, test *Test,
+	src reporter) (err error) {
+	var x report
+	if test.hasExpect() {
+		x = report{replayExpect{test, nil, a.Expect}}
+	}
+	o, me := a.Multi.tee(ctx, rw, test)
+	var pe <-chan error
+	if o != nil {
+		pe = report([]reporter{src}).add(x).pipeline(ctx, rw, nil, o)
+	} else if x != nil {
+		pe = x.pipeline(ctx, rw, nil, nil)
+	}
+	for e := range mergeErr(me, pe) {
+		if err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+// reportConfig contains the Config-level settings that affect the output of
+// After reports. These are not in the Test's encoded config, as reporters hold
+// them in unexported fields, or they're applied by the resultRW.
+type reportConfig struct {
+	Theme Theme
+	Codec Codecs
+}
+
+// key returns the cache key for the Test's After reports. The key is a hash of
+// the antler executable, the Test's config, the Flow registry, the
+// reportConfig and the DataFile. For segmented data, the DataFile is the index,
+// which identifies its segments.
+func (a afterReports) key(rw resultRW, test *Test) (key string, err error) {
+	h := sha256.New()
+	var x []byte
+	if x, err = executableHash(); err != nil {
+		return
+	}
+	h.Write(x)
+	e := json.NewEncoder(h)
+	if err = e.Encode(test); err != nil {
+		return
+	}
+	if err = a.Flows.encode(h); err != nil {
+		return
+	}
+	if err = e.Encode(a.Config); err != nil {
+		return
+	}
+	var r *ResultReader
+	if r, err = rw.Reader(test.DataFile); err != nil {
+		return
+	}
+	defer func() {
+		if e := r.Close(); e != nil && err == nil {
+			err = e
+		}
+	}()
+	if _, err = io.Copy(h, r); err != nil {
+		return
+	}
+	key = hex.EncodeToString(h.Sum(nil))
+	return
+}
+
+// link reads the reportCache from the prior result, and if its key matches,
+// links the files it lists and removes any removed files. If all the files
+// were linked, cached is true.
+func (a afterReports) link(rw resultRW, key string) (cached bool, err error) {
+	var c reportCache
+	var f *os.File
+	if f, err = rw.openPrior(reportCacheFile); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+		return
+	}
+	defer f.Close()
+	if err = json.NewDecoder(f).Decode(&c); err != nil || c.Key != key {
+		err = nil
+		return
+	}
+	for _, n := range c.Write {
+		if !rw.HasPrior(n) {
+			return
+		}
+	}
+	for _, n := range append(c.Write, reportCacheFile) {
+		if err = rw.Link(n); err != nil {
+			return
+		}
+	}
+	for _, n := range c.Remove {
+		if e := rw.Remove(n); e != nil && !errors.Is(e, fs.ErrNotExist) {
+			err = e
+			return
+		}
+	}
+	cached = true
+	return
+}
+
+// executableHash returns a hash of the running executable, so that cached
+// reports are regenerated when the report code changes.
+var executableHash = sync.OnceValues(func() (hash []byte, err error) {
+	var p string
+	if p, err = os.Executable(); err != nil {
+		return
+	}
+	var f *os.File
+	if f, err = os.Open(p); err != nil {
+		return
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return
+	}
+	hash = h.Sum(nil)
+	return
+})
+
+// cacheRW is an rwer that records the names of the files written and removed
+// by reports, for the reportCache.
+type cacheRW struct {
+	rwer
+	cache reportCache
+	mtx   sync.Mutex
+}
+
+// Writer implements rwer
+func (c *cacheRW) Writer(name string) *ResultWriter {
+	if name != "-" {
+		c.mtx.Lock()
+		if !slices.Contains(c.cache.Write, name) {
+			c.cache.Write = append(c.cache.Write, name)
+		}
+		c.cache.Remove = slices.DeleteFunc(c.cache.Remove,
+			func(n string) bool {
+				return n == name
+			})
+		c.mtx.Unlock()
+	}
+	return c.rwer.Writer(name)
+}
+
+// Remove implements rwer
+func (c *cacheRW) Remove(name string) (err error) {
+	if err = c.rwer.Remove(name); err != nil {
+		return
+	}
+	c.mtx.Lock()
+	c.cache.Write = slices.DeleteFunc(c.cache.Write, func(n string) bool {
+		return n == name
+	})
+	if !slices.Contains(c.cache.Remove, name) {
+		c.cache.Remove = append(c.cache.Remove, name)
+	}
+	c.mtx.Unlock()
+	return
+}
+
+// save writes the reportCache.
+func (c *cacheRW) save(rw resultRW) (err error) {
+	w := rw.Writer(reportCacheFile)
+	defer func() {
+		if e := w.Close(); e != nil && err == nil {
+			err = e
+		}
+	}()
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	err = e.Encode(c.cache)
+	return
+}
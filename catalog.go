@@ -0,0 +1,327 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// Catalog is a metadata index of results, with the Test IDs, key Metrics and
+// paths for each run. It's updated when a result is saved, so that historical
+// results may be searched by Test ID and Metrics (see CatalogQuery).
+type Catalog struct {
+	// Run lists the runs in the catalog, sorted by Name.
+	Run []CatalogRun
+}
+
+// CatalogRun is the metadata for one result directory.
+type CatalogRun struct {
+	// Name is the base name of the result directory.
+	Name string
+
+	// Test lists the Tests that wrote new files to the result.
+	Test []CatalogTest
+}
+
+// CatalogTest is the metadata for one Test in a result.
+type CatalogTest struct {
+	// ID is the Test ID.
+	ID TestID
+
+	// Path is the Test's path prefix within the result directory.
+	Path string
+
+	// Metrics are the key metrics written by EmitMetrics, if any.
+	Metrics Metrics `json:",omitempty"`
+
+	// Expect is the result of the Test's Expect conditions, if any.
+	Expect *ExpectResult `json:",omitempty"`
+}
+
+// loadCatalog reads the Catalog from the named file. If the file doesn't
+// exist, an empty Catalog is returned.
+func loadCatalog(name string) (c Catalog, err error) {
+	var b []byte
+	if b, err = os.ReadFile(name); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+		return
+	}
+	if err = json.Unmarshal(b, &c); err != nil {
+		err = fmt.Errorf("unable to read catalog '%s': %w", name, err)
+	}
+	return
+}
+
+// prune removes the runs whose result directories under rootDir no longer
+// exist.
+func (c *Catalog) prune(rootDir string) (err error) {
+	var rr []CatalogRun
+	for _, u := range c.Run {
+		if _, e := os.Stat(filepath.Join(rootDir, u.Name)); e != nil {
+			if errors.Is(e, fs.ErrNotExist) {
+				continue
+			}
+			err = e
+			return
+		}
+		rr = append(rr, u)
+	}
+	c.Run = rr
+	return
+}
+
+// updateCatalog adds the result directory to the Catalog, with the Tests that
+// wrote new files to it. Runs whose result directories no longer exist are
+// removed. If Catalog is empty, nothing is done.
+func (r resultRW) updateCatalog(resultDir string, tests Tests) (err error) {
+	if r.Catalog == "" || resultDir == "" {
+		return
+	}
+	var c Catalog
+	if c, err = loadCatalog(r.Catalog); err != nil {
+		return
+	}
+	if err = c.prune(r.RootDir); err != nil {
+		return
+	}
+	n := filepath.Base(resultDir)
+	var rr []CatalogRun
+	for _, u := range c.Run {
+		if u.Name != n {
+			rr = append(rr, u)
+		}
+	}
+	u := CatalogRun{Name: n}
+	w := r.New()
+	for i := range tests {
+		t := &tests[i]
+		if len(w.withPrefix(t.Path)) == 0 {
+			continue
+		}
+		ct := CatalogTest{ID: t.ID, Path: t.Path}
+		p := filepath.Join(resultDir, t.Path)
+		if err = readJSON(p+metricsFile, &ct.Metrics); err != nil {
+			return
+		}
+		var x ExpectResult
+		if err = readJSON(p+expectFile, &x); err != nil {
+			return
+		}
+		if x.Cond != nil {
+			ct.Expect = &x
+		}
+		u.Test = append(u.Test, ct)
+	}
+	if len(u.Test) > 0 {
+		rr = append(rr, u)
+	}
+	sort.Slice(rr, func(i, j int) bool {
+		return rr[i].Name < rr[j].Name
+	})
+	c.Run = rr
+	err = r.saveCatalog(c)
+	return
+}
+
+// priorMetrics returns the Metrics for the Test from the most recent run in
+// the Catalog that has them, or nil if there are none.
+func (r resultRW) priorMetrics(test *Test) (m Metrics, err error) {
+	if r.Catalog == "" {
+		return
+	}
+	var c Catalog
+	if c, err = loadCatalog(r.Catalog); err != nil {
+		return
+	}
+	for i := len(c.Run) - 1; i >= 0; i-- {
+		for _, t := range c.Run[i].Test {
+			if t.ID.Equal(test.ID) && t.Metrics != nil {
+				m = t.Metrics
+				return
+			}
+		}
+	}
+	return
+}
+
+// readJSON reads the named JSON file into v. If the file doesn't exist, v is
+// unchanged and nil is returned.
+func readJSON(name string, v any) (err error) {
+	var b []byte
+	if b, err = os.ReadFile(name); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+		return
+	}
+	if err = json.Unmarshal(b, v); err != nil {
+		err = fmt.Errorf("unable to read '%s': %w", name, err)
+	}
+	return
+}
+
+// saveCatalog writes the Catalog to a temporary file, then renames it to the
+// Catalog file, so readers always see a complete Catalog.
+func (r resultRW) saveCatalog(c Catalog) (err error) {
+	if err = r.perm.mkdirAll(filepath.Dir(r.Catalog)); err != nil {
+		return
+	}
+	t := r.Catalog + "~"
+	var f *os.File
+	if f, err = r.perm.create(t); err != nil {
+		return
+	}
+	e := json.NewEncoder(f)
+	e.SetIndent("", "  ")
+	if err = e.Encode(c); err != nil {
+		f.Close()
+		return
+	}
+	if err = f.Close(); err != nil {
+		return
+	}
+	err = os.Rename(t, r.Catalog)
+	return
+}
+
+// CatalogQuery selects Tests from the Catalog by Test ID and Metrics.
+type CatalogQuery struct {
+	// Filter accepts Tests by their ID. If nil, all Tests are accepted.
+	Filter TestFilter
+
+	// Metric lists conditions that the Test's Metrics must all meet.
+	Metric []MetricCond
+}
+
+// metricCondRegex matches a MetricCond argument.
+var metricCondRegex = regexp.MustCompile(`^([^<>=!]+)(<=|>=|<|>)(.+)$`)
+
+// NewCatalogQueryArgs returns a new CatalogQuery from string arguments. Each
+// argument is either a metric condition in the form name<op>value, where op is
+// one of <, <=, > or >=, or a Test ID filter argument as for
+// NewRegexFilterArg. For example, the arguments cca=bbr2 and owd_p95_ms>20
+// select Tests with cca=bbr2 and a 95th percentile one-way delay over 20 ms.
+func NewCatalogQueryArgs(args []string) (q CatalogQuery, err error) {
+	var a AndFilter
+	for _, s := range args {
+		if metricCondRegex.MatchString(s) {
+			var m MetricCond
+			if err = m.UnmarshalText([]byte(s)); err != nil {
+				return
+			}
+			q.Metric = append(q.Metric, m)
+			continue
+		}
+		var f *RegexFilter
+		if f, err = NewRegexFilterArg(s); err != nil {
+			return
+		}
+		a = append(a, f)
+	}
+	if len(a) > 0 {
+		q.Filter = a
+	}
+	return
+}
+
+// MetricCond is a condition on the value of a named metric.
+type MetricCond struct {
+	Name  string
+	Op    string
+	Value float64
+}
+
+// String implements fmt.Stringer
+func (m MetricCond) String() string {
+	return fmt.Sprintf("%s%s%g", m.Name, m.Op, m.Value)
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (m MetricCond) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, to parse a condition in
+// the form name<op>value, e.g. owd_p95_ms<=20.
+func (m *MetricCond) UnmarshalText(text []byte) (err error) {
+	s := string(text)
+	c := metricCondRegex.FindStringSubmatch(s)
+	if c == nil {
+		err = fmt.Errorf("invalid metric condition '%s'", s)
+		return
+	}
+	var v float64
+	if v, err = strconv.ParseFloat(c[3], 64); err != nil {
+		err = fmt.Errorf("invalid value in metric condition '%s': %w", s, err)
+		return
+	}
+	*m = MetricCond{c[1], c[2], v}
+	return
+}
+
+// Match returns true if the named metric is in Metrics, and its value meets
+// the condition.
+func (m MetricCond) Match(metrics Metrics) bool {
+	v, ok := metrics[m.Name]
+	if !ok {
+		return false
+	}
+	return compare(v, m.Op, m.Value)
+}
+
+// CatalogResult is a Test in the Catalog selected by a CatalogQuery.
+type CatalogResult struct {
+	// Run is the base name of the result directory.
+	Run string
+
+	// Path is the Test's path prefix, including RootDir and the result
+	// directory.
+	Path string
+
+	// ID is the Test ID.
+	ID TestID
+
+	// Metrics are the Test's key metrics.
+	Metrics Metrics `json:",omitempty"`
+
+	// Expect is the result of the Test's Expect conditions, if any.
+	Expect *ExpectResult `json:",omitempty"`
+}
+
+// Search returns the Tests in the Catalog selected by the query, in Catalog
+// order. rootDir is prepended to each result's Path.
+func (c Catalog) Search(q CatalogQuery, rootDir string) (
+	result []CatalogResult) {
+	for _, u := range c.Run {
+	test:
+		for _, t := range u.Test {
+			if q.Filter != nil && !q.Filter.Accept(&Test{ID: t.ID}) {
+				continue
+			}
+			for _, m := range q.Metric {
+				if !m.Match(t.Metrics) {
+					continue test
+				}
+			}
+			result = append(result, CatalogResult{
+				u.Name,
+				filepath.Join(rootDir, u.Name, t.Path),
+				t.ID,
+				t.Metrics,
+				t.Expect,
+			})
+		}
+	}
+	return
+}
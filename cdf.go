@@ -0,0 +1,272 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"maps"
+	"sort"
+
+	"github.com/heistp/antler/node"
+)
+
+// CDF metrics.
+const (
+	cdfOWD = "OWD"
+	cdfRTT = "RTT"
+	cdfFCT = "FCT"
+)
+
+// ChartsCDF is a reporter that plots the cumulative distribution (CDF), or
+// complementary cumulative distribution (CCDF), of OWD or RTT for each packet
+// flow, or FCT for each series of stream flows, using Google Charts. Unlike
+// time series plots, these show the tail behavior of the distributions. It
+// requires Analyze before it in the pipeline.
+type ChartsCDF struct {
+	// To lists the names of files to execute the template to. A file of "-"
+	// emits to stdout.
+	To []string
+
+	// Metric is the distribution to plot, either OWD, RTT or FCT.
+	Metric string
+
+	// CCDF, if true, plots the complementary CDF, the fraction of values
+	// greater than or equal to each value, instead of the CDF.
+	CCDF bool
+
+	// LogScale, if true, uses a log scale for the probability axis. This is
+	// normally used with CCDF, to show the tail in more detail.
+	LogScale bool
+
+	// FlowLabel sets custom labels for Flows.
+	FlowLabel map[node.Flow]string
+
+	// Series matches stream Flows to series, for FCT. If empty, all streams
+	// are plotted in one series.
+	Series []FlowSeries
+
+	// MaxPoints, if positive, limits the number of points plotted for each
+	// series, by plotting evenly spaced quantiles.
+	MaxPoints int
+
+	// DataTable, if true, includes a collapsible table of the plotted data in
+	// the HTML, and a button to download it as CSV.
+	DataTable bool
+
+	// Options is an arbitrary structure of Charts options, with defaults
+	// defined in config.cue.
+	// https://developers.google.com/chart/interactive/docs/gallery/linechart#configuration-options
+	Options map[string]any
+
+	// theme is the Theme set from the Config.
+	theme Theme
+}
+
+// setTheme implements themer
+func (g *ChartsCDF) setTheme(t Theme) {
+	g.theme = t
+}
+
+// validate implements validater
+func (g *ChartsCDF) validate() (err error) {
+	switch g.Metric {
+	case cdfOWD, cdfRTT, cdfFCT:
+	default:
+		err = fmt.Errorf("ChartsCDF Metric must be %s, %s or %s: '%s'",
+			cdfOWD, cdfRTT, cdfFCT, g.Metric)
+	}
+	return
+}
+
+// report implements reporter
+func (g *ChartsCDF) report(ctx context.Context, rw rwer, in <-chan any,
+	out chan<- any) (err error) {
+	var t *template.Template
+	if t, err = newStyleTemplate(g.theme); err != nil {
+		return
+	}
+	t = t.New("ChartsCDF")
+	if t, err = t.Parse(chartsTemplate); err != nil {
+		return
+	}
+	var a analysis
+	for d := range in {
+		out <- d
+		switch v := d.(type) {
+		case analysis:
+			a = v
+		}
+	}
+	f := a.flows
+	if f == nil {
+		f = newFlowRegistry(nil, g.theme.palette())
+	}
+	var ss []cdfSeries
+	if ss, err = g.series(a, f); err != nil {
+		return
+	}
+	dt, cc := g.data(ss)
+	td := chartsTemplateData{
+		"google.visualization.LineChart",
+		dt,
+		g.options(cc, f),
+		a.streamList(),
+		a.packetList(),
+		a.rpcList(),
+		nil,
+		g.DataTable,
+	}
+	var ww []io.WriteCloser
+	for _, to := range g.To {
+		ww = append(ww, rw.Writer(to))
+	}
+	defer func() {
+		for _, w := range ww {
+			if e := w.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	}()
+	err = t.Execute(multiWriteCloser(ctx, ww...), td)
+	return
+}
+
+// cdfSeries is a named series of values for a CDF, with the Flow and line
+// dash style used to style it.
+type cdfSeries struct {
+	Name   string
+	Flow   node.Flow
+	Dash   []int
+	Values []float64
+}
+
+// series returns the series to plot for the Metric, omitting any without
+// values.
+func (g *ChartsCDF) series(a analysis, reg *flowRegistry) (ss []cdfSeries,
+	err error) {
+	add := func(s cdfSeries) {
+		if len(s.Values) > 0 {
+			ss = append(ss, s)
+		}
+	}
+	switch g.Metric {
+	case cdfOWD:
+		for _, p := range a.packetList() {
+			l := reg.Label(p.Flow, g.FlowLabel, false)
+			add(cdfSeries{l + " up", p.Flow, nil, p.Up.delays()})
+			add(cdfSeries{l + " down", p.Flow, dashRTT, p.Down.delays()})
+		}
+	case cdfRTT:
+		for _, p := range a.packetList() {
+			var vv []float64
+			for _, r := range p.RTT {
+				vv = append(vv, r.Delay.Seconds()*1000)
+			}
+			add(cdfSeries{reg.Label(p.Flow, g.FlowLabel, false), p.Flow, nil,
+				vv})
+		}
+	case cdfFCT:
+		r := g.Series
+		if len(r) == 0 {
+			var f flows
+			for _, s := range a.streams {
+				f.add(s.Client.Flow)
+			}
+			r = []FlowSeries{{f.commonPrefix(), ".*", nil}}
+		}
+		for i := range r {
+			s := &r[i]
+			if err = s.Compile(); err != nil {
+				err = fmt.Errorf("regex error in series %s: %w", s.Name, err)
+				return
+			}
+			var vv []float64
+			for _, t := range a.streamList() {
+				if s.Match(t.Client.Flow) {
+					vv = append(vv, t.FCT.Seconds())
+				}
+			}
+			add(cdfSeries{s.Name, node.Flow(s.Name), nil, vv})
+		}
+	}
+	return
+}
+
+// data returns the chart data for the given series, and its columns.
+func (g *ChartsCDF) data(ss []cdfSeries) (data chartsData,
+	cc chartsColumns) {
+	data.set(0, 0, g.xTitle())
+	row := 1
+	for i, s := range ss {
+		data.set(0, i+1, s.Name)
+		cc.add(s.Flow, s.Dash)
+		vv := s.Values
+		sort.Float64s(vv)
+		n := len(vv)
+		k := 1
+		if g.MaxPoints > 0 && n > g.MaxPoints {
+			k = (n + g.MaxPoints - 1) / g.MaxPoints
+		}
+		for j := 0; j < n; j += k {
+			if j+k >= n {
+				j = n - 1
+			}
+			p := float64(j+1) / float64(n)
+			if g.CCDF {
+				p = float64(n-j) / float64(n)
+			}
+			data.set(row, 0, vv[j])
+			data.set(row, i+1, p)
+			row++
+		}
+	}
+	data.normalize()
+	return
+}
+
+// xTitle returns the title for the domain axis.
+func (g *ChartsCDF) xTitle() string {
+	if g.Metric == cdfFCT {
+		return "Flow Completion Time (sec)"
+	}
+	return fmt.Sprintf("%s (ms)", g.Metric)
+}
+
+// options returns the Charts options, with the series styled from the Flow
+// registry, and the title, axis titles and scale set if they weren't
+// configured.
+func (g *ChartsCDF) options(cc chartsColumns, reg *flowRegistry) (
+	opt map[string]any) {
+	opt = cc.options(g.Options, reg)
+	d := "CDF"
+	if g.CCDF {
+		d = "CCDF"
+	}
+	if _, ok := opt["title"]; !ok {
+		opt["title"] = fmt.Sprintf("%s %s", g.Metric, d)
+	}
+	h := make(map[string]any)
+	if m, ok := opt["hAxis"].(map[string]any); ok {
+		h = maps.Clone(m)
+	}
+	if _, ok := h["title"]; !ok {
+		h["title"] = g.xTitle()
+	}
+	opt["hAxis"] = h
+	v := make(map[string]any)
+	if m, ok := opt["vAxis"].(map[string]any); ok {
+		v = maps.Clone(m)
+	}
+	if _, ok := v["title"]; !ok {
+		v["title"] = d
+	}
+	if g.LogScale {
+		v["scaleType"] = "log"
+	}
+	opt["vAxis"] = v
+	return
+}
@@ -7,8 +7,11 @@ import (
 	"context"
 	_ "embed"
 	"fmt"
+	"maps"
+	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"unicode"
 
@@ -25,11 +28,64 @@ var chartsTemplate string
 
 // chartsTemplateData contains the data for chartsTemplate execution.
 type chartsTemplateData struct {
-	Class   template.JS
-	Data    chartsData
-	Options map[string]any
-	Stream  []StreamAnalysis
-	Packet  []PacketAnalysis
+	Class     template.JS
+	Data      chartsData
+	Options   map[string]any
+	Stream    []StreamAnalysis
+	Packet    []PacketAnalysis
+	RPC       []RPCAnalysis
+	FlowFile  map[node.Flow]string
+	DataTable bool
+}
+
+// Anchor returns the fragment ID for the table row of the given kind (e.g.
+// "stream") and Flow.
+func (d chartsTemplateData) Anchor(kind string, flow node.Flow) string {
+	return flowAnchor(kind, flow)
+}
+
+// FlowLink returns the Flow, linked to its separate file if there is one.
+func (d chartsTemplateData) FlowLink(flow node.Flow) template.HTML {
+	s := template.HTMLEscapeString(string(flow))
+	f, ok := d.FlowFile[flow]
+	if !ok {
+		return template.HTML(s)
+	}
+	return template.HTML(fmt.Sprintf(`<a href="%s">%s</a>`,
+		template.HTMLEscapeString(f), s))
+}
+
+// DSCP returns true if there are DSCP stats for any packet flow.
+func (d chartsTemplateData) DSCP() bool {
+	for _, p := range d.Packet {
+		if len(p.DSCP) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Delays returns true if there are delay distributions for any packet flow.
+func (d chartsTemplateData) Delays() bool {
+	for _, p := range d.Packet {
+		if len(p.DelayDists()) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Percentiles returns the delay percentiles calculated for the packet flows.
+func (d chartsTemplateData) Percentiles() (pp []float64) {
+	for _, p := range d.Packet {
+		for _, n := range p.DelayDists() {
+			for _, c := range n.Percentile {
+				pp = append(pp, c.P)
+			}
+			return
+		}
+	}
+	return
 }
 
 // ChartsTimeSeries is a reporter that makes time series plots using Google
@@ -38,31 +94,54 @@ type ChartsTimeSeries struct {
 	// FlowLabel sets custom labels for Flows.
 	FlowLabel map[node.Flow]string
 
+	// FlowLabelMeta, if true, appends a summary of each Flow's metadata to
+	// its label, for Flows without a label in FlowLabel.
+	FlowLabelMeta bool
+
 	// To lists the names of files to execute the template to. A file of "-"
 	// emits to stdout.
 	To []string
 
+	// FlowTo, if not empty, is the name of a separate file to execute the
+	// template to for each Flow, with only that Flow's series and stats. The
+	// verb %s is replaced by a slug of the Flow, made by flowSlug.
+	FlowTo string
+
+	// MaxPoints, if positive, is the maximum number of points plotted for each
+	// series. Longer series are downsampled by plotting every nth point, which
+	// keeps the size of the output manageable for long Tests.
+	MaxPoints int
+
+	// DataTable, if true, includes a collapsible table of the plotted data in
+	// the HTML, and a button to download it as CSV.
+	DataTable bool
+
 	// Options is an arbitrary structure of Charts options, with defaults
 	// defined in config.cue.
 	// https://developers.google.com/chart/interactive/docs/gallery/linechart#configuration-options
 	Options map[string]any
+
+	// theme is the Theme set from the Config.
+	theme Theme
+}
+
+// setTheme implements themer
+func (g *ChartsTimeSeries) setTheme(t Theme) {
+	g.theme = t
 }
 
 // report implements reporter
 func (g *ChartsTimeSeries) report(ctx context.Context, rw rwer, in <-chan any,
 	out chan<- any) (err error) {
-	t := template.New("Style")
-	if t, err = t.Parse(styleTemplate); err != nil {
+	var t *template.Template
+	if t, err = newStyleTemplate(g.theme); err != nil {
 		return
 	}
 	t = t.New("ChartsTimeSeries")
+	var f *flowRegistry
 	t = t.Funcs(template.FuncMap{
-		"flowLabel": func(flow node.Flow) (label string) {
-			label, ok := g.FlowLabel[flow]
-			if !ok {
-				return string(flow)
-			}
-			return label
+		"flowLabel": func(flow node.Flow) string {
+			return f.Label(flow, g.FlowLabel, g.FlowLabelMeta)
 		},
 	})
 	if t, err = t.Parse(chartsTemplate); err != nil {
@@ -76,17 +155,58 @@ func (g *ChartsTimeSeries) report(ctx context.Context, rw rwer, in <-chan any,
 			a = v
 		}
 	}
-	td := chartsTemplateData{
-		"google.visualization.LineChart",
-		g.data(a.streams.byTime(), a.packets.byTime()),
-		g.Options,
-		a.streams.byTime(),
-		a.packets.byTime(),
+	if f = a.flows; f == nil {
+		f = newFlowRegistry(nil, g.theme.palette())
+	}
+	san, pan, ran := a.streamList(), a.packetList(), a.rpcList()
+	var ff map[node.Flow]string
+	if g.FlowTo != "" {
+		ff = make(map[node.Flow]string)
+		for _, l := range chartsFlows(san, pan, ran) {
+			if err = context.Cause(ctx); err != nil {
+				return
+			}
+			w := rw.Writer(fmt.Sprintf(g.FlowTo, flowSlug(l)))
+			ff[l] = filepath.Base(w.Path)
+			s, p, r := chartsFilter(l, san, pan, ran)
+			if err = g.execute(ctx, t, []io.WriteCloser{w}, f, s, p, r, nil,
+				f.Label(l, g.FlowLabel, g.FlowLabelMeta)); err != nil {
+				return
+			}
+		}
 	}
 	var ww []io.WriteCloser
 	for _, to := range g.To {
 		ww = append(ww, rw.Writer(to))
 	}
+	err = g.execute(ctx, t, ww, f, san, pan, ran, ff, "")
+	return
+}
+
+// execute executes the template to the given WriteClosers, and closes them.
+// If label is not empty, it's appended to the chart title.
+func (g *ChartsTimeSeries) execute(ctx context.Context, t *template.Template,
+	ww []io.WriteCloser, reg *flowRegistry, san []StreamAnalysis,
+	pan []PacketAnalysis, ran []RPCAnalysis, flowFile map[node.Flow]string,
+	label string) (err error) {
+	dt, cc := g.data(reg, san, pan, ran)
+	td := chartsTemplateData{
+		"google.visualization.LineChart",
+		dt,
+		cc.options(g.Options, reg),
+		san,
+		pan,
+		ran,
+		flowFile,
+		g.DataTable,
+	}
+	if label != "" {
+		l := label
+		if t, ok := td.Options["title"].(string); ok && t != "" {
+			l = fmt.Sprintf("%s: %s", t, label)
+		}
+		td.Options["title"] = l
+	}
 	defer func() {
 		for _, w := range ww {
 			if e := w.Close(); e != nil && err == nil {
@@ -94,24 +214,93 @@ func (g *ChartsTimeSeries) report(ctx context.Context, rw rwer, in <-chan any,
 			}
 		}
 	}()
-	err = t.Execute(multiWriteCloser(ww...), td)
+	err = t.Execute(multiWriteCloser(ctx, ww...), td)
+	return
+}
+
+// chartsFlows returns the unique Flows in the given analyses, in order.
+func chartsFlows(san []StreamAnalysis, pan []PacketAnalysis,
+	ran []RPCAnalysis) (flows []node.Flow) {
+	m := make(map[node.Flow]struct{})
+	add := func(flow node.Flow) {
+		if _, ok := m[flow]; !ok {
+			m[flow] = struct{}{}
+			flows = append(flows, flow)
+		}
+	}
+	for _, s := range san {
+		add(s.Flow)
+	}
+	for _, p := range pan {
+		add(p.Flow)
+	}
+	for _, r := range ran {
+		add(r.Flow)
+	}
 	return
 }
 
+// chartsFilter returns the analyses for the given Flow.
+func chartsFilter(flow node.Flow, san []StreamAnalysis, pan []PacketAnalysis,
+	ran []RPCAnalysis) (s []StreamAnalysis, p []PacketAnalysis,
+	r []RPCAnalysis) {
+	for _, a := range san {
+		if a.Flow == flow {
+			s = append(s, a)
+		}
+	}
+	for _, a := range pan {
+		if a.Flow == flow {
+			p = append(p, a)
+		}
+	}
+	for _, a := range ran {
+		if a.Flow == flow {
+			r = append(r, a)
+		}
+	}
+	return
+}
+
+// flowAnchor returns a stable fragment ID for the given kind of element (e.g.
+// "stream") and Flow.
+func flowAnchor(kind string, flow node.Flow) string {
+	return kind + "-" + flowSlug(flow)
+}
+
+// flowSlug returns a stable, URL safe form of the Flow, for use in file names
+// and fragment IDs. Letters and numbers are lowercased, and runs of any other
+// characters are replaced with a single hyphen.
+func flowSlug(flow node.Flow) string {
+	var b strings.Builder
+	h := false
+	for _, r := range string(flow) {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			b.WriteRune(unicode.ToLower(r))
+			h = false
+		} else if !h && b.Len() > 0 {
+			b.WriteByte('-')
+			h = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
 // data returns the chart data.
-func (g *ChartsTimeSeries) data(san []StreamAnalysis, pan []PacketAnalysis) (
-	data chartsData) {
+func (g *ChartsTimeSeries) data(reg *flowRegistry, san []StreamAnalysis,
+	pan []PacketAnalysis, ran []RPCAnalysis) (data chartsData,
+	cols chartsColumns) {
 	data.set(0, 0, "Time (sec)")
 	col := 1
 	row := 1
 	for _, d := range san {
-		l := string(d.Client.Flow)
-		if ll, ok := g.FlowLabel[d.Client.Flow]; ok {
-			l = ll
-		}
+		l := reg.Label(d.Flow, g.FlowLabel, g.FlowLabelMeta)
 		if len(d.GoodputPoint) > 1 {
+			cols.add(d.Flow, nil)
 			data.set(0, col, fmt.Sprintf("%s goodput", l))
-			for _, g := range d.GoodputPoint {
+			n := chartsStride(len(d.GoodputPoint), g.MaxPoints)
+			for i := 0; i < len(d.GoodputPoint); i += n {
+				g := d.GoodputPoint[i]
 				data.set(row, 0, g.T.Duration().Seconds())
 				data.set(row, col, g.Goodput.Mbps())
 				row++
@@ -119,8 +308,11 @@ func (g *ChartsTimeSeries) data(san []StreamAnalysis, pan []PacketAnalysis) (
 			col++
 		}
 		if len(d.TCPInfo) > 0 {
+			cols.add(d.Flow, dashDeliveryRate)
 			data.set(0, col, fmt.Sprintf("%s delivery rate", l))
-			for _, t := range d.TCPInfo {
+			n := chartsStride(len(d.TCPInfo), g.MaxPoints)
+			for i := 0; i < len(d.TCPInfo); i += n {
+				t := d.TCPInfo[i]
 				data.set(row, 0, t.T.Duration().Seconds())
 				data.set(row, col, t.DeliveryRate.Mbps())
 				row++
@@ -128,8 +320,11 @@ func (g *ChartsTimeSeries) data(san []StreamAnalysis, pan []PacketAnalysis) (
 			col++
 		}
 		if len(d.TCPInfo) > 0 {
+			cols.add(d.Flow, dashRTT)
 			data.set(0, col, fmt.Sprintf("%s TCP RTT", l))
-			for _, t := range d.TCPInfo {
+			n := chartsStride(len(d.TCPInfo), g.MaxPoints)
+			for i := 0; i < len(d.TCPInfo); i += n {
+				t := d.TCPInfo[i]
 				data.set(row, 0, t.T.Duration().Seconds())
 				data.set(row, col, t.RTT.Seconds()*1000.0)
 				row++
@@ -138,13 +333,13 @@ func (g *ChartsTimeSeries) data(san []StreamAnalysis, pan []PacketAnalysis) (
 		}
 	}
 	for _, d := range pan {
-		l := string(d.Client.Flow)
-		if ll, ok := g.FlowLabel[d.Client.Flow]; ok {
-			l = ll
-		}
+		l := reg.Label(d.Flow, g.FlowLabel, g.FlowLabelMeta)
 		if len(d.Up.OWD) > 0 {
+			cols.add(d.Flow, nil)
 			data.set(0, col, fmt.Sprintf("%s OWD up", l))
-			for _, o := range d.Up.OWD {
+			n := chartsStride(len(d.Up.OWD), g.MaxPoints)
+			for i := 0; i < len(d.Up.OWD); i += n {
+				o := d.Up.OWD[i]
 				data.set(row, 0, o.T.Duration().Seconds())
 				data.set(row, col, o.Delay.Seconds()*1000.0)
 				row++
@@ -152,10 +347,97 @@ func (g *ChartsTimeSeries) data(san []StreamAnalysis, pan []PacketAnalysis) (
 			col++
 		}
 	}
+	for _, d := range ran {
+		l := reg.Label(d.Flow, g.FlowLabel, g.FlowLabelMeta)
+		if len(d.Transaction) > 0 {
+			cols.add(d.Flow, nil)
+			data.set(0, col, fmt.Sprintf("%s RPC latency", l))
+			n := chartsStride(len(d.Transaction), g.MaxPoints)
+			for i := 0; i < len(d.Transaction); i += n {
+				x := d.Transaction[i]
+				data.set(row, 0, x.T.Duration().Seconds())
+				data.set(row, col, x.Latency.Seconds()*1000.0)
+				row++
+			}
+			col++
+		}
+	}
 	data.normalize()
 	return
 }
 
+// chartsStride returns the step between plotted points for a series of length
+// n, such that at most max points are plotted. If max is not positive, all
+// points are plotted.
+func chartsStride(n, max int) int {
+	if max <= 0 || n <= max {
+		return 1
+	}
+	return (n + max - 1) / max
+}
+
+// Line dash styles for secondary series of the same Flow.
+var (
+	dashDeliveryRate = []int{6, 3}
+	dashRTT          = []int{2, 2}
+)
+
+// chartsPanel contains the data and options for one of multiple charts in a
+// report, drawn in the element with the given ID.
+type chartsPanel struct {
+	ID      string
+	Data    chartsData
+	Options map[string]any
+}
+
+// chartsColumns records the Flow and line dash style for each data column in a
+// chart, excluding the domain column.
+type chartsColumns []chartsColumn
+
+// chartsColumn contains the Flow and line dash style for one data column.
+type chartsColumn struct {
+	Flow node.Flow
+	Dash []int
+}
+
+// add adds a column.
+func (c *chartsColumns) add(flow node.Flow, dash []int) {
+	*c = append(*c, chartsColumn{flow, dash})
+}
+
+// options returns a copy of the given Charts options, with the series color
+// and line dash style set for each column from the Flow registry, unless a
+// color was already configured for that series.
+func (c chartsColumns) options(options map[string]any, reg *flowRegistry) (
+	opt map[string]any) {
+	opt = maps.Clone(options)
+	if opt == nil {
+		opt = make(map[string]any)
+	}
+	ss := make(map[string]any)
+	if s, ok := opt["series"].(map[string]any); ok {
+		maps.Copy(ss, s)
+	}
+	for i, l := range c {
+		k := strconv.Itoa(i)
+		var m map[string]any
+		if s, ok := ss[k].(map[string]any); ok {
+			m = maps.Clone(s)
+		} else {
+			m = make(map[string]any)
+		}
+		if _, ok := m["color"]; !ok {
+			m["color"] = reg.Info(l.Flow).Color()
+			if _, ok := m["lineDashStyle"]; !ok && l.Dash != nil {
+				m["lineDashStyle"] = l.Dash
+			}
+		}
+		ss[k] = m
+	}
+	opt["series"] = ss
+	return
+}
+
 // ChartsFCT is a reporter that makes time series plots using Google Charts.
 type ChartsFCT struct {
 	// To lists the names of files to execute the template to. A file of "-"
@@ -165,17 +447,29 @@ type ChartsFCT struct {
 	// Series matches Flows to series.
 	Series []FlowSeries
 
+	// DataTable, if true, includes a collapsible table of the plotted data in
+	// the HTML, and a button to download it as CSV.
+	DataTable bool
+
 	// Options is an arbitrary structure of Charts options, with defaults
 	// defined in config.cue.
 	// https://developers.google.com/chart/interactive/docs/gallery/scatterchart#configuration-options
 	Options map[string]any
+
+	// theme is the Theme set from the Config.
+	theme Theme
+}
+
+// setTheme implements themer
+func (g *ChartsFCT) setTheme(t Theme) {
+	g.theme = t
 }
 
 // report implements reporter
 func (g *ChartsFCT) report(ctx context.Context, rw rwer, in <-chan any,
 	out chan<- any) (err error) {
-	t := template.New("Style")
-	if t, err = t.Parse(styleTemplate); err != nil {
+	var t *template.Template
+	if t, err = newStyleTemplate(g.theme); err != nil {
 		return
 	}
 	t = t.New("ChartsFCT")
@@ -209,8 +503,11 @@ func (g *ChartsFCT) report(ctx context.Context, rw rwer, in <-chan any,
 		"google.visualization.ScatterChart",
 		g.data(a.streams.byTime()),
 		g.Options,
-		a.streams.byTime(),
-		a.packets.byTime(),
+		a.streamList(),
+		a.packetList(),
+		a.rpcList(),
+		nil,
+		g.DataTable,
 	}
 	var ww []io.WriteCloser
 	for _, to := range g.To {
@@ -223,7 +520,7 @@ func (g *ChartsFCT) report(ctx context.Context, rw rwer, in <-chan any,
 			}
 		}
 	}()
-	err = t.Execute(multiWriteCloser(ww...), td)
+	err = t.Execute(multiWriteCloser(ctx, ww...), td)
 	return
 }
 
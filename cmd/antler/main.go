@@ -4,36 +4,69 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"os"
 	"os/signal"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"text/tabwriter"
+	"time"
 
 	"cuelang.org/go/cue/errors"
 	"cuelang.org/go/cue/load"
 	"github.com/heistp/antler"
+	"github.com/heistp/antler/node"
 	"github.com/heistp/antler/version"
 	"github.com/spf13/cobra"
 )
 
+// canceled is set when a command is canceled by a signal, so that the exit code
+// is ExitCanceled.
+var canceled atomic.Bool
+
 // root returns the root cobra command.
-func root() (cmd *cobra.Command) {
+func root(prof *profiler) (cmd *cobra.Command) {
 	cmd = &cobra.Command{
-		Use:           "antler",
-		Short:         "Active Network Tester of Load et Response",
+		Use:   "antler",
+		Short: "Active Network Tester of Load et Response",
+		Long: `Antler is the Active Network Tester of Load et Response.
+
+The exit code reflects the class of outcome, so automation may branch on it:
+
+  0  success
+  1  an error not in one of the classes below
+  2  invalid config or command line
+  3  environment error (missing prerequisites, or unusable results directory)
+  4  a test failed to run
+  5  a report failed
+  6  canceled by a signal
+  7  a test with ExpectFail set did not meet its expectations`,
 		SilenceUsage:  true,
 		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return prof.start()
+		},
 	}
+	prof.flags(cmd)
+	cmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return antler.ExitError{Code: antler.ExitConfig, Err: err}
+	})
 	cmd.AddCommand(initCmd())
 	cmd.AddCommand(vet())
 	cmd.AddCommand(list())
 	cmd.AddCommand(run())
+	cmd.AddCommand(sweep())
 	cmd.AddCommand(report())
 	cmd.AddCommand(server())
+	cmd.AddCommand(search())
+	cmd.AddCommand(compare())
+	cmd.AddCommand(selftest())
+	cmd.AddCommand(selfcheck())
 	cmd.Version = version.Version()
 	return
 }
@@ -127,9 +160,39 @@ func run() (cmd *cobra.Command) {
 		Linked: func(test *antler.Test) {
 			fmt.Printf("linked %s\n", test.ID)
 		},
+		Blocked: func(test *antler.Test, dependency antler.TestID) {
+			fmt.Printf("skipped %s, dependency %s did not complete\n",
+				test.ID, dependency)
+		},
+		Unfed: func(test *antler.Test, key string) {
+			fmt.Printf("skipped %s, feedback '%s' was not exported\n",
+				test.ID, key)
+		},
+		Windowed: func(decision antler.WindowDecision) {
+			fmt.Println(decision)
+		},
+		Preflight: func(tests []*antler.Test) {
+			if len(tests) > 0 {
+				fmt.Printf("checking prerequisites for %d tests...\n",
+					len(tests))
+			}
+		},
+		Expected: printExpectResult,
+		Copying:  printCopyProgress(),
+		CollectFailed: func(err error) {
+			fmt.Fprintf(os.Stderr, "unable to stream results to collector, "+
+				"will retry: %s\n", err)
+		},
+		LiveFailed: func(err error) {
+			fmt.Fprintf(os.Stderr, "unable to send live progress to server, "+
+				"will retry: %s\n", err)
+		},
 		Done: func(info antler.RunInfo) {
 			fmt.Printf("ran %d tests, linked %d, elapsed %s\n",
 				info.Ran, info.Linked, info.Elapsed)
+			if info.Unmet > 0 {
+				fmt.Printf("%d tests did not meet expectations\n", info.Unmet)
+			}
 			if info.ResultDir == "" {
 				fmt.Printf("no tests run or no changes made, result not saved\n")
 			} else {
@@ -137,19 +200,24 @@ func run() (cmd *cobra.Command) {
 			}
 		},
 	}
-	var a bool
+	var a, s, g bool
 	cmd = &cobra.Command{
 		Use:   "run [filter] ...",
 		Short: "Runs tests and reports",
 		Long: help(`Run runs tests and reports.
 
 {{template "filter" "run"}}
+
+With --debug, the filter must match a single test. After it completes, its
+nodes and network namespaces are kept running, and the information needed to
+attach to them is printed. The nodes are torn down when Enter is pressed.
 `),
 		RunE: func(cmd *cobra.Command, args []string) (err error) {
 			c, x := context.WithCancelCause(context.Background())
 			defer x(nil)
 			if a && len(args) > 0 {
-				err = errors.New("-a/--all not compatible with arguments")
+				err = antler.ExitError{Code: antler.ExitConfig,
+					Err: errors.New("-a/--all not compatible with arguments")}
 				return
 			}
 			if len(args) > 0 {
@@ -160,12 +228,19 @@ func run() (cmd *cobra.Command) {
 			if a {
 				r.Filter = antler.BoolFilter(true)
 			}
+			if s {
+				r.PipelineStats = printPipelineStats
+			}
+			if g {
+				r.Hold = debugHold
+			}
 			sc := make(chan os.Signal, 1)
 			signal.Notify(sc, os.Interrupt, syscall.SIGTERM)
 			go func() {
 				s := <-sc
 				fmt.Fprintf(os.Stderr,
 					"%s, canceling (one more to terminate)\n", s)
+				canceled.Store(true)
 				x(errors.New(s.String()))
 				s = <-sc
 				fmt.Fprintf(os.Stderr, "%s, exiting forcibly\n", s)
@@ -177,24 +252,154 @@ func run() (cmd *cobra.Command) {
 	}
 	cmd.Flags().BoolVarP(&a, "all", "a", false,
 		"runs all tests (may not be used with filter args)")
+	cmd.Flags().BoolVar(&r.CheckPrereqs, "preflight", false,
+		"checks for prerequisites on each node before running tests")
+	cmd.Flags().BoolVar(&r.NoCache, "no-cache", false,
+		"runs reports even if their inputs are unchanged")
+	cmd.Flags().IntVarP(&r.ReportJobs, "report-jobs", "j", 0,
+		"defers reports until all tests have run, then runs up to this "+
+			"many concurrently")
+	cmd.Flags().BoolVar(&s, "pipeline-stats", false,
+		"prints throughput and queue stats for each report pipeline stage")
+	cmd.Flags().BoolVar(&g, "debug", false,
+		"runs a single test, and keeps its nodes running until Enter is "+
+			"pressed")
 	return
 }
 
+// debugHold prints how to attach to the nodes of a test that's run in debug
+// mode, then waits for Enter to be pressed, or the Context to be done.
+func debugHold(ctx context.Context, test *antler.Test,
+	proc []node.NodeProcess) {
+	fmt.Printf("%s done, nodes kept running for debugging:\n", test.ID)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "  NODE\tHOST\tPID\tNETNS\n")
+	for _, p := range proc {
+		a := "(default namespace)"
+		if p.Netns != "" {
+			a = fmt.Sprintf("%s (ip netns exec %s $SHELL)", p.Netns, p.Netns)
+		}
+		fmt.Fprintf(w, "  %s\t%s\t%d\t%s\n", p.NodeID, p.Host, p.PID, a)
+	}
+	w.Flush()
+	fmt.Printf("press Enter to tear down...")
+	k := make(chan struct{})
+	go func() {
+		bufio.NewReader(os.Stdin).ReadString('\n')
+		close(k)
+	}()
+	select {
+	case <-k:
+	case <-ctx.Done():
+		fmt.Println()
+	}
+	fmt.Printf("tearing down nodes\n")
+}
+
+// sweep returns the sweep cobra command.
+func sweep() (cmd *cobra.Command) {
+	s := &antler.SweepCommand{
+		Run: antler.RunCommand{
+			Running: func(test *antler.Test) {
+				fmt.Printf("running %s...\n", test.ID)
+			},
+			Expected: printExpectResult,
+			Copying:  printCopyProgress(),
+		},
+		Done: func(result antler.SweepResult) {
+			fmt.Printf("ran %d steps, elapsed %s\n", len(result.Step),
+				time.Since(result.Start).Round(time.Second))
+			if result.Found {
+				fmt.Printf("knee for %s is between %s=%g (met) and %s=%g "+
+					"(not met)\n", result.Cond, result.Tag, result.Met,
+					result.Tag, result.Unmet)
+			} else if len(result.Step) > 2 {
+				fmt.Printf("knee not found within resolution, last interval "+
+					"%s=%g (met) to %s=%g (not met)\n", result.Tag,
+					result.Met, result.Tag, result.Unmet)
+			}
+		},
+	}
+	s.Step = func(result antler.SweepResult) {
+		p := result.Step[len(result.Step)-1]
+		m := "met"
+		if !p.Met {
+			m = "not met"
+		}
+		fmt.Printf("step %d, %s=%g: %s=%g, %s %s\n", len(result.Step),
+			result.Tag, p.Value, result.Cond.Name, p.Metric, result.Cond, m)
+	}
+	cmd = &cobra.Command{
+		Use:   "sweep name",
+		Short: "Searches for the knee in a parameter using bisection",
+		Long: `Sweep runs a Test repeatedly, bisecting a parameter injected with a CUE
+tag (e.g. a shaper rate or flow count), to find the knee where a metric
+condition stops being met. Sweeps are configured by name in the Sweep field of
+the config. The trajectory of the search is written to sweep_<name>.json in the
+results directory after each step.
+`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			c, x := context.WithCancelCause(context.Background())
+			defer x(nil)
+			s.Name = args[0]
+			sc := make(chan os.Signal, 1)
+			signal.Notify(sc, os.Interrupt, syscall.SIGTERM)
+			go func() {
+				s := <-sc
+				fmt.Fprintf(os.Stderr,
+					"%s, canceling (one more to terminate)\n", s)
+				canceled.Store(true)
+				x(errors.New(s.String()))
+				s = <-sc
+				fmt.Fprintf(os.Stderr, "%s, exiting forcibly\n", s)
+				os.Exit(-1)
+			}()
+			err = antler.Run(c, s)
+			return
+		},
+	}
+	cmd.Flags().BoolVar(&s.Run.CheckPrereqs, "preflight", false,
+		"checks for prerequisites on each node before running tests")
+	return
+}
+
+// printExpectResult prints the result of a Test's Expect conditions.
+func printExpectResult(test *antler.Test, result antler.ExpectResult) {
+	if result.Pass {
+		fmt.Printf("%s met expectations\n", test.ID)
+		return
+	}
+	for _, c := range result.Cond {
+		if !c.Pass {
+			fmt.Printf("%s expected %s\n", test.ID, c)
+		}
+	}
+}
+
 // report returns the report cobra command.
 func report() (cmd *cobra.Command) {
 	r := &antler.ReportCommand{
 		Reporting: func(test *antler.Test) {
 			fmt.Printf("reporting on %s...\n", test.ID)
 		},
+		Cached: func(test *antler.Test) {
+			fmt.Printf("linked unchanged reports for %s\n", test.ID)
+		},
 		DataFileUnset: func(test *antler.Test) {
 			fmt.Printf("skipping %s, DataFile field is empty\n", test.ID)
 		},
 		NotFound: func(test *antler.Test, name string) {
 			fmt.Printf("skipping %s, '%s' not found\n", test.ID, name)
 		},
+		Expected: printExpectResult,
+		Copying:  printCopyProgress(),
 		Done: func(info antler.ReportInfo) {
 			fmt.Printf("reported on %d tests, elapsed %s\n",
 				info.Reported, info.Elapsed)
+			if info.Unmet > 0 {
+				fmt.Printf("%d tests did not meet expectations\n", info.Unmet)
+			}
 			if info.ResultDir == "" {
 				fmt.Printf("no changes made, result not saved\n")
 			} else {
@@ -202,16 +407,44 @@ func report() (cmd *cobra.Command) {
 			}
 		},
 	}
-	return &cobra.Command{
+	var s bool
+	cmd = &cobra.Command{
 		Use:   "report",
 		Short: "Re-runs reports using existing data files",
 		RunE: func(cmd *cobra.Command, args []string) (err error) {
 			c, x := context.WithCancelCause(context.Background())
 			defer x(nil)
+			if s {
+				r.PipelineStats = printPipelineStats
+			}
 			err = antler.Run(c, r)
 			return
 		},
 	}
+	cmd.Flags().BoolVar(&r.NoCache, "no-cache", false,
+		"runs reports even if their inputs are unchanged")
+	cmd.Flags().BoolVar(&s, "pipeline-stats", false,
+		"prints throughput and queue stats for each report pipeline stage")
+	return
+}
+
+// printPipelineStats prints the stats for a report pipeline.
+func printPipelineStats(stats antler.PipelineStats) {
+	fmt.Print(stats)
+}
+
+// printCopyProgress returns a func that prints the progress of copying the
+// result directory, at most once per second.
+func printCopyProgress() func(antler.CopyProgress) {
+	var t time.Time
+	return func(p antler.CopyProgress) {
+		if p.Copied < p.Total && time.Since(t) < time.Second {
+			return
+		}
+		t = time.Now()
+		fmt.Printf("copying result to '%s': %s of %s\n", p.To,
+			p.Copied.SI(), p.Total.SI())
+	}
 }
 
 // server returns the server cobra command.
@@ -229,6 +462,7 @@ func server() (cmd *cobra.Command) {
 				s := <-sc
 				fmt.Fprintf(os.Stderr,
 					"%s, shutting down (one more to terminate)\n", s)
+				canceled.Store(true)
 				x(errors.New(s.String()))
 				s = <-sc
 				fmt.Fprintf(os.Stderr, "%s, exiting forcibly\n", s)
@@ -240,12 +474,217 @@ func server() (cmd *cobra.Command) {
 	}
 }
 
+// search returns the search cobra command.
+func search() (cmd *cobra.Command) {
+	var m []string
+	cmd = &cobra.Command{
+		Use:   "search [filter|condition] ...",
+		Short: "Searches the catalog of results",
+		Long: help(`Search searches the catalog of results by Test ID and metrics.
+
+Each argument is either a filter, or a metric condition in the form
+name<op>value, where op compares the metric to the value, and is one of less
+than, less than or equal, greater than, or greater than or equal (e.g.
+'owd_p95_ms>=20'). Metrics are written by the EmitMetrics report. Tests must
+match all filters and conditions. The metrics in conditions, and those listed
+with --metric, are shown for each Test.
+
+{{template "filter" "search"}}
+Example 3: antler search cca=bbr2 'owd_p95_ms>20'
+`),
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			s := &antler.SearchCommand{}
+			if s.Query, err = antler.NewCatalogQueryArgs(args); err != nil {
+				err = antler.ExitError{Code: antler.ExitConfig, Err: err}
+				return
+			}
+			var n []string
+			for _, c := range s.Query.Metric {
+				n = append(n, c.Name)
+			}
+			m = append(n, m...)
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprint(w, "Result\tTest ID\tPath")
+			for _, n := range m {
+				fmt.Fprintf(w, "\t%s", n)
+			}
+			fmt.Fprintln(w)
+			s.Result = func(r antler.CatalogResult) {
+				fmt.Fprintf(w, "%s\t%s\t%s", r.Run, r.ID, r.Path)
+				for _, n := range m {
+					if v, ok := r.Metrics[n]; ok {
+						fmt.Fprintf(w, "\t%.3f", v)
+					} else {
+						fmt.Fprint(w, "\t-")
+					}
+				}
+				fmt.Fprintln(w)
+			}
+			if err = antler.Run(context.Background(), s); err != nil {
+				return
+			}
+			w.Flush()
+			return
+		},
+	}
+	cmd.Flags().StringSliceVarP(&m, "metric", "m", nil,
+		"additional metrics to show for each Test")
+	return
+}
+
+// compare returns the compare cobra command.
+func compare() (cmd *cobra.Command) {
+	c := &antler.CompareCommand{}
+	var j bool
+	cmd = &cobra.Command{
+		Use:   "compare resultA resultB",
+		Short: "Compares the Tests in two results",
+		Long: `Compare analyzes the data for each Test in two result directories, matches
+the Tests by ID, and shows the differences in their flows' metrics, including
+goodput, FCT and one-way delay, with the percent change from resultA to
+resultB, and the p-value of the Mann-Whitney U test, where the metric has
+samples to test. Significant differences are marked with an asterisk.
+
+Each result is either the path to a result directory, or its name under
+Results.RootDir. Tests are those in the current config.
+
+Example: antler compare 2024-05-01-120000Z results/latest
+`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			c.A, c.B = args[0], args[1]
+			c.Missing = func(test *antler.Test, result string) {
+				fmt.Fprintf(os.Stderr, "no data for %s in '%s'\n", test.ID,
+					result)
+			}
+			var cc []antler.Comparison
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ',
+				tabwriter.AlignRight)
+			c.Compared = func(r antler.Comparison) {
+				if j {
+					cc = append(cc, r)
+					return
+				}
+				fmt.Fprintf(w, "%s\n", r.ID)
+				fmt.Fprint(w, "Flow\tDir\tMetric\tA\tB\tDelta\tChange\tp\t\t\n")
+				for _, m := range r.Metric {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%.3f\t%.3f\t%+.3f\t", m.Flow,
+						m.Direction, m.Name, m.A, m.B, m.Delta)
+					if m.Change != nil {
+						fmt.Fprintf(w, "%+.1f%%\t", *m.Change)
+					} else {
+						fmt.Fprint(w, "-\t")
+					}
+					if m.P != nil {
+						fmt.Fprintf(w, "%.4f\t", *m.P)
+					} else {
+						fmt.Fprint(w, "-\t")
+					}
+					if m.Significant {
+						fmt.Fprint(w, "*")
+					}
+					fmt.Fprint(w, "\t\n")
+				}
+				fmt.Fprintln(w)
+			}
+			if err = antler.Run(context.Background(), c); err != nil {
+				return
+			}
+			if j {
+				if cc == nil {
+					cc = []antler.Comparison{}
+				}
+				e := json.NewEncoder(os.Stdout)
+				e.SetIndent("", "  ")
+				err = e.Encode(cc)
+				return
+			}
+			w.Flush()
+			return
+		},
+	}
+	cmd.Flags().Float64VarP(&c.Alpha, "alpha", "a", 0.05,
+		"significance level for the Mann-Whitney U test")
+	cmd.Flags().BoolVarP(&j, "json", "j", false, "emit the comparison as JSON")
+	return
+}
+
+// selftest returns the selftest cobra command.
+func selftest() (cmd *cobra.Command) {
+	s := &antler.SelftestCommand{
+		Result: func(r node.BenchmarkResult) {
+			fmt.Println(r)
+		},
+	}
+	cmd = &cobra.Command{
+		Use:   "selftest [pattern]",
+		Short: "Benchmarks the traffic generator on this host",
+		Long: `Selftest runs benchmarks of the node's hot paths, including packet encoding,
+UDP packet sends and TCP transfers over loopback, and node message throughput,
+and reports the achievable rates on this host. These may be compared with the
+link speeds under test, to know the traffic generator's limits.
+
+If a pattern is given, only the benchmarks whose names match the regular
+expression are run.
+`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			if len(args) > 0 {
+				s.Pattern = args[0]
+			}
+			err = antler.Run(context.Background(), s)
+			return
+		},
+	}
+	return
+}
+
+// selfcheck returns the selfcheck cobra command.
+func selfcheck() (cmd *cobra.Command) {
+	s := &antler.SelfcheckCommand{
+		Checking: func(name string) {
+			fmt.Printf("checking %s... ", name)
+		},
+		Checked: func(name string, err error) {
+			if err != nil {
+				fmt.Printf("FAIL\n")
+				return
+			}
+			fmt.Printf("ok\n")
+		},
+		Done: func(dir string) {
+			if dir != "" {
+				fmt.Printf("selfcheck directory kept at: '%s'\n", dir)
+			}
+		},
+	}
+	cmd = &cobra.Command{
+		Use:   "selfcheck",
+		Short: "Checks the environment by running a built-in test",
+		Long: `Selfcheck runs a minimal built-in test in a temporary directory, with one
+stream and one packet flow between a pair of network namespaces, then verifies
+the results and report generation. Root is required. If a check fails, the
+temporary directory is kept for inspection.
+`,
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			err = antler.Run(context.Background(), s)
+			return
+		},
+	}
+	cmd.Flags().IntVarP(&s.Duration, "duration", "d", 3,
+		"duration of the test flows, in seconds")
+	cmd.Flags().BoolVarP(&s.Keep, "keep", "k", false,
+		"keeps the temporary directory, even if all checks pass")
+	return
+}
+
 // newRegexFilter returns a TestFilter that's a logical and of the given
 // regex filters.
 func newRegexFilter(args []string) (flt antler.AndFilter, err error) {
 	for _, a := range args {
 		var f antler.TestFilter
 		if f, err = antler.NewRegexFilterArg(a); err != nil {
+			err = antler.ExitError{Code: antler.ExitConfig, Err: err}
 			return
 		}
 		flt = append(flt, f)
@@ -281,12 +720,22 @@ func help(text string) string {
 
 // main executes the antler command.
 func main() {
-	if err := root().Execute(); err != nil {
+	p := &profiler{}
+	err := root(p).Execute()
+	if e := p.stop(); e != nil && err == nil {
+		err = e
+	}
+	if err != nil {
 		s := err.Error()
-		if ce, ok := err.(errors.Error); ok {
+		var ce errors.Error
+		if errors.As(err, &ce) {
 			s = errors.Details(ce, nil)
 		}
 		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], s)
-		os.Exit(1)
+		c := antler.ExitCodeOf(err)
+		if canceled.Load() {
+			c = antler.ExitCanceled
+		}
+		os.Exit(int(c))
 	}
 }
@@ -0,0 +1,73 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	_ "net/http/pprof"
+	"os"
+	"runtime"
+	"runtime/pprof"
+
+	"github.com/spf13/cobra"
+)
+
+// profiler captures profiles of the antler command, as set by the persistent
+// profiling flags.
+type profiler struct {
+	http string   // listen address for net/http/pprof endpoints
+	cpu  string   // CPU profile file name
+	mem  string   // heap profile file name
+	file *os.File // CPU profile file
+}
+
+// flags adds the profiling flags to the given command.
+func (p *profiler) flags(cmd *cobra.Command) {
+	f := cmd.PersistentFlags()
+	f.StringVar(&p.http, "pprof", "",
+		"serves pprof endpoints at the given address (e.g. localhost:6060)")
+	f.StringVar(&p.cpu, "cpuprofile", "", "writes a CPU profile to file")
+	f.StringVar(&p.mem, "memprofile", "",
+		"writes a heap profile to file on exit")
+}
+
+// start starts profiling, as configured by the flags.
+func (p *profiler) start() (err error) {
+	if p.http != "" {
+		go func() {
+			if e := http.ListenAndServe(p.http, nil); e != nil {
+				fmt.Fprintf(os.Stderr, "pprof server error: %s\n", e)
+			}
+		}()
+	}
+	if p.cpu != "" {
+		if p.file, err = os.Create(p.cpu); err != nil {
+			return
+		}
+		if err = pprof.StartCPUProfile(p.file); err != nil {
+			p.file.Close()
+			p.file = nil
+		}
+	}
+	return
+}
+
+// stop stops profiling and writes any profiles.
+func (p *profiler) stop() (err error) {
+	if p.file != nil {
+		pprof.StopCPUProfile()
+		err = p.file.Close()
+	}
+	if p.mem != "" {
+		var f *os.File
+		if f, err = os.Create(p.mem); err != nil {
+			return
+		}
+		runtime.GC()
+		err = errors.Join(pprof.Lookup("heap").WriteTo(f, 0), f.Close(), err)
+	}
+	return
+}
@@ -0,0 +1,159 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+// Command sockdiag prints tcp_info statistics for established TCP sockets,
+// using the same sock_diag(7) path that antler nodes use to sample TCPInfo. It
+// is a diagnostic tool for debugging TCPInfo sampling problems.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/netip"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/heistp/antler/node"
+)
+
+// main executes the sockdiag command.
+func main() {
+	var f filter
+	var probe, ipv4, ipv6 bool
+	var interval time.Duration
+	var count int
+	flag.BoolVar(&probe, "probe", false,
+		"only check that sock_diag sampling works, and exit")
+	flag.DurationVar(&interval, "i", time.Second, "sampling interval")
+	flag.IntVar(&count, "n", 0, "number of samples, or 0 to run until "+
+		"interrupted")
+	flag.BoolVar(&ipv4, "4", false, "sample IPv4 sockets only")
+	flag.BoolVar(&ipv6, "6", false, "sample IPv6 sockets only")
+	flag.Func("addr", "show only sockets with this local or remote address",
+		f.setAddr)
+	flag.IntVar(&f.port, "port", 0,
+		"show only sockets with this local or remote port")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags]\n\n",
+			os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() > 0 || interval <= 0 || (ipv4 && ipv6) {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if probe {
+		if err := node.TestSockdiag(); err != nil {
+			fmt.Fprintf(os.Stderr, "sock_diag failed: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("sock_diag OK")
+		return
+	}
+	c, x := context.WithCancelCause(context.Background())
+	defer x(nil)
+	i := make(chan os.Signal, 1)
+	signal.Notify(i, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		s := <-i
+		x(errors.New(s.String()))
+	}()
+	s := sampler{f, !ipv6, !ipv4}
+	if err := s.run(c, interval, count); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+}
+
+// sampler samples and prints socket statistics.
+type sampler struct {
+	filter filter
+	ipv4   bool
+	ipv6   bool
+}
+
+// run samples and prints socket statistics on the given interval, until count
+// samples were taken, or the Context is canceled.
+func (s sampler) run(ctx context.Context, interval time.Duration,
+	count int) (err error) {
+	var c *node.SockdiagConn
+	if c, err = node.OpenSockdiag(); err != nil {
+		return
+	}
+	defer c.Close()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for n := 0; count == 0 || n < count; n++ {
+		if n > 0 {
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err = s.sample(c); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// sample takes and prints one sample.
+func (s sampler) sample(conn *node.SockdiagConn) (err error) {
+	var ss []node.SockSample
+	for _, v6 := range []bool{false, true} {
+		if (v6 && !s.ipv6) || (!v6 && !s.ipv4) {
+			continue
+		}
+		var p []node.SockSample
+		if p, err = conn.Sample(v6); err != nil {
+			return
+		}
+		ss = append(ss, p...)
+	}
+	fmt.Println(time.Now().Format("15:04:05.000"))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "Src\tDst\tRTT\tRTTVar\tMinRTT\tCwnd\tSSThresh\t"+
+		"Retrans\tDelivery\tPacing\tMSS\tSampleTime\t")
+	for _, p := range ss {
+		if !s.filter.accept(p) {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%d\t%d\t%s\t%s\t%d\t%s\t\n",
+			p.Src, p.Dst, p.RTT, p.RTTVar, p.MinRTT, p.SendCwnd,
+			p.SendSSThresh, p.TotalRetransmits, p.DeliveryRate, p.PacingRate,
+			p.SendMSS, p.SampleTime)
+	}
+	err = w.Flush()
+	return
+}
+
+// filter selects sockets by address and port.
+type filter struct {
+	addr netip.Addr
+	port int
+}
+
+// setAddr sets the address to filter on.
+func (f *filter) setAddr(s string) (err error) {
+	f.addr, err = netip.ParseAddr(s)
+	return
+}
+
+// accept returns true if the given sample is accepted by the filter.
+func (f filter) accept(s node.SockSample) bool {
+	if f.addr.IsValid() && s.Src.Addr() != f.addr && s.Dst.Addr() != f.addr {
+		return false
+	}
+	p := uint16(f.port)
+	if f.port != 0 && s.Src.Port() != p && s.Dst.Port() != p {
+		return false
+	}
+	return true
+}
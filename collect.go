@@ -0,0 +1,539 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/heistp/antler/node/metric"
+)
+
+// collectPartSuffix is the suffix added by the collector endpoint to files that
+// are still being written.
+const collectPartSuffix = ".part"
+
+// Collect configures streaming of results to a central collector while Tests
+// run, so that the central archive stays current even if the host running the
+// Tests is lost. The collector is the /collect endpoint of the builtin web
+// server on another host (see Server.CollectDir).
+type Collect struct {
+	// URL is the base URL of the collector, e.g. http://archive:8080.
+	URL string
+
+	// TokenEnv is the name of an environment variable that contains the
+	// token, sent in the Authorization header. The collector requires it.
+	TokenEnv string
+
+	// Host is the name results are collected under, or the host name if
+	// empty.
+	Host string
+
+	// Interval is the time between scans of WorkDir for new data.
+	Interval metric.Duration
+
+	// Timeout is the maximum time for each request to the collector.
+	Timeout metric.Duration
+}
+
+// validate returns an error if the Collect config is invalid.
+func (c *Collect) validate() (err error) {
+	if c.Interval <= 0 {
+		err = fmt.Errorf("Collect Interval must be positive: %s", c.Interval)
+	}
+	return
+}
+
+// collector incrementally uploads the files in WorkDir to a collector. WorkDir
+// is scanned every Interval, and the bytes appended to each file since the
+// last scan are sent, so files are collected while they're being written.
+// Files written atomically (as name~, then renamed) are collected as partial
+// files, then marked done when renamed.
+type collector struct {
+	*Collect
+	workDir string
+	host    string
+	run     string
+	token   string
+	client  *http.Client
+	file    map[string]*collectFile
+	failed  func(error)
+	fail    bool
+	stopc   chan struct{}
+	done    chan struct{}
+}
+
+// collectFile records the state of a file being collected.
+type collectFile struct {
+	info fs.FileInfo
+	sent int64
+	done bool
+}
+
+// collectEntry is a file found in WorkDir.
+type collectEntry struct {
+	path    string
+	info    fs.FileInfo
+	partial bool
+}
+
+// newCollector returns a new collector for the given Results. The run name is
+// the start time, formatted as for result directories. failed, if not nil, is
+// called when uploads to the collector fail, at most once until they succeed
+// again.
+func newCollector(r Results, failed func(error)) *collector {
+	t := time.Now()
+	if r.ResultDirUTC {
+		t = t.UTC()
+	}
+	c := &collector{
+		Collect: r.Collect,
+		workDir: r.WorkDir,
+		run:     t.Format(r.ResultDirFormat),
+		client:  &http.Client{Timeout: r.Collect.Timeout.Duration()},
+		file:    make(map[string]*collectFile),
+		failed:  failed,
+		stopc:   make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	c.token = os.Getenv(c.TokenEnv)
+	if c.host = c.Host; c.host == "" {
+		if c.host, _ = os.Hostname(); c.host == "" {
+			c.host = "unknown"
+		}
+	}
+	return c
+}
+
+// start starts uploading in a goroutine.
+func (c *collector) start(ctx context.Context) {
+	go func() {
+		defer close(c.done)
+		t := time.NewTicker(c.Interval.Duration())
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				c.report(c.sync(ctx))
+			case <-c.stopc:
+				return
+			}
+		}
+	}()
+}
+
+// stop stops the upload goroutine and uploads any remaining data. It must be
+// called before WorkDir is moved to the result directory.
+func (c *collector) stop(ctx context.Context) {
+	close(c.stopc)
+	<-c.done
+	c.report(c.sync(ctx))
+}
+
+// finish tells the collector that the result is complete, with the given
+// result directory name, or that it was aborted, if name is empty.
+func (c *collector) finish(ctx context.Context, name string) {
+	m := http.MethodDelete
+	u := c.url("")
+	if name != "" {
+		m = http.MethodPost
+		u += "?name=" + url.QueryEscape(name)
+	}
+	c.report(c.do(ctx, m, u, nil))
+}
+
+// report calls failed for the first error after a success.
+func (c *collector) report(err error) {
+	if err == nil {
+		c.fail = false
+		return
+	}
+	if !c.fail && c.failed != nil {
+		c.failed(err)
+	}
+	c.fail = true
+}
+
+// sync scans WorkDir, uploads new data, and removes files from the collector
+// that were removed from WorkDir.
+func (c *collector) sync(ctx context.Context) (err error) {
+	var ee map[string]collectEntry
+	if ee, err = c.scan(); err != nil {
+		return
+	}
+	for n, e := range ee {
+		f, ok := c.file[n]
+		if !ok {
+			f = &collectFile{}
+			c.file[n] = f
+		}
+		if f.info == nil || !os.SameFile(f.info, e.info) ||
+			e.info.Size() < f.sent || (f.done && (e.partial ||
+			!e.info.ModTime().Equal(f.info.ModTime()))) {
+			*f = collectFile{}
+		}
+		if e.info.Size() == f.sent && (e.partial || f.done) {
+			continue
+		}
+		if err = c.upload(ctx, n, e, f); err != nil {
+			return
+		}
+	}
+	for n := range c.file {
+		if _, ok := ee[n]; ok {
+			continue
+		}
+		if err = c.do(ctx, http.MethodDelete, c.url(n), nil); err != nil {
+			return
+		}
+		delete(c.file, n)
+	}
+	return
+}
+
+// scan returns the files in WorkDir, keyed by their names relative to WorkDir.
+// If both a file and its partial version exist, the partial version is
+// returned.
+func (c *collector) scan() (ee map[string]collectEntry, err error) {
+	ee = make(map[string]collectEntry)
+	err = filepath.WalkDir(c.workDir, func(p string, d fs.DirEntry,
+		err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		var r string
+		if r, err = filepath.Rel(c.workDir, p); err != nil {
+			return err
+		}
+		r = filepath.ToSlash(r)
+		n, x := strings.CutSuffix(r, "~")
+		if e, ok := ee[n]; ok && e.partial {
+			return nil
+		}
+		var i fs.FileInfo
+		if i, err = d.Info(); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		ee[n] = collectEntry{p, i, x}
+		return nil
+	})
+	return
+}
+
+// upload uploads the data in the file since the last upload, and marks it done
+// if it's not partial.
+func (c *collector) upload(ctx context.Context, name string, e collectEntry,
+	f *collectFile) (err error) {
+	var o *os.File
+	if o, err = os.Open(e.path); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+		return
+	}
+	defer o.Close()
+	var i fs.FileInfo
+	if i, err = o.Stat(); err != nil {
+		return
+	}
+	if !os.SameFile(i, e.info) {
+		return
+	}
+	u := c.url(name) + "?offset=" + strconv.FormatInt(f.sent, 10)
+	if !e.partial {
+		u += "&done=1"
+	}
+	n := i.Size() - f.sent
+	err = c.do(ctx, http.MethodPut, u, io.NewSectionReader(o, f.sent, n))
+	var s collectStatusError
+	if errors.As(err, &s) && s.code == http.StatusConflict {
+		*f = collectFile{}
+		return
+	}
+	if err != nil {
+		return
+	}
+	f.info = i
+	f.sent += n
+	f.done = !e.partial
+	return
+}
+
+// url returns the collector URL for the named file in the run, or the run
+// itself if name is empty.
+func (c *collector) url(name string) string {
+	u := strings.TrimSuffix(c.URL, "/") + "/collect/" +
+		url.PathEscape(c.host) + "/" + url.PathEscape(c.run)
+	if name == "" {
+		return u
+	}
+	for _, s := range strings.Split(name, "/") {
+		u += "/" + url.PathEscape(s)
+	}
+	return u
+}
+
+// do sends a request to the collector.
+func (c *collector) do(ctx context.Context, method, url string,
+	body io.Reader) (err error) {
+	var q *http.Request
+	if q, err = http.NewRequestWithContext(ctx, method, url, body); err != nil {
+		return
+	}
+	if c.token != "" {
+		q.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	var r *http.Response
+	if r, err = c.client.Do(q); err != nil {
+		return
+	}
+	defer r.Body.Close()
+	if r.StatusCode/100 != 2 {
+		b, _ := io.ReadAll(io.LimitReader(r.Body, 512))
+		err = collectStatusError{r.StatusCode, method, url,
+			strings.TrimSpace(string(b))}
+		return
+	}
+	_, err = io.Copy(io.Discard, r.Body)
+	return
+}
+
+// collectStatusError is returned when the collector responds with an error
+// status.
+type collectStatusError struct {
+	code   int
+	method string
+	url    string
+	text   string
+}
+
+// Error implements error
+func (e collectStatusError) Error() string {
+	return fmt.Sprintf("collector %s %s: %s: %s", e.method, e.url,
+		http.StatusText(e.code), e.text)
+}
+
+// collectHandler is the http.Handler for the /collect endpoint of the builtin
+// web server, which receives results from collectors into Dir. The token must
+// not be empty, as the endpoint writes to the filesystem.
+type collectHandler struct {
+	Dir   string
+	token string
+}
+
+// register registers the handlers for the endpoint.
+func (h collectHandler) register(m *http.ServeMux) {
+	m.HandleFunc("PUT /collect/{host}/{run}/{path...}", h.auth(h.put))
+	m.HandleFunc("DELETE /collect/{host}/{run}/{path...}", h.auth(h.remove))
+	m.HandleFunc("POST /collect/{host}/{run}", h.auth(h.finish))
+	m.HandleFunc("DELETE /collect/{host}/{run}", h.auth(h.abort))
+}
+
+// auth returns a handler that checks the request's token before calling
+// handler.
+func (h collectHandler) auth(handler http.HandlerFunc) http.HandlerFunc {
+	return tokenAuth(h.token, handler)
+}
+
+// tokenAuth returns a handler that checks that the request's Authorization
+// header contains the given bearer token, if it's not empty, before calling
+// handler.
+func tokenAuth(token string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token != "" {
+			t, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(t), []byte(token)) != 1 {
+				http.Error(w, "invalid token", http.StatusUnauthorized)
+				return
+			}
+		}
+		handler(w, r)
+	}
+}
+
+// runDir returns the directory for the request's run, or an error if the host
+// or run are invalid.
+func (h collectHandler) runDir(r *http.Request) (dir string, err error) {
+	for _, s := range []string{r.PathValue("host"), r.PathValue("run")} {
+		if !collectName(s) {
+			err = fmt.Errorf("invalid name: '%s'", s)
+			return
+		}
+	}
+	dir = filepath.Join(h.Dir, r.PathValue("host"), r.PathValue("run"))
+	return
+}
+
+// filePath returns the path for the request's file, or an error if the path is
+// invalid.
+func (h collectHandler) filePath(r *http.Request) (name string, err error) {
+	var d string
+	if d, err = h.runDir(r); err != nil {
+		return
+	}
+	p := r.PathValue("path")
+	if p == "" || path.Clean(p) != p || !filepath.IsLocal(p) ||
+		strings.HasSuffix(p, collectPartSuffix) {
+		err = fmt.Errorf("invalid path: '%s'", p)
+		return
+	}
+	name = filepath.Join(d, filepath.FromSlash(p))
+	return
+}
+
+// collectName returns true if s is a valid host or run name.
+func collectName(s string) bool {
+	return s != "" && s != "." && s != ".." && !strings.ContainsAny(s, `/\`)
+}
+
+// put writes the request body to a file at the given offset, truncating the
+// file there first. The file has the .part suffix until done is set. If the
+// offset is greater than the size of the file, StatusConflict is returned, and
+// the collector starts again from offset zero.
+func (h collectHandler) put(w http.ResponseWriter, r *http.Request) {
+	n, err := h.filePath(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var o int64
+	o, err = strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil || o < 0 {
+		http.Error(w, "invalid offset", http.StatusBadRequest)
+		return
+	}
+	p := n + collectPartSuffix
+	if err = h.write(p, o, r.Body); err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, errCollectOffset) {
+			code = http.StatusConflict
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+	if r.URL.Query().Get("done") != "" {
+		if err = os.Rename(p, n); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// errCollectOffset is returned when data is received at an offset past the end
+// of the file.
+var errCollectOffset = errors.New("offset is past the end of the file")
+
+// write writes data to the named file at the given offset.
+func (h collectHandler) write(name string, offset int64,
+	data io.Reader) (err error) {
+	if err = os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return
+	}
+	var f *os.File
+	if offset == 0 {
+		f, err = os.Create(name)
+	} else {
+		f, err = os.OpenFile(name, os.O_WRONLY, 0)
+	}
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = errCollectOffset
+		}
+		return
+	}
+	defer func() {
+		if e := f.Close(); e != nil && err == nil {
+			err = e
+		}
+	}()
+	var i fs.FileInfo
+	if i, err = f.Stat(); err != nil {
+		return
+	}
+	if offset > i.Size() {
+		err = errCollectOffset
+		return
+	}
+	if err = f.Truncate(offset); err != nil {
+		return
+	}
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		return
+	}
+	_, err = io.Copy(f, data)
+	return
+}
+
+// remove removes a file, and its partial version.
+func (h collectHandler) remove(w http.ResponseWriter, r *http.Request) {
+	n, err := h.filePath(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, p := range []string{n, n + collectPartSuffix} {
+		if e := os.Remove(p); e != nil && !errors.Is(e, fs.ErrNotExist) {
+			http.Error(w, e.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// finish renames the run's directory to the result directory name given in
+// the name parameter.
+func (h collectHandler) finish(w http.ResponseWriter, r *http.Request) {
+	d, err := h.runDir(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	n := r.URL.Query().Get("name")
+	if !collectName(n) {
+		http.Error(w, "invalid name", http.StatusBadRequest)
+		return
+	}
+	p := filepath.Join(filepath.Dir(d), n)
+	if p == d {
+		return
+	}
+	if _, err = os.Stat(p); err == nil {
+		http.Error(w, fmt.Sprintf("'%s' exists", n), http.StatusConflict)
+		return
+	}
+	if err = os.Rename(d, p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// abort removes the run's directory.
+func (h collectHandler) abort(w http.ResponseWriter, r *http.Request) {
+	d, err := h.runDir(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err = os.RemoveAll(d); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
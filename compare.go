@@ -0,0 +1,304 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"cuelang.org/go/cue/load"
+	"github.com/heistp/antler/node"
+	"gonum.org/v1/gonum/stat"
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+// compareMinSamples is the minimum number of samples in each result for a
+// metric to be tested for significance.
+const compareMinSamples = 8
+
+// CompareCommand compares the results of the Tests in two result directories,
+// e.g. runs before and after a kernel patch. Tests are matched by ID, and
+// their data is analyzed as for the Analyze report, with default settings.
+type CompareCommand struct {
+	// A is the baseline result directory, either a path or the name of a
+	// result directory under Results.RootDir.
+	A string
+
+	// B is the result directory compared to A, as for A.
+	B string
+
+	// Alpha is the significance level, at or below which a metric's
+	// difference is significant. If zero, 0.05 is used.
+	Alpha float64
+
+	// Compared is called with the Comparison for each Test found in both
+	// results, in config order.
+	Compared func(Comparison)
+
+	// Missing is called for each Test whose data was not found in the named
+	// result directory.
+	Missing func(test *Test, result string)
+}
+
+// Comparison compares the metrics of a Test in two results.
+type Comparison struct {
+	// ID is the Test ID.
+	ID TestID
+
+	// Metric lists the MetricDeltas for the flows found in both results.
+	Metric []MetricDelta
+}
+
+// MetricDelta is the difference in a flow's metric between results A and B.
+type MetricDelta struct {
+	// Flow is the flow the metric is for.
+	Flow node.Flow
+
+	// Direction is "up" or "down" for packet flow metrics, or empty.
+	Direction string `json:",omitempty"`
+
+	// Name is the name of the metric, as in the EmitStats CSV header.
+	Name string
+
+	// A and B are the values of the metric in each result.
+	A float64
+	B float64
+
+	// Delta is B - A.
+	Delta float64
+
+	// Change is the percent change from A to B, or nil if A is zero.
+	Change *float64 `json:",omitempty"`
+
+	// P is the two-sided p-value of the Mann-Whitney U test on the metric's
+	// samples in each result, or nil if the metric has no samples, or too
+	// few. The samples within a flow aren't independent (e.g. successive
+	// delays), so P should be taken as a guide only.
+	P *float64 `json:",omitempty"`
+
+	// Significant is true if P is at or below the significance level.
+	Significant bool
+}
+
+// run implements command
+func (c CompareCommand) run(ctx context.Context) (err error) {
+	var g *Config
+	if g, err = LoadConfig(&load.Config{}); err != nil {
+		return
+	}
+	var a, b string
+	if a, err = g.Results.resultDir(c.A); err != nil {
+		return
+	}
+	if b, err = g.Results.resultDir(c.B); err != nil {
+		return
+	}
+	if c.Alpha == 0 {
+		c.Alpha = 0.05
+	}
+	for i := range g.Test {
+		t := &g.Test[i]
+		if t.DataFile == "" {
+			continue
+		}
+		if err = context.Cause(ctx); err != nil {
+			return
+		}
+		var ya, yb analysis
+		var ok bool
+		if ya, ok, err = c.analysis(g.Results, a, t); err != nil || !ok {
+			if err != nil {
+				return
+			}
+			continue
+		}
+		if yb, ok, err = c.analysis(g.Results, b, t); err != nil || !ok {
+			if err != nil {
+				return
+			}
+			continue
+		}
+		if c.Compared != nil {
+			c.Compared(Comparison{t.ID, c.compare(ya, yb)})
+		}
+	}
+	return
+}
+
+// analysis returns the analysis for a Test in the given result directory. If
+// its data file doesn't exist, Missing is called and ok is false.
+func (c CompareCommand) analysis(results Results, dir string, test *Test) (
+	y analysis, ok bool, err error) {
+	if y, err = readAnalysis(results, dir, test); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+			if c.Missing != nil {
+				c.Missing(test, dir)
+			}
+		}
+		return
+	}
+	ok = true
+	return
+}
+
+// compare returns the MetricDeltas for the flows in both analyses.
+func (c CompareCommand) compare(a, b analysis) (dd []MetricDelta) {
+	add := func(flow node.Flow, dir, name string, va, vb float64,
+		sa, sb []float64) {
+		d := MetricDelta{
+			Flow:      flow,
+			Direction: dir,
+			Name:      name,
+			A:         va,
+			B:         vb,
+			Delta:     vb - va,
+		}
+		if va != 0 {
+			p := (vb - va) / math.Abs(va) * 100
+			d.Change = &p
+		}
+		if p, ok := mannWhitney(sa, sb); ok {
+			d.P = &p
+			d.Significant = p <= c.Alpha
+		}
+		dd = append(dd, d)
+	}
+	for _, sa := range a.streamList() {
+		sb, ok := b.streams[sa.Flow]
+		if !ok {
+			continue
+		}
+		add(sa.Flow, "", "goodput_mbps", sa.Goodput().Mbps(),
+			sb.Goodput().Mbps(), goodputSamples(&sa), goodputSamples(sb))
+		add(sa.Flow, "", "fct_ms", sa.FCT.Duration().Seconds()*1000,
+			sb.FCT.Duration().Seconds()*1000, nil, nil)
+	}
+	for _, pa := range a.packetList() {
+		pb, ok := b.packets[pa.Flow]
+		if !ok {
+			continue
+		}
+		for _, r := range []struct {
+			dir    string
+			sa, sb *packetStats
+		}{
+			{"up", &pa.Up, &pb.Up},
+			{"down", &pa.Down, &pb.Down},
+		} {
+			da, db := r.sa.delays(), r.sb.delays()
+			if len(da) == 0 || len(db) == 0 {
+				continue
+			}
+			add(pa.Flow, r.dir, "owd_mean_ms", stat.Mean(da, nil),
+				stat.Mean(db, nil), da, db)
+			sort.Float64s(da)
+			sort.Float64s(db)
+			add(pa.Flow, r.dir, "owd_p99_ms",
+				stat.Quantile(0.99, stat.Empirical, da, nil),
+				stat.Quantile(0.99, stat.Empirical, db, nil), nil, nil)
+			add(pa.Flow, r.dir, "lost_pct", r.sa.LostPct, r.sb.LostPct, nil,
+				nil)
+		}
+		if len(pa.RTT) > 0 && len(pb.RTT) > 0 {
+			ra, rb := rttSamples(&pa), rttSamples(pb)
+			add(pa.Flow, "", "rtt_mean_ms", stat.Mean(ra, nil),
+				stat.Mean(rb, nil), ra, rb)
+		}
+	}
+	return
+}
+
+// goodputSamples returns the goodput samples for a stream, in Mbps, omitting
+// the first GoodputPoint, which is always zero.
+func goodputSamples(s *StreamAnalysis) (v []float64) {
+	for i, g := range s.GoodputPoint {
+		if i > 0 {
+			v = append(v, g.Goodput.Mbps())
+		}
+	}
+	return
+}
+
+// rttSamples returns the RTT samples for a packet flow, in milliseconds.
+func rttSamples(p *PacketAnalysis) (v []float64) {
+	for _, r := range p.RTT {
+		v = append(v, r.Delay.Seconds()*1000)
+	}
+	return
+}
+
+// mannWhitney returns the two-sided p-value of the Mann-Whitney U test on the
+// samples a and b, using the normal approximation with tie and continuity
+// corrections. False is returned if either has fewer than compareMinSamples.
+func mannWhitney(a, b []float64) (p float64, ok bool) {
+	n1, n2 := len(a), len(b)
+	if n1 < compareMinSamples || n2 < compareMinSamples {
+		return
+	}
+	type sample struct {
+		value float64
+		a     bool
+	}
+	s := make([]sample, 0, n1+n2)
+	for _, v := range a {
+		s = append(s, sample{v, true})
+	}
+	for _, v := range b {
+		s = append(s, sample{v, false})
+	}
+	sort.Slice(s, func(i, j int) bool {
+		return s[i].value < s[j].value
+	})
+	var r1, ties float64
+	for i := 0; i < len(s); {
+		j := i + 1
+		for j < len(s) && s[j].value == s[i].value {
+			j++
+		}
+		r := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if s[k].a {
+				r1 += r
+			}
+		}
+		t := float64(j - i)
+		ties += t*t*t - t
+		i = j
+	}
+	f1, f2 := float64(n1), float64(n2)
+	n := f1 + f2
+	u := r1 - f1*(f1+1)/2
+	m := f1 * f2 / 2
+	v := f1 * f2 / 12 * ((n + 1) - ties/(n*(n-1)))
+	ok = true
+	if v <= 0 {
+		p = 1
+		return
+	}
+	z := math.Max(math.Abs(u-m)-0.5, 0) / math.Sqrt(v)
+	p = math.Min(2*distuv.UnitNormal.Survival(z), 1)
+	return
+}
+
+// resultDir returns the path to a result directory, given either its path or
+// its name under RootDir.
+func (r Results) resultDir(name string) (dir string, err error) {
+	for _, d := range []string{name, filepath.Join(r.RootDir, name)} {
+		var i fs.FileInfo
+		if i, err = os.Stat(d); err == nil && i.IsDir() {
+			dir = d
+			return
+		}
+	}
+	err = ExitError{ExitConfig, fmt.Errorf("result directory '%s' not found",
+		name)}
+	return
+}
@@ -3,6 +3,41 @@
 
 // This CUE file defines the Antler configuration schema. For documentation,
 // see the corresponding types referenced in the comments.
+//
+// Files in the test package ending in .cue.tmpl are executed as Go templates
+// (https://pkg.go.dev/text/template) to create the corresponding .cue files
+// before the config is loaded. In addition to the builtin template functions,
+// these are available:
+//
+//   expRand n rate
+//     n random numbers on an exponential distribution, as a JSON list
+//   expRandDuration mean n rate
+//     n random durations deviating from mean on an exponential distribution
+//   lognRand n p5 p95
+//     n random numbers on a lognormal distribution with the given 5th and
+//     95th percentiles
+//   lognRandBytes n p5 p95
+//     as for lognRand, for byte lengths
+//   inventory file
+//     reads an inventory file (e.g. of hosts, links and addresses), so large
+//     testbeds may be generated from it instead of maintaining node lists in
+//     CUE. Files ending in .json are decoded to maps, lists and values, and
+//     files ending in .csv are read, with a header row, to a list of maps from
+//     the header fields to each row's values. Relative paths are relative to
+//     the config directory.
+//   json value
+//     the value as JSON, which is valid CUE, e.g. for quoting strings
+//
+// For example, with a hosts.csv file containing the columns id, addr and
+// platform, this generates a node for each host:
+//
+//   {{range inventory "hosts.csv"}}
+//   _node: {{json .id}}: {
+//   	ID:       {{json .id}}
+//   	Platform: {{json .platform}}
+//   	Launcher: SSH: Destination: {{json .addr}}
+//   }
+//   {{end}}
 
 package antler
 
@@ -13,6 +48,13 @@ import (
 // Test lists the Tests to run. Test packages must set this field to run Tests.
 Test: [...#Test]
 
+// Order selects how Tests with the same Priority are ordered in a run, either
+// "config" to keep the order they're listed in, or "id" to sort them by ID,
+// with the ID keys sorted, e.g. [a=1 b=2], which is stable across edits to the
+// config. Tests are then reordered to run after the Tests they DependsOn. The
+// resulting order is recorded in the Order field of manifest.json.
+Order: *"config" | "id"
+
 // MultiReport is a list of multi-Test reports to run. 
 MultiReport?: [...#MultiReport]
 
@@ -22,6 +64,15 @@ Results: #Results
 // Server configures the builtin web server.
 Server: #Server
 
+// Theme configures the appearance of HTML reports.
+Theme: #Theme
+
+// Tuning configures channel depths and buffer sizes.
+Tuning: #Tuning
+
+// Sweep configures searches run by the sweep command, by name.
+Sweep?: [=~_IDregex]: #Sweep
+
 // _IDregex is used for text identifiers in various places.
 _IDregex: "[a-zA-Z0-9][a-zA-Z0-9_-]*"
 
@@ -44,7 +95,10 @@ _IDregex: "[a-zA-Z0-9][a-zA-Z0-9_-]*"
 // the test package. If this is changed, then the existing root directory must
 // be renamed in order to retain and serve existing results.
 //
-// WorkDir is the name of the working directory, under RootDir.
+// WorkDir is the name of the working directory, under RootDir by default. It
+// may be on a different filesystem than RootDir (e.g. fast local scratch space,
+// with RootDir on NFS), in which case the result directory is copied, synced
+// and verified by checksum when the test is complete, instead of renamed.
 //
 // ResultDirUTC indicates whether to use UTC time for result directories (true)
 // or local time (false). If this is changed, existing directories should be
@@ -59,6 +113,37 @@ _IDregex: "[a-zA-Z0-9][a-zA-Z0-9_-]*"
 // LatestSymlink is the name of the symlink that links to the latest result
 // directory. If empty, the latest symlink is not created.
 //
+// DirMode and FileMode are the Unix modes (as for chmod) of the directories and
+// files created for results, before the umask is applied. The setgid bit may be
+// set on DirMode (e.g. 0o2775), so that new results inherit the group of their
+// parent directory. Files hard linked from prior results keep their modes.
+//
+// Group, if not empty, is the group name or numeric gid that owns the files and
+// directories created for results, so that results on shared servers may be
+// read by a team group. The user must be a member of the group.
+//
+// Umask, if not -1, sets the process umask before any results are written.
+// For example, a Umask of 0o002 with the default modes makes results writable
+// by the group. If -1, the umask of the antler process is used.
+//
+// KeySymlinks lists Test ID keys for which symlink trees are maintained under
+// RootDir, to navigate results by parameter. For each key, a symlink to the
+// result directory is added under by-<key>/<value> for each value of the key
+// in the Tests that wrote new files, e.g. by-cca/bbr/2006-01-02-150405Z.
+// Symlinks to result directories that no longer exist are removed.
+//
+// Catalog is the path to a JSON metadata index of results, updated when each
+// result is saved, with the Test IDs, paths and key metrics (see EmitMetrics)
+// of the Tests that wrote new files. It may be searched with the antler search
+// command, or the /search endpoint of the builtin web server. If empty, the
+// Catalog is not maintained.
+//
+// Collect, if set, streams results to a central collector while the run
+// command runs (see #Collect).
+//
+// Live, if set, sends the live progress of the run command to the builtin web
+// server, where it's shown on the /live page (see #Live).
+//
 // Codec defines some recognized file encoding (e.g. compression) formats.
 #Results: {
 	RootDir:      string & !="" | *"results"
@@ -71,6 +156,14 @@ _IDregex: "[a-zA-Z0-9][a-zA-Z0-9_-]*"
 		ResultDirFormat: "2006-01-02-150405Z"
 	}
 	LatestSymlink: string | *"\(RootDir)/latest"
+	DirMode:       int & >=0 & <=0o7777 | *0o755
+	FileMode:      int & >=0 & <=0o7777 | *0o644
+	Group:         string | *""
+	Umask:         int & >=-1 & <=0o777 | *-1
+	KeySymlinks: [...string & =~"^\(_IDregex)$"]
+	Catalog:     string | *"\(RootDir)/catalog.json"
+	Collect?:    #Collect
+	Live?:       #Live
 	Codec: [_id=string & !=""]: #Codec & {ID: _id}
 	Codec: {
 		zstd: {
@@ -92,6 +185,64 @@ _IDregex: "[a-zA-Z0-9][a-zA-Z0-9_-]*"
 	}
 }
 
+// antler.Collect configures streaming of results to a central collector while
+// Tests run, so the central archive stays current even if the host running
+// the Tests is lost. The collector is the builtin web server on another host,
+// with CollectDir set (see #Server).
+//
+// URL is the base URL of the collector, e.g. "http://archive:8080".
+//
+// TokenEnv is the name of an environment variable containing a token, which is
+// sent in the Authorization header as "Bearer <token>", and must match the
+// collector's token (see CollectTokenEnv in #Server).
+//
+// Host is the name results are collected under, or the host name if empty.
+//
+// WorkDir is scanned every Interval, and data appended to each file since the
+// last scan is sent, so DataFiles and reports are collected while they're
+// being written. Each request may take up to Timeout. If the collector is
+// unreachable, a warning is shown, and uploads continue from where they left
+// off when it's reachable again. Streaming doesn't affect the local result,
+// which is saved as usual.
+#Collect: {
+	URL:      string & =~"^https?://"
+	TokenEnv: string & !=""
+	Host:     string & !~"[/\\\\]" & !="." & !=".." | *""
+	Interval: #Duration | *"2s"
+	Timeout:  #Duration | *"30s"
+}
+
+// antler.Live configures sending the live progress of a run to the builtin
+// web server while the run command runs, so long runs may be followed in a
+// browser at the server's /live page. The server must have Live set (see
+// #Server), and may be on the same or another host.
+//
+// URL is the base URL of the server, e.g. "http://localhost:8080".
+//
+// TokenEnv, if not empty, is the name of an environment variable containing a
+// token, which is sent in the Authorization header as "Bearer <token>", and
+// must match the server's LiveTokenEnv token.
+//
+// Host is the name the progress is shown under, or the host name if empty.
+//
+// Every Interval, the state of each Test (pending, running, done or failed),
+// the stream flows in flight with their recent goodput, and recent log lines
+// are sent, and each request may take up to Timeout. If the server is
+// unreachable, a warning is shown, and updates continue when it's reachable
+// again. Sending progress doesn't affect the Tests or their results.
+//
+// Flows and log lines are shown as their results are received from the nodes,
+// which buffer them until the end of the Test by default, so to follow them
+// during a Test, stream them with ResultStream on each node along the way, e.g.
+// {ResultStream: Include: All: true}, possibly with a MaxRate.
+#Live: {
+	URL:      string & =~"^https?://"
+	TokenEnv: string | *""
+	Host:     string & !~"[/\\\\]" & !="." & !=".." | *""
+	Interval: #Duration | *"1s"
+	Timeout:  #Duration | *"5s"
+}
+
 // antler.Codec configures a file encoder/decoder. This may be for compression,
 // or translation between file formats.
 //
@@ -129,9 +280,154 @@ _IDregex: "[a-zA-Z0-9][a-zA-Z0-9_-]*"
 // ListenAddr is the listen address in the form ":port" or "host:port".
 //
 // RootDir is fixed to serve the results.
+//
+// Catalog is fixed to the Catalog of results, which is searched at the /search
+// endpoint, with one q parameter for each argument as for the antler search
+// command, e.g. /search?q=cca=bbr2&q=owd_p95_ms>20. Results are returned as
+// JSON, with paths relative to RootDir.
+//
+// CollectDir, if not empty, enables the /collect endpoint, which receives
+// results streamed from other hosts (see #Collect). Results are saved under
+// CollectDir/<host>/<result>, where result is the name of the result
+// directory on the sending host. While a run is in progress, result is its
+// start time, and files still being written have a .part suffix. If the run
+// is not saved (e.g. no Tests were run), its results are removed.
+//
+// CollectTokenEnv is the name of an environment variable containing the token
+// that collected results must be sent with. It's required if CollectDir is
+// set, as the /collect endpoint writes files on the server.
+//
+// Live, if true, enables the /live page, which shows the progress of runs in
+// progress, sent by the run command on this or other hosts (see #Live), with
+// per-Test progress, flows in flight with goodput sparklines, and recent log
+// lines. The page is updated with server-sent events from /live/events.
+//
+// LiveTokenEnv, if not empty, is the name of an environment variable
+// containing the token that live progress must be sent with.
+//
+// API, if true, enables a JSON REST API, so that dashboards and CI jobs may
+// integrate with antler without scraping directory listings. It requires the
+// Catalog. The endpoints are:
+//
+//   GET /api/results
+//     lists the results in the Catalog, newest first, with the number of Tests
+//     that wrote new files to each, and how many had unmet Expect conditions
+//   GET /api/results/{result}/tests
+//     lists the Tests in a result, with their IDs, paths, Metrics and Expect
+//     results, as for the search command
+//   GET /api/results/{result}/stats/{path}
+//     returns the summary statistics for the Test with the given path, as
+//     written by EmitStats, calculated from its DataFile (the Test must still
+//     be in the config)
+//   POST /api/report
+//     regenerates the reports from the latest data, as for the report command,
+//     in the server's working directory, returning 202 (Accepted), or 409
+//     (Conflict) if regeneration is already running (only if APITokenEnv is
+//     set)
+//   GET /api/report
+//     returns the state of report regeneration, and the result of the last one
+//
+// APITokenEnv, if not empty, is the name of an environment variable containing
+// the token that POST requests to the API must be sent with. POST requests are
+// not accepted without it.
 #Server: {
-	ListenAddr: string & !="" | *":8080"
-	RootDir:    Results.RootDir
+	ListenAddr:      string & !="" | *":8080"
+	RootDir:         Results.RootDir
+	Catalog:         Results.Catalog
+	CollectDir:      string | *""
+	CollectTokenEnv: string | *""
+	Live:            bool | *false
+	LiveTokenEnv:    string | *""
+	API:             bool | *false
+	APITokenEnv:     string | *""
+}
+
+// antler.Theme configures the appearance of HTML reports.
+//
+// Mode selects the colors used for the page and charts. "auto" uses dark colors
+// when the viewer's browser or OS prefers a dark color scheme
+// (prefers-color-scheme), while "light" and "dark" force the given mode.
+//
+// Palette selects the palette used to assign colors to Flows, Stations in
+// ChartsStation, and tins in ChartsTin:
+// - default: the default palette in Google Charts
+// - okabe-ito: the Okabe-Ito colorblind-safe palette (without black)
+// - tol-bright: Paul Tol's colorblind-safe bright palette
+// - tol-muted: Paul Tol's colorblind-safe muted palette
+//
+// Colors, if set, is a custom list of colors (e.g. "#0072b2") used instead of
+// Palette.
+#Theme: {
+	Mode:    *"auto" | "light" | "dark"
+	Palette: *"default" | "okabe-ito" | "tol-bright" | "tol-muted"
+	Colors?: [...string & !=""]
+}
+
+// antler.Sweep configures a search, run with "antler sweep <name>", for the
+// knee in a parameter (e.g. a shaper rate or flow count) where a metric
+// condition stops being met, using bisection between Min and Max.
+//
+// Tag is the name of a CUE tag that the parameter value is injected with, for
+// each step. The tagged field should have a default, so the config also loads
+// without it, e.g.:
+//
+//   _rate: *100 | number @tag(rate,type=number)
+//
+// Min and Max are the bounds of the search. Cond must be met at one bound and
+// not the other, which are run first.
+//
+// Resolution is the width of the interval at which the search stops.
+//
+// Integer, if true, rounds parameter values to integers, e.g. for flow counts.
+//
+// MaxSteps is the maximum number of Test runs, including the two bounds.
+//
+// Cond is a condition on a key metric, in the same form as for Test.Expect
+// (e.g. owd_p95_ms<=20), which is met on one side of the knee. EmitMetrics
+// must be in the Test's After reports.
+//
+// Test selects the Test to run, with keys as Test ID keys and values as
+// regular expressions their values must match. It must select exactly one
+// Test. Other Tests are linked from prior results, if any.
+//
+// Each step is saved as a separate result, and the search trajectory is
+// written to sweep_<name>.json in the Results RootDir after each step.
+#Sweep: {
+	Tag:        string & =~"^\(_IDregex)$"
+	Min:        number
+	Max:        number
+	Resolution: number & >0
+	Integer:    bool | *false
+	MaxSteps:   int & >=2 | *12
+	Cond:       #MetricCond
+	Test: [string]: string
+}
+
+// antler.Tuning configures channel depths and buffer sizes. The optimal values
+// differ widely between hosts (e.g. a Raspberry Pi vs a 100GbE server), and
+// the defaults are a compromise.
+//
+// DataChanLen is the buffer length of the channels that carry data items
+// between report stages, and from the nodes to the reports.
+//
+// ConnTxLen is the maximum number of messages that a conn between nodes passes
+// to its transport at once. Larger values may increase throughput at high
+// message rates, at the expense of memory and latency.
+//
+// TransferBufLen is the default BufLen for Uploads, Downloads and Workloads,
+// which is the size of the buffer used to read and write from the conn.
+//
+// FileRate, if not zero, limits the rate (see #Bitrate) at which each node
+// sends FileData (e.g. packet captures or System output), so that large files
+// don't starve the control traffic on the conns. FileData is sent in
+// checksummed chunks of at most 64 KiB, which SaveFiles verifies. Transfers
+// still in progress when a node fails are abandoned, and diagnostics are not
+// limited.
+#Tuning: {
+	DataChanLen:    int & >0 | *64
+	ConnTxLen:      int & >0 | *16
+	TransferBufLen: int & >0 | *(1024 * 128)
+	FileRate:       int & >=0 | #Bitrate | *0
 }
 
 // antler.Test defines a test to run.
@@ -152,16 +448,50 @@ _IDregex: "[a-zA-Z0-9][a-zA-Z0-9_-]*"
 // minimal gain), but the Test must always be re-run to generate reports, and
 // the report command will not work.
 //
+// DataSegment, if set, writes the raw result data in segments, with DataFile
+// as the index. This is documented in more detail in #DataSegment.
+//
+// DataFormat is the format of the raw result data, either "gob" (the default),
+// or "json" for newline-delimited JSON, which may be read by other tools (e.g.
+// jq or Python) without a Go decoder. Each line is an object with the item's
+// type name in Type (e.g. "node.StreamIO") and its fields in Data. JSON data
+// is larger and slower to write and read than gob. The default DataFile is
+// "data.jsonl" for json. Data in either format may be read by antler, and may
+// be encoded with any Codec (e.g. with #Encode or DataSegment.Extension).
+//
 // HMAC enables or disables HMAC protection for test traffic. Enabling HMAC
 // prevents casual attackers from sending unauthorized traffic to test servers,
 // but does not provide immunity from sophisticated attacks.
 //
 // Run defines the Run hierarchy, and is documented in more detail in #Run.
 //
+// IdleProbe, if set, adds idle latency probe phases before and/or after the
+// Run, as documented in #IdleProbe.
+//
 // Timeout sets the maximum amount of time the Test can run for, and defaults
 // to 11 minutes, to comfortably accommodate 10 minute Tests.  A timeout of 0
 // disables the timeout.
 //
+// MemoryLimit, if not zero, limits the live heap size (see #Bytes) of the
+// antler process while reports buffer data for the Test in memory, so that
+// hosts with limited RAM aren't OOM-killed during long runs. When the limit is
+// exceeded, in-memory data for a Test with an empty DataFile is spilled to a
+// temporary file, and the Analyze and Snapshot reports fail with an error
+// naming the Test and report. Since the heap is shared, the limit should leave
+// room for any Tests and MultiReports that run concurrently.
+//
+// Log limits the log entries sent by each node (see #LogLimit).
+//
+// Overflow limits the messages each node queues for sending to its parent,
+// if the reports stall (see #Overflow).
+//
+// Diagnose, if set, captures diagnostic state from each node if the Test fails
+// (see #Diagnose).
+//
+// Lock, if set, makes each node take a lock on its host, so runs from
+// different controllers can't run conflicting Tests on the same hosts (see
+// #Lock).
+//
 // DuringDefault and During are concatenated together to form a pipeline of
 // Reports that are run *while* the Test is run. They may not be used to
 // generate saved reports from result data, otherwise those reports would be
@@ -173,13 +503,74 @@ _IDregex: "[a-zA-Z0-9][a-zA-Z0-9_-]*"
 // run *after* the Test is run. These may be used to generate persistent reports
 // from the result data. AfterDefault defines some sensible defaults to run
 // after Tests, like saving sorted log files, and system information.
+//
+// Expect lists conditions on the Test's key metrics (see #EmitMetrics for the
+// metric names), in the form name<op>value, where op is one of <, <=, > or >=,
+// e.g. "goodput_mbps>=90", "owd_p95_ms<=20" or "lost_pct<=1". The conditions
+// are evaluated after the After reports, which must include Analyze. The
+// result is written to expect.json and added to the Catalog, and each unmet
+// condition is sent to the MultiReports as an error, so the Test is shown as
+// failed (e.g. in JUnit). A condition on a metric that wasn't found is unmet.
+//
+// Assert lists composable predicates on the Test's results, for acceptance
+// criteria that can't be expressed in Expect (see #Assert). They're evaluated
+// and reported along with Expect.
+//
+// ExpectFail, if true, fails the run (or report) with exit code 7 after all
+// Tests are done, if any of the Expect or Assert conditions were not met.
+//
+// Minimal, if set, enables minimal results mode, which analyzes the raw data
+// as it arrives, and saves only summaries and downsampled series, as
+// documented in #Minimal.
+//
+// Window, if set, restricts the wall-clock times the Test may run in, as
+// documented in #Window.
+//
+// Export lists the keys of the Feedback returned by the Test's Run (e.g. a
+// measured baseline RTT or a discovered server address) to save in a run-level
+// Feedback store, and Import lists the keys to load from the store and pass to
+// the Test's Run as incoming Feedback, so runners may use them (e.g. with
+// AddrKey). Each Imported key must be Exported by an earlier Test, which is
+// checked by vet, and it's recommended that the importing Test also DependsOn
+// the exporting Test. If an Imported key is not in the store when the Test is
+// to run (e.g. the exporting Test was linked from a prior result), the Test is
+// skipped. Exported values are recorded in the Feedback field of
+// manifest.json, and a later Export of the same key replaces the value.
+//
+// Priority orders Tests in a run, with Tests having a lower Priority run
+// first. Tests with the same Priority are ordered according to Order.
+//
+// Iterations is the number of times to run the Test, for reliable statistics
+// across repetitions. If greater than one, the Test is replaced by that many
+// iterations, each with the key "iteration" added to its ID, with the
+// iteration number from 1, and "iter<n>_" appended to its Path, so the data
+// for each is stored separately. The ID key "iteration" is reserved for this.
+// Iterations may be run separately with a filter (e.g. iteration=2), and a
+// DependsOn on the Test depends on all its iterations. See
+// #AggregateIterations for aggregate statistics across the iterations.
+//
+// DependsOn lists the IDs of Tests that must complete successfully earlier in
+// the same run before this Test may run, e.g. a calibration Test whose
+// results are used by later Tests. Tests are reordered so they run after
+// their dependencies, and otherwise keep their order. Unknown IDs and
+// dependency cycles are errors, reported by vet. If a dependency didn't
+// complete (e.g. it was skipped by a filter or its Window), the Test is
+// skipped. Tests whose data was linked from a prior result count as completed.
 #Test: {
 	ID?: [string & =~_IDregex]: string & =~_IDregex
 	Path:     string | *"{{range $v := .}}{{$v}}_{{end}}"
-	DataFile: string | *"data.gob"
-	HMAC:     bool | *false
+	DataFormat:   *"gob" | "json"
+	DataFile:     string | *[if DataFormat == "json" {"data.jsonl"}, "data.gob"][0]
+	DataSegment?: #DataSegment
+	HMAC:         bool | *false
 	#Run
-	Timeout: #Duration | *"660s"
+	IdleProbe?:  #IdleProbe
+	Timeout:     #Duration | *"660s"
+	MemoryLimit: int & >=0 | #Bytes | *0
+	Log:         #LogLimit
+	Overflow:    #Overflow
+	Diagnose?:   #Diagnose
+	Lock?:       #Lock
 	During?: [...#Report]
 	DuringDefault: [...#Report] | *[
 			{SaveFiles: {Consume: true}},
@@ -190,23 +581,290 @@ _IDregex: "[a-zA-Z0-9][a-zA-Z0-9_-]*"
 			{EmitLog: {To: ["log.txt"], Sort: true}},
 			{EmitSysInfo: {To: ["sysinfo_%s.html"]}},
 	]
+	ExpectFail: bool | *false
+	Expect?: [...#MetricCond]
+	Assert?: [...#Assert]
+	Minimal?: #Minimal
+	Window?:  #Window
+	Export?: [...string & !=""]
+	Import?: [...string & !=""]
+	Priority:   int | *0
+	Iterations: int & >=1 | *1
+	DependsOn?: [...{[string & =~_IDregex]: string & =~_IDregex}]
+}
+
+// antler.Window is a recurring wall-clock time window in which a Test may run,
+// e.g. {Start: "02:00", End: "06:00"} for probes on a production network.
+//
+// Start and End are the times of day the Window opens and closes, in the form
+// HH:MM. If End is before Start, the Window ends on the following day, and if
+// they're equal, it lasts all day. Day, if set, lists the days of the week the
+// Window opens on (e.g. ["Mon", "Tue"]). Location is the IANA time zone name
+// (e.g. "Europe/Berlin") for Start and End, or the local time zone if empty.
+//
+// When the Test is about to run outside its Window, Outside selects whether to
+// "wait" for the Window to open, or "skip" the Test. MaxWait, if set, is the
+// maximum time to wait, after which the Test is skipped instead. Skipped Tests
+// have no result, so they're run by the next run command without a filter. The
+// decision for each Test is recorded in the Window field of manifest.json.
+#Window: {
+	Start:     string & =~"^([01][0-9]|2[0-3]):[0-5][0-9]$"
+	End:       string & =~"^([01][0-9]|2[0-3]):[0-5][0-9]$"
+	Day?:      [...("Sun" | "Mon" | "Tue" | "Wed" | "Thu" | "Fri" | "Sat")]
+	Location?: string & !=""
+	Outside:   *"wait" | "skip"
+	MaxWait?:  #Duration
+}
+
+// antler.Minimal configures minimal results mode for a Test, for constrained
+// controllers (e.g. running on the router under test) where the full raw data
+// is too heavy to save. The raw data is analyzed at the start of the During
+// pipeline, and only summaries and downsampled series are saved to the
+// DataFile, or buffered in memory if DataFile is empty.
+//
+// The StreamIO and TCPInfo series, which are sampled at high rates (see
+// IOSampleInterval and TCPInfoInterval), are downsampled as they arrive,
+// keeping the first sample and the last sample in each Interval of each
+// series. Stream lengths, FCTs and total goodput are unchanged, as StreamIO is
+// cumulative, but time series such as goodput and cwnd have a resolution of
+// Interval. The slow-start exit time is taken from the full TCPInfo series.
+//
+// PacketIO is held in memory until the end of the Test, then analyzed and
+// discarded. The loss, duplicate, reordering and delay stats, and the Metrics
+// of the packet flows, are saved in a summary, along with the OWD and RTT
+// series downsampled in the same way. After reports use the summary, so the
+// stats, Metrics and Expect conditions are exact, while reports that plot or
+// use the delay series (e.g. CDFs, WorkingLatency and Percentile assertions)
+// use the downsampled series. ClockOffset and Percentiles are used to analyze
+// the packet flows, as for #Analyze, whose own settings don't apply to them
+// in minimal results mode. RPC data is kept in full.
+#Minimal: {
+	Interval:    #Duration | *"100ms"
+	ClockOffset: bool | *false
+	Percentiles: [...number & >=0 & <=100] | *[50, 90, 99, 99.9]
+}
+
+// antler.MetricCond is a condition on a named metric, in the form
+// name<op>value, where op is one of <, <=, > or >=.
+#MetricCond: string & =~"^[^<>=!]+(<=|>=|<|>)[-+]?[0-9.]+([eE][-+]?[0-9]+)?$"
+
+// antler.Assert contains the union of composable predicates on a Test's
+// results. Only one field may be set. Metric names are as for #EmitMetrics, and
+// Op is one of <, <=, > or >=.
+//
+// Metric is a condition in the same form as for Expect.
+//
+// Between passes if the value of Metric is from Min to Max, inclusive.
+//
+// Percentile passes if the P percentile (from 0 to 100) of the delays in Dist,
+// in milliseconds, meets the condition given by Op and Value. Dist is the name
+// of a delay metric without the statistic and unit suffix, e.g. owd, rtt,
+// udp.owd_up or rpc_latency, so any percentile may be checked, e.g. p99.9.
+//
+// Ratio passes if the value of Metric divided by the value of Over meets the
+// condition, e.g. to check that one flow gets at least a given fraction of the
+// goodput of another.
+//
+// Trend passes if the percent change in the value of Metric, relative to its
+// value for the same Test in the most recent run in the Catalog, meets the
+// condition, e.g. to catch regressions over 10% with {Op: "<=", Value: 10}.
+// Prior values are read from the Catalog, so EmitMetrics must be in the After
+// pipeline. If there's no prior value, Trend passes.
+//
+// Not, All and Any compose other predicates, and pass if their predicate fails,
+// all of their predicates pass, or any of their predicates pass, respectively.
+#Assert: {
+	Metric?:     #MetricCond
+	Between?:    #Between
+	Percentile?: #Percentile
+	Ratio?:      #Ratio
+	Trend?:      #Trend
+	Not?:        #Assert
+	All?: [#Assert, ...#Assert]
+	Any?: [#Assert, ...#Assert]
+}
+
+// antler.Between passes if the value of Metric is from Min to Max, inclusive.
+#Between: {
+	Metric: string & !=""
+	Min:    number
+	Max:    number & >=Min
+}
+
+// antler.Percentile passes if the P percentile of the delays in Dist meets the
+// condition given by Op and Value.
+#Percentile: {
+	Dist:  string & !=""
+	P:     number & >=0 & <=100
+	Op:    #CondOp
+	Value: number
+}
+
+// antler.Ratio passes if Metric divided by Over meets the condition given by
+// Op and Value.
+#Ratio: {
+	Metric: string & !=""
+	Over:   string & !=""
+	Op:     #CondOp
+	Value:  number
+}
+
+// antler.Trend passes if the percent change in Metric from the prior run meets
+// the condition given by Op and Value.
+#Trend: {
+	Metric: string & !=""
+	Op:     #CondOp
+	Value:  number
+}
+
+// #CondOp is a comparison operator for conditions.
+#CondOp: "<" | "<=" | ">" | ">="
+
+// node.LogLimit limits the log entries sent by each node during a Test, so that
+// a chatty runner (e.g. a System command with Stdout set to "stream") can't
+// flood the connection to the parent node and stall result streaming. Errors
+// are never limited.
+//
+// Level is the minimum severity of entries that are sent, one of "debug",
+// "info" or "warn".
+//
+// Rate is the maximum sustained rate of entries per second for each log tag
+// (e.g. for each runner type), and Burst is the number of entries per tag that
+// may be sent at once before Rate applies. Max is the maximum number of entries
+// sent by each node during the Test. A Rate or Max of 0 disables that limit.
+//
+// Dropped entries are counted, and a warning with the count is logged when
+// entries for the tag are sent again, or when the node completes.
+#LogLimit: {
+	Level: *"info" | "debug" | "warn"
+	Rate:  int & >=0 | *100
+	Burst: int & >=1 | *1000
+	Max:   int & >=0 | *100000
+}
+
+// node.Overflow limits the number of messages each node queues for sending to
+// its parent, so that if the reports stall (e.g. on a slow disk), memory use on
+// the nodes is bounded. Only messages that are streamed (see #ResultStream) or
+// pushed are queued, as others are buffered until the end of the Test. Queue is
+// the maximum number of queued messages, or 0 for no limit. Policy selects
+// what happens when the queue is full:
+//
+//   block:       runners block until the queue has room
+//   drop-oldest: the oldest data item in the queue is dropped for each new one
+//   fail:        the Test fails, and data items are dropped thereafter
+//
+// Errors and FileData are never dropped. If the queue overflows, a warning is
+// logged, and the node sends a QueueOverflow data item at the end of the Test,
+// with the number of dropped items, or the number of times the node blocked.
+#Overflow: {
+	Queue:  int & >=0 | *0
+	Policy: *"block" | "drop-oldest" | "fail"
+}
+
+// node.Diagnose lists commands that each node runs if a Test fails, to capture
+// the state of the node for diagnosing the failure after the lab state has
+// changed. The commands are run after the node's runners complete, but before
+// its cleanup (e.g. before Netns nodes exit), and their output is saved to
+// diag_<nodeID>.txt in the result directory by SaveFiles. Each Command is split
+// into arguments as for #Command, so shell pipelines must use sh -c. Timeout is
+// the maximum time for each command. Set Diagnose to {} to use the defaults.
+#Diagnose: {
+	Command: [...string & !=""] | *[
+		"ip addr",
+		"ip route",
+		"tc -s qdisc",
+		"ss -tinm",
+		"sh -c 'dmesg | tail -n 50'",
+	]
+	Timeout: #Duration | *"5s"
+}
+
+// node.Lock is a lock that each node, other than the root node, takes on its
+// host during setup, to guard against concurrent runs from different
+// controllers on shared nodes. The nodes in a run share the lock, so nodes on
+// the same host (e.g. Netns nodes) don't conflict. The lock is held until the
+// node exits, and is released by the OS if it dies.
+//
+// The lock is taken with flock(2) on the file at Path, which records the
+// owning run's user, controller host and PID, Test ID and start time. Path
+// must be on a filesystem shared by all nodes on the host (e.g. not inside a
+// container's private filesystem). The file Path with ".guard" appended is
+// also used.
+//
+// If the lock is held by another run, setup fails with the owner's identity,
+// unless Wait is true, in which case the node waits (queues) for the lock to
+// be released. MaxWait, if set, is the maximum time to wait.
+#Lock: {
+	Path:     string & !="" | *"/tmp/antler.lock"
+	Wait:     bool | *false
+	MaxWait?: #Duration
+}
+
+// antler.DataSegment configures a Test to write its raw result data as
+// multiple segment files, for very large results (e.g. long soak Tests).
+// Segments may be fetched and decoded individually, and completed segments may
+// be compressed while the Test runs. The DataFile becomes an index, containing
+// one antler.SegmentRef for each segment. Segment files are named by inserting
+// a sequence number before the DataFile's extension (e.g. data.0001.gob).
+//
+// A new segment is started when the current segment reaches Size bytes (before
+// encoding), or has been written to for Duration. At least one of Size or
+// Duration must be set.
+//
+// Extension, if not empty, is the extension of a Codec used to encode each
+// segment after it's completed (e.g. ".zst"). The unencoded segment is then
+// removed.
+#DataSegment: {
+	Size?:     int & >0 | #Bytes
+	Duration?: #Duration
+	Extension: string | *""
 }
 
 // antler.Report contains the union of Report types. Only one field may be set.
 // Reports are documented in more detail in their individual definitions.
+//
+// Timeout, if set, is the maximum time the Report may take to complete after
+// its input is done (e.g. to execute a chart template). If it's exceeded, the
+// Report is canceled, its remaining output is discarded, and an error is
+// returned naming the stage that timed out.
 #Report: {
+	Timeout?:          #Duration
 	Analyze?:          #Analyze
 	Encode?:           #Encode
 	EmitLog?:          #EmitLog
 	EmitSysInfo?:      #EmitSysInfo
+	EmitMetrics?:      #EmitMetrics
+	EmitStats?:        #EmitStats
+	EmitInflux?:       #EmitInflux
+	EmitNATS?:         #EmitNATS
 	ChartsTimeSeries?: #ChartsTimeSeries
 	ChartsFCT?:        #ChartsFCT
+	ChartsCDF?:        #ChartsCDF
+	ChartsRRUL?:       #ChartsRRUL
+	ChartsStation?:    #ChartsStation
+	ChartsTin?:        #ChartsTin
+	ChartsTCPInfo?:    #ChartsTCPInfo
+	Snapshot?:         #Snapshot
+	FilterTag?:        #FilterTag
 	SaveFiles?:        #SaveFiles
+	Custom?:           #CustomReport
 }
 
 // antler.Analyze is a report that analyzes data used by other reports. This
 // must be in the Report pipeline *before* reports that require it.
+//
+// ClockOffset, if true, estimates the clock offset between the client and
+// server of each packet flow, from the minimum one-way deltas in each
+// direction, and corrects the server's times for it, so one-way delays are
+// meaningful between separate hosts whose clocks aren't synchronized. This
+// assumes the minimum one-way delays are symmetric, so it should not be used
+// when nodes share a clock and the path delays are intentionally asymmetric.
+//
+// Percentiles lists the percentiles, from 0 to 100, calculated for the OWD and
+// RTT of packet flows, along with the min, max, mean and standard deviation.
 #Analyze: {
+	ClockOffset: bool | *false
+	Percentiles: [...number & >=0 & <=100] | *[50, 90, 99, 99.9]
 }
 
 // antler.Encode is a report that encodes, re-encodes and decodes files.
@@ -231,7 +889,10 @@ _IDregex: "[a-zA-Z0-9][a-zA-Z0-9_-]*"
 }
 
 // antler.EmitLog is a report that emits logs. Multiple destinations may be
-// listed in To, either filenames, or the '-' character for stdout.
+// listed in To, either filenames, or the '-' character for stdout. When
+// multiple reports write to stdout at once, the first streams its output, and
+// the output of the others is held and written after it completes, so output
+// from different reports isn't interleaved.
 //
 // If Sort is true, logs are first gathered, then emitted sorted by time when
 // the pipeline stage (that EmitLog runs in) completes.
@@ -250,17 +911,183 @@ _IDregex: "[a-zA-Z0-9][a-zA-Z0-9_-]*"
 	To: [string & !="", ...string & !=""] | *["sysinfo_%s.html"]
 }
 
+// antler.EmitMetrics is a report that writes key metrics for a Test to
+// metrics.json, so that they may be searched in the Catalog of results. It
+// requires Analyze before it in the pipeline.
+//
+// Metrics are named by type and statistic, with units in the name, e.g.
+// owd_p95_ms, rtt_p99_ms, lost_pct, goodput_mbps or rpc_latency_p50_ms.
+// Aggregate metrics over all flows have no prefix, and the metrics for each
+// flow are prefixed by the Flow and a dot, e.g. udp.owd_up_p95_ms. Delay
+// metrics (owd, owd_up, owd_down, rtt and rpc_latency) have the mean, p50, p95
+// and p99 statistics.
+#EmitMetrics: {
+}
+
+// antler.EmitStats is a report that writes summary statistics for the stream
+// and packet flows in each Test, for further statistical analysis. It requires
+// Analyze before it in the pipeline.
+//
+// Multiple destinations may be listed in To, either filenames, or the '-'
+// character for stdout. Filenames ending in .csv are written as CSV, and all
+// others as JSON.
+//
+// For stream flows, the length, FCT and goodput are written. For each
+// direction of packet flows, the number of packets sent, the lost, duplicate,
+// early and late (reordered) percentages, and the mean, p50, p95 and p99
+// one-way delay are written. Delays are in milliseconds, and bitrates in Mbps.
+//
+// For JSON, working_latency is also written if packet flows measured RTTs both
+// while idle (see #Index) and while the stream flows were active (loaded),
+// with the median idle RTT, the p50, p95 and p99 loaded RTTs, and increase_ms,
+// the p95 loaded RTT minus the idle RTT (see #Index).
+#EmitStats: {
+	To: [string & !="", ...string & !=""] | *["stats.json"]
+}
+
+// antler.EmitInflux is a report that writes StreamIO, PacketIO and TCPInfo
+// samples to a time-series database as they arrive, using the InfluxDB line
+// protocol over HTTP, so existing dashboards (e.g. Grafana) may be used to
+// watch Tests. It's normally used in the During pipeline, but may also be used
+// in the After pipeline to export saved results. Data is passed through to the
+// next stage.
+//
+// URL is the write endpoint, e.g.
+// "http://localhost:8086/api/v2/write?org=lab&bucket=antler&precision=ns" for
+// InfluxDB 2, or "http://localhost:8086/write?db=antler" for InfluxDB 1.x.
+// Other databases that accept the line protocol may also be used, e.g.
+// TimescaleDB via Telegraf's influxdb_listener input and postgresql output.
+//
+// TokenEnv, if not empty, is the name of an environment variable containing an
+// API token, which is sent in the Authorization header as "Token <token>".
+//
+// Points are written to the measurements stream_io (field bytes), packet_io
+// (fields seq and len) and tcp_info (fields rtt_ms, rttvar_ms, cwnd,
+// delivery_rate_bps, pacing_rate_bps, retransmits, min_rtt_ms, bytes_acked,
+// bytes_retrans, delivered, delivered_ce, snd_wnd, rcv_wnd, busy_time_ms,
+// rwnd_limited_ms and sndbuf_limited_ms), each prefixed by
+// Prefix. Points are tagged with the Test ID, the Flow's Tags, any tags in Tag,
+// and the tags flow, and dir (sent or rcvd) and/or side (client or server for
+// packet_io, sender or receiver for tcp_info). Timestamps are absolute, in
+// nanoseconds.
+//
+// Points are written in batches of up to BatchSize points, or every
+// FlushInterval. Each write may take up to WriteTimeout. If writes can't keep
+// up, batches are dropped rather than stalling the pipeline. Failed writes and
+// dropped batches are logged as warnings, but don't fail the Test.
+#EmitInflux: {
+	URL:           string & =~"^https?://"
+	TokenEnv:      string | *""
+	Prefix:        string | *"antler_"
+	BatchSize:     int & >0 | *5000
+	FlushInterval: #Duration | *"1s"
+	WriteTimeout:  #Duration | *"10s"
+	Tag: [string & !=""]: string
+}
+
+// antler.EmitNATS is a report that publishes selected data items, and events
+// for the start and end of each Test, to a NATS server as they arrive, so that
+// stream-processing pipelines may be triggered by live measurements (e.g. to
+// alert on anomalies). It's normally used in the During pipeline. Data is
+// passed through to the next stage.
+//
+// URL is the server URL, e.g. "nats://localhost:4222". The core NATS protocol
+// is used, without JetStream, so messages are only received by subscribers
+// connected at the time. Kafka may be fed using a NATS-to-Kafka bridge.
+//
+// TokenEnv, if not empty, is the name of an environment variable containing an
+// auth token, which is sent when connecting.
+//
+// Type lists the data item types to publish, by their type names as in the
+// JSON DataFormat (e.g. node.StreamIO, node.PacketIO, node.TCPInfo,
+// node.LogEntry or node.Error). Each is published to <Subject>.data as the JSON
+// object {"Test":{<Test ID>},"Type":"<type>","Data":{<item>}}.
+//
+// Events are published to <Subject>.event as the JSON object
+// {"Test":{<Test ID>},"Event":"<event>","Time":"<RFC 3339 time>"}, where event
+// is start when the Test's pipeline starts, or end when its data is done. The
+// end event also has Errors, the number of node.Errors seen, if any.
+//
+// Connecting, and each write, may take up to WriteTimeout. If publishing can't
+// keep up, messages are dropped rather than stalling the pipeline. Failed and
+// dropped messages are logged as warnings, but don't fail the Test.
+#EmitNATS: {
+	URL:          string & =~"^nats://"
+	Subject:      string & =~"^[^ \t\r\n*>]+$" | *"antler"
+	TokenEnv:     string | *""
+	WriteTimeout: #Duration | *"5s"
+	Type: [...string & !=""] | *["node.StreamIO", "node.TCPInfo", "node.Error"]
+}
+
+// antler.Snapshot is a report for long running (soak) Tests, which runs the
+// Report pipeline every Interval on the data received so far, so results may be
+// inspected before the Test completes. It must be in the During pipeline, and
+// the pipeline should normally start with Analyze. Files written by the
+// Reports are prefixed with Prefix, and replaced with each snapshot. All data
+// items are retained in memory for the duration of the Test.
+#Snapshot: {
+	Interval: #Duration | *"1h"
+	Prefix:   string & !="" | *"snapshot_"
+	Report: [...#Report]
+}
+
+// antler.FilterTag is a report that runs the Report pipeline on the data for
+// Flows whose Tags include all of those in Tag, e.g. to make separate plots for
+// foreground and background traffic. All data is still passed to the next
+// stage. The pipeline should normally start with Analyze. Files written by the
+// Reports are prefixed with Prefix.
+//
+// Tags are set in a Stream (e.g. in Upload or Download) or PacketClient. Data
+// without a Flow (e.g. logs) is always passed to the Report pipeline.
+#FilterTag: {
+	Tag:    #Tags
+	Prefix: string | *""
+	Report: [...#Report]
+}
+
 // antler.ChartsTimeSeries runs a Go template to create a time series plot
 // using Google Charts containing one or two axes, with the goodput for any
 // stream flows, and delay times for any packet flows. The Options field may
 // be used to set any Configuration Options that Google Charts supports:
 //
 // https://developers.google.com/chart/interactive/docs/gallery/linechart#configuration-options
+//
+// Series are ordered by the Flows' declaration order in the config. Each Flow
+// is assigned a color that is consistent across all Tests in a run, with
+// secondary series for the same Flow (e.g. TCP RTT) drawn with dashed lines.
+// Colors set in Options.series take precedence.
+//
+// FlowTo, if set, is a file name containing the verb %s, which is replaced by
+// a slug of each Flow (letters and numbers lowercased, other characters
+// replaced with hyphens) to write a separate page for each Flow, with only its
+// series and stats. Flows in the main page's tables link to these pages, so
+// results may be shared pointing at one Flow in a Test. Table rows also have
+// stable fragment IDs, of the form #stream-<slug>, #packet-<slug>,
+// #dscp-<slug>-<dscp> and #rpc-<slug>.
+//
+// MaxPoints, if positive, limits the number of points plotted for each series
+// by plotting only every nth point. This may be used to keep the size of the
+// HTML manageable for long Tests.
+//
+// DataTable, if true, adds a collapsible table of the plotted data below the
+// chart, and a button to download it as CSV, so the numbers are available
+// without the raw result data. This is also supported by ChartsRRUL,
+// ChartsStation, ChartsTin, ChartsTCPInfo and ChartsFCT.
+//
+// FlowLabelMeta, if true, appends a summary of each Flow's declared metadata
+// (direction, CCA, DSCP, ECN, and client and server Nodes) to the labels of
+// Flows that don't have a label in FlowLabel, e.g. "up-be (up, cubic,
+// c1 → s1)". The same summary is always shown in the Info column of the Flow
+// tables. This is also supported by ChartsRRUL.
 #ChartsTimeSeries: {
 	FlowLabel?: {
 		[=~".*"]: string
 	}
-	To:      [string & !="", ...string & !=""] | *["timeseries.html"]
+	FlowLabelMeta: bool | *false
+	To:            [string & !="", ...string & !=""] | *["timeseries.html"]
+	MaxPoints?:    int & >=0
+	FlowTo?:       string & =~"%s"
+	DataTable:     bool | *false
 	Options: {...} & {
 		title: string | *"Time Series"
 		titleTextStyle: {
@@ -342,6 +1169,285 @@ _IDregex: "[a-zA-Z0-9][a-zA-Z0-9_-]*"
 	}
 }
 
+// antler.ChartsRRUL runs a Go template to plot results in the layout of
+// flent's RRUL test, with stacked charts for download throughput, upload
+// throughput and latency, followed by a table of summary statistics. It is
+// intended for use with the rrul Workload Preset, but works with any mix of
+// stream and packet flows. Streams are plotted by Direction, with a Total
+// series if there is more than one, and packet flows are plotted by round-trip
+// time.
+//
+// Step is the time interval used to calculate throughput, and defaults to
+// 200ms, as in flent.
+//
+// Options are applied to each chart, and may be used to set any Configuration
+// Options that Google Charts supports:
+//
+// https://developers.google.com/chart/interactive/docs/gallery/linechart#configuration-options
+#ChartsRRUL: {
+	FlowLabel?: {
+		[=~".*"]: string
+	}
+	FlowLabelMeta: bool | *false
+	To:            [string & !="", ...string & !=""] | *["rrul.html"]
+	Title:         string | *"Realtime Response Under Load"
+	Step:          #Duration | *"200ms"
+	DataTable:     bool | *false
+	Options:       {...} & {
+		titleTextStyle: {
+			fontSize: 16
+			...
+		}
+		width:     1280
+		height:    360
+		lineWidth: 1
+		vAxis: {
+			titleTextStyle: {
+				italic: bool | *false
+				...
+			}
+			viewWindow: {
+				min: float | *0
+				...
+			}
+			baselineColor: string | *"#cccccc"
+			gridlines: {
+				color: string | *"transparent"
+				...
+			}
+			...
+		}
+		hAxis: {
+			title: string | *"Time (sec)"
+			titleTextStyle: {
+				italic: bool | *false
+				...
+			}
+			viewWindow: {
+				min: int | *0
+				...
+			}
+			baselineColor: string | *"#cccccc"
+			gridlines: {
+				color: string | *"transparent"
+				...
+			}
+			...
+		}
+		chartArea: {
+			backgroundColor: string | *"#f7f7f7"
+			width:           string | *"80%"
+			...
+		}
+		explorer: {
+			actions:   [...string] | *["dragToZoom", "rightClickToReset"]
+			maxZoomIn: float | *0.001
+			...
+		}
+		...
+	}
+}
+
+// antler.ChartsStation runs a Go template to plot the wireless station
+// statistics recorded by Station runners, with charts for PHY rate, signal,
+// retries and airtime (if reported by the driver), followed by a table of
+// summary statistics for each station. Options are applied to each chart, and
+// may be used to set any Configuration Options that Google Charts supports:
+//
+// https://developers.google.com/chart/interactive/docs/gallery/linechart#configuration-options
+#ChartsStation: {
+	To:        [string & !="", ...string & !=""] | *["station.html"]
+	Title:     string | *"Wireless Stations"
+	DataTable: bool | *false
+	Options:   {...} & {
+		titleTextStyle: {
+			fontSize: 16
+			...
+		}
+		width:     1280
+		height:    360
+		lineWidth: 1
+		vAxis: {
+			titleTextStyle: {
+				italic: bool | *false
+				...
+			}
+			viewWindow: {
+				min: float | *0
+				...
+			}
+			baselineColor: string | *"#cccccc"
+			gridlines: {
+				color: string | *"transparent"
+				...
+			}
+			...
+		}
+		hAxis: {
+			title: string | *"Time (sec)"
+			titleTextStyle: {
+				italic: bool | *false
+				...
+			}
+			baselineColor: string | *"#cccccc"
+			gridlines: {
+				color: string | *"transparent"
+				...
+			}
+			...
+		}
+		chartArea: {
+			backgroundColor: string | *"#f7f7f7"
+			width:           string | *"80%"
+			...
+		}
+		explorer: {
+			actions:   [...string] | *["dragToZoom", "rightClickToReset"]
+			maxZoomIn: float | *0.001
+			...
+		}
+		...
+	}
+}
+
+// antler.ChartsTin runs a Go template to plot the per-tin statistics of cake
+// and fq_codel qdiscs recorded by TinStats runners, with charts for average
+// and peak delay (cake only), backlog, throughput, and drop and mark rates,
+// followed by a table of summary statistics for each tin. Options are applied
+// to each chart, and may be used to set any Configuration Options that Google
+// Charts supports:
+//
+// https://developers.google.com/chart/interactive/docs/gallery/linechart#configuration-options
+#ChartsTin: {
+	To:        [string & !="", ...string & !=""] | *["tin.html"]
+	Title:     string | *"Qdisc Tins"
+	DataTable: bool | *false
+	Options:   {...} & {
+		titleTextStyle: {
+			fontSize: 16
+			...
+		}
+		width:     1280
+		height:    360
+		lineWidth: 1
+		vAxis: {
+			titleTextStyle: {
+				italic: bool | *false
+				...
+			}
+			viewWindow: {
+				min: float | *0
+				...
+			}
+			baselineColor: string | *"#cccccc"
+			gridlines: {
+				color: string | *"transparent"
+				...
+			}
+			...
+		}
+		hAxis: {
+			title: string | *"Time (sec)"
+			titleTextStyle: {
+				italic: bool | *false
+				...
+			}
+			baselineColor: string | *"#cccccc"
+			gridlines: {
+				color: string | *"transparent"
+				...
+			}
+			...
+		}
+		chartArea: {
+			backgroundColor: string | *"#f7f7f7"
+			width:           string | *"80%"
+			...
+		}
+		explorer: {
+			actions:   [...string] | *["dragToZoom", "rightClickToReset"]
+			maxZoomIn: float | *0.001
+			...
+		}
+		...
+	}
+}
+
+// antler.ChartsTCPInfo runs a Go template to plot the TCPInfo sampled from the
+// sender of each stream with TCPInfoInterval set, for analysis of congestion
+// control behavior. There are charts for cwnd, RTT and min RTT, delivery and
+// pacing rates, retransmitted bytes (as a percent of bytes acked), CE marks (as
+// a percent of delivered segments), the send window, and the time limited by
+// the receive window and send buffer (as a percent of busy time), followed by a
+// table of summary statistics for each stream. Percentages are calculated
+// between plotted samples. Some fields require recent kernels, and are omitted
+// when zero.
+//
+// MaxPoints, if positive, limits the number of points plotted for each series
+// by plotting only every nth sample. Options are applied to each chart, and
+// may be used to set any Configuration Options that Google Charts supports:
+//
+// https://developers.google.com/chart/interactive/docs/gallery/linechart#configuration-options
+#ChartsTCPInfo: {
+	FlowLabel?: {
+		[=~".*"]: string
+	}
+	FlowLabelMeta: bool | *false
+	To:            [string & !="", ...string & !=""] | *["tcpinfo.html"]
+	Title:         string | *"TCP Info"
+	MaxPoints?:    int & >=0
+	DataTable:     bool | *false
+	Options:       {...} & {
+		titleTextStyle: {
+			fontSize: 16
+			...
+		}
+		width:     1280
+		height:    360
+		lineWidth: 1
+		vAxis: {
+			titleTextStyle: {
+				italic: bool | *false
+				...
+			}
+			viewWindow: {
+				min: float | *0
+				...
+			}
+			baselineColor: string | *"#cccccc"
+			gridlines: {
+				color: string | *"transparent"
+				...
+			}
+			...
+		}
+		hAxis: {
+			title: string | *"Time (sec)"
+			titleTextStyle: {
+				italic: bool | *false
+				...
+			}
+			baselineColor: string | *"#cccccc"
+			gridlines: {
+				color: string | *"transparent"
+				...
+			}
+			...
+		}
+		chartArea: {
+			backgroundColor: string | *"#f7f7f7"
+			width:           string | *"80%"
+			...
+		}
+		explorer: {
+			actions:   [...string] | *["dragToZoom", "rightClickToReset"]
+			maxZoomIn: float | *0.001
+			...
+		}
+		...
+	}
+}
+
 // antler.ChartsFCT runs a Go template to create a scatter plot of flow
 // completion time vs length. The Options field may be used to set any
 // Configuration Options that Google Charts supports:
@@ -353,6 +1459,7 @@ _IDregex: "[a-zA-Z0-9][a-zA-Z0-9_-]*"
 	}
 	To: [string & !="", ...string & !=""]
 	Series?: [...#FlowSeries]
+	DataTable: bool | *false
 	Options: {...} & {
 		title: string | *"Flow Completion Time vs Length"
 		titleTextStyle: {
@@ -412,6 +1519,70 @@ _IDregex: "[a-zA-Z0-9][a-zA-Z0-9_-]*"
 	}
 }
 
+// antler.ChartsCDF runs a Go template to plot the CDF of a distribution, for
+// each flow or series. It requires Analyze before it in the pipeline. Metric
+// selects the distribution:
+//
+//   - OWD: the one-way delay of each packet flow, with a series for each
+//     direction (down is dashed)
+//   - RTT: the round-trip time of each packet flow
+//   - FCT: the flow completion time of the streams in each of Series, or all
+//     streams if Series is empty
+//
+// CCDF, if true, plots the complementary CDF (the fraction of values greater
+// than or equal to each value), and LogScale, if true, uses a log scale for
+// the probability axis, which together show tail behavior in the most detail.
+//
+// MaxPoints, if positive, limits the number of points plotted for each series,
+// by plotting evenly spaced quantiles. The Options field may be used to set
+// any Configuration Options that Google Charts supports:
+//
+// https://developers.google.com/chart/interactive/docs/gallery/linechart#configuration-options
+#ChartsCDF: {
+	To:       [string & !="", ...string & !=""]
+	Metric:   "OWD" | "RTT" | "FCT"
+	CCDF:     bool | *false
+	LogScale: bool | *false
+	FlowLabel?: {
+		[=~".*"]: string
+	}
+	Series?: [...#FlowSeries]
+	MaxPoints: int & >=0 | *1000
+	DataTable: bool | *false
+	Options: {...} & {
+		titleTextStyle: {
+			fontSize: 18
+			...
+		}
+		width:            1280
+		height:           720
+		interpolateNulls: true
+		hAxis: {
+			titleTextStyle: {
+				italic: bool | *false
+				...
+			}
+			baselineColor: string | *"#cccccc"
+			...
+		}
+		vAxis: {
+			titleTextStyle: {
+				italic: bool | *false
+				...
+			}
+			baselineColor: string | *"#cccccc"
+			...
+		}
+		chartArea: {
+			backgroundColor: string | *"#f7f7f7"
+			top:             int | *100
+			width:           string | *"80%"
+			...
+		}
+		...
+	}
+}
+
 // antler.FlowSeries groups Flows into a chart series named Name, using the
 // given Pattern, an RE2 regular expression:
 //
@@ -428,6 +1599,17 @@ _IDregex: "[a-zA-Z0-9][a-zA-Z0-9_-]*"
 	Consume: bool | *true
 }
 
+// antler.CustomReport runs a reporter from an external package, registered
+// with antler.RegisterReporter under Name, so reports may be added (e.g. for
+// the data from proprietary devices) without forking antler. Config is any
+// value, which is passed to the reporter's factory as JSON. The antler
+// executable must be built with the registering package imported, and vet
+// checks that Name is registered.
+#CustomReport: {
+	Name:    string & !=""
+	Config?: _
+}
+
 // antler.MultiReport contains one definition for a multi-Test report.
 // MultiReports process all the data streams from the Tests they are run for.
 // Their input comes from the output of the Test.After pipeline, so that
@@ -443,7 +1625,9 @@ _IDregex: "[a-zA-Z0-9][a-zA-Z0-9_-]*"
 #MultiReport: {
 	ID?: [string & =~_IDregex]: string & =~_IDregex
 
-	Index?: #Index
+	Index?:               #Index
+	JUnit?:               #JUnit
+	AggregateIterations?: #AggregateIterations
 }
 
 // antler.Index is a MultiReport that generates an index page for Tests.
@@ -453,18 +1637,70 @@ _IDregex: "[a-zA-Z0-9][a-zA-Z0-9_-]*"
 // GroupBy is a Test ID key used to separate Tests into groups. It is
 // recommended that Tests in a group share the same TestID keys.
 //
+// SubGroupBy is a list of further Test ID keys used to separate the Tests in
+// each group into nested subgroups, in order (e.g. GroupBy "qdisc" and
+// SubGroupBy ["rtt"]). It requires GroupBy. Groups are shown in collapsible
+// sections, with summary rows containing the number of Tests, and the number
+// of Tests with errors.
+//
 // Title is a title for the index page.
 //
 // ExcludeFile is a list of glob patterns
 // (https://pkg.go.dev/path/filepath#Match) matching files to exclude from the
 // index.
+//
+// If any Test in a group has both stream and packet flows, a working latency
+// column is added, with the latency under load for each Test: the increase of
+// the p95 RTT of the packet flows while streams were active (loaded), over
+// their median RTT before the streams started (idle), followed by the idle,
+// p95 and p99 RTTs. Packet flows must start before the streams to measure the
+// idle RTT, unless the Test has an IdleProbe, in which case the idle RTT is
+// taken from its phase flows (see #IdleProbe).
 #Index: {
 	To:          string & !="" | *"index.html"
 	GroupBy?:    string & !=""
+	SubGroupBy?: [...string & !=""]
 	Title?:      string & !=""
 	ExcludeFile: [...string] | *["*.gob"]
 }
 
+// antler.JUnit is a MultiReport that writes a JUnit XML file for CI
+// integration (e.g. GitLab or Jenkins test result views), with a test case for
+// each Test, named by its Test ID. A test case fails if the Test's data
+// contains any errors, with the first error as the failure message, and all
+// errors in the failure text. The time for each test case is the time between
+// its first and last log entries. A Test that fails to run, which stops the
+// run, is included as a failed test case. Since the file is written to the
+// result directory, CI jobs may find it in the latest result, e.g.
+// results/latest/junit.xml.
+//
+// To is the path to the JUnit XML file to be generated.
+//
+// Suite is the name of the test suite, also used as the test case class name.
+#JUnit: {
+	To:    string & !="" | *"junit.xml"
+	Suite: string & !="" | *"antler"
+}
+
+// antler.AggregateIterations is a MultiReport that aggregates the summary
+// statistics of each flow (as written by EmitStats) across the iterations of
+// each Test with Iterations greater than one, for the mean, sample standard
+// deviation, and confidence interval of the mean, using Student's t
+// distribution. The per-iteration values are included. Tests are matched to
+// their iterations by ID, without the iteration key, and the results are
+// written as JSON under the Test's Path (before the iteration prefix).
+//
+// The statistics are taken from Analyze if it's in the After pipeline, or
+// calculated with its defaults otherwise.
+//
+// To is the name of the JSON file written under each Test's Path.
+//
+// Confidence is the confidence level of the intervals, from 0 to 1.
+#AggregateIterations: {
+	To:         string & !="" | *"aggregate.json"
+	Confidence: number & >0 & <1 | *0.95
+}
+
 //
 // node package
 //
@@ -486,6 +1722,7 @@ _IDregex: "[a-zA-Z0-9][a-zA-Z0-9_-]*"
 	Parallel?: [...#Run]
 	Schedule?: #Schedule
 	Child?:    #Child
+	Workload?: #Workload
 }
 
 // node.Schedule schedules execution of the given Runs, using the given
@@ -503,6 +1740,102 @@ _IDregex: "[a-zA-Z0-9][a-zA-Z0-9_-]*"
 	Run: [...#Run]
 }
 
+// node.Workload assembles a standard mix of flows from the current node to a
+// StreamServer at StreamAddr and a PacketServer at PacketAddr, so common
+// benchmark scenarios don't have to be rebuilt for each test. The servers must
+// be started separately. The Workload is expanded to a Parallel Run of
+// StreamClients and PacketClients when the config is loaded.
+//
+// Preset selects a standard mix, which sets the default number of each kind
+// of flow. Counts that are set explicitly, including zero, are used instead:
+//
+//   rrul:      4 Upload, 4 Download, 3 Probe, as in flent's RRUL test
+//   rrul-like: 4 Upload, 4 Download, 1 Probe
+//   bulk-rpc:  1 Upload, 1 Download, 1 RPC, 1 Probe
+//   upload:    1 Upload, 1 Probe
+//   download:  1 Download, 1 Probe
+//
+// Flows are named with Prefix, followed by the kind of flow and a number
+// starting from 1, e.g. "up1", "down1", "rpc1" and "probe1". Flow IDs are
+// limited to 16 bytes, so Prefix should be short.
+//
+// The rrul Preset assigns DSCP classes to flows, which are named by their
+// class instead of a number. Uploads and Downloads use the BE, BK, CS5 and EF
+// classes (e.g. "up-be" and "down-ef"), and Probes the EF, BE and BK classes
+// (e.g. "probe-ef"). The IOSampleInterval and ProbeInterval default to 200ms.
+// The ChartsRRUL report plots the results in flent's RRUL layout.
+//
+// Probe flows send UDP echo requests of ProbeLength every ProbeInterval
+// (default 100ms). RPC flows send requests of RPCRequestLength (default 64)
+// every RPCInterval (default 100ms), and receive responses of
+// RPCResponseLength (default 1024).
+//
+// BufLen is the BufLen for Upload and Download flows, and defaults to
+// Tuning.TransferBufLen.
+#Workload: {
+	Preset:             *"" | "rrul" | "rrul-like" | "bulk-rpc" | "upload" | "download"
+	Prefix?:            string & =~"^[a-z][a-z0-9\\.-]*$"
+	StreamAddr?:        string & !=""
+	PacketAddr?:        string & !=""
+	Duration:           #Duration | *"1m"
+	Upload:             int & >=0 | *_workloadPreset[Preset].Upload
+	Download:           int & >=0 | *_workloadPreset[Preset].Download
+	RPC:                int & >=0 | *_workloadPreset[Preset].RPC
+	Probe:              int & >=0 | *_workloadPreset[Preset].Probe
+	CCA?:               string & !=""
+	IOSampleInterval?:  #Duration
+	TCPInfoInterval?:   #Duration
+	BufLen:             int & >0 | *Tuning.TransferBufLen
+	RPCInterval?:       #Duration
+	RPCRequestLength?:  int & >=0
+	RPCResponseLength?: int & >=0
+	ProbeInterval?:     #Duration
+	ProbeLength?:       int & >=0
+}
+
+// _workloadPreset is the number of each kind of flow for each Workload Preset,
+// where "" is no Preset.
+_workloadPreset: [string]: {
+	Upload:   int
+	Download: int
+	RPC:      int
+	Probe:    int
+}
+_workloadPreset: {
+	"": {Upload: 0, Download: 0, RPC: 0, Probe: 0}
+	rrul: {Upload: 4, Download: 4, RPC: 0, Probe: 3}
+	"rrul-like": {Upload: 4, Download: 4, RPC: 0, Probe: 1}
+	"bulk-rpc": {Upload: 1, Download: 1, RPC: 1, Probe: 1}
+	upload: {Upload: 1, Download: 0, RPC: 0, Probe: 1}
+	download: {Upload: 0, Download: 1, RPC: 0, Probe: 1}
+}
+
+// node.IdleProbe adds idle latency probe phases around a Test's Run, so every
+// Test gets a baseline RTT without manual changes to its Run tree. When the
+// config is loaded, the Run is wrapped in a Serial Run that starts a
+// PacketServer on ListenAddr on the Server node, then runs a PacketClient on
+// the Client node that sends echo requests to Addr every Interval for Duration,
+// before (Pre) and after (Post) the Run. ListenAddr must differ from that of
+// any PacketServer on the same node in the Run.
+//
+// The phase flows are named with Prefix followed by "idle-pre" and
+// "idle-post", and tagged with "idle": "pre" or "post". When they're present,
+// the working latency analysis takes its idle RTT samples from them, rather
+// than from packet flows before the streams start (see #Index). Length is the
+// echo request length, and if 0, the minimum length is used.
+#IdleProbe: {
+	Client:     #Node
+	Server:     #Node
+	ListenAddr: string & !=""
+	Addr:       string & !=""
+	Prefix:     string & =~"^([a-z][a-z0-9\\.-]*)?$" | *""
+	Duration:   #Duration | *"5s"
+	Interval:   #Duration | *"100ms"
+	Length:     int & >=0 | *0
+	Pre:        bool | *true
+	Post:       bool | *true
+}
+
 // node.Child defines a Run to execute on a child Node. In this way, entire Run
 // hierarchies may be passed to a child Node at once. Nodes are launched
 // automatically and recursively at the start of each Test by walking the Run
@@ -534,7 +1867,7 @@ _IDregex: "[a-zA-Z0-9][a-zA-Z0-9_-]*"
 
 // node.Launchers lists the available ways to start a node.
 //
-// One of either Local or SSH must be specified.
+// One of Local, SSH or Container must be specified.
 //
 // If Local is specified, the node will be launched in a separate process on
 // the local machine, using stdio for communication.
@@ -542,23 +1875,56 @@ _IDregex: "[a-zA-Z0-9][a-zA-Z0-9_-]*"
 // If SSH is specified, the node will be executed on a host via the ssh command.
 // Destination specifies the destination as given to the ssh binary, if
 // different from the Node ID. It must be possible to connect to the ssh
-// destination without a password.
+// destination without a password. Port sets the remote port. ProxyJump lists
+// jump hosts (bastions) to connect through, separated by commas, as for
+// ssh -J, and ProxyCommand sets a command to connect with instead, as in
+// ssh_config(5). IdentityFile sets the private key file, as for ssh -i, and
+// ConfigFile sets an alternative ssh_config file, as for ssh -F, in which
+// hosts, jump hosts and keys may instead be configured.
 //
 // For Linux, the root user is required to use network namespaces. Sudo may be
 // set to true to run the node with the sudo command, which must then be
 // configured to not require a password.
 //
+// If Container is specified, the node will be executed inside a container
+// from Image, using Runtime (podman or docker). The node executable is
+// mounted into the container, so the image needn't have antler installed, and
+// the container is removed when the node exits. Network sets the container's
+// network (e.g. "host"), Capability lists Linux capabilities to add (e.g.
+// "NET_ADMIN", which is required to configure qdiscs and addresses), Volume
+// lists volumes to mount, in the runtime's --volume syntax, and Arg lists
+// additional arguments for the runtime's run command. If Privileged is true,
+// the container runs in privileged mode, and if Sudo is true, the runtime is
+// run with sudo. Env Vars are passed to the container, but Netns is not
+// supported. The image's platform must match the Node's Platform.
+//
 // The Set fields are for internal use and must not be changed.
 #Launchers: {
 	SSH?: {
-		Destination?: string & !=""
-		Sudo:         bool | *false
-		Set:          true
+		Destination?:  string & !=""
+		Sudo:          bool | *false
+		Port?:         int & >0 & <=65535
+		ProxyJump?:    string & !=""
+		ProxyCommand?: string & !=""
+		IdentityFile?: string & !=""
+		ConfigFile?:   string & !=""
+		Set:           true
 	}
 	Local?: {
 		Sudo: bool | *false
 		Set:  true
 	}
+	Container?: {
+		Runtime:     *"podman" | "docker" | string & !=""
+		Image:       string & !=""
+		Network?:    string & !=""
+		Capability?: [...string & !=""] & list.MaxItems(16)
+		Volume?:     [...string & !=""] & list.MaxItems(16)
+		Arg?:        [...string & !=""] & list.MaxItems(16)
+		Privileged:  bool | *false
+		Sudo:        bool | *false
+		Set:         true
+	}
 }
 
 // node.Netns may be set to launch the node in a Linux network namespace.
@@ -590,36 +1956,90 @@ _IDregex: "[a-zA-Z0-9][a-zA-Z0-9_-]*"
 // node.Runners lists the Runners available for execution. Each is documented
 // further in its corresponding value definition.
 #Runners: {
-	Sleep?:        #Duration
-	ResultStream?: #ResultStream
-	SysInfo?:      #SysInfo
-	System?:       #System
-	PacketClient?: #PacketClient
-	PacketServer?: #PacketServer
-	StreamClient?: #StreamClient
-	StreamServer?: #StreamServer
+	Sleep?:            #Duration
+	ResultStream?:     #ResultStream
+	SysInfo?:          #SysInfo
+	System?:           #System
+	PacketClient?:     #PacketClient
+	PacketServer?:     #PacketServer
+	StreamClient?:     #StreamClient
+	StreamServer?:     #StreamServer
+	Profile?:          #Profile
+	Watchdog?:         #Watchdog
+	Station?:          #Station
+	Clock?:            #Clock
+	Rendezvous?:       #Rendezvous
+	HTTPClient?:       #HTTPClient
+	HTTPServer?:       #HTTPServer
+	QUICStreamClient?: #QUICStreamClient
+	QUICStreamServer?: #QUICStreamServer
+	SetSysctl?:        #SetSysctl
+	KernelModule?:     #KernelModule
+	PacketCapture?:    #PacketCapture
+	QdiscStats?:       #QdiscStats
+	Fetch?:            #Fetch
+	TinStats?:         #TinStats
+	Netem?:            #Netem
+	Topology?:         #Topology
+	Prereq?:           #Prereq
+	IRTTClient?:       #IRTTClient
+	Custom?:           #Custom
 }
 
 // node.Duration is a time duration with mandatory units, as defined here:
 //
 // https://pkg.go.dev/time#ParseDuration
-#Duration: string & =~"^([0-9]*\\.)?[0-9]+(ns|us|µs|ms|s|m|h)$"
+//
+// Units may be combined, e.g. "2h30m" or "1m30.5s".
+#Duration: string & =~"^(([0-9]*\\.)?[0-9]+(ns|us|µs|ms|s|m|h))+$"
+
+// metric.Bitrate is a bitrate, which may be given as an int in bits per
+// second, or as a string with a decimal number and unit, e.g. "1.5Mbps",
+// "100Kbit" or "10G". The unit prefixes K, M, G and T are powers of 1000, and
+// the optional unit suffix may be bps, bit or bit/s.
+#Bitrate: string & =~"^([0-9]*\\.)?[0-9]+([KkMmGgTt]?(bps|bit|bit/s)|[KkMmGgTt])?$"
+
+// metric.Bytes is a number of bytes, which may be given as an int, or as a
+// string with a decimal number and unit, e.g. "250KB", "1.5MB" or "4MiB". SI
+// units (kB, MB, GB, TB and PB) are powers of 1000, and IEC units (KiB, MiB,
+// GiB, TiB and PiB) are powers of 1024. The trailing B may be omitted.
+#Bytes: string & =~"^([0-9]*\\.)?[0-9]+([KkMmGgTtPp]i?B?|B)?$"
 
 // node.Flow is a string flow identifier. Flow identifiers give a relevant
 // label to a network flow (e.g. for TCP and UDP, a 5-tuple of protocol,
 // src/dst host and src/dst port). To establish a readable convention, flow
 // identifiers are lowercase, must start with a-z, and may use digits 0-9,
-// '.' or '-'. They are limited to 16 characters, as they may be passed in
-// data points. Flow identifiers are best kept small to reduce the size,
-// transfer and processing time of results.
-#Flow: string & !="" & =~"^[a-z][a-z0-9\\.-]{0,16}$"
+// '.' or '-'. They are limited to 16 characters (node.maxFlowID), as they are
+// sent in test packets and data points. Flow identifiers are best kept small
+// to reduce the size, transfer and processing time of results.
+#Flow: string & !="" & =~"^[a-z][a-z0-9\\.-]{0,15}$"
+
+// #FlowTemplate is a Go template for a Flow, executed with node.FlowNameData.
+// The Flow of a client runner (Upload, Download, RPC, PacketClient or
+// HTTPClient) may be a template instead of a #Flow, e.g.
+// "{{.Test.cca}}-{{.Role}}{{.Index}}", which is expanded when the config is
+// loaded. Test is the Test ID, Node is the ID of the client's Node, Role is
+// up, down, rpc, packet or http, and Index is the client's position among
+// those with the same Role in the Test, from 1. The output is lowercased, and
+// invalid characters are replaced with '-'. Names longer than 16 bytes are
+// shortened to fit, by truncating them and appending '-' and four hex digits
+// from a hash of the full name. Reports label the generated Flows by their
+// full names, unless a FlowLabel is set for them.
+#FlowTemplate: string & =~"\\{\\{"
+
+// #ClientFlow is the Flow of a client runner, which may be a #FlowTemplate.
+#ClientFlow: #Flow | #FlowTemplate
 
 // node.ResultStream defines Include and Exclude filters that select which
 // results are included and excluded from realtime streaming during a Test.
-// Additional documentation is in #MessageFilter.
+// Additional documentation is in #MessageFilter. MaxRate, if set, caps the
+// bitrate in bits/sec of the streamed results sent by the node, so that
+// streaming doesn't compete meaningfully with measurement traffic on shared
+// links. Control messages, such as the final cancel, are not limited.
 #ResultStream: {
 	Include?: #MessageFilter
 	Exclude?: #MessageFilter
+	MaxRate?: int & >0 | #Bitrate
 }
 
 // node.MessageFilter selects results (messages) based on some simple type and
@@ -637,7 +2057,7 @@ _IDregex: "[a-zA-Z0-9][a-zA-Z0-9_-]*"
 #MessageFilter: {
 	File?: [...string]
 	Log?:  bool
-	Flow?: #Flow
+	Flow?: [...#Flow]
 	All?:  bool
 }
 
@@ -683,6 +2103,278 @@ _IDregex: "[a-zA-Z0-9][a-zA-Z0-9_-]*"
 // node.Sysctls represents a list of patterns of sysctl parameter names.
 #Sysctls: [...string & !=""]
 
+// node.Watchdog records the node's goroutine count and open file descriptors
+// when run, and compares them after the node's runners are canceled at the end
+// of the Test, logging any increase as a possible leak. It should be the first
+// runner on a node. Settle is the maximum time to wait for the counts to return
+// to the baseline. If Stacks is true, a goroutine dump is saved to
+// goroutines_<nodeID>.txt when a leak is found. If Fail is true, a leak is an
+// error instead of a log entry.
+#Watchdog: {
+	Settle: #Duration | *"1s"
+	Stacks: bool | *false
+	Fail:   bool | *false
+}
+
+// node.Station samples wireless station statistics for a mac80211 Interface
+// every Interval, using 'iw dev <Interface> station dump', from when it's run
+// until the end of the Test. A StationInfo data point is recorded for each
+// associated station, with its signal, PHY rates, retries, failures and
+// airtime (if reported by the driver). It should be placed before the runners
+// that generate traffic. The ChartsStation report plots the results.
+#Station: {
+	Interface: string & !=""
+	Interval:  #Duration | *"1s"
+}
+
+// node.Clock selects the PTP hardware clock (PHC) at the device path PHC
+// (e.g. "/dev/ptp0") as the time source for timestamps on a node, instead of
+// the system clock. With PTP or GPS disciplined PHCs on each node, this brings
+// inter-node OWD measurements to microsecond accuracy. All nodes measuring
+// one-way delay between them must use the same time scale, and since PHCs
+// normally run in TAI, Offset may be set (e.g. to "37s") to convert to UTC if
+// other nodes use the system clock. Clock must be the first runner on a node,
+// and may not be used on the root node. If the PHC can't be read during the
+// Test, a warning is logged and the system clock is used from then on.
+#Clock: {
+	PHC:     string & !=""
+	Offset?: #Duration
+}
+
+// node.Rendezvous synchronizes the start of complementary Tests run by multiple
+// antler controllers (e.g. at two sites, one sending and one receiving across
+// a WAN), and exchanges Feedback between them. It's placed in a Serial Run
+// before the runners to start simultaneously, and returns at the common start
+// time.
+//
+// One controller is the coordinator, which sets Listen (e.g. ":7900") and waits
+// for Peers other controllers to connect, which set Addr to the coordinator's
+// address. Each controller has a unique Name. Peers retry connecting until
+// Timeout, and the coordinator waits up to Timeout for all Peers to join. When
+// all have joined, the start time is the coordinator's Delay later, and is
+// adjusted on each peer by its estimated clock offset from the coordinator. If
+// MaxOffset is set, the Test fails if a peer's clock offset exceeds it.
+//
+// TokenEnv, if not empty, is the name of an environment variable containing a
+// token, which must match between all controllers.
+//
+// The key/value pairs in Feedback, and any incoming Feedback with string,
+// boolean or numeric values, are sent to the other controllers. Feedback from
+// each other controller is returned with its keys prefixed by that controller's
+// Name and a dot, so e.g. a StreamClient may use an AddrKey of "siteb.addr" to
+// connect to the address given in Feedback by the controller named siteb.
+// Numeric values are returned as floating point.
+#Rendezvous: {
+	Name:       string & !=""
+	Listen?:    string & !=""
+	Addr?:      string & !=""
+	Peers:      int & >0 | *1
+	Delay:      #Duration | *"2s"
+	Timeout:    #Duration | *"5m"
+	MaxOffset?: #Duration
+	TokenEnv:   string | *""
+	Feedback: [string & !=""]: string
+}
+
+// node.Netem configures the root qdisc of Interface declaratively using tc,
+// with netem for Delay, Jitter, Loss and Rate, and/or an AQM qdisc given in tc
+// syntax (e.g. "cake bandwidth 50Mbit"). The root qdisc is deleted at the end
+// of the Test, even if it fails or is interrupted, restoring the interface's
+// default qdisc.
+//
+// If both netem parameters and an AQM are given, netem is the root qdisc
+// (handle 1:), and the AQM is its child (handle 10:), so packets are delayed
+// before they're enqueued to the AQM. The bottleneck rate is then normally set
+// in the AQM's parameters, rather than with Rate. Distribution selects the
+// delay distribution for Jitter, Loss is a percentage, ECN marks ECN capable
+// packets instead of dropping them for Loss, and Limit is netem's queue limit
+// in packets.
+#Netem: {
+	Interface:     string & !=""
+	Delay?:        #Duration
+	Jitter?:       #Duration
+	Distribution?: "uniform" | "normal" | "pareto" | "paretonormal"
+	Loss?:         number & >=0 & <=100
+	ECN?:          bool
+	Rate?:         int & >0 | #Bitrate
+	Limit?:        int & >0
+	AQM?:          string & !=""
+}
+
+// node.Topology builds a network topology declaratively using ip, from a graph
+// of network namespaces, veth links, addresses and routes, and tears it down at
+// the end of the Test, even if it fails or is interrupted. Namespaces are added
+// first, then Links, then Routes. Forward enables IP forwarding in a namespace,
+// so it may be used as a router. An empty Namespace in a Link endpoint or Route
+// refers to the node's own namespace. Address is in CIDR notation, and a Route
+// requires Via and/or Device. Child nodes may be launched in the namespaces
+// using Netns, by Name, with Create false.
+#Topology: {
+	Namespace?: [...#TopologyNamespace]
+	Link?: [...#TopologyLink]
+	Route?: [...#TopologyRoute]
+}
+
+// node.Custom runs a runner from an external package, registered with
+// node.RegisterRunner under Name, so instrumentation may be added (e.g. for
+// proprietary devices) without forking antler. Config is any value, which is
+// passed to the runner's factory as JSON. Data points sent by the runner must
+// have their types registered with node.RegisterData, and are passed to the
+// reporters and saved in the DataFile like builtin data types.
+//
+// To use custom runners, build a node executable from a copy of cmd/node that
+// imports the registering package, and an antler executable from a copy of
+// cmd/antler that imports it and calls antler.SetNodeExes with the directory
+// of the custom node executables.
+#Custom: {
+	Name:    string & !=""
+	Config?: _
+}
+
+// node.TopologyNamespace is a network namespace added by Topology.
+#TopologyNamespace: {
+	Name:    string & !=""
+	Forward: bool | *false
+}
+
+// node.TopologyLink is a veth link added by Topology, between endpoints A and
+// B, with an optional MTU for both.
+#TopologyLink: {
+	A:    #TopologyEndpoint
+	B:    #TopologyEndpoint
+	MTU?: int & >=68
+}
+
+// node.TopologyEndpoint is one end of a TopologyLink.
+#TopologyEndpoint: {
+	Namespace: string | *""
+	Interface: string & !="" & =~"^.{1,15}$"
+	Address?: [...string & !=""]
+}
+
+// node.TopologyRoute is a route added by Topology, to Destination in CIDR
+// notation, or "default".
+#TopologyRoute: {
+	Namespace:   string | *""
+	Destination: string & !=""
+	Via?:        string & !=""
+	Device?:     string & !=""
+}
+
+// node.SetSysctl sets sysctl kernel parameters, and restores their original
+// values at the end of the Test, even if it fails or is interrupted. TCPECN, if
+// not -1, sets net.ipv4.tcp_ecn, TCPCongestionControl sets the default CCA,
+// TCPRmem and TCPWmem set the min, default and max TCP buffer sizes, and
+// RmemMax and WmemMax set net.core.rmem_max and net.core.wmem_max. Other
+// parameters may be set in Param, by name
+// (e.g. "net.core.default_qdisc": "fq").
+#SetSysctl: {
+	TCPECN:                int & >=-1 & <=2 | *-1
+	TCPCongestionControl?: string & !=""
+	TCPRmem?: [int & >0, int & >0, int & >0]
+	TCPWmem?: [int & >0, int & >0, int & >0]
+	RmemMax?: int & >0
+	WmemMax?: int & >0
+	Param?: [string & !=""]: string
+}
+
+// node.KernelModule loads the kernel modules in Module with modprobe, if they
+// aren't already loaded, and should be placed before the runners that need
+// them. If Unload is true, the modules it loaded are unloaded at the end of the
+// Test, in which case it should normally be the first runner on the node.
+#KernelModule: {
+	Module: [...string & !=""]
+	Unload: bool | *false
+}
+
+// node.PacketCapture captures packets on Interface with an AF_PACKET socket,
+// from when it's run until the end of the Test, and sends the capture in pcap
+// format as FileData, which is saved by the SaveFiles reporter. Filter may be a
+// pcap-filter(7) expression, which requires tcpdump on the node to compile it.
+// Snaplen is the maximum number of bytes captured per packet. Buffer is the
+// size of the socket receive buffer, which drops packets if it overflows. If
+// Promiscuous is true, the interface is put in promiscuous mode. File is the
+// name of the pcap file, and defaults to <node ID>_<Interface>.pcap. Captured
+// packets are sent at least every FlushInterval.
+#PacketCapture: {
+	Interface:     string & !=""
+	Filter?:       string & !=""
+	Snaplen:       int & >0 | *128
+	Buffer:        int & >0 | *4194304
+	Promiscuous:   bool | *false
+	File?:         string & !=""
+	FlushInterval: #Duration | *"1s"
+}
+
+// node.QdiscStats samples the statistics for the qdiscs on Interface each
+// Interval using rtnetlink, from when it's run until the end of the Test, and
+// sends a QdiscInfo data point for each qdisc (with the backlog, drops,
+// requeues, overlimits and, for AQMs that report them, ECN marks). It should
+// be placed after the qdiscs are configured.
+#QdiscStats: {
+	Interface: string & !=""
+	Interval:  #Duration | *"100ms"
+}
+
+// node.TinStats samples the per-tin statistics of the cake and fq_codel qdiscs
+// on Interface each Interval, using 'tc -s -j qdisc show dev <Interface>', from
+// when it's run until the end of the Test. A TinInfo data point is sent for
+// each cake tin (with its threshold rate, delays, backlog, drops, ECN marks,
+// ACK drops and flow counts), and for each fq_codel qdisc as a single tin. It
+// should be placed after the qdiscs are configured. The ChartsTin report plots
+// the results.
+#TinStats: {
+	Interface: string & !=""
+	Interval:  #Duration | *"100ms"
+}
+
+// node.Fetch reads the files or directories in Path from the node, and sends
+// them as FileData, which is saved by the SaveFiles reporter. Each Path may be
+// a glob pattern, and directories are fetched recursively. Fetched files are
+// named by Prefix (<node ID>_ by default), followed by their path relative to
+// the matched Path's parent directory, with slashes replaced by underscores.
+// MaxFileSize limits the bytes fetched from each file, truncating larger files,
+// and MaxSize limits the total bytes fetched, skipping files after it's
+// reached. If IgnoreMissing is true, a Path that matches no files is logged,
+// instead of causing an error.
+#Fetch: {
+	Path: [string & !="", ...string & !=""]
+	Prefix?:       string & !=""
+	MaxFileSize?:  int & >0 | #Bytes
+	MaxSize?:      int & >0 | #Bytes
+	IgnoreMissing: bool | *false
+}
+
+// node.Prereq checks for prerequisites on a node, and reports any that are
+// missing, without failing. Prereq runners are normally generated by the
+// preflight check that runs before any Tests with antler run --preflight, so
+// they're rarely needed in the config. Command lists executables that must be
+// in the PATH. NetAdmin requires CAP_NET_ADMIN. Sockdiag requires sock_diag
+// support, which is used for efficient TCPInfo sampling, though getsockopt is
+// used instead if it's missing. Module lists kernel modules that must be
+// available, and CCA lists TCP congestion control algorithms that must be
+// available.
+#Prereq: {
+	Command?: [...string & !=""]
+	NetAdmin?: bool
+	Sockdiag?: bool
+	Module?: [...string & !=""]
+	CCA?: [...string & !=""]
+}
+
+// node.Profile captures Go runtime profiles on a node, from when it's run until
+// the end of the Test, and should be the first runner on a node. If CPU is
+// true, a CPU profile is saved to cpu_<nodeID>.pprof. If Heap is true, a heap
+// profile is saved to heap_<nodeID>.pprof at the end of the Test. HTTP may be
+// set to a listen address (e.g. "localhost:6060") to serve the net/http/pprof
+// endpoints for the duration of the Test. Profiles may be viewed with
+// 'go tool pprof'.
+#Profile: {
+	CPU?:  bool
+	Heap?: bool
+	HTTP?: string & !=""
+}
+
 // node.System is a system command Runner. See the Go documentation in
 // node/system.go for explanations of each field. Often the Command field is
 // all that's required.
@@ -695,16 +2387,46 @@ _IDregex: "[a-zA-Z0-9][a-zA-Z0-9_-]*"
 	Kill?:         bool
 }
 
-// node.PacketClient
+// node.PacketClient. CountersKey, if set, is the key used in the returned
+// Feedback for the client's FlowCounters: the bytes and packets sent and
+// received, and the duration. The counters are logged with the Feedback at the
+// end of the Test, and may be Exported to the manifest, so simple results are
+// available even without a DataFile or the Analyze report.
 #PacketClient: {
 	Addr:          string & !=""
 	Protocol:      #PacketProtocol
-	Flow:          #Flow
+	Flow:          #ClientFlow
 	MaxPacketSize: #MaxPacketSize
 	Sender: [#PacketSenders, ...#PacketSenders]
 	DSCP?: int & <=0x3F
 	ECN?:  int & <=0x3
-	Sockopt?: [...#Sockopt]
+	Sockopt?:     [...#Sockopt]
+	Tags?:        #Tags
+	CountersKey?: string & !=""
+}
+
+// node.IRTTClient runs an irtt client (https://github.com/heistp/irtt) to the
+// irtt server at Addr, and emits its per-packet results as PacketInfo and
+// PacketIO data points for Flow, so the Analyze report and packet charts work
+// on irtt measurements, as for a PacketClient. The irtt server must be started
+// separately, e.g. with a System runner with Background set, running
+// "irtt server". Duration, Interval and Length are passed to irtt as -d, -i
+// and -l, and Arg lists any extra arguments to irtt client (e.g.
+// "--dscp=0xb8"). Command is the irtt executable.
+//
+// Server receive and send times are included if irtt reports them. They are by
+// the server's clock, so one-way delays are only meaningful if the clocks are
+// synchronized, or if ClockOffset is enabled in Analyze. The data points are
+// emitted after irtt completes.
+#IRTTClient: {
+	Addr:     string & !=""
+	Flow:     #ClientFlow
+	Duration: #Duration | *"10s"
+	Interval: #Duration | *"10ms"
+	Length?:  int & >=0
+	Arg?: [...string]
+	Command: string & !="" | *"irtt"
+	Tags?:   #Tags
 }
 
 // MaxPacketSize is the maximum size of a received packet for
@@ -715,6 +2437,9 @@ _IDregex: "[a-zA-Z0-9][a-zA-Z0-9_-]*"
 // node.PacketSenders
 #PacketSenders: {
 	Unresponsive?: #Unresponsive
+	Responsive?:   #Responsive
+	Isochronous?:  #Isochronous
+	Train?:        #Train
 }
 
 // node.Unresponsive
@@ -726,6 +2451,74 @@ _IDregex: "[a-zA-Z0-9][a-zA-Z0-9_-]*"
 	RandomLength?: bool
 	Duration:      #Duration
 	Echo:          bool | *false
+	DSCP?: [...int & >=0 & <=0x3F]
+}
+
+// node.Responsive sends packets at a rate that adapts to congestion, using the
+// RTT of echo replies, to emulate application-limited responsive UDP traffic
+// such as real-time media. Rates are in bits per second.
+//
+// Packets of Length bytes are sent for Duration, starting at InitialRate. For
+// each echo reply, the queueing delay is estimated as the RTT minus the minimum
+// RTT seen. While it's at or below DelayTarget, the rate increases by Increase
+// per RTT, up to MaxRate. When it's above DelayTarget, or packets sent before
+// the replied packet have no reply (assumed lost), the rate is multiplied by
+// Decrease, at most once per RTT, down to MinRate.
+#Responsive: {
+	Length:      int & >0 | *1200
+	Duration:    #Duration
+	InitialRate: int & >0 | #Bitrate | *1000000
+	MinRate:     int & >0 | #Bitrate | *100000
+	MaxRate:     int & >0 | #Bitrate | *10000000
+	Increase:    int & >0 | #Bitrate | *100000
+	Decrease:    number & >0 & <1 | *0.85
+	DelayTarget: #Duration | *"25ms"
+}
+
+// node.Isochronous sends packets of Length bytes every Interval for Duration,
+// to emulate VoIP flows. The defaults approximate G.711 with 20ms packets
+// (160 bytes of audio, plus RTP header). For Opus, a Length of around 100
+// bytes may be used.
+//
+// Jitter, if not zero, is the maximum time each packet is sent before or after
+// its nominal send time, selected uniformly at random. Jitter doesn't
+// accumulate, so the mean packet rate is unchanged.
+//
+// On and Off, if not zero, are the mean durations of talkspurts and silence
+// periods, which are exponentially distributed, and packets are only sent
+// during talkspurts. Both must be set to enable talkspurts. For conversational
+// speech, an On of "1s" and Off of "1.5s" are typical (ITU-T P.59 gives means
+// of 1.004s and 1.587s).
+//
+// Echo, if true, requests mirrored replies from the server, to measure RTT.
+#Isochronous: {
+	Interval: #Duration | *"20ms"
+	Length:   int & >0 | *172
+	Jitter:   #Duration | *"0s"
+	On:       #Duration | *"0s"
+	Off:      #Duration | *"0s"
+	Duration: #Duration
+	Echo:     bool | *false
+}
+
+// node.Train sends Trains trains of Count back-to-back packets of the given
+// Length, every Interval, to estimate the bottleneck capacity and available
+// bandwidth from their dispersion at the server. The Analyze report records
+// the estimates, and EmitMetrics writes them as <flow>.capacity_mbps and
+// <flow>.avail_bw_mbps, so a short probing Test may check the path with
+// Expect (e.g. probe.capacity_mbps>=90) before bulk tests.
+//
+// Capacity is estimated from the rates of the trains least affected by cross
+// traffic, and available bandwidth from the rates each train was sent and
+// received at, using a single bottleneck fluid model. Interval should be long
+// enough for each train to clear the bottleneck queue, and a larger Count
+// improves the available bandwidth estimate, but may cause loss at the
+// bottleneck. Both are estimates, and are best compared between runs.
+#Train: {
+	Count:    int & >=2 | *50
+	Length:   int & >0 | *(1500 - 20 - 8)
+	Interval: #Duration | *"100ms"
+	Trains:   int & >0 | *20
 }
 
 // node.PacketProtocol
@@ -738,11 +2531,15 @@ _IDregex: "[a-zA-Z0-9][a-zA-Z0-9_-]*"
 	MaxPacketSize: #MaxPacketSize
 }
 
-// node.StreamClient
+// node.StreamClient. CountersKey, if set, is the key used in the returned
+// Feedback for the connection's FlowCounters, as for PacketClient. The counters
+// are from the kernel's TCP_INFO, so the packets are TCP segments, including
+// pure ACKs, and the sent bytes are those acknowledged by the server.
 #StreamClient: {
-	Addr?:    string & !=""
-	AddrKey?: string & !=""
-	Protocol: #StreamProtocol
+	Addr?:        string & !=""
+	AddrKey?:     string & !=""
+	Protocol:     #StreamProtocol
+	CountersKey?: string & !=""
 	#Streamers
 }
 
@@ -750,6 +2547,28 @@ _IDregex: "[a-zA-Z0-9][a-zA-Z0-9_-]*"
 #Streamers: {
 	Upload?:   #Upload
 	Download?: #Download
+	RPC?:      #RPC
+}
+
+// node.RPC sends requests and receives responses over a persistent TCP
+// connection, and records the latency of each transaction. Requests are sent
+// every Interval for Duration, whether or not prior responses have arrived.
+// Interval, RequestLength and ResponseLength are cycled through sequentially,
+// or selected randomly if the corresponding Random field is true. Request
+// lengths include an eight byte header, and lengths may not exceed 16 MiB.
+#RPC: {
+	Flow:     #ClientFlow
+	Duration: #Duration | *"1m"
+	Interval: [#Duration, ...#Duration] | *["100ms"]
+	RandomInterval?: bool
+	RequestLength: [...int & >=0 & <=16777216 | #Bytes] | *[64]
+	RandomRequestLength?: bool
+	ResponseLength: [...int & >=0 & <=16777216 | #Bytes] | *[1024]
+	RandomResponseLength?: bool
+	CCA?:                  string & !=""
+	DSCP?:                 int & <=0x3F
+	ECN?:                  int & <=0x3
+	Sockopt?: [...#Sockopt]
 }
 
 // node.Upload
@@ -765,19 +2584,39 @@ _IDregex: "[a-zA-Z0-9][a-zA-Z0-9_-]*"
 }
 
 // node.transfer
+//
+// The CCA, DSCP, ECN and Sockopt fields from #Stream are set on the sender's
+// socket, which is the client's for an Upload, and the server's for a
+// Download. Receiver sets DSCP, ECN and Sockopt on the receiver's socket, e.g.
+// to mark ACKs, or set receive buffer sizes. The CCA can't be set for the
+// receiver, as it's only used by the sender.
+//
+// It's an error to set both the CCA and a generic Sockopt for TCP_CONGESTION,
+// or both the DSCP or ECN and a generic Sockopt for IP_TOS, or a MaxRate less
+// than Rate.
 #Transfer: {
-	Duration:          #Duration | *"1m"
-	Length?:           int & >0
-	IOSampleInterval?: #Duration
-	TCPInfoInterval?:  #Duration
-	BufLen:            int & >0 | *(1024 * 128)
+	Duration:            #Duration | *"1m"
+	Length?:             int & >0 | #Bytes
+	Rate?:               int & >0 | #Bitrate
+	MaxRate?:            int & >0 | #Bitrate
+	IOSampleInterval?:   #Duration
+	TCPInfoInterval?:    #Duration
+	TCPInfoMaxInterval?: #Duration
+	TCPInfoReceiver?:    bool
+	BufLen:              int & >0 | *Tuning.TransferBufLen
+	Receiver?: {
+		DSCP?: int & <=0x3F
+		ECN?:  int & <=0x3
+		Sockopt?: [...#Sockopt]
+	}
 	#Stream
 }
 
 // node.Stream defines a stream flow. Flow and Direction are described in their
 // corresponding definitions.
 //
-// CCA is the Congestion Control Algorithm to use.
+// CCA is the Congestion Control Algorithm to use. For an Upload or Download,
+// this and the other socket options are set by the sender (see #Transfer).
 //
 // DSCP is the value for the Differentiated services codepoint.  This value is
 // left shifted two places into the upper 6 bits of the former ToS byte /
@@ -789,13 +2628,23 @@ _IDregex: "[a-zA-Z0-9][a-zA-Z0-9_-]*"
 // the field for testing purposes.
 //
 // Sockopt may be used to set generic socket options.
+//
+// Tags are added to the StreamIO and TCPInfo data points for the Stream, and
+// may be used to select data in reports (see #FilterTag).
 #Stream: {
-	Flow:      #Flow
+	Flow:      #ClientFlow
 	Direction: #Direction
 	CCA?:      string & !=""
 	DSCP?:     int & <=0x3F
 	ECN?:      int & <=0x3
 	Sockopt?: [...#Sockopt]
+	Tags?:    #Tags
+}
+
+// node.Tags are arbitrary key/value pairs added to data points, which may be
+// used to select data in reports.
+#Tags: {
+	[string & !=""]: string
 }
 
 // node.Direction is the sense for a Stream, either "up" (client to server) or
@@ -828,11 +2677,68 @@ _IDregex: "[a-zA-Z0-9][a-zA-Z0-9_-]*"
 	Protocol:       #StreamProtocol
 }
 
+// node.QUICStreamClient runs a streamer (Upload, Download or RPC) over a QUIC
+// connection to a QUICStreamServer, using quic-go. It mirrors StreamClient, so
+// QUIC and TCP flows may be compared in the same Test. Congestion control is
+// quic-go's builtin CUBIC, so CCA and the other socket options may not be set,
+// and TCPInfo isn't available.
+#QUICStreamClient: {
+	Addr?:    string & !=""
+	AddrKey?: string & !=""
+	Protocol: #PacketProtocol
+	#Streamers
+}
+
+// node.QUICStreamServer listens for and handles QUIC connections from the
+// QUICStreamClient. ListenAddr and ListenAddrKey are as for StreamServer. The
+// server uses a self-signed certificate, which the client doesn't verify.
+#QUICStreamServer: {
+	ListenAddr?:    string & !=""
+	ListenAddrKey?: string & !=""
+	Protocol:       #PacketProtocol
+}
+
 // StreamProtocol is the protocol used for StreamClient and StreamServer. It
 // defaults to tcp, which may use IPv4 or IPv6, depending on the given address.
 // tcp4 or tcp6 forces the use of IPv4 or IPv6, respectively.
 #StreamProtocol: *"tcp" | "tcp4" | "tcp6"
 
+// node.HTTPServer is a Runner that serves objects of the requested length over
+// HTTP, for HTTPClient. ListenAddr and ListenAddrKey are as for StreamServer.
+#HTTPServer: {
+	ListenAddr?:    string & !=""
+	ListenAddrKey?: string & !=""
+	Protocol:       #StreamProtocol
+}
+
+// node.HTTPClient is a Runner that requests objects from an HTTPServer at Addr
+// (or the address in Feedback under AddrKey), and records the time to the
+// first byte and completion of each request as an HTTPRequest. Requests are
+// sent sequentially, waiting ThinkTime after each response, for Duration,
+// and/or until the number of Requests is reached, if not zero.
+//
+// Length and ThinkTime are cycled through sequentially, or selected randomly
+// if the corresponding Random field is true. Lists from a distribution may be
+// generated in a .cue.tmpl file, e.g. with lognRandBytes for object sizes, and
+// expRandDuration for think times. Lengths may not exceed 1 GiB.
+//
+// NewConnection, if true, uses a new connection for each request instead of
+// keeping it alive, and Timeout, if not zero, limits the time for each request.
+#HTTPClient: {
+	Addr?:    string & !=""
+	AddrKey?: string & !=""
+	Flow:     #ClientFlow
+	Duration: #Duration | *"1m"
+	Requests: int & >=0 | *0
+	Length: [int & >=0 & <=1073741824 | #Bytes,
+		...int & >=0 & <=1073741824 | #Bytes] | *[65536]
+	RandomLength?: bool
+	ThinkTime: [...#Duration] | *["1s"]
+	RandomThinkTime?: bool
+	NewConnection:    bool | *false
+	Timeout:          #Duration | *"0s"
+}
+
 //
 // Note on Templates
 //
@@ -5,6 +5,7 @@ package antler
 
 import (
 	_ "embed"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"math"
@@ -15,6 +16,7 @@ import (
 	"time"
 
 	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/build"
 	"cuelang.org/go/cue/cuecontext"
 	"cuelang.org/go/cue/load"
 	"github.com/heistp/antler/node/metric"
@@ -30,32 +32,118 @@ var configCUE string
 // Config is the Antler configuration, loaded from CUE.
 type Config struct {
 	Test        Tests
+	Order       string
 	MultiReport []MultiReport
 	Results     Results
 	Server      Server
+	Theme       Theme
+	Tuning      Tuning
+	Sweep       map[string]Sweep
 }
 
 // validate performs any programmatic generation and validation on the Config
 // that isn't possible to do with the schema in config.cue.
 func (c *Config) validate() (err error) {
+	if err = c.Test.sort(c.Order); err != nil {
+		return
+	}
 	if err = c.Test.validate(); err != nil {
 		return
 	}
+	if c.Test, err = c.Test.expandIterations(); err != nil {
+		return
+	}
 	for _, m := range c.MultiReport {
 		if err = m.validate(); err != nil {
 			return
 		}
 	}
+	if err = c.Results.validate(); err != nil {
+		return
+	}
+	if err = c.Theme.validate(); err != nil {
+		return
+	}
+	for _, s := range c.Sweep {
+		if err = s.validate(); err != nil {
+			return
+		}
+	}
+	c.setTheme()
 	return
 }
 
+// setTheme sets the Theme for all reporters and multiReporters that implement
+// themer.
+func (c *Config) setTheme() {
+	for i := range c.Test {
+		t := &c.Test[i]
+		for _, r := range []Report{t.DuringDefault, t.During, t.AfterDefault,
+			t.After} {
+			r.setTheme(c.Theme)
+		}
+	}
+	for i := range c.MultiReport {
+		if t, ok := c.MultiReport[i].multiReporter().(themer); ok {
+			t.setTheme(c.Theme)
+		}
+	}
+	c.Server.setTheme(c.Theme)
+}
+
+// reportConfig returns the Config-level settings that affect After reports.
+func (c *Config) reportConfig() reportConfig {
+	return reportConfig{c.Theme, c.Results.Codec}
+}
+
 // LoadConfig first executes templates in any .cue.tmpl files to create the
 // corresponding .cue files, then uses the CUE API to load and return the Antler
 // Config.
+//
+// The compiled config is cached in the user's cache directory (e.g.
+// ~/.cache/antler), and if the .cue files that CUE loaded, the tags and the
+// schema are unchanged, it's loaded from the cache instead of from CUE. The
+// cache is disabled if the ANTLER_NOCONFIGCACHE environment variable is set to
+// a non-empty value.
 func LoadConfig(cuecfg *load.Config) (cfg *Config, err error) {
-	if err = executeConfigTemplates(); err != nil {
+	defer func() {
+		err = exitError(ExitConfig, err)
+	}()
+	if err = executeConfigTemplates(cuecfg.Dir); err != nil {
 		return
 	}
+	cfg = &Config{}
+	c := newConfigCache(cuecfg)
+	if b := c.get(); b != nil {
+		if err = decodeConfig(b, cfg); err != nil {
+			return
+		}
+		err = cfg.validate()
+		return
+	}
+	var v cue.Value
+	var inst *build.Instance
+	if v, inst, err = compileConfig(cuecfg); err != nil {
+		return
+	}
+	if err = v.Decode(cfg); err != nil {
+		return
+	}
+	if c != nil {
+		var b []byte
+		if b, err = v.MarshalJSON(); err != nil {
+			return
+		}
+		c.put(b, inst)
+	}
+	err = cfg.validate()
+	return
+}
+
+// compileConfig loads the config with the CUE API, unifies it with the schema,
+// and returns the unified value, and the loaded instance.
+func compileConfig(cuecfg *load.Config) (value cue.Value, inst *build.Instance,
+	err error) {
 	// compile config schema
 	ctx := cuecontext.New()
 	s := ctx.CompileString(configCUE, cue.Filename("config.cue"))
@@ -64,37 +152,31 @@ func LoadConfig(cuecfg *load.Config) (cfg *Config, err error) {
 		return
 	}
 	// compile data value from the CUE app instance
-	inst := load.Instances([]string{}, cuecfg)[0]
+	inst = load.Instances([]string{}, cuecfg)[0]
 	d := ctx.BuildInstance(inst)
 	if d.Err() != nil {
 		err = d.Err()
 		return
 	}
 	// unify data and schema into CUE value
-	v := d.Unify(s)
-	if v.Err() != nil {
-		err = v.Err()
-		return
-	}
-	cfg = &Config{}
-	if err = v.Decode(cfg); err != nil {
-		return
-	}
-	err = cfg.validate()
+	value = d.Unify(s)
+	err = value.Err()
 	return
 }
 
-// executeConfigTemplates runs any .cue.tmpl files as Go templates, to create
-// their corresponding .cue files.
-func executeConfigTemplates() (err error) {
+// executeConfigTemplates runs any .cue.tmpl files in dir as Go templates, to
+// create their corresponding .cue files. If dir is empty, the current
+// directory is used.
+func executeConfigTemplates(dir string) (err error) {
 	var ff []string
-	if ff, err = filepath.Glob("*.cue" + templateExtension); err != nil {
+	p := filepath.Join(dir, "*.cue"+templateExtension)
+	if ff, err = filepath.Glob(p); err != nil {
 		return
 	}
-	f := configFunc{}
+	f := configFunc{dir}
 	var t *template.Template
 	for _, tf := range ff {
-		t = template.New(tf).Funcs(f.funcMap())
+		t = template.New(filepath.Base(tf)).Funcs(f.funcMap())
 		if t, err = t.ParseFiles(tf); err != nil {
 			return
 		}
@@ -135,8 +217,69 @@ func (u UnionError) Error() string {
 		u.Value, u.Set, u.Value)
 }
 
+// Number is a float64 config value. It implements json.Unmarshaler, which the
+// CUE decoder uses in place of its float64 conversion, as that conversion fails
+// for zero values.
+type Number float64
+
+// UnmarshalJSON implements json.Unmarshaler
+func (n *Number) UnmarshalJSON(b []byte) (err error) {
+	var f float64
+	if err = json.Unmarshal(b, &f); err != nil {
+		return
+	}
+	*n = Number(f)
+	return
+}
+
 // configFunc contains the template functions for .cue.tmpl config files.
 type configFunc struct {
+	// dir is the config directory, or empty for the current directory.
+	dir string
+}
+
+// inventory reads the named inventory file (e.g. of hosts, links and
+// addresses), so large testbeds may be generated from it instead of maintaining
+// node lists in CUE. Files ending in .json are decoded as JSON, to the values
+// for encoding/json's any. Files ending in .csv are read as CSV with a header
+// row, to a list of maps from the header fields to the values in each row.
+// Relative paths are relative to the config directory.
+func (c configFunc) inventory(name string) (inv any, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("inventory '%s': %w", name, err)
+		}
+	}()
+	p := name
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(c.dir, p)
+	}
+	var f *os.File
+	if f, err = os.Open(p); err != nil {
+		return
+	}
+	defer f.Close()
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		err = json.NewDecoder(f).Decode(&inv)
+	case ".csv":
+		var rr [][]string
+		if rr, err = csv.NewReader(f).ReadAll(); err != nil {
+			return
+		}
+		m := []map[string]string{}
+		for _, r := range rr[min(len(rr), 1):] {
+			c := make(map[string]string)
+			for i, h := range rr[0] {
+				c[h] = r[i]
+			}
+			m = append(m, c)
+		}
+		inv = m
+	default:
+		err = fmt.Errorf("unsupported format (use .json or .csv)")
+	}
+	return
 }
 
 // expRandFloat64 returns a list of n random numbers on an exponential
@@ -228,5 +371,7 @@ func (f configFunc) funcMap() template.FuncMap {
 		"expRandDuration": f.expRandDuration,
 		"lognRand":        f.lognRand,
 		"lognRandBytes":   f.lognRandBytes,
+		"inventory":       f.inventory,
+		"json":            f.jsonString,
 	}
 }
@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cuelang.org/go/cue/load"
+)
+
+// loadTestConfig writes a package with the given CUE source to a temporary
+// directory, and loads it with LoadConfig.
+func loadTestConfig(t *testing.T, src string) (*Config, error) {
+	t.Helper()
+	t.Setenv(configCacheEnv, "1")
+	d := t.TempDir()
+	b := []byte("package test\n\n" + src)
+	if err := os.WriteFile(filepath.Join(d, "test.cue"), b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return LoadConfig(&load.Config{Dir: d})
+}
+
+// TestConfigVet tests that valid configs load, and invalid configs are
+// rejected.
+func TestConfigVet(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		src   string
+		valid bool
+	}{
+		{"empty", ``, true},
+		{"collect", `Results: Collect: {URL: "http://archive:8080",
+			TokenEnv: "T"}`, true},
+		{"collect zero interval",
+			`Results: Collect: {URL: "http://archive:8080", TokenEnv: "T",
+			Interval: "0s"}`, false},
+		{"live", `Results: Live: {URL: "http://localhost:8080", Host: "a"}`,
+			true},
+		{"live host slash",
+			`Results: Live: {URL: "http://localhost:8080", Host: "a/b"}`,
+			false},
+		{"live host backslash",
+			`Results: Live: {URL: "http://localhost:8080", Host: "a\\b"}`,
+			false},
+		{"live zero interval",
+			`Results: Live: {URL: "http://localhost:8080", Interval: "0s"}`,
+			false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := loadTestConfig(t, c.src)
+			if c.valid && err != nil {
+				t.Fatalf("expected config to load: %s", err)
+			}
+			if !c.valid && err == nil {
+				t.Fatal("expected config to be rejected")
+			}
+		})
+	}
+}
+
+// TestConfigInventory tests that the inventory template function reads files
+// relative to the config directory, not the working directory.
+func TestConfigInventory(t *testing.T) {
+	t.Setenv(configCacheEnv, "1")
+	d := t.TempDir()
+	for n, s := range map[string]string{
+		"hosts.csv": "id,platform\na,linux-amd64\n",
+		"test.cue.tmpl": "package test\n\n" +
+			"{{range inventory \"hosts.csv\"}}" +
+			"_node: {{json .id}}: Platform: {{json .platform}}\n{{end}}",
+	} {
+		if err := os.WriteFile(filepath.Join(d, n), []byte(s),
+			0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(w) })
+	if _, err = LoadConfig(&load.Config{Dir: d}); err != nil {
+		t.Fatal(err)
+	}
+}
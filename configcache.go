@@ -0,0 +1,248 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"cuelang.org/go/cue/build"
+	"cuelang.org/go/cue/load"
+)
+
+// configCacheDir is the directory under os.UserCacheDir that contains the
+// compiled config cache files.
+const configCacheDir = "antler"
+
+// configCacheEnv is the environment variable that disables the config cache,
+// if set to a non-empty value.
+const configCacheEnv = "ANTLER_NOCONFIGCACHE"
+
+// configCache is a compiled config, saved so that unchanged configs may be
+// loaded without loading and unifying the CUE. There is one cache file per
+// config directory. Dir lists the directories that CUE loaded files from,
+// including those of the package in parent directories, imported packages and
+// the cue.mod directory. Key is a hash of the config schema, the CUE load tags,
+// and the names and contents of the .cue files in each of Dir, so the cache is
+// invalidated when any of them change, or files are added or removed. Config
+// is the JSON encoding of the unified CUE value.
+type configCache struct {
+	Key    string
+	Dir    []string
+	Config json.RawMessage
+
+	tags []string
+	path string
+}
+
+// newConfigCache returns a configCache for the given CUE load config. If the
+// cache is disabled, or can't be used (e.g. there is no user cache directory),
+// nil is returned.
+func newConfigCache(cuecfg *load.Config) *configCache {
+	if os.Getenv(configCacheEnv) != "" {
+		return nil
+	}
+	d := cuecfg.Dir
+	if d == "" {
+		d = "."
+	}
+	d, err := filepath.Abs(d)
+	if err != nil {
+		return nil
+	}
+	var u string
+	if u, err = os.UserCacheDir(); err != nil {
+		return nil
+	}
+	h := sha256.Sum256([]byte(d))
+	n := "config-" + hex.EncodeToString(h[:8]) + ".json"
+	return &configCache{tags: cuecfg.Tags,
+		path: filepath.Join(u, configCacheDir, n)}
+}
+
+// configCacheDirs returns the sorted directories that files were loaded from
+// for the given instance, and its imports.
+func configCacheDirs(inst *build.Instance) (dirs []string) {
+	m := make(map[string]bool)
+	seen := make(map[*build.Instance]bool)
+	var add func(i *build.Instance)
+	add = func(i *build.Instance) {
+		if seen[i] {
+			return
+		}
+		seen[i] = true
+		for _, f := range i.BuildFiles {
+			if filepath.IsAbs(f.Filename) {
+				m[filepath.Dir(f.Filename)] = true
+			}
+		}
+		for _, j := range i.Imports {
+			add(j)
+		}
+	}
+	add(inst)
+	if inst.Root != "" {
+		m[filepath.Join(inst.Root, "cue.mod")] = true
+		// directories between the root and the package directory may add
+		// files to the package
+		for d := inst.Dir; ; d = filepath.Dir(d) {
+			m[d] = true
+			if d == inst.Root || d == filepath.Dir(d) {
+				break
+			}
+		}
+	} else if inst.Dir != "" {
+		m[inst.Dir] = true
+	}
+	for d := range m {
+		dirs = append(dirs, d)
+	}
+	sort.Strings(dirs)
+	return
+}
+
+// configCacheKey returns the cache key for the .cue files in the given
+// directories.
+func configCacheKey(dirs, tags []string) (key string, err error) {
+	h := sha256.New()
+	h.Write([]byte(configCUE))
+	h.Write([]byte{0})
+	h.Write([]byte(strings.Join(tags, "\x00")))
+	h.Write([]byte{0})
+	for _, d := range dirs {
+		var ff []string
+		if ff, err = filepath.Glob(filepath.Join(d, "*.cue")); err != nil {
+			return
+		}
+		sort.Strings(ff)
+		for _, f := range ff {
+			var b []byte
+			if b, err = os.ReadFile(f); err != nil {
+				return
+			}
+			h.Write([]byte(f))
+			h.Write([]byte{0})
+			h.Write(b)
+			h.Write([]byte{0})
+		}
+	}
+	key = hex.EncodeToString(h.Sum(nil))
+	return
+}
+
+// get returns the cached compiled config, or nil if it isn't cached, is stale,
+// or the cache is nil.
+func (c *configCache) get() []byte {
+	if c == nil {
+		return nil
+	}
+	b, err := os.ReadFile(c.path)
+	if err != nil {
+		return nil
+	}
+	var f configCache
+	if err = json.Unmarshal(b, &f); err != nil || len(f.Dir) == 0 {
+		return nil
+	}
+	var k string
+	if k, err = configCacheKey(f.Dir, c.tags); err != nil || k != f.Key {
+		return nil
+	}
+	return f.Config
+}
+
+// put saves the compiled config for the given instance to the cache. Errors
+// are ignored, as the cache is only an optimization.
+func (c *configCache) put(config []byte, inst *build.Instance) {
+	var err error
+	c.Dir = configCacheDirs(inst)
+	if c.Key, err = configCacheKey(c.Dir, c.tags); err != nil {
+		return
+	}
+	c.Config = config
+	var b []byte
+	if b, err = json.Marshal(c); err != nil {
+		return
+	}
+	d := filepath.Dir(c.path)
+	if err = os.MkdirAll(d, 0o755); err != nil {
+		return
+	}
+	var f *os.File
+	if f, err = os.CreateTemp(d, "config-*.tmp"); err != nil {
+		return
+	}
+	_, err = f.Write(b)
+	if e := f.Close(); e != nil && err == nil {
+		err = e
+	}
+	if err != nil || os.Rename(f.Name(), c.path) != nil {
+		os.Remove(f.Name())
+	}
+}
+
+// decodeConfig decodes a compiled config into the Config. Numbers in values
+// of interface type are decoded as int if they're integers, otherwise float64,
+// as for decoding from CUE.
+func decodeConfig(config []byte, cfg *Config) (err error) {
+	d := json.NewDecoder(bytes.NewReader(config))
+	d.UseNumber()
+	if err = d.Decode(cfg); err != nil {
+		return
+	}
+	jsonNumbers(reflect.ValueOf(cfg).Elem())
+	return
+}
+
+// jsonNumbers recursively replaces any json.Number in values of interface type
+// with an int, if it's an integer, or else a float64.
+func jsonNumbers(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			jsonNumbers(v.Elem())
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				jsonNumbers(v.Field(i))
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			jsonNumbers(v.Index(i))
+		}
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			e := reflect.New(v.Type().Elem()).Elem()
+			e.Set(v.MapIndex(k))
+			jsonNumbers(e)
+			v.SetMapIndex(k, e)
+		}
+	case reflect.Interface:
+		if v.IsNil() {
+			return
+		}
+		e := v.Elem()
+		if n, ok := e.Interface().(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				v.Set(reflect.ValueOf(int(i)))
+			} else if f, err := n.Float64(); err == nil {
+				v.Set(reflect.ValueOf(f))
+			}
+			return
+		}
+		c := reflect.New(e.Type()).Elem()
+		c.Set(e)
+		jsonNumbers(c)
+		v.Set(c)
+	}
+}
@@ -0,0 +1,195 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/heistp/antler/node/metric"
+)
+
+// CopyProgress contains the progress of copying the result directory, when the
+// WorkDir and RootDir are on different filesystems.
+type CopyProgress struct {
+	// From is the directory being copied.
+	From string
+
+	// To is the destination directory.
+	To string
+
+	// Copied is the number of bytes copied so far.
+	Copied metric.Bytes
+
+	// Total is the total number of bytes to copy.
+	Total metric.Bytes
+}
+
+// ChecksumError is returned when a copied file's checksum doesn't match the
+// original.
+type ChecksumError struct {
+	Path string
+}
+
+// Error implements error
+func (c ChecksumError) Error() string {
+	return fmt.Sprintf("checksum mismatch after copying '%s'", c.Path)
+}
+
+// crossDevice returns true if err is from an operation that isn't supported
+// across filesystems, e.g. rename(2) or link(2).
+func crossDevice(err error) bool {
+	return errors.Is(err, syscall.EXDEV)
+}
+
+// moveDir moves the directory from to the path to, for when they're on
+// different filesystems. The contents are copied to a temporary directory next
+// to to, synced and verified by checksum, then the temporary directory is
+// renamed to to, and from is removed. If progress is not nil, it's called after
+// each file is copied. If an error occurs, from is left intact.
+func moveDir(from, to string, progress func(CopyProgress)) (err error) {
+	p := CopyProgress{From: from, To: to}
+	if err = filepath.WalkDir(from, func(path string, d fs.DirEntry,
+		err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		var i fs.FileInfo
+		if i, err = d.Info(); err != nil {
+			return err
+		}
+		p.Total += metric.Bytes(i.Size())
+		return nil
+	}); err != nil {
+		return
+	}
+	t := filepath.Join(filepath.Dir(to), "."+filepath.Base(to)+".tmp")
+	if err = os.RemoveAll(t); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(t)
+		}
+	}()
+	var dd []string
+	if err = filepath.WalkDir(from, func(path string, d fs.DirEntry,
+		err error) error {
+		if err != nil {
+			return err
+		}
+		var r string
+		if r, err = filepath.Rel(from, path); err != nil {
+			return err
+		}
+		dst := filepath.Join(t, r)
+		switch {
+		case d.IsDir():
+			var i fs.FileInfo
+			if i, err = d.Info(); err != nil {
+				return err
+			}
+			dd = append(dd, dst)
+			return os.Mkdir(dst, i.Mode().Perm())
+		case d.Type()&fs.ModeSymlink != 0:
+			var l string
+			if l, err = os.Readlink(path); err != nil {
+				return err
+			}
+			return os.Symlink(l, dst)
+		case d.Type().IsRegular():
+			var n int64
+			if n, err = copyFile(path, dst); err != nil {
+				return err
+			}
+			p.Copied += metric.Bytes(n)
+			if progress != nil {
+				progress(p)
+			}
+			return nil
+		}
+		return fmt.Errorf("unable to copy '%s' of type %s", path, d.Type())
+	}); err != nil {
+		return
+	}
+	for i := len(dd) - 1; i >= 0; i-- {
+		if err = syncDir(dd[i]); err != nil {
+			return
+		}
+	}
+	if err = os.Rename(t, to); err != nil {
+		return
+	}
+	if err = syncDir(filepath.Dir(to)); err != nil {
+		return
+	}
+	err = os.RemoveAll(from)
+	return
+}
+
+// copyFile copies the regular file from to the new file to, with the same
+// permissions. The copy is synced, then verified by comparing its SHA-256
+// checksum with that of the original. The number of bytes copied is returned.
+func copyFile(from, to string) (n int64, err error) {
+	var r *os.File
+	if r, err = os.Open(from); err != nil {
+		return
+	}
+	defer r.Close()
+	var i fs.FileInfo
+	if i, err = r.Stat(); err != nil {
+		return
+	}
+	var w *os.File
+	if w, err = os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL,
+		i.Mode().Perm()); err != nil {
+		return
+	}
+	defer func() {
+		if e := w.Close(); e != nil && err == nil {
+			err = e
+		}
+	}()
+	h := sha256.New()
+	if n, err = io.Copy(w, io.TeeReader(r, h)); err != nil {
+		return
+	}
+	if err = w.Sync(); err != nil {
+		return
+	}
+	var v *os.File
+	if v, err = os.Open(to); err != nil {
+		return
+	}
+	defer v.Close()
+	g := sha256.New()
+	if _, err = io.Copy(g, v); err != nil {
+		return
+	}
+	if !bytes.Equal(h.Sum(nil), g.Sum(nil)) {
+		err = ChecksumError{from}
+	}
+	return
+}
+
+// syncDir calls fsync on the named directory, so that its entries are
+// persisted.
+func syncDir(name string) (err error) {
+	var d *os.File
+	if d, err = os.Open(name); err != nil {
+		return
+	}
+	defer d.Close()
+	if err = d.Sync(); errors.Is(err, syscall.EINVAL) {
+		err = nil
+	}
+	return
+}
@@ -0,0 +1,106 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// ReporterFactory returns a Reporter, given the Config from a CustomReport.
+// Config is the JSON encoding of the Config value in the CUE config, or empty
+// if Config isn't set.
+type ReporterFactory func(config json.RawMessage) (Reporter, error)
+
+// Reporter is the interface implemented by reporters in external packages,
+// which are registered with RegisterReporter and run with a CustomReport.
+//
+// Report is run as a stage in a Test's report pipeline. It receives data items
+// on in, which include any data points of the types registered with
+// node.RegisterData, and sends data items on out. Unrecognized items should be
+// forwarded to out. Result files may be read and written using files. Report
+// should return the error from context.Cause(ctx) if ctx is canceled.
+type Reporter interface {
+	Report(ctx context.Context, files ResultFiles, in <-chan any,
+		out chan<- any) error
+}
+
+// ResultFiles reads and writes result files for a Reporter.
+type ResultFiles interface {
+	// Reader returns a ResultReader for reading the named result file. Callers
+	// should take care to always close the returned ResultReader.
+	Reader(name string) (*ResultReader, error)
+
+	// Writer returns a ResultWriter for writing a result. If name is "-", the
+	// result is written to stdout. Otherwise, the result is written to the
+	// named result file. Callers should take care to always close the returned
+	// ResultWriter.
+	Writer(name string) *ResultWriter
+}
+
+// customReporters contains the registered ReporterFactory's, by name.
+var customReporters = struct {
+	factory map[string]ReporterFactory
+	sync.Mutex
+}{factory: make(map[string]ReporterFactory)}
+
+// RegisterReporter registers a ReporterFactory under the given name, for use
+// by CustomReports. It's typically called from the init function of an
+// external package, which is imported by a custom antler executable (see
+// cmd/antler). RegisterReporter panics if the name is empty, or is already
+// registered.
+func RegisterReporter(name string, factory ReporterFactory) {
+	customReporters.Lock()
+	defer customReporters.Unlock()
+	if name == "" {
+		panic("RegisterReporter: empty name")
+	}
+	if _, ok := customReporters.factory[name]; ok {
+		panic(fmt.Sprintf("RegisterReporter: duplicate name %s", name))
+	}
+	customReporters.factory[name] = factory
+}
+
+// CustomReport runs a Reporter registered with RegisterReporter.
+type CustomReport struct {
+	// Name is the name the ReporterFactory was registered with.
+	Name string
+
+	// Config is the configuration passed to the ReporterFactory, as JSON.
+	Config json.RawMessage
+}
+
+// factory returns the ReporterFactory for the CustomReport.
+func (c *CustomReport) factory() (f ReporterFactory, err error) {
+	customReporters.Lock()
+	defer customReporters.Unlock()
+	var ok bool
+	if f, ok = customReporters.factory[c.Name]; !ok {
+		err = fmt.Errorf("no custom reporter registered with name %s", c.Name)
+	}
+	return
+}
+
+// report implements reporter
+func (c *CustomReport) report(ctx context.Context, rw rwer, in <-chan any,
+	out chan<- any) (err error) {
+	var f ReporterFactory
+	if f, err = c.factory(); err != nil {
+		return
+	}
+	var r Reporter
+	if r, err = f(c.Config); err != nil {
+		return
+	}
+	err = r.Report(ctx, rw, in, out)
+	return
+}
+
+// validate returns an error if the CustomReport's Reporter isn't registered.
+func (c *CustomReport) validate() (err error) {
+	_, err = c.factory()
+	return
+}
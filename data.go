@@ -0,0 +1,305 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/heistp/antler/node/metric"
+)
+
+// DataSegment configures a Test to write its raw result data as multiple
+// segment files, instead of a single DataFile. Segmenting allows very large
+// results to be partially fetched and decoded, and completed segments to be
+// compressed while the Test is still running.
+//
+// When segmenting, the DataFile becomes an index containing one SegmentRef for
+// each segment, in order. A new segment is started when either the Size or
+// Duration limit of the current segment is reached.
+type DataSegment struct {
+	// Size is the maximum size of a segment, in bytes before encoding. Zero
+	// means there is no size limit.
+	Size metric.Bytes
+
+	// Duration is the maximum time a segment is written to. Zero means there is
+	// no time limit.
+	Duration metric.Duration
+
+	// Extension, if not empty, is the extension of a Codec used to encode
+	// (e.g. compress) each segment after it's completed (e.g. ".zst").
+	Extension string
+}
+
+// validate returns an error if the DataSegment is invalid.
+func (s *DataSegment) validate() (err error) {
+	if s.Duration < 0 {
+		err = fmt.Errorf("DataSegment Duration may not be negative")
+		return
+	}
+	if s.Size == 0 && s.Duration == 0 {
+		err = fmt.Errorf("DataSegment requires a Size or Duration")
+	}
+	return
+}
+
+// name returns the name of the segment with the given index, for the given
+// DataFile name. The index is inserted before the first extension of the base
+// name, e.g. data.gob becomes data.0001.gob.
+func (s *DataSegment) name(dataFile string, index int) string {
+	d, b := filepath.Split(dataFile)
+	x := ""
+	if i := strings.Index(b, "."); i >= 0 {
+		b, x = b[:i], b[i:]
+	}
+	return fmt.Sprintf("%s%s.%04d%s", d, b, index, x)
+}
+
+// SegmentRef is a data item in the index DataFile of a segmented Test, that
+// references one segment of raw result data.
+type SegmentRef struct {
+	// Name is the name of the segment file, before encoding.
+	Name string
+
+	// Start is the wall clock time the segment was started.
+	Start time.Time
+
+	// End is the wall clock time the segment was completed.
+	End time.Time
+
+	// Items is the number of data items in the segment.
+	Items int
+
+	// Size is the size of the segment in bytes, before encoding.
+	Size int64
+}
+
+// init registers SegmentRef with the gob encoder.
+func init() {
+	gob.Register(SegmentRef{})
+}
+
+// decodeData decodes the data items from r, in any DataFormat, and calls f for
+// each. If a SegmentRef is decoded, f is called for the SegmentRef, then the
+// referenced segment is read using rw, and f is called for each of its items.
+// Decoding stops when f returns false, or an error occurs.
+func decodeData(rw rwer, r io.Reader, f func(a any) bool) (err error) {
+	c := newDataDecoder(r)
+	for {
+		var a any
+		if a, err = c.Decode(); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+		if !f(a) {
+			return
+		}
+		s, ok := a.(SegmentRef)
+		if !ok {
+			continue
+		}
+		var k bool
+		if k, err = decodeSegment(rw, s, f); err != nil || !k {
+			return
+		}
+	}
+}
+
+// decodeSegment decodes the data items in the segment referenced by s, and
+// calls f for each. If f returns false, decoding stops and more is false.
+func decodeSegment(rw rwer, s SegmentRef, f func(a any) bool) (more bool,
+	err error) {
+	var r *ResultReader
+	if r, err = rw.Reader(s.Name); err != nil {
+		return
+	}
+	defer func() {
+		if e := r.Close(); e != nil && err == nil {
+			err = e
+		}
+	}()
+	c := newDataDecoder(r)
+	for {
+		var a any
+		if a, err = c.Decode(); err != nil {
+			if err == io.EOF {
+				err = nil
+				more = true
+			}
+			return
+		}
+		if !f(a) {
+			return
+		}
+	}
+}
+
+// writeSegments is an internal reporter that writes data in the given
+// DataFormat to segment files, and a SegmentRef to the index for each completed
+// segment.
+// writeSegments expects to be the final stage in a pipeline, so all data is
+// consumed.
+//
+// If an encoding error occurs, the error is returned immediately.
+//
+// If the data includes any errors, the first error is returned after reading
+// and saving all the data.
+type writeSegments struct {
+	DataSegment
+	index    io.WriteCloser
+	dataFile string
+	format   DataFormat
+	written  *atomic.Int64
+	encoding sync.WaitGroup
+	mtx      sync.Mutex
+	encErr   error
+}
+
+// report implements reporter
+func (w *writeSegments) report(ctx context.Context, rw rwer, in <-chan any,
+	out chan<- any) (err error) {
+	c := newDataEncoder(w.format, w.index)
+	var s *segment
+	defer func() {
+		if s != nil {
+			if e := w.complete(s, c, rw); e != nil && err == nil {
+				err = e
+			}
+		}
+		w.encoding.Wait()
+		if w.encErr != nil && err == nil {
+			err = w.encErr
+		}
+		if e := w.index.Close(); e != nil && err == nil {
+			err = e
+		}
+	}()
+	var tc <-chan time.Time
+	if w.Duration > 0 {
+		t := time.NewTicker(w.Duration.Duration())
+		defer t.Stop()
+		tc = t.C
+	}
+	var i int
+	for {
+		select {
+		case d, ok := <-in:
+			if !ok {
+				return
+			}
+			if s == nil {
+				i++
+				s = newSegment(rw.Writer(w.name(w.dataFile, i)), w.format)
+				s.written = w.written
+			}
+			if e := s.encode(d); e != nil {
+				err = e
+				return
+			}
+			if e, ok := d.(error); ok && err == nil {
+				err = e
+			}
+			if w.Size > 0 && s.Size >= int64(w.Size) {
+				e := w.complete(s, c, rw)
+				s = nil
+				if e != nil {
+					err = e
+					return
+				}
+			}
+		case <-tc:
+			if s == nil {
+				continue
+			}
+			e := w.complete(s, c, rw)
+			s = nil
+			if e != nil {
+				err = e
+				return
+			}
+		}
+	}
+}
+
+// complete closes the segment, writes its SegmentRef to the index, and if
+// Extension is set, starts encoding the segment in the background. The first
+// background encoding error is stored in encErr.
+func (w *writeSegments) complete(s *segment, c dataEncoder, rw rwer) (
+	err error) {
+	if err = s.close(); err != nil {
+		return
+	}
+	if err = c.Encode(s.SegmentRef); err != nil {
+		return
+	}
+	if w.Extension == "" {
+		return
+	}
+	w.encoding.Add(1)
+	go func() {
+		defer w.encoding.Done()
+		x := &Encode{Extension: w.Extension, Destructive: true}
+		if e := x.encode(s.Name, rw); e != nil {
+			w.mtx.Lock()
+			if w.encErr == nil {
+				w.encErr = e
+			}
+			w.mtx.Unlock()
+		}
+	}()
+	return
+}
+
+// segment is one segment of data being written.
+type segment struct {
+	SegmentRef
+	writer  *ResultWriter
+	encoder dataEncoder
+	written *atomic.Int64
+}
+
+// newSegment returns a new segment that writes to w in the given DataFormat.
+func newSegment(w *ResultWriter, format DataFormat) (s *segment) {
+	s = &segment{
+		SegmentRef: SegmentRef{Name: w.Name, Start: time.Now()},
+		writer:     w,
+	}
+	s.encoder = newDataEncoder(format, s)
+	return
+}
+
+// Write implements io.Writer to count the bytes written.
+func (s *segment) Write(p []byte) (n int, err error) {
+	n, err = s.writer.Write(p)
+	s.Size += int64(n)
+	if s.written != nil {
+		s.written.Add(int64(n))
+	}
+	return
+}
+
+// encode encodes a data item to the segment.
+func (s *segment) encode(a any) (err error) {
+	if err = s.encoder.Encode(a); err != nil {
+		return
+	}
+	s.Items++
+	return
+}
+
+// close closes the segment's writer, and records the end time.
+func (s *segment) close() (err error) {
+	err = s.writer.Close()
+	s.End = time.Now()
+	return
+}
@@ -0,0 +1,182 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/heistp/antler/node"
+)
+
+// DataFormat is the format used to write the raw result data for a Test.
+type DataFormat string
+
+const (
+	// DataGob writes data items as a gob stream. This is the most compact and
+	// efficient format, but requires a Go decoder.
+	DataGob DataFormat = "gob"
+
+	// DataJSON writes data items as newline-delimited JSON, one object per
+	// line, with the item's type name in Type and its fields in Data, e.g.
+	// {"Type":"node.StreamIO","Data":{...}}. This may be read by other tools
+	// (e.g. jq or Python) without a Go decoder.
+	DataJSON DataFormat = "json"
+)
+
+// dataJSONPrefix is the prefix of each line of JSON data, used to detect the
+// format of data being read.
+var dataJSONPrefix = []byte(`{"Type":`)
+
+// dataEncoder encodes data items.
+type dataEncoder interface {
+	Encode(a any) error
+}
+
+// newDataEncoder returns a dataEncoder that writes to w in the given format.
+func newDataEncoder(format DataFormat, w io.Writer) dataEncoder {
+	if format == DataJSON {
+		return jsonDataEncoder{json.NewEncoder(w)}
+	}
+	return gobDataEncoder{gob.NewEncoder(w)}
+}
+
+// dataDecoder decodes data items. Decode returns io.EOF after the last item.
+type dataDecoder interface {
+	Decode() (any, error)
+}
+
+// newDataDecoder returns a dataDecoder that reads from r. The format is
+// detected from the start of the data, so data in any DataFormat may be read,
+// regardless of the Test's current DataFormat.
+func newDataDecoder(r io.Reader) dataDecoder {
+	b := bufio.NewReader(r)
+	if p, _ := b.Peek(len(dataJSONPrefix)); bytes.Equal(p, dataJSONPrefix) {
+		return jsonDataDecoder{json.NewDecoder(b)}
+	}
+	return gobDataDecoder{gob.NewDecoder(b)}
+}
+
+// gobDataEncoder is a dataEncoder for DataGob.
+type gobDataEncoder struct {
+	*gob.Encoder
+}
+
+// Encode implements dataEncoder
+func (e gobDataEncoder) Encode(a any) error {
+	return e.Encoder.Encode(&a)
+}
+
+// gobDataDecoder is a dataDecoder for DataGob.
+type gobDataDecoder struct {
+	*gob.Decoder
+}
+
+// Decode implements dataDecoder
+func (d gobDataDecoder) Decode() (a any, err error) {
+	err = d.Decoder.Decode(&a)
+	return
+}
+
+// jsonData is one line of data in the DataJSON format.
+type jsonData struct {
+	Type string
+	Data json.RawMessage
+}
+
+// jsonDataEncoder is a dataEncoder for DataJSON.
+type jsonDataEncoder struct {
+	*json.Encoder
+}
+
+// Encode implements dataEncoder
+func (e jsonDataEncoder) Encode(a any) (err error) {
+	t := reflect.TypeOf(a)
+	if _, ok := dataType(t.String()); !ok {
+		err = fmt.Errorf("unable to encode data item of type %s as JSON", t)
+		return
+	}
+	var b []byte
+	if b, err = json.Marshal(a); err != nil {
+		return
+	}
+	err = e.Encoder.Encode(jsonData{t.String(), b})
+	return
+}
+
+// jsonDataDecoder is a dataDecoder for DataJSON.
+type jsonDataDecoder struct {
+	*json.Decoder
+}
+
+// Decode implements dataDecoder
+func (d jsonDataDecoder) Decode() (a any, err error) {
+	var j jsonData
+	if err = d.Decoder.Decode(&j); err != nil {
+		return
+	}
+	t, ok := dataType(j.Type)
+	if !ok {
+		err = fmt.Errorf("unknown data item type in JSON: %s", j.Type)
+		return
+	}
+	v := reflect.New(t)
+	if err = json.Unmarshal(j.Data, v.Interface()); err != nil {
+		return
+	}
+	a = v.Elem().Interface()
+	return
+}
+
+// dataTypes maps the names of the data item types that may be written to a
+// DataFile to their types, for DataJSON.
+var dataTypes = newDataTypes(
+	node.Error{},
+	node.FileData{},
+	node.HTTPInfo{},
+	node.HTTPRequest{},
+	node.LogEntry{},
+	node.PacketInfo{},
+	node.PacketIO{},
+	node.PacketTrain{},
+	node.PrereqResult{},
+	node.QdiscInfo{},
+	node.RPCInfo{},
+	node.RPCTransaction{},
+	node.RunnerStart{},
+	node.QueueOverflow{},
+	node.StationInfo{},
+	node.StreamInfo{},
+	node.StreamIO{},
+	node.SysInfoData{},
+	node.TCPInfo{},
+	node.TinInfo{},
+	FileRef{},
+	MinimalSummary{},
+	SegmentRef{},
+)
+
+// dataType returns the data item type with the given name, from dataTypes or
+// the types registered with node.RegisterData.
+func dataType(name string) (t reflect.Type, ok bool) {
+	if t, ok = dataTypes[name]; ok {
+		return
+	}
+	return node.DataType(name)
+}
+
+// newDataTypes returns a map of type names to types for the given values.
+func newDataTypes(values ...any) (types map[string]reflect.Type) {
+	types = make(map[string]reflect.Type)
+	for _, v := range values {
+		t := reflect.TypeOf(v)
+		types[t.String()] = t
+	}
+	return
+}
@@ -0,0 +1,151 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"context"
+	"io"
+	"maps"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/heistp/antler/node"
+)
+
+// progressInterval is the interval at which TestProgress events are sent.
+const progressInterval = time.Second
+
+// An Event is passed to RunCommand.Event to report the progress of a run, for
+// library users that embed antler and present their own UI. The concrete types
+// are TestStarted, RunnerStarted, TestProgress and TestDone.
+type Event interface {
+	// EventTest returns the Test the Event is for.
+	EventTest() *Test
+}
+
+// TestStarted is the Event sent when a Test starts running.
+type TestStarted struct {
+	Test *Test
+	Time time.Time
+}
+
+// EventTest implements Event
+func (s TestStarted) EventTest() *Test {
+	return s.Test
+}
+
+// RunnerStarted is the Event sent when a runner starts on one of a Test's
+// nodes.
+type RunnerStarted struct {
+	Test *Test
+	node.RunnerStart
+}
+
+// EventTest implements Event
+func (s RunnerStarted) EventTest() *Test {
+	return s.Test
+}
+
+// TestProgress is the Event sent periodically while a Test runs, and once
+// after its During reports complete.
+type TestProgress struct {
+	Test *Test
+
+	// Count maps data item type names (e.g. "node.StreamIO") to the number of
+	// items of that type received so far.
+	Count map[string]int
+
+	// Bytes is the number of bytes written to the DataFile, or its segments,
+	// so far. It's zero if the Test has no DataFile.
+	Bytes int64
+}
+
+// EventTest implements Event
+func (p TestProgress) EventTest() *Test {
+	return p.Test
+}
+
+// TestDone is the Event sent when a Test's Run and During reports complete,
+// before its After reports.
+type TestDone struct {
+	Test    *Test
+	Elapsed time.Duration
+
+	// Err is the error that occurred running the Test, if any.
+	Err error
+}
+
+// EventTest implements Event
+func (d TestDone) EventTest() *Test {
+	return d.Test
+}
+
+// progress is an internal reporter that sends RunnerStarted events for each
+// node.RunnerStart, and TestProgress events each progressInterval with the
+// counts of the data items it receives. progress expects to be the first stage
+// in the During pipeline.
+type progress struct {
+	test    *Test
+	event   func(Event)
+	written atomic.Int64
+	count   map[string]int
+	mtx     sync.Mutex
+}
+
+// newProgress returns a new progress for the given Test.
+func newProgress(test *Test, event func(Event)) *progress {
+	return &progress{
+		test:  test,
+		event: event,
+		count: make(map[string]int),
+	}
+}
+
+// report implements reporter
+func (p *progress) report(ctx context.Context, rw rwer, in <-chan any,
+	out chan<- any) (err error) {
+	t := time.NewTicker(progressInterval)
+	defer t.Stop()
+	for {
+		select {
+		case d, ok := <-in:
+			if !ok {
+				return
+			}
+			p.mtx.Lock()
+			p.count[reflect.TypeOf(d).String()]++
+			p.mtx.Unlock()
+			if s, ok := d.(node.RunnerStart); ok {
+				p.event(RunnerStarted{p.test, s})
+			}
+			out <- d
+		case <-t.C:
+			p.send()
+		}
+	}
+}
+
+// send sends a TestProgress event with the current counts.
+func (p *progress) send() {
+	p.mtx.Lock()
+	c := maps.Clone(p.count)
+	p.mtx.Unlock()
+	p.event(TestProgress{p.test, c, p.written.Load()})
+}
+
+// countWriteCloser wraps an io.WriteCloser to add the number of bytes written
+// to an atomic counter.
+type countWriteCloser struct {
+	io.WriteCloser
+	n *atomic.Int64
+}
+
+// Write implements io.Writer
+func (c countWriteCloser) Write(p []byte) (n int, err error) {
+	n, err = c.WriteCloser.Write(p)
+	c.n.Add(int64(n))
+	return
+}
@@ -67,6 +67,12 @@ _tcpinfo: {
 				}
 			}
 		}},
+		{ChartsTCPInfo: {
+			FlowLabel: {
+				"cubic": "CUBIC"
+				"reno":  "Reno"
+			}
+		}},
 	]
 
 	// _rig defines the dumbbell Test setup.
@@ -0,0 +1,91 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ExitCode is an exit code for the antler command, which reflects the class of
+// outcome of a Command, so that automation can branch on the type of failure.
+type ExitCode int
+
+const (
+	ExitOK          ExitCode = 0 // success
+	ExitFailure     ExitCode = 1 // an error not in one of the classes below
+	ExitConfig      ExitCode = 2 // invalid config or command line
+	ExitEnvironment ExitCode = 3 // missing prerequisites, or unusable results
+	ExitTest        ExitCode = 4 // a Test failed to run
+	ExitReport      ExitCode = 5 // a report failed
+	ExitCanceled    ExitCode = 6 // canceled, e.g. by a signal
+	ExitExpect      ExitCode = 7 // a Test did not meet its expectations
+)
+
+// String implements fmt.Stringer
+func (c ExitCode) String() string {
+	switch c {
+	case ExitOK:
+		return "ok"
+	case ExitFailure:
+		return "failure"
+	case ExitConfig:
+		return "config error"
+	case ExitEnvironment:
+		return "environment error"
+	case ExitTest:
+		return "test error"
+	case ExitReport:
+		return "report error"
+	case ExitCanceled:
+		return "canceled"
+	case ExitExpect:
+		return "expectation not met"
+	}
+	return fmt.Sprintf("ExitCode(%d)", int(c))
+}
+
+// ExitError wraps an error with the ExitCode for its class of outcome.
+type ExitError struct {
+	Code ExitCode
+	Err  error
+}
+
+// exitError returns err wrapped in an ExitError with the given code, or nil if
+// err is nil. If err is already an ExitError, it's returned unchanged, so the
+// innermost class is kept.
+func exitError(code ExitCode, err error) error {
+	if err == nil {
+		return nil
+	}
+	var x ExitError
+	if errors.As(err, &x) {
+		return err
+	}
+	return ExitError{code, err}
+}
+
+// Error implements error
+func (e ExitError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e ExitError) Unwrap() error {
+	return e.Err
+}
+
+// ExitCodeOf returns the ExitCode for an error returned by Run. ExitOK is
+// returned if err is nil, the Code of the first ExitError in err's tree if
+// there is one, or ExitFailure otherwise.
+func ExitCodeOf(err error) ExitCode {
+	if err == nil {
+		return ExitOK
+	}
+	var x ExitError
+	if errors.As(err, &x) {
+		return x.Code
+	}
+	return ExitFailure
+}
@@ -0,0 +1,235 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync"
+)
+
+// expectFile is the name suffix of the file that records the result of a
+// Test's Expect conditions, which is read when the result is added to the
+// Catalog.
+const expectFile = "expect.json"
+
+// ExpectResult is the result of evaluating a Test's Expect and Assert
+// conditions.
+type ExpectResult struct {
+	// Pass is true if all of the conditions were met.
+	Pass bool
+
+	// Cond lists the result for each condition, in Expect then Assert order.
+	Cond []ExpectCond
+}
+
+// newExpectResult returns the ExpectResult for a Test's conditions.
+func newExpectResult(test *Test, env assertEnv) (r ExpectResult) {
+	var pp []predicate
+	for i := range test.Expect {
+		pp = append(pp, &test.Expect[i])
+	}
+	for i := range test.Assert {
+		pp = append(pp, test.Assert[i].predicate())
+	}
+	r.Pass = true
+	for _, p := range pp {
+		c := p.eval(env)
+		if !c.Pass {
+			r.Pass = false
+		}
+		r.Cond = append(r.Cond, c)
+	}
+	return
+}
+
+// errors returns an ExpectError for each condition that was not met.
+func (r ExpectResult) errors(id TestID) (err []error) {
+	for _, c := range r.Cond {
+		if !c.Pass {
+			err = append(err, ExpectError{id, c})
+		}
+	}
+	return
+}
+
+// ExpectCond is the result of evaluating one Expect or Assert condition.
+type ExpectCond struct {
+	// Expect describes the condition.
+	Expect string
+
+	// Value is the value the condition was evaluated on, or nil if it has no
+	// single value, or the value couldn't be found.
+	Value *float64 `json:",omitempty"`
+
+	// Note explains the result, e.g. when a metric wasn't found.
+	Note string `json:",omitempty"`
+
+	// Pass is true if the condition was met.
+	Pass bool
+}
+
+// ExpectError is sent to the MultiReports when a Test's Expect condition was
+// not met, so that the Test is shown as failed (e.g. in JUnit).
+type ExpectError struct {
+	ID TestID
+	ExpectCond
+}
+
+// Error implements error
+func (e ExpectError) Error() string {
+	return fmt.Sprintf("%s expected %s", e.ID, e.ExpectCond)
+}
+
+// String implements fmt.Stringer
+func (c ExpectCond) String() string {
+	s := c.Expect
+	if c.Value != nil {
+		s += fmt.Sprintf(", got %g", *c.Value)
+	}
+	if c.Note != "" {
+		s += fmt.Sprintf(" (%s)", c.Note)
+	}
+	return s
+}
+
+// checkExpect is an internal reporter added to the end of the After pipeline
+// for Tests with Expect or Assert conditions. It evaluates the conditions on
+// the results from the analysis, writes the ExpectResult to expect.json, and
+// sends an ExpectError for each condition that was not met.
+type checkExpect struct {
+	test   *Test
+	prior  Metrics
+	result func(*Test, ExpectResult)
+}
+
+// report implements reporter
+func (c checkExpect) report(ctx context.Context, rw rwer, in <-chan any,
+	out chan<- any) (err error) {
+	var a *analysis
+	for d := range in {
+		out <- d
+		if y, ok := d.(analysis); ok {
+			a = &y
+		}
+	}
+	if err = context.Cause(ctx); err != nil {
+		return
+	}
+	if a == nil {
+		err = fmt.Errorf("Expect for %s requires Analyze in the After "+
+			"pipeline", c.test.ID)
+		return
+	}
+	r := newExpectResult(c.test, assertEnv{newMetrics(*a), newDists(*a),
+		c.prior})
+	if err = writeExpect(rw, r); err != nil {
+		return
+	}
+	if c.result != nil {
+		c.result(c.test, r)
+	}
+	for _, e := range r.errors(c.test.ID) {
+		out <- e
+	}
+	return
+}
+
+// replayExpect is an internal reporter used in place of checkExpect when the
+// After reports were linked from the cache. It reads the prior ExpectResult
+// from expect.json, and sends an ExpectError for each condition that was not
+// met.
+type replayExpect checkExpect
+
+// report implements reporter
+func (c replayExpect) report(ctx context.Context, rw rwer, in <-chan any,
+	out chan<- any) (err error) {
+	for d := range in {
+		out <- d
+	}
+	var r ExpectResult
+	var ok bool
+	if r, ok, err = readExpect(rw); err != nil || !ok {
+		return
+	}
+	if c.result != nil {
+		c.result(c.test, r)
+	}
+	for _, e := range r.errors(c.test.ID) {
+		out <- e
+	}
+	return
+}
+
+// writeExpect writes an ExpectResult to expect.json.
+func writeExpect(rw rwer, r ExpectResult) (err error) {
+	w := rw.Writer(expectFile)
+	defer func() {
+		if e := w.Close(); e != nil && err == nil {
+			err = e
+		}
+	}()
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	e.SetEscapeHTML(false)
+	err = e.Encode(r)
+	return
+}
+
+// readExpect reads an ExpectResult from expect.json. If the file doesn't
+// exist, ok is false.
+func readExpect(rw rwer) (r ExpectResult, ok bool, err error) {
+	var rr *ResultReader
+	if rr, err = rw.Reader(expectFile); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+		return
+	}
+	defer func() {
+		if e := rr.Close(); e != nil && err == nil {
+			err = e
+		}
+	}()
+	if err = json.NewDecoder(rr).Decode(&r); err != nil {
+		return
+	}
+	ok = true
+	return
+}
+
+// expectTally counts the Tests that did not meet their Expect conditions.
+type expectTally struct {
+	unmet int
+	fail  int
+	sync.Mutex
+}
+
+// add adds the ExpectResult for a Test.
+func (t *expectTally) add(test *Test, r ExpectResult) {
+	if r.Pass {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+	t.unmet++
+	if test.ExpectFail {
+		t.fail++
+	}
+}
+
+// err returns an ExitError with ExitExpect if any Tests with ExpectFail set
+// did not meet their Expect conditions, or nil otherwise.
+func (t *expectTally) err() error {
+	t.Lock()
+	defer t.Unlock()
+	if t.fail == 0 {
+		return nil
+	}
+	return ExitError{ExitExpect,
+		fmt.Errorf("%d tests did not meet their expectations", t.fail)}
+}
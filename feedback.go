@@ -0,0 +1,78 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"sync"
+
+	"github.com/heistp/antler/node"
+)
+
+// FeedbackValue is a Feedback value that a Test exported to the run's Feedback
+// store.
+type FeedbackValue struct {
+	// ID is the ID of the Test that exported the value.
+	ID TestID
+
+	// Key is the Feedback key.
+	Key string
+
+	// Value is the Feedback value.
+	Value any
+}
+
+// feedbackStore holds the Feedback values exported by Tests during a run, so
+// they may be imported by later Tests.
+type feedbackStore struct {
+	value node.Feedback
+	mtx   sync.Mutex
+}
+
+// newFeedbackStore returns a new, empty feedbackStore.
+func newFeedbackStore() *feedbackStore {
+	return &feedbackStore{value: node.Feedback{}}
+}
+
+// missing returns ok false and the first of the given keys that isn't in the
+// store, if any.
+func (s *feedbackStore) missing(keys []string) (key string, ok bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for _, k := range keys {
+		if _, ok = s.value[k]; !ok {
+			key = k
+			return
+		}
+	}
+	ok = true
+	return
+}
+
+// get returns the Feedback for the given keys that are in the store.
+func (s *feedbackStore) get(keys []string) (fb node.Feedback) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	fb = node.Feedback{}
+	for _, k := range keys {
+		if v, ok := s.value[k]; ok {
+			fb[k] = v
+		}
+	}
+	return
+}
+
+// export stores the values in fb for the keys the Test Exports, and returns
+// the FeedbackValues stored.
+func (s *feedbackStore) export(test *Test, fb node.Feedback) (
+	vv []FeedbackValue) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for _, k := range test.Export {
+		if v, ok := fb[k]; ok {
+			s.value[k] = v
+			vv = append(vv, FeedbackValue{test.ID, k, v})
+		}
+	}
+	return
+}
@@ -0,0 +1,282 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/heistp/antler/node"
+)
+
+// flowPalette is the default palette used to assign colors to Flows. It is the
+// same as the default palette in Google Charts.
+var flowPalette = []string{
+	"#3366cc",
+	"#dc3912",
+	"#ff9900",
+	"#109618",
+	"#990099",
+	"#0099c6",
+	"#dd4477",
+	"#66aa00",
+	"#b82e2e",
+	"#316395",
+}
+
+// FlowInfo contains metadata for a Flow in the flowRegistry.
+type FlowInfo struct {
+	node.FlowDecl
+
+	// Index is the position of the Flow in the registry, which determines its
+	// order and color.
+	Index int
+
+	// Name is the full name that the Flow was generated from by a Flow
+	// template, or empty if the Flow wasn't generated.
+	Name string
+
+	// palette is the palette that the Flow's color is selected from.
+	palette []string
+}
+
+// Color returns the color assigned to the Flow.
+func (f FlowInfo) Color() string {
+	return paletteColor(f.palette, f.Index)
+}
+
+// Meta returns the FlowMeta for the Flow, from its declaration.
+func (f FlowInfo) Meta() FlowMeta {
+	return FlowMeta{
+		f.Direction,
+		f.CCA,
+		f.DSCP,
+		f.ECN,
+		f.Node,
+		f.Server,
+		f.ServerKnown,
+	}
+}
+
+// FlowMeta contains metadata for a Flow, for use in labels and summaries.
+type FlowMeta struct {
+	// Direction is the client to server sense, for stream flows.
+	Direction node.Direction
+
+	// CCA is the congestion control algorithm, if set.
+	CCA string
+
+	// DSCP is the DSCP value set by the client.
+	DSCP byte
+
+	// ECN is the ECN field set by the client.
+	ECN byte
+
+	// Client is the ID of the Node the client runs on, or empty for the root.
+	Client node.ID
+
+	// Server is the ID of the Node the server runs on, or empty for the root.
+	// It's only valid if ServerKnown is true.
+	Server      node.ID
+	ServerKnown bool
+}
+
+// stream returns a copy of the FlowMeta, updated with any fields that are set
+// in the given Stream.
+func (m FlowMeta) stream(s node.Stream) FlowMeta {
+	if s.Direction != "" {
+		m.Direction = s.Direction
+	}
+	if s.CCA != "" {
+		m.CCA = s.CCA
+	}
+	if s.DSCP != 0 {
+		m.DSCP = s.DSCP
+	}
+	if s.ECN != 0 {
+		m.ECN = s.ECN
+	}
+	return m
+}
+
+// Nodes returns the client and server Node IDs, separated by an arrow, with
+// "root" for the root node, and "?" for an unknown server.
+func (m FlowMeta) Nodes() string {
+	n := func(id node.ID) string {
+		if id == "" {
+			return "root"
+		}
+		return string(id)
+	}
+	s := "?"
+	if m.ServerKnown {
+		s = n(m.Server)
+	}
+	return n(m.Client) + " → " + s
+}
+
+// String returns a short summary of the fields that are set, e.g.
+// "up, cubic, DSCP 8, ECN 1, c1 → s1".
+func (m FlowMeta) String() string {
+	var ss []string
+	if m.Direction != "" {
+		ss = append(ss, string(m.Direction))
+	}
+	if m.CCA != "" {
+		ss = append(ss, m.CCA)
+	}
+	if m.DSCP != 0 {
+		ss = append(ss, fmt.Sprintf("DSCP %d", m.DSCP))
+	}
+	if m.ECN != 0 {
+		ss = append(ss, fmt.Sprintf("ECN %d", m.ECN))
+	}
+	if m.Client != "" || m.ServerKnown {
+		ss = append(ss, m.Nodes())
+	}
+	return strings.Join(ss, ", ")
+}
+
+// flowRegistry contains the Flows declared in the config, so that reporters
+// use consistent ordering, colors and labels across all Tests in a run. Flows
+// with the same ID in different Tests share the same FlowInfo.
+type flowRegistry struct {
+	info    map[node.Flow]FlowInfo
+	palette []string
+	mtx     sync.Mutex
+}
+
+// newFlowRegistry returns a new flowRegistry for the given Tests, with Flows
+// registered in declaration order and assigned colors from palette. If palette
+// is empty, flowPalette is used.
+func newFlowRegistry(tests Tests, palette []string) (r *flowRegistry) {
+	r = &flowRegistry{info: make(map[node.Flow]FlowInfo), palette: palette}
+	for i := range tests {
+		for _, d := range node.Flows(&tests[i].Run) {
+			r.add(d, tests[i].flowName[d.Flow])
+		}
+	}
+	return
+}
+
+// add adds a FlowDecl to the registry with the given full name, if its Flow
+// isn't already registered.
+func (r *flowRegistry) add(decl node.FlowDecl, name string) (info FlowInfo) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	var ok bool
+	if info, ok = r.info[decl.Flow]; ok {
+		return
+	}
+	info = FlowInfo{decl, len(r.info), name, r.palette}
+	r.info[decl.Flow] = info
+	return
+}
+
+// encode writes the registered Flows to w, in a stable encoding.
+func (r *flowRegistry) encode(w io.Writer) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return json.NewEncoder(w).Encode(r.info)
+}
+
+// meta returns the FlowMeta for the given Flow, from its declaration. Flows
+// that aren't declared in the config are not registered, and return an empty
+// FlowMeta.
+func (r *flowRegistry) meta(flow node.Flow) FlowMeta {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.info[flow].Meta()
+}
+
+// Info returns the FlowInfo for the given Flow. Flows not declared in the
+// config are registered on first use, so they still receive a stable color.
+func (r *flowRegistry) Info(flow node.Flow) FlowInfo {
+	return r.add(node.FlowDecl{Flow: flow}, "")
+}
+
+// Label returns the label for the given Flow, from the given labels map if
+// present, or the Flow's full name if it was generated from a template, or the
+// Flow ID otherwise. If meta is true, the name is followed by a summary of its
+// declared FlowMeta in parentheses, if there is any.
+func (r *flowRegistry) Label(flow node.Flow, label map[node.Flow]string,
+	meta bool) string {
+	if l, ok := label[flow]; ok {
+		return l
+	}
+	n := r.name(flow)
+	if meta {
+		if m := r.meta(flow).String(); m != "" {
+			return fmt.Sprintf("%s (%s)", n, m)
+		}
+	}
+	return n
+}
+
+// name returns the full name for the given Flow, if it was generated from a
+// template, or the Flow ID otherwise.
+func (r *flowRegistry) name(flow node.Flow) string {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if n := r.info[flow].Name; n != "" {
+		return n
+	}
+	return string(flow)
+}
+
+// less reports whether Flow a sorts before Flow b. Declared Flows sort in
+// declaration order, before any undeclared Flows.
+func (r *flowRegistry) less(a, b node.Flow) bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	ia, aok := r.info[a]
+	ib, bok := r.info[b]
+	switch {
+	case aok && bok:
+		return ia.Index < ib.Index
+	case aok:
+		return true
+	}
+	return false
+}
+
+// sortStreams sorts StreamAnalysis by registry order. The sort is stable, so
+// undeclared Flows retain their input order.
+func (r *flowRegistry) sortStreams(s []StreamAnalysis) {
+	sort.SliceStable(s, func(i, j int) bool {
+		return r.less(s[i].Flow, s[j].Flow)
+	})
+}
+
+// sortPackets sorts PacketAnalysis by registry order. The sort is stable, so
+// undeclared Flows retain their input order.
+func (r *flowRegistry) sortPackets(p []PacketAnalysis) {
+	sort.SliceStable(p, func(i, j int) bool {
+		return r.less(p[i].Flow, p[j].Flow)
+	})
+}
+
+// sortRPCs sorts RPCAnalysis by registry order. The sort is stable, so
+// undeclared Flows retain their input order.
+func (r *flowRegistry) sortRPCs(a []RPCAnalysis) {
+	sort.SliceStable(a, func(i, j int) bool {
+		return r.less(a[i].Flow, a[j].Flow)
+	})
+}
+
+// report implements reporter to emit the flowRegistry as the first data item
+// in the pipeline, then forward all other items.
+func (r *flowRegistry) report(ctx context.Context, rw rwer, in <-chan any,
+	out chan<- any) (err error) {
+	out <- r
+	for d := range in {
+		out <- d
+	}
+	return
+}
@@ -6,9 +6,11 @@ package antler
 import (
 	"context"
 	_ "embed"
+	"fmt"
 	"html/template"
 	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 )
 
@@ -21,25 +23,70 @@ var indexTemplate string
 type Index struct {
 	To          string
 	GroupBy     string
+	SubGroupBy  []string
 	Title       string
 	ExcludeFile []string
-	test        []*Test
+	theme       Theme
+	test        []indexEntry
 	sync.Mutex
 }
 
-// report implements multiReporter to gather the Tests.
+// indexEntry records a Test, the number of errors in its data, and its
+// WorkingLatency.
+type indexEntry struct {
+	test    *Test
+	errors  int
+	working WorkingLatency
+}
+
+// validate returns an error if the Index is invalid.
+func (i *Index) validate() (err error) {
+	if len(i.SubGroupBy) > 0 && i.GroupBy == "" {
+		err = fmt.Errorf("Index SubGroupBy requires GroupBy")
+	}
+	return
+}
+
+// setTheme implements themer
+func (i *Index) setTheme(t Theme) {
+	i.theme = t
+}
+
+// report implements multiReporter to gather the Tests, count any errors, and
+// get the WorkingLatency. The WorkingLatency is taken from the analysis if the
+// Analyze report was run, or calculated from the data otherwise (e.g. when the
+// After reports were linked from the cache).
 func (i *Index) report(ctx context.Context, work resultRW, test *Test,
 	data <-chan any) error {
+	var n int
+	var a *analysis
+	y := newAnalysis()
+	for d := range data {
+		switch v := d.(type) {
+		case error:
+			n++
+		case analysis:
+			a = &v
+		default:
+			if a == nil {
+				y.add(d)
+			}
+		}
+	}
+	if a == nil {
+		y.analyze()
+		a = &y
+	}
 	i.Lock()
-	i.test = append(i.test, test)
+	i.test = append(i.test, indexEntry{test, n, a.working})
 	i.Unlock()
 	return nil
 }
 
 // stop implements multiStopper to generate the index file.
 func (i *Index) stop(work resultRW) (err error) {
-	t := template.New("Style")
-	if t, err = t.Parse(styleTemplate); err != nil {
+	var t *template.Template
+	if t, err = newStyleTemplate(i.theme); err != nil {
 		return
 	}
 	t = t.New("Index")
@@ -64,41 +111,102 @@ func (i *Index) stop(work resultRW) (err error) {
 func (i *Index) templateData(paths pathSet) (data indexTemplateData, err error) {
 	data.Title = i.Title
 	data.GroupBy = i.GroupBy
-	for _, v := range i.groupValues() {
-		g := indexGroup{Key: i.GroupBy, Value: v}
-		c := make(map[string]struct{})
-		for _, t := range i.test {
-			if t.ID[i.GroupBy] != v {
+	var tt []indexTest
+	for _, e := range i.test {
+		var l []indexLink
+		for _, p := range paths.withPrefix(e.test.Path).sorted() {
+			if strings.HasSuffix(p, reportCacheFile) {
 				continue
 			}
-			var l []indexLink
-			for _, p := range paths.withPrefix(t.Path).sorted() {
-				var x bool
-				if x, err = i.excludeFile(p); err != nil {
-					return
-				}
-				if !x {
-					l = append(l, indexLink{filepath.Base(p), p})
-				}
+			var x bool
+			if x, err = i.excludeFile(p); err != nil {
+				return
 			}
-			g.Test = append(g.Test, indexTest{t.ID, l})
-			for k := range t.ID {
-				c[k] = struct{}{}
+			if !x {
+				l = append(l, indexLink{filepath.Base(p), p})
 			}
 		}
-		delete(c, i.GroupBy)
-		for k := range c {
-			g.Column = append(g.Column, k)
+		tt = append(tt, indexTest{e.test.ID, l, e.errors, e.working})
+	}
+	k := i.groupKeys()
+	if len(k) == 0 {
+		data.Group = []indexGroup{i.leaf(indexGroup{}, tt)}
+		return
+	}
+	data.Group = i.groups(tt, k, "")
+	return
+}
+
+// groupKeys returns the Test ID keys used for grouping, from the outermost
+// group inwards.
+func (i *Index) groupKeys() (keys []string) {
+	if i.GroupBy == "" {
+		return
+	}
+	keys = append(keys, i.GroupBy)
+	keys = append(keys, i.SubGroupBy...)
+	return
+}
+
+// groups returns the groups of the given Tests for the first key, with
+// subgroups nested for any remaining keys. Anchor IDs are prefixed with the
+// given parent anchor.
+func (i *Index) groups(tests []indexTest, keys []string,
+	anchor string) (gg []indexGroup) {
+	k := keys[0]
+	for _, v := range groupValues(tests, k) {
+		g := indexGroup{Key: k, Value: v, Anchor: anchor + v + "-"}
+		var tt []indexTest
+		for _, t := range tests {
+			if t.ID[k] == v {
+				tt = append(tt, t)
+			}
 		}
-		sort.Strings(g.Column)
-		if i.GroupBy != "" {
-			g.Column = append([]string{i.GroupBy}, g.Column...)
+		if len(keys) > 1 {
+			g.Group = i.groups(tt, keys[1:], g.Anchor)
+			for _, s := range g.Group {
+				g.Tests += s.Tests
+				g.Errors += s.Errors
+				g.Failed += s.Failed
+			}
+		} else {
+			g = i.leaf(g, tt)
 		}
-		data.Group = append(data.Group, g)
+		gg = append(gg, g)
 	}
 	return
 }
 
+// leaf returns the given group with the Tests added, and its columns and
+// summary stats set.
+func (i *Index) leaf(group indexGroup, tests []indexTest) indexGroup {
+	k := i.groupKeys()
+	c := make(map[string]struct{})
+	for _, t := range tests {
+		for d := range t.ID {
+			c[d] = struct{}{}
+		}
+		group.Tests++
+		group.Errors += t.Errors
+		if t.Errors > 0 {
+			group.Failed++
+		}
+		if t.Working.Known() {
+			group.Working = true
+		}
+	}
+	for _, d := range k {
+		delete(c, d)
+	}
+	for d := range c {
+		group.Column = append(group.Column, d)
+	}
+	sort.Strings(group.Column)
+	group.Column = append(k[:len(k):len(k)], group.Column...)
+	group.Test = tests
+	return group
+}
+
 // excludeFile returns true if the base name of the given path matches any of
 // the ExcludeFile patterns.
 func (i *Index) excludeFile(path string) (matched bool, err error) {
@@ -111,16 +219,11 @@ func (i *Index) excludeFile(path string) (matched bool, err error) {
 	return
 }
 
-// groupValues returns the sorted, unique TestID values for the GroupBy key.
-func (i *Index) groupValues() (val []string) {
+// groupValues returns the sorted, unique TestID values for the given key.
+func groupValues(tests []indexTest, key string) (val []string) {
 	g := make(map[string]struct{})
-	if i.GroupBy != "" {
-		for _, t := range i.test {
-			v := t.ID[i.GroupBy]
-			g[v] = struct{}{}
-		}
-	} else {
-		g[""] = struct{}{}
+	for _, t := range tests {
+		g[t.ID[key]] = struct{}{}
 	}
 	for k := range g {
 		val = append(val, k)
@@ -137,17 +240,26 @@ type indexTemplateData struct {
 }
 
 // indexGroup contains the information for one group of Tests in the index.
+// Groups contain either Tests, or nested Groups for the next key.
 type indexGroup struct {
-	Key    string
-	Value  string
-	Column []string
-	Test   []indexTest
+	Key     string
+	Value   string
+	Anchor  string
+	Column  []string
+	Test    []indexTest
+	Group   []indexGroup
+	Tests   int  // number of Tests in the group, including subgroups
+	Failed  int  // number of Tests with errors
+	Errors  int  // total number of errors
+	Working bool // true if any Test has a known WorkingLatency
 }
 
 // indexTest contains the information for one Test in an indexGroup.
 type indexTest struct {
-	ID   TestID
-	Link []indexLink
+	ID      TestID
+	Link    []indexLink
+	Errors  int
+	Working WorkingLatency
 }
 
 // indexLink contains the information for one link in an indexTest.
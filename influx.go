@@ -0,0 +1,377 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/heistp/antler/node"
+	"github.com/heistp/antler/node/metric"
+)
+
+// influxQueueLen is the maximum number of batches that may be queued for
+// writing, after which batches are dropped, so a slow or unreachable database
+// can't stall the pipeline.
+const influxQueueLen = 16
+
+// EmitInflux is a reporter that writes StreamIO, PacketIO and TCPInfo samples
+// to a time-series database using the InfluxDB line protocol over HTTP, as they
+// arrive. Points are tagged by the Test ID, Flow and Flow Tags, and written in
+// batches. All data is passed through to the next stage.
+type EmitInflux struct {
+	// URL is the write endpoint, e.g.
+	// http://localhost:8086/api/v2/write?org=lab&bucket=antler for InfluxDB 2,
+	// or http://localhost:8086/write?db=antler for InfluxDB 1.x.
+	URL string
+
+	// TokenEnv, if not empty, is the name of an environment variable that
+	// contains an API token, sent in the Authorization header.
+	TokenEnv string
+
+	// Prefix is prepended to the measurement names.
+	Prefix string
+
+	// Tag contains additional tags added to each point.
+	Tag map[string]string
+
+	// BatchSize is the maximum number of points in a batch.
+	BatchSize int
+
+	// FlushInterval is the maximum time points are held before being written.
+	FlushInterval metric.Duration
+
+	// WriteTimeout is the maximum time for each write request.
+	WriteTimeout metric.Duration
+}
+
+// validate returns an error if any fields are invalid.
+func (x *EmitInflux) validate() (err error) {
+	if x.BatchSize <= 0 {
+		err = fmt.Errorf("EmitInflux BatchSize must be positive: %d",
+			x.BatchSize)
+		return
+	}
+	if x.FlushInterval <= 0 {
+		err = fmt.Errorf("EmitInflux FlushInterval must be positive: %s",
+			x.FlushInterval)
+	}
+	return
+}
+
+// report implements reporter
+func (x *EmitInflux) report(ctx context.Context, rw rwer, in <-chan any,
+	out chan<- any) (err error) {
+	p := newInfluxPoints(x, contextTest(ctx))
+	s := &influxSender{
+		EmitInflux: x,
+		client:     &http.Client{Timeout: x.WriteTimeout.Duration()},
+	}
+	if x.TokenEnv != "" {
+		s.token = os.Getenv(x.TokenEnv)
+	}
+	bc := make(chan []byte, influxQueueLen)
+	done := make(chan struct{})
+	go s.run(ctx, bc, done)
+	t := time.NewTicker(x.FlushInterval.Duration())
+	defer t.Stop()
+	var dropped int
+	flush := func() {
+		if p.n == 0 {
+			return
+		}
+		select {
+		case bc <- p.take():
+		default:
+			p.take()
+			dropped++
+		}
+	}
+	warn := func() {
+		if dropped > 0 {
+			out <- influxWarning("dropped %d batches, as writes to %s "+
+				"couldn't keep up", dropped, x.URL)
+			dropped = 0
+		}
+		if e := s.warning(); e != nil {
+			out <- *e
+		}
+	}
+loop:
+	for {
+		select {
+		case d, ok := <-in:
+			if !ok {
+				break loop
+			}
+			out <- d
+			if p.add(d); p.n >= x.BatchSize {
+				flush()
+			}
+		case <-t.C:
+			flush()
+			warn()
+		}
+	}
+	if p.n > 0 {
+		select {
+		case bc <- p.take():
+		case <-ctx.Done():
+		}
+	}
+	close(bc)
+	<-done
+	warn()
+	return
+}
+
+// influxWarning returns a warning LogEntry for EmitInflux.
+func influxWarning(format string, a ...any) node.LogEntry {
+	return node.LogEntry{
+		Time:   time.Now(),
+		NodeID: node.RootNodeID,
+		Tag:    "influx",
+		Text:   fmt.Sprintf(format, a...),
+		Level:  node.LogWarn,
+	}
+}
+
+// influxSender writes batches of points.
+type influxSender struct {
+	*EmitInflux
+	client *http.Client
+	token  string
+	failed int
+	err    error
+	mtx    sync.Mutex
+}
+
+// run writes the batches received from bc until it's closed, then closes done.
+func (s *influxSender) run(ctx context.Context, bc <-chan []byte,
+	done chan<- struct{}) {
+	defer close(done)
+	for b := range bc {
+		if e := s.write(ctx, b); e != nil {
+			s.mtx.Lock()
+			s.failed++
+			s.err = e
+			s.mtx.Unlock()
+		}
+	}
+}
+
+// write writes a batch of points.
+func (s *influxSender) write(ctx context.Context, batch []byte) (err error) {
+	var q *http.Request
+	q, err = http.NewRequestWithContext(ctx, http.MethodPost, s.URL,
+		bytes.NewReader(batch))
+	if err != nil {
+		return
+	}
+	q.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.token != "" {
+		q.Header.Set("Authorization", "Token "+s.token)
+	}
+	var r *http.Response
+	if r, err = s.client.Do(q); err != nil {
+		return
+	}
+	defer r.Body.Close()
+	if r.StatusCode/100 != 2 {
+		b, _ := io.ReadAll(io.LimitReader(r.Body, 512))
+		err = fmt.Errorf("%s: %s", r.Status, strings.TrimSpace(string(b)))
+		return
+	}
+	_, err = io.Copy(io.Discard, r.Body)
+	return
+}
+
+// warning returns a warning LogEntry if any writes failed since the last call,
+// or nil otherwise.
+func (s *influxSender) warning() *node.LogEntry {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.failed == 0 {
+		return nil
+	}
+	e := influxWarning("%d batches failed to write to %s, last error: %s",
+		s.failed, s.URL, s.err)
+	s.failed = 0
+	return &e
+}
+
+// influxPoints builds a batch of points in the line protocol, from data items.
+type influxPoints struct {
+	*EmitInflux
+	base  string
+	tinit map[influxSide]time.Time
+	dir   map[node.Flow]node.Direction
+	tags  map[node.Flow]string
+	buf   bytes.Buffer
+	n     int
+}
+
+// influxSide identifies one side of a Flow.
+type influxSide struct {
+	flow   node.Flow
+	server bool
+}
+
+// newInfluxPoints returns a new influxPoints, with the Test ID keys and Tag as
+// the base tags for each point.
+func newInfluxPoints(x *EmitInflux, test *Test) *influxPoints {
+	t := make(map[string]string)
+	if test != nil {
+		for k, v := range test.ID {
+			t[k] = v
+		}
+	}
+	for k, v := range x.Tag {
+		t[k] = v
+	}
+	return &influxPoints{
+		EmitInflux: x,
+		base:       influxTags(t),
+		tinit:      make(map[influxSide]time.Time),
+		dir:        make(map[node.Flow]node.Direction),
+		tags:       make(map[node.Flow]string),
+	}
+}
+
+// add adds a point for the data item, if it's a supported type.
+func (p *influxPoints) add(a any) {
+	switch v := a.(type) {
+	case node.StreamInfo:
+		p.info(v.Flow, v.Server, v.Tinit, v.Tags)
+		if v.Direction != "" {
+			p.dir[v.Flow] = v.Direction
+		}
+	case node.PacketInfo:
+		p.info(v.Flow, v.Server, v.Tinit, v.Tags)
+	case node.StreamIO:
+		d := "rcvd"
+		if v.Sent {
+			d = "sent"
+		}
+		p.point("stream_io", v.Flow, !v.Sent, v.T, "dir="+d,
+			"bytes="+strconv.FormatUint(uint64(v.Total), 10)+"i")
+	case node.PacketIO:
+		s, d := "client", "rcvd"
+		if v.Server {
+			s = "server"
+		}
+		if v.Sent {
+			d = "sent"
+		}
+		p.point("packet_io", v.Flow, v.Server, v.T, "dir="+d+",side="+s,
+			"seq="+strconv.FormatUint(uint64(v.Seq), 10)+"i,"+
+				"len="+strconv.Itoa(v.Len)+"i")
+	case node.TCPInfo:
+		s := "sender"
+		if v.Receiver {
+			s = "receiver"
+		}
+		ms := func(d time.Duration) float64 {
+			return float64(d) / float64(time.Millisecond)
+		}
+		f := fmt.Sprintf("rtt_ms=%g,rttvar_ms=%g,cwnd=%di,"+
+			"delivery_rate_bps=%di,pacing_rate_bps=%di,retransmits=%di,"+
+			"min_rtt_ms=%g,bytes_acked=%di,bytes_retrans=%di,"+
+			"delivered=%di,delivered_ce=%di,snd_wnd=%di,rcv_wnd=%di,"+
+			"busy_time_ms=%g,rwnd_limited_ms=%g,sndbuf_limited_ms=%g",
+			ms(v.RTT), ms(v.RTTVar), v.SendCwnd, int64(v.DeliveryRate),
+			int64(v.PacingRate), v.TotalRetransmits, ms(v.MinRTT),
+			v.BytesAcked, v.BytesRetrans, v.Delivered, v.DeliveredCE,
+			v.SendWnd, v.RcvWnd, ms(v.BusyTime), ms(v.RwndLimited),
+			ms(v.SndbufLimited))
+		p.point("tcp_info", v.Flow, (p.dir[v.Flow] == node.Down) != v.Receiver,
+			v.T, "side="+s, f)
+	}
+}
+
+// info records the base time and Tags for one side of a Flow.
+func (p *influxPoints) info(flow node.Flow, server bool, tinit time.Time,
+	tags node.Tags) {
+	p.tinit[influxSide{flow, server}] = tinit
+	if _, ok := p.tags[flow]; !ok || len(tags) > 0 {
+		p.tags[flow] = influxTags(tags)
+	}
+}
+
+// point adds a point for the given measurement, Flow and side of the Flow. The
+// point is skipped if the base time for the side isn't known yet.
+func (p *influxPoints) point(measurement string, flow node.Flow, server bool,
+	t metric.RelativeTime, tags, fields string) {
+	i, ok := p.tinit[influxSide{flow, server}]
+	if !ok {
+		return
+	}
+	b := &p.buf
+	b.WriteString(influxEscape(p.Prefix+measurement, ", "))
+	b.WriteString(p.base)
+	b.WriteString(p.tags[flow])
+	b.WriteString(",flow=")
+	b.WriteString(influxEscape(string(flow), ",= "))
+	b.WriteByte(',')
+	b.WriteString(tags)
+	b.WriteByte(' ')
+	b.WriteString(fields)
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatInt(t.Time(i).UnixNano(), 10))
+	b.WriteByte('\n')
+	p.n++
+}
+
+// take returns the batch, and starts a new one.
+func (p *influxPoints) take() (batch []byte) {
+	batch = bytes.Clone(p.buf.Bytes())
+	p.buf.Reset()
+	p.n = 0
+	return
+}
+
+// influxTags returns the tags in the line protocol, sorted by key, with a
+// leading comma. Tags with empty keys or values are omitted.
+func influxTags(tags map[string]string) string {
+	var kk []string
+	for k, v := range tags {
+		if k != "" && v != "" {
+			kk = append(kk, k)
+		}
+	}
+	sort.Strings(kk)
+	var b strings.Builder
+	for _, k := range kk {
+		b.WriteByte(',')
+		b.WriteString(influxEscape(k, ",= "))
+		b.WriteByte('=')
+		b.WriteString(influxEscape(tags[k], ",= "))
+	}
+	return b.String()
+}
+
+// influxEscape escapes backslashes and the given special characters with a
+// backslash.
+func influxEscape(s, special string) string {
+	if !strings.ContainsAny(s, special+`\`) {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if r == '\\' || strings.ContainsRune(special, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -3,15 +3,34 @@
 
 package antler
 
-import "io"
+import (
+	"context"
+	"io"
+)
 
 // multiWriteCloser returns an io.MultiWriter that uses the given WriteClosers
 // as the Writers, so that writes to the returned Writer are duplicated to each
-// of the given WriteClosers.
-func multiWriteCloser(wc ...io.WriteCloser) io.Writer {
+// of the given WriteClosers. Writes fail once the given Context is done.
+func multiWriteCloser(ctx context.Context, wc ...io.WriteCloser) io.Writer {
 	var ww []io.Writer
 	for _, w := range wc {
 		ww = append(ww, w)
 	}
-	return io.MultiWriter(ww...)
+	return contextWriter{ctx, io.MultiWriter(ww...)}
+}
+
+// contextWriter is an io.Writer that returns the cause of the Context's
+// cancellation instead of writing, once the Context is done. It's used as a
+// cancellation checkpoint for long running writes, like template execution.
+type contextWriter struct {
+	ctx context.Context
+	io.Writer
+}
+
+// Write implements io.Writer
+func (c contextWriter) Write(p []byte) (n int, err error) {
+	if err = context.Cause(c.ctx); err != nil {
+		return
+	}
+	return c.Writer.Write(p)
 }
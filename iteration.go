@@ -0,0 +1,288 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/heistp/antler/node"
+	"gonum.org/v1/gonum/stat"
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+// IterationKey is the Test ID key added to each iteration of a Test with
+// Iterations greater than one, with the iteration number, from 1, as its value.
+const IterationKey = "iteration"
+
+// iteration identifies one iteration of a Test.
+type iteration struct {
+	// Number is the iteration number, from 1.
+	Number int
+
+	// ID is the Test ID, without IterationKey.
+	ID TestID
+
+	// Path is the Test's Path, without the iteration prefix.
+	Path string
+}
+
+// expandIterations returns the Tests with each Test that has Iterations greater
+// than one replaced by that many copies, each with IterationKey added to its
+// ID, and its Path followed by "iter<n>_", so the data for each iteration is
+// stored separately. Dependencies on an expanded Test are replaced by
+// dependencies on all of its iterations.
+func (s Tests) expandIterations() (x Tests, err error) {
+	iter := make(map[string][]TestID)
+	for _, t := range s {
+		if t.Iterations <= 1 {
+			x = append(x, t)
+			continue
+		}
+		if _, ok := t.ID[IterationKey]; ok {
+			err = fmt.Errorf("test %s with Iterations may not have ID key '%s'",
+				t.ID, IterationKey)
+			return
+		}
+		for i := 1; i <= t.Iterations; i++ {
+			c := t
+			c.ID = maps.Clone(t.ID)
+			if c.ID == nil {
+				c.ID = make(TestID)
+			}
+			c.ID[IterationKey] = strconv.Itoa(i)
+			c.Path = fmt.Sprintf("%siter%d_", t.Path, i)
+			c.iteration = &iteration{i, t.ID, t.Path}
+			x = append(x, c)
+			iter[t.ID.String()] = append(iter[t.ID.String()], c.ID)
+		}
+	}
+	if len(iter) == 0 {
+		return
+	}
+	for i := range x {
+		t := &x[i]
+		var d []TestID
+		for _, id := range t.DependsOn {
+			if ii, ok := iter[id.String()]; ok {
+				d = append(d, ii...)
+			} else {
+				d = append(d, id)
+			}
+		}
+		t.DependsOn = d
+	}
+	return
+}
+
+// AggregateIterations is a multiReporter that calculates the mean, standard
+// deviation and confidence interval of the mean of each flow's summary
+// statistics (as for EmitStats) across the iterations of each Test with
+// Iterations greater than one. The results are written as JSON to To, under
+// the Test's Path.
+type AggregateIterations struct {
+	// To is the name of the file to write under each Test's Path.
+	To string
+
+	// Confidence is the confidence level of the confidence intervals, from 0
+	// to 1.
+	Confidence float64
+
+	stats map[string]*iterationStats
+	sync.Mutex
+}
+
+// iterationStats records the Stats for the iterations of a Test.
+type iterationStats struct {
+	iteration
+	stats map[int]*Stats
+}
+
+// Aggregate contains the aggregate statistics across a Test's iterations.
+type Aggregate struct {
+	// ID is the Test ID, without IterationKey.
+	ID TestID
+
+	// Iterations is the number of iterations with data.
+	Iterations int
+
+	// Confidence is the confidence level of the confidence intervals.
+	Confidence float64
+
+	// Metric lists the aggregate statistics for each flow metric.
+	Metric []AggregateMetric
+}
+
+// AggregateMetric contains the aggregate statistics for one flow metric.
+type AggregateMetric struct {
+	// Flow is the flow the metric is for.
+	Flow node.Flow
+
+	// Direction is "up" or "down" for packet flow metrics, or empty.
+	Direction string `json:",omitempty"`
+
+	// Name is the name of the metric, as in the EmitStats CSV header.
+	Name string
+
+	// N is the number of iterations with a value for the metric.
+	N int
+
+	// Mean is the mean value across the iterations.
+	Mean float64
+
+	// StdDev is the sample standard deviation across the iterations.
+	StdDev float64
+
+	// CI is the confidence interval of the mean, using Student's t
+	// distribution, or nil if N is less than two.
+	CI *[2]float64 `json:",omitempty"`
+
+	// Value lists the metric's values, in iteration order.
+	Value []float64
+}
+
+// validate returns an error if the AggregateIterations is invalid.
+func (a *AggregateIterations) validate() (err error) {
+	if a.Confidence <= 0 || a.Confidence >= 1 {
+		err = fmt.Errorf("AggregateIterations Confidence must be between "+
+			"0 and 1: %f", a.Confidence)
+	}
+	return
+}
+
+// report implements multiReporter to gather the Stats for each iteration. The
+// Stats are taken from the analysis if the Analyze report was run, or
+// calculated from the data otherwise.
+func (a *AggregateIterations) report(ctx context.Context, work resultRW,
+	test *Test, data <-chan any) error {
+	var y *analysis
+	z := newAnalysis()
+	for d := range data {
+		if test.iteration == nil {
+			continue
+		}
+		switch v := d.(type) {
+		case analysis:
+			y = &v
+		default:
+			if y == nil {
+				z.add(d)
+			}
+		}
+	}
+	if test.iteration == nil {
+		return nil
+	}
+	if y == nil {
+		z.analyze()
+		y = &z
+	}
+	a.Lock()
+	defer a.Unlock()
+	if a.stats == nil {
+		a.stats = make(map[string]*iterationStats)
+	}
+	k := test.iteration.ID.String()
+	s, ok := a.stats[k]
+	if !ok {
+		s = &iterationStats{*test.iteration, make(map[int]*Stats)}
+		a.stats[k] = s
+	}
+	s.stats[test.iteration.Number] = newStats(*y)
+	return nil
+}
+
+// stop implements multiStopper to write the aggregate statistics.
+func (a *AggregateIterations) stop(work resultRW) (err error) {
+	a.Lock()
+	defer a.Unlock()
+	for _, s := range a.stats {
+		if err = a.write(work, s); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// write writes the Aggregate for a Test's iterations.
+func (a *AggregateIterations) write(work resultRW, s *iterationStats) (
+	err error) {
+	w := work.Child(s.Path).Writer(a.To)
+	defer func() {
+		if e := w.Close(); e != nil && err == nil {
+			err = e
+		}
+	}()
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	err = e.Encode(a.aggregate(s))
+	return
+}
+
+// aggregate returns the Aggregate for a Test's iterations.
+func (a *AggregateIterations) aggregate(s *iterationStats) (g Aggregate) {
+	g = Aggregate{
+		ID:         s.ID,
+		Iterations: len(s.stats),
+		Confidence: a.Confidence,
+		Metric:     []AggregateMetric{},
+	}
+	type key struct {
+		flow node.Flow
+		dir  string
+		name string
+	}
+	var kk []key
+	vv := make(map[key][]float64)
+	add := func(k key, v float64) {
+		if _, ok := vv[k]; !ok {
+			kk = append(kk, k)
+		}
+		vv[k] = append(vv[k], v)
+	}
+	nn := slices.Collect(maps.Keys(s.stats))
+	sort.Ints(nn)
+	for _, n := range nn {
+		t := s.stats[n]
+		for _, m := range t.Stream {
+			add(key{m.Flow, "", "goodput_mbps"}, m.Goodput)
+			add(key{m.Flow, "", "fct_ms"}, m.FCT)
+		}
+		for _, m := range t.Packet {
+			add(key{m.Flow, m.Direction, "lost_pct"}, m.LostPct)
+			if m.OWD != nil {
+				add(key{m.Flow, m.Direction, "owd_mean_ms"}, m.OWD.Mean)
+				add(key{m.Flow, m.Direction, "owd_p99_ms"}, m.OWD.P99)
+			}
+		}
+	}
+	for _, k := range kk {
+		v := vv[k]
+		m := AggregateMetric{
+			Flow:      k.flow,
+			Direction: k.dir,
+			Name:      k.name,
+			N:         len(v),
+			Value:     v,
+		}
+		m.Mean, m.StdDev = stat.MeanStdDev(v, nil)
+		if m.N >= 2 {
+			t := distuv.StudentsT{Mu: 0, Sigma: 1, Nu: float64(m.N - 1)}
+			h := t.Quantile((1+a.Confidence)/2) * m.StdDev /
+				math.Sqrt(float64(m.N))
+			m.CI = &[2]float64{m.Mean - h, m.Mean + h}
+		} else {
+			m.StdDev = 0
+		}
+		g.Metric = append(g.Metric, m)
+	}
+	return
+}
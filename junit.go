@@ -0,0 +1,164 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JUnit is a multiReporter that writes a JUnit XML file, with a test case for
+// each Test, so that results may be shown in CI test result views (e.g. GitLab
+// or Jenkins). A test case fails if the Test's data contains any errors.
+type JUnit struct {
+	// To is the path to the JUnit XML file to write.
+	To string
+
+	// Suite is the name of the test suite, also used as the test case class
+	// name.
+	Suite string
+
+	test []junitEntry
+	sync.Mutex
+}
+
+// junitEntry records a Test, its errors, and the time range of its data.
+type junitEntry struct {
+	test   *Test
+	errors []string
+	start  time.Time
+	end    time.Time
+}
+
+// report implements multiReporter to gather the Tests, and their errors.
+func (j *JUnit) report(ctx context.Context, work resultRW, test *Test,
+	in <-chan any) error {
+	e := junitEntry{test: test}
+	for d := range in {
+		if r, ok := d.(error); ok {
+			e.errors = append(e.errors, r.Error())
+		}
+		if l, ok := d.(LogEntry); ok {
+			t := l.GetLogEntry().Time
+			if e.start.IsZero() || t.Before(e.start) {
+				e.start = t
+			}
+			if t.After(e.end) {
+				e.end = t
+			}
+		}
+	}
+	j.Lock()
+	j.test = append(j.test, e)
+	j.Unlock()
+	return nil
+}
+
+// stop implements multiStopper to write the JUnit XML file.
+func (j *JUnit) stop(work resultRW) (err error) {
+	w := work.Writer(j.To)
+	defer func() {
+		if e := w.Close(); e != nil && err == nil {
+			err = e
+		}
+	}()
+	if _, err = w.Write([]byte(xml.Header)); err != nil {
+		return
+	}
+	x := xml.NewEncoder(w)
+	x.Indent("", "  ")
+	if err = x.Encode(j.suites()); err != nil {
+		return
+	}
+	_, err = w.Write([]byte("\n"))
+	return
+}
+
+// suites returns the junitSuites for the gathered Tests, with test cases
+// sorted by name.
+func (j *JUnit) suites() (s junitSuites) {
+	j.Lock()
+	defer j.Unlock()
+	u := junitSuite{Name: j.Suite}
+	var d time.Duration
+	for _, e := range j.test {
+		c := junitCase{
+			Name:      strings.Trim(e.test.ID.String(), "[]"),
+			ClassName: j.Suite,
+		}
+		if t := e.end.Sub(e.start); t > 0 {
+			c.Time = junitTime(t)
+			d += t
+		}
+		if len(e.errors) > 0 {
+			m := e.errors[0]
+			if len(e.errors) > 1 {
+				m = fmt.Sprintf("%s (and %d more errors)", m, len(e.errors)-1)
+			}
+			c.Failure = &junitFailure{
+				Message: m,
+				Type:    "error",
+				Text:    strings.Join(e.errors, "\n"),
+			}
+			u.Failures++
+		}
+		u.Case = append(u.Case, c)
+	}
+	sort.Slice(u.Case, func(i, j int) bool {
+		return u.Case[i].Name < u.Case[j].Name
+	})
+	u.Tests = len(u.Case)
+	u.Time = junitTime(d)
+	u.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	s.Tests = u.Tests
+	s.Failures = u.Failures
+	s.Time = u.Time
+	s.Suite = []junitSuite{u}
+	return
+}
+
+// junitTime formats a Duration as seconds, for the time attributes.
+func junitTime(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// junitSuites is the testsuites element of a JUnit XML file.
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Time     string       `xml:"time,attr"`
+	Suite    []junitSuite `xml:"testsuite"`
+}
+
+// junitSuite is a testsuite element.
+type junitSuite struct {
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Errors    int         `xml:"errors,attr"`
+	Time      string      `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr"`
+	Case      []junitCase `xml:"testcase"`
+}
+
+// junitCase is a testcase element.
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr,omitempty"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+// junitFailure is a failure element.
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
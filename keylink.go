@@ -0,0 +1,100 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// keyLinkPrefix is the prefix for the names of the directories under RootDir
+// that contain the symlink trees for each Test ID key in KeySymlinks.
+const keyLinkPrefix = "by-"
+
+// linkKeys adds symlinks to the result directory in the symlink tree for each
+// Test ID key in KeySymlinks, using the values of the key in the given Tests
+// that wrote new files to the result. For example, for the key cca, the symlink
+// RootDir/by-cca/bbr/2006-01-02-150405Z is added if a Test with cca=bbr wrote
+// new files. Symlinks to result directories that no longer exist are removed,
+// along with any directories that become empty as a result.
+func (r resultRW) linkKeys(resultDir string, tests Tests) (err error) {
+	if len(r.KeySymlinks) == 0 || resultDir == "" {
+		return
+	}
+	n := filepath.Base(resultDir)
+	w := r.New()
+	for _, k := range r.KeySymlinks {
+		d := filepath.Join(r.RootDir, keyLinkPrefix+k)
+		if err = r.pruneKeyLinks(d); err != nil {
+			return
+		}
+		for i := range tests {
+			t := &tests[i]
+			v, ok := t.ID[k]
+			if !ok || len(w.withPrefix(t.Path)) == 0 {
+				continue
+			}
+			vd := filepath.Join(d, v)
+			if err = r.perm.mkdirAll(vd); err != nil {
+				return
+			}
+			l := filepath.Join(vd, n)
+			if err = os.Symlink(filepath.Join("..", "..", n), l); err != nil {
+				if errors.Is(err, fs.ErrExist) {
+					err = nil
+					continue
+				}
+				return
+			}
+			if err = r.perm.apply(l, 0); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// pruneKeyLinks removes the dangling symlinks in the symlink tree under dir,
+// and any value directories that are empty as a result.
+func (r resultRW) pruneKeyLinks(dir string) (err error) {
+	var vv []fs.DirEntry
+	if vv, err = os.ReadDir(dir); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+		return
+	}
+	for _, v := range vv {
+		if !v.IsDir() {
+			continue
+		}
+		vd := filepath.Join(dir, v.Name())
+		var ll []fs.DirEntry
+		if ll, err = os.ReadDir(vd); err != nil {
+			return
+		}
+		n := len(ll)
+		for _, l := range ll {
+			if l.Type()&fs.ModeSymlink == 0 {
+				continue
+			}
+			p := filepath.Join(vd, l.Name())
+			if _, e := os.Stat(p); !errors.Is(e, fs.ErrNotExist) {
+				continue
+			}
+			if err = os.Remove(p); err != nil {
+				return
+			}
+			n--
+		}
+		if n == 0 {
+			if err = os.Remove(vd); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
@@ -7,7 +7,6 @@ import (
 	"embed"
 	"io"
 	"io/fs"
-	"path/filepath"
 	"sort"
 
 	"github.com/heistp/antler/node"
@@ -19,10 +18,37 @@ var nodeBin embed.FS
 // nodeBinDir
 const nodeBinDir = "node/bin"
 
-// openNodeExe opens an embedded node executable for the given platform.
-func openNodeExe(platform string) (fs.File, error) {
-	n := node.PlatformExeName(platform)
-	return nodeBin.Open(filepath.Join(nodeBinDir, n.String()))
+// nodeExes, if not nil, replaces the embedded node executables (see
+// SetNodeExes).
+var nodeExes fs.FS
+
+// SetNodeExes sets the file system containing the node executables that are
+// launched on each node, replacing the executables embedded in the antler
+// package. The executables must be in the root of fsys, named as by
+// node.PlatformExeName (e.g. antler-node-linux-amd64). This is used by custom
+// antler executables to launch node executables built with custom runners and
+// data types (see node.RegisterRunner). It must be called before any commands
+// are run.
+func SetNodeExes(fsys fs.FS) {
+	nodeExes = fsys
+}
+
+// nodeExeFS returns the file system containing the node executables.
+func nodeExeFS() (fs.FS, error) {
+	if nodeExes != nil {
+		return nodeExes, nil
+	}
+	return fs.Sub(nodeBin, nodeBinDir)
+}
+
+// openNodeExe opens the node executable for the given platform.
+func openNodeExe(platform string) (f fs.File, err error) {
+	var x fs.FS
+	if x, err = nodeExeFS(); err != nil {
+		return
+	}
+	f, err = x.Open(node.PlatformExeName(platform).String())
+	return
 }
 
 // exeSource provides a node.ExeSource implementation for antler.
@@ -50,12 +76,19 @@ func (e *exeSource) Size(platform string) (size int64, err error) {
 
 // Platforms implements ExeSource
 func (e *exeSource) Platforms() (platforms []string, err error) {
+	var x fs.FS
+	if x, err = nodeExeFS(); err != nil {
+		return
+	}
 	var d []fs.DirEntry
-	if d, err = nodeBin.ReadDir(nodeBinDir); err != nil {
+	if d, err = fs.ReadDir(x, "."); err != nil {
 		return
 	}
 	for _, e := range d {
 		n := node.ExeName(e.Name())
+		if e.IsDir() || !n.Valid() {
+			continue
+		}
 		platforms = append(platforms, n.Platform())
 	}
 	sort.Strings(platforms)
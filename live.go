@@ -0,0 +1,510 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/heistp/antler/node"
+	"github.com/heistp/antler/node/metric"
+)
+
+// liveTemplate is the template for the live progress page.
+//
+//go:embed live.html.tmpl
+var liveTemplate string
+
+// liveGoodputLen is the maximum number of goodput samples kept for each flow.
+const liveGoodputLen = 60
+
+// liveLogLen is the maximum number of recent log lines kept.
+const liveLogLen = 50
+
+// liveMaxState is the maximum size of a liveState accepted by the server.
+const liveMaxState = 4 << 20
+
+// Live configures sending the live progress of a run to the builtin web
+// server, while the run command runs. The progress is shown on the server's
+// /live page (see Server.Live).
+type Live struct {
+	// URL is the base URL of the server, e.g. http://localhost:8080.
+	URL string
+
+	// TokenEnv, if not empty, is the name of an environment variable that
+	// contains a token, sent in the Authorization header.
+	TokenEnv string
+
+	// Host is the name the progress is shown under, or the host name if empty.
+	Host string
+
+	// Interval is the time between updates.
+	Interval metric.Duration
+
+	// Timeout is the maximum time for each request to the server.
+	Timeout metric.Duration
+}
+
+// validate returns an error if the Live config is invalid.
+func (l *Live) validate() (err error) {
+	if l.Interval <= 0 {
+		err = fmt.Errorf("Live Interval must be positive: %s", l.Interval)
+	}
+	return
+}
+
+// liveState is the progress of a run, sent to the server each Interval.
+type liveState struct {
+	Host    string     `json:"host"`
+	Start   time.Time  `json:"start"`
+	Updated time.Time  `json:"updated"`
+	Done    bool       `json:"done"`
+	Test    []liveTest `json:"tests"`
+	Flow    []liveFlow `json:"flows"`
+	Log     []string   `json:"log"`
+}
+
+// liveTest is the progress of a Test.
+type liveTest struct {
+	ID      string    `json:"id"`
+	State   string    `json:"state"`
+	Start   time.Time `json:"-"`
+	Elapsed float64   `json:"elapsed"`
+	Items   int       `json:"items"`
+	Bytes   int64     `json:"bytes"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// liveFlow is a stream flow that's in flight, with its recent goodput in
+// Mbps, one sample per Interval.
+type liveFlow struct {
+	Test    string    `json:"test"`
+	Flow    string    `json:"flow"`
+	Total   int64     `json:"total"`
+	Goodput []float64 `json:"goodput"`
+}
+
+// liveFlowKey identifies a flow in a Test, by the Test's ID string.
+type liveFlowKey struct {
+	test string
+	flow node.Flow
+}
+
+// liveFlowState records the received bytes for a flow.
+type liveFlowState struct {
+	liveFlow
+	total metric.Bytes
+	prior metric.Bytes
+	last  time.Time
+}
+
+// liveFeed gathers the progress of a run from its Events and During report
+// pipelines, and sends it to the server each Interval.
+type liveFeed struct {
+	*Live
+	host   string
+	token  string
+	client *http.Client
+	failed func(error)
+	fail   bool
+	state  liveState
+	test   map[string]int
+	flow   map[liveFlowKey]*liveFlowState
+	tick   time.Time
+	mtx    sync.Mutex
+	stopc  chan struct{}
+	done   chan struct{}
+}
+
+// newLiveFeed returns a new liveFeed for the given Live config. failed, if not
+// nil, is called when updates to the server fail, at most once until they
+// succeed again.
+func newLiveFeed(l *Live, failed func(error)) *liveFeed {
+	f := &liveFeed{
+		Live:   l,
+		client: &http.Client{Timeout: l.Timeout.Duration()},
+		failed: failed,
+		test:   make(map[string]int),
+		flow:   make(map[liveFlowKey]*liveFlowState),
+		stopc:  make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if f.TokenEnv != "" {
+		f.token = os.Getenv(f.TokenEnv)
+	}
+	if f.host = f.Host; f.host == "" {
+		if f.host, _ = os.Hostname(); f.host == "" {
+			f.host = "unknown"
+		}
+	}
+	f.state.Host = f.host
+	f.state.Start = time.Now()
+	f.state.Log = []string{}
+	f.tick = f.state.Start
+	return f
+}
+
+// start adds the Tests that will run as pending, and starts sending updates
+// in a goroutine.
+func (f *liveFeed) start(ctx context.Context, tests []*Test) {
+	f.mtx.Lock()
+	for _, t := range tests {
+		f.testIndex(t)
+	}
+	f.mtx.Unlock()
+	go func() {
+		defer close(f.done)
+		t := time.NewTicker(f.Interval.Duration())
+		defer t.Stop()
+		for {
+			f.report(f.send(ctx, f.snapshot(false)))
+			select {
+			case <-t.C:
+			case <-f.stopc:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// stop stops the update goroutine and sends the final update.
+func (f *liveFeed) stop(ctx context.Context) {
+	close(f.stopc)
+	<-f.done
+	f.report(f.send(ctx, f.snapshot(true)))
+}
+
+// report calls failed for the first error after a success.
+func (f *liveFeed) report(err error) {
+	if err == nil {
+		f.fail = false
+		return
+	}
+	if !f.fail && f.failed != nil {
+		f.failed(err)
+	}
+	f.fail = true
+}
+
+// event implements RunCommand.Event to update the state of the Tests.
+func (f *liveFeed) event(e Event) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	t := &f.state.Test[f.testIndex(e.EventTest())]
+	switch v := e.(type) {
+	case TestStarted:
+		t.State = "running"
+		t.Start = v.Time
+	case RunnerStarted:
+		f.log(node.LogEntry{Time: v.Time, NodeID: v.NodeID, Tag: v.Runner,
+			Text: "started"}.String())
+	case TestProgress:
+		t.Items = 0
+		for _, n := range v.Count {
+			t.Items += n
+		}
+		t.Bytes = v.Bytes
+	case TestDone:
+		t.State = "done"
+		t.Elapsed = v.Elapsed.Seconds()
+		if v.Err != nil {
+			t.State = "failed"
+			t.Error = v.Err.Error()
+		}
+		for k := range f.flow {
+			if k.test == t.ID {
+				delete(f.flow, k)
+			}
+		}
+	}
+}
+
+// testIndex returns the index of the Test in state, adding it as pending if
+// it's not there. The mutex must be held.
+func (f *liveFeed) testIndex(test *Test) int {
+	d := test.ID.String()
+	if i, ok := f.test[d]; ok {
+		return i
+	}
+	i := len(f.state.Test)
+	f.state.Test = append(f.state.Test, liveTest{ID: d, State: "pending"})
+	f.test[d] = i
+	return i
+}
+
+// log adds a recent log line. The mutex must be held.
+func (f *liveFeed) log(line string) {
+	f.state.Log = append(f.state.Log, line)
+	if n := len(f.state.Log); n > liveLogLen {
+		f.state.Log = f.state.Log[n-liveLogLen:]
+	}
+}
+
+// reporter returns a reporter for the Test's During pipeline, which records
+// flow goodput and log lines.
+func (f *liveFeed) reporter(test *Test) reporter {
+	return liveReporter{f, test}
+}
+
+// snapshot returns the current state, and updates the goodput of the flows
+// since the last snapshot. Flows without data for 5 Intervals are removed.
+func (f *liveFeed) snapshot(done bool) (s liveState) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	n := time.Now()
+	d := n.Sub(f.tick).Seconds()
+	f.tick = n
+	x := 5 * f.Interval.Duration()
+	s = f.state
+	s.Updated = n
+	s.Done = done
+	s.Test = append([]liveTest{}, f.state.Test...)
+	for i := range s.Test {
+		t := &s.Test[i]
+		if t.State == "running" {
+			t.Elapsed = n.Sub(t.Start).Seconds()
+		}
+	}
+	s.Log = append([]string{}, f.state.Log...)
+	s.Flow = []liveFlow{}
+	for k, l := range f.flow {
+		if n.Sub(l.last) > x {
+			delete(f.flow, k)
+			continue
+		}
+		if d > 0 {
+			g := float64(l.total-l.prior) * 8 / d / 1e6
+			l.Goodput = append(l.Goodput, g)
+			if m := len(l.Goodput); m > liveGoodputLen {
+				l.Goodput = l.Goodput[m-liveGoodputLen:]
+			}
+		}
+		l.prior = l.total
+		l.Total = int64(l.total)
+		c := l.liveFlow
+		c.Goodput = append([]float64{}, l.Goodput...)
+		s.Flow = append(s.Flow, c)
+	}
+	sort.Slice(s.Flow, func(i, j int) bool {
+		a, b := s.Flow[i], s.Flow[j]
+		if a.Test != b.Test {
+			return a.Test < b.Test
+		}
+		return a.Flow < b.Flow
+	})
+	return
+}
+
+// send sends the state to the server.
+func (f *liveFeed) send(ctx context.Context, state liveState) (err error) {
+	var b []byte
+	if b, err = json.Marshal(state); err != nil {
+		return
+	}
+	u := strings.TrimSuffix(f.URL, "/") + "/live/" + url.PathEscape(f.host)
+	var q *http.Request
+	if q, err = http.NewRequestWithContext(ctx, http.MethodPut, u,
+		bytes.NewReader(b)); err != nil {
+		return
+	}
+	q.Header.Set("Content-Type", "application/json")
+	if f.token != "" {
+		q.Header.Set("Authorization", "Bearer "+f.token)
+	}
+	var r *http.Response
+	if r, err = f.client.Do(q); err != nil {
+		return
+	}
+	defer r.Body.Close()
+	if r.StatusCode/100 != 2 {
+		t, _ := io.ReadAll(io.LimitReader(r.Body, 512))
+		err = fmt.Errorf("live PUT %s: %s: %s", u,
+			http.StatusText(r.StatusCode), strings.TrimSpace(string(t)))
+		return
+	}
+	_, err = io.Copy(io.Discard, r.Body)
+	return
+}
+
+// liveReporter is an internal reporter that sends the received bytes of
+// stream flows, log entries and errors to a liveFeed.
+type liveReporter struct {
+	feed *liveFeed
+	test *Test
+}
+
+// report implements reporter
+func (r liveReporter) report(ctx context.Context, rw rwer, in <-chan any,
+	out chan<- any) (err error) {
+	f := r.feed
+	for d := range in {
+		switch v := d.(type) {
+		case node.StreamIO:
+			if v.Sent {
+				break
+			}
+			k := liveFlowKey{r.test.ID.String(), v.Flow}
+			f.mtx.Lock()
+			l, ok := f.flow[k]
+			if !ok {
+				l = &liveFlowState{liveFlow: liveFlow{
+					Test: k.test,
+					Flow: string(v.Flow),
+				}}
+				f.flow[k] = l
+			}
+			l.total = v.Total
+			l.last = time.Now()
+			f.mtx.Unlock()
+		case node.LogEntry:
+			f.mtx.Lock()
+			f.log(v.String())
+			f.mtx.Unlock()
+		case error:
+			f.mtx.Lock()
+			f.log(v.Error())
+			f.mtx.Unlock()
+		}
+		out <- d
+	}
+	return
+}
+
+// liveHandler is the http.Handler for the /live endpoints of the builtin web
+// server. It keeps the latest state sent by each host, and streams the states
+// to browsers as server-sent events.
+type liveHandler struct {
+	token string
+	page  *template.Template
+	state map[string]json.RawMessage
+	sub   map[chan struct{}]struct{}
+	stopc chan struct{}
+	mtx   sync.Mutex
+}
+
+// newLiveHandler returns a new liveHandler, with the page styled using the
+// given Theme.
+func newLiveHandler(token string, theme Theme) (h *liveHandler, err error) {
+	var t *template.Template
+	if t, err = newStyleTemplate(theme); err != nil {
+		return
+	}
+	t = t.New("Live")
+	if t, err = t.Parse(liveTemplate); err != nil {
+		return
+	}
+	h = &liveHandler{
+		token: token,
+		page:  t,
+		state: make(map[string]json.RawMessage),
+		sub:   make(map[chan struct{}]struct{}),
+		stopc: make(chan struct{}),
+	}
+	return
+}
+
+// register registers the handlers for the endpoint.
+func (h *liveHandler) register(m *http.ServeMux) {
+	m.HandleFunc("GET /live", h.get)
+	m.HandleFunc("GET /live/events", h.events)
+	m.HandleFunc("PUT /live/{host}", tokenAuth(h.token, h.put))
+}
+
+// stop ends any event streams, so the server may shut down.
+func (h *liveHandler) stop() {
+	close(h.stopc)
+}
+
+// get serves the live page.
+func (h *liveHandler) get(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := h.page.Execute(w, nil); err != nil {
+		log.Printf("live: %s", err)
+	}
+}
+
+// put stores the state for a host, and notifies the event streams.
+func (h *liveHandler) put(w http.ResponseWriter, r *http.Request) {
+	n := r.PathValue("host")
+	if !collectName(n) {
+		http.Error(w, fmt.Sprintf("invalid name: '%s'", n),
+			http.StatusBadRequest)
+		return
+	}
+	b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, liveMaxState))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !json.Valid(b) {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	h.mtx.Lock()
+	h.state[n] = json.RawMessage(b)
+	for c := range h.sub {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+	h.mtx.Unlock()
+}
+
+// events streams the states of all hosts as server-sent events, once when
+// connected, then after each update.
+func (h *liveHandler) events(w http.ResponseWriter, r *http.Request) {
+	l, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	c := make(chan struct{}, 1)
+	c <- struct{}{}
+	h.mtx.Lock()
+	h.sub[c] = struct{}{}
+	h.mtx.Unlock()
+	defer func() {
+		h.mtx.Lock()
+		delete(h.sub, c)
+		h.mtx.Unlock()
+	}()
+	for {
+		select {
+		case <-c:
+			h.mtx.Lock()
+			b, err := json.Marshal(h.state)
+			h.mtx.Unlock()
+			if err != nil {
+				log.Printf("live: %s", err)
+				return
+			}
+			if _, err = fmt.Fprintf(w, "data: %s\n\n", b); err != nil {
+				return
+			}
+			l.Flush()
+		case <-r.Context().Done():
+			return
+		case <-h.stopc:
+			return
+		}
+	}
+}
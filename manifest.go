@@ -0,0 +1,223 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/heistp/antler/node/metric"
+	"github.com/heistp/antler/version"
+)
+
+// manifestFile is the name of the file in the result directory that records
+// the Manifest.
+const manifestFile = "manifest.json"
+
+// Manifest records information about the controller and the antler and node
+// executables that produced a result, along with the controller's resource
+// usage for each Test, so that anomalies caused by the measurement machinery
+// itself may be spotted later.
+type Manifest struct {
+	// Version is the antler version.
+	Version string
+
+	// GoVersion is the Go version antler was built with.
+	GoVersion string
+
+	// Host is the controller's hostname.
+	Host string
+
+	// OS and Arch are the controller's operating system and architecture.
+	OS   string
+	Arch string
+
+	// NumCPU is the number of logical CPUs on the controller.
+	NumCPU int
+
+	// Executable is the SHA-256 hash of the antler executable.
+	Executable string `json:",omitempty"`
+
+	// Node maps node platforms to the SHA-256 hashes of the embedded node
+	// executables.
+	Node map[string]string `json:",omitempty"`
+
+	// Order lists the IDs of all the Tests in the config, in the order they're
+	// considered for running, after sorting by Priority, Order and DependsOn.
+	// Tests may be omitted from Test if they were filtered, linked or skipped.
+	Order []TestID
+
+	// Test lists the controller's usage for each Test that was run.
+	Test []TestUsage
+
+	// Window lists the decisions made for Tests with a Window.
+	Window []WindowDecision `json:",omitempty"`
+
+	// Feedback lists the Feedback values exported by Tests to the run's
+	// Feedback store.
+	Feedback []FeedbackValue `json:",omitempty"`
+}
+
+// TestUsage records the controller's resource usage while running a Test,
+// including its During reports, and its After reports if they weren't
+// deferred. Usage is for the whole antler process, so it includes any deferred
+// After reports or collector uploads for other Tests that ran concurrently.
+type TestUsage struct {
+	// ID is the Test ID.
+	ID TestID
+
+	// Start is the time the Test started.
+	Start time.Time
+
+	// Elapsed is the wall clock time taken by the Test.
+	Elapsed time.Duration
+
+	// UserCPU and SystemCPU are the user and system CPU time used.
+	UserCPU   time.Duration
+	SystemCPU time.Duration
+
+	// MaxRSS is the peak resident set size of the antler process, as of the
+	// end of the Test.
+	MaxRSS metric.Bytes
+
+	// Alloc is the number of bytes allocated on the heap.
+	Alloc metric.Bytes
+
+	// HeapInuse is the number of bytes in use by the heap at the end of the
+	// Test.
+	HeapInuse metric.Bytes
+
+	// NumGC is the number of garbage collections completed.
+	NumGC uint32
+}
+
+// manifest accumulates a Manifest during a run.
+type manifest struct {
+	Manifest
+	mtx sync.Mutex
+}
+
+// newManifest returns a new manifest with the controller and executable info
+// set. Errors hashing the executables are ignored, leaving the hashes empty.
+func newManifest() (m *manifest) {
+	m = &manifest{}
+	m.Version = version.Version()
+	m.GoVersion = runtime.Version()
+	m.Host, _ = os.Hostname()
+	m.OS = runtime.GOOS
+	m.Arch = runtime.GOARCH
+	m.NumCPU = runtime.NumCPU()
+	if h, e := executableHash(); e == nil {
+		m.Executable = hex.EncodeToString(h)
+	}
+	var x exeSource
+	pp, _ := x.Platforms()
+	for _, p := range pp {
+		r, e := x.Reader(p)
+		if e != nil {
+			continue
+		}
+		if h, e := hashReader(r); e == nil {
+			if m.Node == nil {
+				m.Node = make(map[string]string)
+			}
+			m.Node[p] = h
+		}
+		r.Close()
+	}
+	return
+}
+
+// start returns a func that, when called at the end of the given Test, adds
+// its TestUsage to the manifest.
+func (m *manifest) start(test *Test) (done func()) {
+	t := time.Now()
+	r0 := getRusage()
+	var s0 runtime.MemStats
+	runtime.ReadMemStats(&s0)
+	done = func() {
+		r1 := getRusage()
+		var s1 runtime.MemStats
+		runtime.ReadMemStats(&s1)
+		u := TestUsage{
+			ID:        test.ID,
+			Start:     t,
+			Elapsed:   time.Since(t),
+			UserCPU:   time.Duration(r1.Utime.Nano() - r0.Utime.Nano()),
+			SystemCPU: time.Duration(r1.Stime.Nano() - r0.Stime.Nano()),
+			MaxRSS:    metric.Bytes(r1.Maxrss * 1024),
+			Alloc:     metric.Bytes(s1.TotalAlloc - s0.TotalAlloc),
+			HeapInuse: metric.Bytes(s1.HeapInuse),
+			NumGC:     s1.NumGC - s0.NumGC,
+		}
+		m.mtx.Lock()
+		m.Test = append(m.Test, u)
+		m.mtx.Unlock()
+	}
+	return
+}
+
+// order sets the Order of the Tests in the manifest.
+func (m *manifest) order(tests Tests) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.Order = make([]TestID, 0, len(tests))
+	for _, t := range tests {
+		m.Order = append(m.Order, t.ID)
+	}
+}
+
+// window adds a WindowDecision to the manifest.
+func (m *manifest) window(d WindowDecision) {
+	m.mtx.Lock()
+	m.Window = append(m.Window, d)
+	m.mtx.Unlock()
+}
+
+// feedback adds the exported FeedbackValues to the manifest.
+func (m *manifest) feedback(vv []FeedbackValue) {
+	m.mtx.Lock()
+	m.Feedback = append(m.Feedback, vv...)
+	m.mtx.Unlock()
+}
+
+// save writes the Manifest to manifestFile in the result.
+func (m *manifest) save(rw resultRW) (err error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	w := rw.Writer(manifestFile)
+	defer func() {
+		if e := w.Close(); e != nil && err == nil {
+			err = e
+		}
+	}()
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	err = e.Encode(m.Manifest)
+	return
+}
+
+// getRusage returns the resource usage for the antler process, or the zero
+// value if it's not available.
+func getRusage() (r syscall.Rusage) {
+	syscall.Getrusage(syscall.RUSAGE_SELF, &r)
+	return
+}
+
+// hashReader returns the hex encoded SHA-256 hash of the data read from r.
+func hashReader(r io.Reader) (hash string, err error) {
+	h := sha256.New()
+	if _, err = io.Copy(h, r); err != nil {
+		return
+	}
+	hash = hex.EncodeToString(h.Sum(nil))
+	return
+}
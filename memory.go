@@ -0,0 +1,138 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"runtime/metrics"
+
+	"github.com/heistp/antler/node/metric"
+)
+
+// memoryCheckItems is the number of data items buffered between checks of the
+// heap size against the MemoryLimit.
+const memoryCheckItems = 4096
+
+// liveHeapMetric is the runtime metric for the live heap size, as of the last
+// garbage collection.
+const liveHeapMetric = "/gc/heap/live:bytes"
+
+// MemoryLimitError is returned when a reporter that buffers data in memory
+// finds that the live heap exceeds the Test's MemoryLimit.
+type MemoryLimitError struct {
+	Test     TestID
+	Reporter string
+	Heap     metric.Bytes
+	Limit    metric.Bytes
+}
+
+// Error implements error
+func (m MemoryLimitError) Error() string {
+	return fmt.Sprintf("test %s: %s exceeded MemoryLimit of %s "+
+		"(live heap %s)", m.Test, m.Reporter, m.Limit.IEC(), m.Heap.IEC())
+}
+
+// memoryLimitKey is the Context key for the memoryLimit.
+type memoryLimitKey struct{}
+
+// memoryLimit is the MemoryLimit for a Test, carried in the Context passed to
+// reporters.
+type memoryLimit struct {
+	test  TestID
+	limit metric.Bytes
+}
+
+// withMemoryLimit returns a Context that carries the Test's MemoryLimit, for
+// use by reporters that buffer data. If MemoryLimit is zero, ctx is returned.
+func withMemoryLimit(ctx context.Context, test *Test) context.Context {
+	if test.MemoryLimit == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, memoryLimitKey{},
+		memoryLimit{test.ID, test.MemoryLimit})
+}
+
+// memoryCheck checks the heap size against a MemoryLimit after every
+// memoryCheckItems items buffered by a reporter.
+type memoryCheck struct {
+	memoryLimit
+	reporter string
+	items    int
+	sample   []metrics.Sample
+}
+
+// newMemoryCheck returns a new memoryCheck for the reporter, using the
+// MemoryLimit carried in ctx, if any.
+func newMemoryCheck(ctx context.Context, reporter any) (c *memoryCheck) {
+	c = &memoryCheck{reporter: fmt.Sprintf("%T", reporter)}
+	if l, ok := ctx.Value(memoryLimitKey{}).(memoryLimit); ok {
+		c.memoryLimit = l
+		c.sample = []metrics.Sample{{Name: liveHeapMetric}}
+	}
+	return
+}
+
+// add records that an item was buffered, and returns MemoryLimitError if the
+// live heap exceeds the limit.
+func (c *memoryCheck) add() (err error) {
+	if c.limit == 0 {
+		return
+	}
+	if c.items++; c.items%memoryCheckItems != 0 {
+		return
+	}
+	metrics.Read(c.sample)
+	if c.sample[0].Value.Kind() != metrics.KindUint64 {
+		return
+	}
+	if h := metric.Bytes(c.sample[0].Value.Uint64()); h > c.limit {
+		err = MemoryLimitError{c.test, c.reporter, h, c.limit}
+	}
+	return
+}
+
+// spillFile is a temporary file that data items are spilled to when they can't
+// be buffered in memory. The file is removed when it's closed.
+type spillFile struct {
+	*os.File
+	encoder *gob.Encoder
+}
+
+// newSpillFile creates a new spillFile in the default temporary directory.
+func newSpillFile() (s *spillFile, err error) {
+	var f *os.File
+	if f, err = os.CreateTemp("", "antler-spill-*.gob"); err != nil {
+		return
+	}
+	s = &spillFile{f, gob.NewEncoder(f)}
+	return
+}
+
+// encode encodes a data item to the file.
+func (s *spillFile) encode(a any) error {
+	return s.encoder.Encode(&a)
+}
+
+// reader seeks to the start of the file, and returns a ReadCloser that removes
+// the file when it's closed.
+func (s *spillFile) reader() (r io.ReadCloser, err error) {
+	if _, err = s.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	r = s
+	return
+}
+
+// Close implements io.Closer
+func (s *spillFile) Close() (err error) {
+	err = s.File.Close()
+	if e := os.Remove(s.Name()); e != nil && err == nil {
+		err = e
+	}
+	return
+}
@@ -0,0 +1,165 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"sort"
+
+	"gonum.org/v1/gonum/stat"
+)
+
+// metricsFile is the name of the file EmitMetrics writes for each Test, which
+// is read when the result is added to the Catalog.
+const metricsFile = "metrics.json"
+
+// EmitMetrics is a reporter that writes key metrics for a Test to
+// metrics.json, so they may be searched in the Catalog of results. It requires
+// Analyze before it in the pipeline.
+type EmitMetrics struct {
+}
+
+// report implements reporter
+func (EmitMetrics) report(ctx context.Context, rw rwer, in <-chan any,
+	out chan<- any) (err error) {
+	var m Metrics
+	for d := range in {
+		out <- d
+		if a, ok := d.(analysis); ok {
+			m = newMetrics(a)
+		}
+	}
+	if m == nil {
+		return
+	}
+	if err = context.Cause(ctx); err != nil {
+		return
+	}
+	w := rw.Writer(metricsFile)
+	defer func() {
+		if e := w.Close(); e != nil && err == nil {
+			err = e
+		}
+	}()
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	err = e.Encode(m)
+	return
+}
+
+// Metrics contains named key metrics for a Test. Names for aggregate metrics
+// over all flows have no prefix (e.g. owd_p95_ms), and names for the metrics of
+// each flow are prefixed by the Flow and a dot (e.g. udp.owd_p95_ms). Delays
+// are in milliseconds, bitrates in Mbps, and percentages from 0 to 100.
+type Metrics map[string]float64
+
+// newMetrics returns the Metrics from an analysis. Any Metrics from a
+// MinimalSummary replace those calculated from the downsampled series.
+func newMetrics(y analysis) (m Metrics) {
+	m = make(Metrics)
+	var gput float64
+	for _, s := range y.streamList() {
+		g := s.Goodput().Mbps()
+		m[string(s.Flow)+".goodput_mbps"] = g
+		m[string(s.Flow)+".fct_ms"] = s.FCT.Duration().Seconds() * 1000
+		gput += g
+	}
+	if len(y.streams) > 0 {
+		m["goodput_mbps"] = gput
+	}
+	var sent, lost int
+	for _, p := range y.packetList() {
+		f := string(p.Flow) + "."
+		if p.Up.Sent > 0 {
+			m[f+"lost_up_pct"] = p.Up.LostPct
+		}
+		if p.Down.Sent > 0 {
+			m[f+"lost_down_pct"] = p.Down.LostPct
+		}
+		if p.Capacity > 0 {
+			m[f+"capacity_mbps"] = p.Capacity.Mbps()
+			m[f+"avail_bw_mbps"] = p.AvailBW.Mbps()
+		}
+		sent += p.Up.Sent + p.Down.Sent
+		lost += len(p.Up.Lost) + len(p.Down.Lost)
+	}
+	if sent > 0 {
+		m["lost_pct"] = 100.0 * float64(lost) / float64(sent)
+	}
+	for n, vv := range newDists(y) {
+		m.addDist(n, vv)
+	}
+	maps.Copy(m, y.metrics)
+	return
+}
+
+// dists contains named distributions of delay values in milliseconds, each
+// sorted. Names are as for the delay Metrics, without the statistic and unit
+// suffix (e.g. owd_up, or udp.rtt).
+type dists map[string][]float64
+
+// newDists returns the dists from an analysis. Distributions with no values
+// are omitted.
+func newDists(y analysis) (d dists) {
+	d = make(dists)
+	var up, down, rtt []float64
+	for _, p := range y.packetList() {
+		f := string(p.Flow) + "."
+		u := p.Up.delays()
+		w := p.Down.delays()
+		var r []float64
+		for _, t := range p.RTT {
+			r = append(r, t.Delay.Seconds()*1000)
+		}
+		d.add(f+"owd_up", u)
+		d.add(f+"owd_down", w)
+		d.add(f+"rtt", r)
+		up = append(up, u...)
+		down = append(down, w...)
+		rtt = append(rtt, r...)
+	}
+	d.add("owd", append(append([]float64{}, up...), down...))
+	d.add("owd_up", up)
+	d.add("owd_down", down)
+	d.add("rtt", rtt)
+	var lat []float64
+	for _, r := range y.rpcList() {
+		var l []float64
+		for _, t := range r.Transaction {
+			l = append(l, t.Latency.Seconds()*1000)
+		}
+		d.add(string(r.Flow)+".rpc_latency", l)
+		lat = append(lat, l...)
+	}
+	d.add("rpc_latency", lat)
+	return
+}
+
+// add sorts and adds the named distribution, if vv isn't empty.
+func (d dists) add(name string, vv []float64) {
+	if len(vv) == 0 {
+		return
+	}
+	sort.Float64s(vv)
+	d[name] = vv
+}
+
+// addDist adds the mean, median, 95th and 99th percentiles of the given sorted
+// millisecond values, with name as the prefix.
+func (m Metrics) addDist(name string, vv []float64) {
+	m[name+"_mean_ms"] = stat.Mean(vv, nil)
+	m[name+"_p50_ms"] = stat.Quantile(0.5, stat.Empirical, vv, nil)
+	m[name+"_p95_ms"] = stat.Quantile(0.95, stat.Empirical, vv, nil)
+	m[name+"_p99_ms"] = stat.Quantile(0.99, stat.Empirical, vv, nil)
+}
+
+// delays returns the one-way delays in milliseconds.
+func (s *packetStats) delays() (d []float64) {
+	for _, o := range s.OWD {
+		d = append(d, o.Delay.Seconds()*1000)
+	}
+	return
+}
@@ -0,0 +1,415 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"cmp"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/heistp/antler/node"
+	"github.com/heistp/antler/node/metric"
+)
+
+// Minimal configures minimal results mode for a Test, for constrained
+// controllers (e.g. running on the router under test) where saving the full
+// raw data is too heavy. Minimal runs at the start of the During pipeline, and
+// only summaries and downsampled series are saved to the DataFile, or buffered
+// in memory if DataFile is empty.
+//
+// The StreamIO and TCPInfo series are downsampled as they arrive, keeping the
+// first sample and the last sample in each Interval. Since StreamIO is
+// cumulative, stream lengths, FCTs and total goodput are unchanged, though the
+// goodput and TCPInfo time series have a resolution of Interval. The
+// slow-start exit time of each stream is taken from the full TCPInfo series.
+//
+// PacketIO is not forwarded. It's held in memory until the end of the Test,
+// then analyzed, and a MinimalSummary is sent with the stats for each packet
+// flow, the OWD and RTT series downsampled in the same way, and the Metrics
+// calculated from the PacketIO. The After reports use the summary in place of
+// the PacketIO, so packet stats and Metrics, and the Expect conditions on them,
+// are exact, while reports that plot or use the delay series (e.g. CDFs,
+// WorkingLatency and Percentile assertions) use the downsampled series.
+//
+// RPC data is kept in full.
+type Minimal struct {
+	// Interval is the interval at which series are downsampled.
+	Interval metric.Duration
+
+	// ClockOffset and Percentiles are used to analyze the packet flows, as
+	// for Analyze, which doesn't see the PacketIO in minimal results mode.
+	ClockOffset bool
+	Percentiles []float64
+}
+
+// validate returns an error if the Minimal config is invalid.
+func (m *Minimal) validate() (err error) {
+	if m.Interval <= 0 {
+		err = fmt.Errorf("Minimal Interval must be positive: %s", m.Interval)
+	}
+	return
+}
+
+// report implements reporter
+func (m *Minimal) report(ctx context.Context, rw rwer, in <-chan any,
+	out chan<- any) (err error) {
+	d := newDownsampler(m.Interval.Duration())
+	y := newAnalysis()
+	y.clockOffset = m.ClockOffset
+	if len(m.Percentiles) > 0 {
+		y.percentiles = m.Percentiles
+	}
+	c := newMemoryCheck(ctx, Minimal{})
+	for a := range in {
+		switch v := a.(type) {
+		case node.PacketIO:
+			y.add(v)
+			if err = c.add(); err != nil {
+				return
+			}
+			continue
+		case node.PacketInfo, node.PacketTrain:
+			y.add(v)
+		}
+		for _, b := range d.add(a) {
+			out <- b
+		}
+	}
+	for _, b := range d.flush() {
+		out <- b
+	}
+	if err = context.Cause(ctx); err != nil {
+		return
+	}
+	if s := m.summary(d, y); len(s.Stream) > 0 || len(s.Packet) > 0 {
+		out <- s
+	}
+	return
+}
+
+// summary returns the MinimalSummary for the given downsampler, and the
+// analysis containing the packet flows.
+func (m *Minimal) summary(d *downsampler, y analysis) (s MinimalSummary) {
+	for f, x := range d.ssExit {
+		s.Stream = append(s.Stream, StreamSummary{f, x})
+	}
+	slices.SortFunc(s.Stream, func(a, b StreamSummary) int {
+		return cmp.Compare(a.Flow, b.Flow)
+	})
+	if len(y.packets) == 0 {
+		return
+	}
+	t0 := make(map[node.Flow]time.Time)
+	for f, p := range y.packets {
+		t0[f] = p.T0()
+	}
+	b := y.packets.StartTime()
+	y.analyze()
+	i := m.Interval.Duration()
+	for f, p := range y.packets {
+		s.Packet = append(s.Packet, newPacketSummary(p, b, t0[f], i))
+	}
+	slices.SortFunc(s.Packet, func(a, b PacketSummary) int {
+		return cmp.Compare(a.Flow, b.Flow)
+	})
+	s.Metrics = newMetrics(y)
+	return
+}
+
+// MinimalSummary is sent by Minimal at the end of a Test, with the summaries
+// calculated from the data that's not saved in minimal results mode.
+type MinimalSummary struct {
+	// Stream lists the summary for each stream with sender TCPInfo.
+	Stream []StreamSummary
+
+	// Packet lists the summary for each packet flow.
+	Packet []PacketSummary
+
+	// Metrics are calculated from the PacketIO, and replace the Metrics of the
+	// same name that would be calculated from the downsampled series.
+	Metrics Metrics
+}
+
+// init registers MinimalSummary with the gob encoder
+func init() {
+	gob.Register(MinimalSummary{})
+}
+
+// StreamSummary contains the stats for a stream that are calculated from its
+// full TCPInfo series in minimal results mode.
+type StreamSummary struct {
+	Flow node.Flow
+
+	// SSExit is the time of the sender's first TCPInfo sample after slow-start
+	// exit, relative to the sender's Tinit, or -1 if slow-start didn't exit.
+	SSExit metric.RelativeTime
+}
+
+// PacketSummary contains the stats for a packet flow in minimal results mode,
+// calculated from its PacketIO, with the OWD and RTT series downsampled. Times
+// are relative to Base. Means are zero, instead of NaN, if there are no
+// samples, so the summary may be encoded as JSON.
+type PacketSummary struct {
+	Flow        node.Flow
+	Base        time.Time           // base time for relative times
+	T0          time.Time           // earliest packet time
+	SendStart   metric.RelativeTime // time of the client's first packet
+	Up          packetStats
+	Down        packetStats
+	RTT         []rtt
+	RTTMean     float64
+	RTTStats    delayDist
+	DSCP        []dscpStats
+	Capacity    metric.Bitrate
+	AvailBW     metric.Bitrate
+	ClockOffset time.Duration
+}
+
+// newPacketSummary returns a PacketSummary for the analyzed PacketAnalysis,
+// whose times are relative to base, with the given start time t0 and the
+// series downsampled at the given interval.
+func newPacketSummary(p *PacketAnalysis, base, t0 time.Time,
+	interval time.Duration) (s PacketSummary) {
+	s = PacketSummary{
+		Flow:        p.Flow,
+		Base:        base,
+		T0:          t0,
+		SendStart:   p.SendStart(),
+		Up:          p.Up.summary(interval),
+		Down:        p.Down.summary(interval),
+		RTT:         downsample(p.RTT, interval, rtt.time),
+		RTTMean:     finite(p.RTTMean),
+		RTTStats:    p.RTTStats,
+		DSCP:        p.DSCP,
+		Capacity:    p.Capacity,
+		AvailBW:     p.AvailBW,
+		ClockOffset: p.ClockOffset,
+	}
+	return
+}
+
+// clone returns a copy of the PacketSummary, with the series copied so they
+// may be shifted by packets.synchronize.
+func (s PacketSummary) clone() PacketSummary {
+	s.Up = s.Up.clone()
+	s.Down = s.Down.clone()
+	s.RTT = slices.Clone(s.RTT)
+	return s
+}
+
+// clone returns a copy of the packetStats, with the series copied.
+func (s packetStats) clone() packetStats {
+	s.Lost = slices.Clone(s.Lost)
+	s.Dup = slices.Clone(s.Dup)
+	s.OWD = slices.Clone(s.OWD)
+	s.Early = slices.Clone(s.Early)
+	s.Late = slices.Clone(s.Late)
+	return s
+}
+
+// summary returns a copy of the packetStats for a PacketSummary, with the OWD
+// series downsampled at the given interval.
+func (s packetStats) summary(interval time.Duration) packetStats {
+	s.OWD = downsample(s.OWD, interval, owd.time)
+	s.OWDMean = finite(s.OWDMean)
+	for _, p := range []*float64{&s.LostPct, &s.DupPct, &s.EarlyPct,
+		&s.LatePct} {
+		*p = finite(*p)
+	}
+	return s
+}
+
+// restore sets the NaN means and percentages in the packetStats from a
+// PacketSummary, for the values that had no samples.
+func (s *packetStats) restore() {
+	if s.Sent == 0 {
+		s.LostPct = math.NaN()
+		s.DupPct = math.NaN()
+		s.EarlyPct = math.NaN()
+		s.LatePct = math.NaN()
+	}
+	if s.OWDStats.N == 0 {
+		s.OWDMean = math.NaN()
+	}
+}
+
+// shift adds d to the times in the packetStats.
+func (s *packetStats) shift(d metric.RelativeTime) {
+	for i := range s.Lost {
+		s.Lost[i].T += d
+	}
+	for i := range s.Dup {
+		s.Dup[i].T += d
+	}
+	for i := range s.OWD {
+		s.OWD[i].T += d
+	}
+	for i := range s.Early {
+		s.Early[i].T += d
+	}
+	for i := range s.Late {
+		s.Late[i].T += d
+	}
+}
+
+// finite returns v, or zero if v is NaN.
+func finite(v float64) float64 {
+	if math.IsNaN(v) {
+		return 0
+	}
+	return v
+}
+
+// downsample returns the first sample, and the last sample in each interval,
+// of the given samples, using t to get the time of each sample.
+func downsample[S any](ss []S, interval time.Duration,
+	t func(S) metric.RelativeTime) (d []S) {
+	var b int64
+	var p *S
+	for i := range ss {
+		k := int64(t(ss[i])) / int64(interval)
+		if i == 0 {
+			d = append(d, ss[i])
+			b = k
+			continue
+		}
+		if k != b && p != nil {
+			d = append(d, *p)
+		}
+		b = k
+		p = &ss[i]
+	}
+	if p != nil {
+		d = append(d, *p)
+	}
+	return
+}
+
+// time returns the time of the owd sample.
+func (o owd) time() metric.RelativeTime {
+	return o.T
+}
+
+// time returns the time of the rtt sample.
+func (r rtt) time() metric.RelativeTime {
+	return r.T
+}
+
+// downsampleKey identifies a series to downsample.
+type downsampleKey struct {
+	flow     node.Flow
+	typ      string
+	sent     bool
+	location node.Location
+	receiver bool
+}
+
+// compare compares the key to k2, for sorting.
+func (k downsampleKey) compare(k2 downsampleKey) int {
+	b := func(v bool) int {
+		if v {
+			return 1
+		}
+		return 0
+	}
+	return cmp.Or(
+		cmp.Compare(k.flow, k2.flow),
+		cmp.Compare(k.typ, k2.typ),
+		cmp.Compare(b(k.sent), b(k2.sent)),
+		cmp.Compare(k.location, k2.location),
+		cmp.Compare(b(k.receiver), b(k2.receiver)),
+	)
+}
+
+// downsampleSeries is the downsampling state for a series.
+type downsampleSeries struct {
+	bucket  int64
+	pending any
+}
+
+// downsampler keeps the first sample, and the last sample in each interval, of
+// the StreamIO and TCPInfo series. It also records the slow-start exit time
+// from the full series of sender TCPInfo for each stream.
+type downsampler struct {
+	interval int64
+	series   map[downsampleKey]*downsampleSeries
+	ssExit   map[node.Flow]metric.RelativeTime
+}
+
+// newDownsampler returns a new downsampler for the given interval.
+func newDownsampler(interval time.Duration) *downsampler {
+	return &downsampler{
+		int64(interval),
+		make(map[downsampleKey]*downsampleSeries),
+		make(map[node.Flow]metric.RelativeTime),
+	}
+}
+
+// add adds a data item, and returns the items to forward.
+func (d *downsampler) add(a any) (fwd []any) {
+	var k downsampleKey
+	var t metric.RelativeTime
+	switch v := a.(type) {
+	case node.StreamIO:
+		k = downsampleKey{v.Flow, "StreamIO", v.Sent, "", false}
+		t = v.T
+	case node.TCPInfo:
+		k = downsampleKey{v.Flow, "TCPInfo", false, v.Location, v.Receiver}
+		t = v.T
+		if !v.Receiver {
+			d.tcpInfo(v)
+		}
+	default:
+		fwd = append(fwd, a)
+		return
+	}
+	b := int64(t) / d.interval
+	s, ok := d.series[k]
+	if !ok {
+		d.series[k] = &downsampleSeries{b, nil}
+		fwd = append(fwd, a)
+		return
+	}
+	if b != s.bucket && s.pending != nil {
+		fwd = append(fwd, s.pending)
+	}
+	s.bucket = b
+	s.pending = a
+	return
+}
+
+// tcpInfo records the slow-start exit time from a sender TCPInfo sample, as in
+// streams.analyze.
+func (d *downsampler) tcpInfo(t node.TCPInfo) {
+	x, ok := d.ssExit[t.Flow]
+	if ok && x >= 0 {
+		return
+	}
+	if t.SendSSThresh < LinuxSSThreshInfinity {
+		d.ssExit[t.Flow] = t.T
+	} else if !ok {
+		d.ssExit[t.Flow] = -1
+	}
+}
+
+// flush returns the pending last sample of each series, in key order.
+func (d *downsampler) flush() (fwd []any) {
+	kk := make([]downsampleKey, 0, len(d.series))
+	for k := range d.series {
+		kk = append(kk, k)
+	}
+	slices.SortFunc(kk, downsampleKey.compare)
+	for _, k := range kk {
+		s := d.series[k]
+		if s.pending != nil {
+			fwd = append(fwd, s.pending)
+			s.pending = nil
+		}
+	}
+	return
+}
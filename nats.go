@@ -0,0 +1,380 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/heistp/antler/node"
+	"github.com/heistp/antler/node/metric"
+	"github.com/heistp/antler/version"
+)
+
+// natsQueueLen is the maximum number of messages that may be queued for
+// publishing, after which messages are dropped, so a slow or unreachable server
+// can't stall the pipeline.
+const natsQueueLen = 4096
+
+// natsDefaultPort is the port used if none is given in the URL.
+const natsDefaultPort = "4222"
+
+// EmitNATS is a reporter that publishes selected data items, and events for
+// the start and end of the Test, to a NATS server as they arrive, so that
+// stream-processing pipelines may act on live measurements. Messages are JSON,
+// using the core NATS protocol, which requires no client library. All data is
+// passed through to the next stage.
+type EmitNATS struct {
+	// URL is the server URL, e.g. nats://localhost:4222.
+	URL string
+
+	// Subject is the subject prefix. Data items are published to
+	// <Subject>.data, and events to <Subject>.event.
+	Subject string
+
+	// TokenEnv, if not empty, is the name of an environment variable that
+	// contains an auth token, sent when connecting.
+	TokenEnv string
+
+	// Type lists the types of data items published, by their names in the
+	// DataJSON format, e.g. node.StreamIO.
+	Type []string
+
+	// WriteTimeout is the maximum time to connect, and for each write.
+	WriteTimeout metric.Duration
+}
+
+// validate returns an error if any fields are invalid.
+func (x *EmitNATS) validate() (err error) {
+	if _, err = x.address(); err != nil {
+		return
+	}
+	if x.WriteTimeout <= 0 {
+		err = fmt.Errorf("EmitNATS WriteTimeout must be positive: %s",
+			x.WriteTimeout)
+		return
+	}
+	if x.Subject == "" || strings.ContainsAny(x.Subject, " \t\r\n*>") {
+		err = fmt.Errorf("EmitNATS Subject must not be empty, or contain "+
+			"whitespace or wildcards: '%s'", x.Subject)
+		return
+	}
+	for _, t := range x.Type {
+		if _, ok := dataType(t); !ok {
+			err = fmt.Errorf("EmitNATS Type '%s' is not a known data type", t)
+			return
+		}
+	}
+	return
+}
+
+// address returns the host:port address of the server from URL.
+func (x *EmitNATS) address() (addr string, err error) {
+	var u *url.URL
+	if u, err = url.Parse(x.URL); err != nil {
+		return
+	}
+	if u.Scheme != "nats" || u.Hostname() == "" {
+		err = fmt.Errorf("EmitNATS URL must be nats://host[:port]: '%s'",
+			x.URL)
+		return
+	}
+	p := u.Port()
+	if p == "" {
+		p = natsDefaultPort
+	}
+	addr = net.JoinHostPort(u.Hostname(), p)
+	return
+}
+
+// NATSData is the message published for a data item.
+type NATSData struct {
+	Test TestID
+	Type string
+	Data any
+}
+
+// NATSEvent is the message published for a Test lifecycle event.
+type NATSEvent struct {
+	Test TestID
+
+	// Event is start when the reporter starts, or end when its input is done.
+	Event string
+
+	// Time is the time of the event.
+	Time time.Time
+
+	// Errors is the number of node.Errors seen, for the end event.
+	Errors int `json:",omitempty"`
+}
+
+// report implements reporter
+func (x *EmitNATS) report(ctx context.Context, rw rwer, in <-chan any,
+	out chan<- any) (err error) {
+	var id TestID
+	if t := contextTest(ctx); t != nil {
+		id = t.ID
+	}
+	tt := make(map[string]bool)
+	for _, t := range x.Type {
+		tt[t] = true
+	}
+	p := &natsPublisher{EmitNATS: x}
+	if x.TokenEnv != "" {
+		p.token = os.Getenv(x.TokenEnv)
+	}
+	mc := make(chan natsMsg, natsQueueLen)
+	done := make(chan struct{})
+	go p.run(ctx, mc, done)
+	var dropped, errs int
+	pub := func(subject string, v any) {
+		b, e := json.Marshal(v)
+		if e != nil {
+			out <- natsWarning("unable to encode %T: %s", v, e)
+			return
+		}
+		select {
+		case mc <- natsMsg{x.Subject + "." + subject, b}:
+		default:
+			dropped++
+		}
+	}
+	pub("event", NATSEvent{id, "start", time.Now(), 0})
+	for d := range in {
+		out <- d
+		if _, ok := d.(node.Error); ok {
+			errs++
+		}
+		if n := fmt.Sprintf("%T", d); tt[n] {
+			pub("data", NATSData{id, n, d})
+		}
+	}
+	pub("event", NATSEvent{id, "end", time.Now(), errs})
+	close(mc)
+	<-done
+	if dropped > 0 {
+		out <- natsWarning("dropped %d messages, as publishing to %s "+
+			"couldn't keep up", dropped, x.URL)
+	}
+	if e := p.warning(); e != nil {
+		out <- *e
+	}
+	return
+}
+
+// natsWarning returns a warning LogEntry for EmitNATS.
+func natsWarning(format string, a ...any) node.LogEntry {
+	return node.LogEntry{
+		Time:   time.Now(),
+		NodeID: node.RootNodeID,
+		Tag:    "nats",
+		Text:   fmt.Sprintf(format, a...),
+		Level:  node.LogWarn,
+	}
+}
+
+// natsMsg is a message to publish.
+type natsMsg struct {
+	subject string
+	payload []byte
+}
+
+// natsPublisher publishes messages to a NATS server.
+type natsPublisher struct {
+	*EmitNATS
+	token  string
+	conn   net.Conn
+	w      *bufio.Writer
+	wmtx   sync.Mutex
+	pong   chan struct{}
+	failed int
+	err    error
+	mtx    sync.Mutex
+}
+
+// run connects to the server, and publishes the messages received from mc
+// until it's closed, then waits for the server to process them and closes
+// done. If the connection fails, the remaining messages are discarded.
+func (p *natsPublisher) run(ctx context.Context, mc <-chan natsMsg,
+	done chan<- struct{}) {
+	defer close(done)
+	var n int
+	defer func() {
+		for range mc {
+			n++
+		}
+		if n > 0 {
+			p.fail(n, nil)
+		}
+	}()
+	if e := p.connect(ctx); e != nil {
+		p.fail(0, e)
+		return
+	}
+	defer p.conn.Close()
+	for m := range mc {
+		if e := p.publish(m, len(mc) == 0); e != nil {
+			p.fail(1, e)
+			return
+		}
+	}
+	if e := p.flush(ctx); e != nil {
+		p.fail(0, e)
+	}
+}
+
+// connect connects to the server, and sends CONNECT. The server's replies are
+// handled by a goroutine that answers PINGs and records errors.
+func (p *natsPublisher) connect(ctx context.Context) (err error) {
+	var a string
+	if a, err = p.address(); err != nil {
+		return
+	}
+	d := net.Dialer{Timeout: p.WriteTimeout.Duration()}
+	if p.conn, err = d.DialContext(ctx, "tcp", a); err != nil {
+		return
+	}
+	r := bufio.NewReader(p.conn)
+	p.conn.SetReadDeadline(time.Now().Add(p.WriteTimeout.Duration()))
+	var l string
+	if l, err = r.ReadString('\n'); err != nil {
+		p.conn.Close()
+		return
+	}
+	if !strings.HasPrefix(l, "INFO ") {
+		p.conn.Close()
+		err = fmt.Errorf("expected INFO from %s, got: %s", a,
+			strings.TrimSpace(l))
+		return
+	}
+	p.conn.SetReadDeadline(time.Time{})
+	p.w = bufio.NewWriter(p.conn)
+	p.pong = make(chan struct{}, 1)
+	go p.read(r)
+	c := struct {
+		Verbose   bool   `json:"verbose"`
+		Pedantic  bool   `json:"pedantic"`
+		AuthToken string `json:"auth_token,omitempty"`
+		Name      string `json:"name"`
+		Lang      string `json:"lang"`
+		Version   string `json:"version"`
+	}{false, false, p.token, "antler", "go", version.Version()}
+	var b []byte
+	if b, err = json.Marshal(c); err != nil {
+		return
+	}
+	err = p.write(func(w *bufio.Writer) {
+		w.WriteString("CONNECT ")
+		w.Write(b)
+		w.WriteString("\r\n")
+	}, true)
+	if err != nil {
+		p.conn.Close()
+	}
+	return
+}
+
+// read handles the messages received from the server until the connection is
+// closed.
+func (p *natsPublisher) read(r *bufio.Reader) {
+	for {
+		l, e := r.ReadString('\n')
+		if e != nil {
+			return
+		}
+		l = strings.TrimSpace(l)
+		switch {
+		case l == "PING":
+			p.write(func(w *bufio.Writer) {
+				w.WriteString("PONG\r\n")
+			}, true)
+		case l == "PONG":
+			select {
+			case p.pong <- struct{}{}:
+			default:
+			}
+		case strings.HasPrefix(l, "-ERR"):
+			p.fail(0, errors.New(strings.TrimSpace(l[4:])))
+		}
+	}
+}
+
+// publish writes a PUB message, and flushes the connection if flush is true.
+func (p *natsPublisher) publish(m natsMsg, flush bool) error {
+	return p.write(func(w *bufio.Writer) {
+		fmt.Fprintf(w, "PUB %s %d\r\n", m.subject, len(m.payload))
+		w.Write(m.payload)
+		w.WriteString("\r\n")
+	}, flush)
+}
+
+// flush sends a PING and waits for the PONG, so that all messages published
+// have been processed by the server.
+func (p *natsPublisher) flush(ctx context.Context) (err error) {
+	if err = p.write(func(w *bufio.Writer) {
+		w.WriteString("PING\r\n")
+	}, true); err != nil {
+		return
+	}
+	t := time.NewTimer(p.WriteTimeout.Duration())
+	defer t.Stop()
+	select {
+	case <-p.pong:
+	case <-t.C:
+		err = fmt.Errorf("timeout waiting for PONG from %s", p.URL)
+	case <-ctx.Done():
+		err = context.Cause(ctx)
+	}
+	return
+}
+
+// write calls f to write to the connection, and flushes it if flush is true.
+func (p *natsPublisher) write(f func(*bufio.Writer), flush bool) (
+	err error) {
+	p.wmtx.Lock()
+	defer p.wmtx.Unlock()
+	p.conn.SetWriteDeadline(time.Now().Add(p.WriteTimeout.Duration()))
+	f(p.w)
+	if flush {
+		err = p.w.Flush()
+	}
+	return
+}
+
+// fail records n messages that failed to publish, and the error, if not nil.
+func (p *natsPublisher) fail(n int, err error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.failed += n
+	if err != nil {
+		p.err = err
+	}
+}
+
+// warning returns a warning LogEntry if any messages failed to publish, or an
+// error occurred, or nil otherwise.
+func (p *natsPublisher) warning() *node.LogEntry {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	var e node.LogEntry
+	switch {
+	case p.failed > 0:
+		e = natsWarning("%d messages failed to publish to %s, last error: %v",
+			p.failed, p.URL, p.err)
+	case p.err != nil:
+		e = natsWarning("error publishing to %s: %s", p.URL, p.err)
+	default:
+		return nil
+	}
+	return &e
+}
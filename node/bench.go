@@ -0,0 +1,384 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/heistp/antler/node/metric"
+)
+
+// benchPacketLen is the packet length used for packet benchmarks.
+const benchPacketLen = 1472
+
+// benchBufLen is the buffer length used for transfer benchmarks.
+const benchBufLen = 8192
+
+// benchTime is the minimum duration that each benchmark is run for.
+const benchTime = time.Second
+
+// Benchmark is a benchmark of a node hot path, used to measure the achievable
+// rates of the traffic generator on the current host. The same benchmarks are
+// run by go test -bench, from bench_test.go.
+type Benchmark struct {
+	// Name is the name of the benchmark.
+	Name string
+
+	// Unit is the unit for one benchmark operation (e.g. "packets").
+	Unit string
+
+	// f is the benchmark function.
+	f benchFunc
+}
+
+// benchFunc is a benchmark function, which runs n operations.
+type benchFunc func(b benchB, n int)
+
+// benchB contains the methods of testing.B that are used by the benchmarks,
+// so they may be run without linking the testing package into the node.
+type benchB interface {
+	SetBytes(n int64)
+	ResetTimer()
+	StopTimer()
+	ReportMetric(n float64, unit string)
+	Fatal(args ...any)
+}
+
+// Run runs the Benchmark and returns its result. As for testing.Benchmark, the
+// number of operations is increased until the benchmark runs for at least
+// benchTime.
+func (m Benchmark) Run() (r BenchmarkResult) {
+	n := 1
+	for {
+		t := m.run(n)
+		r = BenchmarkResult{m, n, t.elapsed, t.bytes, t.extra, t.err}
+		if t.err != nil || t.elapsed >= benchTime || n >= 1e9 {
+			return
+		}
+		p := int64(n) * 100
+		if e := t.elapsed.Nanoseconds(); e > 0 {
+			p = benchTime.Nanoseconds() * int64(n) / e
+		}
+		p += p / 5
+		p = min(p, int64(n)*100)
+		p = max(p, int64(n)+1)
+		n = int(min(p, 1e9))
+	}
+}
+
+// run runs the benchmark function once, for n operations.
+func (m Benchmark) run(n int) (t *benchTimer) {
+	t = &benchTimer{extra: make(map[string]float64)}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		t.startTimer()
+		m.f(t, n)
+		t.StopTimer()
+	}()
+	<-done
+	return
+}
+
+// benchTimer implements benchB for Benchmark.Run.
+type benchTimer struct {
+	start   time.Time
+	elapsed time.Duration
+	running bool
+	bytes   int64
+	extra   map[string]float64
+	err     error
+}
+
+// startTimer starts timing the benchmark.
+func (t *benchTimer) startTimer() {
+	if !t.running {
+		t.start = time.Now()
+		t.running = true
+	}
+}
+
+// SetBytes implements benchB
+func (t *benchTimer) SetBytes(n int64) {
+	t.bytes = n
+}
+
+// ResetTimer implements benchB
+func (t *benchTimer) ResetTimer() {
+	if t.running {
+		t.start = time.Now()
+	}
+	t.elapsed = 0
+}
+
+// StopTimer implements benchB
+func (t *benchTimer) StopTimer() {
+	if t.running {
+		t.elapsed += time.Since(t.start)
+		t.running = false
+	}
+}
+
+// ReportMetric implements benchB
+func (t *benchTimer) ReportMetric(n float64, unit string) {
+	t.extra[unit] = n
+}
+
+// Fatal implements benchB. As for testing.B, it must be called from the
+// benchmark's goroutine, which it stops.
+func (t *benchTimer) Fatal(args ...any) {
+	t.err = errors.New(fmt.Sprint(args...))
+	runtime.Goexit()
+}
+
+// BenchmarkResult contains the result of a Benchmark.
+type BenchmarkResult struct {
+	Benchmark
+
+	// N is the number of operations run.
+	N int
+
+	// T is the total time taken.
+	T time.Duration
+
+	// Bytes is the number of bytes processed in one operation.
+	Bytes int64
+
+	// Extra contains any additional metrics reported by the benchmark.
+	Extra map[string]float64
+
+	// Err is the error if the benchmark failed, or nil if it succeeded.
+	Err error
+}
+
+// NsPerOp returns the time per operation, in nanoseconds.
+func (r BenchmarkResult) NsPerOp() int64 {
+	if r.N <= 0 {
+		return 0
+	}
+	return r.T.Nanoseconds() / int64(r.N)
+}
+
+// Rate returns the achieved rate, in Units per second.
+func (r BenchmarkResult) Rate() float64 {
+	if r.T <= 0 {
+		return 0
+	}
+	return float64(r.N) / r.T.Seconds()
+}
+
+// Bitrate returns the achieved bitrate, or zero if the benchmark doesn't
+// process bytes.
+func (r BenchmarkResult) Bitrate() metric.Bitrate {
+	if r.Bytes == 0 || r.T <= 0 {
+		return 0
+	}
+	return metric.CalcBitrate(metric.Bytes(r.Bytes*int64(r.N)), r.T)
+}
+
+func (r BenchmarkResult) String() string {
+	if r.Err != nil {
+		return fmt.Sprintf("%s: failed: %s", r.Name, r.Err)
+	}
+	s := fmt.Sprintf("%s: %.0f %s/s (%d ns/op)", r.Name, r.Rate(), r.Unit,
+		r.NsPerOp())
+	if b := r.Bitrate(); b > 0 {
+		s += fmt.Sprintf(", %s", b)
+	}
+	for k, v := range r.Extra {
+		s += fmt.Sprintf(", %.2f %s", v, k)
+	}
+	return s
+}
+
+// Benchmarks returns the available Benchmarks.
+func Benchmarks() []Benchmark {
+	return []Benchmark{
+		{"PacketHeader", "packets", benchPacketHeader(false)},
+		{"PacketHeaderHMAC", "packets", benchPacketHeader(true)},
+		{"PacketLoopback", "packets", benchPacketLoopback(false)},
+		{"PacketLoopbackHMAC", "packets", benchPacketLoopback(true)},
+		{"TransferLoopback", "writes", benchTransferLoopback},
+		{"ConnMessages", "messages", benchConnMessages},
+	}
+}
+
+// benchPacketHeader returns a benchmark that encodes and decodes packet
+// headers, as done for each packet by PacketClient and PacketServer.
+func benchPacketHeader(sign bool) benchFunc {
+	return func(b benchB, n int) {
+		p := PacketHeader{Flow: "benchmark"}
+		if sign {
+			p.hmac = hmac.New(sha256.New, []byte("benchmark"))
+		}
+		buf := make([]byte, benchPacketLen)
+		b.SetBytes(benchPacketLen)
+		b.ResetTimer()
+		for i := 0; i < n; i++ {
+			p.Seq = Seq(i)
+			if _, err := p.Read(buf); err != nil {
+				b.Fatal(err)
+			}
+			if _, err := p.Write(buf); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// benchPacketLoopback returns a benchmark that sends encoded packets over UDP
+// on the loopback interface, and decodes them on receipt, as in the
+// PacketClient send loop and PacketServer receive loop.
+func benchPacketLoopback(sign bool) benchFunc {
+	return func(b benchB, n int) {
+		var err error
+		var s net.PacketConn
+		if s, err = net.ListenPacket("udp", "127.0.0.1:0"); err != nil {
+			b.Fatal(err)
+		}
+		defer s.Close()
+		var c net.Conn
+		if c, err = net.Dial("udp", s.LocalAddr().String()); err != nil {
+			b.Fatal(err)
+		}
+		defer c.Close()
+		var h headerFunc
+		if sign {
+			h = func() *PacketHeader {
+				return &PacketHeader{Flow: "benchmark",
+					hmac: hmac.New(sha256.New, []byte("benchmark"))}
+			}
+		} else {
+			h = func() *PacketHeader {
+				return &PacketHeader{Flow: "benchmark"}
+			}
+		}
+		done := make(chan int)
+		go func() {
+			var n int
+			defer func() {
+				done <- n
+			}()
+			p := h()
+			buf := make([]byte, benchPacketLen)
+			for {
+				l, _, e := s.ReadFrom(buf)
+				if e != nil {
+					return
+				}
+				if _, e = p.Write(buf[:l]); e != nil {
+					return
+				}
+				n++
+			}
+		}()
+		p := h()
+		buf := make([]byte, benchPacketLen)
+		b.SetBytes(benchPacketLen)
+		b.ResetTimer()
+		for i := 0; i < n; i++ {
+			p.Seq = Seq(i)
+			if _, err = p.Read(buf); err != nil {
+				b.Fatal(err)
+			}
+			if _, err = c.Write(buf); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.StopTimer()
+		// allow the receiver to drain the socket buffer
+		time.Sleep(50 * time.Millisecond)
+		s.Close()
+		b.ReportMetric(100*float64(<-done)/float64(n), "%rcvd")
+	}
+}
+
+// headerFunc returns a new PacketHeader for the packet benchmarks.
+type headerFunc func() *PacketHeader
+
+// benchTransferLoopback is a benchmark that writes BufLen sized buffers to a
+// TCP connection on the loopback interface, as in the Transfer send loop.
+func benchTransferLoopback(b benchB, n int) {
+	var err error
+	var l net.Listener
+	if l, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		b.Fatal(err)
+	}
+	defer l.Close()
+	done := make(chan error)
+	go func() {
+		c, e := l.Accept()
+		if e != nil {
+			done <- e
+			return
+		}
+		defer c.Close()
+		_, e = io.Copy(io.Discard, c)
+		done <- e
+	}()
+	var c net.Conn
+	if c, err = net.Dial("tcp", l.Addr().String()); err != nil {
+		b.Fatal(err)
+	}
+	buf := make([]byte, benchBufLen)
+	for i := range buf {
+		buf[i] = transferFill
+	}
+	b.SetBytes(benchBufLen)
+	b.ResetTimer()
+	for i := 0; i < n; i++ {
+		if _, err = c.Write(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	c.Close()
+	if err = <-done; err != nil {
+		b.Fatal(err)
+	}
+}
+
+// benchConnMessages is a benchmark that sends StreamIO messages through a
+// gobTransport over a pipe, as data points are sent from a node to its parent.
+func benchConnMessages(b benchB, n int) {
+	var err error
+	var r, w *os.File
+	if r, w, err = os.Pipe(); err != nil {
+		b.Fatal(err)
+	}
+	tw := newGobTransport(w)
+	tr := newGobTransport(r)
+	done := make(chan error)
+	go func() {
+		defer tr.Close()
+		for i := 0; i < n; i++ {
+			if _, e := tr.Receive(); e != nil {
+				done <- e
+				return
+			}
+		}
+		done <- nil
+	}()
+	b.ResetTimer()
+	for i := 0; i < n; i++ {
+		m := StreamIO{"benchmark", metric.RelativeTime(i), metric.Bytes(i),
+			true, nil}
+		if err = tw.Send(m); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err = <-done; err != nil {
+		b.Fatal(err)
+	}
+	b.StopTimer()
+	tw.Close()
+}
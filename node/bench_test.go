@@ -0,0 +1,30 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import "testing"
+
+func BenchmarkPacketHeader(b *testing.B) {
+	benchPacketHeader(false)(b, b.N)
+}
+
+func BenchmarkPacketHeaderHMAC(b *testing.B) {
+	benchPacketHeader(true)(b, b.N)
+}
+
+func BenchmarkPacketLoopback(b *testing.B) {
+	benchPacketLoopback(false)(b, b.N)
+}
+
+func BenchmarkPacketLoopbackHMAC(b *testing.B) {
+	benchPacketLoopback(true)(b, b.N)
+}
+
+func BenchmarkTransferLoopback(b *testing.B) {
+	benchTransferLoopback(b, b.N)
+}
+
+func BenchmarkConnMessages(b *testing.B) {
+	benchConnMessages(b, b.N)
+}
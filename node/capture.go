@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/heistp/antler/node/metric"
+)
+
+const (
+	// pcapMagic is the magic number for pcap files with nanosecond timestamps.
+	pcapMagic = 0xa1b23c4d
+
+	// pcapLinkEthernet and pcapLinkRaw are the pcap link types for Ethernet
+	// and raw IP.
+	pcapLinkEthernet = 1
+	pcapLinkRaw      = 101
+
+	// captureChunkLen is the length at which capture data is sent as FileData.
+	captureChunkLen = 64 * 1024
+)
+
+// PacketCapture is a runner that captures packets on a network interface using
+// an AF_PACKET socket, and sends the capture in pcap format as FileData, so
+// it's saved in the result directory by the SaveFiles reporter. The capture
+// starts when PacketCapture is run, and stops when the node's runners are
+// canceled at the end of the Test, so it should be placed before the runners
+// whose traffic is to be captured. PacketCapture requires Linux.
+//
+// The capture is sent in chunks of up to 64 KiB, at least every FlushInterval,
+// so long captures aren't held in memory on the node.
+type PacketCapture struct {
+	// Interface is the name of the interface to capture on.
+	Interface string
+
+	// Filter, if not empty, is a filter expression in pcap-filter(7) syntax
+	// (e.g. "tcp port 7777"). The expression is compiled to BPF by running
+	// tcpdump -ddd on the node, so tcpdump is required if Filter is set.
+	Filter string
+
+	// Snaplen is the maximum number of bytes captured from each packet.
+	Snaplen int
+
+	// Buffer is the size of the socket receive buffer, in bytes. Packets are
+	// dropped from the capture if it overflows.
+	Buffer int
+
+	// Promiscuous, if true, puts the interface in promiscuous mode.
+	Promiscuous bool
+
+	// File is the name of the pcap file. If empty, the name is
+	// <node ID>_<Interface>.pcap.
+	File string
+
+	// FlushInterval is the maximum time that captured packets are held on the
+	// node before they're sent.
+	FlushInterval metric.Duration
+
+	file *os.File
+	done chan struct{}
+	errc chan error
+	rec  *recorder
+}
+
+// validate implements validater
+func (p *PacketCapture) validate() (err error) {
+	if p.Interface == "" {
+		err = fmt.Errorf("PacketCapture requires an Interface")
+		return
+	}
+	if p.Snaplen <= 0 {
+		err = fmt.Errorf("PacketCapture Snaplen must be positive: %d",
+			p.Snaplen)
+		return
+	}
+	if p.Buffer <= 0 {
+		err = fmt.Errorf("PacketCapture Buffer must be positive: %d", p.Buffer)
+		return
+	}
+	if p.FlushInterval <= 0 {
+		err = fmt.Errorf("PacketCapture FlushInterval must be positive: %s",
+			p.FlushInterval)
+	}
+	return
+}
+
+// pcapRecord writes a pcap record for a packet of original length n, captured
+// into b, at time t.
+func pcapRecord(w *bytes.Buffer, b []byte, n int, t time.Time) {
+	var h [16]byte
+	c := min(n, len(b))
+	binary.NativeEndian.PutUint32(h[0:], uint32(t.Unix()))
+	binary.NativeEndian.PutUint32(h[4:], uint32(t.Nanosecond()))
+	binary.NativeEndian.PutUint32(h[8:], uint32(c))
+	binary.NativeEndian.PutUint32(h[12:], uint32(n))
+	w.Write(h[:])
+	w.Write(b[:c])
+}
@@ -0,0 +1,303 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Run implements runner
+func (p *PacketCapture) Run(ctx context.Context, arg runArg) (ofb Feedback,
+	err error) {
+	var i *net.Interface
+	if i, err = net.InterfaceByName(p.Interface); err != nil {
+		return
+	}
+	var l uint32
+	if l, err = captureLinkType(i); err != nil {
+		return
+	}
+	var f []unix.SockFilter
+	if p.Filter != "" {
+		if f, err = compileFilter(ctx, p.Interface, p.Filter); err != nil {
+			return
+		}
+	}
+	if err = p.open(i, f); err != nil {
+		return
+	}
+	n := p.File
+	if n == "" {
+		n = fmt.Sprintf("%s_%s.pcap", arg.rec.nodeID, p.Interface)
+	}
+	p.done = make(chan struct{})
+	p.errc = make(chan error, 1)
+	p.rec = arg.rec
+	go p.capture(n, l, arg.rec)
+	arg.rec.Logf("capturing on %s to %s", p.Interface, n)
+	arg.cxl <- p
+	return
+}
+
+// open opens the AF_PACKET socket, with the given filter attached, and bound
+// to the given interface.
+func (p *PacketCapture) open(ifi *net.Interface,
+	filter []unix.SockFilter) (err error) {
+	var fd int
+	if fd, err = unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_NONBLOCK|
+		unix.SOCK_CLOEXEC, int(htons(unix.ETH_P_ALL))); err != nil {
+		err = fmt.Errorf("unable to open packet socket: %w", err)
+		return
+	}
+	defer func() {
+		if err != nil {
+			unix.Close(fd)
+		}
+	}()
+	if len(filter) > 0 {
+		g := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+		if err = unix.SetsockoptSockFprog(fd, unix.SOL_SOCKET,
+			unix.SO_ATTACH_FILTER, &g); err != nil {
+			err = fmt.Errorf("unable to attach filter: %w", err)
+			return
+		}
+	}
+	if err = unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_TIMESTAMPNS,
+		1); err != nil {
+		return
+	}
+	if unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUFFORCE,
+		p.Buffer) != nil {
+		if err = unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF,
+			p.Buffer); err != nil {
+			return
+		}
+	}
+	a := &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ALL),
+		Ifindex: ifi.Index}
+	if err = unix.Bind(fd, a); err != nil {
+		err = fmt.Errorf("unable to bind to %s: %w", ifi.Name, err)
+		return
+	}
+	if p.Promiscuous {
+		m := unix.PacketMreq{Ifindex: int32(ifi.Index),
+			Type: unix.PACKET_MR_PROMISC}
+		if err = unix.SetsockoptPacketMreq(fd, unix.SOL_PACKET,
+			unix.PACKET_ADD_MEMBERSHIP, &m); err != nil {
+			err = fmt.Errorf("unable to enable promiscuous mode: %w", err)
+			return
+		}
+	}
+	p.file = os.NewFile(uintptr(fd), "packet:"+ifi.Name)
+	return
+}
+
+// Cancel implements canceler
+func (p *PacketCapture) Cancel() error {
+	p.stats()
+	close(p.done)
+	p.file.Close()
+	return <-p.errc
+}
+
+// capture reads packets until the socket is closed, and sends them as FileData
+// with the given name, in pcap format with the given link type.
+func (p *PacketCapture) capture(name string, linkType uint32, rec *recorder) {
+	var err error
+	defer func() {
+		p.errc <- err
+		close(p.errc)
+	}()
+	var c syscall.RawConn
+	if c, err = p.file.SyscallConn(); err != nil {
+		return
+	}
+	var w bytes.Buffer
+	var h [24]byte
+	binary.NativeEndian.PutUint32(h[0:], pcapMagic)
+	binary.NativeEndian.PutUint16(h[4:], 2)
+	binary.NativeEndian.PutUint16(h[6:], 4)
+	binary.NativeEndian.PutUint32(h[16:], uint32(p.Snaplen))
+	binary.NativeEndian.PutUint32(h[20:], linkType)
+	w.Write(h[:])
+	b := make([]byte, p.Snaplen)
+	o := make([]byte, unix.CmsgSpace(int(unsafe.Sizeof(unix.Timespec{}))))
+	t := time.NewTicker(p.FlushInterval.Duration())
+	defer t.Stop()
+	for {
+		var n, on int
+		var re error
+		err = p.file.SetReadDeadline(time.Now().Add(
+			p.FlushInterval.Duration()))
+		if err == nil {
+			err = c.Read(func(fd uintptr) bool {
+				n, on, _, _, re = unix.Recvmsg(int(fd), b, o, unix.MSG_TRUNC)
+				return re != unix.EAGAIN
+			})
+		}
+		if err == nil {
+			err = re
+		}
+		if err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+			select {
+			case <-p.done:
+				err = nil
+			default:
+			}
+			if w.Len() > 0 {
+				rec.FileData(name, w.Bytes())
+			}
+			return
+		}
+		if err == nil {
+			pcapRecord(&w, b, n, captureTime(o[:on]))
+		}
+		select {
+		case <-t.C:
+		default:
+			if w.Len() < captureChunkLen {
+				continue
+			}
+		}
+		if w.Len() > 0 {
+			rec.FileData(name, bytes.Clone(w.Bytes()))
+			w.Reset()
+		}
+	}
+}
+
+// stats logs the number of packets dropped by the kernel, if any.
+func (p *PacketCapture) stats() {
+	var s *unix.TpacketStats
+	var e error
+	c, err := p.file.SyscallConn()
+	if err == nil {
+		err = c.Control(func(fd uintptr) {
+			s, e = unix.GetsockoptTpacketStats(int(fd), unix.SOL_PACKET,
+				unix.PACKET_STATISTICS)
+		})
+	}
+	if err == nil {
+		err = e
+	}
+	if err != nil {
+		p.rec.Logf("unable to get capture statistics: %s", err)
+		return
+	}
+	if s.Drops > 0 {
+		p.rec.Warnf("%d of %d packets dropped from capture on %s", s.Drops,
+			s.Packets, p.Interface)
+	}
+}
+
+// captureTime returns the kernel receive timestamp from the given control
+// messages, or the current time if it's not found.
+func captureTime(oob []byte) time.Time {
+	m, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return time.Now()
+	}
+	for _, c := range m {
+		if c.Header.Level == unix.SOL_SOCKET &&
+			c.Header.Type == unix.SO_TIMESTAMPNS &&
+			len(c.Data) >= int(unsafe.Sizeof(unix.Timespec{})) {
+			s := (*unix.Timespec)(unsafe.Pointer(&c.Data[0]))
+			return time.Unix(s.Unix())
+		}
+	}
+	return time.Now()
+}
+
+// captureLinkType returns the pcap link type for the given interface.
+func captureLinkType(ifi *net.Interface) (linkType uint32, err error) {
+	var b []byte
+	if b, err = os.ReadFile(fmt.Sprintf("/sys/class/net/%s/type",
+		ifi.Name)); err != nil {
+		return
+	}
+	var t int
+	if t, err = strconv.Atoi(strings.TrimSpace(string(b))); err != nil {
+		return
+	}
+	switch t {
+	case unix.ARPHRD_ETHER, unix.ARPHRD_LOOPBACK:
+		linkType = pcapLinkEthernet
+	case unix.ARPHRD_NONE:
+		linkType = pcapLinkRaw
+	default:
+		err = fmt.Errorf("unsupported link type for capture on %s: %d",
+			ifi.Name, t)
+	}
+	return
+}
+
+// compileFilter compiles the given pcap-filter expression for the named
+// interface to classic BPF, using tcpdump -ddd.
+func compileFilter(ctx context.Context, iface, expr string) (
+	filter []unix.SockFilter, err error) {
+	var o []byte
+	c := exec.CommandContext(ctx, "tcpdump", "-ddd", "-i", iface, expr)
+	if o, err = c.Output(); err != nil {
+		var x *exec.ExitError
+		if errors.As(err, &x) {
+			err = fmt.Errorf("%w: %s", err, bytes.TrimSpace(x.Stderr))
+		}
+		err = fmt.Errorf("unable to compile filter '%s': %w", expr, err)
+		return
+	}
+	filter, err = parseFilter(o)
+	return
+}
+
+// parseFilter parses the decimal output of tcpdump -ddd, which is the number
+// of instructions, followed by one instruction per line.
+func parseFilter(ddd []byte) (filter []unix.SockFilter, err error) {
+	s := bufio.NewScanner(bytes.NewReader(ddd))
+	var n int
+	if s.Scan() {
+		if n, err = strconv.Atoi(strings.TrimSpace(s.Text())); err != nil {
+			return
+		}
+	}
+	for s.Scan() {
+		var f unix.SockFilter
+		if _, err = fmt.Sscan(s.Text(), &f.Code, &f.Jt, &f.Jf,
+			&f.K); err != nil {
+			err = fmt.Errorf("invalid filter instruction '%s': %w", s.Text(),
+				err)
+			return
+		}
+		filter = append(filter, f)
+	}
+	if err = s.Err(); err != nil {
+		return
+	}
+	if n == 0 || len(filter) != n {
+		err = fmt.Errorf("filter has %d instructions, expected %d",
+			len(filter), n)
+	}
+	return
+}
+
+// htons converts a uint16 from host to network byte order.
+func htons(i uint16) uint16 {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], i)
+	return binary.NativeEndian.Uint16(b[:])
+}
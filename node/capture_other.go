@@ -0,0 +1,18 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+//go:build !linux
+
+package node
+
+import (
+	"context"
+	"fmt"
+)
+
+// Run implements runner
+func (p *PacketCapture) Run(ctx context.Context, arg runArg) (ofb Feedback,
+	err error) {
+	err = fmt.Errorf("PacketCapture requires Linux (AF_PACKET)")
+	return
+}
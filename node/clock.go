@@ -0,0 +1,135 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/heistp/antler/node/metric"
+	"golang.org/x/sys/unix"
+)
+
+// Clock is a runner that selects the time source used for timestamps on a
+// node. By default, nodes timestamp using the system's monotonic clock, so
+// one-way delay measurements between nodes are only as accurate as the
+// synchronization of their system clocks (often milliseconds, with NTP). On
+// nodes with a PTP hardware clock (PHC) disciplined by PTP or GPS, Clock may be
+// used to timestamp from the PHC instead, for microsecond accuracy.
+//
+// All nodes that measure one-way delay between them must use the same time
+// scale. PHCs normally run in TAI, so Offset may be used to convert to UTC if
+// other nodes use the system clock.
+//
+// Clock must be the first runner on a node, as timestamps taken before it's
+// run are from the previous time source. The time source is global to the node
+// process, so only one Clock may be in use on a node at a time, and Clock may
+// not be run on the in-process root node, where it would change the time source
+// for the controller. The system clock is restored when the node's runners are
+// canceled at the end of the Test. If the PHC can't be read during the Test
+// (e.g. after a device reset), a warning is logged, and the system clock is
+// used from then on.
+type Clock struct {
+	// PHC is the path to the PTP hardware clock device (e.g. "/dev/ptp0").
+	PHC string
+
+	// Offset is subtracted from the PHC time, e.g. 37s to convert from TAI to
+	// UTC.
+	Offset metric.Duration
+
+	phc *phcClock
+}
+
+// activeClock is the Clock in use on this node, or nil if none.
+var activeClock atomic.Pointer[Clock]
+
+// Run implements runner
+func (c *Clock) Run(ctx context.Context, arg runArg) (ofb Feedback,
+	err error) {
+	if arg.rec.nodeID == RootNodeID {
+		err = fmt.Errorf("Clock may not be run on the root node")
+		return
+	}
+	if !activeClock.CompareAndSwap(nil, c) {
+		err = fmt.Errorf("a Clock is already in use on node %s",
+			arg.rec.nodeID)
+		return
+	}
+	if c.phc, err = openPHC(c.PHC, c.Offset.Duration(), arg.rec); err != nil {
+		activeClock.Store(nil)
+		return
+	}
+	metric.SetClock(c.phc)
+	arg.rec.Logf("using PHC %s for timestamps, offset %s", c.PHC, c.Offset)
+	arg.cxl <- c
+	return
+}
+
+// Cancel implements canceler
+func (c *Clock) Cancel() error {
+	metric.SetClock(metric.SystemClock{})
+	activeClock.CompareAndSwap(c, nil)
+	return c.phc.Close()
+}
+
+// validate implements validater
+func (c *Clock) validate() (err error) {
+	if c.PHC == "" {
+		err = fmt.Errorf("Clock requires a PHC device")
+	}
+	return
+}
+
+// phcClock is a metric.Clock that reads time from a PTP hardware clock.
+type phcClock struct {
+	file   *os.File
+	id     int32
+	offset time.Duration
+	rec    *recorder
+	failed atomic.Bool
+}
+
+// openPHC opens the PTP hardware clock at the given device path, and returns a
+// phcClock for it, after checking that its time can be read. Later read
+// failures are logged to rec.
+func openPHC(path string, offset time.Duration, rec *recorder) (c *phcClock,
+	err error) {
+	var f *os.File
+	if f, err = os.Open(path); err != nil {
+		return
+	}
+	// FD_TO_CLOCKID from linux/posix-timers.h
+	id := int32((^int(f.Fd()))<<3 | 3)
+	var t unix.Timespec
+	if err = unix.ClockGettime(id, &t); err != nil {
+		f.Close()
+		err = fmt.Errorf("unable to read PHC %s: %w", path, err)
+		return
+	}
+	c = &phcClock{file: f, id: id, offset: offset, rec: rec}
+	return
+}
+
+// Now implements metric.Clock. If the PHC can't be read, the system clock is
+// restored as the time source, and a warning is logged once.
+func (c *phcClock) Now() time.Time {
+	var t unix.Timespec
+	if err := unix.ClockGettime(c.id, &t); err != nil {
+		if c.failed.CompareAndSwap(false, true) {
+			metric.SetClock(metric.SystemClock{})
+			c.rec.Warnf("unable to read PHC %s, using system clock: %s",
+				c.file.Name(), err)
+		}
+		return time.Now()
+	}
+	return time.Unix(t.Unix()).Add(-c.offset)
+}
+
+// Close closes the PHC device.
+func (c *phcClock) Close() error {
+	return c.file.Close()
+}
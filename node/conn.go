@@ -4,13 +4,23 @@
 package node
 
 import (
+	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/heistp/antler/node/metric"
 )
 
-// txBufLen is the length of the send goroutine's buffered channel.
+// txBufLen is the default maximum number of messages passed to the send
+// goroutine at once (see Tuning.ConnTxLen).
 const txBufLen = 16
 
+// streamBurst is the depth in bytes of the token bucket that limits the rate
+// of streamed messages (see ResultStream.MaxRate).
+const streamBurst = 64 * 1024
+
 // conn is a connection to another node. conn must be created with newConn, and
 // is safe for concurrent use. All methods except Close are asynchronous, with
 // errors sent to the event channel passed to the start method.
@@ -20,28 +30,35 @@ const txBufLen = 16
 // will be sent on the event channel.
 type conn struct {
 	mtx      sync.Mutex
-	tr       transport     // underlying transport
-	to       Node          // peer node
-	tq       chan any      // send queue
-	tx       chan message  // send goroutine channel
-	io       int           // I/O goroutine count
-	rpc      map[runID]run // active RPC calls
-	id       runID         // ID for next Run call
-	canceled bool          // true if conn is canceled
+	tr       transport          // underlying transport
+	to       Node               // peer node
+	tq       chan any           // send queue
+	tx       chan []message     // send goroutine channel
+	io       int                // I/O goroutine count
+	rpc      map[runID]run      // active RPC calls
+	id       runID              // ID for next Run call
+	canceled bool               // true if conn is canceled
+	rate     atomic.Int64       // max rate for streamed messages, or 0
+	ctx      context.Context    // done after a receive error, or when closed
+	stop     context.CancelFunc // cancels ctx
 }
 
 // newConn returns a new conn for the given underlying conn.
 func newConn(tr transport, to Node) *conn {
+	x, s := context.WithCancel(context.Background())
 	return &conn{
-		sync.Mutex{},                 // mtx
-		tr,                           // tr
-		to,                           // to
-		make(chan any),               // tq
-		make(chan message, txBufLen), // tx
-		0,                            // io
-		make(map[runID]run),          // run
-		0,                            // id
-		false,                        // canceled
+		sync.Mutex{},         // mtx
+		tr,                   // tr
+		to,                   // to
+		make(chan any),       // tq
+		make(chan []message), // tx
+		0,                    // io
+		make(map[runID]run),  // run
+		0,                    // id
+		false,                // canceled
+		atomic.Int64{},       // rate
+		x,                    // ctx
+		s,                    // stop
 	}
 }
 
@@ -74,16 +91,17 @@ func (c *conn) Send(m message) {
 	c.tq <- m
 }
 
-// Cancel sends a cancel message and "cancels" the conn. If the call was
-// canceled or closed, this call does nothing.
-func (c *conn) Cancel() {
+// Cancel sends a cancel message and "cancels" the conn. Failed indicates if the
+// cancel is due to a failure. If the call was canceled or closed, this call
+// does nothing.
+func (c *conn) Cancel(failed bool) {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
 	if c.canceled {
 		return
 	}
 	c.canceled = true
-	c.tq <- cancel{}
+	c.tq <- cancel{Failed: failed}
 }
 
 // Canceled sends a canceled message and "cancels" the conn. If the call was
@@ -98,6 +116,29 @@ func (c *conn) Canceled() {
 	c.tq <- canceled{}
 }
 
+// Tune applies the given Tuning to the conn. If the call was canceled or
+// closed, this call does nothing.
+func (c *conn) Tune(t Tuning) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.canceled {
+		return
+	}
+	c.tq <- t
+}
+
+// Limit applies the given Overflow to the conn's send queue, with QueueOverflow
+// items sent for the given node ID. If the call was canceled or closed, this
+// call does nothing.
+func (c *conn) Limit(o Overflow, node ID) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.canceled {
+		return
+	}
+	c.tq <- queueLimit{Overflow: o, node: node}
+}
+
 // Stream uses the given ResultStream to select which messages will be sent
 // immediately (streamed) or buffered. If the call was canceled or closed, this
 // call does nothing.
@@ -122,42 +163,52 @@ func (c *conn) failRPC() {
 // start starts the I/O goroutines. The caller must read from the event channel
 // until connDone is received.
 func (c *conn) start(ev chan<- event) {
-	go c.buffer()
+	go c.buffer(ev)
 	c.io += 2
 	go c.send(ev)
 	go c.receive(ev)
 }
 
-// buffer receives messages and stream filters from the tq channel until closed,
-// or a final message is received, buffering messages as necessary and writing
-// them to the tx channel. After all messages have been sent, tx is closed.
-func (c *conn) buffer() {
+// buffer receives messages, stream filters, Tuning and queue limits from the
+// tq channel until closed, or a final message is received, buffering messages
+// as necessary and writing them to the tx channel in batches of up to
+// ConnTxLen. After all messages have been sent, tx is closed.
+//
+// Streamed and pushed messages are queued in t, subject to the queueLimit. If
+// the queue overflows with the fail Policy, the error event is sent on ev
+// before buffer returns.
+func (c *conn) buffer(ev chan<- event) {
 	defer close(c.tx)
 	var s *ResultStream
+	var q queueLimit
+	n := txBufLen
 	t := make([]message, 0, 1024)
 	b := make([]message, 0, 8192)
-	txc := func() chan message {
-		if len(t) > 0 {
-			return c.tx
-		}
-		return nil
-	}
-	txm := func() message {
-		if len(t) > 0 {
-			return t[0]
-		}
-		return nil
-	}
 	release := func() {
+		c.rate.Store(0)
 		for _, p := range b {
 			t = append(t, p)
 		}
 		b = b[:0]
 	}
 	tq := c.tq
-	for tq != nil || txc() != nil {
+	for tq != nil || len(t) > 0 || q.fail != nil {
+		var tx chan []message
+		k := min(len(t), n)
+		if k > 0 {
+			tx = c.tx
+		}
+		rq := tq
+		var x bool
+		if t, x = q.block(t); x {
+			rq = nil
+		}
+		var fc chan<- event
+		if q.fail != nil {
+			fc = ev
+		}
 		select {
-		case a := <-tq:
+		case a := <-rq:
 			if a == nil {
 				tq = nil
 				release()
@@ -173,6 +224,7 @@ func (c *conn) buffer() {
 				b = append(b, v)
 			case *ResultStream:
 				s = v
+				c.rate.Store(int64(s.MaxRate))
 				bb := make([]message, 0, len(b)+8192)
 				for _, m := range b {
 					if s.accept(m) {
@@ -182,54 +234,121 @@ func (c *conn) buffer() {
 					}
 				}
 				b = bb
+			case Tuning:
+				if v.ConnTxLen > 0 {
+					n = v.ConnTxLen
+				}
+			case queueLimit:
+				q = v
 			}
 			if m != nil {
 				if m.flags()&flagFinal != 0 {
 					tq = nil
 					release()
+					if o := q.summary(); o != nil {
+						t = append(t, o)
+					}
+					t = append(t, m)
+					break
 				}
-				t = append(t, m)
+				t = q.admit(t, m)
 			}
-		case txc() <- txm():
-			t = t[1:]
+		case tx <- t[:k]:
+			t = t[k:]
+		case fc <- q.fail:
+			q.fail = nil
 		}
 	}
 }
 
 // send sends messages from the tx channel to the transport, until tx is closed.
 // After the first error, the tx channel is drained and messages dropped.
+//
+// If the rate is set from ResultStream.MaxRate and the transport counts the
+// bytes it sends, streamed messages are limited to that rate by a token
+// bucket. Each streamed message takes its tokens after it's sent, and the next
+// streamed message waits until the bucket is out of debt. Pushed and final
+// messages, which include cancel and canceled, are neither limited nor delayed
+// by the debt. The wait ends early if receive fails, as the peer is gone.
 func (c *conn) send(ev chan<- event) {
 	defer c.ioDone(ev)
 	defer func() {
 		for range c.tx {
 		}
 	}()
-	for m := range c.tx {
-		if e := c.tr.Send(m); e != nil {
-			e = fmt.Errorf("send error to '%s': %w", c.to, e)
-			ev <- errorEvent{e, true}
-			return
+	sc, _ := c.tr.(sendCounter)
+	var r metric.Bitrate
+	var b *tokenBucket
+	var w time.Time
+	for mm := range c.tx {
+		for _, m := range mm {
+			ctl := m.flags()&(flagPush|flagFinal) != 0
+			if x := metric.Bitrate(c.rate.Load()); x != r {
+				r = x
+				b = nil
+				w = time.Time{}
+				if r > 0 && sc != nil {
+					b = newTokenBucket(r, streamBurst, metric.Now())
+				}
+			}
+			if b != nil && !ctl {
+				if e := c.sleep(w); e != nil {
+					return
+				}
+			}
+			var n int64
+			if sc != nil {
+				n = sc.sent()
+			}
+			if e := c.tr.Send(m); e != nil {
+				e = fmt.Errorf("send error to '%s': %w", c.to, e)
+				ev <- errorEvent{e, true}
+				return
+			}
+			if b != nil && !ctl {
+				w = time.Now().Add(b.reserve(int(sc.sent() - n)))
+			}
 		}
 	}
 }
 
-// receive receives messages from the transport.
+// sleep waits until the given time, or returns the cause if the conn's
+// Context is done first.
+func (c *conn) sleep(until time.Time) (err error) {
+	d := time.Until(until)
+	if d <= 0 {
+		return
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-c.ctx.Done():
+		err = context.Cause(c.ctx)
+	case <-t.C:
+	}
+	return
+}
+
+// receive receives messages from the transport. After an error, the conn's
+// Context is canceled, so that send doesn't wait on the rate limit.
 func (c *conn) receive(ev chan<- event) {
 	defer c.ioDone(ev)
+	fail := func(err error) {
+		c.stop()
+		ev <- errorEvent{err, true}
+	}
 	for {
 		m, e := c.tr.Receive()
 		if e != nil {
-			e = fmt.Errorf("receive error from '%s': %w", c.to, e)
-			ev <- errorEvent{e, true}
+			fail(fmt.Errorf("receive error from '%s': %w", c.to, e))
 			return
 		}
 		if m == nil {
-			e = fmt.Errorf("nil message received from %s", c.to)
-			ev <- errorEvent{e, true}
+			fail(fmt.Errorf("nil message received from %s", c.to))
 			return
 		}
 		if e := c.received(m, ev); e != nil {
-			ev <- errorEvent{e, true}
+			fail(e)
 			return
 		}
 		if m.flags()&flagFinal != 0 {
@@ -270,6 +389,7 @@ func (c *conn) ioDone(ev chan<- event) {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
 	if c.io--; c.io == 0 {
+		c.stop()
 		c.failRPC()
 		close(c.tq)
 		if e := c.tr.Close(); e != nil {
@@ -349,11 +469,12 @@ func (c *child) Count() int {
 	return len(c.m)
 }
 
-// Cancel cancels all of the children in the cache.
-func (c *child) Cancel() {
+// Cancel cancels all of the children in the cache, indicating if the cancel is
+// due to a failure.
+func (c *child) Cancel(failed bool) {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
 	for _, c := range c.m {
-		c.Cancel()
+		c.Cancel(failed)
 	}
 }
@@ -0,0 +1,81 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"encoding/gob"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/heistp/antler/node/metric"
+)
+
+// FlowCounters contains summary counters for the client side of a flow. It's
+// returned in the Feedback of a StreamClient or PacketClient with CountersKey
+// set, so simple results are available without saving a DataFile, or running
+// the Analyze report.
+type FlowCounters struct {
+	// Flow is the flow identifier.
+	Flow Flow
+
+	// SentBytes is the number of bytes sent by the client. For stream flows,
+	// it's the number of bytes acknowledged by the server (tcpi_bytes_acked).
+	SentBytes metric.Bytes
+
+	// ReceivedBytes is the number of bytes received by the client. For stream
+	// flows, it's tcpi_bytes_received.
+	ReceivedBytes metric.Bytes
+
+	// SentPackets is the number of packets sent by the client. For stream
+	// flows, it's the number of TCP segments sent, including pure ACKs
+	// (tcpi_segs_out).
+	SentPackets int
+
+	// ReceivedPackets is the number of packets received by the client. For
+	// stream flows, it's the number of TCP segments received (tcpi_segs_in).
+	ReceivedPackets int
+
+	// Duration is the elapsed time for the flow.
+	Duration metric.Duration
+}
+
+// init registers FlowCounters with the gob encoder
+func init() {
+	gob.Register(FlowCounters{})
+}
+
+// addSent adds a sent packet of the given length.
+func (c *FlowCounters) addSent(length int) {
+	c.SentBytes += metric.Bytes(length)
+	c.SentPackets++
+}
+
+// addReceived adds a received packet of the given length.
+func (c *FlowCounters) addReceived(length int) {
+	c.ReceivedBytes += metric.Bytes(length)
+	c.ReceivedPackets++
+}
+
+// setTCP sets the counters from the kernel's TCP_INFO for the given conn.
+func (c *FlowCounters) setTCP(conn net.Conn) (err error) {
+	var r syscall.RawConn
+	if r, err = rawConn(conn); err != nil {
+		return
+	}
+	err = c.getsockopt(r)
+	return
+}
+
+// since sets Duration to the time elapsed since t0.
+func (c *FlowCounters) since(t0 time.Time) {
+	c.Duration = metric.Duration(time.Since(t0))
+}
+
+func (c FlowCounters) String() string {
+	return fmt.Sprintf("FlowCounters[Flow:%s Sent:%s/%dpkts "+
+		"Received:%s/%dpkts Duration:%s]", c.Flow, c.SentBytes,
+		c.SentPackets, c.ReceivedBytes, c.ReceivedPackets, c.Duration)
+}
@@ -0,0 +1,171 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// RunnerFactory returns a CustomRunner, given the Config from a Custom runner.
+// Config is the JSON encoding of the Config value in the CUE config, or empty
+// if Config isn't set.
+type RunnerFactory func(config json.RawMessage) (CustomRunner, error)
+
+// CustomRunner is the interface implemented by runners in external packages,
+// which are registered with RegisterRunner and run with a Custom runner.
+//
+// Run is called on the node with a Context that's canceled when the Test is
+// canceled, and a RunnerArg used to send data and logs to the controller.
+// Run should return as soon as possible after the Context is canceled.
+type CustomRunner interface {
+	Run(ctx context.Context, arg RunnerArg) (Feedback, error)
+}
+
+// RunnerArg contains the arguments supplied to a CustomRunner.
+type RunnerArg struct {
+	// Feedback is the incoming Feedback from prior runners.
+	Feedback Feedback
+
+	rec *recorder
+	cxl chan canceler
+}
+
+// NodeID returns the ID of the node the runner is running on.
+func (r RunnerArg) NodeID() ID {
+	return r.rec.nodeID
+}
+
+// Logf logs a message, which is saved with the node logs.
+func (r RunnerArg) Logf(format string, a ...any) {
+	r.rec.Logf(format, a...)
+}
+
+// Send sends a data point to the controller, where it's passed to the
+// reporters, and saved in the Test's DataFile. The data point's type must be
+// registered with RegisterData, in both the node and antler executables.
+func (r RunnerArg) Send(data any) {
+	r.rec.Send(customData{data})
+}
+
+// OnCancel registers a function to be called before the node exits, to perform
+// cleanup (e.g. to stop a background process). Functions are called in reverse
+// order, along with the cleanup of the builtin runners.
+func (r RunnerArg) OnCancel(f func() error) {
+	r.cxl <- cancelFunc(f)
+}
+
+// customRunners contains the registered RunnerFactory's, by name.
+var customRunners = struct {
+	factory map[string]RunnerFactory
+	sync.Mutex
+}{factory: make(map[string]RunnerFactory)}
+
+// RegisterRunner registers a RunnerFactory under the given name, for use by
+// Custom runners. It's typically called from the init function of an external
+// package. To use custom runners, both the node and antler executables must be
+// built with the registering package imported (e.g. from copies of cmd/node and
+// cmd/antler), and antler.SetNodeExes must be used to launch the custom node
+// executables.
+// RegisterRunner panics if the name is empty, or is already registered.
+func RegisterRunner(name string, factory RunnerFactory) {
+	customRunners.Lock()
+	defer customRunners.Unlock()
+	if name == "" {
+		panic("RegisterRunner: empty name")
+	}
+	if _, ok := customRunners.factory[name]; ok {
+		panic(fmt.Sprintf("RegisterRunner: duplicate name %s", name))
+	}
+	customRunners.factory[name] = factory
+}
+
+// customDataTypes contains the types registered with RegisterData, by the
+// string representation of their reflect.Type (e.g. "mypkg.Reading").
+var customDataTypes = struct {
+	types map[string]reflect.Type
+	sync.Mutex
+}{types: make(map[string]reflect.Type)}
+
+// RegisterData registers the type of the given value as a data point type that
+// CustomRunners may send, and reporters may receive. It's registered with the
+// gob encoder, and for the JSON DataFormat. RegisterData should be called from
+// an init function in both the node and antler executables, with a value (not
+// a pointer) of the type.
+func RegisterData(value any) {
+	gob.Register(value)
+	t := reflect.TypeOf(value)
+	customDataTypes.Lock()
+	customDataTypes.types[t.String()] = t
+	customDataTypes.Unlock()
+}
+
+// DataType returns the type registered with RegisterData with the given name,
+// as returned by reflect.Type.String, or false if there is none.
+func DataType(name string) (t reflect.Type, ok bool) {
+	customDataTypes.Lock()
+	defer customDataTypes.Unlock()
+	t, ok = customDataTypes.types[name]
+	return
+}
+
+// customData wraps a data point sent by a CustomRunner, so it can be passed
+// through the node tree. It's unwrapped before it's sent to the reporters.
+type customData struct {
+	Value any
+}
+
+// init registers customData with the gob encoder
+func init() {
+	gob.Register(customData{})
+}
+
+// flags implements message
+func (customData) flags() flag {
+	return flagForward
+}
+
+// handle implements event
+func (c customData) handle(node *node) {
+	node.parent.Send(c)
+}
+
+// Custom runs a CustomRunner registered with RegisterRunner.
+type Custom struct {
+	// Name is the name the RunnerFactory was registered with.
+	Name string
+
+	// Config is the configuration passed to the RunnerFactory, as JSON.
+	Config json.RawMessage
+}
+
+// Run implements runner
+func (c *Custom) Run(ctx context.Context, arg runArg) (ofb Feedback,
+	err error) {
+	customRunners.Lock()
+	f, ok := customRunners.factory[c.Name]
+	customRunners.Unlock()
+	if !ok {
+		err = fmt.Errorf("no custom runner registered with name %s", c.Name)
+		return
+	}
+	var r CustomRunner
+	if r, err = f(c.Config); err != nil {
+		return
+	}
+	ofb, err = r.Run(ctx, RunnerArg{arg.ifb, arg.rec, arg.cxl})
+	return
+}
+
+// validate implements validater
+func (c *Custom) validate() (err error) {
+	if c.Name == "" {
+		err = fmt.Errorf("Custom runner requires a Name")
+	}
+	return
+}
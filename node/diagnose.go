@@ -0,0 +1,70 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+
+	"github.com/heistp/antler/node/metric"
+)
+
+// Diagnose configures diagnostic commands that are run on each node when a Test
+// fails, to capture the state of the node (e.g. addresses, routes, qdisc and
+// socket statistics) before it's torn down. The commands are run after the
+// node's runners have completed, but before their cancelers are called, and
+// their output is sent as FileData named diag_<nodeID>.txt, so it's saved in
+// the result directory by the SaveFiles reporter.
+type Diagnose struct {
+	// Command lists the commands to run. Each is split into command name and
+	// arguments using a shell-style escaping scheme, as for Command. If empty,
+	// no diagnostics are captured.
+	Command []string
+
+	// Timeout is the maximum time each command may run for.
+	Timeout metric.Duration
+}
+
+// run runs the commands, and sends their combined output as FileData.
+func (d Diagnose) run(rec *recorder) {
+	if len(d.Command) == 0 {
+		return
+	}
+	rec.Logf("capturing diagnostics after failure")
+	var b bytes.Buffer
+	for _, c := range d.Command {
+		fmt.Fprintf(&b, "$ %s\n", c)
+		o, err := d.output(c)
+		b.Write(o)
+		if len(o) > 0 && o[len(o)-1] != '\n' {
+			b.WriteByte('\n')
+		}
+		if err != nil {
+			fmt.Fprintf(&b, "(%s)\n", err)
+		}
+		b.WriteByte('\n')
+	}
+	// diagnostics are sent without the FileRate limit, as FileData waiting for
+	// it is abandoned after the node fails
+	rec.fileData(fmt.Sprintf("diag_%s.txt", rec.nodeID), b.Bytes(), false)
+}
+
+// output runs the given command, and returns its combined stdout and stderr.
+func (d Diagnose) output(command string) (out []byte, err error) {
+	var n string
+	var a []string
+	if n, a, err = (Command{Command: command}).param(); err != nil {
+		return
+	}
+	ctx := context.Background()
+	if d.Timeout > 0 {
+		var x context.CancelFunc
+		ctx, x = context.WithTimeout(ctx, d.Timeout.Duration())
+		defer x()
+	}
+	out, err = exec.CommandContext(ctx, n, a...).CombinedOutput()
+	return
+}
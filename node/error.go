@@ -50,7 +50,7 @@ type ErrorFactory struct {
 // NewError returns a new Error with the given message.
 func (f ErrorFactory) NewError(message string) Error {
 	t := time.Now()
-	return Error{LogEntry{t, f.nodeID, f.tag, message}}
+	return Error{LogEntry{t, f.nodeID, f.tag, message, LogInfo}}
 }
 
 // NewErrore returns an Error from the given error. If the given error is
@@ -60,13 +60,14 @@ func (f ErrorFactory) NewErrore(err error) Error {
 	if e, ok := err.(Error); ok {
 		return e
 	}
-	return Error{LogEntry{t, f.nodeID, f.tag, err.Error()}}
+	return Error{LogEntry{t, f.nodeID, f.tag, err.Error(), LogInfo}}
 }
 
 // NewErrorf returns an Error with its Message formatted with prinf style args.
 func (f ErrorFactory) NewErrorf(format string, a ...any) Error {
 	t := time.Now()
-	return Error{LogEntry{t, f.nodeID, f.tag, fmt.Sprintf(format, a...)}}
+	return Error{LogEntry{t, f.nodeID, f.tag, fmt.Sprintf(format, a...),
+		LogInfo}}
 }
 
 // UnionError is returned when a union type doesn't have exactly one field set.
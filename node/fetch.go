@@ -0,0 +1,195 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/heistp/antler/node/metric"
+)
+
+// Fetch is a runner that reads files or directories on the node, and sends
+// them as FileData, so artifacts produced on the node (e.g. kernel logs or ss
+// dumps) are saved in the result directory by the SaveFiles reporter, without
+// running cat with System.
+//
+// Fetched files are named by Prefix, followed by their path relative to the
+// directory containing the matched Path, with path separators replaced by
+// underscores. For example, with the default Prefix, the file
+// /var/log/app/run.log fetched from node a with the Path /var/log/app is named
+// a_app_run.log.
+type Fetch struct {
+	// Path lists the files or directories to fetch. Each may be a glob pattern,
+	// in the syntax of filepath.Match. Directories are fetched recursively.
+	Path []string
+
+	// Prefix is prepended to the names of the fetched files. If empty, the
+	// prefix is the node ID followed by an underscore.
+	Prefix string
+
+	// MaxFileSize, if positive, is the maximum number of bytes fetched from
+	// each file. Larger files are truncated, with a warning logged.
+	MaxFileSize metric.Bytes
+
+	// MaxSize, if positive, is the maximum total number of bytes fetched. Once
+	// it's reached, the remaining files are skipped, with a warning logged.
+	MaxSize metric.Bytes
+
+	// IgnoreMissing, if true, logs a warning for a Path that matches no files,
+	// instead of returning an error.
+	IgnoreMissing bool
+
+	prefix string
+	name   map[string]string
+	total  int64
+	rec    *recorder
+}
+
+// Run implements runner
+func (f *Fetch) Run(ctx context.Context, arg runArg) (ofb Feedback,
+	err error) {
+	f.prefix = f.Prefix
+	if f.prefix == "" {
+		f.prefix = string(arg.rec.nodeID) + "_"
+	}
+	f.name = make(map[string]string)
+	f.total = 0
+	f.rec = arg.rec
+	for _, p := range f.Path {
+		var m []string
+		if m, err = filepath.Glob(p); err != nil {
+			return
+		}
+		if len(m) == 0 {
+			if f.IgnoreMissing {
+				arg.rec.Logf("no files match %s", p)
+				continue
+			}
+			err = fmt.Errorf("no files match %s", p)
+			return
+		}
+		for _, r := range m {
+			if err = f.walk(ctx, r); err != nil {
+				return
+			}
+		}
+	}
+	arg.rec.Logf("fetched %s", metric.Bytes(f.total).SI())
+	return
+}
+
+// walk fetches the file or directory tree at root.
+func (f *Fetch) walk(ctx context.Context, root string) error {
+	d := filepath.Dir(root)
+	return filepath.WalkDir(root, func(path string, e fs.DirEntry,
+		err error) error {
+		if err != nil {
+			return err
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if e.IsDir() {
+			return nil
+		}
+		if !e.Type().IsRegular() {
+			var i fs.FileInfo
+			if i, err = os.Stat(path); err != nil {
+				return err
+			}
+			if !i.Mode().IsRegular() {
+				f.rec.Logf("skipping %s, not a regular file", path)
+				return nil
+			}
+		}
+		var r string
+		if r, err = filepath.Rel(d, path); err != nil {
+			return err
+		}
+		n := f.prefix + strings.ReplaceAll(filepath.ToSlash(r), "/", "_")
+		if p, ok := f.name[n]; ok {
+			return fmt.Errorf("fetched files %s and %s have the same name %s",
+				p, path, n)
+		}
+		f.name[n] = path
+		return f.fetch(ctx, path, n)
+	})
+}
+
+// fetch sends the file at path as FileData with the given name, applying
+// MaxFileSize and MaxSize.
+func (f *Fetch) fetch(ctx context.Context, path, name string) (err error) {
+	l := int64(-1)
+	if f.MaxFileSize > 0 {
+		l = int64(f.MaxFileSize)
+	}
+	if f.MaxSize > 0 {
+		r := int64(f.MaxSize) - f.total
+		if r <= 0 {
+			f.rec.Logf("skipping %s, MaxSize %s reached", path,
+				f.MaxSize.SI())
+			return
+		}
+		if l < 0 || r < l {
+			l = r
+		}
+	}
+	var h *os.File
+	if h, err = os.Open(path); err != nil {
+		return
+	}
+	defer h.Close()
+	var r io.Reader = h
+	if l >= 0 {
+		r = io.LimitReader(h, l+1)
+	}
+	var n int64
+	for {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		b := make([]byte, fileChunkLen)
+		var m int
+		m, err = r.Read(b)
+		if l >= 0 && n+int64(m) > l {
+			m = int(l - n)
+			f.rec.Logf("truncated %s to %s", path, metric.Bytes(l).SI())
+			err = io.EOF
+		}
+		if m > 0 || (n == 0 && errors.Is(err, io.EOF)) {
+			f.rec.FileData(name, b[:m])
+			n += int64(m)
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = nil
+			}
+			break
+		}
+	}
+	f.total += n
+	return
+}
+
+// validate implements validater
+func (f *Fetch) validate() (err error) {
+	if len(f.Path) == 0 {
+		err = fmt.Errorf("Fetch requires at least one Path")
+		return
+	}
+	for _, p := range f.Path {
+		if _, e := filepath.Match(p, ""); e != nil {
+			err = fmt.Errorf("invalid Fetch Path '%s': %w", p, e)
+			return
+		}
+	}
+	return
+}
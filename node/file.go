@@ -4,15 +4,41 @@
 package node
 
 import (
+	"context"
 	"encoding/gob"
 	"fmt"
+	"hash/crc32"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/heistp/antler/node/metric"
 )
 
-// FileData contains a chunk of binary data to be saved in a file.
+// fileChunkLen is the maximum length of the Data in the FileData's sent by a
+// recorder. Larger data is split into multiple chunks, so that files don't
+// block other messages on the conns for long.
+const fileChunkLen = 64 * 1024
+
+// castagnoli is the CRC-32C table used for FileData checksums.
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// FileData contains a chunk of binary data to be saved in a file. FileData's
+// sent by a recorder are split into chunks of at most 64 KiB, with Offset and
+// Checksum set, so that lost or corrupted chunks may be detected.
 type FileData struct {
 	Name string // the name of the file
 	Data []byte // the data
+
+	// Offset is the offset of Data in the file.
+	Offset int64
+
+	// Checksum is the CRC-32C of Data, or zero if it's unknown.
+	Checksum uint32
+
+	// NodeID is the ID of the node that sent the data. Offsets are per node,
+	// as more than one node may send data for the same Name.
+	NodeID ID
 }
 
 // init registers FileData with the gob encoder
@@ -30,11 +56,138 @@ func (f FileData) handle(node *node) {
 	node.parent.Send(f)
 }
 
+// Verify returns an error if the Checksum is set and doesn't match Data, or
+// Offset doesn't match the expected offset of the chunk in the file (the total
+// length of the prior chunks from the same node). Offset is only checked if
+// Checksum is set, as older FileData's didn't have it.
+func (f FileData) Verify(offset int64) error {
+	if f.Checksum == 0 {
+		return nil
+	}
+	if c := crc32.Checksum(f.Data, castagnoli); c != f.Checksum {
+		return fmt.Errorf("FileData %s checksum mismatch at offset %d "+
+			"(0x%08x != 0x%08x)", f.Name, f.Offset, c, f.Checksum)
+	}
+	if f.Offset != offset {
+		return fmt.Errorf("FileData %s from node %s has offset %d, "+
+			"expected %d (chunk lost or out of order)", f.Name, f.NodeID,
+			f.Offset, offset)
+	}
+	return nil
+}
+
 // Trim returns Data as a string, with whitespace trimmed.
 func (f FileData) Trim() string {
 	return strings.TrimSpace(string(f.Data))
 }
 
 func (f FileData) String() string {
-	return fmt.Sprintf("FileData[Name:%s Len:%d]", f.Name, len(f.Data))
+	return fmt.Sprintf("FileData[Name:%s Len:%d Offset:%d NodeID:%s]",
+		f.Name, len(f.Data), f.Offset, f.NodeID)
+}
+
+// fileSender splits data into FileData chunks, tracks the offset for each
+// file name, and limits the rate that chunks are sent at. It's shared by a
+// recorder and its copies, so the rate limit applies to the whole node.
+//
+// Waits for the rate limit are done without holding the mutex, so senders of
+// different files take turns by chunk, and are abandoned when the Context set
+// with setContext is done.
+type fileSender struct {
+	node   ID
+	ctx    context.Context
+	rate   metric.Bitrate
+	bucket *tokenBucket
+	offset map[string]int64
+	name   map[string]*sync.Mutex
+	mtx    sync.Mutex
+}
+
+// setContext sets the Context that, when done, abandons any sends waiting for
+// the rate limit.
+func (s *fileSender) setContext(ctx context.Context) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.ctx = ctx
+}
+
+// setRate sets the maximum rate to send FileData at, or zero for no limit.
+func (s *fileSender) setRate(rate metric.Bitrate) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.rate = rate
+	s.bucket = nil
+	if rate > 0 {
+		s.bucket = newTokenBucket(rate, fileChunkLen, metric.Now())
+	}
+}
+
+// send calls the send func with a FileData for each chunk of data, waiting as
+// needed for the rate limit if limit is true. Chunks for the same name are sent
+// in order. If the Context is done while waiting, the remaining chunks are not
+// sent, and the cause is returned.
+func (s *fileSender) send(name string, data []byte, limit bool,
+	send func(FileData)) (err error) {
+	l := s.nameLock(name)
+	l.Lock()
+	defer l.Unlock()
+	for {
+		n := min(len(data), fileChunkLen)
+		if limit {
+			if err = s.wait(n); err != nil {
+				return
+			}
+		}
+		c := data[:n]
+		s.mtx.Lock()
+		o := s.offset[name]
+		s.offset[name] += int64(n)
+		s.mtx.Unlock()
+		send(FileData{name, c, o, crc32.Checksum(c, castagnoli), s.node})
+		if data = data[n:]; len(data) == 0 {
+			return
+		}
+	}
+}
+
+// nameLock returns the mutex that serializes sends for the given file name.
+func (s *fileSender) nameLock(name string) (l *sync.Mutex) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.offset == nil {
+		s.offset = make(map[string]int64)
+		s.name = make(map[string]*sync.Mutex)
+	}
+	var ok bool
+	if l, ok = s.name[name]; !ok {
+		l = &sync.Mutex{}
+		s.name[name] = l
+	}
+	return
+}
+
+// wait reserves n bytes from the rate limit, and waits for them to be
+// available, or returns the cause if the Context is done first.
+func (s *fileSender) wait(n int) (err error) {
+	s.mtx.Lock()
+	var d time.Duration
+	if s.bucket != nil {
+		d = s.bucket.reserve(n)
+	}
+	ctx := s.ctx
+	s.mtx.Unlock()
+	if d <= 0 {
+		return
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		err = context.Cause(ctx)
+	case <-t.C:
+	}
+	return
 }
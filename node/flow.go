@@ -3,11 +3,341 @@
 
 package node
 
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"strings"
+	"text/template"
+)
+
+// maxFlowID is the maximum length of a flow ID, in bytes. This is kept short as
+// flow IDs are used in test packets and to record data points. It must be kept
+// in sync with the #Flow regex in config.cue.
+const maxFlowID = 16
+
 // Flow is a string name identifying a flow.
 type Flow string
 
+// validate returns an error if the Flow is empty or too long.
+func (f Flow) validate() (err error) {
+	if f == "" {
+		err = fmt.Errorf("flow ID is empty")
+		return
+	}
+	if len(f) > maxFlowID {
+		err = FlowLengthError{f}
+	}
+	return
+}
+
+// FlowLengthError is returned when a Flow ID exceeds the maximum length.
+type FlowLengthError struct {
+	Flow Flow
+}
+
+// Error implements error
+func (f FlowLengthError) Error() string {
+	return fmt.Sprintf("flow ID '%s' is %d bytes, exceeding the maximum of %d",
+		f.Flow, len(f.Flow), maxFlowID)
+}
+
 // Flower wraps the Flow method, to return a Flow associated with the
 // implementation.
 type Flower interface {
 	Flow() Flow
 }
+
+// FlowDecl contains information on a Flow, as declared by a client runner in a
+// Run tree.
+type FlowDecl struct {
+	// Flow is the flow identifier.
+	Flow Flow
+
+	// Node is the ID of the Node the client runs on, or empty for the root.
+	Node ID
+
+	// Addr is the dial address of the server, or the AddrKey used to obtain
+	// it, if Addr is empty.
+	Addr string
+
+	// Stream is true for stream flows, and false for packet flows.
+	Stream bool
+
+	// Direction is the client to server sense, for stream flows.
+	Direction Direction
+
+	// CCA is the congestion control algorithm, if set.
+	CCA string
+
+	// DSCP is the DSCP value set by the client's Sockopts.
+	DSCP byte
+
+	// ECN is the ECN field set by the client's Sockopts.
+	ECN byte
+
+	// Server is the ID of the Node the server runs on, or empty for the root.
+	// ServerKnown is true if the server was found in the Run tree, by
+	// matching the client's Addr or AddrKey to a server's listen address.
+	Server      ID
+	ServerKnown bool
+}
+
+// flowServer records the Node and listen address of a server runner, to find
+// the server Node for FlowDecls.
+type flowServer struct {
+	node   ID
+	addr   string
+	key    string
+	stream bool
+}
+
+// matches returns true if the server is the one dialed by the FlowDecl.
+func (s flowServer) matches(d FlowDecl) bool {
+	if s.stream != d.Stream || d.Addr == "" {
+		return false
+	}
+	if s.key != "" && s.key == d.Addr {
+		return true
+	}
+	if s.addr == d.Addr {
+		return true
+	}
+	sh, sp, err := net.SplitHostPort(s.addr)
+	if err != nil {
+		return false
+	}
+	dh, dp, err := net.SplitHostPort(d.Addr)
+	if err != nil || sp != dp {
+		return false
+	}
+	if sh == "" {
+		return true
+	}
+	if ip := net.ParseIP(sh); ip != nil && ip.IsUnspecified() {
+		return true
+	}
+	return sh == dh
+}
+
+// Flows returns FlowDecls for the client runners in the given Run hierarchy,
+// in declaration order. Flows declared more than once are returned only once.
+func Flows(run *Run) (flow []FlowDecl) {
+	m := make(map[Flow]struct{})
+	var ss []flowServer
+	buildFlows(run, "", m, &flow, &ss)
+	for i := range flow {
+		for _, s := range ss {
+			if s.matches(flow[i]) {
+				flow[i].Server = s.node
+				flow[i].ServerKnown = true
+				break
+			}
+		}
+	}
+	return
+}
+
+// buildFlows is called recursively to gather FlowDecls, and the servers they
+// may connect to.
+func buildFlows(run *Run, node ID, seen map[Flow]struct{}, flow *[]FlowDecl,
+	server *[]flowServer) {
+	var rr []Run
+	switch {
+	case len(run.Serial) > 0:
+		rr = run.Serial
+	case len(run.Parallel) > 0:
+		rr = run.Parallel
+	case run.Schedule != nil:
+		rr = run.Schedule.Run
+	case run.Child != nil:
+		buildFlows(&run.Child.Run, run.Child.Node.ID, seen, flow, server)
+		return
+	}
+	for i := range rr {
+		buildFlows(&rr[i], node, seen, flow, server)
+	}
+	var d FlowDecl
+	if s := run.StreamServer; s != nil {
+		*server = append(*server, flowServer{node, s.ListenAddr,
+			s.ListenAddrKey, true})
+		return
+	} else if s := run.PacketServer; s != nil {
+		*server = append(*server, flowServer{node, s.ListenAddr, "", false})
+		return
+	} else if c := run.StreamClient; c != nil {
+		ss, n := c.Streamers.value()
+		if n != 1 {
+			return
+		}
+		var s Stream
+		switch v := ss.(type) {
+		case *Upload:
+			s = v.Stream
+		case *Download:
+			s = v.Stream
+		case *RPC:
+			s.Flow = v.Flow
+			s.Sockopts = v.Sockopts
+		}
+		a := c.Addr
+		if a == "" {
+			a = c.AddrKey
+		}
+		d = FlowDecl{s.Flow, node, a, true, s.Direction, s.CCA, s.DSCP,
+			s.ECN, "", false}
+	} else if c := run.PacketClient; c != nil {
+		d = FlowDecl{c.Flow, node, c.Addr, false, "", c.CCA, c.DSCP, c.ECN,
+			"", false}
+	} else if c := run.IRTTClient; c != nil {
+		d = FlowDecl{c.Flow, node, c.Addr, false, "", "", 0, 0, "", false}
+	} else {
+		return
+	}
+	if _, ok := seen[d.Flow]; ok {
+		return
+	}
+	seen[d.Flow] = struct{}{}
+	*flow = append(*flow, d)
+}
+
+// FlowNameData is the data passed to Flow templates. Client Flows containing
+// "{{" are executed as Go templates by ExpandFlowNames, e.g.
+// "{{.Test.cca}}-{{.Role}}{{.Index}}".
+type FlowNameData struct {
+	// Test is the Test ID.
+	Test map[string]string
+
+	// Node is the ID of the Node the client runs on, or empty for the root.
+	Node ID
+
+	// Role is the role of the client, either up, down or rpc for stream
+	// clients, packet for PacketClient, http for HTTPClient, or irtt for
+	// IRTTClient.
+	Role string
+
+	// Index is the position of the client among those with the same Role in
+	// the Run hierarchy, starting from 1.
+	Index int
+}
+
+// ExpandFlowNames executes the templates in any client Flows in the given Run
+// hierarchy, and replaces them with the output, shortened if needed to a
+// valid Flow by shortFlow. The returned map contains the full names for the
+// generated Flows, so they may be used as labels in reports. An error is
+// returned if a template fails, or two different names shorten to the same
+// Flow.
+func ExpandFlowNames(run *Run, test map[string]string) (
+	name map[Flow]string, err error) {
+	name = make(map[Flow]string)
+	x := flowExpander{test, make(map[string]int), name}
+	err = x.expand(run, "")
+	return
+}
+
+// flowExpander contains the state for ExpandFlowNames.
+type flowExpander struct {
+	test  map[string]string
+	index map[string]int
+	name  map[Flow]string
+}
+
+// expand is called recursively to expand the Flow templates in a Run.
+func (x *flowExpander) expand(run *Run, node ID) (err error) {
+	var rr []Run
+	switch {
+	case len(run.Serial) > 0:
+		rr = run.Serial
+	case len(run.Parallel) > 0:
+		rr = run.Parallel
+	case run.Schedule != nil:
+		rr = run.Schedule.Run
+	case run.Child != nil:
+		return x.expand(&run.Child.Run, run.Child.Node.ID)
+	}
+	for i := range rr {
+		if err = x.expand(&rr[i], node); err != nil {
+			return
+		}
+	}
+	switch {
+	case run.StreamClient != nil:
+		err = x.streamers(&run.StreamClient.Streamers, node)
+	case run.QUICStreamClient != nil:
+		err = x.streamers(&run.QUICStreamClient.Streamers, node)
+	case run.PacketClient != nil:
+		err = x.flow(&run.PacketClient.Flow, node, "packet")
+	case run.HTTPClient != nil:
+		err = x.flow(&run.HTTPClient.Flow, node, "http")
+	case run.IRTTClient != nil:
+		err = x.flow(&run.IRTTClient.Flow, node, "irtt")
+	}
+	return
+}
+
+// streamers expands the Flow template in Streamers.
+func (x *flowExpander) streamers(s *Streamers, node ID) (err error) {
+	switch {
+	case s.Upload != nil:
+		err = x.flow(&s.Upload.Flow, node, string(Up))
+	case s.Download != nil:
+		err = x.flow(&s.Download.Flow, node, string(Down))
+	case s.RPC != nil:
+		err = x.flow(&s.RPC.Flow, node, "rpc")
+	}
+	return
+}
+
+// flow expands the given Flow, if it's a template.
+func (x *flowExpander) flow(flow *Flow, node ID, role string) (err error) {
+	x.index[role]++
+	if !strings.Contains(string(*flow), "{{") {
+		return
+	}
+	var t *template.Template
+	if t, err = template.New("Flow").Parse(string(*flow)); err != nil {
+		return
+	}
+	var b strings.Builder
+	d := FlowNameData{x.test, node, role, x.index[role]}
+	if err = t.Execute(&b, d); err != nil {
+		return
+	}
+	n := b.String()
+	f := shortFlow(n)
+	if m, ok := x.name[f]; ok && m != n {
+		err = fmt.Errorf("flow names '%s' and '%s' both shorten to '%s'",
+			m, n, f)
+		return
+	}
+	x.name[f] = n
+	*flow = f
+	return
+}
+
+// shortFlow returns a valid Flow for the given name. The name is lowercased,
+// invalid characters are replaced with '-', and names that don't start with
+// a-z are prefixed with 'f'. If the result exceeds maxFlowID, it's truncated
+// and suffixed with '-' and four hex digits from a hash of name, so different
+// long names are still likely to produce different Flows.
+func shortFlow(name string) Flow {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '-':
+			b.WriteRune(r)
+		default:
+			b.WriteByte('-')
+		}
+	}
+	s := b.String()
+	if s == "" || s[0] < 'a' || s[0] > 'z' {
+		s = "f" + s
+	}
+	if len(s) <= maxFlowID {
+		return Flow(s)
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return Flow(fmt.Sprintf("%s-%04x", s[:maxFlowID-5], h.Sum32()&0xffff))
+}
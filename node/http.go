@@ -0,0 +1,395 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"time"
+
+	"github.com/heistp/antler/node/metric"
+)
+
+const (
+	// httpObjectPath is the path HTTPServer serves objects from.
+	httpObjectPath = "/object"
+
+	// httpMaxLength is the maximum object length.
+	httpMaxLength = 1024 * 1024 * 1024
+
+	// httpBufLen is the size of the buffer HTTPServer writes objects with.
+	httpBufLen = 32 * 1024
+)
+
+// HTTPServer is a runner that serves objects of the requested length over
+// HTTP, for HTTPClient.
+type HTTPServer struct {
+	// ListenAddr is the listen address, as specified to the address parameter
+	// in net.Listen (e.g. ":port" or "addr:port").
+	ListenAddr string
+
+	// ListenAddrKey is the key used in the returned Feedback for the listen
+	// address, obtained using Listen.Addr.String(). If empty, the listen
+	// address will not be included in the Feedback.
+	ListenAddrKey string
+
+	// Protocol is the protocol to use (tcp, tcp4 or tcp6).
+	Protocol string
+
+	server *http.Server
+	errc   chan error
+}
+
+// Run implements runner
+func (s *HTTPServer) Run(ctx context.Context, arg runArg) (ofb Feedback,
+	err error) {
+	c := net.ListenConfig{}
+	var l net.Listener
+	if l, err = c.Listen(ctx, s.Protocol, s.ListenAddr); err != nil {
+		return
+	}
+	if s.ListenAddrKey != "" {
+		ofb = Feedback{s.ListenAddrKey: l.Addr().String()}
+	}
+	m := http.NewServeMux()
+	m.HandleFunc("GET "+httpObjectPath, s.object)
+	s.server = &http.Server{Handler: m}
+	s.errc = make(chan error, 1)
+	go func() {
+		e := s.server.Serve(l)
+		if errors.Is(e, http.ErrServerClosed) {
+			e = nil
+		}
+		s.errc <- e
+	}()
+	arg.cxl <- s
+	return
+}
+
+// Cancel implements canceler
+func (s *HTTPServer) Cancel() error {
+	s.server.Close()
+	return <-s.errc
+}
+
+// object serves an object with the length in the length query parameter.
+func (s *HTTPServer) object(w http.ResponseWriter, r *http.Request) {
+	n, err := strconv.ParseInt(r.URL.Query().Get("length"), 10, 64)
+	if err != nil || n < 0 || n > httpMaxLength {
+		http.Error(w, "invalid length", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(n, 10))
+	b := make([]byte, min(n, httpBufLen))
+	for i := range b {
+		b[i] = rpcFill
+	}
+	for n > 0 {
+		k := min(n, int64(len(b)))
+		if _, err = w.Write(b[:k]); err != nil {
+			return
+		}
+		n -= k
+	}
+}
+
+// HTTPClient is a runner that requests objects from an HTTPServer, with think
+// times between each request, and records the completion time of each. It
+// models web-like workloads, whose short transfers are dominated by connection
+// setup and slow start, rather than steady state throughput.
+//
+// Requests are sent sequentially, each after the prior response is complete.
+// HTTPClients may be run in Parallel to emulate concurrent connections.
+type HTTPClient struct {
+	// Addr is the dial address, as specified to the address parameter in
+	// net.Dial (e.g. "addr:port").
+	Addr string
+
+	// AddrKey is a key used to obtain the dial address from the incoming
+	// Feedback, if Addr is not specified.
+	AddrKey string
+
+	// Flow is the HTTPClient's flow identifier.
+	Flow Flow
+
+	// Duration is how long to send requests. No new requests are sent after
+	// Duration, though the last request is completed. If zero, requests are
+	// sent until the number of Requests is reached.
+	Duration metric.Duration
+
+	// Requests, if not zero, is the maximum number of requests to send.
+	Requests int
+
+	// Length lists the object lengths to request, which are cycled through
+	// either sequentially or randomly (according to RandomLength).
+	Length []metric.Bytes
+
+	// RandomLength, if true, selects lengths from Length randomly.
+	RandomLength bool
+
+	// ThinkTime lists the times between the completion of each response and
+	// the next request, which are cycled through either sequentially or
+	// randomly (according to RandomThinkTime).
+	ThinkTime []metric.Duration
+
+	// RandomThinkTime, if true, selects times from ThinkTime randomly.
+	RandomThinkTime bool
+
+	// NewConnection, if true, uses a new connection for each request, instead
+	// of keeping the connection alive.
+	NewConnection bool
+
+	// Timeout, if not zero, is the maximum time for each request to complete.
+	Timeout metric.Duration
+
+	lengthIndex int        // current index in Length
+	thinkIndex  int        // current index in ThinkTime
+	rand        *rand.Rand // random number source
+}
+
+// Run implements runner
+func (c *HTTPClient) Run(ctx context.Context, arg runArg) (ofb Feedback,
+	err error) {
+	var a string
+	if a, err = c.addr(arg.ifb); err != nil {
+		return
+	}
+	t := &http.Transport{DisableKeepAlives: c.NewConnection}
+	defer t.CloseIdleConnections()
+	h := &http.Client{Transport: t, Timeout: c.Timeout.Duration()}
+	arg.rec.Send(HTTPInfo{metric.Tinit, c.Flow})
+	end := time.Now().Add(c.Duration.Duration())
+	for q := Seq(0); c.Requests == 0 || int(q) < c.Requests; q++ {
+		if q > 0 {
+			w := time.NewTimer(c.nextThinkTime())
+			select {
+			case <-w.C:
+			case <-ctx.Done():
+				w.Stop()
+				err = context.Cause(ctx)
+				return
+			}
+		}
+		if c.Duration > 0 && !time.Now().Before(end) {
+			break
+		}
+		var r HTTPRequest
+		if r, err = c.get(ctx, h, a, q); err != nil {
+			return
+		}
+		arg.rec.Send(r)
+	}
+	return
+}
+
+// get requests an object, and returns the completed HTTPRequest.
+func (c *HTTPClient) get(ctx context.Context, client *http.Client,
+	addr string, seq Seq) (req HTTPRequest, err error) {
+	u := fmt.Sprintf("http://%s%s?length=%d", addr, httpObjectPath,
+		c.nextLength())
+	var f time.Time
+	var reused bool
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			reused = info.Reused
+		},
+		GotFirstResponseByte: func() {
+			f = time.Now()
+		},
+	})
+	var q *http.Request
+	if q, err = http.NewRequestWithContext(ctx, http.MethodGet, u,
+		nil); err != nil {
+		return
+	}
+	t := metric.Now()
+	t0 := time.Now()
+	var p *http.Response
+	if p, err = client.Do(q); err != nil {
+		return
+	}
+	defer p.Body.Close()
+	var n int64
+	if n, err = io.Copy(io.Discard, p.Body); err != nil {
+		return
+	}
+	d := time.Since(t0)
+	if p.StatusCode != http.StatusOK {
+		err = fmt.Errorf("HTTPClient %s request for %s failed: %s", c.Flow, u,
+			p.Status)
+		return
+	}
+	req = HTTPRequest{c.Flow, seq, t, f.Sub(t0), d, metric.Bytes(n), reused}
+	return
+}
+
+// addr returns the dial address, from either Addr or the Feedback.
+func (c *HTTPClient) addr(ifb Feedback) (a string, err error) {
+	if a = c.Addr; a != "" {
+		return
+	}
+	if v, ok := ifb[c.AddrKey]; ok {
+		a = v.(string)
+	} else {
+		err = fmt.Errorf("no address specified in Addr or AddrKey")
+	}
+	return
+}
+
+// nextLength returns the next object length.
+func (c *HTTPClient) nextLength() metric.Bytes {
+	return c.Length[c.next(len(c.Length), c.RandomLength, &c.lengthIndex)]
+}
+
+// nextThinkTime returns the next think time.
+func (c *HTTPClient) nextThinkTime() time.Duration {
+	if len(c.ThinkTime) == 0 {
+		return 0
+	}
+	return time.Duration(c.ThinkTime[c.next(len(c.ThinkTime),
+		c.RandomThinkTime, &c.thinkIndex)])
+}
+
+// next returns the next index for a list of length n, either randomly, or
+// sequentially using the given current index.
+func (c *HTTPClient) next(n int, random bool, index *int) (i int) {
+	if random {
+		if c.rand == nil {
+			c.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+		}
+		return c.rand.Intn(n)
+	}
+	i = *index
+	if *index++; *index >= n {
+		*index = 0
+	}
+	return
+}
+
+// validate implements validater
+func (c *HTTPClient) validate() (err error) {
+	if err = c.Flow.validate(); err != nil {
+		err = fmt.Errorf("invalid Flow in HTTPClient: %w", err)
+		return
+	}
+	if c.Addr == "" && c.AddrKey == "" {
+		err = fmt.Errorf("either Addr or AddrKey must be set in HTTPClient %s",
+			c.Flow)
+		return
+	}
+	if c.Duration <= 0 && c.Requests <= 0 {
+		err = fmt.Errorf("HTTPClient %s requires a positive Duration or "+
+			"Requests", c.Flow)
+		return
+	}
+	if len(c.Length) == 0 {
+		err = fmt.Errorf("HTTPClient %s requires at least one Length", c.Flow)
+		return
+	}
+	for _, l := range c.Length {
+		if l < 0 || l > httpMaxLength {
+			err = fmt.Errorf("HTTPClient %s Length %d must be from 0 to %d",
+				c.Flow, l, httpMaxLength)
+			return
+		}
+	}
+	for _, t := range c.ThinkTime {
+		if t < 0 {
+			err = fmt.Errorf("HTTPClient %s ThinkTime must not be negative: "+
+				"%s", c.Flow, t)
+			return
+		}
+	}
+	return
+}
+
+// HTTPInfo contains information for an HTTPClient flow.
+type HTTPInfo struct {
+	// Tinit is the base time for the flow's RelativeTime values.
+	Tinit time.Time
+
+	// Flow is the flow identifier.
+	Flow Flow
+}
+
+// init registers HTTPInfo with the gob encoder
+func init() {
+	gob.Register(HTTPInfo{})
+}
+
+// Time returns an absolute from a node-relative time.
+func (h HTTPInfo) Time(t metric.RelativeTime) time.Time {
+	return h.Tinit.Add(time.Duration(t))
+}
+
+// flags implements message
+func (HTTPInfo) flags() flag {
+	return flagForward
+}
+
+// handle implements event
+func (h HTTPInfo) handle(node *node) {
+	node.parent.Send(h)
+}
+
+func (h HTTPInfo) String() string {
+	return fmt.Sprintf("HTTPInfo[Tinit:%s Flow:%s]", h.Tinit, h.Flow)
+}
+
+// HTTPRequest is a time series data point that records one completed HTTP
+// request.
+type HTTPRequest struct {
+	// Flow is the flow that this HTTPRequest is for.
+	Flow Flow
+
+	// Seq is the sequence number of the request, starting from zero.
+	Seq Seq
+
+	// T is the relative time the request was sent.
+	T metric.RelativeTime
+
+	// FirstByte is the time from sending the request until the first byte of
+	// the response was received.
+	FirstByte time.Duration
+
+	// Completion is the time from sending the request until the full response
+	// was received, including any connection setup.
+	Completion time.Duration
+
+	// Length is the length of the response body.
+	Length metric.Bytes
+
+	// Reused is true if the request was sent on a kept alive connection.
+	Reused bool
+}
+
+// init registers HTTPRequest with the gob encoder
+func init() {
+	gob.Register(HTTPRequest{})
+}
+
+// flags implements message
+func (HTTPRequest) flags() flag {
+	return flagForward
+}
+
+// handle implements event
+func (h HTTPRequest) handle(node *node) {
+	node.parent.Send(h)
+}
+
+func (h HTTPRequest) String() string {
+	return fmt.Sprintf("HTTPRequest[Flow:%s Seq:%d T:%s FirstByte:%s "+
+		"Completion:%s Length:%s Reused:%t]", h.Flow, h.Seq, h.T, h.FirstByte,
+		h.Completion, h.Length, h.Reused)
+}
@@ -0,0 +1,131 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/heistp/antler/node/metric"
+)
+
+// IdleTag is the tag key set on the flows of idle probe phases, with the value
+// "pre" or "post". The working latency analysis takes its idle RTT samples
+// from flows with this tag, if there are any.
+const IdleTag = "idle"
+
+// Default IdleProbe field values.
+const (
+	idleProbeDuration = 5 * time.Second
+	idleProbeInterval = 100 * time.Millisecond
+)
+
+// IdleProbe adds idle latency probe phases before and/or after a Test's Run,
+// so each Test gets a baseline RTT measurement without manual changes to its
+// Run. A PacketServer is started on the Server node, then a PacketClient on
+// the Client node sends echo requests every Interval for Duration, before
+// (Pre) and after (Post) the Run. The Run is wrapped with the phases when the
+// config is loaded (see Wrap).
+//
+// The phase flows are named with the Prefix, followed by "idle-pre" or
+// "idle-post", and tagged with IdleTag.
+type IdleProbe struct {
+	// Client is the node that sends the probes.
+	Client Node
+
+	// Server is the node that runs the PacketServer for the probes.
+	Server Node
+
+	// ListenAddr is the PacketServer listen address. It must differ from
+	// that of any PacketServer on the same node in the Run.
+	ListenAddr string
+
+	// Addr is the PacketServer dial address.
+	Addr string
+
+	// Prefix is prepended to the phase Flow IDs.
+	Prefix string
+
+	// Duration is the length of each phase.
+	Duration metric.Duration
+
+	// Interval is the time between echo requests.
+	Interval metric.Duration
+
+	// Length is the length of the echo requests.
+	Length int
+
+	// Pre, if true, adds a phase before the Run.
+	Pre bool
+
+	// Post, if true, adds a phase after the Run.
+	Post bool
+}
+
+// Wrap returns the Run wrapped in a Serial Run with the idle probe phases.
+func (p IdleProbe) Wrap(run Run) (wrapped Run, err error) {
+	if err = p.validate(); err != nil {
+		return
+	}
+	if p.Duration == 0 {
+		p.Duration = metric.Duration(idleProbeDuration)
+	}
+	if p.Interval == 0 {
+		p.Interval = metric.Duration(idleProbeInterval)
+	}
+	s := Serial{{Child: &Child{
+		Run: Run{Runners: Runners{PacketServer: &PacketServer{
+			ListenAddr:    p.ListenAddr,
+			Protocol:      "udp",
+			MaxPacketSize: workloadMaxPacketSize,
+		}}},
+		Node: p.Server,
+	}}}
+	if p.Pre {
+		s = append(s, p.phase("pre"))
+	}
+	s = append(s, run)
+	if p.Post {
+		s = append(s, p.phase("post"))
+	}
+	wrapped.Serial = s
+	return
+}
+
+// phase returns the Run for the named phase.
+func (p IdleProbe) phase(name string) Run {
+	return Run{Child: &Child{
+		Run: Run{Runners: Runners{PacketClient: &PacketClient{
+			Addr:          p.Addr,
+			Protocol:      "udp",
+			Flow:          Flow(p.Prefix + "idle-" + name),
+			MaxPacketSize: workloadMaxPacketSize,
+			Sender: []PacketSenders{{Unresponsive: &Unresponsive{
+				Wait:     []metric.Duration{p.Interval},
+				Length:   []int{p.Length},
+				Duration: p.Duration,
+				Echo:     true,
+			}}},
+			Tags: Tags{IdleTag: name},
+		}}},
+		Node: p.Client,
+	}}
+}
+
+// validate returns an error if the IdleProbe is invalid.
+func (p IdleProbe) validate() (err error) {
+	if !p.Pre && !p.Post {
+		err = fmt.Errorf("IdleProbe requires Pre or Post")
+		return
+	}
+	if p.ListenAddr == "" || p.Addr == "" {
+		err = fmt.Errorf("IdleProbe requires ListenAddr and Addr")
+		return
+	}
+	if p.Duration < 0 || p.Interval < 0 || p.Length < 0 {
+		err = fmt.Errorf("IdleProbe Duration, Interval and Length may not " +
+			"be negative")
+	}
+	return
+}
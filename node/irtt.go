@@ -0,0 +1,161 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/heistp/antler/node/metric"
+)
+
+// IRTTClient runs an irtt client (https://github.com/heistp/irtt) against an
+// irtt server, and emits its per-packet results as PacketInfo and PacketIO
+// data points for Flow, so the Analyze report and packet charts work on irtt
+// measurements as for a PacketClient. The irtt server must be started
+// separately, e.g. with a background System runner.
+//
+// Each round trip is emitted as the client's echo request and reply, with the
+// server's receive and send times if irtt reports them. Server times are by
+// the server's wall clock, so one-way delays are only accurate if the clocks
+// are synchronized, or if Analyze's ClockOffset is enabled. The results are
+// emitted after irtt completes.
+type IRTTClient struct {
+	// Addr is the irtt server address, as given to irtt client.
+	Addr string
+
+	// Flow is the flow identifier for the data points.
+	Flow Flow
+
+	// Duration is the duration of the test (irtt -d).
+	Duration metric.Duration
+
+	// Interval is the send interval (irtt -i).
+	Interval metric.Duration
+
+	// Length is the packet length (irtt -l), or irtt's minimum if zero. It's
+	// used as the Len of the emitted packets.
+	Length int
+
+	// Arg lists extra arguments passed to irtt client (e.g. "--dscp=0xb8").
+	Arg []string
+
+	// Command is the name of or path to the irtt executable.
+	Command string
+
+	// Tags are added to the client's PacketInfo and PacketIO data points.
+	Tags Tags
+}
+
+// irttOutput is the part of irtt's JSON output used by IRTTClient.
+type irttOutput struct {
+	RoundTrips []irttRoundTrip `json:"round_trips"`
+}
+
+// irttRoundTrip is one round trip in irtt's JSON output.
+type irttRoundTrip struct {
+	Seqno      uint64 `json:"seqno"`
+	Timestamps struct {
+		Client irttTimestamps `json:"client"`
+		Server irttTimestamps `json:"server"`
+	} `json:"timestamps"`
+}
+
+// irttTimestamps contains the timestamps for a client or server.
+type irttTimestamps struct {
+	Receive  *irttTime `json:"receive"`
+	Send     *irttTime `json:"send"`
+	Midpoint *irttTime `json:"midpoint"`
+}
+
+// irttTime is a timestamp in irtt's JSON output.
+type irttTime struct {
+	Wall int64 `json:"wall"`
+}
+
+// Run implements runner
+func (c *IRTTClient) Run(ctx context.Context, arg runArg) (ofb Feedback,
+	err error) {
+	x := exec.CommandContext(ctx, c.Command, c.args()...)
+	var o, e bytes.Buffer
+	x.Stdout = &o
+	x.Stderr = &e
+	arg.rec.Logf("%s", x)
+	if err = x.Run(); err != nil {
+		if e.Len() > 0 {
+			err = fmt.Errorf("%w: %s", err, bytes.TrimSpace(e.Bytes()))
+		}
+		return
+	}
+	var r irttOutput
+	if err = json.Unmarshal(o.Bytes(), &r); err != nil {
+		err = fmt.Errorf("invalid irtt output: %w", err)
+		return
+	}
+	c.emit(r, arg.rec)
+	return
+}
+
+// args returns the arguments for irtt client.
+func (c *IRTTClient) args() (args []string) {
+	args = []string{"client", "-Q", "-o", "-"}
+	if c.Duration > 0 {
+		args = append(args, "-d", c.Duration.Duration().String())
+	}
+	if c.Interval > 0 {
+		args = append(args, "-i", c.Interval.Duration().String())
+	}
+	if c.Length > 0 {
+		args = append(args, "-l", strconv.Itoa(c.Length))
+	}
+	args = append(args, c.Arg...)
+	args = append(args, c.Addr)
+	return
+}
+
+// emit sends the data points for irtt's output.
+func (c *IRTTClient) emit(out irttOutput, rec *recorder) {
+	t0 := metric.Tinit
+	rec.Send(PacketInfo{t0, c.Flow, false, c.Tags})
+	rec.Send(PacketInfo{t0, c.Flow, true, nil})
+	send := func(t *irttTime, flag PacketFlag, seq Seq, server, sent bool) {
+		if t == nil {
+			return
+		}
+		p := Packet{PacketHeader: PacketHeader{Flag: flag, Seq: seq,
+			Flow: c.Flow}, Len: c.Length}
+		r := metric.RelativeTime(time.Unix(0, t.Wall).Sub(t0))
+		var g Tags
+		if !server {
+			g = c.Tags
+		}
+		rec.Send(PacketIO{p, r, server, sent, g})
+	}
+	for _, t := range out.RoundTrips {
+		q := Seq(t.Seqno)
+		s := t.Timestamps.Server
+		if s.Midpoint != nil {
+			s.Receive, s.Send = s.Midpoint, s.Midpoint
+		}
+		send(t.Timestamps.Client.Send, FlagEcho, q, false, true)
+		send(s.Receive, FlagEcho, q, true, false)
+		send(s.Send, FlagReply, q, true, true)
+		send(t.Timestamps.Client.Receive, FlagReply, q, false, false)
+	}
+}
+
+// validate implements validater
+func (c *IRTTClient) validate() (err error) {
+	if c.Addr == "" {
+		err = fmt.Errorf("IRTTClient requires an Addr")
+		return
+	}
+	err = c.Flow.validate()
+	return
+}
@@ -70,8 +70,9 @@ func (n Node) String() string {
 
 // launchers is a union of the available launcher implementations.
 type launchers struct {
-	Local Local
-	SSH   SSH
+	Local     Local
+	SSH       SSH
+	Container Container
 }
 
 // launcher returns the launcher.
@@ -87,6 +88,13 @@ func (l *launchers) launcher() (ll launcher) {
 func (l *launchers) validate() (err error) {
 	if _, n := l.value(); n != 1 {
 		err = UnionError{l, n}
+		return
+	}
+	if l.SSH.Set {
+		err = l.SSH.validate()
+	}
+	if l.Container.Set {
+		err = l.Container.validate()
 	}
 	return
 }
@@ -101,6 +109,10 @@ func (l *launchers) value() (ll launcher, n int) {
 		ll = l.SSH
 		n++
 	}
+	if l.Container.Set {
+		ll = l.Container
+		n++
+	}
 	return
 }
 
@@ -122,6 +134,15 @@ func (n Netns) zero() bool {
 	return n == Netns{}
 }
 
+// netnsName returns the name of the Node's network namespace, or the empty
+// string if it runs in the default namespace.
+func (n Node) netnsName() string {
+	if n.Netns.Create && n.Netns.Name == "" {
+		return string(n.ID)
+	}
+	return n.Netns.Name
+}
+
 // EnvMax is the maximum number of allowed environment variables for a Node.
 // This must be kept in sync with the length restriction in config.cue.
 const EnvMax = 16
@@ -0,0 +1,139 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"fmt"
+	"os/exec"
+	"syscall"
+)
+
+// ContainerMax is the maximum number of elements in each of the list fields in
+// Container. This must be kept in sync with the length restrictions in
+// config.cue.
+const ContainerMax = 16
+
+// containerExe is the path the node executable is mounted at in the container.
+const containerExe = "/antler-node"
+
+// Container is a launcher used to start a node inside a container, using
+// Podman or Docker. The node executable is mounted into the container
+// read-only, so the image doesn't need antler installed, and the container is
+// removed when the node exits.
+type Container struct {
+	// Runtime is the container runtime command, e.g. podman or docker.
+	Runtime string
+
+	// Image is the container image to run.
+	Image string
+
+	// Network, if not empty, is the network to connect the container to,
+	// e.g. host, none, or the name of a network created with the runtime.
+	Network string
+
+	// Capability lists the Linux capabilities to add (e.g. NET_ADMIN). This
+	// field is an array so Node can remain a valid map key.
+	Capability [ContainerMax]string
+
+	// Volume lists the volumes to mount, in the runtime's --volume syntax
+	// (e.g. /host/dir:/container/dir:ro). This field is an array so Node can
+	// remain a valid map key.
+	Volume [ContainerMax]string
+
+	// Arg lists any additional arguments to the runtime's run command, before
+	// the image. This field is an array so Node can remain a valid map key.
+	Arg [ContainerMax]string
+
+	// Privileged, if true, runs the container in privileged mode.
+	Privileged bool
+
+	// Sudo, if true, runs the runtime with sudo.
+	Sudo bool
+
+	Set bool
+}
+
+// launch implements launcher
+func (c Container) launch(node Node, log logFunc) (tr transport, err error) {
+	if !node.Netns.zero() {
+		err = fmt.Errorf("Netns not supported with the Container launcher")
+		return
+	}
+	cl := newCloserStack(log)
+	defer func() {
+		if err != nil {
+			cl.Close()
+		}
+	}()
+	var f *exeFile
+	if f, err = repo.File(node.Platform); err != nil {
+		return
+	}
+	cl.Push(f)
+	a := c.args(node, f.Path)
+	x := exec.Command(a[0], a[1:]...)
+	x.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid: true,
+	}
+	log("%s", x)
+	var nc *nodeCmd
+	if nc, err = newNodeCmd(x, cl, log); err != nil {
+		return
+	}
+	if err = nc.Start(); err != nil {
+		return
+	}
+	tr = newGobTransport(nc)
+	return
+}
+
+// args returns the command and arguments to run the node in a container, with
+// the node executable at the given path.
+func (c Container) args(node Node, exe string) (a []string) {
+	if c.Sudo {
+		a = append(a, "sudo")
+	}
+	a = append(a, c.Runtime, "run", "--rm", "--interactive")
+	a = append(a, "--volume", exe+":"+containerExe+":ro")
+	if c.Network != "" {
+		a = append(a, "--network", c.Network)
+	}
+	for _, p := range c.Capability {
+		if p != "" {
+			a = append(a, "--cap-add", p)
+		}
+	}
+	for _, v := range c.Volume {
+		if v != "" {
+			a = append(a, "--volume", v)
+		}
+	}
+	for _, e := range node.Env.Vars {
+		if e != "" {
+			a = append(a, "--env", e)
+		}
+	}
+	if c.Privileged {
+		a = append(a, "--privileged")
+	}
+	for _, g := range c.Arg {
+		if g != "" {
+			a = append(a, g)
+		}
+	}
+	a = append(a, c.Image, containerExe, string(node.ID))
+	return
+}
+
+// validate returns an error if the Container fields are invalid.
+func (c Container) validate() (err error) {
+	if c.Runtime == "" {
+		err = fmt.Errorf("Container requires a Runtime")
+		return
+	}
+	if c.Image == "" {
+		err = fmt.Errorf("Container requires an Image")
+	}
+	return
+}
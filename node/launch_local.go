@@ -28,11 +28,8 @@ func (l Local) launch(node Node, log logFunc) (tr transport, err error) {
 		return
 	}
 	cl.Push(f)
-	ns := node.Netns.Name
+	ns := node.netnsName()
 	if node.Netns.Create {
-		if ns == "" {
-			ns = string(node.ID)
-		}
 		if err = addNetns(ns, log); err != nil {
 			return
 		}
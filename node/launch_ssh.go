@@ -12,6 +12,7 @@ import (
 	"io"
 	"os/exec"
 	"regexp"
+	"strconv"
 	"strings"
 	"syscall"
 )
@@ -30,7 +31,62 @@ type sshArgs struct {
 type SSH struct {
 	Destination string // ssh destination (man ssh(1))
 	Sudo        bool
-	Set         bool
+
+	// Port, if not zero, is the port to connect to on the remote host.
+	Port int
+
+	// ProxyJump, if not empty, lists one or more jump hosts (bastions),
+	// separated by commas, to connect through, as for ssh -J.
+	ProxyJump string
+
+	// ProxyCommand, if not empty, is the command used to connect to the
+	// remote host, as for the ssh_config(5) ProxyCommand option. It may not be
+	// used with ProxyJump.
+	ProxyCommand string
+
+	// IdentityFile, if not empty, is the file from which the identity
+	// (private key) is read, as for ssh -i.
+	IdentityFile string
+
+	// ConfigFile, if not empty, is an alternative per-user ssh_config(5)
+	// file, as for ssh -F, so testbed hosts, jump hosts and keys may be
+	// configured in one place.
+	ConfigFile string
+
+	Set bool
+}
+
+// validate returns an error if the SSH fields are invalid.
+func (s SSH) validate() (err error) {
+	if s.Port < 0 || s.Port > 65535 {
+		err = fmt.Errorf("invalid SSH Port: %d", s.Port)
+		return
+	}
+	if s.ProxyJump != "" && s.ProxyCommand != "" {
+		err = fmt.Errorf("SSH ProxyJump and ProxyCommand may not both be set")
+	}
+	return
+}
+
+// args returns the ssh options for the SSH fields, before the destination.
+func (s SSH) args() (a []string) {
+	a = append(a, "-o", "BatchMode yes")
+	if s.ConfigFile != "" {
+		a = append(a, "-F", s.ConfigFile)
+	}
+	if s.Port != 0 {
+		a = append(a, "-p", strconv.Itoa(s.Port))
+	}
+	if s.IdentityFile != "" {
+		a = append(a, "-i", s.IdentityFile)
+	}
+	if s.ProxyJump != "" {
+		a = append(a, "-J", s.ProxyJump)
+	}
+	if s.ProxyCommand != "" {
+		a = append(a, "-o", "ProxyCommand "+s.ProxyCommand)
+	}
+	return
 }
 
 // launch implements launcher
@@ -59,9 +115,7 @@ func (s SSH) launch(node Node, log logFunc) (tr transport, err error) {
 	if dest == "" {
 		dest = string(node.ID)
 	}
-	var a []string
-	a = append(a, "-o")
-	a = append(a, "BatchMode yes")
+	a := s.args()
 	a = append(a, dest)
 	if s.Sudo {
 		a = append(a, "sudo")
@@ -0,0 +1,189 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/heistp/antler/node/metric"
+	"golang.org/x/sys/unix"
+)
+
+// lockPoll is the interval at which a waiting node retries a held Lock.
+const lockPoll = time.Second
+
+// Lock configures a lock that each node, other than the root node, takes on
+// its host during setup, so that runs from different controllers can't
+// unknowingly run conflicting Tests on the same hosts. The nodes in a run share
+// the lock, so multiple nodes on one host (e.g. in network namespaces) don't
+// conflict with each other. The lock is held until the node exits, and is
+// released by the OS if the node dies.
+//
+// The lock is taken with flock(2) on the file at Path, which also records the
+// LockOwner. A second file, Path with ".guard" appended, serializes acquisition
+// of the lock. Both are created if they don't exist.
+type Lock struct {
+	// Path is the path to the lock file. If empty, no lock is taken.
+	Path string
+
+	// Wait, if true, waits for a lock held by another run to be released,
+	// instead of failing immediately.
+	Wait bool
+
+	// MaxWait, if not zero, is the maximum time to wait for the lock, after
+	// which setup fails.
+	MaxWait metric.Duration
+
+	// Owner identifies the run taking the lock. It's set by the controller.
+	Owner LockOwner
+}
+
+// LockOwner identifies the run that holds a Lock.
+type LockOwner struct {
+	// Run uniquely identifies the run.
+	Run string
+
+	// User is the user that started the run.
+	User string
+
+	// Host is the hostname of the controller.
+	Host string
+
+	// PID is the process ID of the controller.
+	PID int
+
+	// Test is the ID of the Test that took the lock.
+	Test string
+
+	// Start is the time the run started.
+	Start time.Time
+}
+
+func (o LockOwner) String() string {
+	return fmt.Sprintf("%s@%s (pid %d, test %s, run started %s)", o.User,
+		o.Host, o.PID, o.Test, o.Start.Format(time.DateTime))
+}
+
+// acquire takes the Lock, waiting for it if Wait is true, and returns a
+// canceler that releases it.
+func (l Lock) acquire(ctx context.Context, rec *recorder) (c canceler,
+	err error) {
+	var m <-chan time.Time
+	if l.MaxWait > 0 {
+		t := time.NewTimer(l.MaxWait.Duration())
+		defer t.Stop()
+		m = t.C
+	}
+	var w bool
+	for {
+		var f *os.File
+		var o LockOwner
+		if f, o, err = l.try(); err != nil || f != nil {
+			if f != nil {
+				c = cancelFunc(f.Close)
+			}
+			return
+		}
+		if !l.Wait {
+			err = fmt.Errorf("host is locked by %s", o)
+			return
+		}
+		if !w {
+			rec.Logf("waiting for host lock held by %s", o)
+			w = true
+		}
+		select {
+		case <-time.After(lockPoll):
+		case <-m:
+			err = fmt.Errorf("timed out waiting for host lock held by %s", o)
+			return
+		case <-ctx.Done():
+			err = context.Cause(ctx)
+			return
+		}
+	}
+}
+
+// try makes one attempt to take the Lock. If successful, the locked file is
+// returned. Otherwise, the file is nil and the LockOwner is returned.
+func (l Lock) try() (file *os.File, owner LockOwner, err error) {
+	var g *os.File
+	if g, err = l.open(l.Path + ".guard"); err != nil {
+		return
+	}
+	defer g.Close()
+	if err = unix.Flock(int(g.Fd()), unix.LOCK_EX); err != nil {
+		return
+	}
+	var f *os.File
+	if f, err = l.open(l.Path); err != nil {
+		return
+	}
+	defer func() {
+		if file == nil {
+			f.Close()
+		}
+	}()
+	if owner, err = l.read(f); err != nil {
+		return
+	}
+	if owner.Run != l.Owner.Run {
+		err = unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+		if errors.Is(err, unix.EWOULDBLOCK) {
+			err = nil
+			return
+		}
+		if err != nil {
+			return
+		}
+		if err = l.write(f); err != nil {
+			return
+		}
+	}
+	if err = unix.Flock(int(f.Fd()), unix.LOCK_SH); err != nil {
+		return
+	}
+	file = f
+	return
+}
+
+// open opens or creates the named lock file.
+func (l Lock) open(name string) (*os.File, error) {
+	return os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0o666)
+}
+
+// read reads the LockOwner from the lock file. If the file is empty or its
+// contents are invalid, the zero value is returned.
+func (l Lock) read(f *os.File) (owner LockOwner, err error) {
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	var b []byte
+	if b, err = io.ReadAll(f); err != nil {
+		return
+	}
+	if json.Unmarshal(b, &owner) != nil {
+		owner = LockOwner{}
+	}
+	return
+}
+
+// write replaces the contents of the lock file with the Lock's Owner.
+func (l Lock) write(f *os.File) (err error) {
+	var b []byte
+	if b, err = json.Marshal(l.Owner); err != nil {
+		return
+	}
+	if err = f.Truncate(0); err != nil {
+		return
+	}
+	_, err = f.WriteAt(append(b, '\n'), 0)
+	return
+}
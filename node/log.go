@@ -19,6 +19,7 @@ type LogEntry struct {
 	NodeID ID        // the ID of the node that created the entry
 	Tag    string    // tags the entry for categorization
 	Text   string    // the entry's text
+	Level  LogLevel  // the entry's severity
 }
 
 // init registers LogEntry with the gob encoder
@@ -46,6 +47,10 @@ func (l LogEntry) String() string {
 	if strings.Contains(t, "\n") {
 		t = "⏎\n" + t
 	}
+	if l.Level != LogInfo {
+		return fmt.Sprintf("%s %s %s [%s]: %s", l.Time.Format(logTimeFormat),
+			l.NodeID, l.Tag, l.Level, t)
+	}
 	return fmt.Sprintf("%s %s %s: %s", l.Time.Format(logTimeFormat),
 		l.NodeID, l.Tag, t)
 }
@@ -59,12 +64,12 @@ type LogFactory struct {
 // NewLogEntry returns a new LogEntry with the given message.
 func (f LogFactory) NewLogEntry(message string) LogEntry {
 	t := time.Now()
-	return LogEntry{t, f.nodeID, f.tag, message}
+	return LogEntry{t, f.nodeID, f.tag, message, LogInfo}
 }
 
 // NewLogEntryf returns a LogEntry with its Message formatted with printf style
 // args.
 func (f LogFactory) NewLogEntryf(format string, a ...any) LogEntry {
 	t := time.Now()
-	return LogEntry{t, f.nodeID, f.tag, fmt.Sprintf(format, a...)}
+	return LogEntry{t, f.nodeID, f.tag, fmt.Sprintf(format, a...), LogInfo}
 }
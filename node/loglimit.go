@@ -0,0 +1,187 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// LogLevel is the severity of a LogEntry.
+type LogLevel int8
+
+const (
+	LogDebug LogLevel = iota - 1
+	LogInfo
+	LogWarn
+)
+
+// String implements fmt.Stringer
+func (l LogLevel) String() string {
+	switch l {
+	case LogDebug:
+		return "debug"
+	case LogInfo:
+		return "info"
+	case LogWarn:
+		return "warn"
+	}
+	return fmt.Sprintf("LogLevel(%d)", int8(l))
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (l LogLevel) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (l *LogLevel) UnmarshalText(text []byte) (err error) {
+	switch string(text) {
+	case "debug":
+		*l = LogDebug
+	case "info":
+		*l = LogInfo
+	case "warn":
+		*l = LogWarn
+	default:
+		err = fmt.Errorf("unknown LogLevel: '%s'", text)
+	}
+	return
+}
+
+// LogLimit limits the LogEntry's sent by a node, so that a misbehaving runner
+// can't flood the connection to the parent node and stall result streaming.
+// Errors are never limited. Entries dropped by Rate or Max are counted, and
+// reported in a warning.
+type LogLimit struct {
+	// Level is the minimum level of entries that are sent.
+	Level LogLevel
+
+	// Rate is the maximum sustained rate of entries per second for each log
+	// tag (e.g. for each runner type). Zero means there is no rate limit.
+	Rate int
+
+	// Burst is the number of entries for each log tag that may be sent at once
+	// before Rate applies.
+	Burst int
+
+	// Max is the maximum number of entries sent by the node during a Test.
+	// Zero means there is no maximum.
+	Max int
+}
+
+// logLimiter applies a LogLimit to the LogEntry's sent by a node. The zero
+// value sends all entries at LogInfo and above. logLimiter is safe for
+// concurrent use.
+type logLimiter struct {
+	limit  LogLimit
+	bucket map[string]*logBucket
+	sent   int
+	capped int
+	mtx    sync.Mutex
+}
+
+// logBucket is a token bucket for the entries with one log tag.
+type logBucket struct {
+	tokens  float64
+	last    time.Time
+	dropped int
+}
+
+// set sets the LogLimit.
+func (m *logLimiter) set(limit LogLimit) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.limit = limit
+	m.bucket = nil
+}
+
+// admit returns the entries to send for the given entry, which may be empty if
+// the entry is dropped, or include a preceding warning for dropped entries.
+func (m *logLimiter) admit(entry LogEntry) (send []LogEntry) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if entry.Level < m.limit.Level {
+		return
+	}
+	if m.limit.Max > 0 && m.sent >= m.limit.Max {
+		if m.capped == 0 {
+			send = append(send, m.warning(entry,
+				"reached limit of %d log entries, dropping more",
+				m.limit.Max))
+		}
+		m.capped++
+		return
+	}
+	if m.limit.Rate > 0 {
+		b := m.take(entry)
+		if b.tokens < 1 {
+			b.dropped++
+			return
+		}
+		b.tokens--
+		if b.dropped > 0 {
+			send = append(send, m.warning(entry,
+				"dropped %d log entries over rate limit", b.dropped))
+			b.dropped = 0
+		}
+	}
+	m.sent++
+	send = append(send, entry)
+	return
+}
+
+// take returns the logBucket for the entry's tag, with tokens added for the
+// time elapsed since the last entry.
+func (m *logLimiter) take(entry LogEntry) (b *logBucket) {
+	if m.bucket == nil {
+		m.bucket = make(map[string]*logBucket)
+	}
+	t := time.Now()
+	n := float64(max(m.limit.Burst, 1))
+	var ok bool
+	if b, ok = m.bucket[entry.Tag]; !ok {
+		b = &logBucket{n, t, 0}
+		m.bucket[entry.Tag] = b
+		return
+	}
+	b.tokens = min(b.tokens+t.Sub(b.last).Seconds()*float64(m.limit.Rate),
+		n)
+	b.last = t
+	return
+}
+
+// flush returns warnings for any entries dropped since the last warnings were
+// sent, and resets the drop counters.
+func (m *logLimiter) flush(nodeID ID) (send []LogEntry) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	f := LogFactory{nodeID, "log"}
+	for t, b := range m.bucket {
+		if b.dropped > 0 {
+			e := f.NewLogEntryf("dropped %d log entries for tag %s over rate "+
+				"limit", b.dropped, t)
+			e.Level = LogWarn
+			send = append(send, e)
+			b.dropped = 0
+		}
+	}
+	if m.capped > 0 {
+		e := f.NewLogEntryf("dropped %d log entries over limit of %d",
+			m.capped, m.limit.Max)
+		e.Level = LogWarn
+		send = append(send, e)
+		m.capped = 0
+	}
+	return
+}
+
+// warning returns a LogWarn entry for the same node and tag as entry.
+func (m *logLimiter) warning(entry LogEntry, format string, a ...any) (
+	warn LogEntry) {
+	warn = LogEntry{time.Now(), entry.NodeID, entry.Tag,
+		fmt.Sprintf(format, a...), LogWarn}
+	return
+}
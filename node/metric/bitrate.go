@@ -5,6 +5,9 @@ package metric
 
 import (
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -115,3 +118,60 @@ func (b Bitrate) format(units map[string]string) string {
 		return trimFloat(b.Tbps(), 3) + units["T"]
 	}
 }
+
+// bitrateUnits maps unit prefixes to Bitrates, for ParseBitrate. Unit prefixes
+// are matched case-insensitively.
+var bitrateUnits = map[string]Bitrate{
+	"":  Bps,
+	"k": Kbps,
+	"m": Mbps,
+	"g": Gbps,
+	"t": Tbps,
+}
+
+// ParseBitrate parses a Bitrate from a string, consisting of a decimal number
+// followed by an optional unit, e.g. 100000, 1.5Mbps, 100Kbit or 10G. The unit
+// prefixes K, M, G and T are powers of 1000, and the optional unit suffix may
+// be bps, bit or bit/s. Units in bytes per second are not accepted.
+func ParseBitrate(s string) (b Bitrate, err error) {
+	var n string
+	var u string
+	if n, u, err = splitUnit(s); err != nil {
+		return
+	}
+	p := u
+	for _, x := range []string{"bit/s", "bps", "bit"} {
+		if strings.HasSuffix(u, x) {
+			p = strings.TrimSuffix(u, x)
+			break
+		}
+	}
+	m, ok := bitrateUnits[strings.ToLower(p)]
+	if !ok {
+		err = fmt.Errorf("unknown unit '%s' in bitrate '%s'", u, s)
+		return
+	}
+	if i, e := strconv.ParseInt(n, 10, 64); e == nil {
+		b = Bitrate(i) * m
+		return
+	}
+	var f float64
+	if f, err = strconv.ParseFloat(n, 64); err != nil {
+		err = fmt.Errorf("invalid bitrate '%s': %w", s, err)
+		return
+	}
+	b = Bitrate(math.Round(f * float64(m)))
+	return
+}
+
+// UnmarshalJSON implements json.Unmarshaler, accepting either a JSON number
+// of bits per second, or a string in the format accepted by ParseBitrate. This
+// is used when decoding from CUE.
+func (b *Bitrate) UnmarshalJSON(data []byte) (err error) {
+	var s string
+	if s, err = unquote(data); err != nil {
+		return
+	}
+	*b, err = ParseBitrate(s)
+	return
+}
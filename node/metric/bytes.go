@@ -3,7 +3,12 @@
 
 package metric
 
-import "fmt"
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
 
 // Bytes is a number of bytes.
 type Bytes uint64
@@ -80,3 +85,101 @@ func (b Bytes) Pebibytes() float64 {
 func (b Bytes) String() string {
 	return fmt.Sprintf("Bytes[%d]", b)
 }
+
+// byteUnits maps unit suffixes to Bytes, for ParseBytes. Unit prefixes are
+// matched case-insensitively.
+var byteUnits = map[string]Bytes{
+	"":    Byte,
+	"b":   Byte,
+	"k":   Kilobyte,
+	"kb":  Kilobyte,
+	"m":   Megabyte,
+	"mb":  Megabyte,
+	"g":   Gigabyte,
+	"gb":  Gigabyte,
+	"t":   Terabyte,
+	"tb":  Terabyte,
+	"p":   Petabyte,
+	"pb":  Petabyte,
+	"ki":  Kibibyte,
+	"kib": Kibibyte,
+	"mi":  Mebibyte,
+	"mib": Mebibyte,
+	"gi":  Gibibyte,
+	"gib": Gibibyte,
+	"ti":  Tebibyte,
+	"tib": Tebibyte,
+	"pi":  Pebibyte,
+	"pib": Pebibyte,
+}
+
+// ParseBytes parses a number of bytes from a string, consisting of a decimal
+// number followed by an optional unit, e.g. 1500, 250KB, 1.5MB or 4MiB. SI
+// units (kB, MB, GB, TB and PB) are powers of 1000, and IEC units (KiB, MiB,
+// GiB, TiB and PiB) are powers of 1024. The trailing B may be omitted.
+func ParseBytes(s string) (b Bytes, err error) {
+	var n string
+	var u string
+	if n, u, err = splitUnit(s); err != nil {
+		return
+	}
+	m, ok := byteUnits[strings.ToLower(u)]
+	if !ok {
+		err = fmt.Errorf("unknown unit '%s' in bytes '%s'", u, s)
+		return
+	}
+	if i, e := strconv.ParseUint(n, 10, 64); e == nil {
+		b = Bytes(i) * m
+		return
+	}
+	var f float64
+	if f, err = strconv.ParseFloat(n, 64); err != nil {
+		err = fmt.Errorf("invalid bytes '%s': %w", s, err)
+		return
+	}
+	b = Bytes(math.Round(f * float64(m)))
+	return
+}
+
+// UnmarshalJSON implements json.Unmarshaler, accepting either a JSON number
+// of bytes, or a string in the format accepted by ParseBytes. This is used
+// when decoding from CUE.
+func (b *Bytes) UnmarshalJSON(data []byte) (err error) {
+	var s string
+	if s, err = unquote(data); err != nil {
+		return
+	}
+	*b, err = ParseBytes(s)
+	return
+}
+
+// SI returns the Bytes formatted with SI (power of 1000) units, e.g. 1.5MB.
+func (b Bytes) SI() string {
+	return b.format(Kilobyte, "kB", "MB", "GB", "TB", "PB")
+}
+
+// IEC returns the Bytes formatted with IEC (power of 1024) units, e.g. 1.5MiB.
+func (b Bytes) IEC() string {
+	return b.format(Kibibyte, "KiB", "MiB", "GiB", "TiB", "PiB")
+}
+
+// format returns the Bytes formatted using the given base and unit names, with
+// at most three digits after the decimal point, and trailing zeroes trimmed.
+func (b Bytes) format(base Bytes, unit ...string) string {
+	if b < base {
+		return fmt.Sprintf("%dB", b)
+	}
+	f := float64(b) / float64(base)
+	i := 0
+	for ; i < len(unit)-1 && f >= float64(base); i++ {
+		f /= float64(base)
+	}
+	switch {
+	case f < 10:
+		return trimFloat(f, 3) + unit[i]
+	case f < 100:
+		return trimFloat(f, 2) + unit[i]
+	default:
+		return trimFloat(f, 1) + unit[i]
+	}
+}
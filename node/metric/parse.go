@@ -0,0 +1,40 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package metric
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// splitUnit splits a string into a non-negative decimal number and the unit
+// that follows it, ignoring surrounding whitespace and any whitespace between
+// the number and unit.
+func splitUnit(s string) (num, unit string, err error) {
+	t := strings.TrimSpace(s)
+	i := strings.IndexFunc(t, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		i = len(t)
+	}
+	num = t[:i]
+	unit = strings.TrimSpace(t[i:])
+	if num == "" || num == "." {
+		err = fmt.Errorf("'%s' does not start with a non-negative number", s)
+	}
+	return
+}
+
+// unquote returns the string value of JSON data that is either a string, or
+// a number, which is returned as is.
+func unquote(data []byte) (s string, err error) {
+	if len(data) > 0 && data[0] == '"' {
+		err = json.Unmarshal(data, &s)
+		return
+	}
+	s = string(data)
+	return
+}
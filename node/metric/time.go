@@ -5,12 +5,52 @@ package metric
 
 import (
 	"fmt"
+	"sync/atomic"
 	"time"
 )
 
+// Clock is a source of time for RelativeTime values.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+}
+
+// SystemClock is the default Clock, which uses time.Now, and so the monotonic
+// clock for elapsed times.
+type SystemClock struct{}
+
+// Now implements Clock
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
 // Tinit is the base time used for RelativeTime values.
 var Tinit = time.Now()
 
+// clockHolder holds the current Clock.
+type clockHolder struct {
+	Clock
+}
+
+// clock is the current Clock.
+var clock atomic.Pointer[clockHolder]
+
+// init sets the initial Clock to SystemClock.
+func init() {
+	clock.Store(&clockHolder{SystemClock{}})
+}
+
+// SetClock sets the Clock used for RelativeTime values. The Clock is global to
+// the process, so it applies to all measurements taken in it. SetClock is safe
+// for concurrent use, and doesn't change Tinit, so RelativeTime values remain
+// relative to Tinit, and a RelativeTime added to Tinit gives the time by the
+// Clock in use when it was taken. Values taken before and after a change of
+// Clock may differ by the offset between the Clocks, so SetClock should be
+// called before any measurements are taken.
+func SetClock(c Clock) {
+	clock.Store(&clockHolder{c})
+}
+
 // RelativeTime represents a time relative to a base time.
 type RelativeTime time.Duration
 
@@ -24,12 +64,13 @@ func (r RelativeTime) Duration() time.Duration {
 	return time.Duration(r)
 }
 
-// Now returns the current RelativeTime, with Tinit as a base.
+// Now returns the current RelativeTime from the Clock, with Tinit as a base.
 func Now() RelativeTime {
-	return RelativeTime(time.Since(Tinit))
+	return RelativeTime(clock.Load().Now().Sub(Tinit))
 }
 
-// Relative returns the RelativeTime for t, with Tinit as a base.
+// Relative returns the RelativeTime for t, with Tinit as a base. The time must
+// be from the current Clock.
 func Relative(t time.Time) RelativeTime {
 	return RelativeTime(t.Sub(Tinit))
 }
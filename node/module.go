@@ -0,0 +1,125 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// KernelModule is a runner that ensures the kernel modules needed by a Test
+// (e.g. sch_cake, tcp_bbr or sch_netem) are loaded, using modprobe. If Unload
+// is true, the modules that KernelModule loaded are unloaded when the node's
+// runners are canceled at the end of the Test, so the system is left as it was.
+//
+// KernelModule should be placed before the runners that need the modules, and
+// if Unload is true, normally first on the node, so the modules are unloaded
+// after any qdiscs or sockets that use them are gone.
+type KernelModule struct {
+	// Module lists the names of the modules to load.
+	Module []string
+
+	// Unload, if true, unloads the modules that were loaded by KernelModule
+	// at the end of the Test. Modules that were already loaded or are built in
+	// are left alone.
+	Unload bool
+
+	loaded []string
+	rec    *recorder
+}
+
+// Run implements runner
+func (k *KernelModule) Run(ctx context.Context, arg runArg) (ofb Feedback,
+	err error) {
+	k.rec = arg.rec
+	for _, m := range k.Module {
+		if moduleLoaded(m, false) {
+			continue
+		}
+		if err = modprobe(ctx, m); err != nil {
+			break
+		}
+		if moduleLoaded(m, true) {
+			k.loaded = append(k.loaded, m)
+			arg.rec.Logf("loaded kernel module %s", m)
+		}
+	}
+	if k.Unload {
+		if err != nil {
+			k.Cancel()
+			return
+		}
+		arg.cxl <- k
+	}
+	return
+}
+
+// Cancel implements canceler
+func (k *KernelModule) Cancel() error {
+	for i := len(k.loaded) - 1; i >= 0; i-- {
+		m := k.loaded[i]
+		if e := modprobe(context.Background(), "-r", m); e != nil {
+			k.rec.Logf("%s", e)
+			continue
+		}
+		k.rec.Logf("unloaded kernel module %s", m)
+	}
+	k.loaded = nil
+	return nil
+}
+
+// validate implements validater
+func (k *KernelModule) validate() (err error) {
+	if len(k.Module) == 0 {
+		err = fmt.Errorf("KernelModule requires at least one Module")
+		return
+	}
+	for _, m := range k.Module {
+		if m == "" || strings.HasPrefix(m, "-") {
+			err = fmt.Errorf("invalid KernelModule Module: '%s'", m)
+			return
+		}
+	}
+	return
+}
+
+// modprobe runs modprobe with the given arguments, the last of which is the
+// module name. The returned error names the module, and includes modprobe's
+// output.
+func modprobe(ctx context.Context, arg ...string) (err error) {
+	m := arg[len(arg)-1]
+	var p string
+	if p, err = exec.LookPath("modprobe"); err != nil {
+		err = fmt.Errorf("unable to load kernel module %s: %w", m, err)
+		return
+	}
+	var o []byte
+	c := exec.CommandContext(ctx, p, arg...)
+	if o, err = c.CombinedOutput(); err != nil {
+		a := "load"
+		if len(arg) > 1 {
+			a = "unload"
+		}
+		err = fmt.Errorf("unable to %s kernel module %s: %w (%s)", a, m, err,
+			bytes.TrimSpace(o))
+	}
+	return
+}
+
+// moduleLoaded returns true if the given kernel module is loaded, according to
+// sysfs. If loadable is true, built in modules, which may also be in sysfs,
+// aren't considered loaded.
+func moduleLoaded(name string, loadable bool) bool {
+	p := filepath.Join("/sys/module", strings.ReplaceAll(name, "-", "_"))
+	if loadable {
+		p = filepath.Join(p, "initstate")
+	}
+	_, err := os.Stat(p)
+	return err == nil
+}
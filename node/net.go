@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"runtime"
 	"syscall"
 
 	"golang.org/x/sys/unix"
@@ -86,16 +87,65 @@ type Sockopts struct {
 func (s Sockopts) sockopt() (opt []Sockopt) {
 	if s.CCA != "" {
 		opt = append(opt, Sockopt{"string", unix.IPPROTO_TCP,
-			unix.TCP_CONGESTION, "CCA", s.CCA})
+			tcpCongestion, "CCA", s.CCA})
 	}
 	if s.DSCP != 0 || s.ECN != 0 {
-		t := int((s.DSCP << 2) | (s.ECN & 0x3))
+		t := int(tos(s.DSCP, s.ECN))
 		opt = append(opt, Sockopt{"int", unix.IPPROTO_IP, unix.IP_TOS, "ToS", t})
 	}
 	opt = append(opt, s.Sockopt...)
 	return
 }
 
+// empty returns true if no socket options are set.
+func (s Sockopts) empty() bool {
+	return s.CCA == "" && s.DSCP == 0 && s.ECN == 0 && len(s.Sockopt) == 0
+}
+
+// setTCP sets the socket options on the given conn, which must be a TCPConn
+// if any options are set.
+func (s Sockopts) setTCP(conn net.Conn) (err error) {
+	if s.empty() {
+		return
+	}
+	if s.CCA != "" && tcpCongestion < 0 {
+		err = fmt.Errorf("CCA can't be set per socket on %s", runtime.GOOS)
+		return
+	}
+	t, ok := conn.(*net.TCPConn)
+	if !ok {
+		err = fmt.Errorf("not a TCPConn for setting Sockopts: %T", conn)
+		return
+	}
+	for _, o := range s.sockopt() {
+		if err = o.setTCP(t); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// validate returns an error if the fixed fields contradict the generic
+// Sockopts, e.g. if CCA is set along with a TCP_CONGESTION Sockopt.
+func (s Sockopts) validate() (err error) {
+	for _, o := range s.Sockopt {
+		switch {
+		case s.CCA != "" && o.Level == unix.IPPROTO_TCP &&
+			o.Opt == tcpCongestion:
+			err = fmt.Errorf("CCA %s and Sockopt %s both set the CCA",
+				s.CCA, o.Name)
+		case (s.DSCP != 0 || s.ECN != 0) && o.Level == unix.IPPROTO_IP &&
+			o.Opt == unix.IP_TOS:
+			err = fmt.Errorf("DSCP/ECN and Sockopt %s both set the ToS byte",
+				o.Name)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
 // dialControl is the Dialer.Control function and dialController implementation.
 func (s Sockopts) dialControl(network, address string,
 	conn syscall.RawConn) (err error) {
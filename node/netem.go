@@ -0,0 +1,198 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/heistp/antler/node/metric"
+	"github.com/kballard/go-shellquote"
+)
+
+// Netem is a runner that configures the root qdisc of a network interface
+// declaratively, using tc, with netem for delay, jitter, loss and rate, and/or
+// an AQM qdisc. The root qdisc is deleted when the node's runners are canceled
+// at the end of the Test, including when the Test fails or is interrupted,
+// which restores the interface's default qdisc. It's an alternative to running
+// tc with System, which requires matching commands to remove the qdiscs.
+//
+// If both netem parameters and an AQM are given, netem is added as the root
+// qdisc with handle 1:, and the AQM is added as its child, with handle 10:.
+// Packets are then delayed by netem before they're enqueued to the AQM, so a
+// bottleneck rate is normally set in the AQM's parameters (e.g. cake's
+// bandwidth), rather than with Rate. If only an AQM is given, it's added as
+// the root qdisc, with handle 1:.
+type Netem struct {
+	// Interface is the network interface name (e.g. "eth0").
+	Interface string
+
+	// Delay is the delay added to each packet.
+	Delay metric.Duration
+
+	// Jitter, if not zero, is the variation in the delay.
+	Jitter metric.Duration
+
+	// Distribution, if not empty, is the delay distribution used with Jitter
+	// (uniform, normal, pareto or paretonormal).
+	Distribution string
+
+	// Loss is the percentage of packets dropped at random, from 0 to 100.
+	Loss float64
+
+	// ECN, if true, marks ECN capable packets with CE instead of dropping them
+	// for Loss.
+	ECN bool
+
+	// Rate, if not zero, limits the rate that netem sends at.
+	Rate metric.Bitrate
+
+	// Limit, if not zero, is netem's queue limit, in packets.
+	Limit int
+
+	// AQM, if not empty, is a qdisc and its parameters, in tc syntax (e.g.
+	// "fq_codel" or "cake bandwidth 50Mbit").
+	AQM string
+
+	added bool
+	rec   *recorder
+}
+
+// Run implements runner
+func (n *Netem) Run(ctx context.Context, arg runArg) (ofb Feedback,
+	err error) {
+	n.rec = arg.rec
+	var cc [][]string
+	if cc, err = n.commands(); err != nil {
+		return
+	}
+	for _, c := range cc {
+		if err = n.tc(ctx, c...); err != nil {
+			break
+		}
+		n.added = true
+	}
+	if err != nil {
+		n.Cancel()
+		return
+	}
+	arg.cxl <- n
+	return
+}
+
+// Cancel implements canceler
+func (n *Netem) Cancel() (err error) {
+	if !n.added {
+		return
+	}
+	n.added = false
+	err = n.tc(context.Background(), "qdisc", "del", "dev", n.Interface,
+		"root")
+	return
+}
+
+// tc runs tc with the given arguments. The returned error includes tc's
+// output.
+func (n *Netem) tc(ctx context.Context, arg ...string) (err error) {
+	c := exec.CommandContext(ctx, "tc", arg...)
+	n.rec.Logf("%s", c)
+	var o []byte
+	if o, err = c.CombinedOutput(); err != nil {
+		err = fmt.Errorf("%w (%s: %s)", err, c, bytes.TrimSpace(o))
+	}
+	return
+}
+
+// commands returns the arguments to tc to add the qdiscs.
+func (n *Netem) commands() (cmd [][]string, err error) {
+	var q []string
+	if n.AQM != "" {
+		if q, err = shellquote.Split(n.AQM); err != nil {
+			err = fmt.Errorf("invalid Netem AQM '%s': %w", n.AQM, err)
+			return
+		}
+	}
+	r := []string{"qdisc", "replace", "dev", n.Interface, "root", "handle",
+		"1:"}
+	p := n.params()
+	if len(p) == 0 {
+		cmd = append(cmd, append(r, q...))
+		return
+	}
+	cmd = append(cmd, append(append(r, "netem"), p...))
+	if len(q) > 0 {
+		c := []string{"qdisc", "add", "dev", n.Interface, "parent", "1:1",
+			"handle", "10:"}
+		cmd = append(cmd, append(c, q...))
+	}
+	return
+}
+
+// params returns the netem parameters, or nil if netem isn't needed.
+func (n *Netem) params() (p []string) {
+	us := func(d metric.Duration) string {
+		return strconv.FormatInt(d.Duration().Microseconds(), 10) + "us"
+	}
+	if n.Delay > 0 || n.Jitter > 0 {
+		p = append(p, "delay", us(n.Delay))
+		if n.Jitter > 0 {
+			p = append(p, us(n.Jitter))
+			if n.Distribution != "" {
+				p = append(p, "distribution", n.Distribution)
+			}
+		}
+	}
+	if n.Loss > 0 {
+		p = append(p, "loss", "random",
+			strconv.FormatFloat(n.Loss, 'f', -1, 64)+"%")
+	}
+	if n.ECN {
+		p = append(p, "ecn")
+	}
+	if n.Rate > 0 {
+		p = append(p, "rate", n.Rate.Qdisc())
+	}
+	if n.Limit > 0 {
+		p = append(p, "limit", strconv.Itoa(n.Limit))
+	}
+	return
+}
+
+// validate implements validater
+func (n *Netem) validate() (err error) {
+	if n.Interface == "" {
+		err = fmt.Errorf("Netem requires an Interface")
+		return
+	}
+	if n.AQM == "" && len(n.params()) == 0 {
+		err = fmt.Errorf("Netem on %s requires netem parameters or an AQM",
+			n.Interface)
+		return
+	}
+	if n.Delay < 0 || n.Jitter < 0 || n.Rate < 0 || n.Limit < 0 {
+		err = fmt.Errorf("Netem Delay, Jitter, Rate and Limit may not be " +
+			"negative")
+		return
+	}
+	if n.Loss < 0 || n.Loss > 100 {
+		err = fmt.Errorf("Netem Loss must be from 0 to 100: %f", n.Loss)
+		return
+	}
+	if n.Distribution != "" && n.Jitter == 0 {
+		err = fmt.Errorf("Netem Distribution requires Jitter")
+		return
+	}
+	if n.ECN && n.Loss == 0 {
+		err = fmt.Errorf("Netem ECN requires Loss")
+		return
+	}
+	if strings.HasPrefix(n.AQM, "netem") {
+		err = fmt.Errorf("Netem AQM may not be netem, use the netem fields")
+	}
+	return
+}
@@ -7,6 +7,7 @@ package node
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -49,7 +50,14 @@ type node struct {
 	contextDone bool  // true after context is done
 	runsDone    bool  // true after runs goroutine is done
 	parentDone  bool  // true after parent conn is done
+	failed      bool  // true if this node or its parent failed
 	err         error // first error, returned from Serve()
+
+	// set by setup, and read by handleRuns after runs are done
+	diagnose Diagnose
+
+	// set by run, and called to abandon FileData sends if the node fails
+	cancelFiles context.CancelCauseFunc
 }
 
 // newNode returns a new node.
@@ -68,7 +76,10 @@ func newNode(nodeID ID, parent transport) *node {
 		false,                          // contextDone
 		false,                          // runsDone
 		false,                          // parentDone
+		false,                          // failed
 		nil,                            // err
+		Diagnose{},                     // diagnose
+		nil,                            // cancelFiles
 	}
 }
 
@@ -83,16 +94,57 @@ func Serve(ctx context.Context, nodeID ID, conn io.ReadWriteCloser) error {
 	return n.err
 }
 
+// errNodeFailed is the cause used to abandon FileData sends after the node
+// fails.
+var errNodeFailed = errors.New("node failed")
+
 // RootNodeID is the ID used for the root node in node.Do.
 const RootNodeID = "antler"
 
-// Do runs a Run tree in an in-process "root" node, and sends data items back on
-// the given data channel. The item types that may be sent include StreamInfo,
-// StreamIO, TCPInfo, PacketInfo, PacketIO, FileData, SysInfoData, LogEntry and
-// Error.
+// NodeOptions are the options applied on each node in the tree during setup.
+// The zero value applies no limits and the default Tuning.
+type NodeOptions struct {
+	// Log limits the LogEntry's sent by each node.
+	Log LogLimit
+
+	// Overflow limits each node's send queue.
+	Overflow Overflow
+
+	// Tuning is applied on each node.
+	Tuning Tuning
+
+	// Diagnose lists the commands each node runs if the Run fails.
+	Diagnose Diagnose
+
+	// Lock, if its Path is not empty, is taken by each node on its host.
+	Lock Lock
+}
+
+// Options contains the options for Do. The zero value is valid.
+type Options struct {
+	NodeOptions
+
+	// Feedback is passed to the Run as incoming Feedback.
+	Feedback Feedback
+
+	// OnFeedback, if not nil, is called with the Run's outgoing Feedback if it
+	// succeeds.
+	OnFeedback func(Feedback)
+
+	// Hold, if not nil, is called after the Run completes, successfully or
+	// not, with the NodeProcess for each node in the tree, and the nodes are
+	// kept running until it returns. This is used for debugging.
+	Hold func([]NodeProcess)
+}
+
+// Do runs a Run tree in an in-process "root" node, with the given Options, and
+// sends data items back on the given data channel. The item types that may be
+// sent include StreamInfo, StreamIO, TCPInfo, PacketInfo, PacketIO, FileData,
+// SysInfoData, RunnerStart, QueueOverflow, LogEntry and Error.
 //
 // Do is used by the antler package and executable.
-func Do(ctx context.Context, rn *Run, src ExeSource, data chan<- any) {
+func Do(ctx context.Context, rn *Run, src ExeSource, opt Options,
+	data chan<- any) {
 	defer close(data)
 	f := ErrorFactory{RootNodeID, "do"}
 	var err error
@@ -111,6 +163,8 @@ func Do(ctx context.Context, rn *Run, src ExeSource, data chan<- any) {
 	// root conn
 	ev := make(chan event)
 	var w sync.WaitGroup
+	var pp []NodeProcess
+	var pm sync.Mutex
 	w.Add(1)
 	go func() {
 		defer w.Done()
@@ -120,6 +174,12 @@ func Do(ctx context.Context, rn *Run, src ExeSource, data chan<- any) {
 				return
 			case errorEvent:
 				data <- f.NewErrore(v.err)
+			case NodeProcess:
+				pm.Lock()
+				pp = append(pp, v)
+				pm.Unlock()
+			case customData:
+				data <- v.Value
 			default:
 				data <- v
 			}
@@ -129,7 +189,7 @@ func Do(ctx context.Context, rn *Run, src ExeSource, data chan<- any) {
 	c := newConn(tr, ParentNode)
 	c.start(ev)
 	defer func() {
-		c.Cancel()
+		c.Cancel(false)
 		w.Wait()
 	}()
 	// root node
@@ -137,21 +197,36 @@ func Do(ctx context.Context, rn *Run, src ExeSource, data chan<- any) {
 	go n.run(ctx)
 	// setup and run
 	rc := make(chan ran, 1)
-	c.Run(&Run{Runners: Runners{Setup: &setup{0, t, x}}}, Feedback{}, rc)
+	s := &setup{0, t, x, opt.NodeOptions, opt.Hold != nil}
+	c.Run(&Run{Runners: Runners{Setup: s}}, Feedback{}, rc)
 	r := <-rc
 	if !r.OK {
 		return
 	}
-	c.Run(rn, r.Feedback, rc)
-	if k := (<-rc).Feedback; len(k) > 0 {
+	c.Run(rn, r.Feedback.with(opt.Feedback), rc)
+	r = <-rc
+	if k := r.Feedback; len(k) > 0 {
 		data <- LogEntry{time.Now(), RootNodeID, "feedback",
-			fmt.Sprintf("feedback: %s", k)}
+			fmt.Sprintf("feedback: %s", k), LogInfo}
+	}
+	if r.OK && opt.OnFeedback != nil {
+		opt.OnFeedback(r.Feedback)
+	}
+	if opt.Hold != nil {
+		pm.Lock()
+		p := t.processes(pp)
+		pm.Unlock()
+		opt.Hold(p)
 	}
 	return
 }
 
 // run runs the node by handling node events and advancing the state.
 func (n *node) run(ctx context.Context) {
+	f, fx := context.WithCancelCause(ctx)
+	defer fx(nil)
+	n.rec.file.setContext(f)
+	n.cancelFiles = fx
 	ctx, x := context.WithCancelCause(ctx)
 	defer x(nil)
 	n.parent.start(n.ev)
@@ -194,11 +269,16 @@ func (n *node) advance(cxl context.CancelCauseFunc) bool {
 		n.state++
 		switch n.state {
 		case stateCancel:
+			n.failed = n.failed || n.err != nil
 			cxl(n.err)
+			if n.failed {
+				n.cancelFiles(errNodeFailed)
+			}
 			close(n.runc)
 			n.sockdiag.Stop()
-			n.child.Cancel()
+			n.child.Cancel(n.failed)
 		case stateCanceled:
+			n.rec.FlushLog()
 			n.parent.Canceled()
 		case stateDone:
 			return false
@@ -215,7 +295,7 @@ func (n *node) waitContext(ctx context.Context) {
 }
 
 // handleRuns receives and handles Runs from the runc channel until it's closed,
-// then cancels the cancelers.
+// then runs diagnostics if the node failed, and cancels the cancelers.
 func (n *node) handleRuns(ctx context.Context) {
 	defer func() {
 		n.ev <- runsDone{}
@@ -228,6 +308,9 @@ func (n *node) handleRuns(ctx context.Context) {
 	var wg sync.WaitGroup
 	defer func() {
 		wg.Wait()
+		if n.failed {
+			n.diagnose.run(n.rec)
+		}
 	}()
 	for r := range n.runc {
 		r := r
@@ -248,7 +331,8 @@ func (n *node) handleRuns(ctx context.Context) {
 				n.parent.Send(ran{r.ID, f, ok, r.to})
 			}()
 			f, ok = r.Run.run(ctx,
-				runArg{n.child, r.Feedback, n.sockdiag, n.rec, c}, n.ev)
+				runArg{n.child, r.Feedback, n.sockdiag, n.rec, c,
+					&n.diagnose}, n.ev)
 		}()
 	}
 }
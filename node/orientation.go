@@ -18,3 +18,19 @@ const (
 	Up   Direction = "up"   // client to server
 	Down           = "down" // server to client
 )
+
+// Sender returns the Location of the sender for the Direction.
+func (d Direction) Sender() Location {
+	if d == Down {
+		return Server
+	}
+	return Client
+}
+
+// Receiver returns the Location of the receiver for the Direction.
+func (d Direction) Receiver() Location {
+	if d == Down {
+		return Client
+	}
+	return Server
+}
@@ -0,0 +1,177 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"encoding/gob"
+	"fmt"
+	"time"
+)
+
+// OverflowPolicy selects what a node does when its send queue is full.
+type OverflowPolicy string
+
+const (
+	// OverflowBlock blocks the node's runners until the queue has room.
+	OverflowBlock OverflowPolicy = "block"
+
+	// OverflowDropOldest drops the oldest data item in the queue to make
+	// room for each new message.
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
+
+	// OverflowFail fails the Test, and drops the oldest data items
+	// thereafter.
+	OverflowFail OverflowPolicy = "fail"
+)
+
+// Overflow limits the number of messages a node queues for sending to its
+// parent, so that if the antler-side pipeline stalls, memory use on the nodes
+// is bounded and the stall is made visible. Only messages that are sent
+// promptly (streamed or pushed) are queued, while messages buffered until the
+// end of the Test are not subject to the limit.
+type Overflow struct {
+	// Queue is the maximum number of messages queued for sending to the
+	// parent. Zero means there is no limit.
+	Queue int
+
+	// Policy selects what happens when the queue is full. If empty, the
+	// policy is block.
+	Policy OverflowPolicy
+}
+
+// blocks returns true if the Policy is block.
+func (o Overflow) blocks() bool {
+	return o.Policy == OverflowBlock || o.Policy == ""
+}
+
+// QueueOverflow is a data item sent by a node at the end of a Test, if its
+// send queue overflowed.
+type QueueOverflow struct {
+	// NodeID is the ID of the node whose queue overflowed.
+	NodeID ID
+
+	// Policy is the OverflowPolicy that was applied.
+	Policy OverflowPolicy
+
+	// Queue is the queue length limit.
+	Queue int
+
+	// Count is the number of data items dropped, for drop-oldest and fail,
+	// or the number of times the node blocked, for block.
+	Count int
+
+	// First is the time of the first overflow, per the node's clock.
+	First time.Time
+}
+
+// init registers QueueOverflow with the gob encoder
+func init() {
+	gob.Register(QueueOverflow{})
+}
+
+// flags implements message
+func (QueueOverflow) flags() flag {
+	return flagForward
+}
+
+// handle implements event
+func (q QueueOverflow) handle(node *node) {
+	node.parent.Send(q)
+}
+
+func (q QueueOverflow) String() string {
+	return fmt.Sprintf("QueueOverflow[NodeID:%s Policy:%s Queue:%d Count:%d]",
+		q.NodeID, q.Policy, q.Queue, q.Count)
+}
+
+// queueLimit applies an Overflow to a conn's send queue. It's used only by
+// the conn's buffer goroutine, which sends any fail event to the node.
+type queueLimit struct {
+	Overflow
+	node    ID
+	count   int
+	first   time.Time
+	blocked bool
+	fail    event
+}
+
+// full returns true if a queue of length n is full.
+func (q *queueLimit) full(n int) bool {
+	return q.Queue > 0 && n >= q.Queue
+}
+
+// block returns block true if the buffer goroutine should stop receiving
+// messages, because the queue is full and the Policy is block, along with the
+// queue, which may have a warning added.
+func (q *queueLimit) block(queue []message) (out []message, block bool) {
+	out = queue
+	if !q.blocks() || !q.full(len(queue)) {
+		q.blocked = false
+		return
+	}
+	if !q.blocked {
+		q.blocked = true
+		out = q.overflowed(queue)
+	}
+	block = true
+	return
+}
+
+// admit appends the message to the queue, first dropping the oldest data item
+// if the queue is full and the Policy drops messages.
+func (q *queueLimit) admit(queue []message, m message) []message {
+	if q.blocks() || !q.full(len(queue)) || !droppable(m) {
+		return append(queue, m)
+	}
+	queue = q.overflowed(queue)
+	for i, d := range queue {
+		if droppable(d) {
+			queue = append(queue[:i], queue[i+1:]...)
+			return append(queue, m)
+		}
+	}
+	return queue
+}
+
+// overflowed records an overflow. On the first overflow, a warning is added to
+// the queue, or for the fail Policy, fail is set to an error event to fail the
+// Test.
+func (q *queueLimit) overflowed(queue []message) []message {
+	q.count++
+	if q.count > 1 {
+		return queue
+	}
+	q.first = time.Now()
+	e := fmt.Errorf("send queue overflowed its limit of %d (policy %s)",
+		q.Queue, q.Policy)
+	if q.Policy == OverflowFail {
+		q.fail = errorEvent{e, false}
+		return queue
+	}
+	return append(queue, LogEntry{q.first, q.node, "overflow", e.Error(),
+		LogWarn})
+}
+
+// summary returns a QueueOverflow if the queue overflowed, or nil otherwise.
+func (q *queueLimit) summary() message {
+	if q.count == 0 {
+		return nil
+	}
+	return QueueOverflow{q.node, q.Policy, q.Queue, q.count, q.first}
+}
+
+// droppable returns true if the message is a data item that may be dropped
+// from the send queue. Pushed messages (including Errors), final messages,
+// LogEntry's (which are limited by the LogLimit) and FileData (which must
+// arrive complete) are never dropped.
+func droppable(m message) bool {
+	if m.flags()&(flagPush|flagFinal) != 0 {
+		return false
+	}
+	switch m.(type) {
+	case LogEntry, FileData:
+		return false
+	}
+	return true
+}
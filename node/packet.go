@@ -21,10 +21,6 @@ import (
 	"github.com/heistp/antler/node/metric"
 )
 
-// maxFlowID is the maximum length of a flow ID.  This is kept short as flow IDs
-// are used in test packets and to record data points.
-const maxFlowID = 16
-
 // Seq is a packet sequence number.
 type Seq uint64
 
@@ -67,6 +63,14 @@ type Packet struct {
 	// Len is the total length of the packet, in bytes, including the header.
 	Len int
 
+	// TOS is the ToS byte (IPv4) or Traffic Class (IPv6) the packet was sent
+	// or received with, if HasTOS is true.
+	TOS byte
+
+	// HasTOS is true if TOS is known. For received packets, this requires
+	// support from the platform for reading the ToS byte or Traffic Class.
+	HasTOS bool
+
 	// addr is the address the packet is from or to.
 	addr net.Addr
 
@@ -103,8 +107,9 @@ func (p *PacketHeader) Write(b []byte) (n int, err error) {
 	}
 	i := 0
 	if !bytes.Equal(b[i:i+len(packetMagic)], packetMagic) {
-		err = fmt.Errorf("invalid packet magic:%x flow:%d seq:%d",
+		err = fmt.Errorf("invalid packet magic:%x flow:%s seq:%d",
 			b[i:i+len(packetMagic)], p.Flow, p.Seq)
+		return
 	}
 	i += len(packetMagic)
 	p.Flag = PacketFlag(b[i])
@@ -115,15 +120,24 @@ func (p *PacketHeader) Write(b []byte) (n int, err error) {
 	i += 2
 	l := int(b[i])
 	i++
+	if l > maxFlowID || i+l > len(b) {
+		err = fmt.Errorf("invalid flow ID length %d in packet seq:%d", l, p.Seq)
+		return
+	}
 	p.Flow = Flow(string(b[i : i+l]))
 	i += l
 	if p.hmac != nil {
+		if i+p.hmac.Size() > len(b) {
+			err = fmt.Errorf("packet too short for HMAC flow:%s seq:%d",
+				p.Flow, p.Seq)
+			return
+		}
 		p.hmac.Reset()
 		p.hmac.Write(b[:i])
 		h := b[i : i+p.hmac.Size()]
 		x := p.hmac.Sum(nil)
 		if !hmac.Equal(h, x) {
-			err = fmt.Errorf("invalid HMAC:%x flow:%d seq:%d", h, p.Flow, p.Seq)
+			err = fmt.Errorf("invalid HMAC:%x flow:%s seq:%d", h, p.Flow, p.Seq)
 		}
 	}
 	n = p.Len()
@@ -137,7 +151,7 @@ func (p *PacketHeader) Read(b []byte) (n int, err error) {
 		return
 	}
 	if len(p.Flow) > maxFlowID {
-		err = fmt.Errorf("flow ID %s > 16 characters", p.Flow)
+		err = FlowLengthError{p.Flow}
 		return
 	}
 	i := 0
@@ -172,7 +186,10 @@ func (p *PacketHeader) Len() int {
 	return l
 }
 
-// PacketServer is the server used for packet oriented protocols.
+// PacketServer is the server used for packet oriented protocols. Where the
+// platform supports it, the server records the ToS byte or Traffic Class that
+// each packet was received with, and marks echo replies with the received DSCP
+// value, so DiffServ handling may be verified in both directions.
 type PacketServer struct {
 	// ListenAddr is the listen address, as specified to the address parameter
 	// in net.ListenPacket (e.g. ":port" or "addr:port").
@@ -202,6 +219,11 @@ func (s *PacketServer) Run(ctx context.Context, arg runArg) (ofb Feedback,
 	if len(s.Key) > 0 {
 		s.hmac = hmac.New(sha256.New, s.Key)
 	}
+	if u, ok := c.(*net.UDPConn); ok {
+		if e := enableRecvTOS(u); e != nil {
+			arg.rec.Logf("unable to enable receiving ToS: %s", e)
+		}
+	}
 	s.errc = make(chan error)
 	s.start(ctx, c, arg.rec)
 	arg.cxl <- s
@@ -233,7 +255,7 @@ func (s *PacketServer) start(ctx context.Context, conn net.PacketConn,
 		}()
 		dc := ctx.Done()
 		var d bool
-		for d {
+		for !d {
 			select {
 			case <-dc:
 				dc = nil
@@ -267,39 +289,58 @@ func (s *PacketServer) start(ctx context.Context, conn net.PacketConn,
 		var n int
 		var a net.Addr
 		b := make([]byte, s.MaxPacketSize)
-		d := make(map[Seq]struct{})
+		o := make([]byte, tosOOBLen)
+		u, _ := conn.(*net.UDPConn)
+		type flowSeq struct {
+			flow Flow
+			seq  Seq
+		}
+		d := make(map[flowSeq]struct{})
 		for {
-			if n, a, e = conn.ReadFrom(b); e != nil {
+			if u != nil {
+				n, a, p.TOS, p.HasTOS, e = readTOS(u, b, o)
+			} else {
+				n, a, e = conn.ReadFrom(b)
+			}
+			if e != nil {
 				return
 			}
 			t := metric.Now()
 			if _, we := p.Write(b[:n]); we != nil {
-				rec.Logf("dropped packet due to decoding error: %s", we)
+				rec.Warnf("dropped packet due to decoding error: %s", we)
 				continue
 			}
 			if a2, ok := f[p.Flow]; !ok {
-				rec.Send(PacketInfo{metric.Tinit, p.Flow, true})
+				rec.Send(PacketInfo{metric.Tinit, p.Flow, true, nil})
 				f[p.Flow] = a
 			} else if a2.String() != a.String() {
-				rec.Logf("dropped packet after address change for flow %s, this:%s != original:%s",
+				rec.Warnf("dropped packet after address change for flow %s, this:%s != original:%s",
 					p.Flow, a, a2)
 				continue
 			}
-			rec.Send(PacketIO{p, t, true, false})
+			rec.Send(PacketIO{p, t, true, false, nil})
 			if p.Flag&FlagEcho != 0 {
-				if _, ok := d[p.Seq]; ok {
+				k := flowSeq{p.Flow, p.Seq}
+				if _, ok := d[k]; ok {
 					continue
 				}
-				d[p.Seq] = struct{}{}
+				d[k] = struct{}{}
 				p.Flag &= ^FlagEcho
 				p.Flag |= FlagReply
 				if _, e = p.Read(b); e != nil {
 					return
 				}
-				if _, e = conn.WriteTo(b[:n], a); e != nil {
+				if p.HasTOS {
+					// reflect the received DSCP in the reply
+					p.TOS = tos(p.TOS>>2, 0)
+					e = writeTOS(u, b[:n], a.(*net.UDPAddr), p.TOS)
+				} else {
+					_, e = conn.WriteTo(b[:n], a)
+				}
+				if e != nil {
 					return
 				}
-				rec.Send(PacketIO{p, metric.Now(), true, true})
+				rec.Send(PacketIO{p, metric.Now(), true, true, nil})
 			}
 		}
 	}()
@@ -328,14 +369,22 @@ type PacketClient struct {
 	// Key is a security key for HMAC signing.
 	Key []byte
 
-	conn    net.Conn          // connection
-	hmac    hash.Hash         // hash to use for HMAC signing
-	request map[Seq]time.Time // echo request send times
-	srtt    time.Duration     // smoothed RTT
-	rec     *recorder         // recorder
-	timerQ  packetTimerQ      // timer queue
-	sender  int               // index of current sender
-	seq     Seq               // current sequence number
+	// Tags are added to the PacketIO data points for the flow.
+	Tags Tags
+
+	// CountersKey, if not empty, is the key used in the returned Feedback for
+	// the FlowCounters of the client.
+	CountersKey string
+
+	counters FlowCounters      // flow counters
+	conn     net.Conn          // connection
+	hmac     hash.Hash         // hash to use for HMAC signing
+	request  map[Seq]time.Time // echo request send times
+	srtt     time.Duration     // smoothed RTT
+	rec      *recorder         // recorder
+	timerQ   packetTimerQ      // timer queue
+	sender   int               // index of current sender
+	seq      Seq               // current sequence number
 }
 
 // Run implements runner
@@ -348,11 +397,16 @@ func (c *PacketClient) Run(ctx context.Context, arg runArg) (ofb Feedback,
 	if len(c.Key) > 0 {
 		c.hmac = hmac.New(sha256.New, c.Key)
 	}
+	if u, ok := c.conn.(*net.UDPConn); ok {
+		if e := enableRecvTOS(u); e != nil {
+			arg.rec.Logf("unable to enable receiving ToS: %s", e)
+		}
+	}
 	c.request = make(map[Seq]time.Time)
 	c.rec = arg.rec
 	c.timerQ = packetTimerQ{}
 	heap.Init(&c.timerQ)
-	c.rec.Send(PacketInfo{metric.Tinit, c.Flow, false})
+	c.rec.Send(PacketInfo{metric.Tinit, c.Flow, false, c.Tags})
 	r := c.read(arg.rec)
 	defer func() {
 		c.conn.Close()
@@ -411,6 +465,7 @@ func (c *PacketClient) Run(ctx context.Context, arg runArg) (ofb Feedback,
 				done = true
 				break
 			}
+			c.counters.addReceived(p.Len)
 			// get smoothed RTT of echo replies
 			if p.PacketHeader.Flag&FlagReply != 0 {
 				var t time.Time
@@ -425,15 +480,32 @@ func (c *PacketClient) Run(ctx context.Context, arg runArg) (ofb Feedback,
 							a*float64(r) + (1-a)*float64(c.srtt))
 					}
 					delete(c.request, p.Seq)
+					c.reply(p, r)
 				}
 			}
 		case <-ctx.Done():
 			done = true
 		}
 	}
+	if c.CountersKey != "" {
+		c.counters.Flow = c.Flow
+		c.counters.since(t0)
+		ofb = Feedback{c.CountersKey: c.counters}
+	}
 	return
 }
 
+// reply passes the RTT of an echo reply to the sender that sent the request, if
+// it's a packetResponder.
+func (c *PacketClient) reply(p Packet, rtt time.Duration) {
+	if p.Sender < 0 || p.Sender >= len(c.Sender) {
+		return
+	}
+	if r, ok := c.Sender[p.Sender].packetSender().(packetResponder); ok {
+		r.reply(c, p.Seq, rtt)
+	}
+}
+
 // SetKey implements SetKeyer
 func (c *PacketClient) SetKey(key []byte) {
 	c.Key = key
@@ -443,11 +515,15 @@ func (c *PacketClient) SetKey(key []byte) {
 func (c *PacketClient) read(rec *recorder) (
 	rc chan Packet) {
 	pc := c.conn.(net.PacketConn)
+	u, _ := c.conn.(*net.UDPConn)
 	rc = make(chan Packet)
 	go func() {
 		b := make([]byte, c.MaxPacketSize)
+		o := make([]byte, tosOOBLen)
 		var n int
 		var a net.Addr
+		var t byte
+		var k bool
 		var e error
 		defer func() {
 			if e != nil {
@@ -456,25 +532,33 @@ func (c *PacketClient) read(rec *recorder) (
 			close(rc)
 		}()
 		for {
-			n, a, e = pc.ReadFrom(b)
+			if u != nil {
+				n, a, t, k, e = readTOS(u, b, o)
+			} else {
+				n, a, e = pc.ReadFrom(b)
+			}
 			now := metric.Now()
 			if e != nil {
 				break
 			}
 			var p Packet
 			p.addr = a
+			p.TOS, p.HasTOS = t, k
 			if _, e = p.Write(b[:n]); e != nil {
 				return
 			}
-			rec.Send(PacketIO{p, now, false, false})
+			rec.Send(PacketIO{p, now, false, false, c.Tags})
+			p.Len = n
 			rc <- p
 		}
 	}()
 	return
 }
 
-// send sends a Packet.
-func (c *PacketClient) send(length int, echo bool) (seq Seq, err error) {
+// send sends a Packet. If dscp is non-negative, the Packet is marked with that
+// DSCP value, overriding the DSCP in Sockopts.
+func (c *PacketClient) send(length int, echo bool, dscp int) (seq Seq,
+	err error) {
 	var f PacketFlag
 	seq = c.seq
 	c.seq++
@@ -482,7 +566,10 @@ func (c *PacketClient) send(length int, echo bool) (seq Seq, err error) {
 		f |= FlagEcho
 	}
 	p := Packet{PacketHeader{f, seq, c.sender, c.Flow, c.hmac},
-		length, nil, false, nil}
+		length, tos(c.DSCP, c.ECN), true, nil, false, nil}
+	if dscp >= 0 {
+		p.TOS = tos(byte(dscp), c.ECN)
+	}
 	b := make([]byte, c.MaxPacketSize)
 	var n int
 	if n, err = p.Read(b); err != nil {
@@ -495,13 +582,18 @@ func (c *PacketClient) send(length int, echo bool) (seq Seq, err error) {
 			p.Len, n)
 		return
 	}
-	if _, err = c.conn.Write(b[:p.Len]); err != nil {
+	if u, ok := c.conn.(*net.UDPConn); ok && dscp >= 0 {
+		err = writeTOS(u, b[:p.Len], nil, p.TOS)
+	} else {
+		_, err = c.conn.Write(b[:p.Len])
+	}
+	if err != nil {
 		return
 	}
-	now := time.Now()
-	c.rec.Send(PacketIO{p, metric.Relative(now), false, true})
+	c.rec.Send(PacketIO{p, metric.Now(), false, true, c.Tags})
+	c.counters.addSent(p.Len)
 	if p.PacketHeader.Flag&FlagEcho != 0 {
-		c.request[p.Seq] = now
+		c.request[p.Seq] = time.Now()
 	}
 	return
 }
@@ -513,6 +605,10 @@ func (c *PacketClient) schedule(at time.Time, data any) {
 
 // validate implements validater
 func (c *PacketClient) validate() (err error) {
+	if err = c.Flow.validate(); err != nil {
+		err = fmt.Errorf("invalid Flow in PacketClient: %w", err)
+		return
+	}
 	for _, p := range c.Sender {
 		if err = p.validate(); err != nil {
 			return
@@ -568,9 +664,19 @@ type packetSender interface {
 	send(client *PacketClient, at time.Time, data any) error
 }
 
+// A packetResponder is a packetSender that responds to congestion feedback.
+// Reply is called with the RTT when an echo reply is received for a packet it
+// sent with sequence number seq.
+type packetResponder interface {
+	reply(client *PacketClient, seq Seq, rtt time.Duration)
+}
+
 // PacketSenders is the union of available packetSender implementations.
 type PacketSenders struct {
 	Unresponsive *Unresponsive
+	Responsive   *Responsive
+	Isochronous  *Isochronous
+	Train        *Train
 }
 
 // packetSender returns the packetSender.
@@ -586,6 +692,16 @@ func (p *PacketSenders) packetSender() (pp packetSender) {
 func (p *PacketSenders) validate() (err error) {
 	if _, n := p.value(); n != 1 {
 		err = UnionError{p, n}
+		return
+	}
+	if p.Responsive != nil {
+		err = p.Responsive.validate()
+	}
+	if p.Isochronous != nil {
+		err = p.Isochronous.validate()
+	}
+	if p.Train != nil {
+		err = p.Train.validate()
 	}
 	return
 }
@@ -596,6 +712,18 @@ func (p *PacketSenders) value() (pp packetSender, n int) {
 		pp = p.Unresponsive
 		n++
 	}
+	if p.Responsive != nil {
+		pp = p.Responsive
+		n++
+	}
+	if p.Isochronous != nil {
+		pp = p.Isochronous
+		n++
+	}
+	if p.Train != nil {
+		pp = p.Train
+		n++
+	}
 	return
 }
 
@@ -622,10 +750,17 @@ type Unresponsive struct {
 	// Echo, if true, requests mirrored replies from the server.
 	Echo bool
 
+	// DSCP lists the DSCP values to mark packets with, which are cycled through
+	// sequentially, so that one flow may be used to compare the treatment of
+	// multiple classes. If empty, packets are marked according to the
+	// PacketClient's Sockopts.
+	DSCP []byte
+
 	done        time.Time  // start time
 	started     bool       // send called at least once
 	waitIndex   int        // current index in Wait
 	lengthIndex int        // current index in Length
+	dscpIndex   int        // current index in DSCP
 	rand        *rand.Rand // random number source
 }
 
@@ -641,7 +776,8 @@ func (u *Unresponsive) send(client *PacketClient, at time.Time,
 		}
 	}
 	if s {
-		if _, err = client.send(u.nextLength(), u.Echo); err != nil {
+		if _, err = client.send(u.nextLength(), u.Echo,
+			u.nextDSCP()); err != nil {
 			return
 		}
 	}
@@ -675,6 +811,329 @@ func (u *Unresponsive) nextLength() (length int) {
 	return
 }
 
+// nextDSCP returns the next DSCP value, or -1 if DSCP is empty.
+func (u *Unresponsive) nextDSCP() (dscp int) {
+	if len(u.DSCP) == 0 {
+		return -1
+	}
+	dscp = int(u.DSCP[u.dscpIndex])
+	if u.dscpIndex++; u.dscpIndex >= len(u.DSCP) {
+		u.dscpIndex = 0
+	}
+	return
+}
+
+// Responsive sends packets at a rate that adapts to congestion, using the RTT
+// of echo replies, to emulate application-limited responsive traffic such as
+// real-time media. The rate is increased additively while the queueing delay
+// (the RTT above the minimum RTT seen) is below DelayTarget, and decreased
+// multiplicatively, at most once per RTT, when it's above DelayTarget or
+// packets are lost. All packets request echo replies.
+type Responsive struct {
+	// Length is the length of the packets.
+	Length int
+
+	// Duration is how long to send packets.
+	Duration metric.Duration
+
+	// InitialRate is the starting send rate.
+	InitialRate metric.Bitrate
+
+	// MinRate is the minimum send rate.
+	MinRate metric.Bitrate
+
+	// MaxRate is the maximum send rate, e.g. the highest bitrate of a media
+	// codec.
+	MaxRate metric.Bitrate
+
+	// Increase is the amount the rate is increased by per RTT, in the absence
+	// of congestion.
+	Increase metric.Bitrate
+
+	// Decrease is the factor the rate is multiplied by on congestion, from 0
+	// to 1, exclusive.
+	Decrease float64
+
+	// DelayTarget is the queueing delay above which the rate is decreased.
+	DelayTarget metric.Duration
+
+	done     time.Time        // end time
+	started  bool             // send called at least once
+	rate     float64          // current send rate, in bits per second
+	minRTT   time.Duration    // minimum RTT
+	sent     map[Seq]struct{} // packets without replies
+	decrease time.Time        // time of last rate decrease
+}
+
+// validate returns an error if any fields are invalid.
+func (r *Responsive) validate() (err error) {
+	if r.Length <= 0 {
+		err = fmt.Errorf("Responsive Length must be positive: %d", r.Length)
+		return
+	}
+	if r.MinRate <= 0 || r.MinRate > r.MaxRate {
+		err = fmt.Errorf("Responsive MinRate %s must be positive and not "+
+			"exceed MaxRate %s", r.MinRate, r.MaxRate)
+		return
+	}
+	if r.InitialRate < r.MinRate || r.InitialRate > r.MaxRate {
+		err = fmt.Errorf("Responsive InitialRate %s must be from MinRate %s "+
+			"to MaxRate %s", r.InitialRate, r.MinRate, r.MaxRate)
+		return
+	}
+	if r.Decrease <= 0 || r.Decrease >= 1 {
+		err = fmt.Errorf("Responsive Decrease must be between 0 and 1: %f",
+			r.Decrease)
+	}
+	return
+}
+
+// send implements packetSender.
+func (r *Responsive) send(client *PacketClient, at time.Time,
+	data any) (err error) {
+	if !r.started {
+		r.done = at.Add(r.Duration.Duration())
+		r.rate = float64(r.InitialRate)
+		r.sent = make(map[Seq]struct{})
+		r.started = true
+	}
+	var s Seq
+	if s, err = client.send(r.Length, true, -1); err != nil {
+		return
+	}
+	r.sent[s] = struct{}{}
+	if a := at.Add(r.wait()); a.Before(r.done) {
+		client.schedule(a, nil)
+	}
+	return
+}
+
+// reply implements packetResponder. Packets sent before the replied packet
+// that are still without replies are considered lost.
+func (r *Responsive) reply(client *PacketClient, seq Seq, rtt time.Duration) {
+	delete(r.sent, seq)
+	var l bool
+	for s := range r.sent {
+		if s < seq {
+			delete(r.sent, s)
+			l = true
+		}
+	}
+	if r.minRTT == 0 || rtt < r.minRTT {
+		r.minRTT = rtt
+	}
+	if l || rtt-r.minRTT > r.DelayTarget.Duration() {
+		if now := time.Now(); now.Sub(r.decrease) >= client.srtt {
+			r.rate *= r.Decrease
+			r.decrease = now
+		}
+	} else if client.srtt > 0 {
+		r.rate += float64(r.Increase) * r.wait().Seconds() /
+			client.srtt.Seconds()
+	}
+	r.rate = max(float64(r.MinRate), min(r.rate, float64(r.MaxRate)))
+}
+
+// wait returns the wait time between packets at the current rate.
+func (r *Responsive) wait() time.Duration {
+	return time.Duration(float64(8*r.Length) / r.rate * float64(time.Second))
+}
+
+// Isochronous sends fixed length packets at a fixed interval, with optional
+// jitter and on/off talkspurt periods, to emulate VoIP flows (e.g. G.711 or
+// Opus).
+type Isochronous struct {
+	// Interval is the nominal time between packets.
+	Interval metric.Duration
+
+	// Length is the length of the packets.
+	Length int
+
+	// Jitter is the maximum time each packet is sent before or after its
+	// nominal send time, selected uniformly at random. Jitter doesn't
+	// accumulate, so the mean rate is unchanged.
+	Jitter metric.Duration
+
+	// On and Off are the mean durations of talkspurts and silence periods,
+	// which are exponentially distributed. Packets are only sent during
+	// talkspurts. If both are zero, packets are sent continuously.
+	On  metric.Duration
+	Off metric.Duration
+
+	// Duration is how long to send packets.
+	Duration metric.Duration
+
+	// Echo, if true, requests mirrored replies from the server.
+	Echo bool
+
+	done    time.Time  // end time
+	started bool       // send called at least once
+	next    time.Time  // nominal time of the next packet
+	spurt   time.Time  // end time of the current talkspurt
+	rand    *rand.Rand // random number source
+}
+
+// validate returns an error if any fields are invalid.
+func (i *Isochronous) validate() (err error) {
+	if i.Interval <= 0 {
+		err = fmt.Errorf("Isochronous Interval must be positive: %s",
+			i.Interval)
+		return
+	}
+	if i.Length <= 0 {
+		err = fmt.Errorf("Isochronous Length must be positive: %d", i.Length)
+		return
+	}
+	if (i.On > 0) != (i.Off > 0) || i.On < 0 || i.Off < 0 {
+		err = fmt.Errorf("Isochronous On (%s) and Off (%s) must both be "+
+			"zero or positive", i.On, i.Off)
+	}
+	return
+}
+
+// send implements packetSender.
+func (i *Isochronous) send(client *PacketClient, at time.Time,
+	data any) (err error) {
+	if !i.started {
+		i.done = at.Add(i.Duration.Duration())
+		i.next = at
+		i.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+		if i.talkspurts() {
+			i.spurt = at.Add(i.exp(i.On))
+		}
+		i.started = true
+	}
+	if _, err = client.send(i.Length, i.Echo, -1); err != nil {
+		return
+	}
+	n := i.next.Add(i.Interval.Duration())
+	if i.talkspurts() && !n.Before(i.spurt) {
+		n = i.spurt.Add(i.exp(i.Off))
+		i.spurt = n.Add(i.exp(i.On))
+	}
+	i.next = n
+	if !n.Before(i.done) {
+		return
+	}
+	if i.Jitter > 0 {
+		j := i.Jitter.Duration()
+		n = n.Add(time.Duration(i.rand.Int63n(int64(2*j)+1)) - j)
+		if n.Before(at) {
+			n = at
+		}
+	}
+	client.schedule(n, nil)
+	return
+}
+
+// talkspurts returns true if packets are sent in talkspurts.
+func (i *Isochronous) talkspurts() bool {
+	return i.On > 0 && i.Off > 0
+}
+
+// exp returns an exponentially distributed random duration with the given
+// mean.
+func (i *Isochronous) exp(mean metric.Duration) time.Duration {
+	return time.Duration(i.rand.ExpFloat64() * float64(mean))
+}
+
+// Train sends trains of back-to-back packets at an interval, so the bottleneck
+// capacity and available bandwidth may be estimated from the dispersion of the
+// packets at the receiver (see PacketTrain). It's useful as a short sanity
+// check of the path before bulk tests.
+type Train struct {
+	// Count is the number of packets in each train.
+	Count int
+
+	// Length is the length of the packets.
+	Length int
+
+	// Interval is the time between the start of each train, which should be
+	// long enough for the prior train to clear the bottleneck queue.
+	Interval metric.Duration
+
+	// Trains is the number of trains to send.
+	Trains int
+
+	sent int // number of trains sent
+}
+
+// validate returns an error if any fields are invalid.
+func (t *Train) validate() (err error) {
+	if t.Count < 2 {
+		err = fmt.Errorf("Train Count must be at least 2: %d", t.Count)
+		return
+	}
+	if t.Length <= 0 {
+		err = fmt.Errorf("Train Length must be positive: %d", t.Length)
+		return
+	}
+	if t.Interval <= 0 {
+		err = fmt.Errorf("Train Interval must be positive: %s", t.Interval)
+		return
+	}
+	if t.Trains <= 0 {
+		err = fmt.Errorf("Train Trains must be positive: %d", t.Trains)
+	}
+	return
+}
+
+// send implements packetSender.
+func (t *Train) send(client *PacketClient, at time.Time,
+	data any) (err error) {
+	p := PacketTrain{Flow: client.Flow, Count: t.Count, Length: t.Length}
+	for i := 0; i < t.Count; i++ {
+		var s Seq
+		if s, err = client.send(t.Length, false, -1); err != nil {
+			return
+		}
+		if i == 0 {
+			p.Seq = s
+		}
+	}
+	client.rec.Send(p)
+	if t.sent++; t.sent < t.Trains {
+		client.schedule(at.Add(t.Interval.Duration()), nil)
+	}
+	return
+}
+
+// PacketTrain is a data point that records the sequence numbers of a train of
+// back-to-back packets sent by the Train sender.
+type PacketTrain struct {
+	// Flow is the flow that the train was sent on.
+	Flow Flow
+
+	// Seq is the sequence number of the first packet in the train.
+	Seq Seq
+
+	// Count is the number of packets in the train.
+	Count int
+
+	// Length is the length of the packets.
+	Length int
+}
+
+// init registers PacketTrain with the gob encoder
+func init() {
+	gob.Register(PacketTrain{})
+}
+
+// flags implements message
+func (PacketTrain) flags() flag {
+	return flagForward
+}
+
+// handle implements event
+func (p PacketTrain) handle(node *node) {
+	node.parent.Send(p)
+}
+
+func (p PacketTrain) String() string {
+	return fmt.Sprintf("PacketTrain[Flow:%s Seq:%d Count:%d Length:%d]",
+		p.Flow, p.Seq, p.Count, p.Length)
+}
+
 // PacketInfo contains information for a packet flow.
 type PacketInfo struct {
 	// Tinit is the base time for the flow's RelativeTime values.
@@ -685,6 +1144,9 @@ type PacketInfo struct {
 
 	// Server indicates if this is from the server (true) or client (false).
 	Server bool
+
+	// Tags are the Tags from the PacketClient, and are empty for the server.
+	Tags Tags
 }
 
 // init registers PacketInfo with the gob encoder
@@ -725,6 +1187,9 @@ type PacketIO struct {
 
 	// Sent is true for a sent packet, and false for received.
 	Sent bool
+
+	// Tags are the Tags from the PacketClient, and are empty for the server.
+	Tags Tags
 }
 
 // init registers PacketIO with the gob encoder
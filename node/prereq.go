@@ -0,0 +1,467 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"bufio"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/kballard/go-shellquote"
+)
+
+// capNetAdmin is the bit number for CAP_NET_ADMIN in the capability sets.
+const capNetAdmin = 12
+
+// qdiscModule maps tc qdisc kinds to the kernel modules that implement them.
+var qdiscModule = map[string]string{
+	"cake":     "sch_cake",
+	"codel":    "sch_codel",
+	"dualpi2":  "sch_dualpi2",
+	"fq":       "sch_fq",
+	"fq_codel": "sch_fq_codel",
+	"fq_pie":   "sch_fq_pie",
+	"htb":      "sch_htb",
+	"netem":    "sch_netem",
+	"pie":      "sch_pie",
+	"red":      "sch_red",
+	"sfq":      "sch_sfq",
+	"tbf":      "sch_tbf",
+}
+
+// Prereq is a runner that checks for the prerequisites needed by the runners
+// on a node, and sends a PrereqResult listing any that are missing. Prereq
+// doesn't return an error for missing prerequisites, so the results from all
+// nodes may be gathered and reported at once.
+//
+// Prereq runners are normally generated by Preflight, rather than configured
+// directly.
+type Prereq struct {
+	// Command lists the executables that must be found in the PATH.
+	Command []string
+
+	// NetAdmin, if true, requires the CAP_NET_ADMIN capability.
+	NetAdmin bool
+
+	// Sockdiag, if true, requires that sock_diag(7) is available for TCPInfo
+	// sampling. It's not generated by the preflight check, as TCPInfo is
+	// sampled with getsockopt if sock_diag is unavailable.
+	Sockdiag bool
+
+	// Module lists the kernel modules that must be loaded, built in, or
+	// available for loading.
+	Module []string
+
+	// CCA lists the TCP congestion control algorithms that must be available.
+	CCA []string
+}
+
+// Run implements runner
+func (p *Prereq) Run(ctx context.Context, arg runArg) (ofb Feedback,
+	err error) {
+	arg.rec.Send(PrereqResult{arg.rec.nodeID, p.check()})
+	return
+}
+
+// check returns a description of each missing prerequisite.
+func (p *Prereq) check() (missing []string) {
+	for _, c := range p.Command {
+		if _, e := exec.LookPath(c); e != nil {
+			missing = append(missing, fmt.Sprintf("command %s not found", c))
+		}
+	}
+	if p.NetAdmin {
+		if ok, e := hasCapability(capNetAdmin); e != nil {
+			missing = append(missing,
+				fmt.Sprintf("unable to check for CAP_NET_ADMIN: %s", e))
+		} else if !ok {
+			missing = append(missing,
+				"CAP_NET_ADMIN is required (run as root, or with sudo)")
+		}
+	}
+	if p.Sockdiag {
+		if e := TestSockdiag(); e != nil {
+			missing = append(missing,
+				fmt.Sprintf("sock_diag unavailable for TCPInfo: %s", e))
+		}
+	}
+	for _, m := range p.Module {
+		if !moduleAvailable(m) {
+			missing = append(missing,
+				fmt.Sprintf("kernel module %s not found", m))
+		}
+	}
+	for _, c := range p.CCA {
+		if !ccaAvailable(c) {
+			missing = append(missing, fmt.Sprintf(
+				"congestion control %s not available (try modprobe tcp_%s)",
+				c, c))
+		}
+	}
+	return
+}
+
+// add adds the prerequisites from p2 to p, without duplicates.
+func (p *Prereq) add(p2 Prereq) {
+	u := func(s []string, a ...string) []string {
+		for _, v := range a {
+			if !slices.Contains(s, v) {
+				s = append(s, v)
+			}
+		}
+		return s
+	}
+	p.Command = u(p.Command, p2.Command...)
+	p.NetAdmin = p.NetAdmin || p2.NetAdmin
+	p.Sockdiag = p.Sockdiag || p2.Sockdiag
+	p.Module = u(p.Module, p2.Module...)
+	p.CCA = u(p.CCA, p2.CCA...)
+}
+
+// zero returns true if there are no prerequisites.
+func (p *Prereq) zero() bool {
+	return len(p.Command) == 0 && !p.NetAdmin && !p.Sockdiag &&
+		len(p.Module) == 0 && len(p.CCA) == 0
+}
+
+// PrereqResult is sent by the Prereq runner with the prerequisites that are
+// missing on a node.
+type PrereqResult struct {
+	// NodeID is the ID of the node that checked the prerequisites.
+	NodeID ID
+
+	// Missing lists the missing prerequisites. If empty, all prerequisites
+	// were met.
+	Missing []string
+}
+
+// init registers PrereqResult with the gob encoder
+func init() {
+	gob.Register(PrereqResult{})
+}
+
+// flags implements message
+func (PrereqResult) flags() flag {
+	return flagForward
+}
+
+// handle implements event
+func (r PrereqResult) handle(node *node) {
+	node.parent.Send(r)
+}
+
+func (r PrereqResult) String() string {
+	return fmt.Sprintf("PrereqResult[NodeID:%s Missing:%s]", r.NodeID,
+		r.Missing)
+}
+
+// PrereqError is returned when prerequisites are missing on one or more nodes.
+type PrereqError struct {
+	// Missing maps node IDs to their missing prerequisites.
+	Missing map[ID][]string
+}
+
+// Error implements error
+func (p PrereqError) Error() string {
+	ii := make([]ID, 0, len(p.Missing))
+	for i := range p.Missing {
+		ii = append(ii, i)
+	}
+	slices.Sort(ii)
+	var b strings.Builder
+	b.WriteString("missing prerequisites:")
+	for _, i := range ii {
+		for _, m := range p.Missing[i] {
+			fmt.Fprintf(&b, "\n  %s: %s", i, m)
+		}
+	}
+	return b.String()
+}
+
+// Preflight returns a Run that checks the prerequisites for the runners in the
+// given Runs. The returned Run has the same node hierarchy as the given Runs,
+// with a Prereq runner on each node that has prerequisites, and it runs no
+// other runners. Each Prereq sends a PrereqResult.
+//
+// Nodes that join an existing netns are left out, along with their children,
+// as the netns may not exist until an earlier runner creates it.
+func Preflight(run ...*Run) *Run {
+	p := &preflight{make(map[Node]*Prereq), make(Tree)}
+	for _, r := range run {
+		p.build(r, ParentNode, p.tree)
+	}
+	return p.run(ParentNode, p.tree)
+}
+
+// preflight contains the Node tree and prerequisites gathered by Preflight.
+type preflight struct {
+	prereq map[Node]*Prereq
+	tree   Tree
+}
+
+// build is called recursively to gather prerequisites for the given Run on
+// the given Node.
+func (p *preflight) build(run *Run, node Node, tre Tree) {
+	var rr []Run
+	switch {
+	case len(run.Serial) > 0:
+		rr = run.Serial
+	case len(run.Parallel) > 0:
+		rr = run.Parallel
+	case run.Schedule != nil:
+		rr = run.Schedule.Run
+	case run.Child != nil:
+		n := run.Child.Node
+		if n.Netns.Name != "" && !n.Netns.Create {
+			return
+		}
+		t, ok := tre[n]
+		if !ok {
+			t = make(Tree)
+			tre[n] = t
+		}
+		p.add(node, launchPrereq(n))
+		p.build(&run.Child.Run, n, t)
+		return
+	default:
+		p.add(node, runnerPrereq(&run.Runners))
+		return
+	}
+	for i := range rr {
+		p.build(&rr[i], node, tre)
+	}
+}
+
+// add adds prerequisites for the given Node.
+func (p *preflight) add(node Node, prereq Prereq) {
+	q, ok := p.prereq[node]
+	if !ok {
+		q = &Prereq{}
+		p.prereq[node] = q
+	}
+	q.add(prereq)
+}
+
+// run returns the Run for the given Node and its children.
+func (p *preflight) run(node Node, tre Tree) *Run {
+	var s Serial
+	if q, ok := p.prereq[node]; ok && !q.zero() {
+		s = append(s, Run{Runners: Runners{Prereq: q}})
+	}
+	var c Parallel
+	for n, t := range tre {
+		c = append(c, Run{Child: &Child{*p.run(n, t), n}})
+	}
+	if len(c) > 0 {
+		s = append(s, Run{Parallel: c})
+	}
+	return &Run{Serial: s}
+}
+
+// launchPrereq returns the prerequisites needed on a parent node to launch the
+// given child Node.
+func launchPrereq(node Node) (p Prereq) {
+	l, n := node.Launcher.value()
+	if n != 1 {
+		return
+	}
+	switch v := l.(type) {
+	case Local:
+		if v.Sudo {
+			p.Command = append(p.Command, "sudo")
+		}
+		if !node.Netns.zero() {
+			p.Command = append(p.Command, "ip")
+			p.NetAdmin = !v.Sudo
+		}
+	case SSH:
+		p.Command = append(p.Command, "ssh")
+	case Container:
+		if v.Sudo {
+			p.Command = append(p.Command, "sudo")
+		}
+		p.Command = append(p.Command, v.Runtime)
+	}
+	return
+}
+
+// runnerPrereq returns the prerequisites for the given runner.
+func runnerPrereq(runners *Runners) (p Prereq) {
+	if s := runners.System; s != nil {
+		p = commandPrereq(s.Command)
+	} else if c := runners.StreamClient; c != nil {
+		if u := c.Upload; u != nil && u.CCA != "" {
+			p.CCA = append(p.CCA, u.CCA)
+		}
+	} else if c := runners.PacketClient; c != nil {
+		if c.CCA != "" {
+			p.CCA = append(p.CCA, c.CCA)
+		}
+	} else if c := runners.IRTTClient; c != nil {
+		p.Command = append(p.Command, c.Command)
+	} else if runners.Station != nil {
+		p.Command = append(p.Command, "iw")
+	} else if runners.TinStats != nil {
+		p.Command = append(p.Command, "tc")
+	} else if n := runners.Netem; n != nil {
+		p.Command = append(p.Command, "tc")
+		p.NetAdmin = true
+		if cc, err := n.commands(); err == nil {
+			for _, c := range cc {
+				p.Module = append(p.Module, qdiscModules(c)...)
+			}
+		}
+	} else if t := runners.Topology; t != nil {
+		p.Command = append(p.Command, "ip")
+		if t.forward() {
+			p.Command = append(p.Command, "sysctl")
+		}
+		p.NetAdmin = true
+	} else if k := runners.KernelModule; k != nil {
+		p.Command = append(p.Command, "modprobe")
+		p.Module = append(p.Module, k.Module...)
+	} else if c := runners.PacketCapture; c != nil && c.Filter != "" {
+		p.Command = append(p.Command, "tcpdump")
+	}
+	return
+}
+
+// commandPrereq returns the prerequisites for running the given Command. The
+// executable is required, and for the ip and tc commands, CAP_NET_ADMIN if
+// they make changes, and the kernel modules for any qdiscs in the arguments.
+// Shell commands are inspected for tc commands as well.
+func commandPrereq(cmd Command) (p Prereq) {
+	n, a, err := cmd.param()
+	if err != nil {
+		return
+	}
+	p.Command = append(p.Command, n)
+	switch filepath.Base(n) {
+	case "ip":
+		p.NetAdmin = netAdminArgs(a)
+	case "tc":
+		p.NetAdmin = netAdminArgs(a)
+		p.Module = qdiscModules(a)
+	case "sh", "bash":
+		for i, s := range a {
+			if s != "-c" || i+1 >= len(a) {
+				continue
+			}
+			var w []string
+			if w, err = shellquote.Split(a[i+1]); err != nil {
+				continue
+			}
+			if slices.Contains(w, "tc") {
+				p.Module = qdiscModules(w)
+			}
+		}
+	}
+	return
+}
+
+// netAdminVerb lists the ip and tc command verbs that make changes, and so
+// require CAP_NET_ADMIN.
+var netAdminVerb = []string{
+	"add",
+	"change",
+	"del",
+	"delete",
+	"exec",
+	"flush",
+	"replace",
+	"set",
+}
+
+// netAdminArgs returns true if the given ip or tc arguments make changes, so
+// that CAP_NET_ADMIN is required. Commands that only show state don't need it.
+func netAdminArgs(arg []string) bool {
+	for _, a := range arg {
+		if slices.Contains(netAdminVerb, a) {
+			return true
+		}
+	}
+	return false
+}
+
+// qdiscModules returns the kernel modules needed for any qdisc kinds in the
+// given tc arguments.
+func qdiscModules(arg []string) (module []string) {
+	if !slices.Contains(arg, "qdisc") {
+		return
+	}
+	for _, a := range arg {
+		if m, ok := qdiscModule[a]; ok && !slices.Contains(module, m) {
+			module = append(module, m)
+		}
+	}
+	return
+}
+
+// hasCapability returns true if the given capability is in the process's
+// effective capability set.
+func hasCapability(bit uint) (ok bool, err error) {
+	var f *os.File
+	if f, err = os.Open("/proc/self/status"); err != nil {
+		return
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var v string
+		var found bool
+		if v, found = strings.CutPrefix(s.Text(), "CapEff:"); !found {
+			continue
+		}
+		var c uint64
+		v = strings.TrimSpace(v)
+		if c, err = strconv.ParseUint(v, 16, 64); err != nil {
+			return
+		}
+		ok = c&(1<<bit) != 0
+		return
+	}
+	if err = s.Err(); err == nil {
+		err = errors.New("CapEff not found in /proc/self/status")
+	}
+	return
+}
+
+// moduleAvailable returns true if the given kernel module is loaded, or is
+// known to modinfo. If it can't be determined whether the module is available,
+// true is returned, so that the module may still be tried.
+func moduleAvailable(name string) bool {
+	if _, err := os.Stat(filepath.Join("/sys/module", name)); err == nil {
+		return true
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return true
+	}
+	m, err := exec.LookPath("modinfo")
+	if err != nil {
+		return true
+	}
+	return exec.Command(m, name).Run() == nil
+}
+
+// ccaAvailable returns true if the given TCP congestion control algorithm is
+// available, or its module can be loaded.
+func ccaAvailable(cca string) bool {
+	b, err := os.ReadFile(
+		"/proc/sys/net/ipv4/tcp_available_congestion_control")
+	if err != nil {
+		return true
+	}
+	if slices.Contains(strings.Fields(string(b)), cca) {
+		return true
+	}
+	return moduleAvailable("tcp_" + cca)
+}
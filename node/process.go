@@ -0,0 +1,57 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"encoding/gob"
+	"fmt"
+	"os"
+)
+
+// NodeProcess contains the information needed to attach to a running node's
+// process for debugging. Nodes send it during setup only when node.Do is
+// called with Options.Hold set, and it's passed to Hold rather than being sent
+// on the data channel.
+type NodeProcess struct {
+	// NodeID is the ID of the node.
+	NodeID ID
+
+	// Host is the hostname of the system the node runs on.
+	Host string
+
+	// PID is the process ID of the node.
+	PID int
+
+	// Netns is the name of the node's network namespace, or empty if it runs
+	// in the default namespace.
+	Netns string
+}
+
+// init registers NodeProcess with the gob encoder
+func init() {
+	gob.Register(NodeProcess{})
+}
+
+// newNodeProcess returns a NodeProcess for the current process.
+func newNodeProcess(nodeID ID) (p NodeProcess) {
+	p.NodeID = nodeID
+	p.Host, _ = os.Hostname()
+	p.PID = os.Getpid()
+	return
+}
+
+// flags implements message
+func (NodeProcess) flags() flag {
+	return flagForward | flagPush
+}
+
+// handle implements event
+func (p NodeProcess) handle(node *node) {
+	node.parent.Send(p)
+}
+
+func (p NodeProcess) String() string {
+	return fmt.Sprintf("NodeProcess[NodeID:%s Host:%s PID:%d Netns:%s]",
+		p.NodeID, p.Host, p.PID, p.Netns)
+}
@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	rpprof "runtime/pprof"
+)
+
+// Profile is a runner that captures Go runtime profiles on a node, for
+// diagnosing performance problems in the node itself, e.g. in high-rate tests.
+// Profiles are captured from the time Profile is run until the node's runners
+// are canceled at the end of the Test, and are sent as FileData, so they're
+// saved in the result directory by the SaveFiles reporter.
+//
+// Profile should be placed before any other runners on the node, so that the
+// profiles cover the entire Test.
+type Profile struct {
+	// CPU, if true, captures a CPU profile to cpu_<nodeID>.pprof.
+	CPU bool
+
+	// Heap, if true, captures a heap profile to heap_<nodeID>.pprof at the end
+	// of the Test.
+	Heap bool
+
+	// HTTP, if not empty, is a listen address (e.g. "localhost:6060") for an
+	// HTTP server with the net/http/pprof endpoints, which run for the duration
+	// of the Test.
+	HTTP string
+
+	cpu    bytes.Buffer
+	server *http.Server
+	errc   chan error
+	rec    *recorder
+}
+
+// Run implements runner
+func (p *Profile) Run(ctx context.Context, arg runArg) (ofb Feedback,
+	err error) {
+	p.rec = arg.rec
+	if p.HTTP != "" {
+		if err = p.serve(); err != nil {
+			return
+		}
+	}
+	if p.CPU {
+		if err = rpprof.StartCPUProfile(&p.cpu); err != nil {
+			if p.server != nil {
+				p.server.Close()
+			}
+			return
+		}
+	}
+	arg.cxl <- p
+	return
+}
+
+// serve starts the HTTP server for the pprof endpoints.
+func (p *Profile) serve() (err error) {
+	var l net.Listener
+	if l, err = net.Listen("tcp", p.HTTP); err != nil {
+		return
+	}
+	m := http.NewServeMux()
+	m.HandleFunc("/debug/pprof/", pprof.Index)
+	m.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	m.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	m.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	m.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	p.server = &http.Server{Handler: m}
+	p.errc = make(chan error, 1)
+	p.rec.Logf("pprof endpoints at http://%s/debug/pprof/", l.Addr())
+	go func() {
+		defer close(p.errc)
+		if e := p.server.Serve(l); !errors.Is(e, http.ErrServerClosed) {
+			p.errc <- e
+		}
+	}()
+	return
+}
+
+// Cancel implements canceler
+func (p *Profile) Cancel() (err error) {
+	n := p.rec.nodeID
+	if p.CPU {
+		rpprof.StopCPUProfile()
+		p.rec.FileData(fmt.Sprintf("cpu_%s.pprof", n), p.cpu.Bytes())
+	}
+	if p.Heap {
+		runtime.GC()
+		var b bytes.Buffer
+		if err = rpprof.Lookup("heap").WriteTo(&b, 0); err != nil {
+			return
+		}
+		p.rec.FileData(fmt.Sprintf("heap_%s.pprof", n), b.Bytes())
+	}
+	if p.server != nil {
+		if e := p.server.Close(); e != nil && err == nil {
+			err = e
+		}
+		if e := <-p.errc; e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+// validate implements validater
+func (p *Profile) validate() (err error) {
+	if !p.CPU && !p.Heap && p.HTTP == "" {
+		err = fmt.Errorf(
+			"at least one of CPU, Heap or HTTP must be set in Profile")
+	}
+	return
+}
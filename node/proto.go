@@ -99,6 +99,8 @@ type setup struct {
 	ID       runID
 	Children Tree
 	Exes     exes
+	NodeOptions
+	Debug bool
 }
 
 // init registers setup with the gob encoder
@@ -111,6 +113,21 @@ func init() {
 // Run launches and runs setup on child nodes, recursively through the node
 // tree. After successful setup, the node is ready to execute Run's.
 func (s setup) Run(ctx context.Context, arg runArg) (ofb Feedback, err error) {
+	arg.rec.SetLogLimit(s.Log)
+	arg.rec.parent.Limit(s.Overflow, arg.rec.nodeID)
+	arg.rec.parent.Tune(s.Tuning)
+	arg.rec.SetFileRate(s.Tuning.FileRate)
+	*arg.diagnose = s.Diagnose
+	if s.Debug {
+		arg.rec.Send(newNodeProcess(arg.rec.nodeID))
+	}
+	if s.Lock.Path != "" && arg.rec.nodeID != RootNodeID {
+		var c canceler
+		if c, err = s.Lock.acquire(ctx, arg.rec); err != nil {
+			return
+		}
+		arg.cxl <- c
+	}
 	if err = repo.AddSource(s.Exes); err != nil {
 		return
 	}
@@ -127,7 +144,8 @@ func (s setup) Run(ctx context.Context, arg runArg) (ofb Feedback, err error) {
 			return
 		}
 		x.Remove(n.Platform)
-		s := &setup{0, t, x}
+		c.Tune(s.Tuning)
+		s := &setup{0, t, x, s.NodeOptions, s.Debug}
 		c.Run(&Run{Runners: Runners{Setup: s}}, arg.ifb, rc)
 	}
 	for i := 0; i < arg.child.Count(); i++ {
@@ -162,6 +180,9 @@ func (s setup) String() string {
 // after normal execution. It is the final message sent from parent to child.
 type cancel struct {
 	Reason string
+
+	// Failed is true if the parent failed, so the node runs its diagnostics.
+	Failed bool
 }
 
 // init registers cancel with the gob encoder
@@ -178,6 +199,7 @@ func (c cancel) handle(node *node) {
 		} else {
 			node.cancel = true
 		}
+		node.failed = node.failed || c.Failed
 	default:
 		if c.Reason != "" {
 			node.rec.Logf("ignoring cancel request for reason '%s' (state: %s)",
@@ -0,0 +1,171 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"encoding/gob"
+	"fmt"
+	"time"
+
+	"github.com/heistp/antler/node/metric"
+)
+
+// Netlink TC constants, from linux/rtnetlink.h, linux/gen_stats.h and
+// linux/pkt_sched.h.
+const (
+	tcaKind          = 1
+	tcaXstats        = 4
+	tcaStats2        = 7
+	tcaStatsBasic    = 1
+	tcaStatsQueue    = 3
+	tcaStatsPkt64    = 8
+	sizeofTcMsg      = 20
+	sizeofStatsQueue = 20
+	tcHandleRoot     = 0xffffffff
+	tcHandleIngress  = 0xfffffff1
+)
+
+// qdiscMarkOffset maps qdisc kinds to the offset of the ECN mark counter in
+// their xstats (e.g. ecn_mark in struct tc_fq_codel_xstats).
+var qdiscMarkOffset = map[string]int{
+	"codel":    24,
+	"fq_codel": 12,
+	"fq_pie":   16,
+	"pie":      36,
+	"red":      12,
+}
+
+// QdiscStats is a runner that samples the statistics for the qdiscs on a
+// network interface using rtnetlink, from the time it's run until the node's
+// runners are canceled at the end of the Test. A QdiscInfo data point is sent
+// for each qdisc on the interface, for each sample. QdiscStats requires Linux.
+//
+// QdiscStats should be placed after the qdiscs are configured, and before the
+// runners that generate traffic, so that sampling covers the entire Test.
+type QdiscStats struct {
+	// Interface is the network interface name (e.g. "eth0").
+	Interface string
+
+	// Interval is the sampling interval.
+	Interval metric.Duration
+
+	conn *qdiscConn
+	done chan struct{}
+	errc chan error
+}
+
+// validate implements validater
+func (s *QdiscStats) validate() (err error) {
+	if s.Interface == "" {
+		err = fmt.Errorf("QdiscStats requires an Interface")
+		return
+	}
+	if s.Interval <= 0 {
+		err = fmt.Errorf("QdiscStats Interval must be positive: %s", s.Interval)
+	}
+	return
+}
+
+// qdiscConn is an rtnetlink socket for sampling the qdiscs on an interface.
+type qdiscConn struct {
+	fd      int
+	ifindex int
+	seq     uint32
+	buf     []byte
+}
+
+// tcHandle returns a TC handle in the form used by tc (e.g. "1:", "1:10" or
+// "root").
+func tcHandle(h uint32) string {
+	switch h {
+	case tcHandleRoot:
+		return "root"
+	case tcHandleIngress:
+		return "ingress"
+	}
+	if h&0xffff == 0 {
+		return fmt.Sprintf("%x:", h>>16)
+	}
+	return fmt.Sprintf("%x:%x", h>>16, h&0xffff)
+}
+
+// QdiscInfo is a time series data point containing the statistics for one
+// qdisc, as sampled by QdiscStats. Counters are cumulative since the qdisc was
+// created.
+type QdiscInfo struct {
+	// Tinit is the base time for T.
+	Tinit time.Time
+
+	// T is the node-relative time the sample was taken.
+	T metric.RelativeTime
+
+	// NodeID is the ID of the node that took the sample.
+	NodeID ID
+
+	// Interface is the network interface name.
+	Interface string
+
+	// Kind is the kind of qdisc (e.g. "fq_codel").
+	Kind string
+
+	// Handle is the qdisc's handle (e.g. "1:").
+	Handle string
+
+	// Parent is the qdisc's parent (e.g. "root" or "1:1").
+	Parent string
+
+	// Bytes is the number of bytes sent.
+	Bytes metric.Bytes
+
+	// Packets is the number of packets sent.
+	Packets uint64
+
+	// Qlen is the current queue length, in packets.
+	Qlen uint32
+
+	// Backlog is the current queue length, in bytes.
+	Backlog metric.Bytes
+
+	// Drops is the number of packets dropped.
+	Drops uint32
+
+	// Requeues is the number of packets requeued.
+	Requeues uint32
+
+	// Overlimits is the number of times the qdisc was over its limit (e.g.
+	// throttled by a shaper).
+	Overlimits uint32
+
+	// Marks is the number of packets marked with ECN CE, for qdiscs that report
+	// it in their xstats (codel, fq_codel, pie, fq_pie and red).
+	Marks uint32
+}
+
+// init registers QdiscInfo with the gob encoder
+func init() {
+	gob.Register(QdiscInfo{})
+}
+
+// Time returns an absolute from a node-relative time.
+func (q QdiscInfo) Time() time.Time {
+	return q.Tinit.Add(time.Duration(q.T))
+}
+
+// flags implements message
+func (QdiscInfo) flags() flag {
+	return flagForward
+}
+
+// handle implements event
+func (q QdiscInfo) handle(node *node) {
+	node.parent.Send(q)
+}
+
+func (q QdiscInfo) String() string {
+	return fmt.Sprintf("QdiscInfo[T:%s NodeID:%s Interface:%s Kind:%s "+
+		"Handle:%s Parent:%s Bytes:%d Packets:%d Qlen:%d Backlog:%d Drops:%d "+
+		"Requeues:%d Overlimits:%d Marks:%d]", q.T, q.NodeID, q.Interface,
+		q.Kind, q.Handle, q.Parent, q.Bytes, q.Packets, q.Qlen, q.Backlog,
+		q.Drops, q.Requeues, q.Overlimits, q.Marks)
+}
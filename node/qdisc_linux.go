@@ -0,0 +1,233 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/heistp/antler/node/metric"
+	"golang.org/x/sys/unix"
+)
+
+// Run implements runner
+func (s *QdiscStats) Run(ctx context.Context, arg runArg) (ofb Feedback,
+	err error) {
+	var i *net.Interface
+	if i, err = net.InterfaceByName(s.Interface); err != nil {
+		return
+	}
+	if s.conn, err = openQdiscConn(i); err != nil {
+		return
+	}
+	if err = s.sample(arg.rec); err != nil {
+		s.conn.Close()
+		return
+	}
+	s.done = make(chan struct{})
+	s.errc = make(chan error, 1)
+	go s.run(arg.rec)
+	arg.cxl <- s
+	return
+}
+
+// run samples the qdisc statistics each Interval, until done is closed.
+func (s *QdiscStats) run(rec *recorder) {
+	var err error
+	defer func() {
+		if e := s.conn.Close(); e != nil && err == nil {
+			err = e
+		}
+		s.errc <- err
+		close(s.errc)
+	}()
+	t := time.NewTicker(s.Interval.Duration())
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err = s.sample(rec); err != nil {
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Cancel implements canceler
+func (s *QdiscStats) Cancel() error {
+	close(s.done)
+	return <-s.errc
+}
+
+// sample sends a QdiscInfo for each qdisc on the interface.
+func (s *QdiscStats) sample(rec *recorder) (err error) {
+	var ii []QdiscInfo
+	if ii, err = s.conn.sample(); err != nil {
+		err = fmt.Errorf("qdisc stats for %s: %w", s.Interface, err)
+		return
+	}
+	for _, i := range ii {
+		i.Tinit = metric.Tinit
+		i.NodeID = rec.nodeID
+		i.Interface = s.Interface
+		rec.Send(i)
+	}
+	return
+}
+
+// openQdiscConn opens and returns a new qdiscConn for the given interface.
+func openQdiscConn(ifi *net.Interface) (conn *qdiscConn, err error) {
+	var fd int
+	if fd, err = unix.Socket(unix.AF_NETLINK, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC,
+		unix.NETLINK_ROUTE); err != nil {
+		err = fmt.Errorf("unable to open rtnetlink socket: %w", err)
+		return
+	}
+	t := unix.Timeval{Sec: 1}
+	if err = unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO,
+		&t); err != nil {
+		unix.Close(fd)
+		return
+	}
+	conn = &qdiscConn{fd, ifi.Index, 0, make([]byte, sockdiagBufLen)}
+	return
+}
+
+// sample returns a QdiscInfo for each qdisc on the interface. The NodeID,
+// Interface and Tinit fields are not set.
+func (c *qdiscConn) sample() (info []QdiscInfo, err error) {
+	if err = c.request(); err != nil {
+		return
+	}
+	for d := false; !d; {
+		var n int
+		if n, _, err = unix.Recvfrom(c.fd, c.buf, 0); err != nil {
+			return
+		}
+		t := metric.Now()
+		var mm []syscall.NetlinkMessage
+		if mm, err = syscall.ParseNetlinkMessage(c.buf[:n]); err != nil {
+			return
+		}
+		for _, m := range mm {
+			if m.Header.Seq != c.seq {
+				continue
+			}
+			switch m.Header.Type {
+			case unix.NLMSG_DONE:
+				d = true
+			case unix.NLMSG_ERROR:
+				err = netlinkError(m.Data)
+				return
+			case unix.RTM_NEWQDISC:
+				if i, ok := parseTcMsg(m.Data, c.ifindex); ok {
+					i.T = t
+					info = append(info, i)
+				}
+			}
+		}
+	}
+	return
+}
+
+// request sends an RTM_GETQDISC dump request for the interface.
+func (c *qdiscConn) request() (err error) {
+	c.seq++
+	l := unix.SizeofNlMsghdr + sizeofTcMsg
+	b := make([]byte, 0, l)
+	e := binary.NativeEndian
+	// nlmsghdr
+	b = e.AppendUint32(b, uint32(l))
+	b = e.AppendUint16(b, unix.RTM_GETQDISC)
+	b = e.AppendUint16(b, unix.NLM_F_REQUEST|unix.NLM_F_DUMP)
+	b = e.AppendUint32(b, c.seq)
+	b = e.AppendUint32(b, 0)
+	// tcmsg
+	b = append(b, unix.AF_UNSPEC, 0, 0, 0)
+	b = e.AppendUint32(b, uint32(c.ifindex))
+	b = append(b, make([]byte, 12)...)
+	a := &unix.SockaddrNetlink{Family: unix.AF_NETLINK}
+	err = unix.Sendto(c.fd, b, 0, a)
+	return
+}
+
+// Close closes the netlink socket.
+func (c *qdiscConn) Close() error {
+	return unix.Close(c.fd)
+}
+
+// parseTcMsg returns a QdiscInfo from a tcmsg and its attributes. False is
+// returned if the message is not for the given interface.
+func parseTcMsg(data []byte, ifindex int) (info QdiscInfo, ok bool) {
+	if len(data) < sizeofTcMsg {
+		return
+	}
+	e := binary.NativeEndian
+	if int(int32(e.Uint32(data[4:8]))) != ifindex {
+		return
+	}
+	info.Handle = tcHandle(e.Uint32(data[8:12]))
+	info.Parent = tcHandle(e.Uint32(data[12:16]))
+	var x []byte
+	eachRtAttr(data[sizeofTcMsg:], func(typ uint16, val []byte) {
+		switch typ {
+		case tcaKind:
+			if n := len(val); n > 0 && val[n-1] == 0 {
+				val = val[:n-1]
+			}
+			info.Kind = string(val)
+		case tcaXstats:
+			x = val
+		case tcaStats2:
+			eachRtAttr(val, func(typ uint16, val []byte) {
+				switch typ {
+				case tcaStatsBasic:
+					if len(val) >= 12 {
+						info.Bytes = metric.Bytes(e.Uint64(val[0:8]))
+						info.Packets = uint64(e.Uint32(val[8:12]))
+					}
+				case tcaStatsPkt64:
+					if len(val) >= 8 {
+						info.Packets = e.Uint64(val[0:8])
+					}
+				case tcaStatsQueue:
+					if len(val) >= sizeofStatsQueue {
+						info.Qlen = e.Uint32(val[0:4])
+						info.Backlog = metric.Bytes(e.Uint32(val[4:8]))
+						info.Drops = e.Uint32(val[8:12])
+						info.Requeues = e.Uint32(val[12:16])
+						info.Overlimits = e.Uint32(val[16:20])
+					}
+				}
+			})
+		}
+	})
+	if o, ok := qdiscMarkOffset[info.Kind]; ok && len(x) >= o+4 {
+		info.Marks = e.Uint32(x[o : o+4])
+	}
+	ok = true
+	return
+}
+
+// eachRtAttr calls f with the type and value of each rtattr in data.
+func eachRtAttr(data []byte, f func(typ uint16, val []byte)) {
+	for len(data) >= sizeofRtAttr {
+		l := int(binary.NativeEndian.Uint16(data[0:2]))
+		if l < sizeofRtAttr || l > len(data) {
+			return
+		}
+		f(binary.NativeEndian.Uint16(data[2:4])&^unix.NLA_F_NESTED,
+			data[sizeofRtAttr:l])
+		if l = rtaAlign(l); l > len(data) {
+			return
+		}
+		data = data[l:]
+	}
+}
@@ -0,0 +1,381 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// quicALPN is the ALPN protocol ID for QUIC streams.
+const quicALPN = "antler"
+
+// quicCloseTimeout is the maximum time to wait for the peer to finish a stream
+// before closing the connection.
+const quicCloseTimeout = 5 * time.Second
+
+// QUICStreamServer is the server for streams over QUIC. It's the QUIC analog
+// of StreamServer, and serves the same streamers (Upload, Download and RPC),
+// one per QUIC connection.
+//
+// The server uses a self-signed certificate that the client doesn't verify, so
+// as with StreamServer, Key should be used to authenticate clients.
+type QUICStreamServer struct {
+	// ListenAddr is the listen address, as specified to the address parameter
+	// in net.ListenPacket (e.g. ":port" or "addr:port").
+	ListenAddr string
+
+	// ListenAddrKey is the key used in the returned Feedback for the listen
+	// address, obtained using Listen.Addr.String(). If empty, the listen
+	// address will not be included in the Feedback.
+	ListenAddrKey string
+
+	// Protocol is the protocol to use (udp, udp4 or udp6).
+	Protocol string
+
+	// Key is a security key for HMAC verification.
+	Key []byte
+
+	stream *StreamServer
+	errc   chan error
+}
+
+// Run implements runner
+func (s *QUICStreamServer) Run(ctx context.Context, arg runArg) (ofb Feedback,
+	err error) {
+	var t *tls.Config
+	if t, err = quicServerTLS(); err != nil {
+		return
+	}
+	c := net.ListenConfig{}
+	var p net.PacketConn
+	if p, err = c.ListenPacket(ctx, s.Protocol, s.ListenAddr); err != nil {
+		return
+	}
+	var l *quic.Listener
+	if l, err = quic.Listen(p, t, &quic.Config{}); err != nil {
+		p.Close()
+		return
+	}
+	if s.ListenAddrKey != "" {
+		ofb = Feedback{s.ListenAddrKey: l.Addr().String()}
+	}
+	s.stream = &StreamServer{Key: s.Key}
+	if len(s.Key) > 0 {
+		s.stream.nonce = make(map[string]struct{})
+	}
+	s.errc = make(chan error)
+	s.start(ctx, l, p, arg)
+	arg.cxl <- s
+	return
+}
+
+// Cancel implements canceler
+func (s *QUICStreamServer) Cancel() error {
+	return <-s.errc
+}
+
+// SetKey implements SetKeyer
+func (s *QUICStreamServer) SetKey(key []byte) {
+	s.Key = key
+}
+
+// start starts the main and accept goroutines.
+func (s *QUICStreamServer) start(ctx context.Context, lst *quic.Listener,
+	pconn net.PacketConn, arg runArg) {
+	ec := make(chan error)
+	cc := make(chan quic.Connection)
+	// accept goroutine
+	go func() {
+		var e error
+		defer func() {
+			if e != nil {
+				ec <- e
+			}
+			ec <- errDone
+		}()
+		for {
+			var c quic.Connection
+			if c, e = lst.Accept(context.Background()); e != nil {
+				return
+			}
+			cc <- c
+		}
+	}()
+	// main goroutine
+	go func() {
+		var err error
+		defer func() {
+			if err != nil {
+				s.errc <- err
+			}
+			close(s.errc)
+		}()
+		d := ctx.Done()
+		g := 1
+		for g > 0 {
+			select {
+			case c := <-cc:
+				if d == nil {
+					c.CloseWithError(0, "")
+					break
+				}
+				g++
+				go s.serve(ctx, c, arg, ec)
+			case <-d:
+				d = nil
+				err = lst.Close()
+				if e := pconn.Close(); e != nil && err == nil {
+					err = e
+				}
+			case e := <-ec:
+				if e == errDone {
+					g--
+					break
+				}
+				if d == nil {
+					break
+				}
+				arg.rec.SendErrore(e)
+			}
+		}
+	}()
+}
+
+// serve serves one connection.
+func (s *QUICStreamServer) serve(ctx context.Context, conn quic.Connection,
+	arg runArg, errc chan error) {
+	var e error
+	defer func() {
+		if e != nil {
+			errc <- e
+		}
+		errc <- errDone
+	}()
+	var t quic.Stream
+	if t, e = conn.AcceptStream(ctx); e != nil {
+		conn.CloseWithError(0, "")
+		return
+	}
+	c := quicConn{t, conn}
+	var m streamer
+	if m, e = s.stream.header(c); e != nil {
+		conn.CloseWithError(0, "")
+		return
+	}
+	if e = m.handleServer(ctx, c, arg); e != nil {
+		conn.CloseWithError(0, "")
+		return
+	}
+	t.Close()
+	w := time.NewTimer(quicCloseTimeout)
+	defer w.Stop()
+	select {
+	case <-conn.Context().Done():
+	case <-w.C:
+		conn.CloseWithError(0, "")
+	case <-ctx.Done():
+		conn.CloseWithError(0, "")
+	}
+}
+
+// validate implements validater
+func (s *QUICStreamServer) validate() (err error) {
+	if s.ListenAddr == "" && s.ListenAddrKey == "" {
+		err = fmt.Errorf("either ListenAddr or ListenAddrKey must be set in "+
+			"QUICStreamServer: %+v", s)
+	}
+	return
+}
+
+// QUICStreamClient is the client for streams over QUIC. It's the QUIC analog
+// of StreamClient, and runs the same streamers, each on one stream of a new
+// QUIC connection.
+//
+// QUIC congestion control is implemented in userspace by quic-go, so the CCA
+// and other Sockopts can't be set, and TCPInfo isn't available. StreamIO
+// sampling works as for StreamClient.
+type QUICStreamClient struct {
+	// Addr is the dial address, as specified to the address parameter in
+	// net.Dial (e.g. "addr:port").
+	Addr string
+
+	// AddrKey is a key used to obtain the dial address from the incoming
+	// Feedback, if Addr is not specified.
+	AddrKey string
+
+	// Protocol is the protocol to use (udp, udp4 or udp6).
+	Protocol string
+
+	// Key is a security key for HMAC signing.
+	Key []byte
+
+	Streamers
+}
+
+// Run implements runner
+func (s *QUICStreamClient) Run(ctx context.Context, arg runArg) (ofb Feedback,
+	err error) {
+	var a string
+	if a, err = (&StreamClient{Addr: s.Addr, AddrKey: s.AddrKey}).addr(
+		arg.ifb); err != nil {
+		return
+	}
+	var u *net.UDPAddr
+	if u, err = net.ResolveUDPAddr(s.Protocol, a); err != nil {
+		return
+	}
+	var p net.PacketConn
+	if p, err = net.ListenPacket(s.Protocol, ":0"); err != nil {
+		return
+	}
+	defer p.Close()
+	t := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{quicALPN}}
+	var c quic.Connection
+	if c, err = quic.Dial(ctx, p, u, t, &quic.Config{}); err != nil {
+		return
+	}
+	defer c.CloseWithError(0, "")
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+			return
+		}
+		w := time.NewTimer(time.Second)
+		defer w.Stop()
+		select {
+		case <-w.C:
+			arg.rec.Logf("QUICStreamClient closing after 1s cancel timeout")
+			c.CloseWithError(0, "")
+		case <-done:
+		}
+	}()
+	var m quic.Stream
+	if m, err = c.OpenStreamSync(ctx); err != nil {
+		return
+	}
+	q := quicConn{m, c}
+	r := s.streamer()
+	var h []byte
+	if h, err = (&StreamClient{Key: s.Key}).header(r); err != nil {
+		return
+	}
+	if _, err = q.Write(h); err != nil {
+		return
+	}
+	if err = r.handleClient(ctx, q, arg); err != nil {
+		return
+	}
+	// close our side, and wait for the server to finish its side, so that
+	// closing the connection doesn't discard data in flight
+	m.Close()
+	m.SetReadDeadline(time.Now().Add(quicCloseTimeout))
+	_, err = io.Copy(io.Discard, m)
+	return
+}
+
+// SetKey implements SetKeyer
+func (s *QUICStreamClient) SetKey(key []byte) {
+	s.Key = key
+}
+
+// validate implements validater
+func (s *QUICStreamClient) validate() (err error) {
+	if err = s.Streamers.validate(); err != nil {
+		return
+	}
+	if s.Addr == "" && s.AddrKey == "" {
+		err = fmt.Errorf("either Addr or AddrKey must be set in "+
+			"QUICStreamClient: %+v", s)
+		return
+	}
+	var x Transfer
+	var o, r Sockopts
+	switch {
+	case s.Upload != nil:
+		x, o, r = s.Upload.Transfer, s.Upload.Sockopts, s.Upload.Receiver
+	case s.Download != nil:
+		x, o, r = s.Download.Transfer, s.Download.Sockopts,
+			s.Download.Receiver
+	case s.RPC != nil:
+		o = s.RPC.Sockopts
+	}
+	if x.TCPInfoInterval > 0 {
+		err = fmt.Errorf("TCPInfo is not available for QUICStreamClient "+
+			"flow %s", x.Flow)
+		return
+	}
+	if !o.empty() || !r.empty() {
+		err = fmt.Errorf("Sockopts may not be set for QUICStreamClient: %+v",
+			o)
+	}
+	return
+}
+
+// quicConn is a net.Conn for a QUIC stream.
+type quicConn struct {
+	quic.Stream
+	conn quic.Connection
+}
+
+// Read implements net.Conn. quic-go may return io.EOF along with the last bytes
+// of a stream, which the streamers don't expect, so it's left for the next
+// call to return, as for a TCP conn.
+func (c quicConn) Read(b []byte) (n int, err error) {
+	if n, err = c.Stream.Read(b); n > 0 && err == io.EOF {
+		err = nil
+	}
+	return
+}
+
+// LocalAddr implements net.Conn
+func (c quicConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr implements net.Conn
+func (c quicConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// quicServerTLS returns a TLS config for the server, with a new self-signed
+// certificate.
+func quicServerTLS() (config *tls.Config, err error) {
+	var k *ecdsa.PrivateKey
+	if k, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		return
+	}
+	t := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "antler"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour * 365),
+	}
+	var d []byte
+	if d, err = x509.CreateCertificate(rand.Reader, &t, &t, &k.PublicKey,
+		k); err != nil {
+		return
+	}
+	config = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{d},
+			PrivateKey: k}},
+		NextProtos: []string{quicALPN},
+	}
+	return
+}
@@ -3,13 +3,18 @@
 
 package node
 
+import "github.com/heistp/antler/node/metric"
+
 // recorder is a helper used for logging, recording data points and creating
 // Error's. recorder must be created using newRecorder, and is safe for
-// concurrent use.
+// concurrent use. LogEntry's sent by the recorder and its copies from WithTag
+// are limited by a shared logLimiter, and FileData by a shared fileSender.
 type recorder struct {
 	nodeID ID
 	tag    string
 	parent *conn
+	limit  *logLimiter
+	file   *fileSender
 	LogFactory
 	ErrorFactory
 }
@@ -20,6 +25,8 @@ func newRecorder(nodeID ID, tag string, parent *conn) *recorder {
 		nodeID,
 		tag,
 		parent,
+		&logLimiter{},
+		&fileSender{node: nodeID},
 		LogFactory{nodeID, tag},
 		ErrorFactory{nodeID, tag},
 	}
@@ -31,6 +38,8 @@ func (r *recorder) WithTag(tag string) *recorder {
 		r.nodeID,
 		tag,
 		r.parent,
+		r.limit,
+		r.file,
 		LogFactory{r.nodeID, tag},
 		ErrorFactory{r.nodeID, tag},
 	}
@@ -46,9 +55,54 @@ func (r *recorder) Log(message string) {
 	r.Send(r.NewLogEntry(message))
 }
 
-// FileData sends a FileData.
+// Debugf sends a LogEntry at LogDebug using printf style args.
+func (r *recorder) Debugf(format string, a ...any) {
+	l := r.NewLogEntryf(format, a...)
+	l.Level = LogDebug
+	r.Send(l)
+}
+
+// Warnf sends a LogEntry at LogWarn using printf style args.
+func (r *recorder) Warnf(format string, a ...any) {
+	l := r.NewLogEntryf(format, a...)
+	l.Level = LogWarn
+	r.Send(l)
+}
+
+// SetLogLimit sets the LogLimit for the recorder and all of its copies.
+func (r *recorder) SetLogLimit(limit LogLimit) {
+	r.limit.set(limit)
+}
+
+// FlushLog sends warnings for any LogEntry's dropped by the LogLimit that
+// haven't been reported yet.
+func (r *recorder) FlushLog() {
+	for _, l := range r.limit.flush(r.nodeID) {
+		r.parent.Send(l)
+	}
+}
+
+// FileData sends data for the named file as one or more FileData chunks,
+// subject to the FileRate limit. If the node fails or is interrupted while
+// waiting for the rate limit, the rest of the data is dropped, with a warning.
 func (r *recorder) FileData(name string, data []byte) {
-	r.Send(FileData{name, data})
+	r.fileData(name, data, true)
+}
+
+// fileData sends data for the named file as one or more FileData chunks, and
+// applies the FileRate limit if limit is true.
+func (r *recorder) fileData(name string, data []byte, limit bool) {
+	if err := r.file.send(name, data, limit, func(f FileData) {
+		r.parent.Send(f)
+	}); err != nil {
+		r.Warnf("FileData %s incomplete: %s", name, err)
+	}
+}
+
+// SetFileRate sets the maximum rate at which FileData is sent by the recorder
+// and all of its copies, or zero for no limit.
+func (r *recorder) SetFileRate(rate metric.Bitrate) {
+	r.file.setRate(rate)
 }
 
 // Stream sends a Stream filter to the parent conn.
@@ -71,8 +125,15 @@ func (r *recorder) SendErrorf(format string, a ...any) {
 	r.Send(r.NewErrorf(format, a...))
 }
 
-// Send sends a message to the parent conn.
+// Send sends a message to the parent conn. LogEntry's are subject to the
+// LogLimit.
 func (r *recorder) Send(msg message) {
+	if l, ok := msg.(LogEntry); ok {
+		for _, l := range r.limit.admit(l) {
+			r.parent.Send(l)
+		}
+		return
+	}
 	r.parent.Send(msg)
 }
 
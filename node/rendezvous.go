@@ -0,0 +1,319 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/heistp/antler/node/metric"
+)
+
+// rendezvousRetry is the time between attempts to connect to the coordinator.
+const rendezvousRetry = time.Second
+
+// Rendezvous is a runner that synchronizes the start of complementary Tests
+// run by multiple antler controllers (e.g. at two sites, one sending and one
+// receiving across a WAN), and exchanges Feedback between them.
+//
+// One controller is the coordinator, which sets Listen and waits for Peers
+// other controllers to connect to it at Addr. When all have joined, the
+// coordinator chooses a start time Delay in the future, and sends it to each
+// peer, along with the Feedback from all controllers. Each controller then
+// waits until the start time before returning, so the runners after it in a
+// Serial Run start simultaneously.
+//
+// The start time is adjusted on each peer by its estimated clock offset from
+// the coordinator, so the start is simultaneous to within the network delay
+// variation, even if clocks aren't synchronized. Still, synchronized clocks
+// (e.g. with NTP or PTP) are required for one-way delay measurements between
+// sites.
+type Rendezvous struct {
+	// Name identifies this controller, e.g. the site name. Names must be
+	// unique among the controllers.
+	Name string
+
+	// Listen, if not empty, is the listen address, and makes this controller
+	// the coordinator.
+	Listen string
+
+	// Addr is the coordinator's address, if this controller is a peer.
+	Addr string
+
+	// Peers is the number of peers the coordinator waits for.
+	Peers int
+
+	// Delay is how long after all peers have joined that the Tests start. It's
+	// only used by the coordinator.
+	Delay metric.Duration
+
+	// Timeout is the maximum time to wait for all peers to join.
+	Timeout metric.Duration
+
+	// MaxOffset, if not zero, is the maximum clock offset allowed between a
+	// peer and the coordinator.
+	MaxOffset metric.Duration
+
+	// TokenEnv, if not empty, is the name of an environment variable on the
+	// node containing a token that must match between the controllers.
+	TokenEnv string
+
+	// Feedback contains key/value pairs sent to the other controllers, in
+	// addition to the incoming Feedback. Feedback received from another
+	// controller is returned with its keys prefixed by the controller's Name
+	// and a dot, e.g. siteb.addr.
+	Feedback map[string]string
+}
+
+// rendezvousHello is sent by a peer to the coordinator.
+type rendezvousHello struct {
+	Name     string
+	Token    string
+	Feedback map[string]any
+}
+
+// rendezvousStart is sent by the coordinator to each peer.
+type rendezvousStart struct {
+	// Start is the start time, in the coordinator's clock.
+	Start time.Time
+
+	// Recv and Send are the times the hello was received and this message
+	// sent, used to estimate the clock offset.
+	Recv time.Time
+	Send time.Time
+
+	// Feedback contains the Feedback from each controller, by Name.
+	Feedback map[string]map[string]any
+
+	// Error is set if the peer was rejected.
+	Error string
+}
+
+// rendezvousPeer is a peer that has joined the coordinator.
+type rendezvousPeer struct {
+	conn  net.Conn
+	hello rendezvousHello
+	recv  time.Time
+}
+
+// Run implements runner
+func (r *Rendezvous) Run(ctx context.Context, arg runArg) (ofb Feedback,
+	err error) {
+	var t string
+	if r.TokenEnv != "" {
+		if t = os.Getenv(r.TokenEnv); t == "" {
+			err = fmt.Errorf("Rendezvous token variable %s is not set",
+				r.TokenEnv)
+			return
+		}
+	}
+	f := r.feedback(arg.ifb)
+	var s rendezvousStart
+	var o time.Duration
+	if r.Listen != "" {
+		s, err = r.coordinate(ctx, arg, t, f)
+	} else {
+		s, o, err = r.join(ctx, arg, t, f)
+	}
+	if err != nil {
+		return
+	}
+	ofb = Feedback{}
+	for n, ff := range s.Feedback {
+		if n == r.Name {
+			continue
+		}
+		for k, v := range ff {
+			ofb[n+"."+k] = v
+		}
+	}
+	l := s.Start.Add(-o)
+	arg.rec.Logf("starting at %s", l.Format(time.RFC3339Nano))
+	w := time.NewTimer(time.Until(l))
+	defer w.Stop()
+	select {
+	case <-w.C:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return
+}
+
+// feedback returns the Feedback to send to the other controllers. Only
+// incoming Feedback values with string, boolean or numeric types are sent.
+func (r *Rendezvous) feedback(ifb Feedback) (f map[string]any) {
+	f = make(map[string]any)
+	for k, v := range ifb {
+		switch v.(type) {
+		case string, bool, int, int32, int64, uint, uint32, uint64, float32,
+			float64:
+			f[k] = v
+		}
+	}
+	for k, v := range r.Feedback {
+		f[k] = v
+	}
+	return
+}
+
+// coordinate waits for the peers to join, then sends each the start time and
+// Feedback.
+func (r *Rendezvous) coordinate(ctx context.Context, arg runArg, token string,
+	fb map[string]any) (start rendezvousStart, err error) {
+	c, x := context.WithTimeout(ctx, r.Timeout.Duration())
+	defer x()
+	var l net.Listener
+	if l, err = (&net.ListenConfig{}).Listen(c, "tcp", r.Listen); err != nil {
+		return
+	}
+	go func() {
+		<-c.Done()
+		l.Close()
+	}()
+	arg.rec.Logf("waiting for %d peers on %s", r.Peers, l.Addr())
+	var pp []rendezvousPeer
+	defer func() {
+		for _, p := range pp {
+			p.conn.Close()
+		}
+	}()
+	start.Feedback = map[string]map[string]any{r.Name: fb}
+	for len(pp) < r.Peers {
+		var n net.Conn
+		if n, err = l.Accept(); err != nil {
+			if c.Err() != nil {
+				err = fmt.Errorf("%d of %d peers joined before timeout",
+					len(pp), r.Peers)
+			}
+			return
+		}
+		p := rendezvousPeer{conn: n}
+		n.SetDeadline(time.Now().Add(r.Timeout.Duration()))
+		if e := json.NewDecoder(n).Decode(&p.hello); e != nil {
+			arg.rec.Logf("invalid hello from %s: %s", n.RemoteAddr(), e)
+			n.Close()
+			continue
+		}
+		p.recv = time.Now()
+		if e := r.check(p.hello, token, start.Feedback); e != nil {
+			arg.rec.Logf("rejected %s from %s: %s", p.hello.Name,
+				n.RemoteAddr(), e)
+			json.NewEncoder(n).Encode(rendezvousStart{Error: e.Error()})
+			n.Close()
+			continue
+		}
+		arg.rec.Logf("%s joined from %s", p.hello.Name, n.RemoteAddr())
+		start.Feedback[p.hello.Name] = p.hello.Feedback
+		pp = append(pp, p)
+	}
+	start.Start = time.Now().Add(r.Delay.Duration())
+	for _, p := range pp {
+		s := start
+		s.Recv = p.recv
+		s.Send = time.Now()
+		if err = json.NewEncoder(p.conn).Encode(s); err != nil {
+			err = fmt.Errorf("unable to send start to %s: %w", p.hello.Name,
+				err)
+			return
+		}
+	}
+	return
+}
+
+// check returns an error if a peer's hello is invalid.
+func (r *Rendezvous) check(hello rendezvousHello, token string,
+	joined map[string]map[string]any) error {
+	if hello.Token != token {
+		return errors.New("invalid token")
+	}
+	if hello.Name == "" {
+		return errors.New("empty name")
+	}
+	if _, ok := joined[hello.Name]; ok {
+		return fmt.Errorf("name %s already joined", hello.Name)
+	}
+	return nil
+}
+
+// join connects to the coordinator, retrying until Timeout, sends the hello and
+// waits for the start time. The estimated offset of the coordinator's clock
+// from this node's clock is returned.
+func (r *Rendezvous) join(ctx context.Context, arg runArg, token string,
+	fb map[string]any) (start rendezvousStart, offset time.Duration,
+	err error) {
+	c, x := context.WithTimeout(ctx, r.Timeout.Duration())
+	defer x()
+	var n net.Conn
+	for {
+		d := net.Dialer{}
+		if n, err = d.DialContext(c, "tcp", r.Addr); err == nil {
+			break
+		}
+		t := time.NewTimer(rendezvousRetry)
+		select {
+		case <-t.C:
+		case <-c.Done():
+			t.Stop()
+			err = fmt.Errorf("unable to join coordinator at %s: %w", r.Addr,
+				err)
+			return
+		}
+	}
+	defer n.Close()
+	go func() {
+		<-c.Done()
+		n.SetDeadline(time.Now())
+	}()
+	arg.rec.Logf("joined coordinator at %s, waiting for start", r.Addr)
+	t1 := time.Now()
+	h := rendezvousHello{r.Name, token, fb}
+	if err = json.NewEncoder(n).Encode(h); err != nil {
+		return
+	}
+	if err = json.NewDecoder(bufio.NewReader(n)).Decode(&start); err != nil {
+		if c.Err() != nil {
+			err = fmt.Errorf("timed out waiting for start from %s", r.Addr)
+		}
+		return
+	}
+	t4 := time.Now()
+	if start.Error != "" {
+		err = fmt.Errorf("rejected by coordinator at %s: %s", r.Addr,
+			start.Error)
+		return
+	}
+	offset = (start.Recv.Sub(t1) + start.Send.Sub(t4)) / 2
+	arg.rec.Logf("estimated clock offset from coordinator: %s", offset)
+	if m := r.MaxOffset.Duration(); m > 0 && (offset > m || offset < -m) {
+		err = fmt.Errorf("clock offset from coordinator %s exceeds "+
+			"MaxOffset %s", offset, m)
+	}
+	return
+}
+
+// validate implements validater
+func (r *Rendezvous) validate() (err error) {
+	if r.Name == "" {
+		err = fmt.Errorf("Rendezvous requires a Name")
+		return
+	}
+	if (r.Listen == "") == (r.Addr == "") {
+		err = fmt.Errorf("Rendezvous requires one of Listen or Addr")
+		return
+	}
+	if r.Listen != "" && r.Peers <= 0 {
+		err = fmt.Errorf("Rendezvous Peers must be positive: %d", r.Peers)
+		return
+	}
+	if r.Timeout <= 0 {
+		err = fmt.Errorf("Rendezvous Timeout must be positive: %s", r.Timeout)
+	}
+	return
+}
@@ -6,6 +6,8 @@ package node
 import (
 	"context"
 	"path/filepath"
+
+	"github.com/heistp/antler/node/metric"
 )
 
 // ResultStream selects messages for either streaming or buffering.
@@ -15,6 +17,13 @@ type ResultStream struct {
 
 	// Exclude rejects messages to stream, and buffers them instead.
 	Exclude *MessageFilter
+
+	// MaxRate, if not zero, caps the bitrate of streamed messages sent by the
+	// node to its parent, so that streaming doesn't compete meaningfully with
+	// measurement traffic on shared links. Messages that can't be sent within
+	// the cap are queued. Buffered messages sent at the end of the Test are not
+	// limited.
+	MaxRate metric.Bitrate
 }
 
 // Run implements runner
@@ -96,6 +105,11 @@ func (f *MessageFilter) accept(msg message) (verdict bool) {
 
 // validate returns an error if the MessageFilter is invalid.
 func (f *MessageFilter) validate() (err error) {
+	for _, l := range f.Flow {
+		if err = l.validate(); err != nil {
+			return
+		}
+	}
 	for _, p := range f.File {
 		if _, err = filepath.Match(p, ""); err != nil {
 			return
@@ -0,0 +1,376 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/heistp/antler/node/metric"
+)
+
+const (
+	// rpcHeaderLen is the length of the header at the start of each request,
+	// containing the request and response lengths.
+	rpcHeaderLen = 8
+
+	// rpcMaxLength is the maximum request or response length.
+	rpcMaxLength = 16 * 1024 * 1024
+
+	// rpcMaxPending is the maximum number of requests awaiting a response,
+	// after which the sender waits for responses before sending more.
+	rpcMaxPending = 1024
+
+	// rpcFill is the fill byte for requests and responses.
+	rpcFill byte = 0xf0
+)
+
+// RPC is a streamer that sends requests and receives responses over a
+// persistent connection, and records the latency of each transaction. It
+// models RPC and database workloads, whose tail latency often differs markedly
+// from that of bulk transfers.
+//
+// Requests are sent on a schedule, regardless of whether prior responses have
+// been received, so the server's responses may queue behind one another. The
+// server handles requests in order.
+type RPC struct {
+	// Flow is the RPC's flow identifier.
+	Flow Flow
+
+	// Duration is how long to send requests.
+	Duration metric.Duration
+
+	// Interval lists the times between the start of each request, which are
+	// cycled through either sequentially or randomly (according to
+	// RandomInterval).
+	Interval []metric.Duration
+
+	// RandomInterval, if true, selects times from Interval randomly.
+	RandomInterval bool
+
+	// RequestLength lists the request lengths, including an eight byte header,
+	// which are cycled through either sequentially or randomly (according to
+	// RandomRequestLength).
+	RequestLength []metric.Bytes
+
+	// RandomRequestLength, if true, selects lengths from RequestLength
+	// randomly.
+	RandomRequestLength bool
+
+	// ResponseLength lists the response lengths, which are cycled through
+	// either sequentially or randomly (according to RandomResponseLength).
+	ResponseLength []metric.Bytes
+
+	// RandomResponseLength, if true, selects lengths from ResponseLength
+	// randomly.
+	RandomResponseLength bool
+
+	// Sockopts provides support for socket options.
+	Sockopts
+
+	intervalIndex int        // current index in Interval
+	requestIndex  int        // current index in RequestLength
+	responseIndex int        // current index in ResponseLength
+	rand          *rand.Rand // random number source
+}
+
+// init registers RPC with the gob encoder
+func init() {
+	gob.Register(RPC{})
+}
+
+// rpcPending is a request awaiting its response.
+type rpcPending struct {
+	seq      Seq
+	t        metric.RelativeTime
+	request  metric.Bytes
+	response metric.Bytes
+}
+
+// handleClient implements streamer
+func (r RPC) handleClient(ctx context.Context, conn net.Conn,
+	arg runArg) (err error) {
+	arg.rec.Send(RPCInfo{metric.Tinit, r.Flow, false})
+	p := make(chan rpcPending, rpcMaxPending)
+	rc := make(chan error, 1)
+	go r.receive(conn, p, rc, arg)
+	defer func() {
+		if p != nil {
+			close(p)
+		}
+	}()
+	var b []byte
+	var q Seq
+	t0 := time.Now()
+	end := t0.Add(r.Duration.Duration())
+	for at := t0; at.Before(end); at = at.Add(r.nextInterval()) {
+		w := time.NewTimer(time.Until(at))
+		select {
+		case <-w.C:
+		case err = <-rc:
+			w.Stop()
+			return
+		case <-ctx.Done():
+			w.Stop()
+			err = context.Cause(ctx)
+			return
+		}
+		l := max(r.nextLength(r.RequestLength, r.RandomRequestLength,
+			&r.requestIndex), rpcHeaderLen)
+		k := max(r.nextLength(r.ResponseLength, r.RandomResponseLength,
+			&r.responseIndex), 1)
+		if int(l) > len(b) {
+			b = make([]byte, l)
+			for i := range b {
+				b[i] = rpcFill
+			}
+		}
+		binary.LittleEndian.PutUint32(b[0:4], uint32(l))
+		binary.LittleEndian.PutUint32(b[4:8], uint32(k))
+		t := metric.Now()
+		if _, err = conn.Write(b[:l]); err != nil {
+			return
+		}
+		select {
+		case p <- rpcPending{q, t, l, k}:
+		case err = <-rc:
+			return
+		case <-ctx.Done():
+			err = context.Cause(ctx)
+			return
+		}
+		q++
+	}
+	close(p)
+	p = nil
+	if c, ok := conn.(*net.TCPConn); ok {
+		if err = c.CloseWrite(); err != nil {
+			return
+		}
+	}
+	select {
+	case err = <-rc:
+	case <-ctx.Done():
+		err = context.Cause(ctx)
+	}
+	return
+}
+
+// receive reads the responses for the pending requests, in order, and records
+// an RPCTransaction for each. The result is sent on rc.
+func (r RPC) receive(conn net.Conn, pending chan rpcPending,
+	rc chan error, arg runArg) {
+	var err error
+	defer func() {
+		rc <- err
+		for range pending {
+		}
+	}()
+	var b []byte
+	for p := range pending {
+		if int(p.response) > len(b) {
+			b = make([]byte, p.response)
+		}
+		if _, err = io.ReadFull(conn, b[:p.response]); err != nil {
+			return
+		}
+		t := metric.Now()
+		arg.rec.Send(RPCTransaction{r.Flow, p.seq, p.t, time.Duration(t - p.t),
+			p.request, p.response})
+	}
+}
+
+// handleServer implements streamer
+func (r RPC) handleServer(ctx context.Context, conn net.Conn,
+	arg runArg) (err error) {
+	if err = r.Sockopts.setTCP(conn); err != nil {
+		return
+	}
+	arg.rec.Send(RPCInfo{metric.Tinit, r.Flow, true})
+	h := make([]byte, rpcHeaderLen)
+	var b []byte
+	for {
+		if _, err = io.ReadFull(conn, h); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = nil
+			}
+			return
+		}
+		l := binary.LittleEndian.Uint32(h[0:4])
+		k := binary.LittleEndian.Uint32(h[4:8])
+		if l < rpcHeaderLen || l > rpcMaxLength || k > rpcMaxLength {
+			err = fmt.Errorf("invalid RPC request length %d or response "+
+				"length %d", l, k)
+			return
+		}
+		if _, err = io.CopyN(io.Discard, conn,
+			int64(l-rpcHeaderLen)); err != nil {
+			return
+		}
+		if int(k) > len(b) {
+			b = make([]byte, k)
+			for i := range b {
+				b[i] = rpcFill
+			}
+		}
+		if _, err = conn.Write(b[:k]); err != nil {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			err = context.Cause(ctx)
+			return
+		default:
+		}
+	}
+}
+
+// nextInterval returns the next interval.
+func (r *RPC) nextInterval() time.Duration {
+	return time.Duration(r.Interval[r.next(len(r.Interval), r.RandomInterval,
+		&r.intervalIndex)])
+}
+
+// nextLength returns the next length from the given lengths.
+func (r *RPC) nextLength(length []metric.Bytes, random bool,
+	index *int) metric.Bytes {
+	if len(length) == 0 {
+		return 0
+	}
+	return length[r.next(len(length), random, index)]
+}
+
+// next returns the next index for a list of length n, either randomly, or
+// sequentially using the given current index.
+func (r *RPC) next(n int, random bool, index *int) (i int) {
+	if random {
+		if r.rand == nil {
+			r.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+		}
+		return r.rand.Intn(n)
+	}
+	i = *index
+	if *index++; *index >= n {
+		*index = 0
+	}
+	return
+}
+
+// validate implements validater
+func (r RPC) validate() (err error) {
+	if err = r.Flow.validate(); err != nil {
+		err = fmt.Errorf("invalid Flow in RPC: %w", err)
+		return
+	}
+	if len(r.Interval) == 0 {
+		err = fmt.Errorf("RPC %s requires at least one Interval", r.Flow)
+		return
+	}
+	for _, i := range r.Interval {
+		if i <= 0 {
+			err = fmt.Errorf("RPC %s Interval must be positive: %s", r.Flow, i)
+			return
+		}
+	}
+	for _, l := range append(r.RequestLength, r.ResponseLength...) {
+		if l > rpcMaxLength {
+			err = fmt.Errorf("RPC %s length %d exceeds maximum of %d",
+				r.Flow, l, rpcMaxLength)
+			return
+		}
+	}
+	return
+}
+
+func (r RPC) String() string {
+	return fmt.Sprintf("RPC[Flow:%s]", r.Flow)
+}
+
+// RPCInfo contains information for an RPC flow.
+type RPCInfo struct {
+	// Tinit is the base time for the flow's RelativeTime values.
+	Tinit time.Time
+
+	// Flow is the flow identifier.
+	Flow Flow
+
+	// Server indicates if this is from the server (true) or client (false).
+	Server bool
+}
+
+// init registers RPCInfo with the gob encoder
+func init() {
+	gob.Register(RPCInfo{})
+}
+
+// Time returns an absolute from a node-relative time.
+func (r RPCInfo) Time(t metric.RelativeTime) time.Time {
+	return r.Tinit.Add(time.Duration(t))
+}
+
+// flags implements message
+func (RPCInfo) flags() flag {
+	return flagForward
+}
+
+// handle implements event
+func (r RPCInfo) handle(node *node) {
+	node.parent.Send(r)
+}
+
+func (r RPCInfo) String() string {
+	return fmt.Sprintf("RPCInfo[Tinit:%s Flow:%s Server:%t]",
+		r.Tinit, r.Flow, r.Server)
+}
+
+// RPCTransaction is a time series data point that records one completed RPC
+// request and response.
+type RPCTransaction struct {
+	// Flow is the flow that this RPCTransaction is for.
+	Flow Flow
+
+	// Seq is the sequence number of the request, starting from zero.
+	Seq Seq
+
+	// T is the relative time the request was sent.
+	T metric.RelativeTime
+
+	// Latency is the time from sending the request until the full response
+	// was received.
+	Latency time.Duration
+
+	// RequestLength is the length of the request.
+	RequestLength metric.Bytes
+
+	// ResponseLength is the length of the response.
+	ResponseLength metric.Bytes
+}
+
+// init registers RPCTransaction with the gob encoder
+func init() {
+	gob.Register(RPCTransaction{})
+}
+
+// flags implements message
+func (RPCTransaction) flags() flag {
+	return flagForward
+}
+
+// handle implements event
+func (r RPCTransaction) handle(node *node) {
+	node.parent.Send(r)
+}
+
+func (r RPCTransaction) String() string {
+	return fmt.Sprintf("RPCTransaction[Flow:%s Seq:%d T:%s Latency:%s "+
+		"RequestLength:%s ResponseLength:%s]", r.Flow, r.Seq, r.T, r.Latency,
+		r.RequestLength, r.ResponseLength)
+}
@@ -5,6 +5,7 @@ package node
 
 import (
 	"context"
+	"encoding/gob"
 	"fmt"
 	"math/rand"
 	"time"
@@ -21,8 +22,9 @@ import (
 // for sequential, concurrent and child node execution.
 //
 // Run must be created with valid constraints, i.e. each Run must have exactly
-// one of Serial, Parallel, Child or a Runners field set. Run is not safe for
-// concurrent use, though Parallel Runs execute safely, concurrently.
+// one of Serial, Parallel, Schedule, Child, Workload or a Runners field set.
+// Run is not safe for concurrent use, though Parallel Runs execute safely,
+// concurrently.
 type Run struct {
 	// Serial lists Runs to be executed sequentially
 	Serial Serial
@@ -36,6 +38,10 @@ type Run struct {
 	// Child is a Run to be executed on a child Node
 	Child *Child
 
+	// Workload is a standard mix of flows, expanded to a Parallel Run when the
+	// config is loaded.
+	Workload *Workload
+
 	// Runners is a union of the available runner implementations.
 	//
 	// NOTE: In the future, this may be an interface field, if CUE can be made
@@ -55,6 +61,14 @@ func (r *Run) run(ctx context.Context, arg runArg, ev chan event) (
 		ofb, ok = r.Schedule.do(ctx, arg, ev)
 	case r.Child != nil:
 		ofb, ok = r.Child.do(ctx, arg, ev)
+	case r.Workload != nil:
+		var rr []Run
+		var err error
+		if rr, err = r.Workload.runs(); err != nil {
+			ev <- errorEvent{arg.rec.NewErrore(err), false}
+			return
+		}
+		ofb, ok = Parallel(rr).do(ctx, arg, ev)
 	default:
 		ofb, ok = r.Runners.do(ctx, arg, ev)
 	}
@@ -88,6 +102,12 @@ func (r *Run) Validate() (err error) {
 		}
 		n++
 	}
+	if r.Workload != nil {
+		if err = r.Workload.validate(); err != nil {
+			return
+		}
+		n++
+	}
 	if r.Runners != (Runners{}) {
 		if err = r.Runners.validate(); err != nil {
 			return
@@ -108,9 +128,10 @@ type Serial []Run
 func (s Serial) do(ctx context.Context, arg runArg, ev chan event) (
 	ofb Feedback, ok bool) {
 	ofb = Feedback{}
+	a := arg
 	for _, r := range s {
 		var f Feedback
-		f, ok = r.run(ctx, arg, ev)
+		f, ok = r.run(ctx, a, ev)
 		if e := ofb.merge(f); e != nil {
 			ok = false
 			rr := arg.rec.WithTag(typeBaseName(r))
@@ -119,6 +140,7 @@ func (s Serial) do(ctx context.Context, arg runArg, ev chan event) (
 		if !ok {
 			return
 		}
+		a.ifb = arg.ifb.with(ofb)
 	}
 	return
 }
@@ -331,21 +353,41 @@ type runDone struct {
 // Runners is a union of the available runner implementations. Only one of the
 // runners may be non-nil.
 type Runners struct {
-	ResultStream *ResultStream
-	Setup        *setup
-	Sleep        *Sleep
-	SysInfo      *SysInfo
-	System       *System
-	StreamClient *StreamClient
-	StreamServer *StreamServer
-	PacketServer *PacketServer
-	PacketClient *PacketClient
-}
-
-// runner returns the runner.
+	ResultStream     *ResultStream
+	Setup            *setup
+	Sleep            *Sleep
+	SysInfo          *SysInfo
+	System           *System
+	StreamClient     *StreamClient
+	StreamServer     *StreamServer
+	PacketServer     *PacketServer
+	PacketClient     *PacketClient
+	Prereq           *Prereq
+	Profile          *Profile
+	Watchdog         *Watchdog
+	Station          *Station
+	Clock            *Clock
+	Rendezvous       *Rendezvous
+	HTTPClient       *HTTPClient
+	HTTPServer       *HTTPServer
+	QUICStreamClient *QUICStreamClient
+	QUICStreamServer *QUICStreamServer
+	SetSysctl        *SetSysctl
+	KernelModule     *KernelModule
+	PacketCapture    *PacketCapture
+	QdiscStats       *QdiscStats
+	Fetch            *Fetch
+	TinStats         *TinStats
+	Netem            *Netem
+	Topology         *Topology
+	IRTTClient       *IRTTClient
+	Custom           *Custom
+}
+
+// runner returns the runner, or nil if no runner is set.
 func (r *Runners) runner() (rr runner) {
 	var n int
-	if rr, n = r.value(); n != 1 {
+	if rr, n = r.value(); n > 1 {
 		panic(UnionError{r, n}.Error())
 	}
 	return
@@ -403,6 +445,86 @@ func (r *Runners) value() (rr runner, n int) {
 		rr = r.PacketServer
 		n++
 	}
+	if r.Prereq != nil {
+		rr = r.Prereq
+		n++
+	}
+	if r.Profile != nil {
+		rr = r.Profile
+		n++
+	}
+	if r.Watchdog != nil {
+		rr = r.Watchdog
+		n++
+	}
+	if r.Station != nil {
+		rr = r.Station
+		n++
+	}
+	if r.Clock != nil {
+		rr = r.Clock
+		n++
+	}
+	if r.Rendezvous != nil {
+		rr = r.Rendezvous
+		n++
+	}
+	if r.HTTPClient != nil {
+		rr = r.HTTPClient
+		n++
+	}
+	if r.HTTPServer != nil {
+		rr = r.HTTPServer
+		n++
+	}
+	if r.QUICStreamClient != nil {
+		rr = r.QUICStreamClient
+		n++
+	}
+	if r.QUICStreamServer != nil {
+		rr = r.QUICStreamServer
+		n++
+	}
+	if r.SetSysctl != nil {
+		rr = r.SetSysctl
+		n++
+	}
+	if r.KernelModule != nil {
+		rr = r.KernelModule
+		n++
+	}
+	if r.PacketCapture != nil {
+		rr = r.PacketCapture
+		n++
+	}
+	if r.QdiscStats != nil {
+		rr = r.QdiscStats
+		n++
+	}
+	if r.Fetch != nil {
+		rr = r.Fetch
+		n++
+	}
+	if r.TinStats != nil {
+		rr = r.TinStats
+		n++
+	}
+	if r.Netem != nil {
+		rr = r.Netem
+		n++
+	}
+	if r.Topology != nil {
+		rr = r.Topology
+		n++
+	}
+	if r.IRTTClient != nil {
+		rr = r.IRTTClient
+		n++
+	}
+	if r.Custom != nil {
+		rr = r.Custom
+		n++
+	}
 	return
 }
 
@@ -429,6 +551,9 @@ func (r *Runners) do(ctx context.Context, arg runArg, ev chan event) (
 		return
 	}
 	arg.rec = arg.rec.WithTag(typeBaseName(u))
+	if _, ok := u.(*setup); !ok {
+		arg.rec.Send(RunnerStart{time.Now(), arg.rec.nodeID, arg.rec.tag})
+	}
 	var err error
 	ofb, err = u.Run(ctx, arg)
 	if ofb == nil {
@@ -464,6 +589,7 @@ type runArg struct {
 	sockdiag *sockdiag     // access to socket information on Linux
 	rec      *recorder     // recorder for logging, data and errors
 	cxl      chan canceler // canceler stack
+	diagnose *Diagnose     // diagnostics config for the node, set by setup
 }
 
 // canceler is the interface that wraps the Cancel method. If a runner
@@ -498,7 +624,7 @@ type Feedback map[string]any
 // merge merges the given Feedback f2 into this Feedback. An error is returned
 // if any of f2's keys already exist in f.
 func (f Feedback) merge(f2 Feedback) (err error) {
-	for k2, v2 := range f {
+	for k2, v2 := range f2 {
 		if v, ok := f[k2]; ok {
 			err = fmt.Errorf("feedback conflict merging %s=%+v into %s=%+v",
 				k2, v2, k2, v)
@@ -508,3 +634,49 @@ func (f Feedback) merge(f2 Feedback) (err error) {
 	}
 	return
 }
+
+// with returns a new Feedback containing the keys in this Feedback and f2,
+// with those in f2 taking precedence.
+func (f Feedback) with(f2 Feedback) (w Feedback) {
+	w = make(Feedback, len(f)+len(f2))
+	for k, v := range f {
+		w[k] = v
+	}
+	for k, v := range f2 {
+		w[k] = v
+	}
+	return
+}
+
+// RunnerStart is a data point sent when a runner starts on a node, so progress
+// may be followed as a Test runs. It's not sent for the internal setup runner.
+type RunnerStart struct {
+	// Time is the time the runner started, from the node's clock.
+	Time time.Time
+
+	// NodeID is the ID of the node the runner runs on.
+	NodeID ID
+
+	// Runner is the runner's type name (e.g. "StreamClient").
+	Runner string
+}
+
+// init registers RunnerStart with the gob encoder
+func init() {
+	gob.Register(RunnerStart{})
+}
+
+// flags implements message
+func (RunnerStart) flags() flag {
+	return flagForward | flagPush
+}
+
+// handle implements event
+func (s RunnerStart) handle(node *node) {
+	node.parent.Send(s)
+}
+
+func (s RunnerStart) String() string {
+	return fmt.Sprintf("RunnerStart[Time:%s NodeID:%s Runner:%s]",
+		s.Time, s.NodeID, s.Runner)
+}
@@ -3,36 +3,44 @@
 
 package node
 
-/*
-#cgo CFLAGS: -O2 -Wall
-
-#include "sockdiag.h"
-*/
-import "C"
-
 import (
+	"encoding/binary"
 	"encoding/gob"
 	"fmt"
 	"net"
 	"net/netip"
 	"sync"
+	"syscall"
 	"time"
-	"unsafe"
 
 	"github.com/heistp/antler/node/metric"
-	"golang.org/x/sys/unix"
 )
 
+// maxSockFilter is the maximum number of socket addresses that sampler filters
+// for in the kernel. Beyond this, all sockets are returned and filtered in
+// userspace, as the bytecode length is limited by the netlink attribute length.
+const maxSockFilter = 1024
+
 // sockdiag gathers socket statistics using the sock_diag(7) netlink subsystem
 // on Linux. A sampler goroutine is created for each unique sampling interval,
 // as a basic means of timer coalescing. This avoids the need to create a
 // sampling goroutine for each flow. It is possible, though wasteful, to sample
-// the same socket address at multiple different intervals.
+// the same socket address at multiple different intervals. The samplers share
+// a single netlink socket.
+//
+// If sock_diag isn't available (e.g. the inet_diag module isn't loaded, or
+// netlink is restricted in a container or sandbox), the samplers fall back to
+// getsockopt(TCP_INFO) on each socket instead, which returns the same
+// statistics, but requires a system call per socket per sample. On platforms
+// other than Linux, getsockopt is always used (see getsockoptTCPInfo).
 type sockdiag struct {
-	ev      chan event
-	sampler map[time.Duration]*sampler
-	mtx     sync.Mutex
-	cxl     chan struct{}
+	ev         chan event
+	sampler    map[time.Duration]*sampler
+	conn       *sharedSockdiag
+	probed     bool
+	getsockopt bool
+	mtx        sync.Mutex
+	cxl        chan struct{}
 }
 
 // newSockdiag returns a new sockdiag.
@@ -40,6 +48,9 @@ func newSockdiag(ev chan event) *sockdiag {
 	return &sockdiag{
 		ev,
 		make(map[time.Duration]*sampler),
+		&sharedSockdiag{},
+		false,
+		false,
 		sync.Mutex{},
 		make(chan struct{}),
 	}
@@ -47,16 +58,30 @@ func newSockdiag(ev chan event) *sockdiag {
 
 // Add adds the given socket address for TCPInfo sampling at the given interval.
 // Since Flow corresponds to the 5-tuple for TCP, the Flow in the given id
-// must uniquely identify the src and dst socket addresses in addr.
-func (d *sockdiag) Add(addr sockAddr, id TCPInfoID, interval time.Duration) {
+// must uniquely identify the src and dst socket addresses in addr. If
+// maxInterval is greater than interval, sampling is adaptive (see adaptive).
+// The socket's raw conn is used for sampling if sock_diag isn't available, in
+// which case a warning is logged with rec the first time.
+func (d *sockdiag) Add(addr sockAddr, raw syscall.RawConn, id TCPInfoID,
+	interval, maxInterval time.Duration, rec *recorder) {
 	d.mtx.Lock()
 	defer d.mtx.Unlock()
+	if !d.probed {
+		d.probed = true
+		if !haveSockdiag {
+			d.getsockopt = true
+		} else if e := TestSockdiag(); e != nil {
+			d.getsockopt = true
+			rec.Warnf("sock_diag unavailable, sampling TCPInfo with "+
+				"getsockopt: %s", e)
+		}
+	}
 	var s *sampler
 	if s = d.sampler[interval]; s == nil {
-		s = newSampler(d.ev, interval)
+		s = newSampler(d.ev, interval, d.conn, d.getsockopt)
 		d.sampler[interval] = s
 	}
-	s.Add(addr, id)
+	s.Add(addr, raw, id, maxInterval)
 }
 
 // Remove stops sampling for the given sock address, at the given interval.
@@ -83,29 +108,82 @@ func (d *sockdiag) Stop() {
 	}
 }
 
+// sharedSockdiag is a SockdiagConn shared by multiple samplers. The conn is
+// opened on first use, closed after its last user is done, and serializes
+// requests, as responses on a netlink socket may not be interleaved.
+type sharedSockdiag struct {
+	conn *SockdiagConn
+	refs int
+	mtx  sync.Mutex
+}
+
+// open opens the conn, if it's not already open.
+func (s *sharedSockdiag) open() (err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.refs == 0 {
+		if s.conn, err = OpenSockdiag(); err != nil {
+			return
+		}
+	}
+	s.refs++
+	return
+}
+
+// sample calls sample on the conn.
+func (s *sharedSockdiag) sample(ipv6 bool, filter sockFilter) (
+	sample []SockSample, err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.conn.sample(ipv6, filter)
+}
+
+// close closes the conn, if this is the last user.
+func (s *sharedSockdiag) close() (err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.refs--; s.refs == 0 {
+		err = s.conn.Close()
+		s.conn = nil
+	}
+	return
+}
+
 // sampler samples socket statistics on a fixed interval, and sends
 // TCPInfo's with the statistics to the node's event channel.
 type sampler struct {
-	addr     map[sockAddr]TCPInfoID
-	addr4    int
-	addr6    int
-	ev       chan event
-	interval time.Duration
-	mtx      sync.Mutex
-	started  bool
-	cxl      chan struct{}
-	done     chan struct{}
+	addr       map[sockAddr]*sampledSock
+	addr4      int
+	addr6      int
+	filter4    sockFilter
+	filter6    sockFilter
+	dirty      bool
+	ev         chan event
+	interval   time.Duration
+	conn       *sharedSockdiag
+	getsockopt bool
+	mtx        sync.Mutex
+	started    bool
+	cxl        chan struct{}
+	done       chan struct{}
 }
 
 // newSampler returns a new sampler that samples socket statistics on the given
-// interval.
-func newSampler(ev chan event, interval time.Duration) *sampler {
+// interval. If getsockopt is true, sockets are sampled with getsockopt instead
+// of sock_diag.
+func newSampler(ev chan event, interval time.Duration, conn *sharedSockdiag,
+	getsockopt bool) *sampler {
 	return &sampler{
-		make(map[sockAddr]TCPInfoID),
+		make(map[sockAddr]*sampledSock),
 		0,
 		0,
+		nil,
+		nil,
+		false,
 		ev,
 		interval,
+		conn,
+		getsockopt,
 		sync.Mutex{},
 		false,
 		make(chan struct{}),
@@ -114,9 +192,11 @@ func newSampler(ev chan event, interval time.Duration) *sampler {
 }
 
 // Add registers the given socket address to send TCPInfo for, with the given
-// flow id. If this is the first address added, the sampling goroutine is
-// started.
-func (m *sampler) Add(addr sockAddr, id TCPInfoID) {
+// raw conn and flow id. If maxInterval is greater than the sampler's interval,
+// sampling for the address is adaptive. If this is the first address added,
+// the sampling goroutine is started.
+func (m *sampler) Add(addr sockAddr, raw syscall.RawConn, id TCPInfoID,
+	maxInterval time.Duration) {
 	m.mtx.Lock()
 	defer func() {
 		if !m.started && len(m.addr) > 0 {
@@ -131,14 +211,26 @@ func (m *sampler) Add(addr sockAddr, id TCPInfoID) {
 		} else {
 			m.addr6++
 		}
+		m.dirty = true
+	}
+	var a *adaptive
+	if maxInterval > m.interval {
+		a = &adaptive{maxInterval: maxInterval}
 	}
-	m.addr[addr] = id
+	m.addr[addr] = &sampledSock{id, raw, a}
 }
 
 // TCPInfoID contains the flow and location information in TCPInfo.
 type TCPInfoID struct {
 	Flow     Flow
 	Location Location
+
+	// Receiver is true if the TCPInfo is for the receiver's socket, and false
+	// for the sender's.
+	Receiver bool
+
+	// Tags are the Tags from the Stream.
+	Tags Tags
 }
 
 // Remove unregisters the given socket address for sampling.
@@ -151,10 +243,11 @@ func (m *sampler) Remove(addr sockAddr) (empty bool) {
 	if _, ok := m.addr[addr]; ok {
 		delete(m.addr, addr)
 		if addr.Is4() {
-			m.addr4++
+			m.addr4--
 		} else {
-			m.addr6++
+			m.addr6--
 		}
+		m.dirty = true
 	}
 	return
 }
@@ -170,11 +263,16 @@ func (m *sampler) run() {
 			m.ev <- errorEvent{e, false}
 		}
 	}()
-	var fd C.int
-	if fd, e = C.sockdiag_open(); fd < 0 {
-		return
+	if !m.getsockopt {
+		if e = m.conn.open(); e != nil {
+			return
+		}
+		defer func() {
+			if ce := m.conn.close(); ce != nil && e == nil {
+				e = ce
+			}
+		}()
 	}
-	defer C.sockdiag_close(fd)
 	f := true
 	var d bool
 	for !d {
@@ -186,7 +284,7 @@ func (m *sampler) run() {
 				f = false
 				break
 			}
-			if e = m.sample(fd); e != nil {
+			if e = m.sample(); e != nil {
 				d = true
 			}
 		}
@@ -194,46 +292,225 @@ func (m *sampler) run() {
 }
 
 // sample locks the sampler and calls sampleFamily for IPv4 and/or IPv6,
-// according to which IP versions there are registered addresses for.
-func (m *sampler) sample(fd C.int) (err error) {
+// according to which IP versions there are registered addresses for, or
+// sampleSockopt if getsockopt is true.
+func (m *sampler) sample() (err error) {
 	m.mtx.Lock()
 	defer m.mtx.Unlock()
+	if m.getsockopt {
+		err = m.sampleSockopt()
+		return
+	}
+	if m.dirty {
+		m.filter4, m.filter6 = newSockFilters(m.addr)
+		m.dirty = false
+	}
 	if m.addr4 > 0 {
-		if err = m.sampleFamily(fd, unix.AF_INET); err != nil {
+		if err = m.sampleFamily(false, m.filter4); err != nil {
 			return
 		}
 	}
 	if m.addr6 > 0 {
-		err = m.sampleFamily(fd, unix.AF_INET6)
+		err = m.sampleFamily(true, m.filter6)
 	}
 	return
 }
 
-// sampleFamily uses netlink to get tcp_info arrays for the given socket family
-// (AF_INET or AF_INET6), and sends TCPInfo's for each address registered with
-// the sampler.
-func (m *sampler) sampleFamily(fd C.int, family C.uchar) (err error) {
-	var cs C.struct_samples
-	t0 := metric.Now()
-	if _, err = C.sockdiag_sample(fd, family, &cs); err != nil {
+// sampleFamily gets statistics for the IPv4 or IPv6 sockets selected by the
+// given filter, and sends TCPInfo's for each address registered with the
+// sampler.
+func (m *sampler) sampleFamily(ipv6 bool, filter sockFilter) (err error) {
+	var ss []SockSample
+	if ss, err = m.conn.sample(ipv6, filter); err != nil {
 		return
 	}
-	t := metric.Now()
-	ss := (*[1 << 30]C.struct_sample)(unsafe.Pointer(cs.sample))[:cs.len:cs.len]
 	for _, s := range ss {
 		var ok bool
-		var id TCPInfoID
-		if id, ok = m.addr[sockAddrSample(s)]; !ok {
+		var k *sampledSock
+		if k, ok = m.addr[sockAddr{s.Src, s.Dst}]; !ok {
+			continue
+		}
+		if k.adapt != nil && !k.adapt.record(s.TCPInfo) {
+			continue
+		}
+		s.TCPInfoID = k.id
+		m.ev <- s.TCPInfo
+	}
+	return
+}
+
+// sampleSockopt gets statistics for each address registered with the sampler
+// using getsockopt, and sends their TCPInfo's.
+func (m *sampler) sampleSockopt() (err error) {
+	for _, k := range m.addr {
+		if k.raw == nil {
+			continue
+		}
+		t0 := metric.Now()
+		var s TCPInfo
+		if s, err = getsockoptTCPInfo(k.raw); err != nil {
+			return
+		}
+		t := metric.Now()
+		s.TCPInfoID = k.id
+		s.T = t
+		s.SampleTime = time.Duration(t - t0)
+		if k.adapt != nil && !k.adapt.record(s) {
 			continue
 		}
-		m.ev <- newTCPInfo(id, t, time.Duration(t-t0), s.info)
+		m.ev <- s
 	}
-	C.sockdiag_free_samples(&cs)
+	return
+}
+
+// sampledSock contains the sampling state for a socket address registered with
+// a sampler.
+type sampledSock struct {
+	id    TCPInfoID
+	raw   syscall.RawConn // nil if not available
+	adapt *adaptive       // nil if sampling is not adaptive
+}
+
+// Adaptive sampling parameters.
+const (
+	// adaptiveHold is the number of samples recorded at the sampling interval
+	// after retransmits are seen.
+	adaptiveHold = 16
+
+	// adaptiveCwndDelta is the divisor of the last recorded cwnd that gives
+	// the change in cwnd at which a sample is recorded (e.g. 8 for 12.5%).
+	adaptiveCwndDelta = 8
+
+	// tcpInfiniteSSThresh is the initial value of tcpi_snd_ssthresh before slow
+	// start exit, from TCP_INFINITE_SSTHRESH in include/net/tcp.h.
+	tcpInfiniteSSThresh = 0x7fffffff
+
+	// tcpMaxSSThresh is the initial value of the slow start threshold in bytes
+	// on macOS and FreeBSD, from TCP_MAXWIN << TCP_MAX_WINSHIFT in
+	// netinet/tcp.h.
+	tcpMaxSSThresh = 65535 << 14
+)
+
+// adaptive decides which samples to record for a socket with adaptive sampling.
+// Sockets are sampled at the sampler's interval, but samples are only recorded
+// at that interval during slow start, for adaptiveHold samples after
+// retransmits, or when cwnd changes significantly. Otherwise, in steady state,
+// samples are recorded no more often than maxInterval. Slow start is detected
+// by ssthresh, so CCAs that leave ssthresh unset without loss (e.g. BBR) are
+// recorded at the sampling interval until the first retransmit.
+type adaptive struct {
+	maxInterval time.Duration
+	last        TCPInfo
+	retrans     int
+	hold        int
+	started     bool
+}
+
+// record returns true if the given sample should be recorded.
+func (a *adaptive) record(t TCPInfo) (rec bool) {
+	defer func() {
+		a.retrans = t.TotalRetransmits
+		if rec {
+			a.last = t
+			a.started = true
+		}
+	}()
+	if !a.started {
+		return true
+	}
+	if t.TotalRetransmits > a.retrans {
+		a.hold = adaptiveHold
+		return true
+	}
+	if a.hold > 0 {
+		a.hold--
+		return true
+	}
+	if t.SendSSThresh >= tcpInfiniteSSThresh || t.SendCwnd < t.SendSSThresh {
+		return true
+	}
+	d := t.SendCwnd - a.last.SendCwnd
+	if d < 0 {
+		d = -d
+	}
+	if d > 0 && d >= a.last.SendCwnd/adaptiveCwndDelta {
+		return true
+	}
+	return time.Duration(t.T-a.last.T) >= a.maxInterval
+}
+
+// sockFilter is inet_diag bytecode (see linux/inet_diag.h) that selects
+// sockets in the kernel by their source and destination ports, so that
+// statistics for unrelated sockets aren't returned to userspace.
+type sockFilter []byte
+
+// inet_diag bytecode operations, from linux/inet_diag.h.
+const (
+	inetDiagBCJmp = 1
+	inetDiagBCSGE = 2
+	inetDiagBCSLE = 3
+	inetDiagBCDGE = 4
+	inetDiagBCDLE = 5
+)
+
+// sockFilterClauseLen is the length of the bytecode that matches one socket
+// address: four port comparisons of 8 bytes each, and a 4 byte jump.
+const sockFilterClauseLen = 36
+
+// newSockFilters returns sockFilters for the IPv4 and IPv6 addresses in the
+// given map. A nil sockFilter is returned if there are no addresses for the
+// family, or more than maxSockFilter.
+func newSockFilters(addr map[sockAddr]*sampledSock) (filter4,
+	filter6 sockFilter) {
+	var a4, a6 []sockAddr
+	for a := range addr {
+		if a.Is4() {
+			a4 = append(a4, a)
+		} else {
+			a6 = append(a6, a)
+		}
+	}
+	filter4 = newSockFilter(a4)
+	filter6 = newSockFilter(a6)
+	return
+}
+
+// newSockFilter returns a sockFilter that accepts sockets matching the source
+// and destination ports of any of the given socket addresses. For each
+// address, the ports are compared in sequence, with a failed comparison
+// jumping to the next address, and a match jumping to the end of the bytecode,
+// which accepts the socket. After the last address, a final jump past the end
+// rejects the socket.
+func newSockFilter(addr []sockAddr) (filter sockFilter) {
+	if len(addr) == 0 || len(addr) > maxSockFilter {
+		return
+	}
+	l := len(addr)*sockFilterClauseLen + 4
+	filter = make(sockFilter, 0, l)
+	op := func(code byte, yes byte, no uint16) {
+		filter = append(filter, code, yes)
+		filter = binary.NativeEndian.AppendUint16(filter, no)
+	}
+	for _, a := range addr {
+		sp, dp := a.Src.Port(), a.Dst.Port()
+		op(inetDiagBCSGE, 8, 36)
+		op(0, 0, sp)
+		op(inetDiagBCSLE, 8, 28)
+		op(0, 0, sp)
+		op(inetDiagBCDGE, 8, 20)
+		op(0, 0, dp)
+		op(inetDiagBCDLE, 8, 12)
+		op(0, 0, dp)
+		op(inetDiagBCJmp, 4, uint16(l-len(filter)))
+	}
+	op(inetDiagBCJmp, 4, 8)
 	return
 }
 
 // TCPInfo contains a subset of the socket statistics from Linux's tcp_info
-// struct, defined in include/uapi/linux/tcp.h.
+// struct, defined in include/uapi/linux/tcp.h. On macOS and FreeBSD, the
+// fields are set from the closest equivalents in the platform's TCP
+// statistics, and those without one are zero (see getsockoptTCPInfo).
 type TCPInfo struct {
 	TCPInfoID
 
@@ -273,24 +550,56 @@ type TCPInfo struct {
 
 	// SendMSS is the send maximum segment size, from tcpi_snd_mss.
 	SendMSS metric.Bytes
-}
 
-// newTCPInfo returns a new TCPInfo from a sockdiag sample.
-func newTCPInfo(id TCPInfoID, t metric.RelativeTime, st time.Duration,
-	ti C.struct_tcp_info) TCPInfo {
-	return TCPInfo{
-		id,
-		t,
-		st,
-		time.Duration(time.Duration(ti.tcpi_rtt) * time.Microsecond),
-		time.Duration(time.Duration(ti.tcpi_rttvar) * time.Microsecond),
-		int(ti.tcpi_snd_ssthresh),
-		int(ti.tcpi_total_retrans),
-		metric.Bitrate(ti.tcpi_delivery_rate * 8),
-		metric.Bitrate(ti.tcpi_pacing_rate * 8),
-		int(ti.tcpi_snd_cwnd),
-		metric.Bytes(ti.tcpi_snd_mss),
-	}
+	// RwndLimited is the total time the sender has been limited by the
+	// receive window, from tcpi_rwnd_limited.
+	RwndLimited time.Duration
+
+	// ATO is the delayed ACK timeout, from tcpi_ato.
+	ATO time.Duration
+
+	// RcvRTT is the receiver's estimate of the round-trip time, from
+	// tcpi_rcv_rtt.
+	RcvRTT time.Duration
+
+	// RcvSpace is the receiver's estimate of the sender's window, used for
+	// receive buffer autotuning, from tcpi_rcv_space.
+	RcvSpace metric.Bytes
+
+	// RcvWnd is the receive window, from tcpi_rcv_wnd. This is zero for
+	// kernels before 5.4.
+	RcvWnd metric.Bytes
+
+	// MinRTT is the minimum round-trip time seen, from tcpi_min_rtt.
+	MinRTT time.Duration
+
+	// BytesAcked is the number of bytes acknowledged by the peer, from
+	// tcpi_bytes_acked.
+	BytesAcked metric.Bytes
+
+	// BytesRetrans is the number of bytes retransmitted, from
+	// tcpi_bytes_retrans.
+	BytesRetrans metric.Bytes
+
+	// Delivered is the number of data segments delivered to the peer,
+	// including retransmits, from tcpi_delivered.
+	Delivered int
+
+	// DeliveredCE is the number of delivered segments that were ECN CE
+	// marked, as echoed by the peer, from tcpi_delivered_ce.
+	DeliveredCE int
+
+	// SendWnd is the peer's advertised receive window, from tcpi_snd_wnd.
+	// This is zero for kernels before 5.4.
+	SendWnd metric.Bytes
+
+	// BusyTime is the total time the sender has had data in flight, from
+	// tcpi_busy_time.
+	BusyTime time.Duration
+
+	// SndbufLimited is the total time the sender has been limited by the send
+	// buffer, from tcpi_sndbuf_limited.
+	SndbufLimited time.Duration
 }
 
 // init registers TCPInfo with the gob encoder
@@ -309,11 +618,15 @@ func (t TCPInfo) handle(node *node) {
 }
 
 func (t TCPInfo) String() string {
-	return fmt.Sprintf("TCPInfo[Flow:%s Location:%s T:%s SampleTime:%s "+
-		"RTT:%s RTTVar:%s SendSSThresh:%d TotalRetransmits:%d DeliveryRate:%s "+
-		"PacingRate:%s SendCwnd:%d SendMSS:%s]",
+	return fmt.Sprintf("TCPInfo[Flow:%s Location:%s Receiver:%t T:%s "+
+		"SampleTime:%s RTT:%s RTTVar:%s SendSSThresh:%d TotalRetransmits:%d "+
+		"DeliveryRate:%s PacingRate:%s SendCwnd:%d SendMSS:%s RwndLimited:%s "+
+		"ATO:%s RcvRTT:%s RcvSpace:%s RcvWnd:%s MinRTT:%s BytesAcked:%s "+
+		"BytesRetrans:%s Delivered:%d DeliveredCE:%d SendWnd:%s BusyTime:%s "+
+		"SndbufLimited:%s]",
 		t.Flow,
 		t.Location,
+		t.Receiver,
 		t.T,
 		t.SampleTime,
 		t.RTT,
@@ -324,6 +637,19 @@ func (t TCPInfo) String() string {
 		t.PacingRate,
 		t.SendCwnd,
 		t.SendMSS,
+		t.RwndLimited,
+		t.ATO,
+		t.RcvRTT,
+		t.RcvSpace,
+		t.RcvWnd,
+		t.MinRTT,
+		t.BytesAcked,
+		t.BytesRetrans,
+		t.Delivered,
+		t.DeliveredCE,
+		t.SendWnd,
+		t.BusyTime,
+		t.SndbufLimited,
 	)
 }
 
@@ -341,6 +667,17 @@ type sockAddr struct {
 	Dst netip.AddrPort
 }
 
+// rawConn returns the syscall.RawConn for the given conn.
+func rawConn(conn net.Conn) (raw syscall.RawConn, err error) {
+	s, ok := conn.(syscall.Conn)
+	if !ok {
+		err = fmt.Errorf("%T does not support socket options", conn)
+		return
+	}
+	raw, err = s.SyscallConn()
+	return
+}
+
 // sockAddrConn returns a sockAddr for the given Conn.
 func sockAddrConn(c net.Conn) (addr sockAddr) {
 	addr.Src = c.LocalAddr().(*net.TCPAddr).AddrPort()
@@ -348,36 +685,6 @@ func sockAddrConn(c net.Conn) (addr sockAddr) {
 	return
 }
 
-// sockAddrSample returns a sockAddr for the given sample from C.
-func sockAddrSample(s C.struct_sample) (addr sockAddr) {
-	var sa, da netip.Addr
-	switch s.family {
-	case unix.AF_INET:
-		var b [4]byte
-		for i := 0; i < 4; i++ {
-			b[i] = byte(s.saddr[i])
-		}
-		sa = netip.AddrFrom4(b)
-		for i := 0; i < 4; i++ {
-			b[i] = byte(s.daddr[i])
-		}
-		da = netip.AddrFrom4(b)
-	case unix.AF_INET6:
-		var b [16]byte
-		for i := 0; i < 16; i++ {
-			b[i] = byte(s.saddr[i])
-		}
-		sa = netip.AddrFrom16(b)
-		for i := 0; i < 16; i++ {
-			b[i] = byte(s.daddr[i])
-		}
-		da = netip.AddrFrom16(b)
-	}
-	addr.Src = netip.AddrPortFrom(sa, uint16(s.sport))
-	addr.Dst = netip.AddrPortFrom(da, uint16(s.dport))
-	return
-}
-
 // Is4 returns true if this is an IPv4 sockAddr.
 func (a sockAddr) Is4() bool {
 	return a.Src.Addr().Is4()
@@ -386,3 +693,29 @@ func (a sockAddr) Is4() bool {
 func (a sockAddr) String() string {
 	return fmt.Sprintf("sockAddr[Src:%s Dst:%s]", a.Src, a.Dst)
 }
+
+// SockSample contains the statistics for one socket, as returned by
+// SockdiagConn.Sample.
+type SockSample struct {
+	// Src is the source (local) address and port.
+	Src netip.AddrPort
+
+	// Dst is the destination (remote) address and port.
+	Dst netip.AddrPort
+
+	// TCPInfo contains the socket statistics. TCPInfoID is not set.
+	TCPInfo
+}
+
+// TestSockdiag returns an error if sock_diag(7) can't be used to sample
+// TCPInfo on this system, e.g. if the kernel lacks support, or netlink sockets
+// are not permitted.
+func TestSockdiag() (err error) {
+	var c *SockdiagConn
+	if c, err = OpenSockdiag(); err != nil {
+		return
+	}
+	defer c.Close()
+	_, err = c.Sample(false)
+	return
+}
@@ -0,0 +1,218 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/heistp/antler/node/metric"
+	"golang.org/x/sys/unix"
+)
+
+// haveSockdiag is true if sock_diag(7) may be used on this platform.
+const haveSockdiag = true
+
+// Netlink and inet_diag constants, from linux/inet_diag.h and
+// net/tcp_states.h.
+const (
+	inetDiagReqBytecode = 1
+	inetDiagInfo        = 2
+	tcpEstablished      = 1
+)
+
+// Netlink and inet_diag message lengths.
+const (
+	sizeofInetDiagSockID = 48
+	sizeofInetDiagReqV2  = 8 + sizeofInetDiagSockID
+	sizeofInetDiagMsg    = 4 + sizeofInetDiagSockID + 20
+	sizeofRtAttr         = 4
+)
+
+// sockdiagBufLen is the length of the receive buffer for netlink messages.
+const sockdiagBufLen = 32 * 1024
+
+// SockdiagConn is a sock_diag(7) netlink socket for sampling statistics for all
+// established TCP sockets. It uses the same path as the TCPInfo sampler, so may
+// be used to diagnose sampling problems.
+type SockdiagConn struct {
+	fd  int
+	seq uint32
+	buf []byte
+}
+
+// OpenSockdiag opens and returns a new SockdiagConn.
+func OpenSockdiag() (conn *SockdiagConn, err error) {
+	var fd int
+	if fd, err = unix.Socket(unix.AF_NETLINK, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC,
+		unix.NETLINK_SOCK_DIAG); err != nil {
+		err = fmt.Errorf("unable to open sock_diag socket: %w", err)
+		return
+	}
+	t := unix.Timeval{Sec: 1}
+	if err = unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO,
+		&t); err != nil {
+		unix.Close(fd)
+		return
+	}
+	conn = &SockdiagConn{fd, 0, make([]byte, sockdiagBufLen)}
+	return
+}
+
+// Sample returns a SockSample for each established TCP socket, for IPv6 if ipv6
+// is true, or IPv4 otherwise.
+func (c *SockdiagConn) Sample(ipv6 bool) (sample []SockSample, err error) {
+	return c.sample(ipv6, nil)
+}
+
+// sample returns a SockSample for each established TCP socket selected by the
+// given sockFilter, or all sockets if the filter is nil.
+func (c *SockdiagConn) sample(ipv6 bool, filter sockFilter) (
+	sample []SockSample, err error) {
+	var f byte = unix.AF_INET
+	if ipv6 {
+		f = unix.AF_INET6
+	}
+	t0 := metric.Now()
+	if err = c.request(f, filter); err != nil {
+		return
+	}
+	for d := false; !d; {
+		var n int
+		if n, _, err = unix.Recvfrom(c.fd, c.buf, 0); err != nil {
+			return
+		}
+		t := metric.Now()
+		var mm []syscall.NetlinkMessage
+		if mm, err = syscall.ParseNetlinkMessage(c.buf[:n]); err != nil {
+			return
+		}
+		for _, m := range mm {
+			if m.Header.Seq != c.seq {
+				continue
+			}
+			switch m.Header.Type {
+			case unix.NLMSG_DONE:
+				d = true
+			case unix.NLMSG_ERROR:
+				err = netlinkError(m.Data)
+				return
+			default:
+				var s SockSample
+				var ok bool
+				if s, ok = parseInetDiagMsg(m.Data, t,
+					time.Duration(t-t0)); ok {
+					sample = append(sample, s)
+				}
+			}
+		}
+	}
+	return
+}
+
+// request sends an inet_diag request for established TCP sockets in the given
+// family, with tcp_info, and the optional sockFilter.
+func (c *SockdiagConn) request(family byte, filter sockFilter) (err error) {
+	c.seq++
+	l := unix.SizeofNlMsghdr + sizeofInetDiagReqV2
+	if len(filter) > 0 {
+		l += sizeofRtAttr + len(filter)
+	}
+	b := make([]byte, 0, l)
+	e := binary.NativeEndian
+	// nlmsghdr
+	b = e.AppendUint32(b, uint32(l))
+	b = e.AppendUint16(b, unix.SOCK_DIAG_BY_FAMILY)
+	b = e.AppendUint16(b, unix.NLM_F_REQUEST|unix.NLM_F_DUMP)
+	b = e.AppendUint32(b, c.seq)
+	b = e.AppendUint32(b, 0)
+	// inet_diag_req_v2, with a zero inet_diag_sockid
+	b = append(b, family, unix.IPPROTO_TCP, 1<<(inetDiagInfo-1), 0)
+	b = e.AppendUint32(b, 1<<tcpEstablished)
+	b = append(b, make([]byte, sizeofInetDiagSockID)...)
+	// bytecode attribute
+	if len(filter) > 0 {
+		b = e.AppendUint16(b, uint16(sizeofRtAttr+len(filter)))
+		b = e.AppendUint16(b, inetDiagReqBytecode)
+		b = append(b, filter...)
+	}
+	a := &unix.SockaddrNetlink{Family: unix.AF_NETLINK}
+	err = unix.Sendto(c.fd, b, 0, a)
+	return
+}
+
+// netlinkError returns an error from the data in an NLMSG_ERROR message.
+func netlinkError(data []byte) error {
+	if len(data) < 4 {
+		return unix.ENODATA
+	}
+	n := int32(binary.NativeEndian.Uint32(data))
+	if n == 0 {
+		return nil
+	}
+	return unix.Errno(-n)
+}
+
+// parseInetDiagMsg returns a SockSample from an inet_diag_msg and its
+// INET_DIAG_INFO attribute. False is returned if the message doesn't contain
+// tcp_info.
+func parseInetDiagMsg(data []byte, t metric.RelativeTime, st time.Duration) (
+	sample SockSample, ok bool) {
+	if len(data) < sizeofInetDiagMsg {
+		return
+	}
+	// inet_diag_sockid follows family, state, timer and retrans
+	id := data[4 : 4+sizeofInetDiagSockID]
+	sp := binary.BigEndian.Uint16(id[0:2])
+	dp := binary.BigEndian.Uint16(id[2:4])
+	var sa, da netip.Addr
+	switch data[0] {
+	case unix.AF_INET:
+		sa = netip.AddrFrom4([4]byte(id[4:8]))
+		da = netip.AddrFrom4([4]byte(id[20:24]))
+	case unix.AF_INET6:
+		sa = netip.AddrFrom16([16]byte(id[4:20]))
+		da = netip.AddrFrom16([16]byte(id[20:36]))
+	default:
+		return
+	}
+	for a := data[sizeofInetDiagMsg:]; len(a) >= sizeofRtAttr; {
+		l := int(binary.NativeEndian.Uint16(a[0:2]))
+		y := binary.NativeEndian.Uint16(a[2:4])
+		if l < sizeofRtAttr || l > len(a) {
+			return
+		}
+		if y == inetDiagInfo {
+			var ti unix.TCPInfo
+			copy(unsafe.Slice((*byte)(unsafe.Pointer(&ti)),
+				unsafe.Sizeof(ti)), a[sizeofRtAttr:l])
+			sample = SockSample{
+				netip.AddrPortFrom(sa, sp),
+				netip.AddrPortFrom(da, dp),
+				newTCPInfo(TCPInfoID{}, t, st, &ti),
+			}
+			ok = true
+			return
+		}
+		if l = rtaAlign(l); l > len(a) {
+			return
+		}
+		a = a[l:]
+	}
+	return
+}
+
+// rtaAlign returns the given rtattr length rounded up to the alignment.
+func rtaAlign(l int) int {
+	return (l + unix.NLMSG_ALIGNTO - 1) &^ (unix.NLMSG_ALIGNTO - 1)
+}
+
+// Close closes the netlink socket.
+func (c *SockdiagConn) Close() error {
+	return unix.Close(c.fd)
+}
@@ -0,0 +1,44 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+//go:build !linux
+
+package node
+
+import (
+	"errors"
+)
+
+// haveSockdiag is true if sock_diag(7) may be used on this platform.
+const haveSockdiag = false
+
+// errNoSockdiag is returned by OpenSockdiag on platforms other than Linux.
+var errNoSockdiag = errors.New("sock_diag requires Linux")
+
+// SockdiagConn is a sock_diag(7) netlink socket, which is only available on
+// Linux.
+type SockdiagConn struct{}
+
+// OpenSockdiag returns errNoSockdiag.
+func OpenSockdiag() (conn *SockdiagConn, err error) {
+	err = errNoSockdiag
+	return
+}
+
+// Sample returns errNoSockdiag.
+func (c *SockdiagConn) Sample(ipv6 bool) (sample []SockSample, err error) {
+	err = errNoSockdiag
+	return
+}
+
+// sample returns errNoSockdiag.
+func (c *SockdiagConn) sample(ipv6 bool, filter sockFilter) (
+	sample []SockSample, err error) {
+	err = errNoSockdiag
+	return
+}
+
+// Close does nothing.
+func (c *SockdiagConn) Close() error {
+	return nil
+}
@@ -0,0 +1,21 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"errors"
+
+	"github.com/heistp/antler/node/metric"
+)
+
+// tcpCongestion is the socket option for setting the CCA. macOS has no such
+// option, as the CCA is set system wide, so it's negative.
+const tcpCongestion = -1
+
+// setsockoptMaxPacingRate returns an error, as macOS has no
+// SO_MAX_PACING_RATE socket option, so a Transfer's MaxRate falls back to a
+// token bucket in userspace.
+func setsockoptMaxPacingRate(fd int, rate metric.Bitrate) error {
+	return errors.New("SO_MAX_PACING_RATE not supported on macOS")
+}
@@ -0,0 +1,26 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"math"
+
+	"github.com/heistp/antler/node/metric"
+	"golang.org/x/sys/unix"
+)
+
+// tcpCongestion is the socket option for setting the CCA.
+const tcpCongestion = unix.TCP_CONGESTION
+
+// setsockoptMaxPacingRate sets the SO_MAX_PACING_RATE socket option on the
+// given file descriptor. FreeBSD takes the rate in bytes per second as a
+// uint32, so rates above about 34 Gbps are capped.
+func setsockoptMaxPacingRate(fd int, rate metric.Bitrate) error {
+	r := uint64(rate) / 8
+	if r > math.MaxUint32 {
+		r = math.MaxUint32
+	}
+	return unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_MAX_PACING_RATE,
+		int(r))
+}
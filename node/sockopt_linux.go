@@ -0,0 +1,19 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"github.com/heistp/antler/node/metric"
+	"golang.org/x/sys/unix"
+)
+
+// tcpCongestion is the socket option for setting the CCA.
+const tcpCongestion = unix.TCP_CONGESTION
+
+// setsockoptMaxPacingRate sets the SO_MAX_PACING_RATE socket option on the
+// given file descriptor.
+func setsockoptMaxPacingRate(fd int, rate metric.Bitrate) error {
+	return unix.SetsockoptUint64(fd, unix.SOL_SOCKET, unix.SO_MAX_PACING_RATE,
+		uint64(rate)/8)
+}
@@ -0,0 +1,285 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/heistp/antler/node/metric"
+)
+
+// Station is a runner that samples wireless station statistics for a mac80211
+// interface, using 'iw dev <Interface> station dump', from the time it's run
+// until the node's runners are canceled at the end of the Test. A StationInfo
+// data point is sent for each associated station, for each sample.
+//
+// On an access point, one StationInfo is sent per associated client for each
+// sample, and on a client, one is sent for the access point.
+//
+// Station should be placed before the runners that generate traffic, so that
+// sampling covers the entire Test.
+type Station struct {
+	// Interface is the wireless interface name (e.g. "wlan0").
+	Interface string
+
+	// Interval is the sampling interval.
+	Interval metric.Duration
+
+	done chan struct{}
+	errc chan error
+}
+
+// Run implements runner
+func (s *Station) Run(ctx context.Context, arg runArg) (ofb Feedback,
+	err error) {
+	var ii []StationInfo
+	if ii, err = s.sample(arg.rec.nodeID); err != nil {
+		return
+	}
+	for _, i := range ii {
+		arg.rec.Send(i)
+	}
+	s.done = make(chan struct{})
+	s.errc = make(chan error, 1)
+	go s.run(arg.rec)
+	arg.cxl <- s
+	return
+}
+
+// run samples the station statistics each Interval, until done is closed.
+func (s *Station) run(rec *recorder) {
+	var err error
+	defer func() {
+		s.errc <- err
+		close(s.errc)
+	}()
+	t := time.NewTicker(s.Interval.Duration())
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			var ii []StationInfo
+			if ii, err = s.sample(rec.nodeID); err != nil {
+				return
+			}
+			for _, i := range ii {
+				rec.Send(i)
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Cancel implements canceler
+func (s *Station) Cancel() error {
+	close(s.done)
+	return <-s.errc
+}
+
+// sample runs iw and returns the parsed StationInfo for each station.
+func (s *Station) sample(nodeID ID) (info []StationInfo, err error) {
+	c := exec.Command("iw", "dev", s.Interface, "station", "dump")
+	var o []byte
+	t := metric.Now()
+	if o, err = c.Output(); err != nil {
+		err = fmt.Errorf("iw station dump for %s: %w", s.Interface, err)
+		return
+	}
+	if info, err = parseStationDump(o); err != nil {
+		return
+	}
+	for i := range info {
+		info[i].Tinit = metric.Tinit
+		info[i].NodeID = nodeID
+		info[i].Interface = s.Interface
+		info[i].T = t
+	}
+	return
+}
+
+// validate implements validater
+func (s *Station) validate() (err error) {
+	if s.Interface == "" {
+		err = fmt.Errorf("Station requires an Interface")
+		return
+	}
+	if s.Interval <= 0 {
+		err = fmt.Errorf("Station Interval must be positive: %s", s.Interval)
+	}
+	return
+}
+
+// parseStationDump parses the output of 'iw dev <interface> station dump'.
+// Fields that aren't present in the output (e.g. airtime for drivers that
+// don't report it) are left as zero.
+func parseStationDump(out []byte) (info []StationInfo, err error) {
+	var i *StationInfo
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		l := s.Text()
+		if a, ok := strings.CutPrefix(l, "Station "); ok {
+			info = append(info, StationInfo{})
+			i = &info[len(info)-1]
+			i.Station, _, _ = strings.Cut(a, " ")
+			continue
+		}
+		if i == nil {
+			continue
+		}
+		k, v, ok := strings.Cut(strings.TrimSpace(l), ":")
+		if !ok {
+			continue
+		}
+		f := strings.Fields(v)
+		if len(f) == 0 {
+			continue
+		}
+		switch k {
+		case "rx bytes":
+			var b uint64
+			b, err = strconv.ParseUint(f[0], 10, 64)
+			i.RxBytes = metric.Bytes(b)
+		case "tx bytes":
+			var b uint64
+			b, err = strconv.ParseUint(f[0], 10, 64)
+			i.TxBytes = metric.Bytes(b)
+		case "rx packets":
+			i.RxPackets, err = strconv.ParseUint(f[0], 10, 64)
+		case "tx packets":
+			i.TxPackets, err = strconv.ParseUint(f[0], 10, 64)
+		case "tx retries":
+			i.TxRetries, err = strconv.ParseUint(f[0], 10, 64)
+		case "tx failed":
+			i.TxFailed, err = strconv.ParseUint(f[0], 10, 64)
+		case "signal":
+			i.Signal, err = strconv.Atoi(f[0])
+		case "signal avg":
+			i.SignalAvg, err = strconv.Atoi(f[0])
+		case "tx bitrate":
+			i.TxBitrate, err = parseStationBitrate(f[0])
+		case "rx bitrate":
+			i.RxBitrate, err = parseStationBitrate(f[0])
+		case "tx duration":
+			var d uint64
+			d, err = strconv.ParseUint(f[0], 10, 64)
+			i.TxAirtime = time.Duration(d) * time.Microsecond
+		case "rx duration":
+			var d uint64
+			d, err = strconv.ParseUint(f[0], 10, 64)
+			i.RxAirtime = time.Duration(d) * time.Microsecond
+		}
+		if err != nil {
+			err = fmt.Errorf("unable to parse station %s '%s': %w",
+				i.Station, k, err)
+			return
+		}
+	}
+	err = s.Err()
+	return
+}
+
+// parseStationBitrate parses a bitrate field from iw output, in MBit/s.
+func parseStationBitrate(s string) (b metric.Bitrate, err error) {
+	var f float64
+	if f, err = strconv.ParseFloat(s, 64); err != nil {
+		return
+	}
+	b = metric.Bitrate(f * 1000000)
+	return
+}
+
+// StationInfo is a time series data point containing the statistics for one
+// wireless station, as reported by iw. Counters are cumulative since the
+// station associated.
+type StationInfo struct {
+	// Tinit is the base time for T.
+	Tinit time.Time
+
+	// T is the node-relative time the sample was taken.
+	T metric.RelativeTime
+
+	// NodeID is the ID of the node that took the sample.
+	NodeID ID
+
+	// Interface is the wireless interface name.
+	Interface string
+
+	// Station is the station's MAC address.
+	Station string
+
+	// Signal is the signal strength of the last received frame, in dBm.
+	Signal int
+
+	// SignalAvg is the average signal strength, in dBm.
+	SignalAvg int
+
+	// TxBitrate is the PHY rate of the last transmitted frame.
+	TxBitrate metric.Bitrate
+
+	// RxBitrate is the PHY rate of the last received frame.
+	RxBitrate metric.Bitrate
+
+	// TxBytes is the number of bytes transmitted to the station.
+	TxBytes metric.Bytes
+
+	// RxBytes is the number of bytes received from the station.
+	RxBytes metric.Bytes
+
+	// TxPackets is the number of packets transmitted to the station.
+	TxPackets uint64
+
+	// RxPackets is the number of packets received from the station.
+	RxPackets uint64
+
+	// TxRetries is the number of transmit retries.
+	TxRetries uint64
+
+	// TxFailed is the number of failed transmissions.
+	TxFailed uint64
+
+	// TxAirtime is the airtime used for transmitting to the station, if
+	// reported by the driver.
+	TxAirtime time.Duration
+
+	// RxAirtime is the airtime used for receiving from the station, if
+	// reported by the driver.
+	RxAirtime time.Duration
+}
+
+// init registers StationInfo with the gob encoder
+func init() {
+	gob.Register(StationInfo{})
+}
+
+// Time returns an absolute time from the node-relative time T.
+func (s StationInfo) Time() time.Time {
+	return s.Tinit.Add(time.Duration(s.T))
+}
+
+// flags implements message
+func (StationInfo) flags() flag {
+	return flagForward
+}
+
+// handle implements event
+func (s StationInfo) handle(node *node) {
+	node.parent.Send(s)
+}
+
+func (s StationInfo) String() string {
+	return fmt.Sprintf("StationInfo[NodeID:%s Interface:%s Station:%s T:%s "+
+		"Signal:%d SignalAvg:%d TxBitrate:%s RxBitrate:%s TxRetries:%d "+
+		"TxFailed:%d TxAirtime:%s RxAirtime:%s]", s.NodeID, s.Interface,
+		s.Station, s.T, s.Signal, s.SignalAvg, s.TxBitrate, s.RxBitrate,
+		s.TxRetries, s.TxFailed, s.TxAirtime, s.RxAirtime)
+}
@@ -57,7 +57,7 @@ func (s *StreamServer) Run(ctx context.Context, arg runArg) (ofb Feedback,
 		return
 	}
 	if s.ListenAddrKey != "" {
-		ofb[s.ListenAddrKey] = l.Addr().String()
+		ofb = Feedback{s.ListenAddrKey: l.Addr().String()}
 	}
 	if len(s.Key) > 0 {
 		s.nonce = make(map[string]struct{})
@@ -158,7 +158,7 @@ func (s *StreamServer) serve(ctx context.Context, conn *net.TCPConn,
 }
 
 // header reads the header and returns the streamer read from the header.
-func (s *StreamServer) header(conn *net.TCPConn) (streamer streamer, err error) {
+func (s *StreamServer) header(conn net.Conn) (streamer streamer, err error) {
 	var h hash.Hash
 	var m, n []byte
 	if len(s.Key) > 0 {
@@ -241,6 +241,10 @@ type StreamClient struct {
 	// Key is a security key for HMAC signing.
 	Key []byte
 
+	// CountersKey, if not empty, is the key used in the returned Feedback for
+	// the FlowCounters of the connection, obtained from TCP_INFO.
+	CountersKey string
+
 	Streamers
 }
 
@@ -287,7 +291,18 @@ func (s *StreamClient) Run(ctx context.Context, arg runArg) (ofb Feedback,
 	if _, err = c.Write(h); err != nil {
 		return
 	}
-	err = r.handleClient(ctx, c, arg)
+	t0 := time.Now()
+	if err = r.handleClient(ctx, c, arg); err != nil {
+		return
+	}
+	if s.CountersKey != "" {
+		k := FlowCounters{Flow: s.flow()}
+		if err = k.setTCP(c); err != nil {
+			return
+		}
+		k.since(t0)
+		ofb = Feedback{s.CountersKey: k}
+	}
 	return
 }
 
@@ -378,6 +393,7 @@ type dialController interface {
 type Streamers struct {
 	Upload   *Upload
 	Download *Download
+	RPC      *RPC
 }
 
 // streamer returns the streamer.
@@ -389,10 +405,29 @@ func (s *Streamers) streamer() (ss streamer) {
 	return
 }
 
+// flow returns the flow identifier of the streamer.
+func (s *Streamers) flow() (f Flow) {
+	switch v := s.streamer().(type) {
+	case *Upload:
+		f = v.Flow
+	case *Download:
+		f = v.Flow
+	case *RPC:
+		f = v.Flow
+	}
+	return
+}
+
 // validate returns an error if exactly one field isn't set.
 func (s *Streamers) validate() (err error) {
-	if _, n := s.value(); n != 1 {
+	var ss streamer
+	var n int
+	if ss, n = s.value(); n != 1 {
 		err = UnionError{s, n}
+		return
+	}
+	if v, ok := ss.(validater); ok {
+		err = v.validate()
 	}
 	return
 }
@@ -407,6 +442,10 @@ func (s *Streamers) value() (ss streamer, n int) {
 		ss = s.Download
 		n++
 	}
+	if s.RPC != nil {
+		ss = s.RPC
+		n++
+	}
 	return
 }
 
@@ -424,14 +463,28 @@ func init() {
 func (u Upload) handleClient(ctx context.Context, conn net.Conn,
 	arg runArg) error {
 	arg.rec.Send(u.Info(false))
-	return u.send(ctx, conn, arg)
+	return u.send(ctx, conn, Client, arg)
 }
 
 // handleServer implements streamer
 func (u Upload) handleServer(ctx context.Context, conn net.Conn,
-	arg runArg) error {
+	arg runArg) (err error) {
+	if err = u.Receiver.setTCP(conn); err != nil {
+		return
+	}
 	arg.rec.Send(u.Info(true))
-	return u.receive(ctx, conn, arg)
+	err = u.receive(ctx, conn, Server, arg)
+	return
+}
+
+// validate implements validater
+func (u Upload) validate() (err error) {
+	if u.Direction != Up {
+		err = fmt.Errorf("Upload Direction must be %s: %s", Up, u.Direction)
+		return
+	}
+	err = u.Transfer.validate()
+	return
 }
 
 func (u Upload) String() string {
@@ -452,27 +505,35 @@ func init() {
 func (d Download) handleClient(ctx context.Context, conn net.Conn,
 	arg runArg) error {
 	arg.rec.Send(d.Info(false))
-	return d.receive(ctx, conn, arg)
+	return d.receive(ctx, conn, Client, arg)
 }
 
 // handleServer implements streamer
 func (d Download) handleServer(ctx context.Context, conn net.Conn,
 	arg runArg) (err error) {
-	if len(d.Sockopt) > 0 {
-		var t *net.TCPConn
-		var ok bool
-		if t, ok = conn.(*net.TCPConn); !ok {
-			err = fmt.Errorf("not a TCPConn for setting Sockopts: %T")
-			return
-		}
-		for _, o := range d.sockopt() {
-			if err = o.setTCP(t); err != nil {
-				return
-			}
-		}
+	if err = d.Sockopts.setTCP(conn); err != nil {
+		return
 	}
 	arg.rec.Send(d.Info(true))
-	err = d.send(ctx, conn, arg)
+	err = d.send(ctx, conn, Server, arg)
+	return
+}
+
+// dialControl implements dialController. The client is the receiver for a
+// Download, so only the Receiver Sockopts are set on its socket.
+func (d Download) dialControl(network, address string,
+	conn syscall.RawConn) error {
+	return d.Receiver.dialControl(network, address, conn)
+}
+
+// validate implements validater
+func (d Download) validate() (err error) {
+	if d.Direction != Down {
+		err = fmt.Errorf("Download Direction must be %s: %s", Down,
+			d.Direction)
+		return
+	}
+	err = d.Transfer.validate()
 	return
 }
 
@@ -498,8 +559,12 @@ type Stream struct {
 	// Direction is the client to server sense.
 	Direction Direction
 
-	// Sockopts provides support for socket options.
+	// Sockopts provides support for socket options. For an Upload or
+	// Download, these are set on the sender's socket (see Direction.Sender).
 	Sockopts
+
+	// Tags are added to the StreamIO and TCPInfo data points for the Stream.
+	Tags Tags
 }
 
 // Info returns StreamInfo for this Stream.
@@ -507,6 +572,14 @@ func (s Stream) Info(server bool) StreamInfo {
 	return StreamInfo{metric.Tinit, s, server}
 }
 
+// validate returns an error if the Stream is invalid.
+func (s Stream) validate() (err error) {
+	if err = s.Flow.validate(); err != nil {
+		err = fmt.Errorf("invalid Flow in Stream: %w", err)
+	}
+	return
+}
+
 func (s Stream) String() string {
 	return fmt.Sprintf("Stream[Flow:%s Direction:%s CCA:%s]",
 		s.Flow, s.Direction, s.CCA)
@@ -555,15 +628,42 @@ type Transfer struct {
 	// Length is the number of bytes after which the sender stops writing.
 	Length metric.Bytes
 
+	// Rate, if non-zero, is the target application bitrate, enforced with a
+	// token bucket in userspace, to emulate paced sources like ABR video. The
+	// bucket depth is BufLen, so a smaller BufLen results in smoother writes.
+	// Zero means the sender writes as fast as possible.
+	Rate metric.Bitrate
+
+	// MaxRate, if non-zero, is the maximum pacing rate of the sender, set with
+	// the SO_MAX_PACING_RATE socket option, so that the kernel paces the flow
+	// (using TCP internal pacing, or the fq qdisc). The flow is then
+	// application limited at MaxRate, but unlike for Rate, its packets are
+	// evenly spaced. If the option can't be set (e.g. for QUIC), a userspace
+	// token bucket is used instead, as for Rate.
+	MaxRate metric.Bitrate
+
 	// IOSampleInterval is the minimum time between IO samples. Zero disables
 	// IO sampling. A value of 1ns typically means a sample will be recorded for
 	// every read and write.
 	IOSampleInterval metric.Duration
 
-	// TCPInfoInterval is the sampling interval for TCPInfo from Linux. Zero
-	// means TCPInfo sampling is disabled.
+	// TCPInfoInterval is the sampling interval for TCPInfo. Zero means
+	// TCPInfo sampling is disabled. On macOS and FreeBSD, only the TCPInfo
+	// fields with an equivalent in the platform's TCP statistics are set.
 	TCPInfoInterval metric.Duration
 
+	// TCPInfoMaxInterval, if greater than TCPInfoInterval, enables adaptive
+	// TCPInfo sampling. TCPInfo is recorded every TCPInfoInterval during slow
+	// start, after retransmits, and when cwnd changes significantly, and
+	// otherwise at up to TCPInfoMaxInterval, reducing the data volume for long
+	// flows in steady state.
+	TCPInfoMaxInterval metric.Duration
+
+	// TCPInfoReceiver, if true, also samples TCPInfo for the receiver's socket,
+	// so that receive window limiting and delayed ACK behavior may be
+	// observed. By default, only the sender's socket is sampled.
+	TCPInfoReceiver bool
+
 	// BufLen is the size of the buffer used to read and write from the conn.
 	BufLen int
 
@@ -573,6 +673,10 @@ type Transfer struct {
 	// HMAC is the hash value for the nonce.
 	HMAC []byte
 
+	// Receiver contains the socket options for the receiver's socket (see
+	// Direction.Receiver). CCA may not be set, as it only affects the sender.
+	Receiver Sockopts
+
 	Stream
 }
 
@@ -582,22 +686,77 @@ const (
 	transferACK        = 0xff // ack byte for transfers
 )
 
-// send runs the send side of a transfer.
-func (x Transfer) send(ctx context.Context, conn net.Conn, arg runArg) (
-	err error) {
+// validate returns an error if the Transfer is invalid.
+func (x Transfer) validate() (err error) {
+	if err = x.Stream.validate(); err != nil {
+		return
+	}
+	if err = x.Sockopts.validate(); err != nil {
+		err = fmt.Errorf("flow %s: %w", x.Flow, err)
+		return
+	}
+	if err = x.Receiver.validate(); err != nil {
+		err = fmt.Errorf("flow %s Receiver: %w", x.Flow, err)
+		return
+	}
+	if x.Receiver.CCA != "" {
+		err = fmt.Errorf("flow %s Receiver CCA may not be set, as the CCA "+
+			"is only used by the sender (the %s)", x.Flow,
+			x.Direction.Sender())
+		return
+	}
+	if x.Rate > 0 && x.MaxRate > 0 && x.MaxRate < x.Rate {
+		err = fmt.Errorf("flow %s MaxRate %s is less than Rate %s", x.Flow,
+			x.MaxRate, x.Rate)
+		return
+	}
+	if x.TCPInfoMaxInterval > 0 && x.TCPInfoMaxInterval < x.TCPInfoInterval {
+		err = fmt.Errorf("TCPInfoMaxInterval %s less than TCPInfoInterval %s",
+			x.TCPInfoMaxInterval, x.TCPInfoInterval)
+	}
+	return
+}
+
+// sampleTCPInfo starts TCPInfo sampling for the given conn, at the given
+// location, and returns a func that stops it.
+func (x Transfer) sampleTCPInfo(conn net.Conn, loc Location, receiver bool,
+	arg runArg) (stop func()) {
+	a := sockAddrConn(conn)
+	r, _ := rawConn(conn)
+	id := TCPInfoID{x.Flow, loc, receiver, x.Tags}
+	i := x.TCPInfoInterval.Duration()
+	arg.sockdiag.Add(a, r, id, i, x.TCPInfoMaxInterval.Duration(), arg.rec)
+	return func() {
+		arg.sockdiag.Remove(a, i)
+	}
+}
+
+// send runs the send side of a transfer, at the given location.
+func (x Transfer) send(ctx context.Context, conn net.Conn, loc Location,
+	arg runArg) (err error) {
 	b := make([]byte, x.BufLen)
 	for i := 0; i < x.BufLen; i++ {
 		b[i] = transferFill
 	}
 	in, dur := x.IOSampleInterval.Duration(), x.Duration.Duration()
 	t0 := metric.Now()
-	arg.rec.Send(StreamIO{x.Flow, t0, 0, true})
+	arg.rec.Send(StreamIO{x.Flow, t0, 0, true, x.Tags})
 	if x.TCPInfoInterval > 0 {
-		a := sockAddrConn(conn)
-		id := TCPInfoID{x.Flow, Client}
-		i := x.TCPInfoInterval.Duration()
-		arg.sockdiag.Add(a, id, i)
-		defer arg.sockdiag.Remove(a, i)
+		defer x.sampleTCPInfo(conn, loc, false, arg)()
+	}
+	r := x.Rate
+	if x.MaxRate > 0 {
+		if e := setMaxPacingRate(conn, x.MaxRate); e != nil {
+			arg.rec.Logf("using token bucket for MaxRate, as pacing rate "+
+				"could not be set: %s", e)
+			if r == 0 || x.MaxRate < r {
+				r = x.MaxRate
+			}
+		}
+	}
+	var tb *tokenBucket
+	if r > 0 {
+		tb = newTokenBucket(r, x.BufLen, t0)
 	}
 	t := t0
 	ts := t0
@@ -616,12 +775,17 @@ func (x Transfer) send(ctx context.Context, conn net.Conn, arg runArg) (
 		if done {
 			b[bl-1] = transferFinal
 		}
+		if tb != nil {
+			if err = tb.wait(ctx, bl); err != nil {
+				return
+			}
+		}
 		n, err = conn.Write(b[:bl])
 		t = metric.Now()
 		l += metric.Bytes(n)
 		if n > 0 && in > 0 {
 			if time.Duration(t-ts) > in || done {
-				arg.rec.Send(StreamIO{x.Flow, t, l, true})
+				arg.rec.Send(StreamIO{x.Flow, t, l, true, x.Tags})
 				ts = t
 			}
 		}
@@ -646,13 +810,93 @@ func (x Transfer) send(ctx context.Context, conn net.Conn, arg runArg) (
 	return
 }
 
-// receive runs the receive side of a transfer.
-func (x Transfer) receive(ctx context.Context, conn io.ReadWriter, arg runArg) (
-	err error) {
+// setMaxPacingRate sets the SO_MAX_PACING_RATE socket option on the given conn.
+func setMaxPacingRate(conn net.Conn, rate metric.Bitrate) (err error) {
+	s, ok := conn.(syscall.Conn)
+	if !ok {
+		err = fmt.Errorf("%T does not support socket options", conn)
+		return
+	}
+	var c syscall.RawConn
+	if c, err = s.SyscallConn(); err != nil {
+		return
+	}
+	var e error
+	if err = c.Control(func(fd uintptr) {
+		e = setsockoptMaxPacingRate(int(fd), rate)
+	}); err == nil {
+		err = e
+	}
+	return
+}
+
+// tokenBucket limits the write rate for a Transfer. Tokens are bytes, which
+// accumulate at the given rate, up to the bucket depth.
+type tokenBucket struct {
+	rate   metric.Bitrate
+	depth  float64
+	tokens float64
+	last   metric.RelativeTime
+}
+
+// newTokenBucket returns a new, full tokenBucket with the given rate and depth
+// in bytes, starting at time t.
+func newTokenBucket(rate metric.Bitrate, depth int,
+	t metric.RelativeTime) *tokenBucket {
+	return &tokenBucket{rate, float64(depth), float64(depth), t}
+}
+
+// wait blocks until n tokens are available and takes them, or returns the
+// cause if the Context is done first.
+func (b *tokenBucket) wait(ctx context.Context, n int) (err error) {
+	b.fill()
+	if d := float64(n) - b.tokens; d > 0 {
+		w := time.Duration(d * 8 / float64(b.rate) * float64(time.Second))
+		t := time.NewTimer(w)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			err = context.Cause(ctx)
+			return
+		case <-t.C:
+		}
+		b.fill()
+	}
+	b.tokens -= float64(n)
+	return
+}
+
+// reserve takes n tokens, which may leave the bucket in debt, and returns the
+// time to wait until they would have been available.
+func (b *tokenBucket) reserve(n int) time.Duration {
+	b.fill()
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens * 8 / float64(b.rate) * float64(time.Second))
+}
+
+// fill adds the tokens accumulated since the last fill.
+func (b *tokenBucket) fill() {
+	t := metric.Now()
+	b.tokens += float64(b.rate) / 8 * time.Duration(t-b.last).Seconds()
+	if b.tokens > b.depth {
+		b.tokens = b.depth
+	}
+	b.last = t
+}
+
+// receive runs the receive side of a transfer, at the given location.
+func (x Transfer) receive(ctx context.Context, conn net.Conn, loc Location,
+	arg runArg) (err error) {
 	b := make([]byte, x.BufLen)
 	in := x.IOSampleInterval.Duration()
 	t0 := metric.Now()
-	arg.rec.Send(StreamIO{x.Flow, t0, 0, false})
+	arg.rec.Send(StreamIO{x.Flow, t0, 0, false, x.Tags})
+	if x.TCPInfoInterval > 0 && x.TCPInfoReceiver {
+		defer x.sampleTCPInfo(conn, loc, true, arg)()
+	}
 	ts := t0
 	var l metric.Bytes
 	var done bool
@@ -670,7 +914,7 @@ func (x Transfer) receive(ctx context.Context, conn io.ReadWriter, arg runArg) (
 				done = true
 			}
 			if in > 0 && time.Duration(t-ts) > in || done || err != nil {
-				arg.rec.Send(StreamIO{x.Flow, t, l, false})
+				arg.rec.Send(StreamIO{x.Flow, t, l, false, x.Tags})
 				ts = t
 			}
 		}
@@ -705,6 +949,9 @@ type StreamIO struct {
 
 	// Sent is true for sent bytes, and false for received.
 	Sent bool
+
+	// Tags are the Tags from the Stream.
+	Tags Tags
 }
 
 // init registers StreamIO with the gob encoder
@@ -0,0 +1,190 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// sysctlDir is the directory containing the sysctl parameter files.
+const sysctlDir = "/proc/sys"
+
+// SetSysctl is a runner that sets sysctl kernel parameters, and restores their
+// original values when the node's runners are canceled at the end of the Test,
+// including when the Test fails or is interrupted. It's an alternative to
+// running sysctl with System, which leaves the parameters changed if the Test
+// doesn't reach its cleanup commands.
+//
+// Common parameters have typed fields, and others may be set using Param.
+// Parameters are set in the order of the fields, followed by Param in order by
+// name, and restored in the reverse order.
+type SetSysctl struct {
+	// TCPECN, if not negative, sets net.ipv4.tcp_ecn (0 to disable ECN, 1 to
+	// request it on outgoing connections, or 2 to accept it only). Since zero
+	// is a valid value, it's -1 by default in the CUE config.
+	TCPECN int
+
+	// TCPCongestionControl, if not empty, sets the default CCA in
+	// net.ipv4.tcp_congestion_control.
+	TCPCongestionControl string
+
+	// TCPRmem and TCPWmem, if not empty, set the min, default and max TCP
+	// receive and send buffer sizes in net.ipv4.tcp_rmem and
+	// net.ipv4.tcp_wmem.
+	TCPRmem []int
+	TCPWmem []int
+
+	// RmemMax and WmemMax, if not zero, set the maximum socket receive and
+	// send buffer sizes in net.core.rmem_max and net.core.wmem_max.
+	RmemMax int
+	WmemMax int
+
+	// Param maps other parameter names (e.g. net.core.default_qdisc) to the
+	// values to set.
+	Param map[string]string
+
+	orig []sysctlParam
+}
+
+// sysctlParam is a sysctl parameter name and value.
+type sysctlParam struct {
+	name  string
+	value string
+}
+
+// Run implements runner
+func (s *SetSysctl) Run(ctx context.Context, arg runArg) (ofb Feedback,
+	err error) {
+	for _, p := range s.params() {
+		var v string
+		if v, err = readSysctl(p.name); err != nil {
+			break
+		}
+		if err = writeSysctl(p.name, p.value); err != nil {
+			break
+		}
+		s.orig = append(s.orig, sysctlParam{p.name, v})
+		arg.rec.Logf("set %s=%s (was %s)", p.name, p.value, v)
+	}
+	if err != nil {
+		s.Cancel()
+		return
+	}
+	arg.cxl <- s
+	return
+}
+
+// Cancel implements canceler
+func (s *SetSysctl) Cancel() (err error) {
+	for i := len(s.orig) - 1; i >= 0; i-- {
+		p := s.orig[i]
+		if e := writeSysctl(p.name, p.value); e != nil && err == nil {
+			err = e
+		}
+	}
+	s.orig = nil
+	return
+}
+
+// params returns the parameters to set, in order.
+func (s *SetSysctl) params() (pp []sysctlParam) {
+	if s.TCPECN >= 0 {
+		pp = append(pp, sysctlParam{"net.ipv4.tcp_ecn", strconv.Itoa(s.TCPECN)})
+	}
+	if s.TCPCongestionControl != "" {
+		pp = append(pp, sysctlParam{"net.ipv4.tcp_congestion_control",
+			s.TCPCongestionControl})
+	}
+	if len(s.TCPRmem) > 0 {
+		pp = append(pp, sysctlParam{"net.ipv4.tcp_rmem", sysctlInts(s.TCPRmem)})
+	}
+	if len(s.TCPWmem) > 0 {
+		pp = append(pp, sysctlParam{"net.ipv4.tcp_wmem", sysctlInts(s.TCPWmem)})
+	}
+	if s.RmemMax > 0 {
+		pp = append(pp, sysctlParam{"net.core.rmem_max",
+			strconv.Itoa(s.RmemMax)})
+	}
+	if s.WmemMax > 0 {
+		pp = append(pp, sysctlParam{"net.core.wmem_max",
+			strconv.Itoa(s.WmemMax)})
+	}
+	var nn []string
+	for n := range s.Param {
+		nn = append(nn, n)
+	}
+	slices.Sort(nn)
+	for _, n := range nn {
+		pp = append(pp, sysctlParam{n, s.Param[n]})
+	}
+	return
+}
+
+// validate implements validater
+func (s *SetSysctl) validate() (err error) {
+	if len(s.params()) == 0 {
+		err = fmt.Errorf("SetSysctl requires at least one parameter")
+		return
+	}
+	if s.TCPECN > 2 {
+		err = fmt.Errorf("SetSysctl TCPECN must be from -1 to 2: %d", s.TCPECN)
+		return
+	}
+	for _, m := range [][]int{s.TCPRmem, s.TCPWmem} {
+		if len(m) > 0 && len(m) != 3 {
+			err = fmt.Errorf("SetSysctl TCPRmem and TCPWmem require three "+
+				"values (min, default and max): %v", m)
+			return
+		}
+	}
+	for n := range s.Param {
+		if n == "" || strings.Contains(n, "..") {
+			err = fmt.Errorf("invalid SetSysctl parameter name: '%s'", n)
+			return
+		}
+	}
+	return
+}
+
+// sysctlInts returns a list of ints as a sysctl value.
+func sysctlInts(ii []int) string {
+	var ss []string
+	for _, i := range ii {
+		ss = append(ss, strconv.Itoa(i))
+	}
+	return strings.Join(ss, " ")
+}
+
+// sysctlPath returns the path to the file for a sysctl parameter.
+func sysctlPath(name string) string {
+	return filepath.Join(sysctlDir, strings.ReplaceAll(name, ".", "/"))
+}
+
+// readSysctl returns the value of a sysctl parameter. Whitespace between fields
+// is normalized to one space.
+func readSysctl(name string) (value string, err error) {
+	var b []byte
+	if b, err = os.ReadFile(sysctlPath(name)); err != nil {
+		err = fmt.Errorf("unable to read sysctl %s: %w", name, err)
+		return
+	}
+	value = strings.Join(strings.Fields(string(b)), " ")
+	return
+}
+
+// writeSysctl sets the value of a sysctl parameter.
+func writeSysctl(name, value string) (err error) {
+	if err = os.WriteFile(sysctlPath(name), []byte(value),
+		0644); err != nil {
+		err = fmt.Errorf("unable to set sysctl %s to '%s': %w", name, value,
+			err)
+	}
+	return
+}
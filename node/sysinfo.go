@@ -191,7 +191,7 @@ func (s *SysInfoData) gather(ctx context.Context, info SysInfo) (err error) {
 		if d, err = f.Data(); err != nil {
 			return
 		}
-		s.File[f.Name()] = FileData{f.Name(), d}
+		s.File[f.Name()] = FileData{Name: f.Name(), Data: d}
 	}
 
 	// environment variables
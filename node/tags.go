@@ -0,0 +1,23 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+// Tags are arbitrary key/value pairs set in the config for a Stream or
+// PacketClient, and added to the data points they emit (StreamIO, PacketIO and
+// TCPInfo), so results may be sliced by tag in reporters.
+//
+// The PacketServer doesn't know the client's Tags, so the PacketIO it emits
+// have no Tags. Reporters use the Flow to associate these with tagged items.
+type Tags map[string]string
+
+// Match returns true if each of the given Tags is present with the same value.
+// Any Tags match if want is empty.
+func (t Tags) Match(want Tags) bool {
+	for k, v := range want {
+		if w, ok := t[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
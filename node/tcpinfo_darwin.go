@@ -0,0 +1,71 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"syscall"
+	"time"
+
+	"github.com/heistp/antler/node/metric"
+	"golang.org/x/sys/unix"
+)
+
+// getsockopt sets the counters from getsockopt(TCP_CONNECTION_INFO) for the
+// given raw conn. SentBytes excludes retransmitted bytes, to approximate
+// tcpi_bytes_acked on Linux.
+func (c *FlowCounters) getsockopt(raw syscall.RawConn) (err error) {
+	var i *unix.TCPConnectionInfo
+	if i, err = getsockoptConnectionInfo(raw); err != nil {
+		return
+	}
+	c.SentBytes = metric.Bytes(i.Txbytes - i.Txretransmitbytes)
+	c.ReceivedBytes = metric.Bytes(i.Rxbytes)
+	c.SentPackets = int(i.Txpackets)
+	c.ReceivedPackets = int(i.Rxpackets)
+	return
+}
+
+// getsockoptTCPInfo returns a TCPInfo for the given raw conn, using
+// getsockopt(TCP_CONNECTION_INFO). The TCPInfoID, T and SampleTime fields are
+// not set.
+//
+// macOS reports RTT and RTTVar in milliseconds, and the congestion window and
+// slow start threshold in bytes, which are converted to units of MSS. Only
+// RTT, RTTVar, SendSSThresh, TotalRetransmits, SendCwnd, SendMSS, RcvWnd,
+// BytesRetrans and SendWnd are set, as the other fields have no equivalent.
+func getsockoptTCPInfo(raw syscall.RawConn) (info TCPInfo, err error) {
+	var i *unix.TCPConnectionInfo
+	if i, err = getsockoptConnectionInfo(raw); err != nil {
+		return
+	}
+	info.RTT = time.Duration(i.Srtt) * time.Millisecond
+	info.RTTVar = time.Duration(i.Rttvar) * time.Millisecond
+	info.SendSSThresh = tcpInfiniteSSThresh
+	if i.Snd_ssthresh < tcpMaxSSThresh && i.Maxseg > 0 {
+		info.SendSSThresh = int(i.Snd_ssthresh / i.Maxseg)
+	}
+	info.TotalRetransmits = int(i.Txretransmitpackets)
+	if i.Maxseg > 0 {
+		info.SendCwnd = int(i.Snd_cwnd / i.Maxseg)
+	}
+	info.SendMSS = metric.Bytes(i.Maxseg)
+	info.RcvWnd = metric.Bytes(i.Rcv_wnd)
+	info.BytesRetrans = metric.Bytes(i.Txretransmitbytes)
+	info.SendWnd = metric.Bytes(i.Snd_wnd)
+	return
+}
+
+// getsockoptConnectionInfo returns the tcp_connection_info for the given raw
+// conn.
+func getsockoptConnectionInfo(raw syscall.RawConn) (
+	info *unix.TCPConnectionInfo, err error) {
+	var e error
+	if err = raw.Control(func(fd uintptr) {
+		info, e = unix.GetsockoptTCPConnectionInfo(int(fd), unix.IPPROTO_TCP,
+			unix.TCP_CONNECTION_INFO)
+	}); err == nil {
+		err = e
+	}
+	return
+}
@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/heistp/antler/node/metric"
+	"golang.org/x/sys/unix"
+)
+
+// Offsets of the used fields in FreeBSD's tcp_info struct, defined in
+// netinet/tcp.h.
+const (
+	tcpiSndMSS        = 16
+	tcpiRTT           = 68
+	tcpiRTTVar        = 72
+	tcpiSndSSThresh   = 76
+	tcpiSndCwnd       = 80
+	tcpiRcvSpace      = 96 // tp->rcv_wnd
+	tcpiSndWnd        = 100
+	tcpiSndRexmitPack = 120
+	tcpiDeliveredCE   = 132 // zero before FreeBSD 14
+)
+
+// getsockopt returns an error, as FreeBSD's tcp_info has no byte or packet
+// counters.
+func (c *FlowCounters) getsockopt(raw syscall.RawConn) error {
+	return errors.New("TCP FlowCounters not supported on FreeBSD")
+}
+
+// getsockoptTCPInfo returns a TCPInfo for the given raw conn, using
+// getsockopt(TCP_INFO). The TCPInfoID, T and SampleTime fields are not set.
+//
+// FreeBSD reports the congestion window and slow start threshold in bytes,
+// which are converted to units of MSS. Only RTT, RTTVar, SendSSThresh,
+// TotalRetransmits, SendCwnd, SendMSS, RcvWnd, DeliveredCE and SendWnd are
+// set, as the other fields have no equivalent.
+func getsockoptTCPInfo(raw syscall.RawConn) (info TCPInfo, err error) {
+	var b [256]byte
+	l := uint32(len(b))
+	var e error
+	if err = raw.Control(func(fd uintptr) {
+		_, _, n := unix.Syscall6(unix.SYS_GETSOCKOPT, fd, unix.IPPROTO_TCP,
+			unix.TCP_INFO, uintptr(unsafe.Pointer(&b[0])),
+			uintptr(unsafe.Pointer(&l)), 0)
+		if n != 0 {
+			e = n
+		}
+	}); err == nil {
+		err = e
+	}
+	if err != nil {
+		return
+	}
+	if l < tcpiDeliveredCE+4 {
+		err = fmt.Errorf("short tcp_info: %d bytes", l)
+		return
+	}
+	u := func(off int) uint32 {
+		return binary.NativeEndian.Uint32(b[off : off+4])
+	}
+	m := u(tcpiSndMSS)
+	info.RTT = time.Duration(u(tcpiRTT)) * time.Microsecond
+	info.RTTVar = time.Duration(u(tcpiRTTVar)) * time.Microsecond
+	info.SendSSThresh = tcpInfiniteSSThresh
+	if s := u(tcpiSndSSThresh); s < tcpMaxSSThresh && m > 0 {
+		info.SendSSThresh = int(s / m)
+	}
+	info.TotalRetransmits = int(u(tcpiSndRexmitPack))
+	if m > 0 {
+		info.SendCwnd = int(u(tcpiSndCwnd) / m)
+	}
+	info.SendMSS = metric.Bytes(m)
+	info.RcvWnd = metric.Bytes(u(tcpiRcvSpace))
+	info.DeliveredCE = int(u(tcpiDeliveredCE))
+	info.SendWnd = metric.Bytes(u(tcpiSndWnd))
+	return
+}
@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"syscall"
+	"time"
+
+	"github.com/heistp/antler/node/metric"
+	"golang.org/x/sys/unix"
+)
+
+// getsockopt sets the counters from getsockopt(TCP_INFO) for the given raw
+// conn.
+func (c *FlowCounters) getsockopt(raw syscall.RawConn) (err error) {
+	var i *unix.TCPInfo
+	if i, err = getsockoptLinuxTCPInfo(raw); err != nil {
+		return
+	}
+	c.SentBytes = metric.Bytes(i.Bytes_acked)
+	c.ReceivedBytes = metric.Bytes(i.Bytes_received)
+	c.SentPackets = int(i.Segs_out)
+	c.ReceivedPackets = int(i.Segs_in)
+	return
+}
+
+// newTCPInfo returns a new TCPInfo from a sockdiag sample.
+func newTCPInfo(id TCPInfoID, t metric.RelativeTime, st time.Duration,
+	ti *unix.TCPInfo) TCPInfo {
+	return TCPInfo{
+		id,
+		t,
+		st,
+		time.Duration(time.Duration(ti.Rtt) * time.Microsecond),
+		time.Duration(time.Duration(ti.Rttvar) * time.Microsecond),
+		int(ti.Snd_ssthresh),
+		int(ti.Total_retrans),
+		metric.Bitrate(ti.Delivery_rate * 8),
+		metric.Bitrate(ti.Pacing_rate * 8),
+		int(ti.Snd_cwnd),
+		metric.Bytes(ti.Snd_mss),
+		time.Duration(ti.Rwnd_limited) * time.Microsecond,
+		time.Duration(ti.Ato) * time.Microsecond,
+		time.Duration(ti.Rcv_rtt) * time.Microsecond,
+		metric.Bytes(ti.Rcv_space),
+		metric.Bytes(ti.Rcv_wnd),
+		time.Duration(ti.Min_rtt) * time.Microsecond,
+		metric.Bytes(ti.Bytes_acked),
+		metric.Bytes(ti.Bytes_retrans),
+		int(ti.Delivered),
+		int(ti.Delivered_ce),
+		metric.Bytes(ti.Snd_wnd),
+		time.Duration(ti.Busy_time) * time.Microsecond,
+		time.Duration(ti.Sndbuf_limited) * time.Microsecond,
+	}
+}
+
+// getsockoptTCPInfo returns a TCPInfo for the given raw conn, using
+// getsockopt(TCP_INFO). The TCPInfoID, T and SampleTime fields are not set.
+func getsockoptTCPInfo(raw syscall.RawConn) (info TCPInfo, err error) {
+	var i *unix.TCPInfo
+	if i, err = getsockoptLinuxTCPInfo(raw); err != nil {
+		return
+	}
+	info = newTCPInfo(TCPInfoID{}, 0, 0, i)
+	return
+}
+
+// getsockoptLinuxTCPInfo returns the tcp_info for the given raw conn, using
+// getsockopt(TCP_INFO).
+func getsockoptLinuxTCPInfo(raw syscall.RawConn) (info *unix.TCPInfo,
+	err error) {
+	var e error
+	if err = raw.Control(func(fd uintptr) {
+		info, e = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP,
+			unix.TCP_INFO)
+	}); err == nil {
+		err = e
+	}
+	return
+}
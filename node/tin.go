@@ -0,0 +1,343 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"github.com/heistp/antler/node/metric"
+)
+
+// cakeTinNames maps cake diffserv modes to the names of their tins, in the
+// order they're reported by tc. Tins for other modes are named by index.
+var cakeTinNames = map[string][]string{
+	"besteffort": {"Best Effort"},
+	"diffserv3":  {"Bulk", "Best Effort", "Voice"},
+	"diffserv4":  {"Bulk", "Best Effort", "Video", "Voice"},
+}
+
+// TinStats is a runner that samples the per-tin statistics of the cake and
+// fq_codel qdiscs on a network interface, using 'tc -s -j qdisc show dev
+// <Interface>', from the time it's run until the node's runners are canceled
+// at the end of the Test. A TinInfo data point is sent for each cake tin, and
+// for each fq_codel qdisc (as a single tin), for each sample. Other qdiscs on
+// the interface are ignored.
+//
+// TinStats should be placed after the qdiscs are configured, and before the
+// runners that generate traffic, so that sampling covers the entire Test.
+type TinStats struct {
+	// Interface is the network interface name (e.g. "eth0").
+	Interface string
+
+	// Interval is the sampling interval.
+	Interval metric.Duration
+
+	done chan struct{}
+	errc chan error
+}
+
+// Run implements runner
+func (s *TinStats) Run(ctx context.Context, arg runArg) (ofb Feedback,
+	err error) {
+	var ii []TinInfo
+	if ii, err = s.sample(arg.rec.nodeID); err != nil {
+		return
+	}
+	for _, i := range ii {
+		arg.rec.Send(i)
+	}
+	s.done = make(chan struct{})
+	s.errc = make(chan error, 1)
+	go s.run(arg.rec)
+	arg.cxl <- s
+	return
+}
+
+// run samples the tin statistics each Interval, until done is closed.
+func (s *TinStats) run(rec *recorder) {
+	var err error
+	defer func() {
+		s.errc <- err
+		close(s.errc)
+	}()
+	t := time.NewTicker(s.Interval.Duration())
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			var ii []TinInfo
+			if ii, err = s.sample(rec.nodeID); err != nil {
+				return
+			}
+			for _, i := range ii {
+				rec.Send(i)
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Cancel implements canceler
+func (s *TinStats) Cancel() error {
+	close(s.done)
+	return <-s.errc
+}
+
+// sample runs tc and returns the parsed TinInfo for each tin.
+func (s *TinStats) sample(nodeID ID) (info []TinInfo, err error) {
+	c := exec.Command("tc", "-s", "-j", "qdisc", "show", "dev", s.Interface)
+	var o []byte
+	t := metric.Now()
+	if o, err = c.Output(); err != nil {
+		err = fmt.Errorf("tc qdisc show for %s: %w", s.Interface, err)
+		return
+	}
+	if info, err = parseTcQdiscJSON(o); err != nil {
+		err = fmt.Errorf("tc qdisc show for %s: %w", s.Interface, err)
+		return
+	}
+	for i := range info {
+		info[i].Tinit = metric.Tinit
+		info[i].NodeID = nodeID
+		info[i].Interface = s.Interface
+		info[i].T = t
+	}
+	return
+}
+
+// validate implements validater
+func (s *TinStats) validate() (err error) {
+	if s.Interface == "" {
+		err = fmt.Errorf("TinStats requires an Interface")
+		return
+	}
+	if s.Interval <= 0 {
+		err = fmt.Errorf("TinStats Interval must be positive: %s", s.Interval)
+	}
+	return
+}
+
+// tcQdisc is a qdisc in the output of 'tc -s -j qdisc show'. Only the fields
+// used for cake and fq_codel are included.
+type tcQdisc struct {
+	Kind    string `json:"kind"`
+	Handle  string `json:"handle"`
+	Parent  string `json:"parent"`
+	Root    bool   `json:"root"`
+	Options struct {
+		Diffserv string `json:"diffserv"`
+	} `json:"options"`
+	Bytes       uint64  `json:"bytes"`
+	Packets     uint64  `json:"packets"`
+	Drops       uint64  `json:"drops"`
+	Backlog     uint64  `json:"backlog"`
+	MaxPacket   uint64  `json:"maxpacket"`
+	ECNMark     uint64  `json:"ecn_mark"`
+	NewFlowsLen uint32  `json:"new_flows_len"`
+	OldFlowsLen uint32  `json:"old_flows_len"`
+	Tins        []tcTin `json:"tins"`
+}
+
+// tcTin is a cake tin in the output of 'tc -s -j qdisc show'.
+type tcTin struct {
+	ThresholdRate     uint64 `json:"threshold_rate"`
+	SentBytes         uint64 `json:"sent_bytes"`
+	BacklogBytes      uint64 `json:"backlog_bytes"`
+	TargetUs          uint64 `json:"target_us"`
+	IntervalUs        uint64 `json:"interval_us"`
+	PeakDelayUs       uint64 `json:"peak_delay_us"`
+	AvgDelayUs        uint64 `json:"avg_delay_us"`
+	BaseDelayUs       uint64 `json:"base_delay_us"`
+	SentPackets       uint64 `json:"sent_packets"`
+	Drops             uint64 `json:"drops"`
+	ECNMark           uint64 `json:"ecn_mark"`
+	AckDrops          uint64 `json:"ack_drops"`
+	SparseFlows       uint32 `json:"sparse_flows"`
+	BulkFlows         uint32 `json:"bulk_flows"`
+	UnresponsiveFlows uint32 `json:"unresponsive_flows"`
+	MaxPktLen         uint64 `json:"max_pkt_len"`
+}
+
+// parseTcQdiscJSON parses the output of 'tc -s -j qdisc show', and returns a
+// TinInfo for each cake tin and fq_codel qdisc. The NodeID, Interface, T and
+// Tinit fields are not set.
+func parseTcQdiscJSON(out []byte) (info []TinInfo, err error) {
+	var qq []tcQdisc
+	if err = json.Unmarshal(out, &qq); err != nil {
+		return
+	}
+	us := func(v uint64) time.Duration {
+		return time.Duration(v) * time.Microsecond
+	}
+	for _, q := range qq {
+		p := q.Parent
+		if q.Root {
+			p = "root"
+		}
+		switch q.Kind {
+		case "cake":
+			n := cakeTinNames[q.Options.Diffserv]
+			for i, t := range q.Tins {
+				m := fmt.Sprintf("Tin %d", i)
+				if i < len(n) {
+					m = n[i]
+				}
+				info = append(info, TinInfo{
+					Kind:              q.Kind,
+					Handle:            q.Handle,
+					Parent:            p,
+					Tin:               i,
+					Name:              m,
+					ThresholdRate:     metric.Bitrate(t.ThresholdRate * 8),
+					Target:            us(t.TargetUs),
+					Interval:          us(t.IntervalUs),
+					PeakDelay:         us(t.PeakDelayUs),
+					AvgDelay:          us(t.AvgDelayUs),
+					BaseDelay:         us(t.BaseDelayUs),
+					Bytes:             metric.Bytes(t.SentBytes),
+					Packets:           t.SentPackets,
+					Backlog:           metric.Bytes(t.BacklogBytes),
+					Drops:             t.Drops,
+					Marks:             t.ECNMark,
+					AckDrops:          t.AckDrops,
+					SparseFlows:       t.SparseFlows,
+					BulkFlows:         t.BulkFlows,
+					UnresponsiveFlows: t.UnresponsiveFlows,
+					MaxPacketLen:      metric.Bytes(t.MaxPktLen),
+				})
+			}
+		case "fq_codel":
+			info = append(info, TinInfo{
+				Kind:         q.Kind,
+				Handle:       q.Handle,
+				Parent:       p,
+				Name:         "Tin 0",
+				Bytes:        metric.Bytes(q.Bytes),
+				Packets:      q.Packets,
+				Backlog:      metric.Bytes(q.Backlog),
+				Drops:        q.Drops,
+				Marks:        q.ECNMark,
+				SparseFlows:  q.NewFlowsLen,
+				BulkFlows:    q.OldFlowsLen,
+				MaxPacketLen: metric.Bytes(q.MaxPacket),
+			})
+		}
+	}
+	return
+}
+
+// TinInfo is a time series data point containing the statistics for one tin
+// of a cake qdisc, or an fq_codel qdisc, as sampled by TinStats. Counters are
+// cumulative since the qdisc was created. For fq_codel, which has no tins, Tin
+// is 0, and the rate and delay fields are zero, as they're not reported.
+type TinInfo struct {
+	// Tinit is the base time for T.
+	Tinit time.Time
+
+	// T is the node-relative time the sample was taken.
+	T metric.RelativeTime
+
+	// NodeID is the ID of the node that took the sample.
+	NodeID ID
+
+	// Interface is the network interface name.
+	Interface string
+
+	// Kind is the kind of qdisc (cake or fq_codel).
+	Kind string
+
+	// Handle is the qdisc's handle (e.g. "1:").
+	Handle string
+
+	// Parent is the qdisc's parent (e.g. "root" or "1:1").
+	Parent string
+
+	// Tin is the index of the tin, in the order reported by tc.
+	Tin int
+
+	// Name is the name of the tin (e.g. "Best Effort" or "Tin 0").
+	Name string
+
+	// ThresholdRate is the tin's bandwidth threshold.
+	ThresholdRate metric.Bitrate
+
+	// Target is the tin's AQM target delay.
+	Target time.Duration
+
+	// Interval is the tin's AQM interval.
+	Interval time.Duration
+
+	// PeakDelay is the peak sojourn time of packets in the tin.
+	PeakDelay time.Duration
+
+	// AvgDelay is the average sojourn time of packets in the tin.
+	AvgDelay time.Duration
+
+	// BaseDelay is the base (minimum) sojourn time of packets in the tin.
+	BaseDelay time.Duration
+
+	// Bytes is the number of bytes sent.
+	Bytes metric.Bytes
+
+	// Packets is the number of packets sent.
+	Packets uint64
+
+	// Backlog is the current queue length, in bytes.
+	Backlog metric.Bytes
+
+	// Drops is the number of packets dropped.
+	Drops uint64
+
+	// Marks is the number of packets marked with ECN CE.
+	Marks uint64
+
+	// AckDrops is the number of ACKs dropped by the ACK filter.
+	AckDrops uint64
+
+	// SparseFlows is the number of sparse flows (new flows, for fq_codel).
+	SparseFlows uint32
+
+	// BulkFlows is the number of bulk flows (old flows, for fq_codel).
+	BulkFlows uint32
+
+	// UnresponsiveFlows is the number of flows that are unresponsive to
+	// congestion signals.
+	UnresponsiveFlows uint32
+
+	// MaxPacketLen is the length of the largest packet seen.
+	MaxPacketLen metric.Bytes
+}
+
+// init registers TinInfo with the gob encoder
+func init() {
+	gob.Register(TinInfo{})
+}
+
+// Time returns an absolute time from the node-relative time T.
+func (t TinInfo) Time() time.Time {
+	return t.Tinit.Add(time.Duration(t.T))
+}
+
+// flags implements message
+func (TinInfo) flags() flag {
+	return flagForward
+}
+
+// handle implements event
+func (t TinInfo) handle(node *node) {
+	node.parent.Send(t)
+}
+
+func (t TinInfo) String() string {
+	return fmt.Sprintf("TinInfo[NodeID:%s Interface:%s Kind:%s Handle:%s "+
+		"Tin:%d T:%s AvgDelay:%s PeakDelay:%s Backlog:%d Drops:%d Marks:%d]",
+		t.NodeID, t.Interface, t.Kind, t.Handle, t.Tin, t.T, t.AvgDelay,
+		t.PeakDelay, t.Backlog, t.Drops, t.Marks)
+}
@@ -0,0 +1,315 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/netip"
+	"os/exec"
+	"strconv"
+)
+
+// Topology is a runner that builds a network topology declaratively, using ip,
+// from a graph of network namespaces, veth links, addresses and routes. It's an
+// alternative to building the topology with many System runners, which
+// requires matching commands to tear it down.
+//
+// The topology is built in order: Namespaces are added, then Links, then
+// Routes. At the end of the Test, including when the Test fails or is
+// interrupted, it's torn down in reverse order, by deleting the links and
+// routes that weren't in a namespace it added, then its namespaces, which
+// removes everything within them. If building fails, whatever was built is torn
+// down before Run returns.
+//
+// An empty Namespace name in a Link endpoint or Route refers to the node's own
+// namespace. Child nodes may be launched in the namespaces with Netns, by Name,
+// with Create false, after Topology has run.
+type Topology struct {
+	// Namespace lists the network namespaces to add.
+	Namespace []TopologyNamespace
+
+	// Link lists the veth links to add.
+	Link []TopologyLink
+
+	// Route lists the routes to add.
+	Route []TopologyRoute
+
+	undo [][]string
+	rec  *recorder
+}
+
+// TopologyNamespace is a network namespace added by Topology.
+type TopologyNamespace struct {
+	// Name is the name of the namespace (man ip-netns(8)).
+	Name string
+
+	// Forward, if true, enables IPv4 and IPv6 forwarding in the namespace, so
+	// it may be used as a router.
+	Forward bool
+}
+
+// TopologyLink is a veth link added by Topology, between endpoints A and B.
+type TopologyLink struct {
+	// A is the first endpoint.
+	A TopologyEndpoint
+
+	// B is the second endpoint.
+	B TopologyEndpoint
+
+	// MTU, if not zero, is the MTU of both endpoints.
+	MTU int
+}
+
+// TopologyEndpoint is one end of a TopologyLink.
+type TopologyEndpoint struct {
+	// Namespace is the name of the endpoint's namespace, or empty for the
+	// node's namespace.
+	Namespace string
+
+	// Interface is the name of the endpoint's interface.
+	Interface string
+
+	// Address lists the addresses to add to the interface, in CIDR notation
+	// (e.g. "10.0.0.1/24" or "fd00::1/64").
+	Address []string
+}
+
+// TopologyRoute is a route added by Topology.
+type TopologyRoute struct {
+	// Namespace is the name of the namespace to add the route in, or empty for
+	// the node's namespace.
+	Namespace string
+
+	// Destination is the route's destination prefix in CIDR notation, or
+	// "default".
+	Destination string
+
+	// Via, if not empty, is the address of the next hop.
+	Via string
+
+	// Device, if not empty, is the name of the output interface.
+	Device string
+}
+
+// Run implements runner
+func (t *Topology) Run(ctx context.Context, arg runArg) (ofb Feedback,
+	err error) {
+	t.rec = arg.rec
+	t.undo = nil
+	if err = t.build(ctx); err != nil {
+		t.Cancel()
+		return
+	}
+	arg.cxl <- t
+	return
+}
+
+// build builds the topology, and records the commands to tear it down in undo.
+func (t *Topology) build(ctx context.Context) (err error) {
+	added := make(map[string]bool)
+	for _, n := range t.Namespace {
+		if err = t.ip(ctx, "netns", "add", n.Name); err != nil {
+			return
+		}
+		added[n.Name] = true
+		t.undo = append(t.undo, []string{"netns", "del", n.Name})
+		if err = t.ip(ctx, "-n", n.Name, "link", "set", "lo",
+			"up"); err != nil {
+			return
+		}
+		if n.Forward {
+			for _, p := range []string{
+				"net.ipv4.conf.all.forwarding=1",
+				"net.ipv6.conf.all.forwarding=1",
+			} {
+				if err = t.ip(ctx, "netns", "exec", n.Name, "sysctl", "-qw",
+					p); err != nil {
+					return
+				}
+			}
+		}
+	}
+	for _, l := range t.Link {
+		// add the link from the first endpoint's namespace, with the peer in
+		// its namespace, so neither is added to the node's namespace first
+		a, b := l.A, l.B
+		if a.Namespace != "" && b.Namespace == "" {
+			a, b = b, a
+		}
+		c := nsArgs(a.Namespace, "link", "add", a.Interface, "type", "veth",
+			"peer", "name", b.Interface)
+		if b.Namespace != "" {
+			c = append(c, "netns", b.Namespace)
+		}
+		if err = t.ip(ctx, c...); err != nil {
+			return
+		}
+		// the link is deleted with either namespace, if it was added here
+		if !added[a.Namespace] && !added[b.Namespace] {
+			t.undo = append(t.undo, nsArgs(a.Namespace, "link", "del",
+				a.Interface))
+		}
+		for _, e := range []TopologyEndpoint{l.A, l.B} {
+			if l.MTU > 0 {
+				if err = t.ip(ctx, nsArgs(e.Namespace, "link", "set",
+					e.Interface, "mtu", strconv.Itoa(l.MTU))...); err != nil {
+					return
+				}
+			}
+			for _, a := range e.Address {
+				if err = t.ip(ctx, nsArgs(e.Namespace, "addr", "add", a,
+					"dev", e.Interface)...); err != nil {
+					return
+				}
+			}
+			if err = t.ip(ctx, nsArgs(e.Namespace, "link", "set",
+				e.Interface, "up")...); err != nil {
+				return
+			}
+		}
+	}
+	for _, r := range t.Route {
+		a := r.args()
+		if err = t.ip(ctx, nsArgs(r.Namespace,
+			append([]string{"route", "add"}, a...)...)...); err != nil {
+			return
+		}
+		if !added[r.Namespace] {
+			t.undo = append(t.undo, nsArgs(r.Namespace,
+				append([]string{"route", "del"}, a...)...))
+		}
+	}
+	return
+}
+
+// Cancel implements canceler
+func (t *Topology) Cancel() (err error) {
+	for i := len(t.undo) - 1; i >= 0; i-- {
+		if e := t.ip(context.Background(), t.undo[i]...); e != nil {
+			t.rec.Logf("%s", e)
+			if err == nil {
+				err = e
+			}
+		}
+	}
+	t.undo = nil
+	return
+}
+
+// ip runs ip with the given arguments. The returned error includes ip's output.
+func (t *Topology) ip(ctx context.Context, arg ...string) (err error) {
+	c := exec.CommandContext(ctx, "ip", arg...)
+	t.rec.Logf("%s", c)
+	var o []byte
+	if o, err = c.CombinedOutput(); err != nil {
+		err = fmt.Errorf("%w (%s: %s)", err, c, bytes.TrimSpace(o))
+	}
+	return
+}
+
+// nsArgs returns the arguments to ip to run in the given namespace, or the
+// arguments unchanged if the namespace is empty.
+func nsArgs(namespace string, arg ...string) []string {
+	if namespace == "" {
+		return arg
+	}
+	return append([]string{"-n", namespace}, arg...)
+}
+
+// args returns the arguments to ip route add or del, after the command.
+func (r TopologyRoute) args() (a []string) {
+	a = append(a, r.Destination)
+	if r.Via != "" {
+		a = append(a, "via", r.Via)
+	}
+	if r.Device != "" {
+		a = append(a, "dev", r.Device)
+	}
+	return
+}
+
+// forward returns true if any namespace has Forward set.
+func (t *Topology) forward() bool {
+	for _, n := range t.Namespace {
+		if n.Forward {
+			return true
+		}
+	}
+	return false
+}
+
+// validate implements validater
+func (t *Topology) validate() (err error) {
+	ns := map[string]bool{"": true}
+	for _, n := range t.Namespace {
+		if n.Name == "" {
+			err = fmt.Errorf("Topology Namespace requires a Name")
+			return
+		}
+		if ns[n.Name] {
+			err = fmt.Errorf("Topology Namespace %s is duplicated", n.Name)
+			return
+		}
+		ns[n.Name] = true
+	}
+	type nsif struct {
+		namespace string
+		iface     string
+	}
+	ifs := make(map[nsif]bool)
+	for _, l := range t.Link {
+		if l.MTU < 0 {
+			err = fmt.Errorf("Topology Link MTU may not be negative: %d",
+				l.MTU)
+			return
+		}
+		for _, e := range []TopologyEndpoint{l.A, l.B} {
+			if e.Interface == "" {
+				err = fmt.Errorf("Topology Link endpoint requires an Interface")
+				return
+			}
+			if len(e.Interface) > 15 {
+				err = fmt.Errorf("Topology Link Interface %s is longer than "+
+					"15 characters", e.Interface)
+				return
+			}
+			k := nsif{e.Namespace, e.Interface}
+			if ifs[k] {
+				err = fmt.Errorf("Topology Link Interface %s is duplicated "+
+					"in namespace '%s'", e.Interface, e.Namespace)
+				return
+			}
+			ifs[k] = true
+			for _, a := range e.Address {
+				if _, err = netip.ParsePrefix(a); err != nil {
+					err = fmt.Errorf("Topology Link Interface %s: %w",
+						e.Interface, err)
+					return
+				}
+			}
+		}
+	}
+	for _, r := range t.Route {
+		if r.Destination != "default" {
+			if _, err = netip.ParsePrefix(r.Destination); err != nil {
+				err = fmt.Errorf("Topology Route Destination: %w", err)
+				return
+			}
+		}
+		if r.Via == "" && r.Device == "" {
+			err = fmt.Errorf("Topology Route to %s requires Via or Device",
+				r.Destination)
+			return
+		}
+		if r.Via != "" {
+			if _, err = netip.ParseAddr(r.Via); err != nil {
+				err = fmt.Errorf("Topology Route Via: %w", err)
+				return
+			}
+		}
+	}
+	return
+}
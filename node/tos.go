@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// tosOOBLen is the length of the buffer for control messages containing the
+// ToS byte or Traffic Class.
+var tosOOBLen = unix.CmsgSpace(4)
+
+// enableRecvTOS enables receiving the ToS byte (IPv4) or Traffic Class (IPv6)
+// as a control message for packets read from the given UDPConn. Both options
+// are tried, so dual-stack sockets report both. An error is returned only if
+// neither option could be set.
+func enableRecvTOS(conn *net.UDPConn) (err error) {
+	var c syscall.RawConn
+	if c, err = conn.SyscallConn(); err != nil {
+		return
+	}
+	var e4, e6 error
+	if err = c.Control(func(fd uintptr) {
+		e4 = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_RECVTOS, 1)
+		e6 = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6,
+			unix.IPV6_RECVTCLASS, 1)
+	}); err != nil {
+		return
+	}
+	if e4 != nil && e6 != nil {
+		err = e4
+	}
+	return
+}
+
+// readTOS reads a packet from the given UDPConn into b, and returns the ToS
+// byte or Traffic Class it was received with. ok is false if it wasn't
+// available, e.g. if enableRecvTOS wasn't called or failed.
+func readTOS(conn *net.UDPConn, b, oob []byte) (n int, addr net.Addr,
+	tos byte, ok bool, err error) {
+	var oobn int
+	var a *net.UDPAddr
+	if n, oobn, _, a, err = conn.ReadMsgUDP(b, oob); err != nil {
+		return
+	}
+	addr = a
+	var mm []unix.SocketControlMessage
+	if mm, err = unix.ParseSocketControlMessage(oob[:oobn]); err != nil {
+		return
+	}
+	for _, m := range mm {
+		switch {
+		case m.Header.Level == unix.IPPROTO_IP &&
+			m.Header.Type == unix.IP_TOS && len(m.Data) >= 1:
+			tos, ok = m.Data[0], true
+		case m.Header.Level == unix.IPPROTO_IPV6 &&
+			m.Header.Type == unix.IPV6_TCLASS && len(m.Data) >= 4:
+			tos, ok = byte(binary.NativeEndian.Uint32(m.Data)), true
+		}
+	}
+	return
+}
+
+// writeTOS writes a packet to the given UDPConn with the given ToS byte or
+// Traffic Class, overriding the socket's setting. For connected sockets, addr
+// must be nil.
+func writeTOS(conn *net.UDPConn, b []byte, addr *net.UDPAddr,
+	tos byte) (err error) {
+	d := addr
+	if d == nil {
+		d, _ = conn.RemoteAddr().(*net.UDPAddr)
+	}
+	l, t := unix.IPPROTO_IPV6, unix.IPV6_TCLASS
+	if d == nil || d.IP.To4() != nil {
+		l, t = unix.IPPROTO_IP, unix.IP_TOS
+	}
+	oob := make([]byte, tosOOBLen)
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	h.Level = int32(l)
+	h.Type = int32(t)
+	h.SetLen(unix.CmsgLen(4))
+	binary.NativeEndian.PutUint32(oob[unix.CmsgLen(0):], uint32(tos))
+	_, _, err = conn.WriteMsgUDP(b, oob, addr)
+	return
+}
+
+// tos returns the ToS byte for the given DSCP and ECN values.
+func tos(dscp, ecn byte) byte {
+	return dscp<<2 | ecn&0x3
+}
@@ -20,16 +20,28 @@ type transport interface {
 	io.Closer
 }
 
+// sendCounter is implemented by transports that count the bytes they send.
+type sendCounter interface {
+	sent() int64 // returns the total number of bytes sent
+}
+
 // gobTransport is a transport that uses gob.
 type gobTransport struct {
 	closer io.Closer
 	enc    *gob.Encoder
 	dec    *gob.Decoder
+	w      *countWriter
 }
 
 // newGobTransport returns a new gobTransport for the given underlying conn.
 func newGobTransport(conn io.ReadWriteCloser) *gobTransport {
-	return &gobTransport{conn, gob.NewEncoder(conn), gob.NewDecoder(conn)}
+	w := &countWriter{conn, 0}
+	return &gobTransport{conn, gob.NewEncoder(w), gob.NewDecoder(conn), w}
+}
+
+// sent implements sendCounter
+func (g *gobTransport) sent() int64 {
+	return g.w.n
 }
 
 // Send implements transport
@@ -61,6 +73,20 @@ func (g *gobTransport) Close() error {
 	return g.closer.Close()
 }
 
+// countWriter is an io.Writer that counts the bytes written to the underlying
+// Writer. It's not safe for concurrent use.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write implements io.Writer
+func (c *countWriter) Write(p []byte) (n int, err error) {
+	n, err = c.w.Write(p)
+	c.n += int64(n)
+	return
+}
+
 // channelTransport is a transport that uses channels.
 type channelTransport struct {
 	recv chan message
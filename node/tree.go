@@ -54,6 +54,23 @@ func (t Tree) Platforms() (platform []string) {
 	return
 }
 
+// processes returns the NodeProcess's for the Nodes in the Tree, in the order
+// given, with their Netns set. Any for Nodes not in the Tree (e.g. the root
+// node) are omitted.
+func (t Tree) processes(proc []NodeProcess) (pp []NodeProcess) {
+	for _, p := range proc {
+		t.Walk(func(n Node) bool {
+			if n.ID != p.NodeID {
+				return true
+			}
+			p.Netns = n.netnsName()
+			pp = append(pp, p)
+			return false
+		})
+	}
+	return
+}
+
 // Walk calls the given visitor func for each Node in the Tree. If visitor
 // returns false, the walk is terminated and false is returned.
 func (t Tree) Walk(visitor func(Node) bool) bool {
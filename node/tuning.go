@@ -0,0 +1,21 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import "github.com/heistp/antler/node/metric"
+
+// Tuning contains channel depths and buffer sizes for the nodes, which may be
+// adjusted for the resources of the hosts (e.g. a Raspberry Pi vs a 100GbE
+// server). Zero values select the defaults.
+type Tuning struct {
+	// ConnTxLen is the maximum number of messages that a conn passes to its
+	// transport at once. Larger values may increase throughput for high message
+	// rates, at the expense of memory and latency.
+	ConnTxLen int
+
+	// FileRate, if not zero, limits the rate at which each node sends
+	// FileData (e.g. from PacketCapture or System output), so that large files
+	// don't starve other messages on the conns.
+	FileRate metric.Bitrate
+}
@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"time"
+
+	"github.com/heistp/antler/node/metric"
+)
+
+// Watchdog is a runner that records the node's goroutine count and open file
+// descriptors when run, then compares them again after the node's runners have
+// been canceled at the end of the Test. Any increase is logged as a possible
+// leak, e.g. from a server that didn't close its listener or connections.
+//
+// Watchdog should be placed before any other runners on the node, so that its
+// baseline is taken first, and its canceler is called last.
+type Watchdog struct {
+	// Settle is the maximum time to wait for goroutines and file descriptors to
+	// return to their baseline after cancellation, before reporting.
+	Settle metric.Duration
+
+	// Stacks, if true, saves a goroutine dump to a file named
+	// goroutines_<nodeID>.txt when a leak is detected.
+	Stacks bool
+
+	// Fail, if true, returns an error when a leak is detected, instead of only
+	// logging it.
+	Fail bool
+
+	goroutines int
+	fds        int
+	rec        *recorder
+}
+
+// Run implements runner
+func (w *Watchdog) Run(ctx context.Context, arg runArg) (ofb Feedback,
+	err error) {
+	// initialize the runtime's network poller, so its descriptors are included
+	// in the baseline
+	if r, p, e := os.Pipe(); e == nil {
+		r.Close()
+		p.Close()
+	}
+	w.goroutines = runtime.NumGoroutine()
+	w.fds = openFDs()
+	w.rec = arg.rec
+	w.rec.Logf("baseline goroutines:%d fds:%d", w.goroutines, w.fds)
+	arg.cxl <- w
+	return
+}
+
+// Cancel implements canceler
+func (w *Watchdog) Cancel() (err error) {
+	var g, f int
+	t := time.Now()
+	for {
+		g, f = runtime.NumGoroutine(), openFDs()
+		if (g <= w.goroutines && f <= w.fds) ||
+			time.Since(t) >= w.Settle.Duration() {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if g <= w.goroutines && f <= w.fds {
+		w.rec.Logf("no leaks, goroutines:%d fds:%d", g, f)
+		return
+	}
+	m := fmt.Sprintf("possible leak, goroutines:%d->%d fds:%d->%d",
+		w.goroutines, g, w.fds, f)
+	if w.Stacks && g > w.goroutines {
+		var b bytes.Buffer
+		if e := pprof.Lookup("goroutine").WriteTo(&b, 2); e == nil {
+			n := fmt.Sprintf("goroutines_%s.txt", w.rec.nodeID)
+			w.rec.FileData(n, b.Bytes())
+		}
+	}
+	if w.Fail {
+		err = fmt.Errorf("%s", m)
+		return
+	}
+	w.rec.Warnf("%s", m)
+	return
+}
+
+// openFDs returns the number of open file descriptors for the process, or -1
+// if it can't be determined on this platform.
+func openFDs() int {
+	e, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	// exclude the fd used to read the directory
+	return len(e) - 1
+}
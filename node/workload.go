@@ -0,0 +1,342 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package node
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/heistp/antler/node/metric"
+)
+
+// Workload is a composite Run that assembles a standard mix of bulk, RPC and
+// real-time flows from a client to a StreamServer and PacketServer, so common
+// benchmark scenarios don't have to be rebuilt for each test. A Workload is
+// expanded into a Parallel Run of StreamClients and PacketClients when the
+// config is loaded (see ExpandWorkloads).
+//
+// Flows are named with the Prefix, followed by the kind of flow and a number
+// starting from 1, e.g. "up1", "down1", "rpc1" and "probe1". For Presets with
+// DSCP classes, flows are instead named with the kind and class, e.g. "up-ef".
+type Workload struct {
+	// Preset selects a standard mix. The number of each kind of flow that
+	// isn't set explicitly defaults to that of the Preset in the config
+	// schema, while workloadPresets defines its DSCP classes and intervals.
+	Preset string
+
+	// Prefix is prepended to each generated Flow ID.
+	Prefix string
+
+	// StreamAddr is the StreamServer dial address, for Upload, Download and
+	// RPC flows.
+	StreamAddr string
+
+	// PacketAddr is the PacketServer dial address, for Probe flows.
+	PacketAddr string
+
+	// Duration is the length of each flow.
+	Duration metric.Duration
+
+	// Upload is the number of bulk Upload flows.
+	Upload int
+
+	// Download is the number of bulk Download flows.
+	Download int
+
+	// RPC is the number of RPC flows.
+	RPC int
+
+	// Probe is the number of latency probe flows, which send echo requests
+	// every ProbeInterval.
+	Probe int
+
+	// CCA is the Congestion Control Algorithm for stream flows.
+	CCA string
+
+	// IOSampleInterval is the IOSampleInterval for Upload and Download flows.
+	IOSampleInterval metric.Duration
+
+	// TCPInfoInterval is the TCPInfoInterval for Upload and Download flows.
+	TCPInfoInterval metric.Duration
+
+	// BufLen is the BufLen for Upload and Download flows.
+	BufLen int
+
+	// RPCInterval is the time between requests for RPC flows.
+	RPCInterval metric.Duration
+
+	// RPCRequestLength is the request length for RPC flows.
+	RPCRequestLength metric.Bytes
+
+	// RPCResponseLength is the response length for RPC flows.
+	RPCResponseLength metric.Bytes
+
+	// ProbeInterval is the time between echo requests for Probe flows.
+	ProbeInterval metric.Duration
+
+	// ProbeLength is the packet length for Probe flows.
+	ProbeLength int
+}
+
+// workloadPreset contains the DSCP classes and intervals for a Preset. The
+// number of each kind of flow is set by the Workload defaults in config.cue.
+type workloadPreset struct {
+	// class lists the DSCP classes assigned to bulk flows, cycling through
+	// the list for each kind of flow, or nil for no DSCP.
+	class []dscpClass
+
+	// probeClass lists the DSCP classes assigned to Probe flows.
+	probeClass []dscpClass
+
+	// interval is the default IOSampleInterval and ProbeInterval.
+	interval time.Duration
+}
+
+// dscpClass is a named DSCP value.
+type dscpClass struct {
+	name string
+	dscp byte
+}
+
+// DSCP classes used by the rrul Preset, as in flent's RRUL test.
+var (
+	dscpBE  = dscpClass{"be", 0}
+	dscpBK  = dscpClass{"bk", 8}
+	dscpCS5 = dscpClass{"cs5", 40}
+	dscpEF  = dscpClass{"ef", 46}
+)
+
+// workloadPresets are the standard Workload mixes, by Preset name.
+var workloadPresets = map[string]workloadPreset{
+	// rrul: the flent Realtime Response Under Load test, with four bulk flows
+	// in each direction in the BE, BK, CS5 and EF classes, and latency probes
+	// in the EF, BE and BK classes
+	"rrul": {
+		[]dscpClass{dscpBE, dscpBK, dscpCS5, dscpEF},
+		[]dscpClass{dscpEF, dscpBE, dscpBK},
+		200 * time.Millisecond},
+	// rrul-like: four bulk flows in each direction, with a latency probe
+	"rrul-like": {},
+	// bulk-rpc: one bulk flow in each direction, with RPC and a latency probe
+	"bulk-rpc": {},
+	// upload: one bulk upload, with a latency probe
+	"upload": {},
+	// download: one bulk download, with a latency probe
+	"download": {},
+}
+
+// Default Workload field values.
+const (
+	workloadDuration          = time.Minute
+	workloadRPCInterval       = 100 * time.Millisecond
+	workloadRPCRequestLength  = 64
+	workloadRPCResponseLength = 1024
+	workloadProbeInterval     = 100 * time.Millisecond
+	workloadMaxPacketSize     = 1500 - 20
+	workloadBufLen            = 128 * 1024
+)
+
+// preset returns the workloadPreset for the Workload, or the zero value if
+// Preset is empty.
+func (w Workload) preset() (p workloadPreset, err error) {
+	if w.Preset == "" {
+		return
+	}
+	var ok bool
+	if p, ok = workloadPresets[w.Preset]; !ok {
+		err = fmt.Errorf("unknown Workload Preset: '%s'", w.Preset)
+	}
+	return
+}
+
+// withDefaults returns a copy of the Workload with the Preset intervals and any
+// unset fields applied. The number of each kind of flow is used as is, so that
+// an explicit zero is not replaced by the Preset's count.
+func (w Workload) withDefaults() (d Workload, err error) {
+	d = w
+	var p workloadPreset
+	if p, err = d.preset(); err != nil {
+		return
+	}
+	if p.interval > 0 {
+		if d.IOSampleInterval == 0 {
+			d.IOSampleInterval = metric.Duration(p.interval)
+		}
+		if d.ProbeInterval == 0 {
+			d.ProbeInterval = metric.Duration(p.interval)
+		}
+	}
+	if d.Duration == 0 {
+		d.Duration = metric.Duration(workloadDuration)
+	}
+	if d.BufLen == 0 {
+		d.BufLen = workloadBufLen
+	}
+	if d.RPCInterval == 0 {
+		d.RPCInterval = metric.Duration(workloadRPCInterval)
+	}
+	if d.RPCRequestLength == 0 {
+		d.RPCRequestLength = workloadRPCRequestLength
+	}
+	if d.RPCResponseLength == 0 {
+		d.RPCResponseLength = workloadRPCResponseLength
+	}
+	if d.ProbeInterval == 0 {
+		d.ProbeInterval = metric.Duration(workloadProbeInterval)
+	}
+	return
+}
+
+// runs returns the Runs for the Workload.
+func (w Workload) runs() (run []Run, err error) {
+	if w, err = w.withDefaults(); err != nil {
+		return
+	}
+	var p workloadPreset
+	if p, err = w.preset(); err != nil {
+		return
+	}
+	if w.Upload+w.Download+w.RPC > 0 && w.StreamAddr == "" {
+		err = fmt.Errorf("Workload requires StreamAddr for stream flows")
+		return
+	}
+	if w.Probe > 0 && w.PacketAddr == "" {
+		err = fmt.Errorf("Workload requires PacketAddr for Probe flows")
+		return
+	}
+	for i := 1; i <= w.Upload; i++ {
+		f, d := w.classFlow("up", i, w.Upload, p.class)
+		run = append(run, w.streamRun(Streamers{
+			Upload: &Upload{w.transfer(f, Up, d)},
+		}))
+	}
+	for i := 1; i <= w.Download; i++ {
+		f, d := w.classFlow("down", i, w.Download, p.class)
+		run = append(run, w.streamRun(Streamers{
+			Download: &Download{w.transfer(f, Down, d)},
+		}))
+	}
+	for i := 1; i <= w.RPC; i++ {
+		run = append(run, w.streamRun(Streamers{
+			RPC: &RPC{
+				Flow:           w.flow("rpc", i),
+				Duration:       w.Duration,
+				Interval:       []metric.Duration{w.RPCInterval},
+				RequestLength:  []metric.Bytes{w.RPCRequestLength},
+				ResponseLength: []metric.Bytes{w.RPCResponseLength},
+				Sockopts:       Sockopts{CCA: w.CCA},
+			},
+		}))
+	}
+	for i := 1; i <= w.Probe; i++ {
+		f, d := w.classFlow("probe", i, w.Probe, p.probeClass)
+		run = append(run, Run{Runners: Runners{PacketClient: &PacketClient{
+			Addr:          w.PacketAddr,
+			Protocol:      "udp",
+			Flow:          f,
+			MaxPacketSize: workloadMaxPacketSize,
+			Sender: []PacketSenders{{Unresponsive: &Unresponsive{
+				Wait:     []metric.Duration{w.ProbeInterval},
+				Length:   []int{w.ProbeLength},
+				Duration: w.Duration,
+				Echo:     true,
+			}}},
+			Sockopts: Sockopts{DSCP: d},
+		}}})
+	}
+	if len(run) == 0 {
+		err = fmt.Errorf("Workload has no flows: %+v", w)
+	}
+	return
+}
+
+// flow returns the Flow for the given kind of flow and number.
+func (w Workload) flow(kind string, n int) Flow {
+	return Flow(fmt.Sprintf("%s%s%d", w.Prefix, kind, n))
+}
+
+// classFlow returns the Flow and DSCP value for flow number n of count flows
+// of the given kind, with DSCP classes assigned from class. If class is empty,
+// the Flow is named as in flow, with a DSCP value of zero. Otherwise, the Flow
+// is named with the kind and class name, and if there are more flows than
+// classes, a number for each cycle through the classes.
+func (w Workload) classFlow(kind string, n, count int, class []dscpClass) (
+	flow Flow, dscp byte) {
+	if len(class) == 0 {
+		flow = w.flow(kind, n)
+		return
+	}
+	c := class[(n-1)%len(class)]
+	s := fmt.Sprintf("%s%s-%s", w.Prefix, kind, c.name)
+	if count > len(class) {
+		s += fmt.Sprintf("%d", (n-1)/len(class)+1)
+	}
+	flow = Flow(s)
+	dscp = c.dscp
+	return
+}
+
+// transfer returns a Transfer for a bulk flow.
+func (w Workload) transfer(flow Flow, dir Direction, dscp byte) Transfer {
+	return Transfer{
+		Duration:         w.Duration,
+		IOSampleInterval: w.IOSampleInterval,
+		TCPInfoInterval:  w.TCPInfoInterval,
+		BufLen:           w.BufLen,
+		Stream: Stream{
+			Flow:      flow,
+			Direction: dir,
+			Sockopts:  Sockopts{DSCP: dscp, CCA: w.CCA},
+		},
+	}
+}
+
+// streamRun returns a Run with a StreamClient for the given Streamers.
+func (w Workload) streamRun(s Streamers) Run {
+	return Run{Runners: Runners{StreamClient: &StreamClient{
+		Addr:      w.StreamAddr,
+		Protocol:  "tcp",
+		Streamers: s,
+	}}}
+}
+
+// validate returns an error if the Workload is invalid.
+func (w Workload) validate() (err error) {
+	var rr []Run
+	if rr, err = w.runs(); err != nil {
+		return
+	}
+	err = Parallel(rr).validate()
+	return
+}
+
+// ExpandWorkloads is called recursively to replace each Workload in the given
+// Run hierarchy with a Parallel Run of its generated runners.
+// NOTE Keep in sync with Run fields.
+func ExpandWorkloads(run *Run) (err error) {
+	var rr []Run
+	switch {
+	case len(run.Serial) > 0:
+		rr = run.Serial
+	case len(run.Parallel) > 0:
+		rr = run.Parallel
+	case run.Schedule != nil:
+		rr = run.Schedule.Run
+	case run.Child != nil:
+		return ExpandWorkloads(&run.Child.Run)
+	case run.Workload != nil:
+		if rr, err = run.Workload.runs(); err != nil {
+			return
+		}
+		run.Parallel = rr
+		run.Workload = nil
+		return
+	}
+	for i := range rr {
+		if err = ExpandWorkloads(&rr[i]); err != nil {
+			return
+		}
+	}
+	return
+}
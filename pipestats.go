@@ -0,0 +1,215 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pipelineStatsInterval is the interval at which the occupancy of each stage's
+// input queue is sampled.
+const pipelineStatsInterval = 10 * time.Millisecond
+
+// PipelineStats contains throughput and queue metrics for each stage of a
+// report pipeline. It's used to find which reporter is the bottleneck when
+// reporting on large results. A stage whose input queue is often full, while
+// the queue of the stage after it is mostly empty, is a bottleneck.
+type PipelineStats struct {
+	// Test is the ID of the Test the pipeline ran for.
+	Test TestID
+
+	// Pipeline is the name of the pipeline ("during" or "after").
+	Pipeline string
+
+	// Elapsed is the total time the pipeline ran.
+	Elapsed time.Duration
+
+	// Stage contains the stats for each stage, in pipeline order.
+	Stage []StageStats
+}
+
+// String returns a table of the stats for each stage.
+func (p PipelineStats) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s pipeline stats for %s (elapsed %s):\n", p.Pipeline,
+		p.Test, p.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(&b, "  %-5s %-28s %10s %12s %10s %10s %9s\n", "stage",
+		"reporter", "items", "items/sec", "elapsed", "queue avg", "queue max")
+	for i, s := range p.Stage {
+		fmt.Fprintf(&b, "  %-5d %-28s %10d %12.0f %10s %9.1f%% %8.1f%%\n",
+			i+1, s.Reporter, s.Items, s.Rate(),
+			s.Elapsed.Round(time.Millisecond), s.QueueMean*100, s.QueueMax*100)
+	}
+	return b.String()
+}
+
+// StageStats contains the stats for one stage of a report pipeline.
+type StageStats struct {
+	// Reporter is the type of the stage's reporter.
+	Reporter string
+
+	// Items is the number of items the stage received.
+	Items int
+
+	// Elapsed is the time from the start of the pipeline until the stage's
+	// reporter returned.
+	Elapsed time.Duration
+
+	// QueueMean is the mean occupancy of the stage's input queue, from 0
+	// (empty) to 1 (full).
+	QueueMean float64
+
+	// QueueMax is the maximum sampled occupancy of the stage's input queue.
+	QueueMax float64
+}
+
+// Rate returns the stage's throughput in items per second.
+func (s StageStats) Rate() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Items) / s.Elapsed.Seconds()
+}
+
+// pipelineStatsKey is the Context key for the pipelineStatsSink.
+type pipelineStatsKey struct{}
+
+// pipelineStatsSink receives the PipelineStats for a pipeline.
+type pipelineStatsSink struct {
+	test     TestID
+	pipeline string
+	stats    func(PipelineStats)
+}
+
+// withPipelineStats returns a Context that enables collecting PipelineStats for
+// the named pipeline of the Test, which are passed to the stats func when the
+// pipeline completes. If stats is nil, ctx is returned.
+func withPipelineStats(ctx context.Context, test *Test, pipeline string,
+	stats func(PipelineStats)) context.Context {
+	if stats == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, pipelineStatsKey{},
+		&pipelineStatsSink{test.ID, pipeline, stats})
+}
+
+// pipelineStats returns the pipelineStatsSink from the Context, or nil if
+// stats collection isn't enabled.
+func pipelineStats(ctx context.Context) *pipelineStatsSink {
+	s, _ := ctx.Value(pipelineStatsKey{}).(*pipelineStatsSink)
+	return s
+}
+
+// stageMeter measures the stages of a pipeline. It counts the items received
+// by each stage, and samples the occupancy of each stage's input queue.
+type stageMeter struct {
+	sink  *pipelineStatsSink
+	start time.Time
+	queue []<-chan any
+	stats []StageStats
+	sum   []float64
+	n     int
+	stop  chan struct{}
+	done  chan struct{}
+	mtx   sync.Mutex
+}
+
+// newStageMeter returns a new stageMeter for the given reporters.
+func newStageMeter(sink *pipelineStatsSink, r report) *stageMeter {
+	m := &stageMeter{
+		sink:  sink,
+		start: time.Now(),
+		queue: make([]<-chan any, len(r)),
+		stats: make([]StageStats, len(r)),
+		sum:   make([]float64, len(r)),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	for i, t := range r {
+		if d, ok := t.(*deadline); ok {
+			t = d.reporter
+		}
+		m.stats[i].Reporter = strings.TrimPrefix(fmt.Sprintf("%T", t), "*")
+	}
+	return m
+}
+
+// meter confines a goroutine to move items from the stage's input queue to
+// the returned channel, counting them. The returned channel is unbuffered, so
+// the occupancy of queue reflects the stage's backlog.
+func (m *stageMeter) meter(stage int, queue <-chan any) <-chan any {
+	m.queue[stage] = queue
+	c := make(chan any)
+	go func() {
+		var n int
+		for a := range queue {
+			n++
+			c <- a
+		}
+		m.mtx.Lock()
+		m.stats[stage].Items = n
+		m.mtx.Unlock()
+		close(c)
+	}()
+	return c
+}
+
+// returned records that the stage's reporter returned.
+func (m *stageMeter) returned(stage int) {
+	m.mtx.Lock()
+	m.stats[stage].Elapsed = time.Since(m.start)
+	m.mtx.Unlock()
+}
+
+// sample confines a goroutine to sample the queue occupancy until finish is
+// called.
+func (m *stageMeter) sample() {
+	go func() {
+		defer close(m.done)
+		t := time.NewTicker(pipelineStatsInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+			case <-m.stop:
+				return
+			}
+			m.mtx.Lock()
+			for i, q := range m.queue {
+				if q == nil || cap(q) == 0 {
+					continue
+				}
+				o := float64(len(q)) / float64(cap(q))
+				m.sum[i] += o
+				m.stats[i].QueueMax = max(m.stats[i].QueueMax, o)
+			}
+			m.n++
+			m.mtx.Unlock()
+		}
+	}()
+}
+
+// finish stops sampling and sends the PipelineStats to the sink. It must be
+// called after all stages are done.
+func (m *stageMeter) finish() {
+	close(m.stop)
+	<-m.done
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if m.n > 0 {
+		for i := range m.stats {
+			m.stats[i].QueueMean = m.sum[i] / float64(m.n)
+		}
+	}
+	m.sink.stats(PipelineStats{
+		m.sink.test,
+		m.sink.pipeline,
+		time.Since(m.start),
+		m.stats,
+	})
+}
@@ -0,0 +1,45 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"context"
+	"errors"
+
+	"github.com/heistp/antler/node"
+)
+
+// preflight checks the prerequisites on each node for the runners in the given
+// Tests, before any Tests are run. All missing prerequisites are returned in a
+// node.PrereqError, along with any errors that occurred launching the nodes.
+func preflight(ctx context.Context, tests []*Test) (err error) {
+	if len(tests) == 0 {
+		return
+	}
+	rr := make([]*node.Run, len(tests))
+	for i, t := range tests {
+		rr[i] = &t.Run
+	}
+	d := make(chan any, dataChanLen(ctx))
+	go node.Do(ctx, node.Preflight(rr...), &exeSource{}, node.Options{
+		NodeOptions: node.NodeOptions{Tuning: contextTuning(ctx).Tuning},
+	}, d)
+	m := make(map[node.ID][]string)
+	var ee []error
+	for a := range d {
+		switch v := a.(type) {
+		case node.PrereqResult:
+			if len(v.Missing) > 0 {
+				m[v.NodeID] = append(m[v.NodeID], v.Missing...)
+			}
+		case error:
+			ee = append(ee, v)
+		}
+	}
+	if len(m) > 0 {
+		ee = append([]error{node.PrereqError{Missing: m}}, ee...)
+	}
+	err = errors.Join(ee...)
+	return
+}
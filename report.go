@@ -10,8 +10,10 @@ import (
 	"io"
 	"path/filepath"
 	"runtime/debug"
+	"sync"
 
 	"github.com/heistp/antler/node"
+	"github.com/heistp/antler/node/metric"
 )
 
 // A reporter can process data items from the node for a single Test. It is run
@@ -25,7 +27,10 @@ import (
 // Reporters may use the given Context to react to cancellation signals, and if
 // canceled, should return the error from context.Cause(ctx). Reporters may also
 // ignore the Context. In any case, they should expect that partial input data
-// is possible, in which case an error should be returned.
+// is possible, in which case an error should be returned. Reporters that do
+// significant work after their input is done should check the Context before
+// each step, and write files using a contextWriter, so that a Timeout can stop
+// them.
 //
 // Reporters may read or write results using the given 'rwer'.
 //
@@ -56,10 +61,24 @@ func (r Report) validate() (err error) {
 	return
 }
 
-// report returns an equivalent report instance.
+// setTheme sets the Theme for any reporters that implement themer.
+func (r Report) setTheme(theme Theme) {
+	for i := range r {
+		if t, ok := r[i].reporter().(themer); ok {
+			t.setTheme(theme)
+		}
+	}
+}
+
+// report returns an equivalent report instance. Reporters with a Timeout are
+// wrapped in a deadline.
 func (r Report) report() (t report) {
-	for _, p := range r {
-		t = append(t, p.reporter())
+	for i, p := range r {
+		rr := p.reporter()
+		if p.Timeout > 0 {
+			rr = &deadline{rr, i + 1, p.Timeout}
+		}
+		t = append(t, rr)
 	}
 	return
 }
@@ -69,10 +88,27 @@ type reporters struct {
 	Analyze          *Analyze
 	EmitLog          *EmitLog
 	EmitSysInfo      *EmitSysInfo
+	EmitMetrics      *EmitMetrics
+	EmitStats        *EmitStats
+	EmitInflux       *EmitInflux
+	EmitNATS         *EmitNATS
 	ChartsFCT        *ChartsFCT
+	ChartsCDF        *ChartsCDF
 	ChartsTimeSeries *ChartsTimeSeries
+	ChartsRRUL       *ChartsRRUL
+	ChartsStation    *ChartsStation
+	ChartsTin        *ChartsTin
+	ChartsTCPInfo    *ChartsTCPInfo
+	Snapshot         *Snapshot
+	FilterTag        *FilterTag
 	SaveFiles        *SaveFiles
 	Encode           *Encode
+	Custom           *CustomReport
+
+	// Timeout, if not zero, is the maximum time the reporter may take to
+	// complete after its input is done, before a ReporterTimeoutError is
+	// returned.
+	Timeout metric.Duration
 }
 
 // reporter returns the reporter.
@@ -88,6 +124,25 @@ func (r *reporters) reporter() (rr reporter) {
 func (r *reporters) validate() (err error) {
 	if _, n := r.value(); n != 1 {
 		err = UnionError{r, n}
+		return
+	}
+	if r.Snapshot != nil {
+		err = r.Snapshot.validate()
+	}
+	if r.FilterTag != nil {
+		err = r.FilterTag.validate()
+	}
+	if r.EmitInflux != nil {
+		err = r.EmitInflux.validate()
+	}
+	if r.EmitNATS != nil {
+		err = r.EmitNATS.validate()
+	}
+	if r.ChartsCDF != nil {
+		err = r.ChartsCDF.validate()
+	}
+	if r.Custom != nil {
+		err = r.Custom.validate()
 	}
 	return
 }
@@ -106,14 +161,58 @@ func (r *reporters) value() (rr reporter, n int) {
 		rr = r.EmitSysInfo
 		n++
 	}
+	if r.EmitMetrics != nil {
+		rr = r.EmitMetrics
+		n++
+	}
+	if r.EmitStats != nil {
+		rr = r.EmitStats
+		n++
+	}
+	if r.EmitInflux != nil {
+		rr = r.EmitInflux
+		n++
+	}
+	if r.EmitNATS != nil {
+		rr = r.EmitNATS
+		n++
+	}
 	if r.ChartsFCT != nil {
 		rr = r.ChartsFCT
 		n++
 	}
+	if r.ChartsCDF != nil {
+		rr = r.ChartsCDF
+		n++
+	}
 	if r.ChartsTimeSeries != nil {
 		rr = r.ChartsTimeSeries
 		n++
 	}
+	if r.ChartsRRUL != nil {
+		rr = r.ChartsRRUL
+		n++
+	}
+	if r.ChartsStation != nil {
+		rr = r.ChartsStation
+		n++
+	}
+	if r.ChartsTin != nil {
+		rr = r.ChartsTin
+		n++
+	}
+	if r.ChartsTCPInfo != nil {
+		rr = r.ChartsTCPInfo
+		n++
+	}
+	if r.Snapshot != nil {
+		rr = r.Snapshot
+		n++
+	}
+	if r.FilterTag != nil {
+		rr = r.FilterTag
+		n++
+	}
 	if r.SaveFiles != nil {
 		rr = r.SaveFiles
 		n++
@@ -122,6 +221,10 @@ func (r *reporters) value() (rr reporter, n int) {
 		rr = r.Encode
 		n++
 	}
+	if r.Custom != nil {
+		rr = r.Custom
+		n++
+	}
 	return
 }
 
@@ -145,11 +248,22 @@ func (r report) add(other report) report {
 //
 // The returned error channel receives any errors that occur, and is closed when
 // the pipeline is done, meaning all of its stages are done.
+//
+// If the Context was returned by withPipelineStats, the stages are measured,
+// and the PipelineStats are sent before the error channel is closed. Nested
+// pipelines started by the stages are not measured.
 func (r report) pipeline(ctx context.Context, rw rwer, in <-chan any,
 	out chan<- any) <-chan error {
 	if len(r) == 0 {
 		r = append(r, nopReport{})
 	}
+	var m *stageMeter
+	if s := pipelineStats(ctx); s != nil {
+		m = newStageMeter(s, r)
+		ctx = context.WithValue(ctx, pipelineStatsKey{},
+			(*pipelineStatsSink)(nil))
+	}
+	var wg sync.WaitGroup
 	var ecc errChans
 	cc := make([]chan any, len(r)-1)
 	// set input channel, or make a closed input channel if nil
@@ -161,12 +275,12 @@ func (r report) pipeline(ctx context.Context, rw rwer, in <-chan any,
 	}
 	// make intermediary channels
 	for i := 0; i < len(cc); i++ {
-		cc[i] = make(chan any, dataChanBufLen)
+		cc[i] = make(chan any, dataChanLen(ctx))
 	}
 	// set output channel, or make a drained output channel if nil
 	var pout chan<- any
 	if pout = out; pout == nil {
-		o := make(chan any, dataChanBufLen)
+		o := make(chan any, dataChanLen(ctx))
 		pout = o
 		ec := ecc.make()
 		go func(ec chan error) {
@@ -185,8 +299,14 @@ func (r report) pipeline(ctx context.Context, rw rwer, in <-chan any,
 		if x < len(r)-1 {
 			o = cc[x]
 		}
+		if m != nil {
+			i = m.meter(x, i)
+		}
 		ec := ecc.make()
-		go func(t reporter, in <-chan any, out chan<- any, ec chan error) {
+		wg.Add(1)
+		go func(x int, t reporter, in <-chan any, out chan<- any,
+			ec chan error) {
+			defer wg.Done()
 			defer func() {
 				for a := range in {
 					out <- a
@@ -198,10 +318,23 @@ func (r report) pipeline(ctx context.Context, rw rwer, in <-chan any,
 				}
 				close(ec)
 			}()
-			if e := t.report(ctx, rw, in, out); e != nil {
+			e := t.report(ctx, rw, in, out)
+			if m != nil {
+				m.returned(x)
+			}
+			if e != nil {
 				ec <- e
 			}
-		}(t, i, o, ec)
+		}(x, t, i, o, ec)
+	}
+	if m != nil {
+		m.sample()
+		ec := ecc.make()
+		go func() {
+			wg.Wait()
+			m.finish()
+			close(ec)
+		}()
 	}
 	return ecc.merge()
 }
@@ -214,9 +347,9 @@ func (r report) tee(ctx context.Context, rw rwer, in <-chan any,
 	to ...report) <-chan error {
 	var ic []chan any
 	for range to {
-		ic = append(ic, make(chan any, dataChanBufLen))
+		ic = append(ic, make(chan any, dataChanLen(ctx)))
 	}
-	oc := make(chan any, dataChanBufLen)
+	oc := make(chan any, dataChanLen(ctx))
 	go func() {
 		for a := range oc {
 			for _, o := range ic {
@@ -246,7 +379,10 @@ func (nopReport) report(ctx context.Context, rw rwer, in <-chan any,
 }
 
 // SaveFiles is a reporter that saves FileData. If Consume is true, FileData
-// items are not forwarded to the out channel.
+// items are not forwarded to the out channel. The checksum and offset of each
+// chunk are verified, if set, so that lost or corrupted chunks are reported as
+// an error. Offsets are tracked separately for each node, as more than one node
+// may write to the same file.
 type SaveFiles struct {
 	Consume bool
 }
@@ -255,6 +391,7 @@ type SaveFiles struct {
 func (s *SaveFiles) report(ctx context.Context, rw rwer, in <-chan any,
 	out chan<- any) (err error) {
 	m := make(map[string]io.WriteCloser)
+	o := make(map[fileKey]int64)
 	defer func() {
 		for n, w := range m {
 			if e := w.Close(); e != nil && err == nil {
@@ -270,6 +407,10 @@ func (s *SaveFiles) report(ctx context.Context, rw rwer, in <-chan any,
 			out <- d
 			continue
 		}
+		k := fileKey{fd.NodeID, fd.Name}
+		if err = fd.Verify(o[k]); err != nil {
+			return
+		}
 		var w io.WriteCloser
 		if w, ok = m[fd.Name]; !ok {
 			w = rw.Writer(fd.Name)
@@ -279,6 +420,7 @@ func (s *SaveFiles) report(ctx context.Context, rw rwer, in <-chan any,
 		if _, err = w.Write(fd.Data); err != nil {
 			return
 		}
+		o[k] += int64(len(fd.Data))
 		if !s.Consume {
 			out <- d
 		}
@@ -286,6 +428,12 @@ func (s *SaveFiles) report(ctx context.Context, rw rwer, in <-chan any,
 	return
 }
 
+// fileKey identifies the data a node sent for a file, for SaveFiles.
+type fileKey struct {
+	node node.ID
+	name string
+}
+
 // FileRef is sent as a data item by SaveFiles to record the presence of a file
 // with the specified Name, even after its FileData items may have been
 // consumed.
@@ -366,8 +514,10 @@ func (c *Encode) encode(name string, rw rwer) (err error) {
 }
 
 // readData is an internal reporter that reads data items from the ReadCloser
-// that reads a gob file, and sends them to the out channel. readData expects to
-// be the first stage in a pipeline, so any input is first discarded.
+// that reads a data file in any DataFormat, and sends them to the out channel.
+// If the file is the index of a segmented DataFile, the items in each segment
+// are sent instead of its SegmentRef. readData expects to be the first stage in
+// a pipeline, so any input is first discarded.
 //
 // If a decoding error occurs, the error is returned immediately.
 //
@@ -383,28 +533,43 @@ func (r readData) report(ctx context.Context, rw rwer, in <-chan any,
 	defer r.Close()
 	for range in {
 	}
-	c := gob.NewDecoder(r)
-	for {
-		var a any
-		if err = c.Decode(&a); err != nil {
-			if err == io.EOF {
-				err = nil
-			}
-			return
+	err = decodeData(rw, r, func(a any) bool {
+		if _, ok := a.(SegmentRef); ok {
+			return true
 		}
 		out <- a
 		select {
 		case <-ctx.Done():
 			err = context.Cause(ctx)
-			return
+			return false
 		default:
 		}
+		return true
+	})
+	return
+}
+
+// readDataFile is an internal reporter that opens the named data file when the
+// pipeline starts, then reads it like readData. This avoids holding the file
+// open before its pipeline runs.
+type readDataFile string
+
+// report implements reporter
+func (f readDataFile) report(ctx context.Context, rw rwer, in <-chan any,
+	out chan<- any) (err error) {
+	var r *ResultReader
+	if r, err = rw.Reader(string(f)); err != nil {
+		for range in {
+		}
+		return
 	}
+	err = readData{r}.report(ctx, rw, in, out)
+	return
 }
 
-// writeData is a WriteCloser and internal reporter that writes data using gob.
-// writeData expects to be the final stage in a pipeline, so all data is
-// consumed.
+// writeData is a WriteCloser and internal reporter that writes data in the
+// given DataFormat. writeData expects to be the final stage in a pipeline, so
+// all data is consumed.
 //
 // If an encoding error occurs, the error is returned immediately.
 //
@@ -412,6 +577,7 @@ func (r readData) report(ctx context.Context, rw rwer, in <-chan any,
 // and saving all the data.
 type writeData struct {
 	io.WriteCloser
+	format DataFormat
 }
 
 // report implements reporter
@@ -422,9 +588,9 @@ func (w writeData) report(ctx context.Context, rw rwer, in <-chan any,
 			err = e
 		}
 	}()
-	c := gob.NewEncoder(w)
+	c := newDataEncoder(w.format, w)
 	for d := range in {
-		if e := c.Encode(&d); e != nil {
+		if e := c.Encode(d); e != nil {
 			err = e
 			return
 		}
@@ -460,24 +626,71 @@ func (r rangeData) report(ctx context.Context, rw rwer, in <-chan any,
 	return
 }
 
-// appendData is an internal reporter that buffers data in its slice. appendData
-// expects to be the final stage in a pipeline, so all data is consumed.
+// appendData is an internal reporter that buffers data in memory. If the Test's
+// MemoryLimit is exceeded, the buffered data is spilled to a temporary file,
+// and the remaining data is written there instead. appendData expects to be the
+// final stage in a pipeline, so all data is consumed.
 //
 // If the data includes any errors, the first error is returned after reading
 // and buffering all the data.
-type appendData []any
+type appendData struct {
+	data  []any
+	spill *spillFile
+}
 
 // report implements reporter
 func (a *appendData) report(ctx context.Context, rw rwer, in <-chan any,
-	out chan<- any) error {
+	out chan<- any) (err error) {
 	var f error
+	c := newMemoryCheck(ctx, a)
 	for d := range in {
-		*a = append(*a, d)
 		if e, ok := d.(error); ok && f == nil {
 			f = e
 		}
+		if a.spill != nil {
+			if err = a.spill.encode(d); err != nil {
+				return
+			}
+			continue
+		}
+		a.data = append(a.data, d)
+		if c.add() != nil {
+			if err = a.spillData(); err != nil {
+				return
+			}
+		}
+	}
+	err = f
+	return
+}
+
+// spillData creates the spill file, and moves the buffered data to it.
+func (a *appendData) spillData() (err error) {
+	if a.spill, err = newSpillFile(); err != nil {
+		return
+	}
+	for _, d := range a.data {
+		if err = a.spill.encode(d); err != nil {
+			return
+		}
 	}
-	return f
+	a.data = nil
+	return
+}
+
+// source returns a reporter that sends the buffered data, from memory or the
+// spill file.
+func (a *appendData) source() (src reporter, err error) {
+	if a.spill == nil {
+		src = rangeData(a.data)
+		return
+	}
+	var r io.ReadCloser
+	if r, err = a.spill.reader(); err != nil {
+		return
+	}
+	src = readData{r}
+	return
 }
 
 // A multiReporter can process data items for multiple Tests. It receives its
@@ -522,7 +735,9 @@ func (m MultiReport) wants(test *Test) (bool, error) {
 
 // multiReporters is a union of the available multiReporters.
 type multiReporters struct {
-	Index *Index
+	Index               *Index
+	JUnit               *JUnit
+	AggregateIterations *AggregateIterations
 }
 
 // multiReporter returns the multiReporter.
@@ -538,6 +753,13 @@ func (m *multiReporters) multiReporter() (mm multiReporter) {
 func (m *multiReporters) validate() (err error) {
 	if _, n := m.value(); n != 1 {
 		err = UnionError{m, n}
+		return
+	}
+	if m.Index != nil {
+		err = m.Index.validate()
+	}
+	if m.AggregateIterations != nil {
+		err = m.AggregateIterations.validate()
 	}
 	return
 }
@@ -548,6 +770,14 @@ func (m *multiReporters) value() (mm multiReporter, n int) {
 		mm = m.Index
 		n++
 	}
+	if m.JUnit != nil {
+		mm = m.JUnit
+		n++
+	}
+	if m.AggregateIterations != nil {
+		mm = m.AggregateIterations
+		n++
+	}
 	return
 }
 
@@ -614,11 +844,11 @@ func (m *multiRunner) tee(ctx context.Context, work resultRW, test *Test) (
 		return
 	}
 	// create out channel, and data channels for multiReporters
-	oc := make(chan any, dataChanBufLen)
+	oc := make(chan any, dataChanLen(ctx))
 	out = oc
 	var dc []chan any
 	for range rr {
-		dc = append(dc, make(chan any, dataChanBufLen))
+		dc = append(dc, make(chan any, dataChanLen(ctx)))
 	}
 	// start tee goroutine to read from out and write to data channels
 	go func() {
@@ -652,6 +882,22 @@ func (m *multiRunner) tee(ctx context.Context, work resultRW, test *Test) (
 	return
 }
 
+// fail sends err to the multiReporters for a Test that failed to run, so they
+// may record the failure. Any errors from the multiReporters are discarded, as
+// the Test's error takes precedence.
+func (m *multiRunner) fail(ctx context.Context, work resultRW, test *Test,
+	err error) {
+	o, ec := m.tee(ctx, work, test)
+	if o != nil {
+		o <- err
+		close(o)
+	}
+	if ec != nil {
+		for range ec {
+		}
+	}
+}
+
 // stop calls any multiStoppers among the multiReporters, and returns the first
 // error, if any. stop is called on all multiReporters regardless of errors.
 func (m *multiRunner) stop(work resultRW) (err error) {
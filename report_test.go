@@ -0,0 +1,86 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"context"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/heistp/antler/node"
+)
+
+// testResultRW returns a resultRW that writes to a temporary directory.
+func testResultRW(t *testing.T) resultRW {
+	t.Helper()
+	d := t.TempDir()
+	r := Results{
+		RootDir:         d,
+		WorkDir:         filepath.Join(d, "in-progress"),
+		ResultDirFormat: "2006-01-02-150405Z",
+		DirMode:         0o755,
+		FileMode:        0o644,
+		Umask:           -1,
+	}
+	rw, err := r.open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rw
+}
+
+// fileChunk returns a checksummed FileData chunk.
+func fileChunk(id node.ID, name, data string, offset int64) node.FileData {
+	b := []byte(data)
+	c := crc32.Checksum(b, crc32.MakeTable(crc32.Castagnoli))
+	return node.FileData{Name: name, Data: b, Offset: offset, Checksum: c,
+		NodeID: id}
+}
+
+// saveFiles runs SaveFiles with the given FileData, and returns its error.
+func saveFiles(rw resultRW, fd ...node.FileData) error {
+	in := make(chan any, len(fd))
+	out := make(chan any, len(fd)*2)
+	for _, f := range fd {
+		in <- f
+	}
+	close(in)
+	return (&SaveFiles{Consume: true}).report(context.Background(), rw, in,
+		out)
+}
+
+// TestSaveFilesNodes tests that SaveFiles accepts data for the same file from
+// more than one node, with the offsets for each node starting from zero.
+func TestSaveFilesNodes(t *testing.T) {
+	rw := testResultRW(t)
+	if err := saveFiles(rw,
+		fileChunk("a", "out.txt", "node a\n", 0),
+		fileChunk("b", "out.txt", "node b\n", 0),
+		fileChunk("a", "out.txt", "more a\n", 7),
+	); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(rw.WorkDir, "out.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, x := string(b), "node a\nnode b\nmore a\n"; s != x {
+		t.Fatalf("out.txt is %q, expected %q", s, x)
+	}
+}
+
+// TestSaveFilesLost tests that SaveFiles returns an error if a node's chunk is
+// lost.
+func TestSaveFilesLost(t *testing.T) {
+	rw := testResultRW(t)
+	if err := saveFiles(rw,
+		fileChunk("a", "out.txt", "node a\n", 0),
+		fileChunk("b", "out.txt", "node b\n", 0),
+		fileChunk("b", "out.txt", "more b\n", 14),
+	); err == nil {
+		t.Fatal("expected an error for a lost chunk")
+	}
+}
@@ -0,0 +1,183 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"iter"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/heistp/antler/node"
+)
+
+// ResultData reads the raw result data items from a Test's DataFile, so that
+// post-processing tools may be written without knowledge of the DataFormat,
+// segmenting or encoding. Each iteration reads the DataFile from the start.
+//
+// Items may be read by type using the Items function, or the methods for the
+// common types, e.g.:
+//
+//	d, err := antler.OpenResult("results/latest/data.gob")
+//	for s, err := range d.StreamIO() {
+//		...
+//	}
+type ResultData struct {
+	// Path is the path to the DataFile.
+	Path string
+
+	codec Codecs
+}
+
+// OpenResult returns a ResultData for the DataFile at path, which is given
+// without any Codec extension, as encoded versions of the file (e.g. .zst)
+// are found and decoded transparently, using the default Codecs. An error is
+// returned if the DataFile can't be opened.
+func OpenResult(path string) (d *ResultData, err error) {
+	var c Codecs
+	if c, err = defaultCodecs(); err != nil {
+		return
+	}
+	d = &ResultData{path, c}
+	var r *ResultReader
+	if r, err = newResultReader(path, path, c); err != nil {
+		d = nil
+		return
+	}
+	err = r.Close()
+	return
+}
+
+// All returns an iterator over all the data items, in the order they were
+// received. The items in segments are yielded in place of their SegmentRefs.
+// Any error is yielded last, with a nil item.
+func (d *ResultData) All() iter.Seq2[any, error] {
+	return func(yield func(any, error) bool) {
+		r, err := newResultReader(d.Path, d.Path, d.codec)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		var stop bool
+		rw := &resultDataRW{d.Path, d.codec, ""}
+		err = decodeData(rw, r, func(a any) bool {
+			if s, ok := a.(SegmentRef); ok {
+				rw.setPrefix(s)
+				return true
+			}
+			stop = !yield(a, nil)
+			return !stop
+		})
+		if e := r.Close(); e != nil && err == nil {
+			err = e
+		}
+		if err != nil && !stop {
+			yield(nil, err)
+		}
+	}
+}
+
+// Items returns an iterator over the data items of type T, e.g.
+// Items[node.QdiscInfo](d). Any error is yielded last, with the zero value of
+// T.
+func Items[T any](d *ResultData) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var z T
+		for a, err := range d.All() {
+			if err != nil {
+				yield(z, err)
+				return
+			}
+			if t, ok := a.(T); ok {
+				if !yield(t, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// StreamIO returns an iterator over the StreamIO data items.
+func (d *ResultData) StreamIO() iter.Seq2[node.StreamIO, error] {
+	return Items[node.StreamIO](d)
+}
+
+// PacketIO returns an iterator over the PacketIO data items.
+func (d *ResultData) PacketIO() iter.Seq2[node.PacketIO, error] {
+	return Items[node.PacketIO](d)
+}
+
+// TCPInfo returns an iterator over the TCPInfo data items.
+func (d *ResultData) TCPInfo() iter.Seq2[node.TCPInfo, error] {
+	return Items[node.TCPInfo](d)
+}
+
+// LogEntry returns an iterator over the LogEntry data items.
+func (d *ResultData) LogEntry() iter.Seq2[node.LogEntry, error] {
+	return Items[node.LogEntry](d)
+}
+
+// resultDataRW is a rwer that reads the segments of a DataFile. The names of
+// segments are relative to the Test's result prefix, which is found by
+// removing the DataFile name that the first SegmentRef implies from the path.
+type resultDataRW struct {
+	path   string
+	codec  Codecs
+	prefix string
+}
+
+// setPrefix sets the prefix from a SegmentRef, if it's not already set.
+func (r *resultDataRW) setPrefix(s SegmentRef) {
+	if r.prefix != "" {
+		return
+	}
+	r.prefix = strings.TrimSuffix(r.path, segmentDataFile(s.Name))
+}
+
+// Reader implements rwer
+func (r *resultDataRW) Reader(name string) (*ResultReader, error) {
+	p := r.prefix + name
+	return newResultReader(name, p, r.codec)
+}
+
+// Writer implements rwer
+func (r *resultDataRW) Writer(name string) *ResultWriter {
+	panic("resultDataRW is read-only")
+}
+
+// Remove implements rwer
+func (r *resultDataRW) Remove(name string) error {
+	panic("resultDataRW is read-only")
+}
+
+// segmentDataFile returns the name of the DataFile for the named segment. It
+// reverses DataSegment.name, removing the segment index after the first dot
+// in the base name, e.g. data.0001.gob becomes data.gob.
+func segmentDataFile(segment string) string {
+	d, b := filepath.Split(segment)
+	i := strings.Index(b, ".")
+	if i < 0 {
+		return segment
+	}
+	x := b[i+1:]
+	if j := strings.Index(x, "."); j >= 0 {
+		x = x[j:]
+	} else {
+		x = ""
+	}
+	return d + b[:i] + x
+}
+
+// defaultCodecs returns the default Codecs defined in config.cue.
+var defaultCodecs = sync.OnceValues(func() (c Codecs, err error) {
+	s := cuecontext.New().CompileString(configCUE,
+		cue.Filename("config.cue"))
+	if err = s.Err(); err != nil {
+		return
+	}
+	err = s.LookupPath(cue.ParsePath("Results.Codec")).Decode(&c)
+	return
+})
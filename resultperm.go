@@ -0,0 +1,150 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// Mode is a Unix file mode as used by chmod(1), including the setuid, setgid
+// and sticky bits, e.g. 0o2775.
+type Mode uint32
+
+// FileMode returns the Mode as an fs.FileMode.
+func (m Mode) FileMode() (f fs.FileMode) {
+	f = fs.FileMode(m) & fs.ModePerm
+	if m&0o4000 != 0 {
+		f |= fs.ModeSetuid
+	}
+	if m&0o2000 != 0 {
+		f |= fs.ModeSetgid
+	}
+	if m&0o1000 != 0 {
+		f |= fs.ModeSticky
+	}
+	return
+}
+
+// filePerm applies the modes and group ownership configured in Results to the
+// files and directories created for results.
+type filePerm struct {
+	dir   fs.FileMode
+	file  fs.FileMode
+	gid   int // -1 to leave the group unchanged
+	umask fs.FileMode
+}
+
+// perm returns the filePerm for the Results. If Umask is not negative, the
+// process umask is set to Umask, so this must be called before any result
+// files are created.
+func (r Results) perm() (p filePerm, err error) {
+	p = filePerm{r.DirMode.FileMode(), r.FileMode.FileMode(), -1, 0}
+	if r.Umask >= 0 {
+		syscall.Umask(r.Umask)
+		p.umask = fs.FileMode(r.Umask) & fs.ModePerm
+	} else {
+		u := syscall.Umask(0)
+		syscall.Umask(u)
+		p.umask = fs.FileMode(u) & fs.ModePerm
+	}
+	if r.Group == "" {
+		return
+	}
+	var g *user.Group
+	if g, err = user.LookupGroup(r.Group); err != nil {
+		if _, e := strconv.Atoi(r.Group); e != nil {
+			err = fmt.Errorf("unable to set result group: %w", err)
+			return
+		}
+		if g, err = user.LookupGroupId(r.Group); err != nil {
+			err = fmt.Errorf("unable to set result group: %w", err)
+			return
+		}
+	}
+	if p.gid, err = strconv.Atoi(g.Gid); err != nil {
+		err = fmt.Errorf("invalid gid for group '%s': %w", r.Group, err)
+	}
+	return
+}
+
+// mkdir creates the named directory with the configured mode and group.
+func (p filePerm) mkdir(name string) (err error) {
+	if err = os.Mkdir(name, p.dir.Perm()); err != nil {
+		return
+	}
+	err = p.apply(name, p.dir)
+	return
+}
+
+// mkdirAll creates the named directory and any missing parents, with the
+// configured mode and group. Existing directories are left unchanged.
+func (p filePerm) mkdirAll(name string) (err error) {
+	var i fs.FileInfo
+	if i, err = os.Stat(name); err == nil {
+		if !i.IsDir() {
+			err = &os.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
+		}
+		return
+	}
+	if d := filepath.Dir(name); d != name {
+		if err = p.mkdirAll(d); err != nil {
+			return
+		}
+	}
+	if err = p.mkdir(name); errors.Is(err, fs.ErrExist) {
+		err = nil
+	}
+	return
+}
+
+// create creates or truncates the named file for writing, with the configured
+// mode and group.
+func (p filePerm) create(name string) (f *os.File, err error) {
+	if f, err = os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC,
+		p.file.Perm()); err != nil {
+		return
+	}
+	if err = p.apply(name, p.file); err != nil {
+		f.Close()
+		f = nil
+	}
+	return
+}
+
+// apply sets the group of the named file, if configured, then sets any of the
+// setuid, setgid or sticky bits in mode, which aren't set on creation. The
+// group is set first, as changing it may clear the setuid and setgid bits.
+func (p filePerm) apply(name string, mode fs.FileMode) (err error) {
+	if p.gid >= 0 {
+		if err = os.Lchown(name, -1, p.gid); err != nil {
+			return
+		}
+	}
+	if mode&(fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky) != 0 {
+		err = os.Chmod(name, mode&^p.umask)
+	}
+	return
+}
+
+// chownAll sets the group of the named file or directory, and everything
+// under it, if a group is configured.
+func (p filePerm) chownAll(name string) error {
+	if p.gid < 0 {
+		return nil
+	}
+	return filepath.WalkDir(name, func(path string, d fs.DirEntry,
+		err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, -1, p.gid)
+	})
+}
@@ -5,6 +5,7 @@ package antler
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -30,20 +31,60 @@ type Results struct {
 	ResultDirUTC    bool
 	ResultDirFormat string
 	LatestSymlink   string
+	DirMode         Mode
+	FileMode        Mode
+	Group           string
+	Umask           int
+	KeySymlinks     []string
+	Catalog         string
+	Collect         *Collect
+	Live            *Live
 	Codec           Codecs
 }
 
+// validate returns an error if the Results are invalid.
+func (r Results) validate() (err error) {
+	if r.Collect != nil {
+		if err = r.Collect.validate(); err != nil {
+			return
+		}
+	}
+	if r.Live != nil {
+		err = r.Live.validate()
+	}
+	return
+}
+
+// in returns a copy of the Results with its relative paths made relative to
+// dir. If dir is empty, the Results are returned unchanged.
+func (r Results) in(dir string) Results {
+	if dir == "" {
+		return r
+	}
+	for _, p := range []*string{&r.RootDir, &r.WorkDir, &r.LatestSymlink,
+		&r.Catalog} {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(dir, *p)
+		}
+	}
+	return r
+}
+
 // open returns a new resultRW for reading and writing results to WorkDir.
 // The existence of WorkDir is used as a lock to prevent multiple antler
 // instances from writing results at the same time.
 func (r Results) open() (rw resultRW, err error) {
+	var p filePerm
+	if p, err = r.perm(); err != nil {
+		return
+	}
 	d := filepath.Dir(r.WorkDir)
 	if d != "." && d != ".." && d != string(filepath.Separator) {
-		if err = os.MkdirAll(d, 0755); err != nil {
+		if err = p.mkdirAll(d); err != nil {
 			return
 		}
 	}
-	if err = os.Mkdir(r.WorkDir, 0755); err != nil {
+	if err = p.mkdir(r.WorkDir); err != nil {
 		if errors.Is(err, fs.ErrExist) {
 			err = fmt.Errorf("'%s' exists- move it away if not in use (%w)",
 				r.WorkDir, err)
@@ -54,7 +95,7 @@ func (r Results) open() (rw resultRW, err error) {
 	if i, err = r.info(); err != nil {
 		return
 	}
-	rw = resultRW{r, "", i, newResultStat()}
+	rw = resultRW{r, "", i, p, newResultStat()}
 	return
 }
 
@@ -216,6 +257,7 @@ type resultRW struct {
 	Results
 	prefix string
 	info   []ResultInfo
+	perm   filePerm
 	*resultStat
 }
 
@@ -310,7 +352,8 @@ func (s *resultStat) Changed() (changed bool) {
 // Child returns a child resultRW by appending the given prefix to the prefix
 // of this resultRW.
 func (r resultRW) Child(prefix string) resultRW {
-	return resultRW{r.Results, r.prefix + prefix, r.info, r.resultStat}
+	return resultRW{r.Results, r.prefix + prefix, r.info, r.perm,
+		r.resultStat}
 }
 
 // Reader implements rwer
@@ -324,14 +367,15 @@ func (r resultRW) Writer(name string) (w *ResultWriter) {
 	w = &ResultWriter{
 		Name: name,
 		Path: r.path(name),
+		perm: r.perm,
 	}
 	if name == "-" {
-		w.WriteCloser = stdoutWriter{}
+		w.WriteCloser = &stdoutWriter{}
 		w.initted = true
 		return
 	}
 	w.WriteCloser = newAtomicWriter(r.prefix+name, r.WorkDir, r.info,
-		r.resultStat)
+		r.perm, r.resultStat)
 	var ok bool
 	if w.Codec, ok = r.Codec.forName(name); !ok {
 		return
@@ -369,10 +413,15 @@ func (r resultRW) Link(name string) (err error) {
 				}
 				continue
 			}
-			if err = os.MkdirAll(filepath.Dir(w+x), 0755); err != nil {
+			if err = r.perm.mkdirAll(filepath.Dir(w + x)); err != nil {
 				return
 			}
-			if err = os.Link(p+x, w+x); err != nil {
+			if err = os.Link(p+x, w+x); crossDevice(err) {
+				if _, err = copyFile(p+x, w+x); err == nil {
+					err = r.perm.chownAll(w + x)
+				}
+			}
+			if err != nil {
 				return
 			}
 			r.addLinked(n + x)
@@ -385,6 +434,44 @@ func (r resultRW) Link(name string) (err error) {
 	return
 }
 
+// HasPrior returns true if the named file exists, in any encoding, in a prior
+// result, i.e. if Link would succeed.
+func (r resultRW) HasPrior(name string) bool {
+	xx := []string{""}
+	for _, c := range r.Codec.byID() {
+		xx = append(xx, c.Extension...)
+	}
+	n := r.prefix + name
+	for _, i := range r.info {
+		p := filepath.Join(i.Path, n)
+		for _, x := range xx {
+			if _, e := os.Stat(p + x); e == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// openPrior opens the named file, unencoded, from the most recent prior result
+// containing it. If the file is not found, errors.Is(err, fs.ErrNotExist)
+// returns true.
+func (r resultRW) openPrior(name string) (f *os.File, err error) {
+	n := r.prefix + name
+	for _, i := range r.info {
+		if f, err = os.Open(filepath.Join(i.Path, n)); err == nil ||
+			!errors.Is(err, fs.ErrNotExist) {
+			return
+		}
+	}
+	err = &os.PathError{
+		Op:   "openPrior",
+		Path: n,
+		Err:  fs.ErrNotExist,
+	}
+	return
+}
+
 // LinkError is returned by resultRW.Link when the named file could not be found
 // in any prior result.
 type LinkError struct {
@@ -406,7 +493,13 @@ func (l LinkError) Is(target error) bool {
 // and/or RootDir are empty because no results changed, they are removed,
 // and no error is returned as long as this succeeds. If no unique files were
 // written, Abort is called instead.
-func (r resultRW) Close() (resultDir string, err error) {
+//
+// If WorkDir and RootDir are on different filesystems, WorkDir is copied to the
+// result directory and verified instead (see moveDir), and progress, if not
+// nil, is called as the copy proceeds. The copy is then given the configured
+// Group, if any.
+func (r resultRW) Close(progress func(CopyProgress)) (resultDir string,
+	err error) {
 	if !r.Changed() {
 		err = r.Abort()
 		return
@@ -434,7 +527,16 @@ func (r resultRW) Close() (resultDir string, err error) {
 	}
 	n := t.Format(r.ResultDirFormat)
 	resultDir = filepath.Join(r.RootDir, n)
-	if err = os.Rename(r.WorkDir, resultDir); errors.Is(err, fs.ErrNotExist) {
+	if err = r.perm.mkdirAll(r.RootDir); err != nil {
+		return
+	}
+	err = os.Rename(r.WorkDir, resultDir)
+	if crossDevice(err) {
+		if err = moveDir(r.WorkDir, resultDir, progress); err == nil {
+			err = r.perm.chownAll(resultDir)
+		}
+	}
+	if errors.Is(err, fs.ErrNotExist) {
 		err = nil
 		return
 	}
@@ -443,11 +545,24 @@ func (r resultRW) Close() (resultDir string, err error) {
 		if err = os.Symlink(n, l); err != nil {
 			return
 		}
+		if err = r.perm.apply(l, 0); err != nil {
+			return
+		}
 		err = os.Rename(l, r.LatestSymlink)
 	}
 	return
 }
 
+// index adds the result directory returned by Close to the KeySymlinks trees
+// and the Catalog, for the given Tests that wrote new files to it.
+func (r resultRW) index(resultDir string, tests Tests) (err error) {
+	if err = r.linkKeys(resultDir, tests); err != nil {
+		return
+	}
+	err = r.updateCatalog(resultDir, tests)
+	return
+}
+
 // dirEmpty returns empty true if the named directory is empty or does not exist.
 func dirEmpty(name string) (empty bool, err error) {
 	var d *os.File
@@ -702,12 +817,15 @@ type ResultWriter struct {
 
 	// initted is true after ResultWriter is lazily initialized in Write.
 	initted bool
+
+	// perm is used to create any missing directories in Path.
+	perm filePerm
 }
 
 // Write implements io.Writer.
 func (w *ResultWriter) Write(p []byte) (n int, err error) {
 	if !w.initted {
-		if err = os.MkdirAll(filepath.Dir(w.Path), 0755); err != nil {
+		if err = w.perm.mkdirAll(filepath.Dir(w.Path)); err != nil {
 			return
 		}
 		w.initted = true
@@ -799,14 +917,15 @@ type atomicWriter struct {
 	name    string // includes prefix, but not WorkDir
 	workDir string
 	info    []ResultInfo
+	perm    filePerm
 	tmp     *os.File
 	stat    *resultStat
 }
 
 // newAtomicWriter returns a new atomicWriter.
-func newAtomicWriter(name, workDir string, info []ResultInfo,
+func newAtomicWriter(name, workDir string, info []ResultInfo, perm filePerm,
 	stat *resultStat) *atomicWriter {
-	return &atomicWriter{name, workDir, info, nil, stat}
+	return &atomicWriter{name, workDir, info, perm, nil, stat}
 }
 
 // path returns the path to the file in WorkDir.
@@ -822,7 +941,7 @@ func (a *atomicWriter) tmpPath() string {
 // Write implements io.Writer.
 func (a *atomicWriter) Write(p []byte) (n int, err error) {
 	if a.tmp == nil {
-		if a.tmp, err = os.Create(a.tmpPath()); err != nil {
+		if a.tmp, err = a.perm.create(a.tmpPath()); err != nil {
 			return
 		}
 	}
@@ -936,18 +1055,75 @@ func compareFiles(name1, name2 string) (same bool, err error) {
 	}
 }
 
-// stdoutWriter writes to stdout, and does nothing on Close.
+// stdoutWriter writes to stdout through the stdout arbiter, so that output from
+// multiple writers isn't interleaved.
 type stdoutWriter struct {
+	buf    bytes.Buffer
+	closed bool
 }
 
 // Write implements io.Writer.
-func (stdoutWriter) Write(p []byte) (n int, err error) {
-	return os.Stdout.Write(p)
+func (w *stdoutWriter) Write(p []byte) (n int, err error) {
+	return stdout.write(w, p)
 }
 
 // Close implements io.Closer.
-func (stdoutWriter) Close() error {
-	return nil
+func (w *stdoutWriter) Close() error {
+	return stdout.close(w)
+}
+
+// stdout is the arbiter for all stdoutWriters.
+var stdout stdoutArbiter
+
+// stdoutArbiter serializes the output of stdoutWriters, so that the output from
+// each writer appears contiguously on stdout. The first writer to write owns
+// stdout, and writes directly to it until closed, so that output may be
+// streamed (e.g. logs during a Test). Output from other writers is buffered
+// until they become the owner, in the order of their first write.
+type stdoutArbiter struct {
+	owner *stdoutWriter
+	wait  []*stdoutWriter
+	mtx   sync.Mutex
+}
+
+// write writes p for the given writer, or buffers it if another writer owns
+// stdout.
+func (a *stdoutArbiter) write(w *stdoutWriter, p []byte) (n int, err error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if a.owner == nil {
+		a.owner = w
+	}
+	if a.owner == w {
+		return os.Stdout.Write(p)
+	}
+	if !slices.Contains(a.wait, w) {
+		a.wait = append(a.wait, w)
+	}
+	return w.buf.Write(p)
+}
+
+// close closes the given writer. If it owns stdout, ownership passes to the
+// next waiting writer, after its buffered output is written.
+func (a *stdoutArbiter) close(w *stdoutWriter) (err error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	w.closed = true
+	if a.owner != w {
+		return
+	}
+	a.owner = nil
+	for len(a.wait) > 0 && a.owner == nil {
+		n := a.wait[0]
+		a.wait = a.wait[1:]
+		if _, e := n.buf.WriteTo(os.Stdout); e != nil && err == nil {
+			err = e
+		}
+		if !n.closed {
+			a.owner = n
+		}
+	}
+	return
 }
 
 // pathSet represents a set of file paths.
@@ -0,0 +1,287 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"context"
+	_ "embed"
+	"html/template"
+	"io"
+	"maps"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/heistp/antler/node"
+	"github.com/heistp/antler/node/metric"
+	"gonum.org/v1/gonum/stat"
+)
+
+// rrulDefaultStep is the throughput Step used if Step is not set, as in flent.
+const rrulDefaultStep = 200 * time.Millisecond
+
+// rrulTemplate is the template for the ChartsRRUL reporter.
+//
+//go:embed rrul.html.tmpl
+var rrulTemplate string
+
+// ChartsRRUL is a reporter that plots results in the layout of flent's RRUL
+// test, with stacked charts for download throughput, upload throughput and
+// latency, and a summary table. It is intended for use with the rrul Workload
+// Preset, but works with any mix of stream and packet flows.
+type ChartsRRUL struct {
+	// FlowLabel sets custom labels for Flows.
+	FlowLabel map[node.Flow]string
+
+	// FlowLabelMeta, if true, appends a summary of each Flow's metadata to
+	// its label, for Flows without a label in FlowLabel.
+	FlowLabelMeta bool
+
+	// To lists the names of files to execute the template to. A file of "-"
+	// emits to stdout.
+	To []string
+
+	// Title is the title of the report.
+	Title string
+
+	// Step is the time interval used to calculate throughput.
+	Step metric.Duration
+
+	// DataTable, if true, includes a collapsible table of the plotted data in
+	// the HTML, and a button to download it as CSV.
+	DataTable bool
+
+	// Options is an arbitrary structure of Charts options applied to each
+	// chart, with defaults defined in config.cue.
+	// https://developers.google.com/chart/interactive/docs/gallery/linechart#configuration-options
+	Options map[string]any
+
+	// theme is the Theme set from the Config.
+	theme Theme
+}
+
+// rrulTemplateData contains the data for rrulTemplate execution.
+type rrulTemplateData struct {
+	Title     string
+	Chart     []chartsPanel
+	Stream    []rrulStream
+	Probe     []rrulProbe
+	DataTable bool
+}
+
+// rrulStream contains summary statistics for a stream.
+type rrulStream struct {
+	Flow      node.Flow
+	Meta      FlowMeta
+	Direction node.Direction
+	Goodput   metric.Bitrate
+}
+
+// rrulProbe contains summary statistics for a latency probe.
+type rrulProbe struct {
+	Flow      node.Flow
+	Meta      FlowMeta
+	RTTMean   float64
+	RTTMedian float64
+	LostPct   float64
+}
+
+// setTheme implements themer
+func (g *ChartsRRUL) setTheme(t Theme) {
+	g.theme = t
+}
+
+// report implements reporter
+func (g *ChartsRRUL) report(ctx context.Context, rw rwer, in <-chan any,
+	out chan<- any) (err error) {
+	var t *template.Template
+	if t, err = newStyleTemplate(g.theme); err != nil {
+		return
+	}
+	t = t.New("ChartsRRUL")
+	var f *flowRegistry
+	t = t.Funcs(template.FuncMap{
+		"flowLabel": func(flow node.Flow) string {
+			return f.Label(flow, g.FlowLabel, g.FlowLabelMeta)
+		},
+		"anchor": flowAnchor,
+	})
+	if t, err = t.Parse(rrulTemplate); err != nil {
+		return
+	}
+	var a analysis
+	for d := range in {
+		out <- d
+		switch v := d.(type) {
+		case analysis:
+			a = v
+		}
+	}
+	if f = a.flows; f == nil {
+		f = newFlowRegistry(nil, g.theme.palette())
+	}
+	td := g.data(f, a.streamList(), a.packetList())
+	var ww []io.WriteCloser
+	for _, to := range g.To {
+		ww = append(ww, rw.Writer(to))
+	}
+	defer func() {
+		for _, w := range ww {
+			if e := w.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	}()
+	err = t.Execute(multiWriteCloser(ctx, ww...), td)
+	return
+}
+
+// data returns the template data.
+func (g *ChartsRRUL) data(reg *flowRegistry, san []StreamAnalysis,
+	pan []PacketAnalysis) (td rrulTemplateData) {
+	td.Title = g.Title
+	td.DataTable = g.DataTable
+	var down, up []StreamAnalysis
+	for _, s := range san {
+		d := s.Client.Direction
+		if d == "" {
+			d = s.Server.Direction
+		}
+		if d == node.Down {
+			down = append(down, s)
+		} else {
+			up = append(up, s)
+		}
+		td.Stream = append(td.Stream,
+			rrulStream{s.Flow, s.Meta, d, s.Goodput()})
+	}
+	var probe []PacketAnalysis
+	for _, p := range pan {
+		if len(p.RTT) == 0 {
+			continue
+		}
+		probe = append(probe, p)
+		var rr []float64
+		for _, r := range p.RTT {
+			rr = append(rr, r.Delay.Seconds()*1000.0)
+		}
+		sort.Float64s(rr)
+		td.Probe = append(td.Probe, rrulProbe{p.Flow, p.Meta, p.RTTMean,
+			stat.Quantile(0.5, stat.Empirical, rr, nil), p.Up.LostPct})
+	}
+	td.Chart = append(td.Chart,
+		g.throughputChart(reg, "download", "Download", down),
+		g.throughputChart(reg, "upload", "Upload", up),
+		g.latencyChart(reg, probe),
+	)
+	return
+}
+
+// throughputChart returns a chart of the throughput for each of the given
+// streams, and their total, calculated in intervals of Step.
+func (g *ChartsRRUL) throughputChart(reg *flowRegistry, id, title string,
+	san []StreamAnalysis) (c chartsPanel) {
+	c.ID = id
+	var cols chartsColumns
+	c.Data.set(0, 0, "Time (sec)")
+	step := g.Step.Duration()
+	if step <= 0 {
+		step = rrulDefaultStep
+	}
+	var end metric.RelativeTime
+	for _, s := range san {
+		if n := len(s.Rcvd); n > 0 && s.Rcvd[n-1].T > end {
+			end = s.Rcvd[n-1].T
+		}
+	}
+	var tot []float64
+	for i, s := range san {
+		cols.add(s.Flow, nil)
+		c.Data.set(0, i+1, reg.Label(s.Flow, g.FlowLabel, g.FlowLabelMeta))
+		r := 1
+		d := metric.RelativeTime(step)
+		for t := metric.RelativeTime(0); t < end; t += d {
+			b := rrulTotalAt(s.Rcvd, t+d) - rrulTotalAt(s.Rcvd, t)
+			m := metric.CalcBitrate(b, step).Mbps()
+			c.Data.set(r, 0, (t + d).Duration().Seconds())
+			c.Data.set(r, i+1, m)
+			if r > len(tot) {
+				tot = append(tot, 0)
+			}
+			tot[r-1] += m
+			r++
+		}
+	}
+	if len(san) > 1 {
+		c.Data.set(0, len(san)+1, "Total")
+		for i, m := range tot {
+			c.Data.set(i+1, len(san)+1, m)
+		}
+	}
+	c.Data.normalize()
+	c.Options = g.options(cols, reg, title, "Mbps")
+	if len(san) > 1 {
+		s := c.Options["series"].(map[string]any)
+		s[strconv.Itoa(len(san))] = map[string]any{
+			"color":     "#000000",
+			"lineWidth": 2,
+		}
+	}
+	return
+}
+
+// latencyChart returns a chart of the round-trip times for the given packet
+// flows.
+func (g *ChartsRRUL) latencyChart(reg *flowRegistry,
+	pan []PacketAnalysis) (c chartsPanel) {
+	c.ID = "latency"
+	var cols chartsColumns
+	c.Data.set(0, 0, "Time (sec)")
+	row := 1
+	for i, p := range pan {
+		cols.add(p.Flow, nil)
+		c.Data.set(0, i+1, reg.Label(p.Flow, g.FlowLabel, g.FlowLabelMeta))
+		for _, r := range p.RTT {
+			c.Data.set(row, 0, r.T.Duration().Seconds())
+			c.Data.set(row, i+1, r.Delay.Seconds()*1000.0)
+			row++
+		}
+	}
+	c.Data.normalize()
+	c.Options = g.options(cols, reg, "Latency", "RTT (ms)")
+	c.Options["interpolateNulls"] = true
+	return
+}
+
+// options returns the Charts options for one chart, with the given title and
+// vertical axis title.
+func (g *ChartsRRUL) options(cols chartsColumns, reg *flowRegistry,
+	title, vtitle string) (opt map[string]any) {
+	opt = cols.options(g.Options, reg)
+	opt["title"] = title
+	v := make(map[string]any)
+	if a, ok := opt["vAxis"].(map[string]any); ok {
+		v = maps.Clone(a)
+	}
+	v["title"] = vtitle
+	opt["vAxis"] = v
+	return
+}
+
+// rrulTotalAt returns the total bytes received at the given time, linearly
+// interpolated from the given StreamIO's.
+func rrulTotalAt(rcvd []node.StreamIO, t metric.RelativeTime) metric.Bytes {
+	i := sort.Search(len(rcvd), func(i int) bool {
+		return rcvd[i].T >= t
+	})
+	switch {
+	case i == 0:
+		return 0
+	case i == len(rcvd):
+		return rcvd[len(rcvd)-1].Total
+	}
+	p, n := rcvd[i-1], rcvd[i]
+	f := float64(t-p.T) / float64(n.T-p.T)
+	return p.Total + metric.Bytes(f*float64(n.Total-p.Total))
+}
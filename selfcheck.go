@@ -0,0 +1,230 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"context"
+	"embed"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"text/template"
+
+	"cuelang.org/go/cue/load"
+)
+
+//go:embed selfcheck/*.cue
+var selfcheckCue embed.FS
+
+// selfcheckTestPath is the path prefix of the selfcheck Test's result files.
+const selfcheckTestPath = "selfcheck_"
+
+// SelfcheckCommand runs a minimal built-in Test in a temporary directory, with
+// one stream and one packet flow between a pair of network namespaces, then
+// verifies the results and report generation. It is used to check that the
+// environment is suitable for running Antler.
+type SelfcheckCommand struct {
+	// Duration is the duration of the flows, in seconds.
+	Duration int
+
+	// Keep, if true, keeps the temporary directory, even if all checks pass.
+	Keep bool
+
+	// Checking is called when a check starts.
+	Checking func(name string)
+
+	// Checked is called when a check completes, with a nil error if it passed.
+	Checked func(name string, err error)
+
+	// Done is called when the SelfcheckCommand is done, with the temporary
+	// directory, or an empty string if it was removed.
+	Done func(dir string)
+
+	// Platform is the node platform, set by run.
+	Platform string
+}
+
+// selfcheckFunc is a function that performs one check.
+type selfcheckFunc func(ctx context.Context) error
+
+// run implements command
+func (s *SelfcheckCommand) run(ctx context.Context) (err error) {
+	if s.Duration <= 0 {
+		s.Duration = 3
+	}
+	s.Platform = fmt.Sprintf("%s-%s", runtime.GOOS, runtime.GOARCH)
+	var d string
+	if d, err = os.MkdirTemp("", "antler-selfcheck-"); err != nil {
+		return
+	}
+	defer func() {
+		if err == nil && !s.Keep {
+			if e := os.RemoveAll(d); e != nil {
+				err = e
+			}
+			d = ""
+		}
+		if s.Done != nil {
+			s.Done(d)
+		}
+	}()
+	var r string
+	cc := []struct {
+		name string
+		f    selfcheckFunc
+	}{
+		{"environment", s.checkEnv},
+		{"config", func(ctx context.Context) error {
+			return s.checkConfig(ctx, d)
+		}},
+		{"run", func(ctx context.Context) error {
+			return s.checkRun(ctx, d, &r)
+		}},
+		{"results", func(ctx context.Context) error {
+			return s.checkResults(r)
+		}},
+	}
+	for _, c := range cc {
+		if s.Checking != nil {
+			s.Checking(c.name)
+		}
+		err = c.f(ctx)
+		if s.Checked != nil {
+			s.Checked(c.name, err)
+		}
+		if err != nil {
+			err = ExitError{ExitEnvironment,
+				fmt.Errorf("selfcheck %s failed: %w", c.name, err)}
+			return
+		}
+	}
+	return
+}
+
+// checkEnv checks that the required privileges, commands and node executable
+// are available.
+func (s *SelfcheckCommand) checkEnv(ctx context.Context) (err error) {
+	if runtime.GOOS != "linux" {
+		err = fmt.Errorf("network namespaces require Linux, not %s",
+			runtime.GOOS)
+		return
+	}
+	if os.Geteuid() != 0 {
+		err = fmt.Errorf("root is required to create network namespaces")
+		return
+	}
+	if _, err = exec.LookPath("ip"); err != nil {
+		return
+	}
+	var p []string
+	if p, err = (&exeSource{}).Platforms(); err != nil {
+		return
+	}
+	if !slices.Contains(p, s.Platform) {
+		err = fmt.Errorf("node platform %s not built in (have %s), "+
+			"see Makenode", s.Platform, p)
+	}
+	return
+}
+
+// checkConfig writes the selfcheck package to dir and checks that it loads.
+func (s *SelfcheckCommand) checkConfig(ctx context.Context, dir string) (
+	err error) {
+	var t *template.Template
+	if t, err = template.ParseFS(selfcheckCue, "selfcheck/*.cue"); err != nil {
+		return
+	}
+	var f *os.File
+	if f, err = os.Create(filepath.Join(dir, "selfcheck.cue")); err != nil {
+		return
+	}
+	defer func() {
+		if e := f.Close(); e != nil && err == nil {
+			err = e
+		}
+	}()
+	if err = t.Execute(f, s); err != nil {
+		return
+	}
+	_, err = LoadConfig(&load.Config{Dir: dir})
+	return
+}
+
+// checkRun runs the selfcheck Test in dir, and sets the result directory.
+func (s *SelfcheckCommand) checkRun(ctx context.Context, dir string,
+	resultDir *string) (err error) {
+	r := &RunCommand{
+		Filter: BoolFilter(true),
+		Done: func(info RunInfo) {
+			*resultDir = info.ResultDir
+		},
+		dir: dir,
+	}
+	if err = r.run(ctx); err != nil {
+		return
+	}
+	if *resultDir == "" {
+		err = fmt.Errorf("no result directory was saved")
+	}
+	return
+}
+
+// checkResults checks that the expected result files were written, and that
+// the data contains goodput for the stream and replies for the packet flow.
+func (s *SelfcheckCommand) checkResults(resultDir string) (err error) {
+	p := func(name string) string {
+		return filepath.Join(resultDir, selfcheckTestPath+name)
+	}
+	for _, n := range []string{"data.gob", "timeseries.html", "log.txt"} {
+		if _, err = os.Stat(p(n)); err != nil {
+			return
+		}
+	}
+	var f *os.File
+	if f, err = os.Open(p("data.gob")); err != nil {
+		return
+	}
+	defer f.Close()
+	y := newAnalysis()
+	c := gob.NewDecoder(f)
+	for {
+		var a any
+		if err = c.Decode(&a); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = nil
+				break
+			}
+			return
+		}
+		if e, ok := a.(error); ok {
+			err = e
+			return
+		}
+		y.add(a)
+	}
+	y.analyze()
+	for _, t := range y.streamList() {
+		if t.Length == 0 {
+			err = fmt.Errorf("stream %s transferred no data", t.Flow)
+			return
+		}
+	}
+	for _, k := range y.packetList() {
+		if k.Up.Rcvd == 0 {
+			err = fmt.Errorf("packet flow %s had no packets received", k.Flow)
+			return
+		}
+	}
+	if len(y.streams) != 1 || len(y.packets) != 1 {
+		err = fmt.Errorf("expected 1 stream and 1 packet flow, got %d and %d",
+			len(y.streams), len(y.packets))
+	}
+	return
+}
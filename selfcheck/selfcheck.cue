@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+// This package is written and run by the antler selfcheck command. It runs one
+// stream and one packet flow for a few seconds between two nodes in a pair of
+// network namespaces, connected by a veth pair.
+
+package selfcheck
+
+_platform: "{{.Platform}}"
+
+_duration: {{.Duration}}
+
+_addr: {
+	left:  "10.254.254.1"
+	right: "10.254.254.2"
+}
+
+_serverAddr: "\(_addr.right):7777"
+
+_node: {
+	ID:       string & !=""
+	Platform: _platform
+	Launcher: Local: {}
+	Netns: {Create: true}
+}
+
+_left:  _node & {ID: "selfcheck-l"}
+_right: _node & {ID: "selfcheck-r"}
+
+Test: [{
+	ID: name: "selfcheck"
+	Timeout: "\(_duration+30)s"
+	Serial: [
+		{Child: {
+			Node: _right
+			Serial: [
+				{System: Command: "ip link add dev sc.r type veth peer name sc.l"},
+				{System: Command: "ip link set dev sc.l netns selfcheck-l"},
+				{System: Command: "ip addr add \(_addr.right)/24 dev sc.r"},
+				{System: Command: "ip link set sc.r up"},
+				{StreamServer: {ListenAddr: _serverAddr}},
+				{PacketServer: {ListenAddr: _serverAddr}},
+			]
+		}},
+		{Child: {
+			Node: _left
+			Serial: [
+				{System: Command: "ip addr add \(_addr.left)/24 dev sc.l"},
+				{System: Command: "ip link set sc.l up"},
+				{Parallel: [
+					{StreamClient: {
+						Addr: _serverAddr
+						Upload: {
+							Flow:             "selfcheck-tcp"
+							Duration:         "\(_duration)s"
+							IOSampleInterval: "100ms"
+						}
+					}},
+					{PacketClient: {
+						Addr: _serverAddr
+						Flow: "selfcheck-udp"
+						Sender: [
+							{Unresponsive: {
+								Wait: ["20ms"]
+								Duration: "\(_duration)s"
+							}},
+						]
+					}},
+				]},
+			]
+		}},
+	]
+	DuringDefault: [{SaveFiles: {Consume: true}}]
+	After: [
+		{Analyze: {}},
+		{ChartsTimeSeries: {To: ["timeseries.html"]}},
+	]
+}]
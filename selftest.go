@@ -0,0 +1,51 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/heistp/antler/node"
+)
+
+// SelftestCommand runs benchmarks of the node's hot paths, to report the
+// achievable rates of the traffic generator on the current host.
+type SelftestCommand struct {
+	// Pattern, if not empty, is a regular expression that selects which
+	// benchmarks to run by name.
+	Pattern string
+
+	// Running is called when a benchmark starts running.
+	Running func(name string)
+
+	// Result is called with the result of each benchmark.
+	Result func(node.BenchmarkResult)
+}
+
+// run implements command
+func (s *SelftestCommand) run(ctx context.Context) (err error) {
+	var r *regexp.Regexp
+	if s.Pattern != "" {
+		if r, err = regexp.Compile(s.Pattern); err != nil {
+			return
+		}
+	}
+	for _, b := range node.Benchmarks() {
+		if r != nil && !r.MatchString(b.Name) {
+			continue
+		}
+		if err = context.Cause(ctx); err != nil {
+			return
+		}
+		if s.Running != nil {
+			s.Running(b.Name)
+		}
+		t := b.Run()
+		if s.Result != nil {
+			s.Result(t)
+		}
+	}
+	return
+}
@@ -5,9 +5,11 @@ package antler
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"runtime/debug"
 	"time"
 )
@@ -17,8 +19,23 @@ import (
 
 // Server is the builtin web server.
 type Server struct {
-	ListenAddr string
-	RootDir    string
+	ListenAddr      string
+	RootDir         string
+	Catalog         string
+	CollectDir      string
+	CollectTokenEnv string
+	Live            bool
+	LiveTokenEnv    string
+	API             bool
+	APITokenEnv     string
+	theme           Theme
+	results         Results
+	test            Tests
+}
+
+// setTheme implements themer
+func (s *Server) setTheme(t Theme) {
+	s.theme = t
 }
 
 // Run runs the server.
@@ -27,8 +44,63 @@ func (s Server) Run(ctx context.Context) (err error) {
 
 	m := http.NewServeMux()
 	m.Handle("/", http.FileServer(http.Dir(s.RootDir)))
-	//m.Handle("/admin/", http.FileServer(http.FS(admin)))
+	if s.Catalog != "" {
+		m.HandleFunc("/search", s.search)
+	}
+	if s.CollectDir != "" {
+		if s.CollectTokenEnv == "" {
+			err = fmt.Errorf("collect requires a token " +
+				"(Server.CollectTokenEnv is empty)")
+			return
+		}
+		h := collectHandler{Dir: s.CollectDir}
+		if h.token = os.Getenv(s.CollectTokenEnv); h.token == "" {
+			err = fmt.Errorf("collect token variable %s is not set",
+				s.CollectTokenEnv)
+			return
+		}
+		h.register(m)
+	}
+	if s.API {
+		if s.Catalog == "" {
+			err = fmt.Errorf("the API requires the Catalog " +
+				"(Results.Catalog is empty)")
+			return
+		}
+		h := &apiHandler{
+			ctx:     ctx,
+			rootDir: s.RootDir,
+			catalog: s.Catalog,
+			results: s.results,
+			test:    s.test,
+		}
+		if s.APITokenEnv != "" {
+			if h.token = os.Getenv(s.APITokenEnv); h.token == "" {
+				err = fmt.Errorf("API token variable %s is not set",
+					s.APITokenEnv)
+				return
+			}
+		}
+		h.register(m)
+	}
 	var v http.Server
+	if s.Live {
+		var t string
+		if s.LiveTokenEnv != "" {
+			if t = os.Getenv(s.LiveTokenEnv); t == "" {
+				err = fmt.Errorf("live token variable %s is not set",
+					s.LiveTokenEnv)
+				return
+			}
+		}
+		var h *liveHandler
+		if h, err = newLiveHandler(t, s.theme); err != nil {
+			return
+		}
+		h.register(m)
+		v.RegisterOnShutdown(h.stop)
+	}
+	//m.Handle("/admin/", http.FileServer(http.FS(admin)))
 	v.Addr = s.ListenAddr
 	v.Handler = m
 
@@ -68,3 +140,30 @@ func (s Server) Run(ctx context.Context) (err error) {
 
 	return
 }
+
+// search handles requests to search the Catalog. Each q parameter is an
+// argument as for NewCatalogQueryArgs, and the CatalogResults are returned as
+// JSON, with paths relative to RootDir.
+func (s Server) search(w http.ResponseWriter, r *http.Request) {
+	q, err := NewCatalogQueryArgs(r.URL.Query()["q"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var c Catalog
+	if c, err = loadCatalog(s.Catalog); err == nil {
+		err = c.prune(s.RootDir)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rr := c.Search(q, "")
+	if rr == nil {
+		rr = []CatalogResult{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(rr); err != nil {
+		log.Printf("search: %s", err)
+	}
+}
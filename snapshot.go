@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/heistp/antler/node/metric"
+)
+
+// Snapshot is a reporter for long running (soak) Tests, which periodically runs
+// a pipeline of Reports on the data received so far, so that results may be
+// inspected before the Test completes. Snapshot must be in the During
+// pipeline. Files written by the Reports are prefixed with Prefix, and are
+// replaced with each snapshot.
+//
+// Snapshot retains all data items in memory for the duration of the Test, so
+// memory use grows with the amount of data. For long Tests, sample intervals
+// (e.g. Transfer.IOSampleInterval) should be chosen accordingly.
+type Snapshot struct {
+	// Interval is the time between snapshots.
+	Interval metric.Duration
+
+	// Prefix is prepended to the names of files written by Report.
+	Prefix string
+
+	// Report is the pipeline of Reports run for each snapshot.
+	Report Report
+}
+
+// setTheme implements themer
+func (s *Snapshot) setTheme(t Theme) {
+	s.Report.setTheme(t)
+}
+
+// report implements reporter
+func (s *Snapshot) report(ctx context.Context, rw rwer, in <-chan any,
+	out chan<- any) (err error) {
+	var a []any
+	c := newMemoryCheck(ctx, s)
+	t := time.NewTicker(s.Interval.Duration())
+	defer t.Stop()
+	var done chan error
+	defer func() {
+		if done != nil {
+			if e := <-done; e != nil && err == nil {
+				err = e
+			}
+		}
+	}()
+	for {
+		select {
+		case d, ok := <-in:
+			if !ok {
+				return
+			}
+			a = append(a, d)
+			out <- d
+			if err = c.add(); err != nil {
+				return
+			}
+		case <-t.C:
+			if done != nil {
+				select {
+				case e := <-done:
+					if e != nil && err == nil {
+						err = e
+					}
+				default:
+					// previous snapshot still running
+					continue
+				}
+			}
+			done = make(chan error, 1)
+			go s.run(ctx, rw, a[:len(a):len(a)], done)
+		}
+	}
+}
+
+// validate returns an error if the Snapshot is invalid.
+func (s *Snapshot) validate() (err error) {
+	if s.Interval <= 0 {
+		err = fmt.Errorf("Snapshot Interval must be positive: %s", s.Interval)
+		return
+	}
+	err = s.Report.validate()
+	return
+}
+
+// run runs the Report pipeline for the given data, and sends the first error,
+// or nil, to done.
+func (s *Snapshot) run(ctx context.Context, rw rwer, data []any,
+	done chan<- error) {
+	var err error
+	defer func() {
+		done <- err
+	}()
+	r := report([]reporter{rangeData(data)})
+	r = r.add(s.Report.report())
+	for e := range r.pipeline(ctx, prefixRW{rw, s.Prefix}, nil, nil) {
+		if err == nil {
+			err = e
+		}
+	}
+}
+
+// prefixRW is an rwer that adds a prefix to the names of written files.
+type prefixRW struct {
+	rwer
+	prefix string
+}
+
+// Writer implements rwer
+func (p prefixRW) Writer(name string) *ResultWriter {
+	if name == "-" {
+		return p.rwer.Writer(name)
+	}
+	return p.rwer.Writer(p.prefix + name)
+}
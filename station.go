@@ -0,0 +1,380 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+	"maps"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/heistp/antler/node"
+	"github.com/heistp/antler/node/metric"
+	"gonum.org/v1/gonum/stat"
+)
+
+// stationTemplate is the template for the ChartsStation reporter.
+//
+//go:embed station.html.tmpl
+var stationTemplate string
+
+// StationAnalysis contains the data and calculated stats for one wireless
+// station, as sampled by a Station runner on a node.
+type StationAnalysis struct {
+	// data
+	NodeID    node.ID
+	Interface string
+	Station   string
+	Info      []node.StationInfo
+
+	// statistics
+	Point        []stationPoint
+	SignalMean   float64 // mean signal (dBm)
+	TxRateMean   float64 // mean transmit PHY rate (Mbps)
+	RxRateMean   float64 // mean receive PHY rate (Mbps)
+	TxRetries    uint64  // transmit retries during sampling
+	TxFailed     uint64  // failed transmissions during sampling
+	TxPackets    uint64  // transmitted packets during sampling
+	RetryPct     float64 // transmit retries, as a percent of TxPackets
+	AirtimePct   float64 // transmit and receive airtime, as a percent of time
+	AirtimeKnown bool    // true if airtime was reported by the driver
+}
+
+// stationPoint contains the stats calculated between two samples.
+type stationPoint struct {
+	T          metric.RelativeTime // time of the later sample
+	RetryPct   float64             // retries as a percent of tx packets
+	AirtimePct float64             // tx and rx airtime as a percent of time
+}
+
+// Label returns a label for the station, with its node ID and MAC address.
+func (s *StationAnalysis) Label() string {
+	return fmt.Sprintf("%s %s", s.NodeID, s.Station)
+}
+
+// analyze calculates the stats for the station. The data fields must already
+// have been populated, and synchronized.
+func (s *StationAnalysis) analyze() {
+	if len(s.Info) == 0 {
+		return
+	}
+	var sg, tx, rx []float64
+	for _, i := range s.Info {
+		sg = append(sg, float64(i.Signal))
+		tx = append(tx, i.TxBitrate.Mbps())
+		rx = append(rx, i.RxBitrate.Mbps())
+	}
+	s.SignalMean = stat.Mean(sg, nil)
+	s.TxRateMean = stat.Mean(tx, nil)
+	s.RxRateMean = stat.Mean(rx, nil)
+	s.Point = nil
+	for j := 1; j < len(s.Info); j++ {
+		p, i := s.Info[j-1], s.Info[j]
+		var t stationPoint
+		t.T = i.T
+		if i.TxPackets < p.TxPackets || i.TxRetries < p.TxRetries {
+			// counters were reset, e.g. after reassociation
+			continue
+		}
+		if n := i.TxPackets - p.TxPackets; n > 0 {
+			t.RetryPct = 100.0 * float64(i.TxRetries-p.TxRetries) / float64(n)
+		}
+		if d := time.Duration(i.T - p.T); d > 0 {
+			a := (i.TxAirtime - p.TxAirtime) + (i.RxAirtime - p.RxAirtime)
+			t.AirtimePct = 100.0 * float64(a) / float64(d)
+		}
+		s.Point = append(s.Point, t)
+	}
+	f, l := s.Info[0], s.Info[len(s.Info)-1]
+	if l.TxPackets < f.TxPackets || l.TxRetries < f.TxRetries ||
+		l.TxFailed < f.TxFailed {
+		return
+	}
+	s.TxRetries = l.TxRetries - f.TxRetries
+	s.TxFailed = l.TxFailed - f.TxFailed
+	s.TxPackets = l.TxPackets - f.TxPackets
+	if s.TxPackets > 0 {
+		s.RetryPct = 100.0 * float64(s.TxRetries) / float64(s.TxPackets)
+	}
+	a := (l.TxAirtime - f.TxAirtime) + (l.RxAirtime - f.RxAirtime)
+	s.AirtimeKnown = l.TxAirtime > 0 || l.RxAirtime > 0
+	if d := time.Duration(l.T - f.T); d > 0 {
+		s.AirtimePct = 100.0 * float64(a) / float64(d)
+	}
+}
+
+// stations aggregates data for multiple wireless stations.
+type stations map[string]*StationAnalysis
+
+// newStations returns a new stations.
+func newStations() stations {
+	return stations(make(map[string]*StationAnalysis))
+}
+
+// analysis adds StationAnalysis for the station in the given StationInfo if it
+// doesn't already exist.
+func (m *stations) analysis(info node.StationInfo) (s *StationAnalysis) {
+	k := fmt.Sprintf("%s/%s/%s", info.NodeID, info.Interface, info.Station)
+	var ok bool
+	if s, ok = (*m)[k]; ok {
+		return
+	}
+	s = &StationAnalysis{
+		NodeID:    info.NodeID,
+		Interface: info.Interface,
+		Station:   info.Station,
+	}
+	(*m)[k] = s
+	return
+}
+
+// StartTime returns the earliest absolute sample time among the stations. This
+// is used as the start time only if there are no flows.
+func (m *stations) StartTime() (start time.Time) {
+	for _, s := range *m {
+		if len(s.Info) == 0 {
+			continue
+		}
+		if t := s.Info[0].Time(); start.IsZero() || t.Before(start) {
+			start = t
+		}
+	}
+	return
+}
+
+// synchronize adjusts the StationInfo RelativeTime values from node-relative
+// to test-relative time. Samples taken before the start time have negative
+// times.
+func (m *stations) synchronize(start time.Time) {
+	for _, s := range *m {
+		for i := 0; i < len(s.Info); i++ {
+			f := &s.Info[i]
+			f.T = metric.RelativeTime(f.Time().Sub(start))
+			f.Tinit = start
+		}
+	}
+}
+
+// analyze uses the collected data to calculate relevant metrics and stats.
+func (m *stations) analyze() {
+	for _, s := range *m {
+		s.analyze()
+	}
+}
+
+// list returns a slice of StationAnalysis, sorted by node ID, interface and
+// station.
+func (m *stations) list() (s []StationAnalysis) {
+	for _, a := range *m {
+		s = append(s, *a)
+	}
+	sort.Slice(s, func(i, j int) bool {
+		if s[i].NodeID != s[j].NodeID {
+			return s[i].NodeID < s[j].NodeID
+		}
+		if s[i].Interface != s[j].Interface {
+			return s[i].Interface < s[j].Interface
+		}
+		return s[i].Station < s[j].Station
+	})
+	return
+}
+
+// ChartsStation is a reporter that plots wireless station statistics from
+// Station runners using Google Charts, with charts for PHY rate, signal,
+// retries and airtime, and a summary table.
+type ChartsStation struct {
+	// To lists the names of files to execute the template to. A file of "-"
+	// emits to stdout.
+	To []string
+
+	// Title is the title of the report.
+	Title string
+
+	// DataTable, if true, includes a collapsible table of the plotted data in
+	// the HTML, and a button to download it as CSV.
+	DataTable bool
+
+	// Options is an arbitrary structure of Charts options applied to each
+	// chart, with defaults defined in config.cue.
+	// https://developers.google.com/chart/interactive/docs/gallery/linechart#configuration-options
+	Options map[string]any
+
+	// theme is the Theme set from the Config.
+	theme Theme
+}
+
+// stationTemplateData contains the data for stationTemplate execution.
+type stationTemplateData struct {
+	Title     string
+	Chart     []chartsPanel
+	Station   []StationAnalysis
+	DataTable bool
+}
+
+// setTheme implements themer
+func (g *ChartsStation) setTheme(t Theme) {
+	g.theme = t
+}
+
+// report implements reporter
+func (g *ChartsStation) report(ctx context.Context, rw rwer, in <-chan any,
+	out chan<- any) (err error) {
+	var t *template.Template
+	if t, err = newStyleTemplate(g.theme); err != nil {
+		return
+	}
+	t = t.New("ChartsStation")
+	if t, err = t.Parse(stationTemplate); err != nil {
+		return
+	}
+	var a analysis
+	for d := range in {
+		out <- d
+		switch v := d.(type) {
+		case analysis:
+			a = v
+		}
+	}
+	td := g.data(a.stations.list())
+	var ww []io.WriteCloser
+	for _, to := range g.To {
+		ww = append(ww, rw.Writer(to))
+	}
+	defer func() {
+		for _, w := range ww {
+			if e := w.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	}()
+	err = t.Execute(multiWriteCloser(ctx, ww...), td)
+	return
+}
+
+// data returns the template data.
+func (g *ChartsStation) data(sta []StationAnalysis) (td stationTemplateData) {
+	td.Title = g.Title
+	td.DataTable = g.DataTable
+	td.Station = sta
+	if len(sta) == 0 {
+		return
+	}
+	var air bool
+	for _, s := range sta {
+		air = air || s.AirtimeKnown
+	}
+	td.Chart = append(td.Chart, g.rateChart(sta), g.signalChart(sta),
+		g.pointChart(sta, "retries", "Retries", "Retries (% of tx packets)",
+			func(p stationPoint) float64 { return p.RetryPct }))
+	if air {
+		td.Chart = append(td.Chart, g.pointChart(sta, "airtime", "Airtime",
+			"Airtime (%)", func(p stationPoint) float64 {
+				return p.AirtimePct
+			}))
+	}
+	return
+}
+
+// rateChart returns a chart of the transmit and receive PHY rates.
+func (g *ChartsStation) rateChart(sta []StationAnalysis) (c chartsPanel) {
+	c.ID = "rate"
+	c.Data.set(0, 0, "Time (sec)")
+	row := 1
+	var ss []map[string]any
+	for i, s := range sta {
+		col := 2*i + 1
+		c.Data.set(0, col, fmt.Sprintf("%s tx", s.Label()))
+		c.Data.set(0, col+1, fmt.Sprintf("%s rx", s.Label()))
+		for _, f := range s.Info {
+			c.Data.set(row, 0, f.T.Duration().Seconds())
+			c.Data.set(row, col, f.TxBitrate.Mbps())
+			c.Data.set(row, col+1, f.RxBitrate.Mbps())
+			row++
+		}
+		l := paletteColor(g.theme.palette(), i)
+		ss = append(ss, map[string]any{"color": l},
+			map[string]any{"color": l, "lineDashStyle": dashDeliveryRate})
+	}
+	c.Data.normalize()
+	c.Options = g.options(ss, "PHY Rate", "Mbps")
+	return
+}
+
+// signalChart returns a chart of the signal strength.
+func (g *ChartsStation) signalChart(sta []StationAnalysis) (c chartsPanel) {
+	c.ID = "signal"
+	c.Data.set(0, 0, "Time (sec)")
+	row := 1
+	var ss []map[string]any
+	for i, s := range sta {
+		c.Data.set(0, i+1, s.Label())
+		for _, f := range s.Info {
+			c.Data.set(row, 0, f.T.Duration().Seconds())
+			c.Data.set(row, i+1, f.Signal)
+			row++
+		}
+		ss = append(ss, map[string]any{
+			"color": paletteColor(g.theme.palette(), i),
+		})
+	}
+	c.Data.normalize()
+	c.Options = g.options(ss, "Signal", "dBm")
+	if v, ok := c.Options["vAxis"].(map[string]any); ok {
+		delete(v, "viewWindow")
+	}
+	return
+}
+
+// pointChart returns a chart of a value from the stationPoints.
+func (g *ChartsStation) pointChart(sta []StationAnalysis, id, title,
+	vtitle string, value func(stationPoint) float64) (c chartsPanel) {
+	c.ID = id
+	c.Data.set(0, 0, "Time (sec)")
+	row := 1
+	var ss []map[string]any
+	for i, s := range sta {
+		c.Data.set(0, i+1, s.Label())
+		for _, p := range s.Point {
+			c.Data.set(row, 0, p.T.Duration().Seconds())
+			c.Data.set(row, i+1, value(p))
+			row++
+		}
+		ss = append(ss, map[string]any{
+			"color": paletteColor(g.theme.palette(), i),
+		})
+	}
+	c.Data.normalize()
+	c.Options = g.options(ss, title, vtitle)
+	return
+}
+
+// options returns the Charts options for one chart, with the given series
+// options, title and vertical axis title.
+func (g *ChartsStation) options(series []map[string]any,
+	title, vtitle string) (opt map[string]any) {
+	opt = maps.Clone(g.Options)
+	if opt == nil {
+		opt = make(map[string]any)
+	}
+	ss := make(map[string]any)
+	for i, s := range series {
+		ss[strconv.Itoa(i)] = s
+	}
+	opt["series"] = ss
+	opt["title"] = title
+	opt["interpolateNulls"] = true
+	v := make(map[string]any)
+	if a, ok := opt["vAxis"].(map[string]any); ok {
+		v = maps.Clone(a)
+	}
+	v["title"] = vtitle
+	opt["vAxis"] = v
+	return
+}
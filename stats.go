@@ -0,0 +1,289 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/heistp/antler/node"
+)
+
+// EmitStats is a reporter that writes summary statistics for the stream and
+// packet flows in a Test, so they may be used for further statistical analysis
+// without scraping the HTML charts. It requires Analyze before it in the
+// pipeline.
+type EmitStats struct {
+	// To lists the destinations to send output to. "-" sends output to stdout,
+	// and everything else sends output to the named file. Destinations ending
+	// in .csv are written as CSV, and all others as JSON.
+	To []string
+}
+
+// report implements reporter
+func (s *EmitStats) report(ctx context.Context, rw rwer, in <-chan any,
+	out chan<- any) (err error) {
+	var t *Stats
+	for d := range in {
+		out <- d
+		if a, ok := d.(analysis); ok {
+			t = newStats(a)
+		}
+	}
+	if t == nil {
+		return
+	}
+	if err = context.Cause(ctx); err != nil {
+		return
+	}
+	for _, n := range s.To {
+		if err = s.emit(rw, n, t); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// emit writes the Stats to the named destination.
+func (s *EmitStats) emit(rw rwer, name string, t *Stats) (err error) {
+	w := rw.Writer(name)
+	defer func() {
+		if e := w.Close(); e != nil && err == nil {
+			err = e
+		}
+	}()
+	if strings.EqualFold(filepath.Ext(name), ".csv") {
+		err = t.writeCSV(w)
+		return
+	}
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	err = e.Encode(t)
+	return
+}
+
+// Stats contains the summary statistics for the flows in a Test. Delays are in
+// milliseconds, bitrates in Mbps, and percentages from 0 to 100.
+type Stats struct {
+	// Stream lists the statistics for each stream flow.
+	Stream []StreamStats `json:"stream"`
+
+	// Packet lists the statistics for each direction of each packet flow.
+	Packet []PacketStats `json:"packet"`
+
+	// WorkingLatency is the latency under load summary, if both idle and
+	// loaded RTTs were measured. It's included only in JSON output.
+	WorkingLatency *WorkingLatency `json:"working_latency,omitempty"`
+}
+
+// newStats returns the Stats from an analysis.
+func newStats(y analysis) (t *Stats) {
+	t = &Stats{[]StreamStats{}, []PacketStats{}, nil}
+	if w := y.working; w.Known() {
+		t.WorkingLatency = &w
+	}
+	for _, s := range y.streamList() {
+		d := s.Client.Direction
+		if d == "" {
+			d = s.Server.Direction
+		}
+		t.Stream = append(t.Stream, StreamStats{
+			s.Flow,
+			d,
+			int64(s.Length),
+			s.FCT.Duration().Seconds() * 1000,
+			s.Goodput().Mbps(),
+		})
+	}
+	m := newMetrics(y)
+	for _, p := range y.packetList() {
+		if p.Up.Sent > 0 {
+			t.Packet = append(t.Packet, newPacketStats(p.Flow, "up", &p.Up, m))
+		}
+		if p.Down.Sent > 0 {
+			t.Packet = append(t.Packet,
+				newPacketStats(p.Flow, "down", &p.Down, m))
+		}
+	}
+	return
+}
+
+// statsHeader is the header row for CSV output. Stream and packet statistics
+// are written in the same table, with empty values for the columns that don't
+// apply to the flow type.
+var statsHeader = []string{
+	"flow",
+	"type",
+	"direction",
+	"length_bytes",
+	"fct_ms",
+	"goodput_mbps",
+	"sent",
+	"lost_pct",
+	"dup_pct",
+	"early_pct",
+	"late_pct",
+	"owd_mean_ms",
+	"owd_p50_ms",
+	"owd_p95_ms",
+	"owd_p99_ms",
+}
+
+// writeCSV writes the Stats to w in CSV format.
+func (t *Stats) writeCSV(w io.Writer) (err error) {
+	c := csv.NewWriter(w)
+	if err = c.Write(statsHeader); err != nil {
+		return
+	}
+	for _, s := range t.Stream {
+		if err = c.Write(s.record()); err != nil {
+			return
+		}
+	}
+	for _, p := range t.Packet {
+		if err = c.Write(p.record()); err != nil {
+			return
+		}
+	}
+	c.Flush()
+	err = c.Error()
+	return
+}
+
+// StreamStats contains the summary statistics for a stream flow.
+type StreamStats struct {
+	// Flow is the stream's flow.
+	Flow node.Flow `json:"flow"`
+
+	// Direction is the client to server sense.
+	Direction node.Direction `json:"direction"`
+
+	// Length is the number of bytes transferred.
+	Length int64 `json:"length_bytes"`
+
+	// FCT is the flow completion time.
+	FCT float64 `json:"fct_ms"`
+
+	// Goodput is the goodput over the FCT.
+	Goodput float64 `json:"goodput_mbps"`
+}
+
+// record returns the CSV record for the StreamStats.
+func (s StreamStats) record() []string {
+	return []string{
+		string(s.Flow),
+		"stream",
+		string(s.Direction),
+		strconv.FormatInt(s.Length, 10),
+		formatStat(s.FCT),
+		formatStat(s.Goodput),
+		"", "", "", "", "", "", "", "", "",
+	}
+}
+
+// PacketStats contains the summary statistics for one direction of a packet
+// flow.
+type PacketStats struct {
+	// Flow is the packet flow.
+	Flow node.Flow `json:"flow"`
+
+	// Direction is "up" for client to server, or "down" for server to client.
+	Direction string `json:"direction"`
+
+	// Sent is the number of packets sent.
+	Sent int `json:"sent"`
+
+	// LostPct is the percentage of sent packets that were lost.
+	LostPct float64 `json:"lost_pct"`
+
+	// DupPct is the percentage of received packets that were duplicates.
+	DupPct float64 `json:"dup_pct"`
+
+	// EarlyPct and LatePct are the percentages of received packets that
+	// arrived early or late (reordered).
+	EarlyPct float64 `json:"early_pct"`
+	LatePct  float64 `json:"late_pct"`
+
+	// OWD contains the one-way delay statistics, or nil if there were no
+	// one-way delay samples.
+	OWD *DelayStats `json:"owd_ms,omitempty"`
+}
+
+// newPacketStats returns the PacketStats for one direction of a packet flow.
+// The OWD statistics are taken from the Test's Metrics, so they're exact in
+// minimal results mode.
+func newPacketStats(flow node.Flow, dir string, s *packetStats,
+	m Metrics) (p PacketStats) {
+	p = PacketStats{
+		Flow:      flow,
+		Direction: dir,
+		Sent:      s.Sent,
+		LostPct:   s.LostPct,
+		DupPct:    s.DupPct,
+		EarlyPct:  s.EarlyPct,
+		LatePct:   s.LatePct,
+	}
+	p.OWD = newDelayStats(m, string(flow)+".owd_"+dir)
+	return
+}
+
+// record returns the CSV record for the PacketStats.
+func (p PacketStats) record() (r []string) {
+	r = []string{
+		string(p.Flow),
+		"packet",
+		p.Direction,
+		"", "", "",
+		strconv.Itoa(p.Sent),
+		formatStat(p.LostPct),
+		formatStat(p.DupPct),
+		formatStat(p.EarlyPct),
+		formatStat(p.LatePct),
+	}
+	if p.OWD == nil {
+		r = append(r, "", "", "", "")
+		return
+	}
+	r = append(r,
+		formatStat(p.OWD.Mean),
+		formatStat(p.OWD.P50),
+		formatStat(p.OWD.P95),
+		formatStat(p.OWD.P99),
+	)
+	return
+}
+
+// DelayStats contains the mean, median, 95th and 99th percentiles of a
+// distribution of delays.
+type DelayStats struct {
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P95  float64 `json:"p95"`
+	P99  float64 `json:"p99"`
+}
+
+// newDelayStats returns the DelayStats for the named distribution from the
+// given Metrics (see Metrics.addDist), or nil if it has no values.
+func newDelayStats(m Metrics, name string) *DelayStats {
+	v, ok := m[name+"_mean_ms"]
+	if !ok {
+		return nil
+	}
+	return &DelayStats{
+		v,
+		m[name+"_p50_ms"],
+		m[name+"_p95_ms"],
+		m[name+"_p99_ms"],
+	}
+}
+
+// formatStat formats a statistic for CSV output.
+func formatStat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,303 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"cuelang.org/go/cue/load"
+)
+
+// sweepFilePrefix is the prefix of the file in RootDir that the trajectory of a
+// Sweep is written to, followed by the Sweep's name and .json.
+const sweepFilePrefix = "sweep_"
+
+// Sweep configures a search for the knee in a parameter, such as a shaper rate
+// or flow count, where a metric condition stops being met. The parameter is
+// injected into the CUE config using a tag (see the @tag attribute in the CUE
+// documentation), and the selected Test is run for each value the search
+// evaluates, using bisection between Min and Max.
+type Sweep struct {
+	// Tag is the name of the CUE tag the parameter value is injected with. The
+	// tagged field should have a default, so the config loads without it.
+	Tag string
+
+	// Min and Max are the bounds of the search. The Cond must be met at one
+	// bound and not the other.
+	Min Number
+	Max Number
+
+	// Resolution is the width of the interval at which the search stops.
+	Resolution Number
+
+	// Integer, if true, rounds the parameter values to integers, e.g. for flow
+	// counts.
+	Integer bool
+
+	// MaxSteps is the maximum number of Test runs, including the two for the
+	// bounds.
+	MaxSteps int
+
+	// Cond is the metric condition that's met on one side of the knee, e.g.
+	// owd_p95_ms<=20. The metrics are read from the Test's metrics.json, so
+	// EmitMetrics must be in its After reports.
+	Cond MetricCond
+
+	// Test selects the Test to run by its ID, with keys as Test ID keys and
+	// values as regular expressions that the values must match. It must select
+	// exactly one Test.
+	Test map[string]string
+}
+
+// validate returns an error if any fields are invalid.
+func (s *Sweep) validate() (err error) {
+	if s.Min == s.Max {
+		err = fmt.Errorf("Sweep Min and Max must differ: %g", s.Min)
+		return
+	}
+	if s.Resolution <= 0 {
+		err = fmt.Errorf("Sweep Resolution must be positive: %g", s.Resolution)
+		return
+	}
+	if s.MaxSteps < 2 {
+		err = fmt.Errorf("Sweep MaxSteps must be at least 2: %d", s.MaxSteps)
+		return
+	}
+	_, err = s.filter()
+	return
+}
+
+// filter returns a TestFilter for the Test field.
+func (s *Sweep) filter() (flt AndFilter, err error) {
+	for k, v := range s.Test {
+		f := &RegexFilter{Key: regexp.MustCompile(
+			"^" + regexp.QuoteMeta(k) + "$")}
+		if f.Value, err = regexp.Compile(v); err != nil {
+			err = fmt.Errorf("invalid Sweep Test regex for %s: %w", k, err)
+			return
+		}
+		flt = append(flt, f)
+	}
+	return
+}
+
+// value returns v as the tag value, rounded if Integer is true.
+func (s *Sweep) value(v float64) float64 {
+	if s.Integer {
+		return math.Round(v)
+	}
+	return v
+}
+
+// SweepStep is the result of one Test run in a Sweep.
+type SweepStep struct {
+	// Value is the parameter value.
+	Value float64
+
+	// Metric is the value of the metric in the Cond.
+	Metric float64
+
+	// Met is true if the Cond was met.
+	Met bool
+
+	// ResultDir is the result directory the Test was saved to.
+	ResultDir string
+}
+
+// SweepResult is the result of a Sweep, which is written to sweep_<name>.json
+// in the RootDir after each step.
+type SweepResult struct {
+	Name  string
+	Tag   string
+	Cond  MetricCond
+	Start time.Time
+
+	// Step lists the steps, in the order they were run.
+	Step []SweepStep
+
+	// Met and Unmet are the closest values found on either side of the knee,
+	// where the Cond was and wasn't met.
+	Met   float64
+	Unmet float64
+
+	// Found is true if the search completed, with Met and Unmet within the
+	// Resolution.
+	Found bool
+}
+
+// SweepCommand runs a Sweep.
+type SweepCommand struct {
+	// Name is the name of the Sweep in the config.
+	Name string
+
+	// Run is the RunCommand used for each step. Its Filter and Tags are set
+	// for each step, and its Done func is wrapped.
+	Run RunCommand
+
+	// Step is called after each step, with the result so far.
+	Step func(SweepResult)
+
+	// Done is called with the result when the Sweep is done, even if it
+	// failed.
+	Done func(SweepResult)
+}
+
+// run implements command
+func (s *SweepCommand) run(ctx context.Context) (err error) {
+	var c *Config
+	if c, err = LoadConfig(&load.Config{}); err != nil {
+		return
+	}
+	w, ok := c.Sweep[s.Name]
+	if !ok {
+		err = exitError(ExitConfig, fmt.Errorf("Sweep '%s' not found",
+			s.Name))
+		return
+	}
+	r := SweepResult{Name: s.Name, Tag: w.Tag, Cond: w.Cond,
+		Start: time.Now()}
+	n := filepath.Join(c.Results.RootDir, sweepFilePrefix+s.Name+".json")
+	defer func() {
+		if s.Done != nil {
+			s.Done(r)
+		}
+	}()
+	v := [2]float64{w.value(float64(w.Min)), w.value(float64(w.Max))}
+	var m [2]bool
+	for i := range v {
+		var p SweepStep
+		if p, err = s.step(ctx, &w, v[i]); err != nil {
+			return
+		}
+		m[i] = p.Met
+		if err = s.add(&r, p, n); err != nil {
+			return
+		}
+	}
+	if m[0] == m[1] {
+		err = fmt.Errorf("Sweep Cond %s must be met at only one of Min (%g) "+
+			"and Max (%g)", w.Cond, v[0], v[1])
+		return
+	}
+	if !m[0] {
+		v[0], v[1] = v[1], v[0]
+	}
+	d := float64(w.Resolution)
+	if w.Integer {
+		d = math.Max(d, 1)
+	}
+	for len(r.Step) < w.MaxSteps && math.Abs(v[1]-v[0]) > d {
+		x := w.value((v[0] + v[1]) / 2)
+		if x == v[0] || x == v[1] {
+			break
+		}
+		var p SweepStep
+		if p, err = s.step(ctx, &w, x); err != nil {
+			return
+		}
+		if p.Met {
+			v[0] = x
+		} else {
+			v[1] = x
+		}
+		r.Met, r.Unmet = v[0], v[1]
+		if err = s.add(&r, p, n); err != nil {
+			return
+		}
+	}
+	r.Met, r.Unmet = v[0], v[1]
+	r.Found = math.Abs(v[1]-v[0]) <= d
+	err = writeJSON(n, r)
+	return
+}
+
+// add adds a step to the result, writes the result to the named file, and calls
+// Step.
+func (s *SweepCommand) add(result *SweepResult, step SweepStep,
+	name string) (err error) {
+	result.Step = append(result.Step, step)
+	if err = writeJSON(name, result); err != nil {
+		return
+	}
+	if s.Step != nil {
+		s.Step(*result)
+	}
+	return
+}
+
+// step runs the Sweep's Test with the given parameter value, and returns the
+// result.
+func (s *SweepCommand) step(ctx context.Context, sweep *Sweep, value float64) (
+	step SweepStep, err error) {
+	step.Value = value
+	r := s.Run
+	if r.Filter, err = sweep.filter(); err != nil {
+		return
+	}
+	r.Tags = append(append([]string{}, s.Run.Tags...),
+		sweep.Tag+"="+strconv.FormatFloat(value, 'f', -1, 64))
+	var tt []*Test
+	r.Running = func(test *Test) {
+		tt = append(tt, test)
+		if s.Run.Running != nil {
+			s.Run.Running(test)
+		}
+	}
+	r.Done = func(info RunInfo) {
+		step.ResultDir = info.ResultDir
+		if s.Run.Done != nil {
+			s.Run.Done(info)
+		}
+	}
+	if err = r.run(ctx); err != nil {
+		if ExitCodeOf(err) != ExitExpect {
+			return
+		}
+		err = nil
+	}
+	if len(tt) != 1 {
+		err = exitError(ExitConfig, fmt.Errorf("Sweep Test must select "+
+			"exactly one Test, but %d ran", len(tt)))
+		return
+	}
+	if step.ResultDir == "" {
+		err = fmt.Errorf("no result saved for %s=%g", sweep.Tag, value)
+		return
+	}
+	var m Metrics
+	p := filepath.Join(step.ResultDir, tt[0].Path)
+	if err = readJSON(p+metricsFile, &m); err != nil {
+		return
+	}
+	var ok bool
+	if step.Metric, ok = m[sweep.Cond.Name]; !ok {
+		err = fmt.Errorf("metric %s not found for %s (is EmitMetrics in the "+
+			"After reports?)", sweep.Cond.Name, tt[0].ID)
+		return
+	}
+	step.Met = sweep.Cond.Match(m)
+	return
+}
+
+// writeJSON writes v to the named file as indented JSON.
+func writeJSON(name string, v any) (err error) {
+	var b bytes.Buffer
+	e := json.NewEncoder(&b)
+	e.SetEscapeHTML(false)
+	e.SetIndent("", "  ")
+	if err = e.Encode(v); err != nil {
+		return
+	}
+	err = os.WriteFile(name, b.Bytes(), 0644)
+	return
+}
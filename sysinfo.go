@@ -39,7 +39,7 @@ func (y *EmitSysInfo) report(ctx context.Context, rw rwer, in <-chan any,
 	for d := range in {
 		out <- d
 		if i, ok := d.(node.SysInfoData); ok {
-			if err = y.emit(rw, t, i); err != nil {
+			if err = y.emit(ctx, rw, t, i); err != nil {
 				return
 			}
 		}
@@ -48,8 +48,8 @@ func (y *EmitSysInfo) report(ctx context.Context, rw rwer, in <-chan any,
 }
 
 // emit emits a single SysInfoData to all the destinations in To.
-func (y *EmitSysInfo) emit(rw rwer, tpl *template.Template,
-	info node.SysInfoData) (err error) {
+func (y *EmitSysInfo) emit(ctx context.Context, rw rwer,
+	tpl *template.Template, info node.SysInfoData) (err error) {
 	var ww []io.WriteCloser
 	defer func() {
 		for _, w := range ww {
@@ -64,6 +64,6 @@ func (y *EmitSysInfo) emit(rw rwer, tpl *template.Template,
 		}
 		ww = append(ww, rw.Writer(s))
 	}
-	err = tpl.Execute(multiWriteCloser(ww...), info)
+	err = tpl.Execute(multiWriteCloser(ctx, ww...), info)
 	return
 }
@@ -0,0 +1,146 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"context"
+
+	"github.com/heistp/antler/node"
+)
+
+// FilterTag is a reporter that runs a pipeline of Reports on the data items
+// for Flows with matching Tags, so results may be sliced by tag (e.g.
+// foreground vs background traffic). All data items are forwarded to the next
+// stage unchanged. Files written by the Reports are prefixed with Prefix.
+//
+// StreamInfo, StreamIO, TCPInfo, PacketInfo and PacketIO items are passed to
+// Report if their Tags match Tag. Items from a PacketServer have no Tags, so
+// are passed if the Tags from the client for the same Flow match. All other
+// items, such as logs and errors, are passed to Report.
+type FilterTag struct {
+	// Tag lists the Tags that data items must have to be passed to Report.
+	Tag node.Tags
+
+	// Prefix is prepended to the names of files written by Report.
+	Prefix string
+
+	// Report is the pipeline of Reports run on the matching data items.
+	Report Report
+}
+
+// setTheme implements themer
+func (f *FilterTag) setTheme(t Theme) {
+	f.Report.setTheme(t)
+}
+
+// validate returns an error if the FilterTag is invalid.
+func (f *FilterTag) validate() error {
+	return f.Report.validate()
+}
+
+// report implements reporter
+func (f *FilterTag) report(ctx context.Context, rw rwer, in <-chan any,
+	out chan<- any) (err error) {
+	fin := make(chan any, dataChanLen(ctx))
+	ec := f.Report.report().pipeline(ctx, prefixRW{rw, f.Prefix}, fin, nil)
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		for e := range ec {
+			if err == nil {
+				err = e
+			}
+		}
+		done <- err
+	}()
+	defer func() {
+		close(fin)
+		if e := <-done; e != nil && err == nil {
+			err = e
+		}
+	}()
+	s := newTagSelect(f.Tag)
+	for d := range in {
+		out <- d
+		for _, a := range s.add(d) {
+			fin <- a
+		}
+	}
+	for _, a := range s.flush() {
+		fin <- a
+	}
+	return
+}
+
+// tagSelect selects data items with matching Tags. Items from a PacketServer
+// are held until the Tags for their Flow are known from the client.
+type tagSelect struct {
+	tag     node.Tags
+	match   map[node.Flow]bool
+	pending map[node.Flow][]any
+}
+
+// newTagSelect returns a new tagSelect for the given Tags.
+func newTagSelect(tag node.Tags) *tagSelect {
+	return &tagSelect{
+		tag,
+		make(map[node.Flow]bool),
+		make(map[node.Flow][]any),
+	}
+}
+
+// add adds a data item, and returns the items that are selected as a result.
+func (s *tagSelect) add(a any) (sel []any) {
+	var f node.Flow
+	var t node.Tags
+	var known bool
+	switch v := a.(type) {
+	case node.StreamInfo:
+		f, t, known = v.Flow, v.Tags, true
+	case node.StreamIO:
+		f, t, known = v.Flow, v.Tags, true
+	case node.TCPInfo:
+		f, t, known = v.Flow, v.Tags, true
+	case node.PacketInfo:
+		f, t, known = v.Flow, v.Tags, !v.Server
+	case node.PacketIO:
+		f, t, known = v.Flow, v.Tags, !v.Server
+	default:
+		sel = append(sel, a)
+		return
+	}
+	if !known {
+		m, ok := s.match[f]
+		if !ok {
+			s.pending[f] = append(s.pending[f], a)
+		} else if m {
+			sel = append(sel, a)
+		}
+		return
+	}
+	m := t.Match(s.tag)
+	if _, ok := s.match[f]; !ok {
+		s.match[f] = m
+		if m {
+			sel = append(sel, s.pending[f]...)
+		}
+		delete(s.pending, f)
+	}
+	if m {
+		sel = append(sel, a)
+	}
+	return
+}
+
+// flush returns any held items that are selected, for Flows with no items
+// from the client. These are selected only if Tag is empty.
+func (s *tagSelect) flush() (sel []any) {
+	if !node.Tags(nil).Match(s.tag) {
+		return
+	}
+	for _, p := range s.pending {
+		sel = append(sel, p...)
+	}
+	return
+}
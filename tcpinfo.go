@@ -0,0 +1,320 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+	"maps"
+	"time"
+
+	"github.com/heistp/antler/node"
+	"github.com/heistp/antler/node/metric"
+)
+
+// tcpInfoTemplate is the template for the ChartsTCPInfo reporter.
+//
+//go:embed tcpinfo.html.tmpl
+var tcpInfoTemplate string
+
+// ChartsTCPInfo is a reporter that plots the TCPInfo sampled from the sender
+// of each stream using Google Charts, for analysis of congestion control
+// behavior. There are charts for cwnd, RTT, delivery and pacing rates,
+// retransmissions, CE marks, the send window and the time limited by the
+// receive window and send buffer, followed by a summary table.
+type ChartsTCPInfo struct {
+	// FlowLabel sets custom labels for Flows.
+	FlowLabel map[node.Flow]string
+
+	// FlowLabelMeta, if true, appends a summary of each Flow's metadata to
+	// its label, for Flows without a label in FlowLabel.
+	FlowLabelMeta bool
+
+	// To lists the names of files to execute the template to. A file of "-"
+	// emits to stdout.
+	To []string
+
+	// Title is the title of the report.
+	Title string
+
+	// MaxPoints is the maximum number of points plotted for each series, or
+	// all points if zero.
+	MaxPoints int
+
+	// DataTable, if true, includes a collapsible table of the plotted data in
+	// the HTML, and a button to download it as CSV.
+	DataTable bool
+
+	// Options is an arbitrary structure of Charts options applied to each
+	// chart, with defaults defined in config.cue.
+	// https://developers.google.com/chart/interactive/docs/gallery/linechart#configuration-options
+	Options map[string]any
+
+	// theme is the Theme set from the Config.
+	theme Theme
+}
+
+// tcpInfoTemplateData contains the data for tcpInfoTemplate execution.
+type tcpInfoTemplateData struct {
+	Title     string
+	Chart     []chartsPanel
+	Stream    []tcpInfoStream
+	DataTable bool
+}
+
+// tcpInfoStream contains summary statistics from the last TCPInfo sample of a
+// stream's sender.
+type tcpInfoStream struct {
+	Flow          node.Flow
+	Meta          FlowMeta
+	Samples       int
+	MinRTT        float64 // min RTT (ms)
+	BytesAcked    metric.Bytes
+	BytesRetrans  metric.Bytes
+	RetransPct    float64
+	Delivered     int
+	DeliveredCE   int
+	CEPct         float64
+	BusyTime      time.Duration
+	RwndLimited   float64
+	SndbufLimited float64
+}
+
+// tcpInfoSeries defines one series plotted for each stream. Either value
+// returns the value for a sample, or false if there is none, or ratio returns
+// a numerator and denominator, and the change in the numerator is plotted as a
+// percent of the change in the denominator, between plotted samples.
+type tcpInfoSeries struct {
+	suffix string
+	dash   []int
+	value  func(t node.TCPInfo) (float64, bool)
+	ratio  func(t node.TCPInfo) (n, d float64)
+}
+
+// at returns the value for the TCPInfo at index i, given the stride n between
+// plotted samples, or false if there is no value to plot.
+func (e tcpInfoSeries) at(info []node.TCPInfo, i, n int) (float64, bool) {
+	if e.ratio == nil {
+		return e.value(info[i])
+	}
+	if i < n {
+		return 0, false
+	}
+	n0, d0 := e.ratio(info[i-n])
+	n1, d1 := e.ratio(info[i])
+	return tcpInfoPct(n1-n0, d1-d0)
+}
+
+// setTheme implements themer
+func (g *ChartsTCPInfo) setTheme(t Theme) {
+	g.theme = t
+}
+
+// report implements reporter
+func (g *ChartsTCPInfo) report(ctx context.Context, rw rwer, in <-chan any,
+	out chan<- any) (err error) {
+	var t *template.Template
+	if t, err = newStyleTemplate(g.theme); err != nil {
+		return
+	}
+	t = t.New("ChartsTCPInfo")
+	var f *flowRegistry
+	t = t.Funcs(template.FuncMap{
+		"flowLabel": func(flow node.Flow) string {
+			return f.Label(flow, g.FlowLabel, g.FlowLabelMeta)
+		},
+		"anchor": flowAnchor,
+	})
+	if t, err = t.Parse(tcpInfoTemplate); err != nil {
+		return
+	}
+	var a analysis
+	for d := range in {
+		out <- d
+		switch v := d.(type) {
+		case analysis:
+			a = v
+		}
+	}
+	if f = a.flows; f == nil {
+		f = newFlowRegistry(nil, g.theme.palette())
+	}
+	td := g.data(f, a.streamList())
+	var ww []io.WriteCloser
+	for _, to := range g.To {
+		ww = append(ww, rw.Writer(to))
+	}
+	defer func() {
+		for _, w := range ww {
+			if e := w.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	}()
+	err = t.Execute(multiWriteCloser(ctx, ww...), td)
+	return
+}
+
+// data returns the template data.
+func (g *ChartsTCPInfo) data(reg *flowRegistry,
+	san []StreamAnalysis) (td tcpInfoTemplateData) {
+	td.Title = g.Title
+	td.DataTable = g.DataTable
+	var sa []StreamAnalysis
+	for _, s := range san {
+		if len(s.TCPInfo) == 0 {
+			continue
+		}
+		sa = append(sa, s)
+		td.Stream = append(td.Stream, newTCPInfoStream(s))
+	}
+	if len(sa) == 0 {
+		return
+	}
+	cwnd := func(t node.TCPInfo) (float64, bool) {
+		return float64(t.SendCwnd), true
+	}
+	rtt := func(t node.TCPInfo) (float64, bool) {
+		return tcpInfoMs(t.RTT), true
+	}
+	minRTT := func(t node.TCPInfo) (float64, bool) {
+		return tcpInfoMs(t.MinRTT), t.MinRTT > 0
+	}
+	delivery := func(t node.TCPInfo) (float64, bool) {
+		return t.DeliveryRate.Mbps(), true
+	}
+	pacing := func(t node.TCPInfo) (float64, bool) {
+		return t.PacingRate.Mbps(), true
+	}
+	retrans := func(t node.TCPInfo) (n, d float64) {
+		return float64(t.BytesRetrans), float64(t.BytesAcked)
+	}
+	ce := func(t node.TCPInfo) (n, d float64) {
+		return float64(t.DeliveredCE), float64(t.Delivered)
+	}
+	sndwnd := func(t node.TCPInfo) (float64, bool) {
+		return float64(t.SendWnd) / 1000.0, t.SendWnd > 0
+	}
+	rwnd := func(t node.TCPInfo) (n, d float64) {
+		return float64(t.RwndLimited), float64(t.BusyTime)
+	}
+	sndbuf := func(t node.TCPInfo) (n, d float64) {
+		return float64(t.SndbufLimited), float64(t.BusyTime)
+	}
+	td.Chart = append(td.Chart,
+		g.chart(reg, sa, "cwnd", "Congestion Window", "cwnd (packets)",
+			tcpInfoSeries{value: cwnd}),
+		g.chart(reg, sa, "rtt", "Round-Trip Time", "RTT (ms)",
+			tcpInfoSeries{suffix: "RTT", value: rtt},
+			tcpInfoSeries{suffix: "min RTT", dash: dashRTT, value: minRTT}),
+		g.chart(reg, sa, "rate", "Delivery and Pacing Rates", "Mbps",
+			tcpInfoSeries{suffix: "delivery", value: delivery},
+			tcpInfoSeries{suffix: "pacing", dash: dashDeliveryRate,
+				value: pacing}),
+		g.chart(reg, sa, "retrans", "Retransmissions",
+			"Retransmitted (% of bytes acked)",
+			tcpInfoSeries{ratio: retrans}),
+		g.chart(reg, sa, "ce", "CE Marks", "CE marked (% of delivered)",
+			tcpInfoSeries{ratio: ce}),
+		g.chart(reg, sa, "sndwnd", "Send Window", "Send window (KB)",
+			tcpInfoSeries{value: sndwnd}),
+		g.chart(reg, sa, "limited", "Sender Limited",
+			"Limited (% of busy time)",
+			tcpInfoSeries{suffix: "rwnd", ratio: rwnd},
+			tcpInfoSeries{suffix: "sndbuf", dash: dashDeliveryRate,
+				ratio: sndbuf}),
+	)
+	return
+}
+
+// chart returns a chart of the given series for each of the given streams.
+func (g *ChartsTCPInfo) chart(reg *flowRegistry, san []StreamAnalysis, id,
+	title, vtitle string, series ...tcpInfoSeries) (c chartsPanel) {
+	c.ID = id
+	var cols chartsColumns
+	c.Data.set(0, 0, "Time (sec)")
+	col := 1
+	row := 1
+	for _, s := range san {
+		l := reg.Label(s.Flow, g.FlowLabel, g.FlowLabelMeta)
+		n := chartsStride(len(s.TCPInfo), g.MaxPoints)
+		for _, e := range series {
+			cols.add(s.Flow, e.dash)
+			if e.suffix != "" {
+				c.Data.set(0, col, fmt.Sprintf("%s %s", l, e.suffix))
+			} else {
+				c.Data.set(0, col, l)
+			}
+			for i := 0; i < len(s.TCPInfo); i += n {
+				t := s.TCPInfo[i]
+				if v, ok := e.at(s.TCPInfo, i, n); ok {
+					c.Data.set(row, 0, t.T.Duration().Seconds())
+					c.Data.set(row, col, v)
+					row++
+				}
+			}
+			col++
+		}
+	}
+	c.Data.normalize()
+	c.Options = g.options(cols, reg, title, vtitle)
+	return
+}
+
+// options returns the Charts options for one chart, with the given title and
+// vertical axis title.
+func (g *ChartsTCPInfo) options(cols chartsColumns, reg *flowRegistry,
+	title, vtitle string) (opt map[string]any) {
+	opt = cols.options(g.Options, reg)
+	opt["title"] = title
+	opt["interpolateNulls"] = true
+	v := make(map[string]any)
+	if a, ok := opt["vAxis"].(map[string]any); ok {
+		v = maps.Clone(a)
+	}
+	v["title"] = vtitle
+	opt["vAxis"] = v
+	return
+}
+
+// newTCPInfoStream returns the summary statistics for the given stream, which
+// must have at least one TCPInfo sample.
+func newTCPInfoStream(s StreamAnalysis) (t tcpInfoStream) {
+	l := s.TCPInfo[len(s.TCPInfo)-1]
+	t = tcpInfoStream{
+		Flow:         s.Flow,
+		Meta:         s.Meta,
+		Samples:      len(s.TCPInfo),
+		MinRTT:       tcpInfoMs(l.MinRTT),
+		BytesAcked:   l.BytesAcked,
+		BytesRetrans: l.BytesRetrans,
+		Delivered:    l.Delivered,
+		DeliveredCE:  l.DeliveredCE,
+		BusyTime:     l.BusyTime,
+	}
+	t.RetransPct, _ = tcpInfoPct(float64(l.BytesRetrans),
+		float64(l.BytesAcked))
+	t.CEPct, _ = tcpInfoPct(float64(l.DeliveredCE), float64(l.Delivered))
+	t.RwndLimited, _ = tcpInfoPct(float64(l.RwndLimited),
+		float64(l.BusyTime))
+	t.SndbufLimited, _ = tcpInfoPct(float64(l.SndbufLimited),
+		float64(l.BusyTime))
+	return
+}
+
+// tcpInfoPct returns n as a percent of d, or false if d is not positive.
+func tcpInfoPct(n, d float64) (float64, bool) {
+	if d <= 0 {
+		return 0, false
+	}
+	return 100.0 * n / d, true
+}
+
+// tcpInfoMs returns the given Duration in milliseconds.
+func tcpInfoMs(d time.Duration) float64 {
+	return d.Seconds() * 1000.0
+}
@@ -4,8 +4,9 @@
 package antler
 
 import (
+	"cmp"
+	"context"
 	"crypto/rand"
-	"encoding/gob"
 	"fmt"
 	"html/template"
 	"io"
@@ -31,6 +32,13 @@ type Test struct {
 	// empty, raw result data is not saved for the Test.
 	DataFile string
 
+	// DataSegment, if not nil, configures the raw result data to be written in
+	// segments, with DataFile as the index.
+	DataSegment *DataSegment
+
+	// DataFormat is the format of the raw result data.
+	DataFormat DataFormat
+
 	// HMAC, if true, indicates that all nodes participating in this Test use
 	// HMAC signing, to protect the servers from unauthorized use.
 	HMAC bool
@@ -38,9 +46,33 @@ type Test struct {
 	// Run is the top-level Run instance.
 	node.Run
 
+	// IdleProbe, if not nil, wraps Run with idle latency probe phases.
+	IdleProbe *node.IdleProbe
+
 	// Timeout is the maximum amount of time the Test can run for.
 	Timeout metric.Duration
 
+	// MemoryLimit, if not zero, is the maximum live heap size while reporters
+	// buffer data for the Test. See config.cue for how it's enforced.
+	MemoryLimit metric.Bytes
+
+	// Log limits the log entries sent by each node, so that excessive logging
+	// can't stall the streaming of results.
+	Log node.LogLimit
+
+	// Overflow limits the messages each node queues for sending to its
+	// parent, and selects what happens when the queue is full.
+	Overflow node.Overflow
+
+	// Diagnose configures the diagnostic commands each node runs if the Test
+	// fails.
+	Diagnose node.Diagnose
+
+	// Lock configures the lock each node takes on its host, to guard against
+	// concurrent runs from other controllers. If its Path is empty, no lock is
+	// taken.
+	Lock node.Lock
+
 	// DuringDefault is the first part of a pipeline of Reports run while the
 	// Test runs.
 	DuringDefault Report
@@ -56,6 +88,59 @@ type Test struct {
 	// After is the latter part of a pipeline of Reports run while the Test
 	// Runs.
 	After Report
+
+	// Expect lists conditions on the Test's Metrics that are evaluated after
+	// the After reports. See config.cue for details.
+	Expect []MetricCond
+
+	// Assert lists composable predicates on the Test's results, which are
+	// evaluated along with Expect.
+	Assert []Assert
+
+	// ExpectFail, if true, fails the run with ExitExpect if any of the Expect
+	// or Assert conditions are not met.
+	ExpectFail bool
+
+	// Minimal, if not nil, enables minimal results mode, in which only
+	// summaries and downsampled series are saved.
+	Minimal *Minimal
+
+	// Window, if not nil, is the wall-clock time window the Test may run in.
+	Window *Window
+
+	// Export lists the keys of the Feedback returned by the Test's Run to store
+	// in the run's Feedback store, for Tests that Import them.
+	Export []string
+
+	// Import lists the keys of the Feedback to load from the run's Feedback
+	// store and pass to the Test's Run. Each must be Exported by an earlier
+	// Test.
+	Import []string
+
+	// Priority orders the Tests in a run, with Tests having a lower Priority
+	// run first. Tests with the same Priority are ordered according to the
+	// Config's Order.
+	Priority int
+
+	// Iterations is the number of times to run the Test. If greater than one,
+	// the Test is replaced by that many iterations when the config is loaded
+	// (see expandIterations).
+	Iterations int
+
+	// DependsOn lists the IDs of Tests that must complete successfully in the
+	// same run before this Test may run. Tests are reordered so that they run
+	// after their dependencies, and a Test is skipped if any of its
+	// dependencies were not run. Tests linked from a prior result count as
+	// completed.
+	DependsOn []TestID
+
+	// flowName maps the Flows generated from Flow templates to their full
+	// names, before they were shortened.
+	flowName map[node.Flow]string
+
+	// iteration is set for each iteration of a Test with Iterations greater
+	// than one.
+	iteration *iteration
 }
 
 // TestID represents a compound Test identifier. Keys and values must match the
@@ -208,20 +293,11 @@ func (t *Test) DataHasError(rw resultRW) (hasError bool, err error) {
 			err = e
 		}
 	}()
-	c := gob.NewDecoder(r)
-	for {
-		var a any
-		if err = c.Decode(&a); err != nil {
-			if err == io.EOF {
-				err = nil
-			}
-			return
-		}
-		if _, ok := a.(error); ok {
-			hasError = true
-			return
-		}
-	}
+	err = decodeData(rw, r, func(a any) bool {
+		_, hasError = a.(error)
+		return !hasError
+	})
+	return
 }
 
 // RW returns a child resultRW for reading and writing this Test's results.
@@ -230,7 +306,7 @@ func (t *Test) RW(work resultRW) resultRW {
 }
 
 // LinkPriorData creates hard links to the most recent result data for this
-// Test. DataFile is linked, along with any FileRefs it contains.
+// Test. DataFile is linked, along with any segments and FileRefs it contains.
 //
 // If DataFile is empty, DataFileUnsetError is returned.
 //
@@ -252,25 +328,42 @@ func (t *Test) LinkPriorData(rw resultRW) (err error) {
 			err = e
 		}
 	}()
-	c := gob.NewDecoder(r)
-	for {
-		var a any
-		if err = c.Decode(&a); err != nil {
-			if err == io.EOF {
-				err = nil
-				break
-			}
-			return
-		}
-		if l, k := a.(FileRef); k {
-			if err = rw.Link(l.Name); err != nil {
-				return
-			}
+	var e error
+	err = decodeData(rw, r, func(a any) bool {
+		switch v := a.(type) {
+		case SegmentRef:
+			e = rw.Link(v.Name)
+		case FileRef:
+			e = rw.Link(v.Name)
 		}
+		return e == nil
+	})
+	if err == nil {
+		err = e
 	}
 	return
 }
 
+// hasExpect returns true if the Test has any Expect or Assert conditions.
+func (t *Test) hasExpect() bool {
+	return len(t.Expect) > 0 || len(t.Assert) > 0
+}
+
+// testKey is the Context key for the Test.
+type testKey struct{}
+
+// withTest returns a Context that carries the Test, for use by reporters that
+// need information about the Test they're reporting on.
+func withTest(ctx context.Context, test *Test) context.Context {
+	return context.WithValue(ctx, testKey{}, test)
+}
+
+// contextTest returns the Test carried in the Context, or nil if there is none.
+func contextTest(ctx context.Context) *Test {
+	t, _ := ctx.Value(testKey{}).(*Test)
+	return t
+}
+
 // Tests wraps a list of Tests to add functionality.
 type Tests []Test
 
@@ -279,6 +372,18 @@ func (s Tests) validate() (err error) {
 	if err = s.validateTestIDs(); err != nil {
 		return
 	}
+	if err = s.orderDependencies(); err != nil {
+		return
+	}
+	if err = s.expandWorkloads(); err != nil {
+		return
+	}
+	if err = s.expandIdleProbes(); err != nil {
+		return
+	}
+	if err = s.expandFlowNames(); err != nil {
+		return
+	}
 	if err = s.generatePaths(); err != nil {
 		return
 	}
@@ -294,6 +399,21 @@ func (s Tests) validate() (err error) {
 	if err = s.validateReports(); err != nil {
 		return
 	}
+	if err = s.validateDataSegments(); err != nil {
+		return
+	}
+	if err = s.validateAsserts(); err != nil {
+		return
+	}
+	if err = s.validateWindows(); err != nil {
+		return
+	}
+	if err = s.validateMinimal(); err != nil {
+		return
+	}
+	if err = s.validateFeedback(); err != nil {
+		return
+	}
 	return
 }
 
@@ -333,6 +453,117 @@ func (d DuplicateTestIDError) Error() string {
 	return fmt.Sprintf("duplicate Test IDs: %s", strings.Join(s, ", "))
 }
 
+// Test orders.
+const (
+	// orderConfig keeps Tests with the same Priority in the order they're
+	// listed in the config.
+	orderConfig = "config"
+
+	// orderID sorts Tests with the same Priority by their IDs, as returned by
+	// TestID.String.
+	orderID = "id"
+)
+
+// sort stably sorts the Tests by Priority, then according to the given order,
+// which is orderConfig or orderID. Dependencies are ordered afterwards, by
+// orderDependencies.
+func (s Tests) sort(order string) (err error) {
+	var c func(a, b Test) int
+	switch order {
+	case "", orderConfig:
+		c = func(a, b Test) int {
+			return cmp.Compare(a.Priority, b.Priority)
+		}
+	case orderID:
+		c = func(a, b Test) int {
+			if n := cmp.Compare(a.Priority, b.Priority); n != 0 {
+				return n
+			}
+			return strings.Compare(a.ID.String(), b.ID.String())
+		}
+	default:
+		err = fmt.Errorf("unknown test order: %s", order)
+		return
+	}
+	slices.SortStableFunc(s, c)
+	return
+}
+
+// orderDependencies reorders the Tests so that each runs after the Tests it
+// DependsOn, otherwise keeping the Tests in their original order. An error is
+// returned if any dependencies are not found, or form a cycle.
+func (s Tests) orderDependencies() (err error) {
+	index := func(id TestID) int {
+		return slices.IndexFunc(s, func(t Test) bool {
+			return t.ID.Equal(id)
+		})
+	}
+	dep := make([][]int, len(s))
+	var has bool
+	for i, t := range s {
+		for _, d := range t.DependsOn {
+			j := index(d)
+			if j < 0 {
+				err = fmt.Errorf("test %s depends on unknown test %s", t.ID, d)
+				return
+			}
+			if j == i {
+				err = fmt.Errorf("test %s depends on itself", t.ID)
+				return
+			}
+			dep[i] = append(dep[i], j)
+			has = true
+		}
+	}
+	if !has {
+		return
+	}
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(s))
+	var order, path []int
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case visited:
+			return nil
+		case visiting:
+			var c []string
+			for _, j := range path[slices.Index(path, i):] {
+				c = append(c, s[j].ID.String())
+			}
+			c = append(c, s[i].ID.String())
+			return fmt.Errorf("test dependency cycle: %s",
+				strings.Join(c, " -> "))
+		}
+		state[i] = visiting
+		path = append(path, i)
+		for _, j := range dep[i] {
+			if e := visit(j); e != nil {
+				return e
+			}
+		}
+		path = path[:len(path)-1]
+		state[i] = visited
+		order = append(order, i)
+		return nil
+	}
+	for i := range s {
+		if err = visit(i); err != nil {
+			return
+		}
+	}
+	o := make([]Test, len(s))
+	for i, j := range order {
+		o[i] = s[j]
+	}
+	copy(s, o)
+	return
+}
+
 // generatePaths expands any Path fields that use Go templates, and returns an
 // error if any Paths are duplicated.
 func (s Tests) generatePaths() (err error) {
@@ -417,6 +648,48 @@ func (a AmbiguousNodeIDError) Error() string {
 		a.TestID, strings.Join(s, ", "))
 }
 
+// expandWorkloads replaces any Workloads in the Tests' Runs with their
+// generated runners.
+func (s Tests) expandWorkloads() (err error) {
+	for i := range s {
+		t := &s[i]
+		if err = node.ExpandWorkloads(&t.Run); err != nil {
+			err = fmt.Errorf("test %s: %w", t.ID, err)
+			return
+		}
+	}
+	return
+}
+
+// expandIdleProbes wraps the Runs of any Tests with an IdleProbe with the idle
+// probe phases.
+func (s Tests) expandIdleProbes() (err error) {
+	for i := range s {
+		t := &s[i]
+		if t.IdleProbe == nil {
+			continue
+		}
+		if t.Run, err = t.IdleProbe.Wrap(t.Run); err != nil {
+			err = fmt.Errorf("test %s: %w", t.ID, err)
+			return
+		}
+	}
+	return
+}
+
+// expandFlowNames executes any Flow templates in the Tests, and records the
+// full names of the generated Flows.
+func (s Tests) expandFlowNames() (err error) {
+	for i := range s {
+		t := &s[i]
+		if t.flowName, err = node.ExpandFlowNames(&t.Run, t.ID); err != nil {
+			err = fmt.Errorf("test %s: %w", t.ID, err)
+			return
+		}
+	}
+	return
+}
+
 // setKeys generates and sets a Test-specific security key on any SetKeyers, for
 // Tests that have HMAC protection enabled.
 func (s Tests) setKeys() (err error) {
@@ -440,6 +713,80 @@ func (s Tests) validateRuns() (err error) {
 	return
 }
 
+// validateDataSegments returns an error if any DataSegment fields are invalid.
+func (s Tests) validateDataSegments() (err error) {
+	for _, t := range s {
+		if t.DataSegment == nil {
+			continue
+		}
+		if err = t.DataSegment.validate(); err != nil {
+			err = fmt.Errorf("test %s: %w", t.ID, err)
+			return
+		}
+	}
+	return
+}
+
+// validateAsserts returns an error if any of the Assert fields are invalid.
+func (s Tests) validateAsserts() (err error) {
+	for _, t := range s {
+		for _, a := range t.Assert {
+			if err = a.validate(); err != nil {
+				err = fmt.Errorf("test %s: %w", t.ID, err)
+				return
+			}
+		}
+	}
+	return
+}
+
+// validateWindows returns an error if any Windows are invalid.
+func (s Tests) validateWindows() (err error) {
+	for _, t := range s {
+		if t.Window == nil {
+			continue
+		}
+		if err = t.Window.validate(); err != nil {
+			err = fmt.Errorf("test %s: %w", t.ID, err)
+			return
+		}
+	}
+	return
+}
+
+// validateMinimal returns an error if any Minimal configs are invalid.
+func (s Tests) validateMinimal() (err error) {
+	for _, t := range s {
+		if t.Minimal == nil {
+			continue
+		}
+		if err = t.Minimal.validate(); err != nil {
+			err = fmt.Errorf("test %s: %w", t.ID, err)
+			return
+		}
+	}
+	return
+}
+
+// validateFeedback returns an error if any Test Imports a Feedback key that's
+// not Exported by an earlier Test.
+func (s Tests) validateFeedback() (err error) {
+	x := make(map[string]bool)
+	for _, t := range s {
+		for _, k := range t.Import {
+			if !x[k] {
+				err = fmt.Errorf("test %s imports feedback key '%s', which "+
+					"is not exported by an earlier test", t.ID, k)
+				return
+			}
+		}
+		for _, k := range t.Export {
+			x[k] = true
+		}
+	}
+	return
+}
+
 // validateReports returns an error if any of the Report fields are invalid.
 func (s Tests) validateReports() (err error) {
 	for _, t := range s {
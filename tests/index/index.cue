@@ -35,6 +35,14 @@ MultiReport: [
 			GroupBy: "A"
 		}
 	},
+	{
+		Index: {
+			Title:      "All Tests, Group By Field A, Then B"
+			To:         "index_nested.html"
+			GroupBy:    "A"
+			SubGroupBy: ["B"]
+		}
+	},
 	{
 		ID: {A: "W"}
 		Index: {
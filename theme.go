@@ -0,0 +1,112 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"fmt"
+	"html/template"
+)
+
+// Theme configures the appearance of HTML reports.
+type Theme struct {
+	// Mode selects light or dark colors. "auto" follows the viewer's
+	// prefers-color-scheme setting, while "light" and "dark" force the mode.
+	Mode string
+
+	// Palette is the name of the palette used to assign colors to Flows. It
+	// is ignored if Colors is set.
+	Palette string
+
+	// Colors is a custom list of colors used to assign colors to Flows.
+	Colors []string
+}
+
+// palettes contains the named palettes that may be selected by Theme.Palette.
+var palettes = map[string][]string{
+	// Google Charts default palette
+	"default": flowPalette,
+	// Okabe-Ito colorblind-safe palette, without black
+	"okabe-ito": {
+		"#e69f00",
+		"#56b4e9",
+		"#009e73",
+		"#f0e442",
+		"#0072b2",
+		"#d55e00",
+		"#cc79a7",
+	},
+	// Paul Tol's colorblind-safe bright palette
+	"tol-bright": {
+		"#4477aa",
+		"#ee6677",
+		"#228833",
+		"#ccbb44",
+		"#66ccee",
+		"#aa3377",
+		"#bbbbbb",
+	},
+	// Paul Tol's colorblind-safe muted palette
+	"tol-muted": {
+		"#cc6677",
+		"#332288",
+		"#ddcc77",
+		"#117733",
+		"#88ccee",
+		"#882255",
+		"#44aa99",
+		"#999933",
+		"#aa4499",
+	},
+}
+
+// palette returns the colors used to assign colors to Flows.
+func (t Theme) palette() []string {
+	if len(t.Colors) > 0 {
+		return t.Colors
+	}
+	if p, ok := palettes[t.Palette]; ok {
+		return p
+	}
+	return flowPalette
+}
+
+// paletteColor returns the color at index i in palette, wrapping around if the
+// index exceeds the palette length. If palette is empty, flowPalette is used.
+func paletteColor(palette []string, i int) string {
+	if len(palette) == 0 {
+		palette = flowPalette
+	}
+	return palette[i%len(palette)]
+}
+
+// validate returns an error if the Theme is invalid.
+func (t Theme) validate() (err error) {
+	switch t.Mode {
+	case "", "auto", "light", "dark":
+	default:
+		err = fmt.Errorf("unknown Theme Mode: '%s'", t.Mode)
+		return
+	}
+	if _, ok := palettes[t.Palette]; !ok && t.Palette != "" {
+		err = fmt.Errorf("unknown Theme Palette: '%s'", t.Palette)
+	}
+	return
+}
+
+// themer is implemented by reporters that generate themed HTML.
+type themer interface {
+	setTheme(Theme)
+}
+
+// newStyleTemplate returns a new template containing the Style template, with
+// a "theme" function that returns the given Theme.
+func newStyleTemplate(theme Theme) (t *template.Template, err error) {
+	t = template.New("Style").Funcs(template.FuncMap{
+		"theme": func() Theme {
+			return theme
+		},
+	})
+	t, err = t.Parse(styleTemplate)
+	return
+}
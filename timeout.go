@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/heistp/antler/node/metric"
+)
+
+// ReporterTimeoutError is returned when a reporter doesn't complete within its
+// Timeout after its input is done.
+type ReporterTimeoutError struct {
+	Stage    int
+	Reporter string
+	Timeout  metric.Duration
+}
+
+// Error implements error
+func (r ReporterTimeoutError) Error() string {
+	return fmt.Sprintf("report stage %d (%s) did not complete within %s "+
+		"after its input was done", r.Stage, r.Reporter, r.Timeout)
+}
+
+// deadline is a reporter that runs another reporter, and returns a
+// ReporterTimeoutError if it doesn't complete within timeout after its input is
+// done. The Context passed to the reporter is canceled with the error as its
+// cause, and if the reporter doesn't return, its output is discarded so the
+// rest of the pipeline can complete.
+type deadline struct {
+	reporter
+	stage   int
+	timeout metric.Duration
+}
+
+// report implements reporter
+func (d *deadline) report(ctx context.Context, rw rwer, in <-chan any,
+	out chan<- any) (err error) {
+	ctx, cxl := context.WithCancelCause(ctx)
+	defer cxl(nil)
+	din := make(chan any, dataChanLen(ctx))
+	dout := make(chan any, dataChanLen(ctx))
+	ec := report{d.reporter}.pipeline(ctx, rw, din, dout)
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		for e := range ec {
+			if err == nil {
+				err = e
+			}
+		}
+		done <- err
+	}()
+	stop := make(chan struct{})
+	fwd := make(chan struct{})
+	go func() {
+		defer close(fwd)
+		for a := range dout {
+			select {
+			case out <- a:
+			case <-stop:
+				go func() {
+					for range dout {
+					}
+				}()
+				return
+			}
+		}
+	}()
+	abort := func(e error) {
+		close(stop)
+		<-fwd
+		err = e
+	}
+	for a := range in {
+		select {
+		case din <- a:
+		case <-ctx.Done():
+			close(din)
+			abort(context.Cause(ctx))
+			return
+		}
+	}
+	close(din)
+	t := time.NewTimer(d.timeout.Duration())
+	defer t.Stop()
+	select {
+	case err = <-done:
+		<-fwd
+	case <-t.C:
+		e := ReporterTimeoutError{
+			d.stage,
+			strings.TrimPrefix(fmt.Sprintf("%T", d.reporter), "*"),
+			d.timeout,
+		}
+		cxl(e)
+		abort(e)
+	}
+	return
+}
@@ -0,0 +1,417 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+	"maps"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/heistp/antler/node"
+	"github.com/heistp/antler/node/metric"
+	"gonum.org/v1/gonum/stat"
+)
+
+// tinTemplate is the template for the ChartsTin reporter.
+//
+//go:embed tin.html.tmpl
+var tinTemplate string
+
+// TinAnalysis contains the data and calculated stats for one tin of a cake
+// qdisc, or an fq_codel qdisc, as sampled by a TinStats runner on a node.
+type TinAnalysis struct {
+	// data
+	NodeID    node.ID
+	Interface string
+	Kind      string
+	Handle    string
+	Tin       int
+	Name      string
+	Info      []node.TinInfo
+
+	// statistics
+	Point         []tinPoint
+	ThresholdRate metric.Bitrate // bandwidth threshold, from the last sample
+	AvgDelayMean  float64        // mean of the average delay samples (ms)
+	PeakDelayMax  float64        // maximum peak delay (ms)
+	BacklogMax    metric.Bytes   // maximum backlog
+	Bytes         metric.Bytes   // bytes sent during sampling
+	Packets       uint64         // packets sent during sampling
+	Drops         uint64         // drops during sampling
+	Marks         uint64         // ECN marks during sampling
+	AckDrops      uint64         // ACK filter drops during sampling
+	DelayKnown    bool           // true if delays are reported (cake)
+}
+
+// tinPoint contains the stats calculated between two samples.
+type tinPoint struct {
+	T          metric.RelativeTime // time of the later sample
+	Throughput float64             // sent bitrate (Mbps)
+	DropRate   float64             // drops per second
+	MarkRate   float64             // ECN marks per second
+}
+
+// Label returns a label for the tin, with its node ID, interface, qdisc handle
+// and tin name.
+func (t *TinAnalysis) Label() string {
+	return fmt.Sprintf("%s %s %s %s", t.NodeID, t.Interface, t.Handle, t.Name)
+}
+
+// analyze calculates the stats for the tin. The data fields must already have
+// been populated, and synchronized.
+func (t *TinAnalysis) analyze() {
+	if len(t.Info) == 0 {
+		return
+	}
+	var ad []float64
+	for _, i := range t.Info {
+		ad = append(ad, float64(i.AvgDelay)/float64(time.Millisecond))
+		if d := float64(i.PeakDelay) / float64(time.Millisecond); d >
+			t.PeakDelayMax {
+			t.PeakDelayMax = d
+		}
+		if i.Backlog > t.BacklogMax {
+			t.BacklogMax = i.Backlog
+		}
+		t.DelayKnown = t.DelayKnown || i.Kind == "cake"
+	}
+	t.AvgDelayMean = stat.Mean(ad, nil)
+	t.Point = nil
+	for j := 1; j < len(t.Info); j++ {
+		p, i := t.Info[j-1], t.Info[j]
+		if i.Bytes < p.Bytes || i.Drops < p.Drops || i.Marks < p.Marks {
+			// counters were reset, e.g. after the qdisc was replaced
+			continue
+		}
+		d := time.Duration(i.T - p.T)
+		if d <= 0 {
+			continue
+		}
+		s := d.Seconds()
+		t.Point = append(t.Point, tinPoint{
+			i.T,
+			metric.CalcBitrate(i.Bytes-p.Bytes, d).Mbps(),
+			float64(i.Drops-p.Drops) / s,
+			float64(i.Marks-p.Marks) / s,
+		})
+	}
+	f, l := t.Info[0], t.Info[len(t.Info)-1]
+	t.ThresholdRate = l.ThresholdRate
+	if l.Bytes < f.Bytes || l.Packets < f.Packets || l.Drops < f.Drops ||
+		l.Marks < f.Marks || l.AckDrops < f.AckDrops {
+		return
+	}
+	t.Bytes = l.Bytes - f.Bytes
+	t.Packets = l.Packets - f.Packets
+	t.Drops = l.Drops - f.Drops
+	t.Marks = l.Marks - f.Marks
+	t.AckDrops = l.AckDrops - f.AckDrops
+}
+
+// tins aggregates data for multiple qdisc tins.
+type tins map[string]*TinAnalysis
+
+// newTins returns a new tins.
+func newTins() tins {
+	return tins(make(map[string]*TinAnalysis))
+}
+
+// analysis adds TinAnalysis for the tin in the given TinInfo if it doesn't
+// already exist.
+func (m *tins) analysis(info node.TinInfo) (t *TinAnalysis) {
+	k := fmt.Sprintf("%s/%s/%s/%d", info.NodeID, info.Interface, info.Handle,
+		info.Tin)
+	var ok bool
+	if t, ok = (*m)[k]; ok {
+		return
+	}
+	t = &TinAnalysis{
+		NodeID:    info.NodeID,
+		Interface: info.Interface,
+		Kind:      info.Kind,
+		Handle:    info.Handle,
+		Tin:       info.Tin,
+		Name:      info.Name,
+	}
+	(*m)[k] = t
+	return
+}
+
+// StartTime returns the earliest absolute sample time among the tins. This is
+// used as the start time only if there are no flows.
+func (m *tins) StartTime() (start time.Time) {
+	for _, t := range *m {
+		if len(t.Info) == 0 {
+			continue
+		}
+		if s := t.Info[0].Time(); start.IsZero() || s.Before(start) {
+			start = s
+		}
+	}
+	return
+}
+
+// synchronize adjusts the TinInfo RelativeTime values from node-relative to
+// test-relative time. Samples taken before the start time have negative times.
+func (m *tins) synchronize(start time.Time) {
+	for _, t := range *m {
+		for i := 0; i < len(t.Info); i++ {
+			f := &t.Info[i]
+			f.T = metric.RelativeTime(f.Time().Sub(start))
+			f.Tinit = start
+		}
+	}
+}
+
+// analyze uses the collected data to calculate relevant metrics and stats.
+func (m *tins) analyze() {
+	for _, t := range *m {
+		t.analyze()
+	}
+}
+
+// list returns a slice of TinAnalysis, sorted by node ID, interface, handle
+// and tin.
+func (m *tins) list() (t []TinAnalysis) {
+	for _, a := range *m {
+		t = append(t, *a)
+	}
+	sort.Slice(t, func(i, j int) bool {
+		if t[i].NodeID != t[j].NodeID {
+			return t[i].NodeID < t[j].NodeID
+		}
+		if t[i].Interface != t[j].Interface {
+			return t[i].Interface < t[j].Interface
+		}
+		if t[i].Handle != t[j].Handle {
+			return t[i].Handle < t[j].Handle
+		}
+		return t[i].Tin < t[j].Tin
+	})
+	return
+}
+
+// ChartsTin is a reporter that plots the per-tin statistics of cake and
+// fq_codel qdiscs from TinStats runners using Google Charts, with charts for
+// delay, backlog, throughput, and drop and mark rates, and a summary table.
+type ChartsTin struct {
+	// To lists the names of files to execute the template to. A file of "-"
+	// emits to stdout.
+	To []string
+
+	// Title is the title of the report.
+	Title string
+
+	// DataTable, if true, includes a collapsible table of the plotted data in
+	// the HTML, and a button to download it as CSV.
+	DataTable bool
+
+	// Options is an arbitrary structure of Charts options applied to each
+	// chart, with defaults defined in config.cue.
+	// https://developers.google.com/chart/interactive/docs/gallery/linechart#configuration-options
+	Options map[string]any
+
+	// theme is the Theme set from the Config.
+	theme Theme
+}
+
+// tinTemplateData contains the data for tinTemplate execution.
+type tinTemplateData struct {
+	Title     string
+	Chart     []chartsPanel
+	Tin       []TinAnalysis
+	DataTable bool
+}
+
+// setTheme implements themer
+func (g *ChartsTin) setTheme(t Theme) {
+	g.theme = t
+}
+
+// report implements reporter
+func (g *ChartsTin) report(ctx context.Context, rw rwer, in <-chan any,
+	out chan<- any) (err error) {
+	var t *template.Template
+	if t, err = newStyleTemplate(g.theme); err != nil {
+		return
+	}
+	t = t.New("ChartsTin")
+	if t, err = t.Parse(tinTemplate); err != nil {
+		return
+	}
+	var a analysis
+	for d := range in {
+		out <- d
+		switch v := d.(type) {
+		case analysis:
+			a = v
+		}
+	}
+	td := g.data(a.tins.list())
+	var ww []io.WriteCloser
+	for _, to := range g.To {
+		ww = append(ww, rw.Writer(to))
+	}
+	defer func() {
+		for _, w := range ww {
+			if e := w.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	}()
+	err = t.Execute(multiWriteCloser(ctx, ww...), td)
+	return
+}
+
+// data returns the template data.
+func (g *ChartsTin) data(tin []TinAnalysis) (td tinTemplateData) {
+	td.Title = g.Title
+	td.DataTable = g.DataTable
+	td.Tin = tin
+	if len(tin) == 0 {
+		return
+	}
+	var dl []TinAnalysis
+	for _, t := range tin {
+		if t.DelayKnown {
+			dl = append(dl, t)
+		}
+	}
+	if len(dl) > 0 {
+		td.Chart = append(td.Chart, g.delayChart(dl))
+	}
+	td.Chart = append(td.Chart, g.backlogChart(tin),
+		g.pointChart(tin, "throughput", "Throughput", "Mbps",
+			func(p tinPoint) float64 { return p.Throughput }),
+		g.rateChart(tin))
+	return
+}
+
+// delayChart returns a chart of the average and peak delay for each tin.
+func (g *ChartsTin) delayChart(tin []TinAnalysis) (c chartsPanel) {
+	c.ID = "delay"
+	c.Data.set(0, 0, "Time (sec)")
+	row := 1
+	var ss []map[string]any
+	ms := func(d time.Duration) float64 {
+		return float64(d) / float64(time.Millisecond)
+	}
+	for i, t := range tin {
+		col := 2*i + 1
+		c.Data.set(0, col, fmt.Sprintf("%s avg", t.Label()))
+		c.Data.set(0, col+1, fmt.Sprintf("%s peak", t.Label()))
+		for _, f := range t.Info {
+			c.Data.set(row, 0, f.T.Duration().Seconds())
+			c.Data.set(row, col, ms(f.AvgDelay))
+			c.Data.set(row, col+1, ms(f.PeakDelay))
+			row++
+		}
+		l := paletteColor(g.theme.palette(), i)
+		ss = append(ss, map[string]any{"color": l},
+			map[string]any{"color": l, "lineDashStyle": dashDeliveryRate})
+	}
+	c.Data.normalize()
+	c.Options = g.options(ss, "Delay", "Delay (ms)")
+	return
+}
+
+// backlogChart returns a chart of the backlog for each tin.
+func (g *ChartsTin) backlogChart(tin []TinAnalysis) (c chartsPanel) {
+	c.ID = "backlog"
+	c.Data.set(0, 0, "Time (sec)")
+	row := 1
+	var ss []map[string]any
+	for i, t := range tin {
+		c.Data.set(0, i+1, t.Label())
+		for _, f := range t.Info {
+			c.Data.set(row, 0, f.T.Duration().Seconds())
+			c.Data.set(row, i+1, f.Backlog.Kilobytes())
+			row++
+		}
+		ss = append(ss, map[string]any{
+			"color": paletteColor(g.theme.palette(), i),
+		})
+	}
+	c.Data.normalize()
+	c.Options = g.options(ss, "Backlog", "Backlog (kB)")
+	return
+}
+
+// rateChart returns a chart of the drop and mark rates for each tin.
+func (g *ChartsTin) rateChart(tin []TinAnalysis) (c chartsPanel) {
+	c.ID = "drops"
+	c.Data.set(0, 0, "Time (sec)")
+	row := 1
+	var ss []map[string]any
+	for i, t := range tin {
+		col := 2*i + 1
+		c.Data.set(0, col, fmt.Sprintf("%s drops", t.Label()))
+		c.Data.set(0, col+1, fmt.Sprintf("%s marks", t.Label()))
+		for _, p := range t.Point {
+			c.Data.set(row, 0, p.T.Duration().Seconds())
+			c.Data.set(row, col, p.DropRate)
+			c.Data.set(row, col+1, p.MarkRate)
+			row++
+		}
+		l := paletteColor(g.theme.palette(), i)
+		ss = append(ss, map[string]any{"color": l},
+			map[string]any{"color": l, "lineDashStyle": dashDeliveryRate})
+	}
+	c.Data.normalize()
+	c.Options = g.options(ss, "Drops and Marks", "Packets / sec")
+	return
+}
+
+// pointChart returns a chart of a value from the tinPoints.
+func (g *ChartsTin) pointChart(tin []TinAnalysis, id, title, vtitle string,
+	value func(tinPoint) float64) (c chartsPanel) {
+	c.ID = id
+	c.Data.set(0, 0, "Time (sec)")
+	row := 1
+	var ss []map[string]any
+	for i, t := range tin {
+		c.Data.set(0, i+1, t.Label())
+		for _, p := range t.Point {
+			c.Data.set(row, 0, p.T.Duration().Seconds())
+			c.Data.set(row, i+1, value(p))
+			row++
+		}
+		ss = append(ss, map[string]any{
+			"color": paletteColor(g.theme.palette(), i),
+		})
+	}
+	c.Data.normalize()
+	c.Options = g.options(ss, title, vtitle)
+	return
+}
+
+// options returns the Charts options for one chart, with the given series
+// options, title and vertical axis title.
+func (g *ChartsTin) options(series []map[string]any,
+	title, vtitle string) (opt map[string]any) {
+	opt = maps.Clone(g.Options)
+	if opt == nil {
+		opt = make(map[string]any)
+	}
+	ss := make(map[string]any)
+	for i, s := range series {
+		ss[strconv.Itoa(i)] = s
+	}
+	opt["series"] = ss
+	opt["title"] = title
+	opt["interpolateNulls"] = true
+	v := make(map[string]any)
+	if a, ok := opt["vAxis"].(map[string]any); ok {
+		v = maps.Clone(a)
+	}
+	v["title"] = vtitle
+	opt["vAxis"] = v
+	return
+}
@@ -0,0 +1,50 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"context"
+
+	"github.com/heistp/antler/node"
+)
+
+// Tuning configures channel depths and buffer sizes, which may be adjusted for
+// the resources of the hosts. See config.cue for the defaults.
+type Tuning struct {
+	// DataChanLen is the buffer length of the channels that carry data items
+	// between report stages, and from the nodes to the reports.
+	DataChanLen int
+
+	// TransferBufLen is the default BufLen for Transfers and Workloads. It's
+	// applied in config.cue.
+	TransferBufLen int
+
+	// Tuning contains the tuning for the nodes.
+	node.Tuning
+}
+
+// tuningKey is the Context key for the Tuning.
+type tuningKey struct{}
+
+// withTuning returns a Context that carries the given Tuning, for use by
+// pipelines and reporters.
+func withTuning(ctx context.Context, tuning Tuning) context.Context {
+	return context.WithValue(ctx, tuningKey{}, tuning)
+}
+
+// contextTuning returns the Tuning from the Context, or the zero value if not
+// set.
+func contextTuning(ctx context.Context) (t Tuning) {
+	t, _ = ctx.Value(tuningKey{}).(Tuning)
+	return
+}
+
+// dataChanLen returns the buffer length for data channels from the Tuning in
+// the Context, or dataChanBufLen if not set.
+func dataChanLen(ctx context.Context) int {
+	if n := contextTuning(ctx).DataChanLen; n > 0 {
+		return n
+	}
+	return dataChanBufLen
+}
@@ -0,0 +1,211 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/heistp/antler/node/metric"
+)
+
+// windowTimeFormat is the format of Window Start and End times.
+const windowTimeFormat = "15:04"
+
+// Window is a recurring wall-clock time window in which a Test is allowed to
+// run, e.g. only from 02:00 to 06:00 local time for probes on a production
+// network. Before a Test with a Window runs, it's either run if the current
+// time is in the Window, or else it waits for the Window to open or is
+// skipped, according to Outside. The decision is recorded in the Manifest.
+type Window struct {
+	// Start is the time of day the Window opens, in the form "15:04".
+	Start string
+
+	// End is the time of day the Window closes, in the form "15:04". If End
+	// is before Start, the Window ends on the following day. If End equals
+	// Start, the Window lasts all day.
+	End string
+
+	// Day, if not empty, lists the days of the week the Window opens on, by
+	// their three letter abbreviations (e.g. "Mon").
+	Day []string
+
+	// Location is the IANA time zone name for Start and End (e.g.
+	// "Europe/Berlin"). If empty, the local time zone is used.
+	Location string
+
+	// Outside is the action taken when a Test is to run outside its Window,
+	// either "wait" to wait until it opens, or "skip" to skip the Test.
+	Outside string
+
+	// MaxWait, if not zero, is the maximum time to wait for the Window to
+	// open, after which the Test is skipped instead.
+	MaxWait metric.Duration
+}
+
+// Window decision actions.
+const (
+	windowRun  = "run"
+	windowWait = "wait"
+	windowSkip = "skip"
+)
+
+// WindowDecision records the decision made for a Test with a Window when it
+// was about to run.
+type WindowDecision struct {
+	// ID is the Test ID.
+	ID TestID
+
+	// Time is the time the decision was made.
+	Time time.Time
+
+	// Action is "run" if the Test was in its Window, "wait" if it waited for
+	// its Window to open before running, or "skip" if it was skipped.
+	Action string
+
+	// Open is the time the next Window opens, if the Test was outside its
+	// Window.
+	Open time.Time
+}
+
+func (d WindowDecision) String() string {
+	switch d.Action {
+	case windowWait:
+		return fmt.Sprintf("waiting until %s to run %s in its window",
+			d.Open.Format(time.DateTime), d.ID)
+	case windowSkip:
+		return fmt.Sprintf("skipped %s outside its window (next opens %s)",
+			d.ID, d.Open.Format(time.DateTime))
+	}
+	return fmt.Sprintf("%s is in its window", d.ID)
+}
+
+// open returns true if the given time is in the Window. If not, the time the
+// Window next opens is returned.
+func (w Window) open(now time.Time) (in bool, next time.Time, err error) {
+	var l *time.Location
+	if l, err = w.location(); err != nil {
+		return
+	}
+	var s, e time.Time
+	if s, err = time.Parse(windowTimeFormat, w.Start); err != nil {
+		return
+	}
+	if e, err = time.Parse(windowTimeFormat, w.End); err != nil {
+		return
+	}
+	n := e.Sub(s)
+	if n <= 0 {
+		n += 24 * time.Hour
+	}
+	t := now.In(l)
+	y, m, d := t.Date()
+	for i := -1; i <= 8; i++ {
+		o := time.Date(y, m, d+i, s.Hour(), s.Minute(), 0, 0, l)
+		if !w.onDay(o.Weekday()) {
+			continue
+		}
+		if !t.Before(o) && t.Before(o.Add(n)) {
+			in = true
+			return
+		}
+		if o.After(t) {
+			next = o
+			return
+		}
+	}
+	err = fmt.Errorf("window %s-%s never opens", w.Start, w.End)
+	return
+}
+
+// onDay returns true if the Window opens on the given day of the week.
+func (w Window) onDay(day time.Weekday) bool {
+	if len(w.Day) == 0 {
+		return true
+	}
+	for _, d := range w.Day {
+		if strings.EqualFold(d, day.String()[:3]) {
+			return true
+		}
+	}
+	return false
+}
+
+// location returns the Window's Location.
+func (w Window) location() (*time.Location, error) {
+	if w.Location == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(w.Location)
+}
+
+// decide returns the WindowDecision for a Test at the given time.
+func (w Window) decide(test *Test, now time.Time) (d WindowDecision,
+	err error) {
+	d = WindowDecision{ID: test.ID, Time: now}
+	var in bool
+	if in, d.Open, err = w.open(now); err != nil {
+		return
+	}
+	switch {
+	case in:
+		d.Action = windowRun
+	case w.Outside == windowWait && (w.MaxWait == 0 ||
+		d.Open.Sub(now) <= w.MaxWait.Duration()):
+		d.Action = windowWait
+	default:
+		d.Action = windowSkip
+	}
+	return
+}
+
+// wait waits for the Window to open, as given by the WindowDecision. It
+// returns an error if the Context is canceled first.
+func (d WindowDecision) wait(ctx context.Context) (err error) {
+	if d.Action != windowWait {
+		return
+	}
+	t := time.NewTimer(time.Until(d.Open))
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+		err = context.Cause(ctx)
+	}
+	return
+}
+
+// validate returns an error if the Window is invalid.
+func (w Window) validate() (err error) {
+	for _, s := range []string{w.Start, w.End} {
+		if _, err = time.Parse(windowTimeFormat, s); err != nil {
+			err = fmt.Errorf("invalid Window time '%s' (use HH:MM)", s)
+			return
+		}
+	}
+	if _, err = w.location(); err != nil {
+		err = fmt.Errorf("invalid Window Location '%s': %w", w.Location, err)
+		return
+	}
+	for _, d := range w.Day {
+		var ok bool
+		for i := time.Sunday; i <= time.Saturday; i++ {
+			if strings.EqualFold(d, i.String()[:3]) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			err = fmt.Errorf("invalid Window Day '%s' (use e.g. Mon)", d)
+			return
+		}
+	}
+	if w.Outside != windowWait && w.Outside != windowSkip {
+		err = fmt.Errorf("invalid Window Outside '%s' (use wait or skip)",
+			w.Outside)
+	}
+	return
+}
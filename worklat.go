@@ -0,0 +1,135 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// Copyright 2024 Pete Heist
+
+package antler
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/heistp/antler/node"
+	"github.com/heistp/antler/node/metric"
+	"gonum.org/v1/gonum/stat"
+)
+
+// WorkingLatency summarizes the latency under load, or working latency, of a
+// Test. It compares the RTTs of the packet flows (e.g. probes) measured while
+// idle, with those measured while at least one stream flow was active
+// (loaded). Delays are in milliseconds.
+//
+// If the Test has idle probe phases (see node.IdleProbe), the idle RTTs are
+// those of the phase flows, which are tagged with node.IdleTag. Otherwise, the
+// idle RTTs are those measured before any stream flow started, so the packet
+// flows must start before the streams, e.g. by adding a Sleep before the
+// streams start.
+type WorkingLatency struct {
+	// IdleN is the number of idle RTT samples.
+	IdleN int `json:"idle_samples"`
+
+	// Idle is the median idle RTT.
+	Idle float64 `json:"idle_rtt_ms"`
+
+	// LoadedN is the number of loaded RTT samples.
+	LoadedN int `json:"loaded_samples"`
+
+	// LoadedP50 is the median loaded RTT.
+	LoadedP50 float64 `json:"loaded_p50_ms"`
+
+	// LoadedP95 is the 95th percentile loaded RTT.
+	LoadedP95 float64 `json:"loaded_p95_ms"`
+
+	// LoadedP99 is the 99th percentile loaded RTT.
+	LoadedP99 float64 `json:"loaded_p99_ms"`
+
+	// Increase is the one-number summary: the 95th percentile loaded RTT minus
+	// the median idle RTT.
+	Increase float64 `json:"increase_ms"`
+}
+
+// newWorkingLatency returns the WorkingLatency from the synchronized and
+// analyzed streams and packets.
+func newWorkingLatency(s streams, p packets) (w WorkingLatency) {
+	type span struct {
+		t0, t1 metric.RelativeTime
+	}
+	var ss []span
+	for _, a := range s {
+		var x span
+		var ok bool
+		for _, io := range [][]node.StreamIO{a.Sent, a.Rcvd} {
+			if len(io) == 0 {
+				continue
+			}
+			t0, t1 := io[0].T, io[len(io)-1].T
+			if !ok || t0 < x.t0 {
+				x.t0 = t0
+			}
+			if !ok || t1 > x.t1 {
+				x.t1 = t1
+			}
+			ok = true
+		}
+		if ok {
+			ss = append(ss, x)
+		}
+	}
+	var g bool
+	for _, a := range p {
+		if _, ok := a.Client.Tags[node.IdleTag]; ok {
+			g = true
+			break
+		}
+	}
+	if len(ss) == 0 && !g {
+		return
+	}
+	sort.Slice(ss, func(i, j int) bool {
+		return ss[i].t0 < ss[j].t0
+	})
+	var idle, loaded []float64
+	for _, a := range p {
+		_, i := a.Client.Tags[node.IdleTag]
+		for _, r := range a.RTT {
+			d := r.Delay.Seconds() * 1000
+			if i || (!g && r.T < ss[0].t0) {
+				idle = append(idle, d)
+				continue
+			}
+			for _, x := range ss {
+				if r.T >= x.t0 && r.T <= x.t1 {
+					loaded = append(loaded, d)
+					break
+				}
+			}
+		}
+	}
+	w.IdleN = len(idle)
+	w.LoadedN = len(loaded)
+	if w.IdleN > 0 {
+		sort.Float64s(idle)
+		w.Idle = stat.Quantile(0.5, stat.Empirical, idle, nil)
+	}
+	if w.LoadedN > 0 {
+		sort.Float64s(loaded)
+		w.LoadedP50 = stat.Quantile(0.5, stat.Empirical, loaded, nil)
+		w.LoadedP95 = stat.Quantile(0.95, stat.Empirical, loaded, nil)
+		w.LoadedP99 = stat.Quantile(0.99, stat.Empirical, loaded, nil)
+	}
+	if w.Known() {
+		w.Increase = w.LoadedP95 - w.Idle
+	}
+	return
+}
+
+// Known returns true if both idle and loaded RTT samples were recorded.
+func (w WorkingLatency) Known() bool {
+	return w.IdleN > 0 && w.LoadedN > 0
+}
+
+func (w WorkingLatency) String() string {
+	if !w.Known() {
+		return "n/a"
+	}
+	return fmt.Sprintf("%+.1f ms (idle %.1f, p95 %.1f, p99 %.1f)",
+		w.Increase, w.Idle, w.LoadedP95, w.LoadedP99)
+}